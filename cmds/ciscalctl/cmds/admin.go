@@ -0,0 +1,130 @@
+package cmds
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/tierklinik-dobersberg/apis/pkg/cli"
+)
+
+func GetAdminCommand(root *cli.Root) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "admin",
+		Short: "Administrative commands for the calendar service",
+	}
+
+	cmd.AddCommand(
+		GetMaintenanceCommand(root),
+	)
+
+	return cmd
+}
+
+func GetMaintenanceCommand(root *cli.Root) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "maintenance",
+		Short: "Show and change the maintenance mode",
+		Long: "Show and change the maintenance mode.\n\n" +
+			"While the maintenance mode is enabled all changes to calendars are\n" +
+			"rejected, reading events keeps working.",
+		Run: func(cmd *cobra.Command, args []string) {
+			root.Print(sendMaintenanceRequest(root, nil))
+		},
+	}
+
+	cmd.AddCommand(
+		GetMaintenanceOnCommand(root),
+		GetMaintenanceOffCommand(root),
+		GetMaintenanceStatusCommand(root),
+	)
+
+	return cmd
+}
+
+func GetMaintenanceOnCommand(root *cli.Root) *cobra.Command {
+	var (
+		message string
+		until   string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "on",
+		Short: "Enable the maintenance mode",
+		Long: "Enable the maintenance mode.\n\n" +
+			"Use --until to lift the maintenance mode automatically, either as a\n" +
+			"duration like 2h or as a RFC 3339 time.",
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			body := map[string]any{
+				"enabled": true,
+				"message": message,
+			}
+
+			if until != "" {
+				if d, err := time.ParseDuration(until); err == nil {
+					body["until"] = time.Now().Add(d).Format(time.RFC3339)
+				} else if t, err := time.Parse(time.RFC3339, until); err == nil {
+					body["until"] = t.Format(time.RFC3339)
+				} else {
+					logrus.Fatalf("invalid value for --until, expected a duration or a RFC 3339 time")
+				}
+			}
+
+			root.Print(sendMaintenanceRequest(root, body))
+		},
+	}
+
+	f := cmd.Flags()
+	{
+		f.StringVar(&message, "message", "", "The message returned for rejected changes")
+		f.StringVar(&until, "until", "", "Lift the maintenance mode after the duration or at the RFC 3339 time")
+	}
+
+	return cmd
+}
+
+func GetMaintenanceOffCommand(root *cli.Root) *cobra.Command {
+	return &cobra.Command{
+		Use:   "off",
+		Short: "Disable the maintenance mode",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			root.Print(sendMaintenanceRequest(root, map[string]any{
+				"enabled": false,
+			}))
+		},
+	}
+}
+
+func GetMaintenanceStatusCommand(root *cli.Root) *cobra.Command {
+	return &cobra.Command{
+		Use:   "status",
+		Short: "Show the maintenance mode",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			root.Print(sendMaintenanceRequest(root, nil))
+		},
+	}
+}
+
+// sendMaintenanceRequest changes the maintenance mode to body or loads it
+// if body is nil.
+func sendMaintenanceRequest(root *cli.Root, body map[string]any) map[string]any {
+	var (
+		state map[string]any
+		err   error
+	)
+
+	if body != nil {
+		err = doJSON(root.Context(), root, http.MethodPost, "/admin/maintenance", body, &state)
+	} else {
+		err = doJSON(root.Context(), root, http.MethodGet, "/admin/maintenance", nil, &state)
+	}
+	if err != nil {
+		logrus.Fatalf("maintenance request failed: %s", err)
+	}
+
+	return state
+}
@@ -0,0 +1,135 @@
+package cmds
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/tierklinik-dobersberg/apis/pkg/cli"
+)
+
+// backupFormat returns the backup format of --format or derives it from
+// the file extension.
+func backupFormat(format, file string) string {
+	if format != "" {
+		return format
+	}
+
+	if strings.EqualFold(filepath.Ext(file), ".ics") {
+		return "ics"
+	}
+
+	return "ndjson"
+}
+
+func GetCalendarExportCommand(root *cli.Root) *cobra.Command {
+	var (
+		from   string
+		to     string
+		format string
+		output string
+	)
+
+	cmd := &cobra.Command{
+		Use:               "export [calendar]",
+		Short:             "Export all events of a calendar as a backup",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeArgs(completeCalendars(root)),
+		Run: func(cmd *cobra.Command, args []string) {
+			query := url.Values{}
+			query.Set("calendar", mustResolveCalendarId(root, args[0]))
+			query.Set("from", from)
+			query.Set("to", to)
+			query.Set("format", backupFormat(format, output))
+
+			var w io.Writer = os.Stdout
+			if output != "" {
+				f, err := os.Create(output)
+				if err != nil {
+					logrus.Fatalf("failed to create %s: %s", output, err)
+				}
+				defer f.Close()
+
+				w = f
+			}
+
+			if err := doJSON(root.Context(), root, http.MethodGet, "/calendars/export?"+query.Encode(), nil, w); err != nil {
+				if output != "" {
+					_ = os.Remove(output)
+				}
+
+				logrus.Fatalf("failed to export calendar: %s", err)
+			}
+		},
+	}
+
+	f := cmd.Flags()
+	{
+		f.StringVar(&from, "from", "", "Export events starting at this date (YYYY-MM-DD)")
+		f.StringVar(&to, "to", "", "Export events before this date (YYYY-MM-DD, exclusive)")
+		f.StringVar(&format, "format", "", "The export format, either ndjson or ics. Defaults to the extension of --output or ndjson")
+		f.StringVarP(&output, "output", "o", "", "The file to write the export to. Defaults to stdout")
+	}
+
+	_ = cmd.MarkFlagRequired("from")
+	_ = cmd.MarkFlagRequired("to")
+
+	return cmd
+}
+
+func GetCalendarImportCommand(root *cli.Root) *cobra.Command {
+	var format string
+
+	cmd := &cobra.Command{
+		Use:   "import [calendar] [file]",
+		Short: "Import events from a calendar backup",
+		Long:  "Import events from a calendar backup. Events that have already been imported into the calendar are skipped.",
+		Args:  cobra.ExactArgs(2),
+		ValidArgsFunction: completeArgs(completeCalendars(root), func(*cobra.Command, []string, string) ([]string, cobra.ShellCompDirective) {
+			return nil, cobra.ShellCompDirectiveDefault
+		}),
+		Run: func(cmd *cobra.Command, args []string) {
+			f, err := os.Open(args[1])
+			if err != nil {
+				logrus.Fatalf("failed to open backup: %s", err)
+			}
+			defer f.Close()
+
+			query := url.Values{}
+			query.Set("calendar", mustResolveCalendarId(root, args[0]))
+			query.Set("format", backupFormat(format, args[1]))
+
+			var result struct {
+				Created int `json:"created"`
+				Skipped int `json:"skipped"`
+				Failed  []struct {
+					EventID string `json:"eventId"`
+					Error   string `json:"error"`
+				} `json:"failed"`
+			}
+			if err := doJSON(root.Context(), root, http.MethodPost, "/calendars/import?"+query.Encode(), f, &result); err != nil {
+				logrus.Fatalf("failed to import calendar: %s", err)
+			}
+
+			for _, failure := range result.Failed {
+				fmt.Printf("failed to import %s: %s\n", failure.EventID, failure.Error)
+			}
+
+			fmt.Printf("created %d, skipped %d, failed %d events\n", result.Created, result.Skipped, len(result.Failed))
+
+			if len(result.Failed) > 0 {
+				os.Exit(1)
+			}
+		},
+	}
+
+	cmd.Flags().StringVar(&format, "format", "", "The backup format, either ndjson or ics. Defaults to the file extension")
+
+	return cmd
+}
@@ -0,0 +1,39 @@
+package cmds
+
+import (
+	"net/http"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/tierklinik-dobersberg/apis/pkg/cli"
+)
+
+func GetBookingSlotsCommand(root *cli.Root) *cobra.Command {
+	var export bool
+
+	cmd := &cobra.Command{
+		Use:   "booking-slots",
+		Short: "Show the free slots exported for the online booking portal",
+		Long: "Show the free slots exported for the online booking portal.\n\n" +
+			"Use --export to export and push all days now instead of showing the\n" +
+			"latest export.",
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			method := http.MethodGet
+			if export {
+				method = http.MethodPost
+			}
+
+			var doc map[string]any
+			if err := doJSON(root.Context(), root, method, "/booking/slots", nil, &doc); err != nil {
+				logrus.Fatalf("failed to load booking slots: %s", err)
+			}
+
+			root.Print(doc)
+		},
+	}
+
+	cmd.Flags().BoolVar(&export, "export", false, "Export and push all days now")
+
+	return cmd
+}
@@ -0,0 +1,144 @@
+package cmds
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/tierklinik-dobersberg/apis/pkg/cli"
+)
+
+type bulkDeleteEvent struct {
+	ID        string     `json:"id"`
+	Summary   string     `json:"summary"`
+	Start     time.Time  `json:"start"`
+	End       *time.Time `json:"end"`
+	CreatedBy string     `json:"createdBy"`
+}
+
+type bulkDeleteResponse struct {
+	ConfirmToken string            `json:"confirmToken"`
+	Events       []bulkDeleteEvent `json:"events"`
+	Results      []struct {
+		EventID string `json:"eventId"`
+		Deleted bool   `json:"deleted"`
+		Error   string `json:"error"`
+	} `json:"results"`
+}
+
+func GetBulkDeleteEventsCommand(root *cli.Root) *cobra.Command {
+	var (
+		calendar     string
+		from         string
+		to           string
+		summaryRegex string
+		tag          string
+		createdBy    string
+		yes          bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "bulk-delete",
+		Short: "Delete all events of a calendar that match a filter",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			fromTime, err := time.Parse(time.RFC3339, from)
+			if err != nil {
+				logrus.Fatalf("invalid value for --from, expected format %q: %s", time.RFC3339, err)
+			}
+
+			toTime, err := time.Parse(time.RFC3339, to)
+			if err != nil {
+				logrus.Fatalf("invalid value for --to, expected format %q: %s", time.RFC3339, err)
+			}
+
+			body := map[string]any{
+				"calendarId":   mustResolveCalendarId(root, calendar),
+				"from":         fromTime,
+				"to":           toTime,
+				"summaryRegex": summaryRegex,
+				"tag":          tag,
+				"createdBy":    createdBy,
+			}
+
+			res := sendBulkDelete(root, body)
+			if len(res.Events) == 0 {
+				fmt.Println("no matching events")
+				return
+			}
+
+			for _, e := range res.Events {
+				end := ""
+				if e.End != nil {
+					end = e.End.Local().Format("15:04")
+				}
+
+				fmt.Printf("%s %s-%s %q %s\n", e.ID, e.Start.Local().Format("2006-01-02 15:04"), end, e.Summary, e.CreatedBy)
+			}
+
+			if !yes {
+				fmt.Printf("\nDelete %d events? [y/N] ", len(res.Events))
+
+				answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+				if a := strings.ToLower(strings.TrimSpace(answer)); a != "y" && a != "yes" {
+					fmt.Println("aborted")
+					return
+				}
+			}
+
+			// the server only deletes the events if they did not change
+			// since they have been listed.
+			body["confirmToken"] = res.ConfirmToken
+
+			res = sendBulkDelete(root, body)
+
+			failed := 0
+			for _, r := range res.Results {
+				if !r.Deleted {
+					failed++
+					fmt.Printf("failed to delete %s: %s\n", r.EventID, r.Error)
+				}
+			}
+
+			fmt.Printf("deleted %d of %d events\n", len(res.Results)-failed, len(res.Results))
+
+			if failed > 0 {
+				os.Exit(1)
+			}
+		},
+	}
+
+	f := cmd.Flags()
+	{
+		f.StringVar(&calendar, "calendar", "", "The calendar to delete events from")
+		f.StringVar(&from, "from", "", "The start of the time range in format RFC3339")
+		f.StringVar(&to, "to", "", "The end of the time range in format RFC3339")
+		f.StringVar(&summaryRegex, "summary-regex", "", "Only delete events whose summary matches the regular expression")
+		f.StringVar(&tag, "tag", "", "Only delete events with the tag")
+		f.StringVar(&createdBy, "created-by", "", "Only delete events created by the user")
+		f.BoolVarP(&yes, "yes", "y", false, "Delete the matching events without asking for confirmation")
+	}
+
+	_ = cmd.MarkFlagRequired("calendar")
+	_ = cmd.MarkFlagRequired("from")
+	_ = cmd.MarkFlagRequired("to")
+	_ = cmd.RegisterFlagCompletionFunc("calendar", completeCalendars(root))
+
+	return cmd
+}
+
+// sendBulkDelete lists the matching events or, with a confirm token,
+// deletes them.
+func sendBulkDelete(root *cli.Root, body map[string]any) bulkDeleteResponse {
+	var result bulkDeleteResponse
+	if err := doJSON(root.Context(), root, http.MethodPost, "/events/bulk-delete", body, &result); err != nil {
+		logrus.Fatalf("failed to delete events: %s", err)
+	}
+
+	return result
+}
@@ -0,0 +1,145 @@
+package cmds
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/tierklinik-dobersberg/apis/pkg/cli"
+)
+
+type calendarAdminResponse struct {
+	CalendarID  string `json:"calendarId"`
+	Name        string `json:"name"`
+	Color       string `json:"color"`
+	AssignedTo  string `json:"assignedTo"`
+	ShareError  string `json:"shareError"`
+	AssignError string `json:"assignError"`
+}
+
+func GetCreateCalendarCommand(root *cli.Root) *cobra.Command {
+	var (
+		name       string
+		assignUser string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "create",
+		Short: "Create a new calendar and optionally assign it to a user",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			body := map[string]any{
+				"name": name,
+			}
+
+			if assignUser != "" {
+				body["assignUser"] = root.MustResolveUserIds([]string{assignUser})[0]
+			}
+
+			var res calendarAdminResponse
+			if err := doJSON(root.Context(), root, http.MethodPost, "/calendars/create", body, &res); err != nil {
+				logrus.Fatalf("failed to create calendar: %s", err)
+			}
+
+			fmt.Printf("created calendar %q with id %s\n", res.Name, res.CalendarID)
+
+			failed := false
+
+			if res.ShareError != "" {
+				failed = true
+				fmt.Printf("failed to share the calendar: %s\n", res.ShareError)
+			}
+
+			if res.AssignError != "" {
+				failed = true
+				fmt.Printf("failed to assign the calendar: %s\n", res.AssignError)
+				fmt.Printf("retry using: calendar assign %s %s\n", res.CalendarID, assignUser)
+			}
+
+			if res.AssignedTo != "" {
+				fmt.Printf("assigned to user %s\n", res.AssignedTo)
+			}
+
+			if failed {
+				os.Exit(1)
+			}
+		},
+	}
+
+	f := cmd.Flags()
+	{
+		f.StringVar(&name, "name", "", "The display name of the new calendar")
+		f.StringVar(&assignUser, "assign-user", "", "The user the calendar is assigned to")
+	}
+
+	_ = cmd.MarkFlagRequired("name")
+	_ = cmd.RegisterFlagCompletionFunc("assign-user", completeUsers(root))
+
+	return cmd
+}
+
+func GetAssignCalendarCommand(root *cli.Root) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:               "assign [calendar] [user]",
+		Short:             "Assign a calendar to a user",
+		Args:              cobra.ExactArgs(2),
+		ValidArgsFunction: completeArgs(completeCalendars(root), completeUsers(root)),
+		Run: func(cmd *cobra.Command, args []string) {
+			body := map[string]any{
+				"calendarId": mustResolveCalendarId(root, args[0]),
+				"userId":     root.MustResolveUserIds([]string{args[1]})[0],
+			}
+
+			var res calendarAdminResponse
+			if err := doJSON(root.Context(), root, http.MethodPost, "/calendars/assign", body, &res); err != nil {
+				logrus.Fatalf("failed to assign calendar: %s", err)
+			}
+
+			fmt.Printf("assigned calendar %q (%s) to user %s\n", res.Name, res.CalendarID, res.AssignedTo)
+		},
+	}
+
+	return cmd
+}
+
+func GetUpdateCalendarCommand(root *cli.Root) *cobra.Command {
+	var (
+		name  string
+		color string
+	)
+
+	cmd := &cobra.Command{
+		Use:               "update [calendar]",
+		Short:             "Change the name or color of a calendar",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeArgs(completeCalendars(root)),
+		Run: func(cmd *cobra.Command, args []string) {
+			if name == "" && color == "" {
+				logrus.Fatalf("at least one of --name or --color is required")
+			}
+
+			body := map[string]any{
+				"calendarId": mustResolveCalendarId(root, args[0]),
+				"name":       name,
+				"color":      color,
+			}
+
+			var res calendarAdminResponse
+			if err := doJSON(root.Context(), root, http.MethodPost, "/calendars/update", body, &res); err != nil {
+				logrus.Fatalf("failed to update calendar: %s", err)
+			}
+
+			fmt.Printf("updated calendar %s: %q %s\n", res.CalendarID, res.Name, res.Color)
+		},
+	}
+
+	f := cmd.Flags()
+	{
+		f.StringVar(&name, "name", "", "The new display name of the calendar")
+		f.StringVar(&color, "color", "", "The new color of the calendar in hex format, like #1e88e5")
+	}
+
+	return cmd
+}
@@ -11,20 +11,50 @@ import (
 )
 
 func GetCalendarCommand(root *cli.Root) *cobra.Command {
+	var (
+		writableOnly    bool
+		includeDisabled bool
+	)
+
 	cmd := &cobra.Command{
 		Use:     "calendar",
 		Aliases: []string{"calendars", "cal"},
 		Run: func(cmd *cobra.Command, args []string) {
 			cli := root.Calendar()
 
-			calendars, err := cli.ListCalendars(context.Background(), connect.NewRequest(&calendarv1.ListCalendarsRequest{}))
+			req := connect.NewRequest(&calendarv1.ListCalendarsRequest{})
+			if writableOnly {
+				req.Header().Set("X-Writable-Only", "true")
+			}
+
+			if includeDisabled {
+				req.Header().Set("X-Include-Disabled-Users", "true")
+			}
+
+			calendars, err := cli.ListCalendars(context.Background(), req)
 			if err != nil {
 				logrus.Fatalf("failed to get calendar list: %s", err)
 			}
 
+			for _, id := range calendars.Header().Values("X-Disabled-Calendar") {
+				logrus.Infof("calendar of a disabled user: %s", id)
+			}
+
 			root.Print(calendars.Msg)
 		},
 	}
 
+	cmd.Flags().BoolVar(&writableOnly, "writable", false, "Only list calendars that events can be created in")
+	cmd.Flags().BoolVar(&includeDisabled, "include-disabled", false, "Include the calendars of disabled users")
+
+	cmd.AddCommand(
+		GetConflictsCommand(root),
+		GetCalendarExportCommand(root),
+		GetCalendarImportCommand(root),
+		GetCreateCalendarCommand(root),
+		GetAssignCalendarCommand(root),
+		GetUpdateCalendarCommand(root),
+	)
+
 	return cmd
 }
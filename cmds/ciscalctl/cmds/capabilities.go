@@ -0,0 +1,27 @@
+package cmds
+
+import (
+	"net/http"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/tierklinik-dobersberg/apis/pkg/cli"
+)
+
+func GetCapabilitiesCommand(root *cli.Root) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "capabilities",
+		Short: "Show the optional features supported by the calendar service",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			var caps map[string]any
+			if err := doJSON(root.Context(), root, http.MethodGet, "/capabilities", nil, &caps); err != nil {
+				logrus.Fatalf("failed to load capabilities: %s", err)
+			}
+
+			root.Print(caps)
+		},
+	}
+
+	return cmd
+}
@@ -0,0 +1,259 @@
+package cmds
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	calendarv1 "github.com/tierklinik-dobersberg/apis/gen/go/tkd/calendar/v1"
+	idmv1 "github.com/tierklinik-dobersberg/apis/gen/go/tkd/idm/v1"
+	"github.com/tierklinik-dobersberg/apis/pkg/cli"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+)
+
+const (
+	// completionTimeout limits how long the shell waits for completions.
+	completionTimeout = 2 * time.Second
+
+	// calendarCacheTTL is how long the calendar list is cached on disk.
+	// Each completion runs in a new process so an in-memory cache would not
+	// help.
+	calendarCacheTTL = 5 * time.Minute
+)
+
+// completionFunc is the signature of cobra's dynamic completion functions.
+type completionFunc func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective)
+
+// cachedCalendar is the subset of a calendar that is stored in the cache.
+type cachedCalendar struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type calendarCache struct {
+	Updated   time.Time        `json:"updated"`
+	Calendars []cachedCalendar `json:"calendars"`
+}
+
+func calendarCachePath(root *cli.Root) string {
+	name := root.ActiveConfig()
+	if name == "" {
+		name = "default"
+	}
+
+	return filepath.Join(root.ConfigurationDirectory, name+"-calendars.json")
+}
+
+// listCalendars returns the id and name of all calendars. If useCache is set
+// a cached list is returned if it is not older than calendarCacheTTL.
+func listCalendars(ctx context.Context, root *cli.Root, useCache bool) ([]cachedCalendar, error) {
+	path := calendarCachePath(root)
+
+	if useCache {
+		var cache calendarCache
+
+		if blob, err := os.ReadFile(path); err == nil && json.Unmarshal(blob, &cache) == nil {
+			if time.Since(cache.Updated) < calendarCacheTTL {
+				return cache.Calendars, nil
+			}
+		}
+	}
+
+	res, err := root.Calendar().ListCalendars(ctx, connect.NewRequest(&calendarv1.ListCalendarsRequest{}))
+	if err != nil {
+		return nil, err
+	}
+
+	cache := calendarCache{
+		Updated:   time.Now(),
+		Calendars: make([]cachedCalendar, 0, len(res.Msg.Calendars)),
+	}
+
+	for _, cal := range res.Msg.Calendars {
+		cache.Calendars = append(cache.Calendars, cachedCalendar{ID: cal.Id, Name: cal.Name})
+	}
+
+	if blob, err := json.Marshal(cache); err == nil {
+		if err := os.WriteFile(path, blob, 0o600); err != nil {
+			logrus.Debugf("failed to write calendar cache: %s", err)
+		}
+	}
+
+	return cache.Calendars, nil
+}
+
+// errCalendarNotFound is returned if no calendar matches an id or name.
+type errCalendarNotFound string
+
+func (e errCalendarNotFound) Error() string {
+	return fmt.Sprintf("calendar id or name %q not found", string(e))
+}
+
+// matchCalendars returns the ids of the calendars with the given ids or
+// display names. Names are compared case-insensitive and must be
+// unambiguous.
+func matchCalendars(calendars []cachedCalendar, idsOrNames []string) ([]string, error) {
+	result := make([]string, 0, len(idsOrNames))
+
+L:
+	for _, idOrName := range idsOrNames {
+		var candidates []cachedCalendar
+
+		for _, cal := range calendars {
+			if cal.ID == idOrName {
+				result = append(result, cal.ID)
+				continue L
+			}
+
+			if strings.EqualFold(cal.Name, idOrName) {
+				candidates = append(candidates, cal)
+			}
+		}
+
+		switch len(candidates) {
+		case 0:
+			return nil, errCalendarNotFound(idOrName)
+		case 1:
+			result = append(result, candidates[0].ID)
+			continue L
+		}
+
+		names := make([]string, len(candidates))
+		for idx, cal := range candidates {
+			names[idx] = fmt.Sprintf("%s (%s)", cal.Name, cal.ID)
+		}
+
+		return nil, fmt.Errorf("calendar name %q is ambiguous, use one of: %s", idOrName, strings.Join(names, ", "))
+	}
+
+	return result, nil
+}
+
+// resolveCalendarIds resolves a list of calendar ids or display names to
+// calendar ids. The cached calendar list is tried first and reloaded if a
+// value is not found.
+func resolveCalendarIds(ctx context.Context, root *cli.Root, idsOrNames []string) ([]string, error) {
+	if len(idsOrNames) == 0 {
+		return nil, nil
+	}
+
+	calendars, err := listCalendars(ctx, root, true)
+	if err != nil {
+		return nil, err
+	}
+
+	ids, err := matchCalendars(calendars, idsOrNames)
+
+	var notFound errCalendarNotFound
+	if errors.As(err, &notFound) {
+		calendars, err = listCalendars(ctx, root, false)
+		if err != nil {
+			return nil, err
+		}
+
+		ids, err = matchCalendars(calendars, idsOrNames)
+	}
+
+	return ids, err
+}
+
+// mustResolveCalendarIds is like resolveCalendarIds but exits on error.
+func mustResolveCalendarIds(root *cli.Root, idsOrNames []string) []string {
+	ids, err := resolveCalendarIds(root.Context(), root, idsOrNames)
+	if err != nil {
+		logrus.Fatalf("failed to resolve calendars: %s", err)
+	}
+
+	return ids
+}
+
+// mustResolveCalendarId is like mustResolveCalendarIds for a single value.
+func mustResolveCalendarId(root *cli.Root, idOrName string) string {
+	return mustResolveCalendarIds(root, []string{idOrName})[0]
+}
+
+// completeCalendars completes calendar display names. Calendar ids are
+// completed as well if toComplete is a prefix of an id.
+func completeCalendars(root *cli.Root) completionFunc {
+	return func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		ctx, cancel := context.WithTimeout(cmd.Context(), completionTimeout)
+		defer cancel()
+
+		calendars, err := listCalendars(ctx, root, true)
+		if err != nil {
+			cobra.CompErrorln(err.Error())
+
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+
+		prefix := strings.ToLower(toComplete)
+
+		var result []string
+		for _, cal := range calendars {
+			switch {
+			case strings.HasPrefix(strings.ToLower(cal.Name), prefix):
+				result = append(result, cal.Name+"\t"+cal.ID)
+			case strings.HasPrefix(cal.ID, toComplete):
+				result = append(result, cal.ID+"\t"+cal.Name)
+			}
+		}
+
+		return result, cobra.ShellCompDirectiveNoFileComp
+	}
+}
+
+// completeUsers completes usernames. Those are accepted by
+// root.MustResolveUserIds just like user ids.
+func completeUsers(root *cli.Root) completionFunc {
+	return func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		ctx, cancel := context.WithTimeout(cmd.Context(), completionTimeout)
+		defer cancel()
+
+		res, err := root.Users().ListUsers(ctx, connect.NewRequest(&idmv1.ListUsersRequest{
+			FieldMask: &fieldmaskpb.FieldMask{
+				Paths: []string{"users.user.id", "users.user.username", "users.user.display_name"},
+			},
+		}))
+		if err != nil {
+			cobra.CompErrorln(err.Error())
+
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+
+		prefix := strings.ToLower(toComplete)
+
+		var result []string
+		for _, p := range res.Msg.Users {
+			u := p.GetUser()
+			if u == nil {
+				continue
+			}
+
+			if strings.HasPrefix(strings.ToLower(u.Username), prefix) {
+				result = append(result, u.Username+"\t"+u.DisplayName)
+			}
+		}
+
+		return result, cobra.ShellCompDirectiveNoFileComp
+	}
+}
+
+// completeArgs returns a completion function that completes the n-th
+// positional argument using funcs[n].
+func completeArgs(funcs ...completionFunc) completionFunc {
+	return func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		if len(args) >= len(funcs) || funcs[len(args)] == nil {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+
+		return funcs[len(args)](cmd, args, toComplete)
+	}
+}
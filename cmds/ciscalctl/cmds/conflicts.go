@@ -0,0 +1,68 @@
+package cmds
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/tierklinik-dobersberg/apis/pkg/cli"
+)
+
+type conflictEvent struct {
+	ID      string    `json:"id"`
+	Summary string    `json:"summary"`
+	Start   time.Time `json:"start"`
+	End     time.Time `json:"end"`
+}
+
+func GetConflictsCommand(root *cli.Root) *cobra.Command {
+	var (
+		calendarIds []string
+		date        string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "conflicts",
+		Short: "Print overlapping events within calendars",
+		Run: func(cmd *cobra.Command, args []string) {
+			query := url.Values{}
+			if date != "" {
+				query.Set("date", date)
+			}
+
+			for _, id := range mustResolveCalendarIds(root, calendarIds) {
+				query.Add("calendar", id)
+			}
+
+			var conflicts []struct {
+				CalendarID string        `json:"calendarId"`
+				First      conflictEvent `json:"first"`
+				Second     conflictEvent `json:"second"`
+			}
+			if err := doJSON(root.Context(), root, http.MethodGet, "/conflicts?"+query.Encode(), nil, &conflicts); err != nil {
+				logrus.Fatalf("failed to load conflicts: %s", err)
+			}
+
+			for _, c := range conflicts {
+				fmt.Printf("%s: %s %s-%s %q overlaps with %s %s-%s %q\n",
+					c.CalendarID,
+					c.First.ID, c.First.Start.Local().Format("15:04"), c.First.End.Local().Format("15:04"), c.First.Summary,
+					c.Second.ID, c.Second.Start.Local().Format("15:04"), c.Second.End.Local().Format("15:04"), c.Second.Summary,
+				)
+			}
+		},
+	}
+
+	f := cmd.Flags()
+	{
+		f.StringSliceVar(&calendarIds, "calendar", nil, "A list of calendar IDs to check. Defaults to all calendars assigned to users")
+		f.StringVar(&date, "date", "", "The date to check in format YYYY-MM-DD. Defaults to today")
+	}
+
+	_ = cmd.RegisterFlagCompletionFunc("calendar", completeCalendars(root))
+
+	return cmd
+}
@@ -0,0 +1,239 @@
+package cmds
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/tierklinik-dobersberg/apis/pkg/cli"
+)
+
+func GetDebugCommand(root *cli.Root) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "debug",
+		Short: "Commands for debugging the calendar service",
+	}
+
+	cmd.AddCommand(
+		GetDebugCacheCommand(root),
+		GetDebugQuotaCommand(root),
+		GetDebugRedactionCommand(root),
+	)
+
+	return cmd
+}
+
+func GetDebugCacheCommand(root *cli.Root) *cobra.Command {
+	var (
+		events bool
+		from   string
+		to     string
+	)
+
+	cmd := &cobra.Command{
+		Use:               "cache [calendar]",
+		Short:             "Dump the state of the service caches",
+		Args:              cobra.MaximumNArgs(1),
+		ValidArgsFunction: completeArgs(completeCalendars(root)),
+		Run: func(cmd *cobra.Command, args []string) {
+			query := url.Values{}
+			if len(args) > 0 {
+				query.Set("calendar", mustResolveCalendarId(root, args[0]))
+			}
+
+			if events {
+				query.Set("events", "true")
+
+				if from != "" {
+					query.Set("from", from)
+				}
+
+				if to != "" {
+					query.Set("to", to)
+				}
+			}
+
+			var dump struct {
+				Calendars   cacheAge `json:"calendars"`
+				Profiles    cacheAge `json:"profiles"`
+				EventCaches []struct {
+					CalendarID     string     `json:"calendarId"`
+					CalendarName   string     `json:"calendarName"`
+					Backend        string     `json:"backend"`
+					Ready          bool       `json:"ready"`
+					MinTime        time.Time  `json:"minTime"`
+					LastSync       time.Time  `json:"lastSync"`
+					HasSyncToken   bool       `json:"hasSyncToken"`
+					Version        int64      `json:"version"`
+					EventCount     int        `json:"eventCount"`
+					FirstEvent     *time.Time `json:"firstEvent"`
+					LastEvent      *time.Time `json:"lastEvent"`
+					MemoryEstimate int64      `json:"memoryEstimate"`
+					Events         []struct {
+						ID      string     `json:"id"`
+						Summary string     `json:"summary"`
+						Start   time.Time  `json:"start"`
+						End     *time.Time `json:"end"`
+						Status  string     `json:"status"`
+					} `json:"events"`
+				} `json:"eventCaches"`
+			}
+			if err := doJSON(root.Context(), root, http.MethodGet, "/debug/cache?"+query.Encode(), nil, &dump); err != nil {
+				logrus.Fatalf("failed to load cache state: %s", err)
+			}
+
+			fmt.Printf("calendars: %s\n", dump.Calendars)
+			fmt.Printf("profiles:  %s\n", dump.Profiles)
+
+			for _, c := range dump.EventCaches {
+				fmt.Println()
+				fmt.Printf("%s (%s) backend=%s ready=%t sync-token=%t version=%d\n", c.CalendarID, c.CalendarName, c.Backend, c.Ready, c.HasSyncToken, c.Version)
+				fmt.Printf("  min-time:  %s\n", formatDebugTime(c.MinTime))
+				fmt.Printf("  last-sync: %s\n", formatDebugTime(c.LastSync))
+				fmt.Printf("  events:    %d (~%d KiB)\n", c.EventCount, c.MemoryEstimate/1024)
+
+				if c.FirstEvent != nil && c.LastEvent != nil {
+					fmt.Printf("  range:     %s - %s\n", formatDebugTime(*c.FirstEvent), formatDebugTime(*c.LastEvent))
+				}
+
+				for _, e := range c.Events {
+					end := ""
+					if e.End != nil {
+						end = e.End.Local().Format("15:04")
+					}
+
+					fmt.Printf("    %s %s-%s %q %s\n", e.ID, e.Start.Local().Format("2006-01-02 15:04"), end, e.Summary, e.Status)
+				}
+			}
+		},
+	}
+
+	f := cmd.Flags()
+	{
+		f.BoolVar(&events, "events", false, "Include the cached events within --from and --to")
+		f.StringVar(&from, "from", "", "The first day of cached events to include in format YYYY-MM-DD. Defaults to today")
+		f.StringVar(&to, "to", "", "The day after the last day of cached events to include in format YYYY-MM-DD. Defaults to the day after --from")
+	}
+
+	return cmd
+}
+
+func GetDebugQuotaCommand(root *cli.Root) *cobra.Command {
+	var hours int
+
+	cmd := &cobra.Command{
+		Use:   "quota",
+		Short: "Show the google calendar API usage per hour, method and caller",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			query := url.Values{}
+			if hours > 0 {
+				query.Set("hours", strconv.Itoa(hours))
+			}
+
+			var usage []struct {
+				Backend string    `json:"backend"`
+				Hour    time.Time `json:"hour"`
+				Total   int       `json:"total"`
+				Calls   []struct {
+					Method string `json:"method"`
+					Caller string `json:"caller"`
+					Count  int    `json:"count"`
+					Errors int    `json:"errors"`
+				} `json:"calls"`
+			}
+			if err := doJSON(root.Context(), root, http.MethodGet, "/debug/quota?"+query.Encode(), nil, &usage); err != nil {
+				logrus.Fatalf("failed to load quota usage: %s", err)
+			}
+
+			for _, u := range usage {
+				fmt.Printf("%s %s: %d calls\n", u.Backend, u.Hour.Local().Format("2006-01-02 15:04"), u.Total)
+
+				for _, c := range u.Calls {
+					fmt.Printf("  %-20s %-15s %6d (%d errors)\n", c.Method, c.Caller, c.Count, c.Errors)
+				}
+			}
+		},
+	}
+
+	cmd.Flags().IntVar(&hours, "hours", 0, "Only show the usage of the last N hours. Defaults to 24 hours")
+
+	return cmd
+}
+
+func GetDebugRedactionCommand(root *cli.Root) *cobra.Command {
+	var (
+		pause  time.Duration
+		resume bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "redaction",
+		Short: "Show or temporarily pause the redaction of event content in the service logs",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			method := http.MethodGet
+			query := url.Values{}
+
+			switch {
+			case resume:
+				method = http.MethodPost
+				query.Set("duration", "0s")
+			case pause > 0:
+				method = http.MethodPost
+				query.Set("duration", pause.String())
+			}
+
+			var state struct {
+				RedactPII   bool       `json:"redactPII"`
+				PausedUntil *time.Time `json:"pausedUntil"`
+			}
+			if err := doJSON(root.Context(), root, method, "/debug/redaction?"+query.Encode(), nil, &state); err != nil {
+				logrus.Fatalf("failed to update redaction: %s", err)
+			}
+
+			switch {
+			case state.RedactPII:
+				fmt.Println("event content is redacted")
+			case state.PausedUntil != nil:
+				fmt.Printf("redaction is paused until %s\n", formatDebugTime(*state.PausedUntil))
+			default:
+				fmt.Println("redaction is disabled by configuration")
+			}
+		},
+	}
+
+	f := cmd.Flags()
+	{
+		f.DurationVar(&pause, "pause", 0, "Pause redaction for the given duration, at most one hour")
+		f.BoolVar(&resume, "resume", false, "Resume redaction immediately")
+	}
+
+	return cmd
+}
+
+type cacheAge struct {
+	LastFetch time.Time `json:"lastFetch"`
+	Age       string    `json:"age"`
+	Count     int       `json:"count"`
+}
+
+func (c cacheAge) String() string {
+	if c.LastFetch.IsZero() {
+		return "never loaded"
+	}
+
+	return fmt.Sprintf("%d entries, loaded %s (%s ago)", c.Count, formatDebugTime(c.LastFetch), c.Age)
+}
+
+func formatDebugTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+
+	return t.Local().Format(time.RFC3339)
+}
@@ -1,8 +1,12 @@
 package cmds
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
+	"os"
+	"strconv"
 	"time"
 
 	"github.com/bufbuild/connect-go"
@@ -18,11 +22,24 @@ func GetMoveEventCommand(root *cli.Root) *cobra.Command {
 	var (
 		sourceUser bool
 		targetUser bool
+		startTime  string
+		endTime    string
 	)
 
 	cmd := &cobra.Command{
 		Use:  "move [originCalendarID] [eventID] [targetCalendarID]",
 		Args: cobra.ExactArgs(3),
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			source, target := completeCalendars(root), completeCalendars(root)
+			if sourceUser {
+				source = completeUsers(root)
+			}
+			if targetUser {
+				target = completeUsers(root)
+			}
+
+			return completeArgs(source, nil, target)(cmd, args, toComplete)
+		},
 		Run: func(cmd *cobra.Command, args []string) {
 			cli := root.Calendar()
 
@@ -32,25 +49,44 @@ func GetMoveEventCommand(root *cli.Root) *cobra.Command {
 
 			if sourceUser {
 				req.Source = &calendarv1.MoveEventRequest_SourceUserId{
-					SourceUserId: args[0],
+					SourceUserId: root.MustResolveUserToId(args[0]),
 				}
 			} else {
 				req.Source = &calendarv1.MoveEventRequest_SourceCalendarId{
-					SourceCalendarId: args[0],
+					SourceCalendarId: mustResolveCalendarId(root, args[0]),
 				}
 			}
 
 			if targetUser {
 				req.Target = &calendarv1.MoveEventRequest_TargetUserId{
-					TargetUserId: args[2],
+					TargetUserId: root.MustResolveUserToId(args[2]),
 				}
 			} else {
 				req.Target = &calendarv1.MoveEventRequest_TargetCalendarId{
-					TargetCalendarId: args[2],
+					TargetCalendarId: mustResolveCalendarId(root, args[2]),
+				}
+			}
+
+			moveReq := connect.NewRequest(req)
+
+			// the time is not yet part of the MoveEventRequest
+			if startTime != "" {
+				if _, err := time.Parse(time.RFC3339, startTime); err != nil {
+					logrus.Fatalf("invalid value for --from, expected format %q: %s", time.RFC3339, err)
+				}
+
+				moveReq.Header().Set("X-Move-Start", startTime)
+			}
+
+			if endTime != "" {
+				if _, err := time.Parse(time.RFC3339, endTime); err != nil {
+					logrus.Fatalf("invalid value for --to, expected format %q: %s", time.RFC3339, err)
 				}
+
+				moveReq.Header().Set("X-Move-End", endTime)
 			}
 
-			res, err := cli.MoveEvent(root.Context(), connect.NewRequest(req))
+			res, err := cli.MoveEvent(root.Context(), moveReq)
 			if err != nil {
 				logrus.Fatalf("failed to move event: %s", err)
 			}
@@ -63,8 +99,96 @@ func GetMoveEventCommand(root *cli.Root) *cobra.Command {
 	{
 		f.BoolVar(&sourceUser, "source-user", false, "Interpret [originCalendarID] as a user id")
 		f.BoolVar(&targetUser, "target-user", false, "Interpret [targetCalendarID] as a user id")
+		f.StringVar(&startTime, "from", "", "The new start time of the event. The event keeps its duration unless --to is set")
+		f.StringVar(&endTime, "to", "", "The new end time of the event")
+	}
+
+	return cmd
+}
+
+func GetCreateEventCommand(root *cli.Root) *cobra.Command {
+	var (
+		startTime  string
+		endTime    string
+		tags       []string
+		format     string
+		lockToken  string
+		channel    string
+		visibility string
+	)
+	req := &calendarv1.CreateEventRequest{}
+
+	cmd := &cobra.Command{
+		Use:               "create [calendarID]",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeArgs(completeCalendars(root)),
+		Run: func(cmd *cobra.Command, args []string) {
+			req.CalendarId = mustResolveCalendarId(root, args[0])
+
+			fromTime, err := time.Parse(time.RFC3339, startTime)
+			if err != nil {
+				logrus.Fatalf("invalid value for --from, expected format %q: %s", time.RFC3339, err)
+			}
+			req.Start = timestamppb.New(fromTime)
+
+			if endTime != "" {
+				toTime, err := time.Parse(time.RFC3339, endTime)
+				if err != nil {
+					logrus.Fatalf("invalid value for --to, expected format %q: %s", time.RFC3339, err)
+				}
+				req.End = timestamppb.New(toTime)
+			}
+
+			createReq := connect.NewRequest(req)
+
+			// tags are not yet part of the CreateEventRequest
+			for _, tag := range tags {
+				createReq.Header().Add("X-Event-Tag", tag)
+			}
+
+			if format != "" {
+				createReq.Header().Set("X-Description-Format", format)
+			}
+
+			// slot locks are not yet part of the CreateEventRequest
+			if lockToken != "" {
+				createReq.Header().Set("X-Slot-Lock", lockToken)
+			}
+
+			// neither are channels
+			if channel != "" {
+				createReq.Header().Set("X-Event-Channel", channel)
+			}
+
+			if visibility != "" {
+				createReq.Header().Set("X-Event-Visibility", visibility)
+			}
+
+			res, err := root.Calendar().CreateEvent(root.Context(), createReq)
+			if err != nil {
+				logrus.Fatalf("failed to create event: %s", err)
+			}
+
+			root.Print(res.Msg)
+		},
 	}
 
+	f := cmd.Flags()
+	{
+		f.StringVar(&req.Name, "summary", "", "The event summary")
+		f.StringVar(&req.Description, "description", "", "The event description")
+		f.StringVar(&startTime, "from", "", "The start time of the event")
+		f.StringVar(&endTime, "to", "", "The end time of the event")
+		f.StringSliceVar(&tags, "tag", nil, "A list of tags for the event, like surgery or vaccination")
+		f.StringVar(&format, "description-format", "", "The format of --description, either html (default) or markdown")
+		f.StringVar(&lockToken, "lock-token", "", "The token of a slot lock for the event, see events lock")
+		f.StringVar(&channel, "channel", "", "The channel the event is booked through, like online")
+		f.StringVar(&visibility, "visibility", "", "The visibility of the event, either default, public, private or confidential")
+	}
+
+	_ = cmd.MarkFlagRequired("summary")
+	_ = cmd.MarkFlagRequired("from")
+
 	return cmd
 }
 
@@ -72,23 +196,31 @@ func GetUpdateEventCommand(root *cli.Root) *cobra.Command {
 	var (
 		newStartTime string
 		newEndTime   string
+		tags         []string
+		format       string
+		visibility   string
+		fullDay      bool
 	)
 	req := &calendarv1.UpdateEventRequest{
 		UpdateMask: &fieldmaskpb.FieldMask{},
 	}
 
 	cmd := &cobra.Command{
-		Use:  "update [calendarID] [eventID]",
-		Args: cobra.ExactArgs(2),
+		Use:               "update [calendarID] [eventID]",
+		Args:              cobra.ExactArgs(2),
+		ValidArgsFunction: completeArgs(completeCalendars(root)),
 		Run: func(cmd *cobra.Command, args []string) {
 			mapping := [][2]string{
 				{"summary", "name"},
 				{"description", "description"},
 				{"from", "start"},
 				{"to", "end"},
+				{"tag", "tags"},
+				{"visibility", "visibility"},
+				{"full-day", "full_day"},
 			}
 
-			req.CalendarId = args[0]
+			req.CalendarId = mustResolveCalendarId(root, args[0])
 			req.EventId = args[1]
 
 			for _, m := range mapping {
@@ -121,7 +253,26 @@ func GetUpdateEventCommand(root *cli.Root) *cobra.Command {
 				logrus.Fatalf("no changes specified")
 			}
 
-			res, err := root.Calendar().UpdateEvent(root.Context(), connect.NewRequest(req))
+			updateReq := connect.NewRequest(req)
+
+			// tags are not yet part of the UpdateEventRequest
+			for _, tag := range tags {
+				updateReq.Header().Add("X-Event-Tag", tag)
+			}
+
+			if format != "" {
+				updateReq.Header().Set("X-Description-Format", format)
+			}
+
+			if visibility != "" {
+				updateReq.Header().Set("X-Event-Visibility", visibility)
+			}
+
+			if cmd.Flag("full-day").Changed {
+				updateReq.Header().Set("X-Full-Day", strconv.FormatBool(fullDay))
+			}
+
+			res, err := root.Calendar().UpdateEvent(root.Context(), updateReq)
 			if err != nil {
 				logrus.Fatalf("failed to update event: %s", err)
 			}
@@ -136,6 +287,10 @@ func GetUpdateEventCommand(root *cli.Root) *cobra.Command {
 		f.StringVar(&req.Description, "description", "", "The new event description")
 		f.StringVar(&newStartTime, "from", "", "The new start time for the event")
 		f.StringVar(&newEndTime, "to", "", "The new end time for the event")
+		f.StringSliceVar(&tags, "tag", nil, "The new tags of the event. Pass an empty value to remove all tags")
+		f.StringVar(&format, "description-format", "", "The format of --description, either html (default) or markdown")
+		f.StringVar(&visibility, "visibility", "", "The new visibility of the event, either default, public, private or confidential")
+		f.BoolVar(&fullDay, "full-day", false, "Convert the event to a full-day event, or to a timed event with --full-day=false")
 	}
 
 	return cmd
@@ -152,6 +307,20 @@ func GetEventsCommand(root *cli.Root) *cobra.Command {
 		readMask      []string
 		freeSlots     bool
 		onlyFreeSlots bool
+		shifts        bool
+		noOverlays    bool
+		noAbsences    bool
+		holidays      bool
+		excludeCals   []string
+		excludeUsers  []string
+		withDisabled  bool
+		tags          []string
+		statuses      []string
+		channels      []string
+		resource      string
+		allowPartial  bool
+		format        string
+		relative      string
 	)
 
 	cmd := &cobra.Command{
@@ -205,7 +374,7 @@ func GetEventsCommand(root *cli.Root) *cobra.Command {
 				if len(calendarIds) > 0 || len(userIds) > 0 {
 					req.Source = &calendarv1.ListEventsRequest_Sources{
 						Sources: &calendarv1.EventSource{
-							CalendarIds: calendarIds,
+							CalendarIds: mustResolveCalendarIds(root, calendarIds),
 							UserIds:     root.MustResolveUserIds(userIds),
 						},
 					}
@@ -237,11 +406,119 @@ func GetEventsCommand(root *cli.Root) *cobra.Command {
 				}
 			}
 
-			events, err := cli.ListEvents(context.Background(), connect.NewRequest(req))
+			if shifts {
+				if len(req.RequestKinds) == 0 {
+					req.RequestKinds = []calendarv1.CalenarEventRequestKind{
+						calendarv1.CalenarEventRequestKind_CALENDAR_EVENT_REQUEST_KIND_EVENTS,
+					}
+				}
+			}
+
+			listReq := connect.NewRequest(req)
+
+			// exclusions are not yet part of the ListEventsRequest
+			for _, id := range mustResolveCalendarIds(root, excludeCals) {
+				listReq.Header().Add("X-Exclude-Calendar-Id", id)
+			}
+
+			if len(excludeUsers) > 0 {
+				for _, id := range root.MustResolveUserIds(excludeUsers) {
+					listReq.Header().Add("X-Exclude-User-Id", id)
+				}
+			}
+
+			if withDisabled {
+				listReq.Header().Set("X-Include-Disabled-Users", "true")
+			}
+
+			// shift boundaries are not yet a request kind
+			if shifts {
+				listReq.Header().Set("X-Include-Shift-Bounds", "true")
+			}
+
+			// neither is excluding overlays or absences
+			if noOverlays {
+				listReq.Header().Set("X-Exclude-Overlays", "true")
+			}
+
+			if noAbsences {
+				listReq.Header().Set("X-Exclude-Absences", "true")
+			}
+
+			// or the holiday calendar
+			if holidays {
+				listReq.Header().Set("X-Include-Holidays", "true")
+			}
+
+			// tag filters are not yet part of the ListEventsRequest
+			for _, tag := range tags {
+				listReq.Header().Add("X-Event-Tag", tag)
+			}
+
+			// neither are status filters
+			for _, status := range statuses {
+				listReq.Header().Add("X-Event-Status", status)
+			}
+
+			// or channel filters
+			for _, channel := range channels {
+				listReq.Header().Add("X-Event-Channel", channel)
+			}
+
+			// or resource filters
+			if resource != "" {
+				listReq.Header().Set("X-Event-Resource", resource)
+			}
+
+			if format != "" {
+				listReq.Header().Set("X-Description-Format", format)
+			}
+
+			// relative ranges are not yet part of the ListEventsRequest
+			if relative != "" {
+				if req.SearchTime != nil {
+					logrus.Fatalf("--range cannot be combined with --date, --from or --to")
+				}
+
+				listReq.Header().Set("X-Relative-Range", relative)
+			}
+
+			// partial results are not yet part of the ListEventsRequest
+			if cmd.Flags().Changed("allow-partial") {
+				listReq.Header().Set("X-Allow-Partial", strconv.FormatBool(allowPartial))
+			}
+
+			// query diagnostics are not yet part of the ListEventsResponse
+			if root.Debug() {
+				listReq.Header().Set("X-Diagnostics", "true")
+			}
+
+			events, err := cli.ListEvents(context.Background(), listReq)
 			if err != nil {
 				logrus.Fatalf("failed to get event list: %s", err)
 			}
 
+			for _, failed := range events.Header().Values("X-Calendar-Error") {
+				logrus.Warnf("failed to load calendar: %s", failed)
+			}
+
+			for _, source := range events.Header().Values("X-Unresolved-Source") {
+				logrus.Warnf("source did not resolve to a calendar: %s", source)
+			}
+
+			for _, status := range events.Header().Values("X-Event-Status-Result") {
+				logrus.Infof("event status: %s", status)
+			}
+
+			if diag := events.Header().Get("X-Query-Diagnostics"); diag != "" {
+				var buf bytes.Buffer
+				if err := json.Indent(&buf, []byte(diag), "", "  "); err != nil {
+					logrus.Warnf("failed to decode query diagnostics: %s", err)
+				} else {
+					fmt.Fprintln(os.Stderr, buf.String())
+				}
+			}
+
 			root.Print(events.Msg)
 		},
 	}
@@ -254,16 +531,46 @@ func GetEventsCommand(root *cli.Root) *cobra.Command {
 		f.StringVar(&date, "date", "", "The date to query events for in format YYYY/MM/DD")
 		f.StringVar(&from, "from", "", "")
 		f.StringVar(&to, "to", "", "")
-		f.StringSliceVar(&readMask, "fields", nil, "A list of fields to query.")
+		f.StringVar(&relative, "range", "", "A range relative to today, either today, tomorrow, this-week, next-week or this-month, or an ISO week like 2024-W01")
+		f.StringSliceVar(&readMask, "fields", nil, "A list of fields to query, like calendar or events.summary,events.start_time")
 		f.BoolVar(&freeSlots, "include-free", false, "Include free slots")
 		f.BoolVar(&onlyFreeSlots, "only-free", false, "Include free slots")
+		f.BoolVar(&shifts, "include-shifts", false, "Include the shift boundaries of each calendar")
+		f.BoolVar(&noOverlays, "exclude-overlays", false, "Exclude read-only overlay events")
+		f.BoolVar(&noAbsences, "exclude-absences", false, "Exclude out-of-office and focus-time events")
+		f.BoolVar(&holidays, "holidays", false, "Include the public holidays as a virtual calendar")
+		f.StringSliceVar(&excludeCals, "exclude-calendar", nil, "A list of calendar IDs to exclude. Calendars passed to --calendar are never excluded")
+		f.StringSliceVar(&excludeUsers, "exclude-user", nil, "A list of user IDs whose calendars should be excluded")
+		f.BoolVar(&withDisabled, "include-disabled-users", false, "Include the calendars of disabled users with --all")
+		f.StringSliceVar(&tags, "tag", nil, "Only return events with at least one of the tags")
+		f.StringSliceVar(&statuses, "status", nil, "Only return events with one of the appointment statuses, like arrived")
+		f.StringSliceVar(&channels, "channel", nil, "Only return events created through one of the channels, like online")
+		f.StringVar(&resource, "resource", "", "Only return events that require the resource, like \"OP 1\"")
+		f.StringVar(&format, "description-format", "", "Return descriptions written as markdown as markdown instead of html")
+		f.BoolVar(&allowPartial, "allow-partial", false, "Return the events of healthy calendars if some calendars fail. Defaults to true for --all")
 	}
 
 	cmd.MarkFlagsMutuallyExclusive("include-free", "only-free")
 
+	_ = cmd.RegisterFlagCompletionFunc("calendar", completeCalendars(root))
+	_ = cmd.RegisterFlagCompletionFunc("exclude-calendar", completeCalendars(root))
+	_ = cmd.RegisterFlagCompletionFunc("user-ids", completeUsers(root))
+	_ = cmd.RegisterFlagCompletionFunc("exclude-user", completeUsers(root))
+	_ = cmd.RegisterFlagCompletionFunc("status", completeStatus)
+
 	cmd.AddCommand(
+		GetCreateEventCommand(root),
 		GetMoveEventCommand(root),
 		GetUpdateEventCommand(root),
+		GetSearchEventsCommand(root),
+		GetExportEventsCommand(root),
+		GetEventsHeatmapCommand(root),
+		GetLockSlotCommand(root),
+		GetCurrentEventsCommand(root),
+		GetEventStatusCommand(root),
+		GetEventNoteCommand(root),
+		GetBulkDeleteEventsCommand(root),
+		GetBookingSlotsCommand(root),
 	)
 
 	return cmd
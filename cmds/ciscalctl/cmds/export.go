@@ -0,0 +1,64 @@
+package cmds
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/tierklinik-dobersberg/apis/pkg/cli"
+)
+
+func GetExportEventsCommand(root *cli.Root) *cobra.Command {
+	var (
+		calendarIds []string
+		columns     []string
+		from        string
+		to          string
+		format      string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "export",
+		Short: "Export events as CSV",
+		Run: func(cmd *cobra.Command, args []string) {
+			if format != "csv" {
+				logrus.Fatalf("unsupported export format %q, only csv is supported", format)
+			}
+
+			query := url.Values{}
+			query.Set("from", from)
+			query.Set("to", to)
+
+			for _, id := range mustResolveCalendarIds(root, calendarIds) {
+				query.Add("calendar", id)
+			}
+
+			if len(columns) > 0 {
+				query.Set("columns", strings.Join(columns, ","))
+			}
+
+			if err := doJSON(root.Context(), root, http.MethodGet, "/export/events.csv?"+query.Encode(), nil, os.Stdout); err != nil {
+				logrus.Fatalf("failed to export events: %s", err)
+			}
+		},
+	}
+
+	f := cmd.Flags()
+	{
+		f.StringSliceVar(&calendarIds, "calendar", nil, "A list of calendar IDs to export. Defaults to all calendars")
+		f.StringSliceVar(&columns, "columns", nil, "A list of columns to export. Defaults to calendar,event_id,start,end,duration_minutes,customer_id,created_by")
+		f.StringVar(&from, "from", "", "Export events starting at this date (YYYY-MM-DD)")
+		f.StringVar(&to, "to", "", "Export events before this date (YYYY-MM-DD, exclusive)")
+		f.StringVar(&format, "format", "csv", "The export format, only csv is supported")
+	}
+
+	cmd.MarkFlagRequired("from")
+	cmd.MarkFlagRequired("to")
+
+	_ = cmd.RegisterFlagCompletionFunc("calendar", completeCalendars(root))
+
+	return cmd
+}
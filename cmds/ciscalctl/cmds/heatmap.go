@@ -0,0 +1,148 @@
+package cmds
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/tierklinik-dobersberg/apis/pkg/cli"
+)
+
+// heatmapShades are used to render the booked minutes of an hour relative
+// to the busiest hour.
+var heatmapShades = []rune(" .:-=+*#%@")
+
+var heatmapWeekdays = []string{"Mo", "Tu", "We", "Th", "Fr", "Sa", "Su"}
+
+type utilizationHeatmap struct {
+	From      string `json:"from"`
+	To        string `json:"to"`
+	Days      [7]int `json:"days"`
+	Calendars []struct {
+		CalendarID   string     `json:"calendarId"`
+		CalendarName string     `json:"calendarName"`
+		Minutes      [7][24]int `json:"minutes"`
+	} `json:"calendars"`
+}
+
+func GetEventsHeatmapCommand(root *cli.Root) *cobra.Command {
+	var (
+		calendarIds []string
+		weeks       int
+		aggregate   bool
+		raw         bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "heatmap",
+		Short: "Show the booked minutes per weekday and hour",
+		Long: "Show the booked minutes per weekday and hour over the last weeks.\n\n" +
+			"Each cell shows the average booked minutes of the hour relative to the\n" +
+			"busiest hour of the calendar. Full-day, transparent and out-of-office\n" +
+			"events are not counted.",
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			if weeks < 1 {
+				logrus.Fatalf("--weeks must be at least 1")
+			}
+
+			now := time.Now()
+			to := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+			from := to.AddDate(0, 0, -7*weeks)
+
+			query := url.Values{}
+			query.Set("from", from.Format("2006-01-02"))
+			query.Set("to", to.Format("2006-01-02"))
+
+			if aggregate {
+				query.Set("aggregate", "true")
+			}
+
+			for _, id := range mustResolveCalendarIds(root, calendarIds) {
+				query.Add("calendar", id)
+			}
+
+			var heatmap utilizationHeatmap
+			if err := doJSON(root.Context(), root, http.MethodGet, "/events/heatmap?"+query.Encode(), nil, &heatmap); err != nil {
+				logrus.Fatalf("failed to load heatmap: %s", err)
+			}
+
+			if raw {
+				root.Print(heatmap)
+				return
+			}
+
+			renderHeatmap(heatmap)
+		},
+	}
+
+	f := cmd.Flags()
+	{
+		f.StringSliceVar(&calendarIds, "calendar", nil, "A list of calendar IDs. Defaults to all calendars")
+		f.IntVar(&weeks, "weeks", 8, "The number of weeks, ending yesterday, to include")
+		f.BoolVar(&aggregate, "aggregate", false, "Show a single heatmap for all calendars")
+		f.BoolVar(&raw, "raw", false, "Print the booked minutes instead of rendering the heatmap")
+	}
+
+	_ = cmd.RegisterFlagCompletionFunc("calendar", completeCalendars(root))
+
+	return cmd
+}
+
+func renderHeatmap(heatmap utilizationHeatmap) {
+	fmt.Printf("%s - %s\n", heatmap.From, heatmap.To)
+
+	for _, cal := range heatmap.Calendars {
+		name := cal.CalendarName
+		if name == "" {
+			name = "All calendars"
+		}
+
+		// average the minutes over the number of days per weekday
+		var (
+			avg     [7][24]float64
+			busiest float64
+		)
+
+		for day := range cal.Minutes {
+			if heatmap.Days[day] == 0 {
+				continue
+			}
+
+			for hour, minutes := range cal.Minutes[day] {
+				avg[day][hour] = float64(minutes) / float64(heatmap.Days[day])
+				if avg[day][hour] > busiest {
+					busiest = avg[day][hour]
+				}
+			}
+		}
+
+		fmt.Printf("\n%s (busiest hour: %.0f min)\n   ", name, busiest)
+		for hour := 0; hour < 24; hour++ {
+			fmt.Printf("%3d", hour)
+		}
+		fmt.Println()
+
+		for day, label := range heatmapWeekdays {
+			var line strings.Builder
+			line.WriteString(label + " ")
+
+			for hour := 0; hour < 24; hour++ {
+				shade := ' '
+				if busiest > 0 {
+					idx := int(avg[day][hour] / busiest * float64(len(heatmapShades)-1))
+					shade = heatmapShades[idx]
+				}
+
+				line.WriteString("  ")
+				line.WriteRune(shade)
+			}
+
+			fmt.Println(line.String())
+		}
+	}
+}
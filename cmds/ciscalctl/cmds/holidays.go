@@ -2,6 +2,10 @@ package cmds
 
 import (
 	"context"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
 	"time"
 
 	"github.com/bufbuild/connect-go"
@@ -12,10 +16,17 @@ import (
 	"github.com/tierklinik-dobersberg/apis/pkg/cli"
 )
 
+// maxHolidayRangeYears is the maximum number of years a --from/--to range
+// may span.
+const maxHolidayRangeYears = 5
+
 func GetHolidayCommand(root *cli.Root) *cobra.Command {
 	var (
-		year  int
-		month int
+		year      int
+		month     int
+		from      string
+		to        string
+		rangeMode bool
 	)
 	cmd := &cobra.Command{
 		Use:     "holiday",
@@ -24,6 +35,18 @@ func GetHolidayCommand(root *cli.Root) *cobra.Command {
 			// FIXME(ppacher): add a Holiday() method to github.com/tierklinik-dobersberg/apis/pkg/cli#Root
 			cli := calendarv1connect.NewHolidayServiceClient(root.HttpClient, root.Config().BaseURLS.Calendar)
 
+			if from != "" || to != "" {
+				res := getHolidaysBetween(cli, from, to)
+
+				if rangeMode {
+					printHolidayRange(res, from, to)
+				} else {
+					root.Print(res)
+				}
+
+				return
+			}
+
 			if year == 0 {
 				year = time.Now().Year()
 			}
@@ -45,6 +68,92 @@ func GetHolidayCommand(root *cli.Root) *cobra.Command {
 
 	cmd.Flags().IntVar(&year, "year", 0, "The year to query holidays")
 	cmd.Flags().IntVar(&month, "month", 0, "The month to query holidays")
+	cmd.Flags().StringVar(&from, "from", "", "Query holidays starting at this date (YYYY-MM-DD)")
+	cmd.Flags().StringVar(&to, "to", "", "Query holidays until this date (YYYY-MM-DD, inclusive)")
+	cmd.Flags().BoolVar(&rangeMode, "range", false, "Print whether each day between --from and --to is a holiday")
+
+	cmd.MarkFlagsMutuallyExclusive("year", "from")
+	cmd.MarkFlagsMutuallyExclusive("year", "to")
+	cmd.MarkFlagsMutuallyExclusive("month", "from")
+	cmd.MarkFlagsMutuallyExclusive("month", "to")
+	cmd.MarkFlagsRequiredTogether("from", "to")
+	cmd.MarkFlagsRequiredTogether("range", "from")
 
 	return cmd
 }
+
+// getHolidaysBetween returns all holidays between from and to sorted by date.
+// The HolidayService only supports querying a year so the holidays of each
+// year in the range are fetched and filtered.
+func getHolidaysBetween(cli calendarv1connect.HolidayServiceClient, from, to string) *calendarv1.GetHolidayResponse {
+	fromDate, err := time.Parse("2006-01-02", from)
+	if err != nil {
+		logrus.Fatalf("invalid value for --from: %s", err)
+	}
+
+	toDate, err := time.Parse("2006-01-02", to)
+	if err != nil {
+		logrus.Fatalf("invalid value for --to: %s", err)
+	}
+
+	if toDate.Before(fromDate) {
+		logrus.Fatalf("--to must not be before --from")
+	}
+
+	if toDate.Year()-fromDate.Year() >= maxHolidayRangeYears {
+		logrus.Fatalf("the range may span at most %d years", maxHolidayRangeYears)
+	}
+
+	result := &calendarv1.GetHolidayResponse{}
+	for y := fromDate.Year(); y <= toDate.Year(); y++ {
+		res, err := cli.GetHoliday(context.Background(), connect.NewRequest(&calendarv1.GetHolidayRequest{
+			Year: uint64(y),
+		}))
+		if err != nil {
+			logrus.Fatalf("failed to get holidays for %d: %s", y, err)
+		}
+
+		for _, h := range res.Msg.Holidays {
+			// dates are formatted as YYYY-MM-DD so they can be compared as strings
+			if h.Date >= from && h.Date <= to {
+				result.Holidays = append(result.Holidays, h)
+			}
+		}
+	}
+
+	sort.SliceStable(result.Holidays, func(i, j int) bool {
+		return result.Holidays[i].Date < result.Holidays[j].Date
+	})
+
+	return result
+}
+
+// printHolidayRange prints a table with one row for each day between from and
+// to.
+func printHolidayRange(res *calendarv1.GetHolidayResponse, from, to string) {
+	byDate := make(map[string]*calendarv1.PublicHoliday, len(res.Holidays))
+	for _, h := range res.Holidays {
+		byDate[h.Date] = h
+	}
+
+	// from and to have already been validated by getHolidaysBetween
+	start, _ := time.Parse("2006-01-02", from)
+	end, _ := time.Parse("2006-01-02", to)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+
+	fmt.Fprintln(w, "DATE\tHOLIDAY\tNAME")
+	for d := start; !d.After(end); d = d.AddDate(0, 0, 1) {
+		key := d.Format("2006-01-02")
+
+		if h, ok := byDate[key]; ok {
+			fmt.Fprintf(w, "%s\tyes\t%s\n", key, h.LocalName)
+		} else {
+			fmt.Fprintf(w, "%s\tno\t\n", key)
+		}
+	}
+
+	if err := w.Flush(); err != nil {
+		logrus.Fatalf("failed to write results: %s", err)
+	}
+}
@@ -0,0 +1,74 @@
+package cmds
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/tierklinik-dobersberg/apis/pkg/cli"
+)
+
+// doJSON sends a request to one of the plain HTTP endpoints of the calendar
+// service, like /conflicts or /webhooks. body is sent as is if it's an
+// io.Reader and encoded as JSON otherwise, unless it's nil. The response is
+// copied to out if it's an io.Writer and decoded from JSON otherwise, unless
+// out is nil. Responses with a non-2xx status are returned as an error that
+// includes the response body.
+func doJSON(ctx context.Context, root *cli.Root, method, path string, body, out any) error {
+	var payload io.Reader
+
+	switch b := body.(type) {
+	case nil:
+	case io.Reader:
+		payload = b
+	default:
+		blob, err := json.Marshal(b)
+		if err != nil {
+			return fmt.Errorf("failed to encode request: %w", err)
+		}
+
+		payload = bytes.NewReader(blob)
+	}
+
+	u := strings.TrimSuffix(root.Config().BaseURLS.Calendar, "/") + path
+
+	req, err := http.NewRequestWithContext(ctx, method, u, payload)
+	if err != nil {
+		return fmt.Errorf("failed to prepare request: %w", err)
+	}
+
+	if _, raw := body.(io.Reader); body != nil && !raw {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	res, err := root.HttpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		msg, _ := io.ReadAll(res.Body)
+
+		return fmt.Errorf("%s: %s", res.Status, strings.TrimSpace(string(msg)))
+	}
+
+	switch o := out.(type) {
+	case nil:
+		return nil
+	case io.Writer:
+		_, err := io.Copy(o, res.Body)
+
+		return err
+	default:
+		if err := json.NewDecoder(res.Body).Decode(out); err != nil {
+			return fmt.Errorf("failed to decode response: %w", err)
+		}
+
+		return nil
+	}
+}
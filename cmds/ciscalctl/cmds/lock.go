@@ -0,0 +1,78 @@
+package cmds
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/tierklinik-dobersberg/apis/pkg/cli"
+)
+
+func GetLockSlotCommand(root *cli.Root) *cobra.Command {
+	var (
+		from    string
+		to      string
+		release string
+	)
+
+	cmd := &cobra.Command{
+		Use:               "lock [calendarID]",
+		Short:             "Lock a time slot for booking or release a lock",
+		Args:              cobra.MaximumNArgs(1),
+		ValidArgsFunction: completeArgs(completeCalendars(root)),
+		Run: func(cmd *cobra.Command, args []string) {
+			if release != "" {
+				path := "/slot-locks?" + url.Values{"token": {release}}.Encode()
+				if err := doJSON(root.Context(), root, http.MethodDelete, path, nil, nil); err != nil {
+					logrus.Fatalf("failed to release lock: %s", err)
+				}
+
+				fmt.Println("lock released")
+
+				return
+			}
+
+			if len(args) != 1 {
+				logrus.Fatalf("missing calendar id")
+			}
+
+			fromTime, err := time.Parse(time.RFC3339, from)
+			if err != nil {
+				logrus.Fatalf("invalid value for --from, expected format %q: %s", time.RFC3339, err)
+			}
+
+			toTime, err := time.Parse(time.RFC3339, to)
+			if err != nil {
+				logrus.Fatalf("invalid value for --to, expected format %q: %s", time.RFC3339, err)
+			}
+
+			body := map[string]any{
+				"calendarId": mustResolveCalendarId(root, args[0]),
+				"start":      fromTime,
+				"end":        toTime,
+			}
+
+			var lock struct {
+				Token     string    `json:"token"`
+				ExpiresAt time.Time `json:"expiresAt"`
+			}
+			if err := doJSON(root.Context(), root, http.MethodPost, "/slot-locks", body, &lock); err != nil {
+				logrus.Fatalf("failed to lock slot: %s", err)
+			}
+
+			fmt.Printf("%s (expires at %s)\n", lock.Token, lock.ExpiresAt.Local().Format(time.RFC3339))
+		},
+	}
+
+	f := cmd.Flags()
+	{
+		f.StringVar(&from, "from", "", "The start time of the slot")
+		f.StringVar(&to, "to", "", "The end time of the slot")
+		f.StringVar(&release, "release", "", "Release the lock with the given token instead")
+	}
+
+	return cmd
+}
@@ -0,0 +1,60 @@
+package cmds
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/tierklinik-dobersberg/apis/pkg/cli"
+)
+
+func GetEventNoteCommand(root *cli.Root) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "note [calendarID] [eventID] [text]",
+		Short: "Show or set the internal note of an event",
+		Long: "Show or set the internal note of an event.\n\n" +
+			"Notes are only stored by the calendar service and never synced to the\n" +
+			"calendar itself. Without text the current note is printed, an empty\n" +
+			"text (\"\") removes the note.",
+		Args:              cobra.RangeArgs(2, 3),
+		ValidArgsFunction: completeArgs(completeCalendars(root), nil, nil),
+		Run: func(cmd *cobra.Command, args []string) {
+			calID := mustResolveCalendarId(root, args[0])
+
+			var note struct {
+				Text      string    `json:"text"`
+				UpdatedBy string    `json:"updatedBy"`
+				UpdatedAt time.Time `json:"updatedAt"`
+			}
+
+			var err error
+			if len(args) == 2 {
+				err = doJSON(root.Context(), root, http.MethodGet, "/events/note?"+url.Values{
+					"calendar": {calID},
+					"event":    {args[1]},
+				}.Encode(), nil, &note)
+			} else {
+				err = doJSON(root.Context(), root, http.MethodPut, "/events/note", map[string]any{
+					"calendarId": calID,
+					"eventId":    args[1],
+					"note":       args[2],
+				}, &note)
+			}
+			if err != nil {
+				logrus.Fatalf("failed to access event note: %s", err)
+			}
+
+			if note.Text == "" {
+				fmt.Println("note removed")
+				return
+			}
+
+			fmt.Printf("%s\n(updated by %q at %s)\n", note.Text, note.UpdatedBy, note.UpdatedAt.Local().Format(time.RFC3339))
+		},
+	}
+
+	return cmd
+}
@@ -0,0 +1,105 @@
+package cmds
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/tierklinik-dobersberg/apis/pkg/cli"
+)
+
+type currentEvent struct {
+	ID         string    `json:"id"`
+	Summary    string    `json:"summary"`
+	Start      time.Time `json:"start"`
+	End        time.Time `json:"end"`
+	FullDay    bool      `json:"fullDay"`
+	OpenEnd    bool      `json:"openEnd"`
+	CustomerID string    `json:"customerId"`
+}
+
+func (e currentEvent) String() string {
+	var times string
+	switch {
+	case e.FullDay:
+		times = "all day"
+	case e.OpenEnd:
+		times = e.Start.Local().Format("15:04") + "-?"
+	default:
+		times = e.Start.Local().Format("15:04") + "-" + e.End.Local().Format("15:04")
+	}
+
+	s := fmt.Sprintf("%s %q (%s)", times, e.Summary, e.ID)
+	if e.CustomerID != "" {
+		s += " customer=" + e.CustomerID
+	}
+
+	return s
+}
+
+func GetCurrentEventsCommand(root *cli.Root) *cobra.Command {
+	var (
+		calendarIds []string
+		userIds     []string
+		lookahead   time.Duration
+	)
+
+	cmd := &cobra.Command{
+		Use:   "now",
+		Short: "Print the events that are currently running and the next upcoming ones",
+		Run: func(cmd *cobra.Command, args []string) {
+			query := url.Values{}
+			if lookahead > 0 {
+				query.Set("lookahead", lookahead.String())
+			}
+
+			for _, id := range mustResolveCalendarIds(root, calendarIds) {
+				query.Add("calendar", id)
+			}
+
+			for _, id := range root.MustResolveUserIds(userIds) {
+				query.Add("user", id)
+			}
+
+			var calendars []struct {
+				CalendarID string         `json:"calendarId"`
+				Current    []currentEvent `json:"current"`
+				Next       *currentEvent  `json:"next"`
+			}
+			if err := doJSON(root.Context(), root, http.MethodGet, "/events/now?"+query.Encode(), nil, &calendars); err != nil {
+				logrus.Fatalf("failed to load current events: %s", err)
+			}
+
+			for _, cal := range calendars {
+				fmt.Printf("%s:\n", cal.CalendarID)
+
+				if len(cal.Current) == 0 {
+					fmt.Println("  now:  -")
+				}
+
+				for _, e := range cal.Current {
+					fmt.Printf("  now:  %s\n", e)
+				}
+
+				if cal.Next != nil {
+					fmt.Printf("  next: %s\n", *cal.Next)
+				}
+			}
+		},
+	}
+
+	f := cmd.Flags()
+	{
+		f.StringSliceVar(&calendarIds, "calendar", nil, "A list of calendar IDs or names. Defaults to all calendars assigned to users")
+		f.StringSliceVar(&userIds, "user", nil, "A list of user IDs or names whose calendars should be checked")
+		f.DurationVar(&lookahead, "lookahead", 0, "How far ahead to look for the next event. Defaults to the server configuration")
+	}
+
+	_ = cmd.RegisterFlagCompletionFunc("calendar", completeCalendars(root))
+	_ = cmd.RegisterFlagCompletionFunc("user", completeUsers(root))
+
+	return cmd
+}
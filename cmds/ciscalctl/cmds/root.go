@@ -7,5 +7,10 @@ func PrepareRootCommand(root *cli.Root) {
 		GetCalendarCommand(root),
 		GetEventsCommand(root),
 		GetHolidayCommand(root),
+		GetDebugCommand(root),
+		GetWebhooksCommand(root),
+		GetWaitingListCommand(root),
+		GetCapabilitiesCommand(root),
+		GetAdminCommand(root),
 	)
 }
@@ -0,0 +1,159 @@
+package cmds
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	calendarv1 "github.com/tierklinik-dobersberg/apis/gen/go/tkd/calendar/v1"
+	commonv1 "github.com/tierklinik-dobersberg/apis/gen/go/tkd/common/v1"
+	"github.com/tierklinik-dobersberg/apis/pkg/cli"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/repo"
+)
+
+type searchResult struct {
+	calendar string
+	event    *calendarv1.CalendarEvent
+	rank     int
+}
+
+func GetSearchEventsCommand(root *cli.Root) *cobra.Command {
+	var (
+		calendarIds []string
+		from        string
+		to          string
+		limit       int
+		offset      int
+	)
+
+	cmd := &cobra.Command{
+		Use:   "search [query]",
+		Short: "Search events in all calendars",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			start := time.Now()
+			if from != "" {
+				t, err := time.Parse(time.RFC3339, from)
+				if err != nil {
+					logrus.Fatalf("invalid value for --from: %s, expected format %q", err, time.RFC3339)
+				}
+
+				start = t
+			}
+
+			end := start.AddDate(0, 1, 0)
+			if to != "" {
+				t, err := time.Parse(time.RFC3339, to)
+				if err != nil {
+					logrus.Fatalf("invalid value for --to: %s, expected format %q", err, time.RFC3339)
+				}
+
+				end = t
+			}
+
+			req := &calendarv1.ListEventsRequest{
+				SearchTime: &calendarv1.ListEventsRequest_TimeRange{
+					TimeRange: commonv1.NewTimeRange(start, end),
+				},
+			}
+
+			if len(calendarIds) > 0 {
+				req.Source = &calendarv1.ListEventsRequest_Sources{
+					Sources: &calendarv1.EventSource{
+						CalendarIds: mustResolveCalendarIds(root, calendarIds),
+					},
+				}
+			} else {
+				req.Source = &calendarv1.ListEventsRequest_AllCalendars{
+					AllCalendars: true,
+				}
+			}
+
+			// the calendar service only returns matching events, ranking
+			// them is left to the client.
+			listReq := connect.NewRequest(req)
+			listReq.Header().Set("X-Event-Query", args[0])
+
+			res, err := root.Calendar().ListEvents(root.Context(), listReq)
+			if err != nil {
+				logrus.Fatalf("failed to get event list: %s", err)
+			}
+
+			var results []searchResult
+			for _, list := range res.Msg.Results {
+				name := list.GetCalendar().GetName()
+
+				for _, evt := range list.Events {
+					results = append(results, searchResult{
+						calendar: name,
+						event:    evt,
+						rank:     repo.MatchRank(args[0], evt.Summary, evt.Description),
+					})
+				}
+			}
+
+			sort.SliceStable(results, func(i, j int) bool {
+				if results[i].rank != results[j].rank {
+					return results[i].rank > results[j].rank
+				}
+
+				return results[i].event.StartTime.AsTime().Before(results[j].event.StartTime.AsTime())
+			})
+
+			if offset > len(results) {
+				offset = len(results)
+			}
+			results = results[offset:]
+
+			if limit > 0 && len(results) > limit {
+				results = results[:limit]
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+
+			fmt.Fprintln(w, "CALENDAR\tDATE\tSUMMARY\tSNIPPET")
+			for _, r := range results {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\n",
+					r.calendar,
+					r.event.StartTime.AsTime().Local().Format("2006-01-02 15:04"),
+					r.event.Summary,
+					snippet(r.event.Description, 40),
+				)
+			}
+
+			if err := w.Flush(); err != nil {
+				logrus.Fatalf("failed to write results: %s", err)
+			}
+		},
+	}
+
+	f := cmd.Flags()
+	{
+		f.StringSliceVar(&calendarIds, "calendar", nil, "A list of calendar IDs to search. Defaults to all calendars")
+		f.StringVar(&from, "from", "", "Only search events after this time. Defaults to now")
+		f.StringVar(&to, "to", "", "Only search events before this time. Defaults to one month after --from")
+		f.IntVar(&limit, "limit", 20, "The maximum number of results to show")
+		f.IntVar(&offset, "offset", 0, "The number of results to skip")
+	}
+
+	_ = cmd.RegisterFlagCompletionFunc("calendar", completeCalendars(root))
+
+	return cmd
+}
+
+// snippet returns the first line of s shortened to at most n runes.
+func snippet(s string, n int) string {
+	s, _, _ = strings.Cut(strings.TrimSpace(s), "\n")
+
+	if r := []rune(s); len(r) > n {
+		return string(r[:n]) + "…"
+	}
+
+	return s
+}
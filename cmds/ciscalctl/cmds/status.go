@@ -0,0 +1,47 @@
+package cmds
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/tierklinik-dobersberg/apis/pkg/cli"
+)
+
+// eventStatuses are the appointment statuses accepted by the server.
+var eventStatuses = []string{"planned", "arrived", "in-progress", "done", "no-show"}
+
+func completeStatus(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	return eventStatuses, cobra.ShellCompDirectiveNoFileComp
+}
+
+func GetEventStatusCommand(root *cli.Root) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:               "status [calendarID] [eventID] [status]",
+		Short:             "Change the appointment status of an event, like arrived or done",
+		Args:              cobra.ExactArgs(3),
+		ValidArgsFunction: completeArgs(completeCalendars(root), nil, completeStatus),
+		Run: func(cmd *cobra.Command, args []string) {
+			body := map[string]any{
+				"calendarId": mustResolveCalendarId(root, args[0]),
+				"eventId":    args[1],
+				"status":     args[2],
+			}
+
+			var status struct {
+				Status    string    `json:"status"`
+				ChangedBy string    `json:"changedBy"`
+				ChangedAt time.Time `json:"changedAt"`
+			}
+			if err := doJSON(root.Context(), root, http.MethodPost, "/event-status", body, &status); err != nil {
+				logrus.Fatalf("failed to change status: %s", err)
+			}
+
+			fmt.Printf("%s (changed by %q at %s)\n", status.Status, status.ChangedBy, status.ChangedAt.Local().Format(time.RFC3339))
+		},
+	}
+
+	return cmd
+}
@@ -0,0 +1,142 @@
+package cmds
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/tierklinik-dobersberg/apis/pkg/cli"
+)
+
+func GetWaitingListCommand(root *cli.Root) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "waiting-list",
+		Short: "Manage customers waiting for an earlier appointment",
+	}
+
+	cmd.AddCommand(
+		GetAddWaitingListCommand(root),
+		GetListWaitingListCommand(root),
+		GetRemoveWaitingListCommand(root),
+	)
+
+	return cmd
+}
+
+func GetAddWaitingListCommand(root *cli.Root) *cobra.Command {
+	var (
+		customerSource string
+		animals        []string
+		calendar       string
+		user           string
+		earliest       string
+		latest         string
+		duration       time.Duration
+		priority       int
+	)
+
+	cmd := &cobra.Command{
+		Use:   "add [customer]",
+		Short: "Add a customer to the waiting list",
+		Long: "Add a customer to the waiting list.\n\n" +
+			"Whenever a slot of at least the given duration between --earliest and --latest\n" +
+			"becomes free in the calendar, the calendar of the user or, if neither is set,\n" +
+			"any user calendar, it is published to the events service. Slots are never booked\n" +
+			"automatically. Entries are removed once --latest has passed.",
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			body := map[string]any{
+				"customerSource": customerSource,
+				"customerId":     args[0],
+				"animalIds":      animals,
+				"duration":       duration.String(),
+				"priority":       priority,
+			}
+
+			if earliest != "" {
+				earliestTime, err := time.Parse(time.RFC3339, earliest)
+				if err != nil {
+					logrus.Fatalf("invalid value for --earliest, expected format %q: %s", time.RFC3339, err)
+				}
+
+				body["earliest"] = earliestTime
+			}
+
+			latestTime, err := time.Parse(time.RFC3339, latest)
+			if err != nil {
+				logrus.Fatalf("invalid value for --latest, expected format %q: %s", time.RFC3339, err)
+			}
+
+			body["latest"] = latestTime
+
+			if calendar != "" {
+				body["calendarId"] = mustResolveCalendarId(root, calendar)
+			}
+
+			if user != "" {
+				body["userId"] = root.MustResolveUserIds([]string{user})[0]
+			}
+
+			var entry map[string]any
+			sendWaitingListRequest(root, http.MethodPost, "/waiting-list", body, &entry)
+
+			root.Print(entry)
+		},
+	}
+
+	f := cmd.Flags()
+	{
+		f.StringVar(&customerSource, "customer-source", "", "The source of the customer")
+		f.StringSliceVar(&animals, "animal", nil, "The id of an animal of the customer, may be repeated")
+		f.StringVar(&calendar, "calendar", "", "Only match slots of the calendar")
+		f.StringVar(&user, "user", "", "Only match slots of the calendar of the user")
+		f.StringVar(&earliest, "earliest", "", "The earliest acceptable start time, defaults to now")
+		f.StringVar(&latest, "latest", "", "The latest acceptable end time")
+		f.DurationVar(&duration, "duration", 30*time.Minute, "The duration of the appointment")
+		f.IntVar(&priority, "priority", 0, "Slots are offered to entries with a higher priority first")
+	}
+
+	_ = cmd.MarkFlagRequired("latest")
+	_ = cmd.RegisterFlagCompletionFunc("calendar", completeCalendars(root))
+	_ = cmd.RegisterFlagCompletionFunc("user", completeUsers(root))
+
+	return cmd
+}
+
+func GetListWaitingListCommand(root *cli.Root) *cobra.Command {
+	return &cobra.Command{
+		Use:   "list",
+		Short: "List the waiting list ordered by priority",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			var entries []map[string]any
+			sendWaitingListRequest(root, http.MethodGet, "/waiting-list", nil, &entries)
+
+			root.Print(entries)
+		},
+	}
+}
+
+func GetRemoveWaitingListCommand(root *cli.Root) *cobra.Command {
+	return &cobra.Command{
+		Use:   "remove [id]",
+		Short: "Remove an entry from the waiting list",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			sendWaitingListRequest(root, http.MethodDelete, "/waiting-list?id="+url.QueryEscape(args[0]), nil, nil)
+
+			fmt.Printf("removed waiting list entry %s\n", args[0])
+		},
+	}
+}
+
+// sendWaitingListRequest sends a request to the waiting list endpoint and
+// decodes the response into result, if not nil.
+func sendWaitingListRequest(root *cli.Root, method, path string, body any, result any) {
+	if err := doJSON(root.Context(), root, method, path, body, result); err != nil {
+		logrus.Fatalf("waiting list request failed: %s", err)
+	}
+}
@@ -0,0 +1,122 @@
+package cmds
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/tierklinik-dobersberg/apis/pkg/cli"
+)
+
+func GetWebhooksCommand(root *cli.Root) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "webhooks",
+		Short: "Manage webhooks that receive event changes",
+	}
+
+	cmd.AddCommand(
+		GetAddWebhookCommand(root),
+		GetListWebhooksCommand(root),
+		GetRemoveWebhookCommand(root),
+		GetWebhookDeliveriesCommand(root),
+	)
+
+	return cmd
+}
+
+func GetAddWebhookCommand(root *cli.Root) *cobra.Command {
+	var (
+		secret    string
+		calendars []string
+		kinds     []string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "add [url]",
+		Short: "Subscribe an HTTPS URL to event changes",
+		Long: "Subscribe an HTTPS URL to event changes.\n\n" +
+			"Deliveries are signed using the secret of the subscription: the X-Webhook-Signature\n" +
+			"header is \"sha256=\" followed by the hex encoded HMAC-SHA256 of the X-Webhook-Timestamp\n" +
+			"header, a dot and the request body. The secret is only printed once.",
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			body := map[string]any{
+				"url":    args[0],
+				"secret": secret,
+				"kinds":  kinds,
+			}
+
+			if len(calendars) > 0 {
+				body["calendars"] = mustResolveCalendarIds(root, calendars)
+			}
+
+			var sub map[string]any
+			sendWebhookRequest(root, http.MethodPost, "/webhooks", body, &sub)
+
+			root.Print(sub)
+		},
+	}
+
+	f := cmd.Flags()
+	{
+		f.StringVar(&secret, "secret", "", "The secret used to sign deliveries, generated if empty")
+		f.StringSliceVar(&calendars, "calendar", nil, "Only send changes of the calendar, may be repeated")
+		f.StringSliceVar(&kinds, "kind", nil, "Only send changes of the kind (changed, deleted), may be repeated")
+	}
+
+	_ = cmd.RegisterFlagCompletionFunc("calendar", completeCalendars(root))
+	_ = cmd.RegisterFlagCompletionFunc("kind", cobra.FixedCompletions([]string{"changed", "deleted"}, cobra.ShellCompDirectiveNoFileComp))
+
+	return cmd
+}
+
+func GetListWebhooksCommand(root *cli.Root) *cobra.Command {
+	return &cobra.Command{
+		Use:   "list",
+		Short: "List all webhook subscriptions",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			var subs []map[string]any
+			sendWebhookRequest(root, http.MethodGet, "/webhooks", nil, &subs)
+
+			root.Print(subs)
+		},
+	}
+}
+
+func GetRemoveWebhookCommand(root *cli.Root) *cobra.Command {
+	return &cobra.Command{
+		Use:   "remove [id]",
+		Short: "Remove a webhook subscription",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			sendWebhookRequest(root, http.MethodDelete, "/webhooks?id="+url.QueryEscape(args[0]), nil, nil)
+
+			fmt.Printf("removed webhook %s\n", args[0])
+		},
+	}
+}
+
+func GetWebhookDeliveriesCommand(root *cli.Root) *cobra.Command {
+	return &cobra.Command{
+		Use:   "deliveries [id]",
+		Short: "Show the recent deliveries of a webhook subscription",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			var deliveries []map[string]any
+			sendWebhookRequest(root, http.MethodGet, "/webhooks/deliveries?subscription="+url.QueryEscape(args[0]), nil, &deliveries)
+
+			root.Print(deliveries)
+		},
+	}
+}
+
+// sendWebhookRequest sends a request to the webhook endpoints and decodes
+// the response into result, if not nil.
+func sendWebhookRequest(root *cli.Root, method, path string, body any, result any) {
+	if err := doJSON(root.Context(), root, method, path, body, result); err != nil {
+		logrus.Fatalf("webhook request failed: %s", err)
+	}
+}
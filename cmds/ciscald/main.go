@@ -2,16 +2,19 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"syscall"
+	"time"
 
 	"github.com/bufbuild/connect-go"
 	"github.com/bufbuild/protovalidate-go"
 	"github.com/sirupsen/logrus"
 	"github.com/tierklinik-dobersberg/apis/gen/go/tkd/calendar/v1/calendarv1connect"
 	"github.com/tierklinik-dobersberg/apis/pkg/auth"
-	"github.com/tierklinik-dobersberg/apis/pkg/cors"
 	"github.com/tierklinik-dobersberg/apis/pkg/discovery"
 	"github.com/tierklinik-dobersberg/apis/pkg/discovery/consuldiscover"
 	"github.com/tierklinik-dobersberg/apis/pkg/discovery/wellknown"
@@ -21,10 +24,18 @@ import (
 	"github.com/tierklinik-dobersberg/apis/pkg/validator"
 	"github.com/tierklinik-dobersberg/cis-cal/internal/app"
 	"github.com/tierklinik-dobersberg/cis-cal/internal/config"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/cors"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/holidays"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/i18n"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/repo"
 	"github.com/tierklinik-dobersberg/cis-cal/internal/services"
 	"google.golang.org/protobuf/reflect/protoregistry"
 )
 
+// shutdownTimeout bounds how long pending change events are published
+// after the server stopped.
+const shutdownTimeout = 10 * time.Second
+
 func main() {
 	ctx := context.Background()
 
@@ -48,6 +59,8 @@ func main() {
 		logrus.Fatalf("failed to load configuration: %s", err)
 	}
 
+	repo.SetRedactPII(*cfg.Logging.RedactPII)
+
 	app, err := app.New(ctx, cfg)
 	if err != nil {
 		logrus.Fatalf("failed to prepare application providers: %s", err)
@@ -75,23 +88,187 @@ func main() {
 		}),
 	)
 
+	maintenance, err := services.NewMaintenance(cfg.Maintenance)
+	if err != nil {
+		logrus.Fatalf("failed to prepare maintenance mode: %s", err)
+	}
+
+	limiter := services.NewRateLimiter(cfg.RateLimit)
+
 	interceptors := connect.WithInterceptors(
 		logInterceptor,
 		authInterceptor,
 		validatorInterceptor,
 		privacyInterceptor,
+		services.NewMaintenanceInterceptor(maintenance),
+		services.NewResponseSizeInterceptor(cfg.Limits.WarnResponseSize),
+		limiter.Interceptor(),
+		services.NewLanguageInterceptor(i18n.Language(cfg.DefaultLanguage)),
 	)
 
+	// gzip is supported by connect-go out of the box, only compress responses
+	// that are worth it.
+	compression := connect.WithCompressMinBytes(cfg.Limits.CompressMinBytes)
+
 	serveMux := http.NewServeMux()
 
 	calService := services.New(ctx, app)
-	path, handler := calendarv1connect.NewCalendarServiceHandler(calService, interceptors)
+	path, handler := calendarv1connect.NewCalendarServiceHandler(calService, interceptors, compression)
 	serveMux.Handle(path, handler)
 
-	holidayService := services.NewHolidayService(cfg.DefaultCountry)
-	path, handler = calendarv1connect.NewHolidayServiceHandler(holidayService, interceptors)
+	// health endpoint, reports the state of the roster circuit breaker and
+	// the maintenance mode.
+	serveMux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		if err := json.NewEncoder(w).Encode(map[string]any{
+			"status":      "ok",
+			"roster":      calService.RosterState(),
+			"maintenance": maintenance.State(),
+		}); err != nil {
+			logrus.Errorf("failed to encode health response: %s", err)
+		}
+	})
+
+	// color rules for the UI, ListColorRules is not yet part of the
+	// CalendarService.
+	serveMux.HandleFunc("/color-rules", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		rules := cfg.ColorRules
+		if rules == nil {
+			rules = []config.ColorRule{}
+		}
+
+		if err := json.NewEncoder(w).Encode(rules); err != nil {
+			logrus.Errorf("failed to encode color rules: %s", err)
+		}
+	})
+
+	// the apis module does not have RPCs for the following features yet so
+	// they are served as plain HTTP endpoints.
+	serveMux.Handle("/capabilities", services.NewCapabilitiesHandler(calService, maintenance))
+	serveMux.Handle("/conflicts", services.NewConflictsHandler(calService, cfg.Conflicts.AllowedRoles))
+	serveMux.Handle("/slot-locks", services.NewSlotLockHandler(calService, cfg.SlotLocks.AllowedRoles))
+	serveMux.Handle("/calendar-versions", services.NewVersionHandler(calService))
+	serveMux.Handle("/events/now", services.NewCurrentEventsHandler(calService))
+	serveMux.Handle("/event-status", maintenance.Wrap(services.NewStatusHandler(calService)))
+	serveMux.Handle("/waiting-room", services.NewWaitingRoomHandler(calService))
+
+	printHandler, err := services.NewPrintHandler(calService, cfg.Print.Template)
+	if err != nil {
+		logrus.Fatalf("failed to load print template: %s", err)
+	}
+	serveMux.Handle("/print/day", printHandler)
+
+	if len(cfg.Export.AllowedRoles) > 0 {
+		serveMux.Handle("/export/events.csv", services.NewExportHandler(calService, cfg.Export.AllowedRoles))
+	}
+
+	if len(cfg.Heatmap.AllowedRoles) > 0 {
+		serveMux.Handle("/events/heatmap", services.NewHeatmapHandler(calService, cfg.Heatmap.AllowedRoles))
+	}
+
+	if len(cfg.Backup.AllowedRoles) > 0 {
+		serveMux.Handle("/calendars/export", services.NewCalendarExportHandler(calService, cfg.Backup.AllowedRoles))
+		serveMux.Handle("/calendars/import", maintenance.Wrap(services.NewCalendarImportHandler(calService, cfg.Backup.AllowedRoles)))
+	}
+
+	if len(cfg.CalendarAdmin.AllowedRoles) > 0 {
+		serveMux.Handle("/calendars/create", maintenance.Wrap(services.NewCreateCalendarHandler(calService, cfg.CalendarAdmin.AllowedRoles)))
+		serveMux.Handle("/calendars/assign", maintenance.Wrap(services.NewAssignCalendarHandler(calService, cfg.CalendarAdmin.AllowedRoles)))
+		serveMux.Handle("/calendars/update", maintenance.Wrap(services.NewUpdateCalendarHandler(calService, cfg.CalendarAdmin.AllowedRoles)))
+	}
+
+	if len(cfg.BulkDelete.AllowedRoles) > 0 {
+		serveMux.Handle("/events/bulk-delete", maintenance.Wrap(services.NewBulkDeleteHandler(calService, cfg.BulkDelete.AllowedRoles)))
+	}
+
+	if len(cfg.Webhooks.AllowedRoles) > 0 {
+		webhooks, err := services.NewWebhookDispatcher(ctx, cfg.Webhooks)
+		if err != nil {
+			logrus.Fatalf("failed to prepare webhooks: %s", err)
+		}
+
+		webhooks.RedactPrivateEvents(calService)
+
+		if notifier, ok := app.Service.(repo.ChangeNotifier); ok {
+			notifier.OnChange(webhooks.Notify)
+		}
+
+		serveMux.Handle("/webhooks", maintenance.Wrap(services.NewWebhookHandler(webhooks, cfg.Webhooks.AllowedRoles)))
+		serveMux.Handle("/webhooks/deliveries", services.NewWebhookDeliveryHandler(webhooks, cfg.Webhooks.AllowedRoles))
+	}
+
+	if len(cfg.BookingExport.Services) > 0 {
+		exporter, err := services.NewBookingExporter(ctx, calService)
+		if err != nil {
+			logrus.Fatalf("failed to prepare booking portal export: %s", err)
+		}
+
+		if notifier, ok := app.Service.(repo.ChangeNotifier); ok {
+			notifier.OnChange(exporter.Notify)
+		}
+
+		exporter.Start(ctx)
+
+		if len(cfg.BookingExport.AllowedRoles) > 0 {
+			serveMux.Handle("/booking/slots", services.NewBookingExportHandler(exporter, cfg.BookingExport.AllowedRoles))
+		}
+	}
+
+	if len(cfg.Notes.AllowedRoles) > 0 {
+		notes, err := services.NewNoteStore(cfg.Notes)
+		if err != nil {
+			logrus.Fatalf("failed to prepare event notes: %s", err)
+		}
+
+		calService.SetNoteStore(notes)
+		serveMux.Handle("/events/note", maintenance.Wrap(services.NewNoteHandler(calService)))
+	}
+
+	if len(cfg.WaitingList.AllowedRoles) > 0 {
+		waitingList, err := services.NewWaitingList(ctx, calService)
+		if err != nil {
+			logrus.Fatalf("failed to prepare waiting list: %s", err)
+		}
+
+		if notifier, ok := app.Service.(repo.ChangeNotifier); ok {
+			notifier.OnChange(waitingList.Notify)
+		}
+
+		waitingList.Start(ctx)
+
+		serveMux.Handle("/waiting-list", maintenance.Wrap(services.NewWaitingListHandler(waitingList, cfg.WaitingList.AllowedRoles)))
+	}
+
+	if len(cfg.Maintenance.AllowedRoles) > 0 {
+		serveMux.Handle("/admin/maintenance", services.NewMaintenanceHandler(maintenance, cfg.Maintenance.AllowedRoles))
+	}
+
+	if len(cfg.Debug.AllowedRoles) > 0 {
+		serveMux.Handle("/debug/cache", services.NewDebugCacheHandler(calService, cfg.Debug.AllowedRoles))
+		serveMux.Handle("/debug/quota", services.NewDebugQuotaHandler(calService, cfg.Debug.AllowedRoles))
+		serveMux.Handle("/debug/redaction", services.NewDebugRedactionHandler(cfg.Debug.AllowedRoles))
+	}
+
+	holidayService := services.NewHolidayService(cfg.DefaultCountry, app.Holidays)
+
+	if len(cfg.SchoolHolidays) > 0 {
+		school, err := holidays.NewSchoolHolidays(cfg.SchoolHolidays)
+		if err != nil {
+			logrus.Fatalf("failed to prepare school holidays: %s", err)
+		}
+
+		holidayService.SetSchoolHolidays(school)
+	}
+
+	path, handler = calendarv1connect.NewHolidayServiceHandler(holidayService, interceptors, compression)
 	serveMux.Handle(path, handler)
 
+	// read-only JSON facade for integrations that cannot speak Connect.
+	serveMux.Handle("/api/v1/", services.NewRESTHandler(calService, holidayService, limiter))
+
 	corsOpts := cors.Config{
 		AllowedOrigins:   cfg.AllowedOrigins,
 		AllowCredentials: true, // we need allow-credentials here as browsers need to send the token for the forward-auth endpoint
@@ -111,12 +288,31 @@ func main() {
 		logrus.Errorf("failed to register service at catalog: %s", err)
 	}
 
+	// all routes, including the plain HTTP endpoints, are served through the
+	// CORS handler.
+	corsHandler, err := cors.Wrap(corsOpts, serveMux)
+	if err != nil {
+		logrus.Fatalf("failed to setup CORS: %s", err)
+	}
+
 	httpServer := server.Create(
 		cfg.ListenAddress,
-		cors.Wrap(corsOpts, serveMux),
+		corsHandler,
 	)
 
-	if err := server.Serve(ctx, httpServer); err != nil {
+	// ctx is the lifetime context of the application so it must not be
+	// canceled before app.Close had a chance to flush pending work.
+	serveCtx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := server.Serve(serveCtx, httpServer); err != nil {
 		logrus.Fatalf("failed to listen and serve: %s", err)
 	}
+
+	closeCtx, cancel := context.WithTimeout(ctx, shutdownTimeout)
+	defer cancel()
+
+	if err := app.Close(closeCtx); err != nil {
+		logrus.Errorf("failed to shutdown cleanly: %s", err)
+	}
 }
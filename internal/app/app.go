@@ -4,11 +4,16 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"sync"
 
 	"github.com/tierklinik-dobersberg/apis/gen/go/tkd/events/v1/eventsv1connect"
 	"github.com/tierklinik-dobersberg/apis/gen/go/tkd/idm/v1/idmv1connect"
+	"github.com/tierklinik-dobersberg/apis/gen/go/tkd/roster/v1/rosterv1connect"
 	"github.com/tierklinik-dobersberg/apis/pkg/cli"
+	"github.com/tierklinik-dobersberg/apis/pkg/discovery/consuldiscover"
+	"github.com/tierklinik-dobersberg/apis/pkg/discovery/wellknown"
 	"github.com/tierklinik-dobersberg/cis-cal/internal/config"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/holidays"
 	"github.com/tierklinik-dobersberg/cis-cal/internal/repo"
 )
 
@@ -19,23 +24,70 @@ type App struct {
 	Events eventsv1connect.EventServiceClient
 
 	repo.Service
+
+	// Holidays is shared by all services that need to know about
+	// public holidays.
+	Holidays holidays.Getter
+
+	rosterLock sync.Mutex
+	roster     rosterv1connect.RosterServiceClient
+	workShifts rosterv1connect.WorkShiftServiceClient
 }
 
 func New(ctx context.Context, cfg config.Config) (*App, error) {
 
-	service, err := repo.New(ctx, cfg)
+	google, err := repo.New(ctx, cfg)
 	if err != nil {
 		return nil, fmt.Errorf("failed to prepare google calendar backend: %w", err)
 	}
 
+	backends := repo.NewRegistry()
+	if err := backends.Register("google", google); err != nil {
+		return nil, err
+	}
+
 	app := &App{
-		Service: service,
+		Service: backends,
 
 		Config: cfg,
 		Users:  idmv1connect.NewUserServiceClient(http.DefaultClient, cfg.IdmURL),
 		Roles:  idmv1connect.NewRoleServiceClient(http.DefaultClient, cfg.IdmURL),
 		Events: eventsv1connect.NewEventServiceClient(cli.NewInsecureHttp2Client(), cfg.EventsServiceUrl),
+
+		Holidays: holidays.NewCache(),
 	}
 
 	return app, nil
 }
+
+// RosterClients returns clients for the roster and work-shift services. The
+// clients are resolved using service discovery on first use and cached
+// afterwards.
+func (app *App) RosterClients(ctx context.Context) (rosterv1connect.RosterServiceClient, rosterv1connect.WorkShiftServiceClient, error) {
+	app.rosterLock.Lock()
+	defer app.rosterLock.Unlock()
+
+	if app.roster != nil && app.workShifts != nil {
+		return app.roster, app.workShifts, nil
+	}
+
+	disc, err := consuldiscover.NewFromEnv()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get consul discovery client: %w", err)
+	}
+
+	roster, err := wellknown.RosterService.Create(ctx, disc)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get roster service client: %w", err)
+	}
+
+	workShifts, err := wellknown.WorkShiftService.Create(ctx, disc)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get workshift service client: %w", err)
+	}
+
+	app.roster = roster
+	app.workShifts = workShifts
+
+	return roster, workShifts, nil
+}
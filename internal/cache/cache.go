@@ -3,8 +3,11 @@ package cache
 import (
 	"context"
 	"log/slog"
+	"slices"
 	"sync"
 	"time"
+
+	"github.com/tierklinik-dobersberg/cis-cal/internal/clock"
 )
 
 type Loader[T any] interface {
@@ -22,8 +25,9 @@ type Indexer[T any] interface {
 }
 
 type Cache[T any] struct {
-	name string
-	log  *slog.Logger
+	name  string
+	log   *slog.Logger
+	clock clock.Clock
 
 	l         sync.RWMutex
 	interval  time.Duration
@@ -71,16 +75,21 @@ func NewCache[T any](name string, interval time.Duration, loader Loader[T]) *Cac
 		name:     name,
 		interval: interval,
 		loader:   loader,
-		trigger:  make(chan struct{}),
+		trigger:  make(chan struct{}, 1),
 		log:      slog.With("name", name),
+		clock:    clock.Real{},
 	}
 }
 
+// Get returns the cached values and whether they are stale. Values are stale
+// if they have not been refreshed within the interval. The age is measured
+// using the monotonic clock so wall clock adjustments do not make values
+// stale.
 func (c *Cache[T]) Get() ([]T, bool) {
 	c.l.RLock()
 	defer c.l.RUnlock()
 
-	isStale := time.Since(c.lastFetch) > c.interval
+	isStale := c.lastFetch.IsZero() || c.clock.Since(c.lastFetch) > c.interval
 
 	res := make([]T, len(c.values))
 
@@ -89,8 +98,45 @@ func (c *Cache[T]) Get() ([]T, bool) {
 	return res, isStale
 }
 
+// LastFetch returns the time the values have last been loaded and the
+// number of values. The time is zero if the values have never been loaded.
+func (c *Cache[T]) LastFetch() (time.Time, int) {
+	c.l.RLock()
+	defer c.l.RUnlock()
+
+	return c.lastFetch, len(c.values)
+}
+
+// Upsert replaces the first value for which match returns true with value,
+// or adds value if there is none, and updates the indexes. This allows to
+// add values that have been loaded on demand between two refreshes, the next
+// refresh replaces all values again.
+func (c *Cache[T]) Upsert(value T, match func(T) bool) {
+	c.l.Lock()
+
+	values := slices.Clone(c.values)
+	if idx := slices.IndexFunc(values, match); idx >= 0 {
+		values[idx] = value
+	} else {
+		values = append(values, value)
+	}
+	c.values = values
+
+	// indexes are updated while holding the lock so readers never see
+	// indexes that do not match the values.
+	c.updateIndexes(values)
+
+	c.l.Unlock()
+}
+
+// TriggerSync makes the cache reload its values as soon as possible. It
+// does not wait for the reload, a reload that is already pending is not
+// triggered again.
 func (c *Cache[T]) TriggerSync() {
-	c.trigger <- struct{}{}
+	select {
+	case c.trigger <- struct{}{}:
+	default:
+	}
 }
 
 func (c *Cache[T]) Wait() {
@@ -114,14 +160,13 @@ func (c *Cache[T]) Start(ctx context.Context) {
 				if err != nil {
 					c.log.Error("failed to update cache values", "error", err)
 				} else {
-					now := time.Now()
+					now := c.clock.Now()
 
 					c.l.Lock()
 					c.values = values
 					c.lastFetch = now
-					c.l.Unlock()
-
 					c.updateIndexes(values)
+					c.l.Unlock()
 
 					c.log.Info("successfully updated cache values", "count", len(values))
 				}
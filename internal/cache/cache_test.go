@@ -0,0 +1,120 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/clock"
+)
+
+func Test_Cache_Stale(t *testing.T) {
+	fake := clock.NewFake(time.Date(2024, 6, 3, 8, 0, 0, 0, time.UTC))
+
+	loaded := make(chan struct{}, 1)
+	c := NewCache("test", time.Hour, LoaderFunc[string](func(context.Context) ([]string, error) {
+		defer func() { loaded <- struct{}{} }()
+
+		return []string{"a", "b"}, nil
+	}))
+	c.clock = fake
+
+	// values that have never been loaded are stale
+	values, stale := c.Get()
+	assert.Empty(t, values)
+	assert.True(t, stale)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(func() {
+		cancel()
+		c.Wait()
+	})
+
+	c.Start(ctx)
+	<-loaded
+
+	require.Eventually(t, func() bool {
+		values, _ := c.Get()
+		return len(values) == 2
+	}, time.Second, time.Millisecond)
+
+	values, stale = c.Get()
+	assert.Equal(t, []string{"a", "b"}, values)
+	assert.False(t, stale)
+
+	fake.Advance(59 * time.Minute)
+	_, stale = c.Get()
+	assert.False(t, stale)
+
+	fake.Advance(2 * time.Minute)
+	_, stale = c.Get()
+	assert.True(t, stale)
+
+	// a clock that is set back does not make values stale
+	fake.Advance(-2 * time.Hour)
+	_, stale = c.Get()
+	assert.False(t, stale)
+}
+
+func Test_Cache_Upsert(t *testing.T) {
+	c := NewCache("test", time.Hour, LoaderFunc[string](func(context.Context) ([]string, error) {
+		return nil, nil
+	}))
+
+	index := CreateIndex(c, func(v string) (string, bool) {
+		return v[:1], true
+	})
+
+	c.Upsert("a1", func(v string) bool { return v[:1] == "a" })
+	c.Upsert("b1", func(v string) bool { return v[:1] == "b" })
+
+	v, ok := index.Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, "a1", v)
+
+	// matching values are replaced
+	c.Upsert("a2", func(v string) bool { return v[:1] == "a" })
+
+	values, stale := c.Get()
+	assert.Equal(t, []string{"a2", "b1"}, values)
+	assert.True(t, stale, "upserted values do not count as a refresh")
+
+	v, _ = index.Get("a")
+	assert.Equal(t, "a2", v)
+}
+
+func Test_Cache_TriggerSync(t *testing.T) {
+	loaded := make(chan struct{}, 4)
+	c := NewCache("test", time.Hour, LoaderFunc[string](func(context.Context) ([]string, error) {
+		loaded <- struct{}{}
+
+		return []string{"a"}, nil
+	}))
+
+	// triggering a cache that has not been started does not block
+	c.TriggerSync()
+	c.TriggerSync()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(func() {
+		cancel()
+		c.Wait()
+	})
+
+	c.Start(ctx)
+
+	// the initial load and a single reload for the pending triggers
+	<-loaded
+	<-loaded
+
+	c.TriggerSync()
+	<-loaded
+
+	select {
+	case <-loaded:
+		t.Fatal("unexpected reload")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,63 @@
+// Package clock abstracts time access so time dependent logic, like cache
+// freshness and eviction, can be tested with a fake clock.
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock returns the current time.
+type Clock interface {
+	Now() time.Time
+	Since(t time.Time) time.Duration
+}
+
+// Real is the system clock. Times returned by Now carry a monotonic clock
+// reading so durations measured with Since are not affected by adjustments
+// of the wall clock. Callers must not strip the monotonic reading, for
+// example with Round(0), from times they pass to Since.
+type Real struct{}
+
+func (Real) Now() time.Time { return time.Now() }
+
+func (Real) Since(t time.Time) time.Duration { return time.Since(t) }
+
+// Fake is a clock that only moves when advanced. It is safe for concurrent
+// use.
+type Fake struct {
+	l   sync.Mutex
+	now time.Time
+}
+
+// NewFake returns a fake clock set to now.
+func NewFake(now time.Time) *Fake {
+	return &Fake{now: now}
+}
+
+func (f *Fake) Now() time.Time {
+	f.l.Lock()
+	defer f.l.Unlock()
+
+	return f.now
+}
+
+func (f *Fake) Since(t time.Time) time.Duration {
+	return f.Now().Sub(t)
+}
+
+// Advance moves the clock forward by d.
+func (f *Fake) Advance(d time.Duration) {
+	f.l.Lock()
+	defer f.l.Unlock()
+
+	f.now = f.now.Add(d)
+}
+
+// Set sets the clock to now, which may be in the past.
+func (f *Fake) Set(now time.Time) {
+	f.l.Lock()
+	defer f.l.Unlock()
+
+	f.now = now
+}
@@ -0,0 +1,31 @@
+package clock
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Fake(t *testing.T) {
+	start := time.Date(2024, 6, 3, 8, 0, 0, 0, time.UTC)
+	c := NewFake(start)
+
+	assert.Equal(t, start, c.Now())
+	assert.Zero(t, c.Since(start))
+
+	c.Advance(time.Minute)
+	assert.Equal(t, start.Add(time.Minute), c.Now())
+	assert.Equal(t, time.Minute, c.Since(start))
+
+	// fake clocks may be set back, like a wall clock that is corrected
+	c.Set(start.Add(-time.Minute))
+	assert.Equal(t, -time.Minute, c.Since(start))
+}
+
+func Test_Real(t *testing.T) {
+	var c Clock = Real{}
+
+	now := c.Now()
+	assert.GreaterOrEqual(t, c.Since(now), time.Duration(0))
+}
@@ -4,12 +4,257 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"net/url"
 	"os"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/tierklinik-dobersberg/cis-cal/internal/cron"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/i18n"
 	"sigs.k8s.io/yaml"
 )
 
+// Default values for the cache and sync intervals. A zero value in the
+// configuration file keeps the respective default.
+const (
+	DefaultProfilesTTL  = 5 * time.Minute
+	DefaultCalendarsTTL = 5 * time.Minute
+	DefaultSyncInterval = time.Minute
+	DefaultMaxBackoff   = 30 * time.Minute
+	DefaultClockSkew    = 5 * time.Minute
+
+	DefaultBackfillWindow = 31 * 24 * time.Hour
+	DefaultColdLoadWindow = 92 * 24 * time.Hour
+
+	DefaultMaxConcurrentPerCalendar = 2
+	DefaultMaxConcurrent            = 8
+
+	DefaultRosterCacheTTL         = time.Minute
+	DefaultRosterFailureThreshold = 3
+	DefaultRosterCooldown         = 30 * time.Second
+
+	DefaultOpenEndDuration = 30 * time.Minute
+
+	DefaultConflictDays = 14
+
+	DefaultSlotLockTTL = 2 * time.Minute
+
+	DefaultCurrentEventsLookahead = 2 * time.Hour
+
+	DefaultMaxEventDuration   = 24 * time.Hour
+	DefaultMaxFutureHorizon   = 10 * 365 * 24 * time.Hour
+	DefaultMaxFullDaySpanDays = 366
+
+	DefaultMaxEvents        = 10000
+	DefaultWarnResponseSize = 1 << 20
+	DefaultCompressMinBytes = 1024
+
+	DefaultWebhookMaxAttempts = 5
+	DefaultWebhookBackoff     = 10 * time.Second
+
+	DefaultBookingExportDays     = 14
+	DefaultBookingExportDebounce = 30 * time.Second
+
+	DefaultWaitingListDebounce = 10 * time.Second
+
+	DefaultDisabledUserField = "disabled"
+)
+
+// Overlay merges the events of the Source calendar into the Target calendar
+// as read-only busy events.
+type Overlay struct {
+	Target string `json:"target"`
+	Source string `json:"source"`
+}
+
+// Prefetch warms the event caches of Calendars for the next Days days
+// whenever Schedule, a five-field cron expression, fires. If Calendars is
+// empty all calendars assigned to users are prefetched.
+type Prefetch struct {
+	Schedule  string   `json:"schedule"`
+	Days      int      `json:"days"`
+	Calendars []string `json:"calendars"`
+}
+
+// RoleRateLimit overwrites the global rate limit for callers with Role.
+type RoleRateLimit struct {
+	Role      string  `json:"role"`
+	PerMinute float64 `json:"perMinute"`
+	Burst     int     `json:"burst"`
+}
+
+// RateLimit limits the number of mutating requests per caller. Rate limiting
+// is disabled if PerMinute is zero. Callers with one of the BypassRoles are
+// never limited.
+type RateLimit struct {
+	PerMinute   float64         `json:"perMinute"`
+	Burst       int             `json:"burst"`
+	Roles       []RoleRateLimit `json:"roles"`
+	BypassRoles []string        `json:"bypassRoles"`
+}
+
+// Webhooks configures the delivery of event changes to external URLs.
+// Webhooks are disabled if no AllowedRoles are configured.
+type Webhooks struct {
+	// AllowedRoles lists the roles that may manage webhook subscriptions.
+	AllowedRoles []string `json:"allowedRoles"`
+	// StoreFile is the path of the JSON file that persists the
+	// subscriptions. Subscriptions are only kept in memory if empty.
+	StoreFile string `json:"storeFile"`
+	// MaxAttempts is the number of delivery attempts before a delivery is
+	// dead-lettered.
+	MaxAttempts int `json:"maxAttempts"`
+	// Backoff is the delay before the first retry, it doubles with every
+	// further attempt.
+	Backoff Duration `json:"backoff"`
+}
+
+// BookingService is a service template of the online booking portal.
+// Slots of Duration are offered every Step, which defaults to Duration,
+// starting at midnight. If Calendars is set the service is only offered in
+// those calendars.
+type BookingService struct {
+	ID        string   `json:"id"`
+	Name      string   `json:"name"`
+	Duration  Duration `json:"duration"`
+	Step      Duration `json:"step"`
+	Calendars []string `json:"calendars"`
+}
+
+// BookingExport configures the export of free slots to the online booking
+// portal. The export is disabled if no Services are configured.
+type BookingExport struct {
+	// Calendars lists the calendars whose free slots are exported. Defaults
+	// to all calendars assigned to users.
+	Calendars []string         `json:"calendars"`
+	Services  []BookingService `json:"services"`
+	// Days is the number of days, starting today, that are exported.
+	Days int `json:"days"`
+	// Schedule is a five-field cron expression at which all days are
+	// exported again. Changes to events re-export the affected days only.
+	Schedule string `json:"schedule"`
+	// Debounce is how long changes to events are collected before the
+	// affected days are exported again.
+	Debounce Duration `json:"debounce"`
+	// PushURL receives the exported document as a POST request. PushToken
+	// is sent as bearer token if set.
+	PushURL   string `json:"pushURL"`
+	PushToken string `json:"pushToken"`
+	// AllowedRoles lists the roles that may fetch the exported document
+	// and trigger an export.
+	AllowedRoles []string `json:"allowedRoles"`
+}
+
+// Notes configures internal event notes that are stored by this service
+// only and never synced to the calendar backend. Notes are disabled if no
+// AllowedRoles are configured.
+type Notes struct {
+	// AllowedRoles lists the roles that may read and set notes.
+	AllowedRoles []string `json:"allowedRoles"`
+	// StoreFile is the path of the JSON file that persists the notes.
+	// Notes are only kept in memory if empty.
+	StoreFile string `json:"storeFile"`
+}
+
+// WaitingList configures the waiting list of customers that want an
+// earlier appointment. The waiting list is disabled if no AllowedRoles are
+// configured.
+type WaitingList struct {
+	// AllowedRoles lists the roles that may manage waiting list entries.
+	AllowedRoles []string `json:"allowedRoles"`
+	// StoreFile is the path of the JSON file that persists the entries.
+	// Entries are only kept in memory if empty.
+	StoreFile string `json:"storeFile"`
+	// Debounce is how long changes to events are collected before freed
+	// slots are matched against the waiting list.
+	Debounce Duration `json:"debounce"`
+}
+
+// Maintenance configures the maintenance mode that rejects all changes to
+// calendars, like during data migrations.
+type Maintenance struct {
+	// AllowedRoles lists the roles that may enable and disable the
+	// maintenance mode. The mode can only be changed if roles are
+	// configured.
+	AllowedRoles []string `json:"allowedRoles"`
+	// StoreFile is the path of the JSON file that persists the maintenance
+	// mode across restarts. It is only kept in memory if empty.
+	StoreFile string `json:"storeFile"`
+}
+
+// CalendarAdmin configures the endpoints that create calendars, assign them
+// to users and change their name and color.
+type CalendarAdmin struct {
+	// AllowedRoles lists the roles that may create, assign and update
+	// calendars. The endpoints are disabled if no roles are configured.
+	AllowedRoles []string `json:"allowedRoles"`
+	// Timezone is the IANA timezone of new calendars. Google uses the
+	// timezone of the account if empty.
+	Timezone string `json:"timezone"`
+	// ShareWith lists the principals new calendars are shared with.
+	ShareWith []CalendarShare `json:"shareWith"`
+}
+
+// CalendarShare is an access rule that is added to new calendars.
+type CalendarShare struct {
+	// Type is the scope of the rule, one of user, group or domain.
+	Type string `json:"type"`
+	// Value is the email address of the user or group, or the domain.
+	Value string `json:"value"`
+	// Role is the access role, one of freeBusyReader, reader, writer or
+	// owner. Defaults to writer.
+	Role string `json:"role"`
+}
+
+// SchoolHoliday is a range of school holidays, like the Semesterferien, in
+// Country. If Regions is set the range only applies to those ISO 3166-2
+// regions, like AT-3. From and To are inclusive dates as YYYY-MM-DD.
+type SchoolHoliday struct {
+	Name      string   `json:"name"`
+	LocalName string   `json:"localName"`
+	Country   string   `json:"country"`
+	Regions   []string `json:"regions"`
+	From      string   `json:"from"`
+	To        string   `json:"to"`
+}
+
+// Buffer is a cleanup time after events. If Tag is set the buffer only
+// applies to events with the tag, if Calendar is set only to events in the
+// calendar.
+type Buffer struct {
+	Tag      string   `json:"tag"`
+	Calendar string   `json:"calendar"`
+	Duration Duration `json:"duration"`
+}
+
+// ColorRule colors events that match all of the configured regular
+// expressions. Summary and Description match the respective event field, Tag
+// matches if any tag of the event matches and Customer matches the customer
+// ID of the event. Color is a google calendar event color ID from "1" to
+// "11".
+type ColorRule struct {
+	Summary     string `json:"summary"`
+	Description string `json:"description"`
+	Tag         string `json:"tag"`
+	Customer    string `json:"customer"`
+	Color       string `json:"color"`
+}
+
+// Channel is a system events are booked through, like an online booking
+// portal. Events created through a channel get Prefix and Suffix added to
+// their summary. Callers with one of the Roles create events through the
+// channel unless they name another one.
+type Channel struct {
+	Name   string   `json:"name"`
+	Prefix string   `json:"prefix"`
+	Suffix string   `json:"suffix"`
+	Roles  []string `json:"roles"`
+}
+
 type Config struct {
 	CredentialsFile  string   `json:"credentialsFile"`
 	TokenFile        string   `json:"tokenFile"`
@@ -19,10 +264,211 @@ type Config struct {
 	AllowedOrigins   []string `json:"allowedOrigins"`
 	ListenAddress    string   `json:"listen"`
 	DefaultCountry   string   `json:"defaultCountry"`
-	FreeSlots        struct {
+	// DefaultLanguage is the language of server-generated strings, like
+	// free-slot summaries, if the request does not accept any supported
+	// language.
+	DefaultLanguage string `json:"defaultLanguage"`
+	// IncludeDisabledUsers keeps the calendars of disabled users, like
+	// former employees, in the AllUsers and AllCalendars sources and the
+	// calendar list. Otherwise they are only included if requested.
+	IncludeDisabledUsers bool `json:"includeDisabledUsers"`
+	// DisabledUserField is the field of the user extra data that marks
+	// disabled users. The IDM does not have a disabled state, so users
+	// are only detected as disabled if this field is set to the boolean
+	// true on them, for example by an admin or the provisioning of former
+	// employees. Defaults to DefaultDisabledUserField.
+	DisabledUserField string     `json:"disabledUserField"`
+	Overlays          []Overlay  `json:"overlays"`
+	Prefetch          []Prefetch `json:"prefetch"`
+	// SchoolHolidays are reported by the HolidayService in addition to
+	// the public holidays.
+	SchoolHolidays []SchoolHoliday `json:"schoolHolidays"`
+	FreeSlots      struct {
 		IgnoreShiftTags []string `json:"ignoreShiftTags"`
 		RosterTypeName  string   `json:"rosterTypeName"`
+		SkipHolidays    bool     `json:"skipHolidays"`
+		HolidayTypes    []string `json:"holidayTypes"`
+		// OpenEndDuration is the duration assumed for events without an end
+		// time when calculating free slots and conflicts.
+		OpenEndDuration Duration `json:"openEndDuration"`
+		// IgnoreEventTags lists event tags that do not count as busy when
+		// calculating free slots.
+		IgnoreEventTags []string `json:"ignoreEventTags"`
+		// Buffers are cleanup times after events that count as busy when
+		// calculating free slots.
+		Buffers []Buffer `json:"buffers"`
 	} `json:"freeSlots"`
+	Validation struct {
+		// RejectCustomerDoubleBooking rejects new or moved events that overlap
+		// with an event of the same customer in another calendar. Otherwise
+		// only a warning is returned.
+		RejectCustomerDoubleBooking bool `json:"rejectCustomerDoubleBooking"`
+		// RejectBufferViolation rejects new or moved events that start
+		// within the buffer time of a previous event. Otherwise only a
+		// warning is returned.
+		RejectBufferViolation bool `json:"rejectBufferViolation"`
+		// MinDuration and MaxEventDuration bound the duration of timed
+		// events. Timed events longer than a day are multi-day events and
+		// are limited by MaxFullDaySpanDays instead.
+		MinDuration      Duration `json:"minDuration"`
+		MaxEventDuration Duration `json:"maxEventDuration"`
+		// MaxFutureHorizon is how far in the future events may start.
+		MaxFutureHorizon Duration `json:"maxFutureHorizon"`
+		// MaxFullDaySpanDays is the number of days full-day and multi-day
+		// events may span.
+		MaxFullDaySpanDays int `json:"maxFullDaySpanDays"`
+		// BypassRoles lists the roles (X-Remote-Role) that may create
+		// events outside of the above limits.
+		BypassRoles []string `json:"bypassRoles"`
+	} `json:"validation"`
+	// ColorRules are applied in order when events are created or updated,
+	// the first matching rule sets the color of the event.
+	ColorRules []ColorRule `json:"colorRules"`
+	// Channels are matched in order against the roles of the caller when
+	// an event is created.
+	Channels  []Channel `json:"channels"`
+	Conflicts struct {
+		// Interval is the interval at which the calendars assigned to
+		// users are checked for overlapping events. The check is disabled
+		// if zero.
+		Interval Duration `json:"interval"`
+		// Days is the number of days, starting today, that are checked.
+		Days int `json:"days"`
+		// AllowedRoles limits the conflicts endpoint to callers with one
+		// of the roles. All authenticated users may see conflicts if empty.
+		AllowedRoles []string `json:"allowedRoles"`
+	} `json:"conflicts"`
+	Print struct {
+		// Template is the path of a html/template used to render the
+		// printable day plan. The built-in template is used if empty.
+		Template string `json:"template"`
+	} `json:"print"`
+	SlotLocks struct {
+		// TTL is the time after which slot locks expire.
+		TTL Duration `json:"ttl"`
+		// AllowedRoles limits creating and releasing slot locks to callers
+		// with one of the roles. All authenticated users may lock slots if
+		// empty.
+		AllowedRoles []string `json:"allowedRoles"`
+	} `json:"slotLocks"`
+	// Ranges configures how relative ListEvents ranges, like this week,
+	// are resolved.
+	Ranges struct {
+		// Timezone is the IANA timezone relative ranges are resolved in.
+		// Defaults to the local timezone of the service.
+		Timezone string `json:"timezone"`
+		// WeekStart is the first day of relative weeks. Defaults to
+		// Monday, ISO weeks always start on Monday.
+		WeekStart string `json:"weekStart"`
+	} `json:"ranges"`
+	CurrentEvents struct {
+		// Lookahead is how far ahead the next upcoming event is searched
+		// for if the request does not specify a lookahead.
+		Lookahead Duration `json:"lookahead"`
+		// AllowedRoles limits the current events to callers with one of
+		// the roles. All authenticated users may see them if empty.
+		AllowedRoles []string `json:"allowedRoles"`
+	} `json:"currentEvents"`
+	WaitingRoom struct {
+		// AllowedRoles limits the waiting room to callers with one of the
+		// roles. All authenticated users may see it if empty.
+		AllowedRoles []string `json:"allowedRoles"`
+	} `json:"waitingRoom"`
+	RateLimit RateLimit `json:"rateLimit"`
+	Export    struct {
+		// AllowedRoles lists the roles that may use the CSV event export.
+		// The export is disabled if no roles are configured.
+		AllowedRoles []string `json:"allowedRoles"`
+	} `json:"export"`
+	Heatmap struct {
+		// AllowedRoles lists the roles that may request the utilization
+		// heatmap. The heatmap is disabled if no roles are configured.
+		AllowedRoles []string `json:"allowedRoles"`
+	} `json:"heatmap"`
+	Backup struct {
+		// AllowedRoles lists the roles that may export calendars as a
+		// backup and import them again. Backups include the details of
+		// private events so these roles should only be given to users
+		// that may see all events. The backup endpoints are disabled if no
+		// roles are configured.
+		AllowedRoles []string `json:"allowedRoles"`
+	} `json:"backup"`
+	BulkDelete struct {
+		// AllowedRoles lists the roles that may delete events matching a
+		// filter in bulk. Bulk deletion is disabled if no roles are
+		// configured.
+		AllowedRoles []string `json:"allowedRoles"`
+	} `json:"bulkDelete"`
+	Webhooks      Webhooks      `json:"webhooks"`
+	BookingExport BookingExport `json:"bookingExport"`
+	Notes         Notes         `json:"notes"`
+	WaitingList   WaitingList   `json:"waitingList"`
+	Maintenance   Maintenance   `json:"maintenance"`
+	CalendarAdmin CalendarAdmin `json:"calendarAdmin"`
+	Debug         struct {
+		// AllowedRoles lists the roles that may dump the state of the
+		// caches. The debug endpoints are disabled if no roles are
+		// configured.
+		AllowedRoles []string `json:"allowedRoles"`
+	} `json:"debug"`
+	Logging struct {
+		// RedactPII replaces event summaries in log lines by a hash.
+		// Defaults to true, redaction may be paused temporarily using the
+		// debug endpoints.
+		RedactPII *bool `json:"redactPII"`
+	} `json:"logging"`
+	Roster struct {
+		CacheTTL         Duration `json:"cacheTTL"`
+		FailureThreshold int      `json:"failureThreshold"`
+		Cooldown         Duration `json:"cooldown"`
+	} `json:"roster"`
+	Cache struct {
+		ProfilesTTL  Duration `json:"profilesTTL"`
+		CalendarsTTL Duration `json:"calendarsTTL"`
+		// EventProtos enables caching the protobuf representation of
+		// unchanged events between ListEvents requests.
+		EventProtos bool `json:"eventProtos"`
+	} `json:"cache"`
+	Limits struct {
+		// MaxEvents is the maximum number of events returned by a single
+		// ListEvents request.
+		MaxEvents int `json:"maxEvents"`
+		// WarnResponseSize is the response size in bytes above which a
+		// warning is logged.
+		WarnResponseSize int `json:"warnResponseSize"`
+		// CompressMinBytes is the minimum response size in bytes before
+		// responses are compressed.
+		CompressMinBytes int `json:"compressMinBytes"`
+	} `json:"limits"`
+	Google struct {
+		SyncInterval     Duration `json:"syncInterval"`
+		MaxBackoff       Duration `json:"maxBackoff"`
+		PrewarmCalendars []string `json:"prewarmCalendars"`
+		// MaxConcurrentPerCalendar limits the number of concurrent upstream
+		// requests per calendar, MaxConcurrent across all calendars.
+		MaxConcurrentPerCalendar int `json:"maxConcurrentPerCalendar"`
+		MaxConcurrent            int `json:"maxConcurrent"`
+		// ClockSkew is how far before local midnight the event caches load
+		// events, so requests that start slightly before midnight, for
+		// example from clients whose clock is behind, are served from the
+		// cache.
+		ClockSkew Duration `json:"clockSkew"`
+		// BackfillWindow is how far before the cached time range events
+		// are loaded into the event caches. Older events are loaded from
+		// google for every request and are not cached.
+		BackfillWindow Duration `json:"backfillWindow"`
+		// ColdLoadWindow is how far after their start searches without an
+		// end are loaded from google while the event cache of a calendar
+		// is not ready yet, or if they start before the backfill window.
+		ColdLoadWindow Duration `json:"coldLoadWindow"`
+		// QuotaWarnPerHour logs a warning once the number of google
+		// calendar API calls within an hour exceeds the value. The
+		// warning is disabled if zero.
+		QuotaWarnPerHour int `json:"quotaWarnPerHour"`
+		// IdempotentDeletes makes deleting an event that no longer
+		// exists upstream succeed instead of failing with NotFound.
+		IdempotentDeletes bool `json:"idempotentDeletes"`
+	} `json:"google"`
 }
 
 // LoadConfig loads the configuration file from cfgPath.
@@ -65,5 +511,412 @@ func LoadConfig(cfgPath string) (Config, error) {
 		cfg.DefaultCountry = "AT"
 	}
 
+	if cfg.DisabledUserField == "" {
+		cfg.DisabledUserField = DefaultDisabledUserField
+	}
+
+	if cfg.DefaultLanguage == "" {
+		cfg.DefaultLanguage = string(i18n.DefaultLanguage)
+	}
+
+	lang, err := i18n.ParseLanguage(cfg.DefaultLanguage)
+	if err != nil {
+		return cfg, fmt.Errorf("invalid value for defaultLanguage: %w", err)
+	}
+	cfg.DefaultLanguage = string(lang)
+
+	for idx := range cfg.SchoolHolidays {
+		h := &cfg.SchoolHolidays[idx]
+
+		if h.Country == "" {
+			h.Country = cfg.DefaultCountry
+		}
+
+		if h.LocalName == "" {
+			h.LocalName = h.Name
+		}
+	}
+
+	if len(cfg.FreeSlots.HolidayTypes) == 0 {
+		cfg.FreeSlots.HolidayTypes = []string{"Public", "Bank"}
+	}
+
+	if cfg.FreeSlots.OpenEndDuration == 0 {
+		cfg.FreeSlots.OpenEndDuration = Duration(DefaultOpenEndDuration)
+	}
+
+	if cfg.Conflicts.Days <= 0 {
+		cfg.Conflicts.Days = DefaultConflictDays
+	}
+
+	if cfg.SlotLocks.TTL == 0 {
+		cfg.SlotLocks.TTL = Duration(DefaultSlotLockTTL)
+	}
+
+	if cfg.Ranges.WeekStart == "" {
+		cfg.Ranges.WeekStart = time.Monday.String()
+	}
+
+	if cfg.CurrentEvents.Lookahead == 0 {
+		cfg.CurrentEvents.Lookahead = Duration(DefaultCurrentEventsLookahead)
+	}
+
+	if cfg.Validation.MaxEventDuration == 0 {
+		cfg.Validation.MaxEventDuration = Duration(DefaultMaxEventDuration)
+	}
+
+	if cfg.Validation.MaxFutureHorizon == 0 {
+		cfg.Validation.MaxFutureHorizon = Duration(DefaultMaxFutureHorizon)
+	}
+
+	if cfg.Validation.MaxFullDaySpanDays == 0 {
+		cfg.Validation.MaxFullDaySpanDays = DefaultMaxFullDaySpanDays
+	}
+
+	if cfg.Cache.ProfilesTTL == 0 {
+		cfg.Cache.ProfilesTTL = Duration(DefaultProfilesTTL)
+	}
+
+	if cfg.Cache.CalendarsTTL == 0 {
+		cfg.Cache.CalendarsTTL = Duration(DefaultCalendarsTTL)
+	}
+
+	if cfg.Google.SyncInterval == 0 {
+		cfg.Google.SyncInterval = Duration(DefaultSyncInterval)
+	}
+
+	if cfg.Google.MaxBackoff == 0 {
+		cfg.Google.MaxBackoff = Duration(DefaultMaxBackoff)
+	}
+
+	if cfg.Google.ClockSkew == 0 {
+		cfg.Google.ClockSkew = Duration(DefaultClockSkew)
+	}
+
+	if cfg.Google.BackfillWindow == 0 {
+		cfg.Google.BackfillWindow = Duration(DefaultBackfillWindow)
+	}
+
+	if cfg.Google.ColdLoadWindow == 0 {
+		cfg.Google.ColdLoadWindow = Duration(DefaultColdLoadWindow)
+	}
+
+	for idx := range cfg.CalendarAdmin.ShareWith {
+		if cfg.CalendarAdmin.ShareWith[idx].Role == "" {
+			cfg.CalendarAdmin.ShareWith[idx].Role = "writer"
+		}
+	}
+
+	if cfg.Google.MaxConcurrentPerCalendar <= 0 {
+		cfg.Google.MaxConcurrentPerCalendar = DefaultMaxConcurrentPerCalendar
+	}
+
+	if cfg.Google.MaxConcurrent <= 0 {
+		cfg.Google.MaxConcurrent = DefaultMaxConcurrent
+	}
+
+	if cfg.Logging.RedactPII == nil {
+		redact := true
+		cfg.Logging.RedactPII = &redact
+	}
+
+	if cfg.Roster.CacheTTL == 0 {
+		cfg.Roster.CacheTTL = Duration(DefaultRosterCacheTTL)
+	}
+
+	if cfg.Roster.FailureThreshold == 0 {
+		cfg.Roster.FailureThreshold = DefaultRosterFailureThreshold
+	}
+
+	if cfg.Roster.Cooldown == 0 {
+		cfg.Roster.Cooldown = Duration(DefaultRosterCooldown)
+	}
+
+	// a burst of zero would reject all requests
+	if cfg.RateLimit.Burst <= 0 {
+		cfg.RateLimit.Burst = 1
+	}
+
+	for idx := range cfg.RateLimit.Roles {
+		if cfg.RateLimit.Roles[idx].Burst <= 0 {
+			cfg.RateLimit.Roles[idx].Burst = 1
+		}
+	}
+
+	if cfg.Limits.MaxEvents == 0 {
+		cfg.Limits.MaxEvents = DefaultMaxEvents
+	}
+
+	if cfg.Limits.WarnResponseSize == 0 {
+		cfg.Limits.WarnResponseSize = DefaultWarnResponseSize
+	}
+
+	if cfg.Limits.CompressMinBytes == 0 {
+		cfg.Limits.CompressMinBytes = DefaultCompressMinBytes
+	}
+
+	if cfg.Webhooks.MaxAttempts == 0 {
+		cfg.Webhooks.MaxAttempts = DefaultWebhookMaxAttempts
+	}
+
+	if cfg.Webhooks.Backoff == 0 {
+		cfg.Webhooks.Backoff = Duration(DefaultWebhookBackoff)
+	}
+
+	if cfg.BookingExport.Days == 0 {
+		cfg.BookingExport.Days = DefaultBookingExportDays
+	}
+
+	if cfg.BookingExport.Debounce == 0 {
+		cfg.BookingExport.Debounce = Duration(DefaultBookingExportDebounce)
+	}
+
+	if cfg.WaitingList.Debounce == 0 {
+		cfg.WaitingList.Debounce = Duration(DefaultWaitingListDebounce)
+	}
+
+	for idx := range cfg.BookingExport.Services {
+		if s := &cfg.BookingExport.Services[idx]; s.Step == 0 {
+			s.Step = s.Duration
+		}
+	}
+
+	if err := cfg.validate(); err != nil {
+		return cfg, err
+	}
+
 	return cfg, nil
 }
+
+// validate ensures the configured intervals are within sane bounds and the
+// overlays are complete.
+func (cfg Config) validate() error {
+	checks := []struct {
+		name     string
+		value    Duration
+		min, max time.Duration
+	}{
+		{"cache.profilesTTL", cfg.Cache.ProfilesTTL, 10 * time.Second, 24 * time.Hour},
+		{"cache.calendarsTTL", cfg.Cache.CalendarsTTL, 10 * time.Second, 24 * time.Hour},
+		{"google.syncInterval", cfg.Google.SyncInterval, 10 * time.Second, time.Hour},
+		{"google.maxBackoff", cfg.Google.MaxBackoff, cfg.Google.SyncInterval.AsDuration(), 24 * time.Hour},
+		{"google.clockSkew", cfg.Google.ClockSkew, time.Second, time.Hour},
+		{"google.backfillWindow", cfg.Google.BackfillWindow, 24 * time.Hour, 366 * 24 * time.Hour},
+		{"google.coldLoadWindow", cfg.Google.ColdLoadWindow, 24 * time.Hour, 366 * 24 * time.Hour},
+		{"roster.cacheTTL", cfg.Roster.CacheTTL, time.Second, time.Hour},
+		{"roster.cooldown", cfg.Roster.Cooldown, time.Second, time.Hour},
+		{"slotLocks.ttl", cfg.SlotLocks.TTL, 5 * time.Second, time.Hour},
+		{"webhooks.backoff", cfg.Webhooks.Backoff, time.Second, time.Hour},
+		{"bookingExport.debounce", cfg.BookingExport.Debounce, time.Second, time.Hour},
+		{"waitingList.debounce", cfg.WaitingList.Debounce, time.Second, time.Hour},
+	}
+
+	for _, c := range checks {
+		if c.value.AsDuration() < c.min || c.value.AsDuration() > c.max {
+			return fmt.Errorf("invalid value for %s: %s must be between %s and %s", c.name, c.value.AsDuration(), c.min, c.max)
+		}
+	}
+
+	if cfg.Google.QuotaWarnPerHour < 0 {
+		return fmt.Errorf("invalid value for google.quotaWarnPerHour: must not be negative")
+	}
+
+	if cfg.Webhooks.MaxAttempts < 1 || cfg.Webhooks.MaxAttempts > 20 {
+		return fmt.Errorf("invalid value for webhooks.maxAttempts: %d must be between 1 and 20", cfg.Webhooks.MaxAttempts)
+	}
+
+	if cfg.Validation.MaxEventDuration < 0 || cfg.Validation.MaxFutureHorizon < 0 || cfg.Validation.MaxFullDaySpanDays < 0 {
+		return fmt.Errorf("invalid value for validation: limits must not be negative")
+	}
+
+	if v := cfg.Validation; v.MinDuration < 0 || v.MinDuration > v.MaxEventDuration {
+		return fmt.Errorf("invalid value for validation.minDuration: %s must be between 0s and validation.maxEventDuration", v.MinDuration.AsDuration())
+	}
+
+	// the conflict check is optional
+	if d := cfg.Conflicts.Interval.AsDuration(); d != 0 && (d < time.Minute || d > 24*time.Hour) {
+		return fmt.Errorf("invalid value for conflicts.interval: %s must be between %s and %s", d, time.Minute, 24*time.Hour)
+	}
+
+	for idx, o := range cfg.Overlays {
+		if o.Target == "" || o.Source == "" {
+			return fmt.Errorf("invalid value for overlays[%d]: target and source are required", idx)
+		}
+
+		if o.Target == o.Source {
+			return fmt.Errorf("invalid value for overlays[%d]: target and source must be different calendars", idx)
+		}
+	}
+
+	for idx, b := range cfg.FreeSlots.Buffers {
+		if b.Tag == "" && b.Calendar == "" {
+			return fmt.Errorf("invalid value for freeSlots.buffers[%d]: tag or calendar is required", idx)
+		}
+
+		if d := b.Duration.AsDuration(); d <= 0 || d > 24*time.Hour {
+			return fmt.Errorf("invalid value for freeSlots.buffers[%d].duration: %s must be between 0s and %s", idx, d, 24*time.Hour)
+		}
+	}
+
+	channels := make(map[string]struct{}, len(cfg.Channels))
+	for idx, c := range cfg.Channels {
+		if c.Name == "" {
+			return fmt.Errorf("invalid value for channels[%d]: name is required", idx)
+		}
+
+		if _, ok := channels[c.Name]; ok {
+			return fmt.Errorf("invalid value for channels[%d]: duplicate channel %q", idx, c.Name)
+		}
+		channels[c.Name] = struct{}{}
+	}
+
+	for idx, r := range cfg.ColorRules {
+		patterns := []struct{ name, value string }{
+			{"summary", r.Summary},
+			{"description", r.Description},
+			{"tag", r.Tag},
+			{"customer", r.Customer},
+		}
+
+		matchers := 0
+		for _, p := range patterns {
+			if p.value == "" {
+				continue
+			}
+
+			if _, err := regexp.Compile(p.value); err != nil {
+				return fmt.Errorf("invalid value for colorRules[%d].%s: %w", idx, p.name, err)
+			}
+			matchers++
+		}
+
+		if matchers == 0 {
+			return fmt.Errorf("invalid value for colorRules[%d]: at least one of summary, description, tag or customer is required", idx)
+		}
+
+		if c, err := strconv.Atoi(r.Color); err != nil || c < 1 || c > 11 {
+			return fmt.Errorf("invalid value for colorRules[%d].color: %q is not a color ID between 1 and 11", idx, r.Color)
+		}
+	}
+
+	for idx, h := range cfg.SchoolHolidays {
+		if h.Name == "" {
+			return fmt.Errorf("invalid value for schoolHolidays[%d]: name is required", idx)
+		}
+
+		from, err := time.Parse("2006-01-02", h.From)
+		if err != nil {
+			return fmt.Errorf("invalid value for schoolHolidays[%d].from: expected YYYY-MM-DD", idx)
+		}
+
+		to, err := time.Parse("2006-01-02", h.To)
+		if err != nil {
+			return fmt.Errorf("invalid value for schoolHolidays[%d].to: expected YYYY-MM-DD", idx)
+		}
+
+		if to.Before(from) || to.Sub(from) > 366*24*time.Hour {
+			return fmt.Errorf("invalid value for schoolHolidays[%d]: to must be within a year after from", idx)
+		}
+	}
+
+	if _, err := time.LoadLocation(cfg.Ranges.Timezone); err != nil {
+		return fmt.Errorf("invalid value for ranges.timezone: %w", err)
+	}
+
+	if _, err := ParseWeekday(cfg.Ranges.WeekStart); err != nil {
+		return fmt.Errorf("invalid value for ranges.weekStart: %w", err)
+	}
+
+	for idx, p := range cfg.Prefetch {
+		if _, err := cron.Parse(p.Schedule); err != nil {
+			return fmt.Errorf("invalid value for prefetch[%d].schedule: %w", idx, err)
+		}
+
+		if p.Days <= 0 {
+			return fmt.Errorf("invalid value for prefetch[%d].days: must be greater than zero", idx)
+		}
+	}
+
+	if err := cfg.BookingExport.validate(); err != nil {
+		return err
+	}
+
+	if err := cfg.CalendarAdmin.validate(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (c CalendarAdmin) validate() error {
+	if _, err := time.LoadLocation(c.Timezone); err != nil {
+		return fmt.Errorf("invalid value for calendarAdmin.timezone: %w", err)
+	}
+
+	for idx, share := range c.ShareWith {
+		if !slices.Contains([]string{"user", "group", "domain"}, share.Type) {
+			return fmt.Errorf("invalid value for calendarAdmin.shareWith[%d].type: %q must be one of user, group or domain", idx, share.Type)
+		}
+
+		if share.Value == "" {
+			return fmt.Errorf("invalid value for calendarAdmin.shareWith[%d].value: value is required", idx)
+		}
+
+		if !slices.Contains([]string{"freeBusyReader", "reader", "writer", "owner"}, share.Role) {
+			return fmt.Errorf("invalid value for calendarAdmin.shareWith[%d].role: %q must be one of freeBusyReader, reader, writer or owner", idx, share.Role)
+		}
+	}
+
+	return nil
+}
+
+func (b BookingExport) validate() error {
+	if b.Days < 1 || b.Days > 90 {
+		return fmt.Errorf("invalid value for bookingExport.days: %d must be between 1 and 90", b.Days)
+	}
+
+	if b.Schedule != "" {
+		if _, err := cron.Parse(b.Schedule); err != nil {
+			return fmt.Errorf("invalid value for bookingExport.schedule: %w", err)
+		}
+	}
+
+	if b.PushURL != "" {
+		if u, err := url.Parse(b.PushURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid value for bookingExport.pushURL: expected an absolute http or https URL")
+		}
+	}
+
+	ids := make(map[string]struct{}, len(b.Services))
+	for idx, s := range b.Services {
+		if s.ID == "" {
+			return fmt.Errorf("invalid value for bookingExport.services[%d]: id is required", idx)
+		}
+
+		if _, ok := ids[s.ID]; ok {
+			return fmt.Errorf("invalid value for bookingExport.services[%d]: duplicate service %q", idx, s.ID)
+		}
+		ids[s.ID] = struct{}{}
+
+		if d := s.Duration.AsDuration(); d < time.Minute || d > 24*time.Hour {
+			return fmt.Errorf("invalid value for bookingExport.services[%d].duration: %s must be between %s and %s", idx, d, time.Minute, 24*time.Hour)
+		}
+
+		if d := s.Step.AsDuration(); d < time.Minute || d > 24*time.Hour {
+			return fmt.Errorf("invalid value for bookingExport.services[%d].step: %s must be between %s and %s", idx, d, time.Minute, 24*time.Hour)
+		}
+	}
+
+	return nil
+}
+
+// ParseWeekday parses the english name of a weekday, like Monday.
+func ParseWeekday(name string) (time.Weekday, error) {
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		if strings.EqualFold(day.String(), name) {
+			return day, nil
+		}
+	}
+
+	return 0, fmt.Errorf("unknown weekday %q", name)
+}
@@ -0,0 +1,312 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "config.yml")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+
+	return path
+}
+
+func Test_LoadConfig_IntervalDefaults(t *testing.T) {
+	cfg, err := LoadConfig(writeConfig(t, "listen: ':9090'\n"))
+	require.NoError(t, err)
+
+	assert.Equal(t, DefaultProfilesTTL, cfg.Cache.ProfilesTTL.AsDuration())
+	assert.Equal(t, DefaultCalendarsTTL, cfg.Cache.CalendarsTTL.AsDuration())
+	assert.Equal(t, DefaultSyncInterval, cfg.Google.SyncInterval.AsDuration())
+	assert.Equal(t, DefaultMaxBackoff, cfg.Google.MaxBackoff.AsDuration())
+	assert.Equal(t, DefaultClockSkew, cfg.Google.ClockSkew.AsDuration())
+	assert.Equal(t, DefaultBackfillWindow, cfg.Google.BackfillWindow.AsDuration())
+	assert.Equal(t, DefaultColdLoadWindow, cfg.Google.ColdLoadWindow.AsDuration())
+	assert.Equal(t, DefaultMaxConcurrentPerCalendar, cfg.Google.MaxConcurrentPerCalendar)
+	assert.Equal(t, DefaultMaxConcurrent, cfg.Google.MaxConcurrent)
+	assert.Equal(t, DefaultMaxEvents, cfg.Limits.MaxEvents)
+	assert.Equal(t, DefaultWarnResponseSize, cfg.Limits.WarnResponseSize)
+	assert.Equal(t, DefaultCompressMinBytes, cfg.Limits.CompressMinBytes)
+	assert.Equal(t, DefaultOpenEndDuration, cfg.FreeSlots.OpenEndDuration.AsDuration())
+	assert.Equal(t, DefaultSlotLockTTL, cfg.SlotLocks.TTL.AsDuration())
+	assert.Equal(t, DefaultCurrentEventsLookahead, cfg.CurrentEvents.Lookahead.AsDuration())
+	assert.Equal(t, DefaultMaxEventDuration, cfg.Validation.MaxEventDuration.AsDuration())
+	assert.Equal(t, DefaultMaxFutureHorizon, cfg.Validation.MaxFutureHorizon.AsDuration())
+	assert.Equal(t, DefaultMaxFullDaySpanDays, cfg.Validation.MaxFullDaySpanDays)
+	assert.Equal(t, DefaultWebhookMaxAttempts, cfg.Webhooks.MaxAttempts)
+	assert.Equal(t, DefaultWebhookBackoff, cfg.Webhooks.Backoff.AsDuration())
+	assert.False(t, cfg.Cache.EventProtos)
+}
+
+func Test_LoadConfig_Intervals(t *testing.T) {
+	cfg, err := LoadConfig(writeConfig(t, `
+cache:
+  profilesTTL: 1m
+  calendarsTTL: 2m
+  eventProtos: true
+google:
+  syncInterval: 30s
+  maxBackoff: 1h
+  clockSkew: 30s
+`))
+	require.NoError(t, err)
+
+	assert.Equal(t, time.Minute, cfg.Cache.ProfilesTTL.AsDuration())
+	assert.Equal(t, 2*time.Minute, cfg.Cache.CalendarsTTL.AsDuration())
+	assert.True(t, cfg.Cache.EventProtos)
+	assert.Equal(t, 30*time.Second, cfg.Google.SyncInterval.AsDuration())
+	assert.Equal(t, time.Hour, cfg.Google.MaxBackoff.AsDuration())
+	assert.Equal(t, 30*time.Second, cfg.Google.ClockSkew.AsDuration())
+}
+
+func Test_LoadConfig_IntervalBounds(t *testing.T) {
+	cases := []string{
+		"cache:\n  profilesTTL: 1s\n",
+		"cache:\n  calendarsTTL: 48h\n",
+		"google:\n  syncInterval: 2h\n",
+		"google:\n  syncInterval: 5m\n  maxBackoff: 1m\n",
+		"google:\n  syncInterval: five minutes\n",
+		"slotLocks:\n  ttl: 1s\n",
+		"google:\n  clockSkew: 2h\n",
+		"google:\n  backfillWindow: 1h\n",
+		"google:\n  coldLoadWindow: 1h\n",
+		"validation:\n  minDuration: 2h\n  maxEventDuration: 1h\n",
+		"validation:\n  maxFullDaySpanDays: -1\n",
+		"webhooks:\n  maxAttempts: 50\n",
+		"webhooks:\n  backoff: 100ms\n",
+	}
+
+	for _, c := range cases {
+		_, err := LoadConfig(writeConfig(t, c))
+		assert.Error(t, err, c)
+	}
+}
+
+func Test_LoadConfig_Prefetch(t *testing.T) {
+	cfg, err := LoadConfig(writeConfig(t, `
+prefetch:
+  - schedule: "0 5 * * 1-5"
+    days: 14
+`))
+	require.NoError(t, err)
+	require.Len(t, cfg.Prefetch, 1)
+	assert.Equal(t, 14, cfg.Prefetch[0].Days)
+
+	cases := []string{
+		"prefetch:\n  - schedule: 'every day'\n    days: 14\n",
+		"prefetch:\n  - schedule: '0 5 * * *'\n",
+	}
+
+	for _, c := range cases {
+		_, err := LoadConfig(writeConfig(t, c))
+		assert.Error(t, err, c)
+	}
+}
+
+func Test_LoadConfig_ColorRules(t *testing.T) {
+	cfg, err := LoadConfig(writeConfig(t, `
+colorRules:
+  - summary: "(?i)\\bOP\\b"
+    color: "11"
+  - customer: ".+"
+    color: "10"
+`))
+	require.NoError(t, err)
+	require.Len(t, cfg.ColorRules, 2)
+	assert.Equal(t, "11", cfg.ColorRules[0].Color)
+
+	cases := []string{
+		"colorRules:\n  - summary: '(OP'\n    color: '11'\n",
+		"colorRules:\n  - color: '11'\n",
+		"colorRules:\n  - tag: surgery\n    color: '12'\n",
+		"colorRules:\n  - tag: surgery\n    color: red\n",
+	}
+
+	for _, c := range cases {
+		_, err := LoadConfig(writeConfig(t, c))
+		assert.Error(t, err, c)
+	}
+}
+
+func Test_LoadConfig_Channels(t *testing.T) {
+	cfg, err := LoadConfig(writeConfig(t, `
+channels:
+  - name: online
+    prefix: "[Online] "
+    roles: [booking-portal]
+`))
+	require.NoError(t, err)
+	require.Len(t, cfg.Channels, 1)
+	assert.Equal(t, "[Online] ", cfg.Channels[0].Prefix)
+
+	cases := []string{
+		"channels:\n  - prefix: '[Online] '\n",
+		"channels:\n  - name: online\n  - name: online\n",
+	}
+
+	for _, c := range cases {
+		_, err := LoadConfig(writeConfig(t, c))
+		assert.Error(t, err, c)
+	}
+}
+
+func Test_LoadConfig_Buffers(t *testing.T) {
+	cfg, err := LoadConfig(writeConfig(t, `
+freeSlots:
+  buffers:
+    - tag: surgery
+      duration: 10m
+`))
+	require.NoError(t, err)
+	require.Len(t, cfg.FreeSlots.Buffers, 1)
+	assert.Equal(t, 10*time.Minute, cfg.FreeSlots.Buffers[0].Duration.AsDuration())
+
+	cases := []string{
+		"freeSlots:\n  buffers:\n    - duration: 10m\n",
+		"freeSlots:\n  buffers:\n    - tag: surgery\n",
+		"freeSlots:\n  buffers:\n    - calendar: vet-1\n      duration: 25h\n",
+	}
+
+	for _, c := range cases {
+		_, err := LoadConfig(writeConfig(t, c))
+		assert.Error(t, err, c)
+	}
+}
+
+func Test_LoadConfig_DefaultLanguage(t *testing.T) {
+	cfg, err := LoadConfig(writeConfig(t, "listen: ':8080'\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "de", cfg.DefaultLanguage)
+
+	cfg, err = LoadConfig(writeConfig(t, "defaultLanguage: en-GB\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "en", cfg.DefaultLanguage)
+
+	_, err = LoadConfig(writeConfig(t, "defaultLanguage: fr\n"))
+	assert.Error(t, err)
+}
+
+func Test_LoadConfig_DisabledUserField(t *testing.T) {
+	cfg, err := LoadConfig(writeConfig(t, "listen: ':8080'\n"))
+	require.NoError(t, err)
+	assert.Equal(t, DefaultDisabledUserField, cfg.DisabledUserField)
+
+	cfg, err = LoadConfig(writeConfig(t, "disabledUserField: formerEmployee\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "formerEmployee", cfg.DisabledUserField)
+}
+
+func Test_LoadConfig_SchoolHolidays(t *testing.T) {
+	cfg, err := LoadConfig(writeConfig(t, `
+defaultCountry: AT
+schoolHolidays:
+  - name: Semesterferien
+    regions: [AT-3, AT-9]
+    from: "2025-02-03"
+    to: "2025-02-08"
+`))
+	require.NoError(t, err)
+	require.Len(t, cfg.SchoolHolidays, 1)
+	assert.Equal(t, "AT", cfg.SchoolHolidays[0].Country)
+	assert.Equal(t, "Semesterferien", cfg.SchoolHolidays[0].LocalName)
+
+	cases := []string{
+		"schoolHolidays:\n  - from: '2025-02-03'\n    to: '2025-02-08'\n",
+		"schoolHolidays:\n  - name: x\n    from: '03.02.2025'\n    to: '2025-02-08'\n",
+		"schoolHolidays:\n  - name: x\n    from: '2025-02-08'\n    to: '2025-02-03'\n",
+		"schoolHolidays:\n  - name: x\n    from: '2025-02-03'\n    to: '2026-03-01'\n",
+	}
+
+	for _, c := range cases {
+		_, err := LoadConfig(writeConfig(t, c))
+		assert.Error(t, err, c)
+	}
+}
+
+func Test_LoadConfig_RedactPII(t *testing.T) {
+	cfg, err := LoadConfig(writeConfig(t, "listen: ':9090'\n"))
+	require.NoError(t, err)
+	require.NotNil(t, cfg.Logging.RedactPII)
+	assert.True(t, *cfg.Logging.RedactPII)
+
+	cfg, err = LoadConfig(writeConfig(t, "logging:\n  redactPII: false\n"))
+	require.NoError(t, err)
+	require.NotNil(t, cfg.Logging.RedactPII)
+	assert.False(t, *cfg.Logging.RedactPII)
+}
+
+func Test_LoadConfig_Ranges(t *testing.T) {
+	cfg, err := LoadConfig(writeConfig(t, "listen: ':9090'\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "Monday", cfg.Ranges.WeekStart)
+
+	cfg, err = LoadConfig(writeConfig(t, "ranges:\n  timezone: Europe/Vienna\n  weekStart: sunday\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "Europe/Vienna", cfg.Ranges.Timezone)
+
+	_, err = LoadConfig(writeConfig(t, "ranges:\n  timezone: Europe/Nowhere\n"))
+	assert.ErrorContains(t, err, "ranges.timezone")
+
+	_, err = LoadConfig(writeConfig(t, "ranges:\n  weekStart: Caturday\n"))
+	assert.ErrorContains(t, err, "ranges.weekStart")
+}
+
+func Test_LoadConfig_BookingExport(t *testing.T) {
+	cfg, err := LoadConfig(writeConfig(t, "bookingExport:\n  services:\n    - id: checkup\n      duration: 30m\n"))
+	require.NoError(t, err)
+	assert.Equal(t, DefaultBookingExportDays, cfg.BookingExport.Days)
+	assert.Equal(t, Duration(DefaultBookingExportDebounce), cfg.BookingExport.Debounce)
+	assert.Equal(t, Duration(30*time.Minute), cfg.BookingExport.Services[0].Step)
+
+	cases := map[string]string{
+		"bookingExport:\n  days: 120\n":                                                                    "bookingExport.days",
+		"bookingExport:\n  schedule: 'every day'\n":                                                        "bookingExport.schedule",
+		"bookingExport:\n  pushURL: 'portal.local/slots'\n":                                                "bookingExport.pushURL",
+		"bookingExport:\n  services:\n    - duration: 30m\n":                                               "bookingExport.services[0]",
+		"bookingExport:\n  services:\n    - id: checkup\n      duration: 0s\n":                             "bookingExport.services[0].duration",
+		"bookingExport:\n  services:\n    - id: a\n      duration: 30m\n    - id: a\n      duration: 1h\n": "duplicate service",
+	}
+
+	for content, expected := range cases {
+		_, err := LoadConfig(writeConfig(t, content))
+		assert.ErrorContains(t, err, expected, content)
+	}
+}
+
+func Test_LoadConfig_CalendarAdmin(t *testing.T) {
+	cfg, err := LoadConfig(writeConfig(t, `
+calendarAdmin:
+  allowedRoles: [admin]
+  timezone: Europe/Vienna
+  shareWith:
+    - type: group
+      value: reception@example.com
+    - type: user
+      value: office@example.com
+      role: owner
+`))
+	require.NoError(t, err)
+	require.Len(t, cfg.CalendarAdmin.ShareWith, 2)
+	assert.Equal(t, "writer", cfg.CalendarAdmin.ShareWith[0].Role)
+	assert.Equal(t, "owner", cfg.CalendarAdmin.ShareWith[1].Role)
+
+	cases := []string{
+		"calendarAdmin:\n  timezone: Europe/Nowhere\n",
+		"calendarAdmin:\n  shareWith:\n    - type: team\n      value: x@example.com\n",
+		"calendarAdmin:\n  shareWith:\n    - type: user\n",
+		"calendarAdmin:\n  shareWith:\n    - type: user\n      value: x@example.com\n      role: admin\n",
+	}
+
+	for _, c := range cases {
+		_, err := LoadConfig(writeConfig(t, c))
+		assert.Error(t, err, c)
+	}
+}
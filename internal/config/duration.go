@@ -0,0 +1,45 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Duration is a time.Duration that can be unmarshaled from JSON (and YAML)
+// either as a duration string like "5m" or as a number of nanoseconds.
+type Duration time.Duration
+
+// AsDuration returns d as a time.Duration.
+func (d Duration) AsDuration() time.Duration {
+	return time.Duration(d)
+}
+
+// MarshalJSON implements json.Marshaler.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (d *Duration) UnmarshalJSON(blob []byte) error {
+	var v any
+	if err := json.Unmarshal(blob, &v); err != nil {
+		return err
+	}
+
+	switch value := v.(type) {
+	case float64:
+		*d = Duration(value)
+	case string:
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q: %w", value, err)
+		}
+
+		*d = Duration(parsed)
+	default:
+		return fmt.Errorf("invalid duration value %s", string(blob))
+	}
+
+	return nil
+}
@@ -0,0 +1,160 @@
+// Package cors wraps HTTP handlers with CORS support. It mirrors
+// github.com/tierklinik-dobersberg/apis/pkg/cors but supports glob patterns
+// in the allowed origins.
+package cors
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/rs/cors"
+)
+
+type Config struct {
+	// AllowedOrigins is a list of allowed origins. Each origin may contain
+	// any number of * wildcards which match host name characters only, like
+	// https://*.preview.example.com. A single * allows all origins.
+	AllowedOrigins   []string
+	AllowCredentials bool
+	Debug            bool
+}
+
+// OriginMatcher matches request origins against a list of glob patterns.
+type OriginMatcher struct {
+	allowAll bool
+	patterns []*regexp.Regexp
+}
+
+// NewOriginMatcher compiles the origin patterns.
+func NewOriginMatcher(origins []string) (*OriginMatcher, error) {
+	m := new(OriginMatcher)
+
+	for _, origin := range origins {
+		origin = strings.TrimSpace(origin)
+
+		if origin == "*" {
+			m.allowAll = true
+			continue
+		}
+
+		if origin == "" || strings.Contains(origin, "**") {
+			return nil, fmt.Errorf("invalid origin pattern %q", origin)
+		}
+
+		parts := strings.Split(origin, "*")
+		for idx, p := range parts {
+			parts[idx] = regexp.QuoteMeta(p)
+		}
+
+		re, err := regexp.Compile("(?i)^" + strings.Join(parts, "[a-z0-9.-]*") + "$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid origin pattern %q: %w", origin, err)
+		}
+
+		m.patterns = append(m.patterns, re)
+	}
+
+	return m, nil
+}
+
+// Allowed reports whether origin matches one of the patterns.
+func (m *OriginMatcher) Allowed(origin string) bool {
+	if m.allowAll {
+		return true
+	}
+
+	for _, re := range m.patterns {
+		if re.MatchString(origin) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Wrap wraps next with a CORS handler. An error is returned if one of the
+// allowed origins is not a valid pattern.
+func Wrap(cfg Config, next http.Handler) (http.Handler, error) {
+	matcher, err := NewOriginMatcher(cfg.AllowedOrigins)
+	if err != nil {
+		return nil, err
+	}
+
+	c := cors.New(cors.Options{
+		AllowOriginFunc:  matcher.Allowed,
+		AllowCredentials: cfg.AllowCredentials,
+		AllowedMethods: []string{
+			http.MethodGet,
+			http.MethodPost,
+			http.MethodDelete, // Releasing slot locks
+			http.MethodPut,    // Setting event notes
+		},
+		AllowedHeaders: []string{
+			"Accept-Encoding",
+			"Content-Encoding",
+			"Content-Type",
+			"Connect-Protocol-Version",
+			"Connect-Timeout-Ms",
+			"Connect-Accept-Encoding",   // Unused in web browsers, but added for future-proofing
+			"Connect-Content-Encoding",  // Unused in web browsers, but added for future-proofing
+			"Grpc-Timeout",              // Used for gRPC-web
+			"X-Grpc-Web",                // Used for gRPC-web
+			"X-User-Agent",              // Used for gRPC-web
+			"X-Differential",            // Differential ListEvents responses
+			"X-Calendar-If-None-Match",  // Differential ListEvents responses
+			"X-Event-Status",            // ListEvents status filter
+			"X-Move-Start",              // MoveEvent time changes
+			"X-Move-End",                // MoveEvent time changes
+			"If-None-Match",             // Waiting room polling
+			"X-Include-Shift-Bounds",    // ListEvents shift bounds
+			"X-Exclude-Overlays",        // ListEvents overlay filter
+			"X-Writable-Only",           // ListCalendars writable filter
+			"X-Exclude-Calendar-Id",     // ListEvents calendar exclusions
+			"X-Exclude-User-Id",         // ListEvents calendar exclusions
+			"X-Event-Tag",               // CreateEvent tags
+			"X-Exclude-Absences",        // ListEvents absence filter
+			"X-Allow-Partial",           // Partially failed ListEvents requests
+			"X-Description-Format",      // Event description formats
+			"X-Diagnostics",             // ListEvents diagnostics
+			"X-Slot-Lock",               // CreateEvent slot locks
+			"X-Event-Channel",           // CreateEvent booking channels
+			"X-Allow-Missing",           // DeleteEvent of missing events
+			"X-Request-Timeout",         // Per-request deadlines
+			"X-Event-Visibility",        // Private events
+			"X-Include-Holidays",        // ListEvents holidays
+			"X-Holiday-Region",          // Regional and school holidays
+			"X-Include-School-Holidays", // Regional and school holidays
+			"X-Include-Disabled-Users",  // Calendars of disabled users
+			"X-Relative-Range",          // Relative ListEvents ranges
+			"X-Full-Day",                // UpdateEvent full-day changes
+			"X-Event-Query",             // ListEvents free-text search
+			"X-Event-Resource",          // ListEvents resource filter
+			"X-Work-Day-Breakdown",      // NumberOfWorkDays breakdown
+		},
+		ExposedHeaders: []string{
+			"Content-Encoding",            // Unused in web browsers, but added for future-proofing
+			"Connect-Content-Encoding",    // Unused in web browsers, but added for future-proofing
+			"Grpc-Status",                 // Required for gRPC-web
+			"Grpc-Message",                // Required for gRPC-web
+			"Warning",                     // Customer double-booking warnings
+			"Retry-After",                 // Rate limiting
+			"X-Calendar-Error",            // Partially failed ListEvents requests
+			"X-Query-Diagnostics",         // ListEvents diagnostics
+			"X-Calendar-ETag",             // Differential ListEvents responses
+			"X-Not-Modified-Calendar",     // Differential ListEvents responses
+			"X-Event-Status-Result",       // Appointment status of ListEvents results
+			"ETag",                        // Waiting room polling
+			"X-Deleted-Event",             // Undoing DeleteEvent
+			"X-Event-Note",                // Event notes
+			"X-Unresolved-Source",         // Unresolved ListEvents sources
+			"X-Disabled-Calendar",         // Calendars of disabled users
+			"X-Work-Day-Breakdown-Result", // NumberOfWorkDays breakdown
+			"X-Events-Truncated",          // Open-ended ListEvents on a cold cache
+		},
+		Debug: cfg.Debug,
+	})
+
+	return c.Handler(next), nil
+}
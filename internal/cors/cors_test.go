@@ -0,0 +1,71 @@
+package cors
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_OriginMatcher(t *testing.T) {
+	m, err := NewOriginMatcher([]string{"https://*.preview.example.com", "https://app.example.com"})
+	require.NoError(t, err)
+
+	cases := map[string]bool{
+		"https://app.example.com":                  true,
+		"https://APP.example.com":                  true,
+		"https://pr-12.preview.example.com":        true,
+		"https://a.b.preview.example.com":          true,
+		"https://preview.example.com":              false,
+		"http://pr-12.preview.example.com":         false,
+		"https://evil.com/.preview.example.com":    false,
+		"https://evil.com?.preview.example.com":    false,
+		"https://pr-12.preview.example.com.evil":   false,
+		"https://app.example.com:8443":             false,
+		"https://other.example.com":                false,
+		"https://pr-12.preview.example.com:443/x":  false,
+		"https://app.example.com.preview.evil.com": false,
+	}
+
+	for origin, expected := range cases {
+		assert.Equal(t, expected, m.Allowed(origin), origin)
+	}
+
+	_, err = NewOriginMatcher([]string{"https://**.example.com"})
+	assert.Error(t, err)
+
+	all, err := NewOriginMatcher([]string{"*"})
+	require.NoError(t, err)
+	assert.True(t, all.Allowed("https://anything.example.com"))
+}
+
+func Test_Wrap_Preflight(t *testing.T) {
+	handler, err := Wrap(Config{
+		AllowedOrigins:   []string{"https://*.preview.example.com"},
+		AllowCredentials: true,
+	}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	require.NoError(t, err)
+
+	preflight := func(origin string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodOptions, "/tkd.calendar.v1.CalendarService/ListEvents", nil)
+		req.Header.Set("Origin", origin)
+		req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+		req.Header.Set("Access-Control-Request-Headers", "connect-protocol-version,content-type")
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		return rec
+	}
+
+	rec := preflight("https://pr-12.preview.example.com")
+	assert.Equal(t, "https://pr-12.preview.example.com", rec.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "true", rec.Header().Get("Access-Control-Allow-Credentials"))
+
+	rec = preflight("https://evil.example.com")
+	assert.Empty(t, rec.Header().Get("Access-Control-Allow-Origin"))
+}
@@ -0,0 +1,56 @@
+package cron
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Parse_Invalid(t *testing.T) {
+	cases := []string{
+		"",
+		"* * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"a * * * *",
+	}
+
+	for _, c := range cases {
+		_, err := Parse(c)
+		assert.Error(t, err, c)
+	}
+}
+
+func Test_Next(t *testing.T) {
+	// 2024-06-03 is a monday
+	from := time.Date(2024, time.June, 3, 8, 0, 0, 0, time.UTC)
+
+	cases := []struct {
+		expr     string
+		expected time.Time
+	}{
+		{"* * * * *", time.Date(2024, time.June, 3, 8, 1, 0, 0, time.UTC)},
+		{"30 7 * * 1-5", time.Date(2024, time.June, 4, 7, 30, 0, 0, time.UTC)},
+		{"*/15 8-18 * * *", time.Date(2024, time.June, 3, 8, 15, 0, 0, time.UTC)},
+		{"0 3 * * 0", time.Date(2024, time.June, 9, 3, 0, 0, 0, time.UTC)},
+		{"0 3 * * 7", time.Date(2024, time.June, 9, 3, 0, 0, 0, time.UTC)},
+		{"0 0 1 1 *", time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 12 29 2 *", time.Date(2028, time.February, 29, 12, 0, 0, 0, time.UTC)},
+		// day-of-month or day-of-week
+		{"0 6 15 * 6", time.Date(2024, time.June, 8, 6, 0, 0, 0, time.UTC)},
+	}
+
+	for _, c := range cases {
+		s, err := Parse(c.expr)
+		require.NoError(t, err, c.expr)
+
+		assert.Equal(t, c.expected, s.Next(from), c.expr)
+	}
+}
@@ -0,0 +1,63 @@
+package holidays
+
+import (
+	"context"
+	"time"
+)
+
+// Result is the result of checking a single date for public holidays.
+type Result struct {
+	Date time.Time
+
+	// Holiday is set if Date is a public holiday.
+	Holiday *PublicHoliday
+
+	// Err is set if the holidays of the year could not be loaded. In that
+	// case it's unknown whether Date is a holiday.
+	Err error
+}
+
+// IsHoliday reports whether the date is known to be a public holiday.
+func (r Result) IsHoliday() bool {
+	return r.Err == nil && r.Holiday != nil
+}
+
+// Check checks all dates for public holidays in country. The holidays of each
+// year are only requested once from getter and a failure to load the holidays
+// of one year only affects the dates of that year.
+func Check(ctx context.Context, getter Getter, country string, dates []time.Time) []Result {
+	type yearResult struct {
+		holidays []PublicHoliday
+		err      error
+	}
+
+	years := make(map[int]yearResult)
+	results := make([]Result, len(dates))
+
+	for idx, d := range dates {
+		yr, ok := years[d.Year()]
+		if !ok {
+			yr.holidays, yr.err = getter.Get(ctx, country, d.Year())
+			years[d.Year()] = yr
+		}
+
+		results[idx] = Result{
+			Date: d,
+			Err:  yr.err,
+		}
+
+		if yr.err != nil {
+			continue
+		}
+
+		for _, p := range yr.holidays {
+			if p.Is(d) {
+				results[idx].Holiday = &p
+
+				break
+			}
+		}
+	}
+
+	return results
+}
@@ -0,0 +1,64 @@
+package holidays
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// failingYear wraps a getter and fails for a single year.
+type failingYear struct {
+	Getter
+
+	year  int
+	calls map[int]int
+}
+
+func (f *failingYear) Get(ctx context.Context, country string, year int) ([]PublicHoliday, error) {
+	f.calls[year]++
+
+	if year == f.year {
+		return nil, errors.New("upstream failure")
+	}
+
+	return f.Getter.Get(ctx, country, year)
+}
+
+func Test_Check(t *testing.T) {
+	getter := &failingYear{
+		Getter: NewFake(
+			PublicHoliday{Date: "2024-12-25", CountryCode: "AT", LocalName: "Christtag"},
+			PublicHoliday{Date: "2024-12-26", CountryCode: "AT", LocalName: "Stefanitag"},
+		),
+		year:  2025,
+		calls: make(map[int]int),
+	}
+
+	var dates []time.Time
+	for d := time.Date(2024, time.December, 24, 0, 0, 0, 0, time.UTC); d.Year() < 2025 || d.Day() <= 2; d = d.AddDate(0, 0, 1) {
+		dates = append(dates, d)
+	}
+
+	results := Check(context.Background(), getter, "AT", dates)
+	require.Len(t, results, len(dates))
+
+	var holidays []string
+	for _, r := range results {
+		if r.IsHoliday() {
+			holidays = append(holidays, r.Holiday.LocalName)
+		}
+
+		if r.Date.Year() == 2025 {
+			assert.Error(t, r.Err)
+		} else {
+			assert.NoError(t, r.Err)
+		}
+	}
+
+	assert.Equal(t, []string{"Christtag", "Stefanitag"}, holidays)
+	assert.Equal(t, map[int]int{2024: 1, 2025: 1}, getter.calls)
+}
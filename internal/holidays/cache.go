@@ -1,4 +1,4 @@
-package services
+package holidays
 
 import (
 	"context"
@@ -18,8 +18,8 @@ func apiURL(country string, year int) string {
 	return fmt.Sprintf(apiHostFormat, year, country)
 }
 
-// HolidayGetter allows to retrieve holidays.
-type HolidayGetter interface {
+// Getter allows to retrieve holidays.
+type Getter interface {
 	// Get returns a list of public holidays for the given
 	// country and year.
 	Get(ctx context.Context, country string, year int) ([]PublicHoliday, error)
@@ -47,10 +47,10 @@ func (p *PublicHoliday) Is(d time.Time) bool {
 	return fmt.Sprintf("%d-%02d-%02d", d.Year(), d.Month(), d.Day()) == p.Date
 }
 
-// LoadHolidays loads all public holidays for the given country and year
+// Load loads all public holidays for the given country and year
 // from the Nager Holiday API. Users should cache the response as it won't
 // change for the given year anyway.
-func LoadHolidays(ctx context.Context, country string, year int) ([]PublicHoliday, error) {
+func Load(ctx context.Context, country string, year int) ([]PublicHoliday, error) {
 	req, err := http.NewRequestWithContext(ctx, "GET", apiURL(country, year), nil)
 	if err != nil {
 		return nil, err
@@ -81,18 +81,18 @@ type cacheEntry struct {
 	Loaded         time.Time
 }
 
-// HolidayCache can load holidays for countries and supports
+// Cache can load holidays for countries and supports
 // caching the results.
-type HolidayCache struct {
+type Cache struct {
 	call singleflight.Group
 
 	rw    sync.RWMutex
 	cache map[string]*cacheEntry
 }
 
-// NewHolidayCache returns a new holiday cache.
-func NewHolidayCache() *HolidayCache {
-	return &HolidayCache{
+// NewCache returns a new holiday cache.
+func NewCache() *Cache {
+	return &Cache{
 		cache: make(map[string]*cacheEntry),
 	}
 }
@@ -100,7 +100,7 @@ func NewHolidayCache() *HolidayCache {
 // Get returns a list of public holidays for the given two-letter ISO country code
 // in the given year. If the holidays have already been loaded they are served from
 // cache.
-func (cache *HolidayCache) Get(ctx context.Context, country string, year int) ([]PublicHoliday, error) {
+func (cache *Cache) Get(ctx context.Context, country string, year int) ([]PublicHoliday, error) {
 	log := log.L(ctx)
 	cache.rw.RLock()
 
@@ -132,7 +132,7 @@ func (cache *HolidayCache) Get(ctx context.Context, country string, year int) ([
 }
 
 // IsHoliday returns true if d is a public holiday in country.
-func (cache *HolidayCache) IsHoliday(ctx context.Context, country string, d time.Time) (bool, *PublicHoliday, error) {
+func (cache *Cache) IsHoliday(ctx context.Context, country string, d time.Time) (bool, *PublicHoliday, error) {
 	holidays, err := cache.Get(ctx, country, d.Year())
 	if err != nil {
 		return false, nil, err
@@ -149,11 +149,11 @@ func (cache *HolidayCache) IsHoliday(ctx context.Context, country string, d time
 
 // load loads the public holidays for country and year and makes sure no more than one HTTP
 // request is executed for each combination at any time.
-func (cache *HolidayCache) load(country string, year int) (*cacheEntry, error) {
+func (cache *Cache) load(country string, year int) (*cacheEntry, error) {
 	key := fmt.Sprintf("%s-%d", country, year)
 
 	result, err, _ := cache.call.Do(key, func() (interface{}, error) {
-		return LoadHolidays(context.Background(), country, year)
+		return Load(context.Background(), country, year)
 	})
 
 	if err != nil {
@@ -0,0 +1,76 @@
+package holidays
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+)
+
+// Fake is a deterministic, in-memory Getter that serves a fixed set of
+// holidays. It is meant to be used in tests.
+type Fake struct {
+	holidays map[string][]PublicHoliday
+}
+
+// NewFake returns a new fake getter for the given holidays. Holidays are
+// grouped by their country code and year.
+func NewFake(holidays ...PublicHoliday) *Fake {
+	f := &Fake{
+		holidays: make(map[string][]PublicHoliday),
+	}
+
+	for _, p := range holidays {
+		d, err := time.Parse("2006-01-02", p.Date)
+		if err != nil {
+			continue
+		}
+
+		key := fmt.Sprintf("%s-%d", p.CountryCode, d.Year())
+		f.holidays[key] = append(f.holidays[key], p)
+	}
+
+	for _, list := range f.holidays {
+		sort.SliceStable(list, func(i, j int) bool {
+			return list[i].Date < list[j].Date
+		})
+	}
+
+	return f
+}
+
+// NewFakeFromFile returns a new fake getter seeded from a JSON fixture in the
+// format returned by the Nager Holiday API.
+func NewFakeFromFile(path string) (*Fake, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var holidays []PublicHoliday
+	if err := json.Unmarshal(content, &holidays); err != nil {
+		return nil, fmt.Errorf("failed to parse holiday fixture %s: %w", path, err)
+	}
+
+	return NewFake(holidays...), nil
+}
+
+// Get implements Getter.
+func (f *Fake) Get(_ context.Context, country string, year int) ([]PublicHoliday, error) {
+	return f.holidays[fmt.Sprintf("%s-%d", country, year)], nil
+}
+
+// IsHoliday implements Getter.
+func (f *Fake) IsHoliday(ctx context.Context, country string, d time.Time) (bool, *PublicHoliday, error) {
+	holidays, _ := f.Get(ctx, country, d.Year())
+
+	for _, p := range holidays {
+		if p.Is(d) {
+			return true, &p, nil
+		}
+	}
+
+	return false, nil, nil
+}
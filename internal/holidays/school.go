@@ -0,0 +1,131 @@
+package holidays
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/tierklinik-dobersberg/cis-cal/internal/config"
+)
+
+// SchoolHolidayType is the type of school holidays, like the types returned
+// by the Nager Holiday API.
+const SchoolHolidayType = "School"
+
+type schoolRange struct {
+	config.SchoolHoliday
+
+	from time.Time
+	to   time.Time
+}
+
+// SchoolHolidays serves the configured school-holiday ranges. The holiday
+// APIs only know single-day holidays so each day of a range is reported as a
+// separate holiday of type School. A nil SchoolHolidays does not have any
+// school holidays.
+type SchoolHolidays struct {
+	ranges []schoolRange
+}
+
+// NewSchoolHolidays returns the school holidays for ranges.
+func NewSchoolHolidays(ranges []config.SchoolHoliday) (*SchoolHolidays, error) {
+	s := &SchoolHolidays{
+		ranges: make([]schoolRange, len(ranges)),
+	}
+
+	for idx, r := range ranges {
+		from, err := time.Parse("2006-01-02", r.From)
+		if err != nil {
+			return nil, fmt.Errorf("invalid start of school holidays %q: %w", r.Name, err)
+		}
+
+		to, err := time.Parse("2006-01-02", r.To)
+		if err != nil {
+			return nil, fmt.Errorf("invalid end of school holidays %q: %w", r.Name, err)
+		}
+
+		s.ranges[idx] = schoolRange{
+			SchoolHoliday: r,
+			from:          from,
+			to:            to,
+		}
+	}
+
+	return s, nil
+}
+
+// applies reports whether r applies to country and region. Ranges without
+// regions apply to the whole country, all ranges apply if region is empty.
+func (r schoolRange) applies(country, region string) bool {
+	if r.Country != country {
+		return false
+	}
+
+	return region == "" || len(r.Regions) == 0 || slices.Contains(r.Regions, region)
+}
+
+// day returns the school holiday of r on d.
+func (r schoolRange) day(d time.Time) PublicHoliday {
+	return PublicHoliday{
+		Date:        d.Format("2006-01-02"),
+		LocalName:   r.LocalName,
+		Name:        r.Name,
+		CountryCode: r.Country,
+		Global:      len(r.Regions) == 0,
+		Types:       []string{SchoolHolidayType},
+	}
+}
+
+// Get returns one holiday per day of the school holidays in country and
+// year. If region is set only the ranges of the region are considered.
+func (s *SchoolHolidays) Get(country, region string, year int) []PublicHoliday {
+	if s == nil {
+		return nil
+	}
+
+	var result []PublicHoliday
+
+	for _, r := range s.ranges {
+		if !r.applies(country, region) {
+			continue
+		}
+
+		for d := r.from; !d.After(r.to); d = d.AddDate(0, 0, 1) {
+			if d.Year() == year {
+				result = append(result, r.day(d))
+			}
+		}
+	}
+
+	slices.SortStableFunc(result, func(a, b PublicHoliday) int {
+		if a.Date < b.Date {
+			return -1
+		}
+
+		if a.Date > b.Date {
+			return 1
+		}
+
+		return 0
+	})
+
+	return result
+}
+
+// On returns the school holiday in country and region on d, if any.
+func (s *SchoolHolidays) On(country, region string, d time.Time) (*PublicHoliday, bool) {
+	if s == nil {
+		return nil, false
+	}
+
+	day := time.Date(d.Year(), d.Month(), d.Day(), 0, 0, 0, 0, time.UTC)
+
+	for _, r := range s.ranges {
+		if r.applies(country, region) && !day.Before(r.from) && !day.After(r.to) {
+			h := r.day(day)
+			return &h, true
+		}
+	}
+
+	return nil, false
+}
@@ -0,0 +1,57 @@
+package holidays
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/config"
+)
+
+func Test_SchoolHolidays(t *testing.T) {
+	school, err := NewSchoolHolidays([]config.SchoolHoliday{
+		{Name: "Christmas holidays", LocalName: "Weihnachtsferien", Country: "AT", From: "2024-12-30", To: "2025-01-03"},
+		{Name: "Semester holidays", LocalName: "Semesterferien", Country: "AT", Regions: []string{"AT-3", "AT-9"}, From: "2025-02-03", To: "2025-02-04"},
+		{Name: "Semester holidays", LocalName: "Semesterferien", Country: "AT", Regions: []string{"AT-4"}, From: "2025-02-10", To: "2025-02-11"},
+	})
+	require.NoError(t, err)
+
+	dates := func(list []PublicHoliday) []string {
+		var result []string
+		for _, h := range list {
+			result = append(result, h.Date)
+		}
+
+		return result
+	}
+
+	// ranges spanning the year boundary are split
+	assert.Equal(t, []string{"2024-12-30", "2024-12-31"}, dates(school.Get("AT", "", 2024)))
+	assert.Equal(t, []string{
+		"2025-01-01", "2025-01-02", "2025-01-03",
+		"2025-02-03", "2025-02-04",
+		"2025-02-10", "2025-02-11",
+	}, dates(school.Get("AT", "", 2025)))
+
+	// regional ranges only apply to their regions
+	assert.Equal(t, []string{
+		"2025-01-01", "2025-01-02", "2025-01-03",
+		"2025-02-03", "2025-02-04",
+	}, dates(school.Get("AT", "AT-3", 2025)))
+
+	assert.Empty(t, school.Get("DE", "", 2025))
+
+	h, ok := school.On("AT", "AT-4", time.Date(2025, time.February, 11, 15, 0, 0, 0, time.Local))
+	require.True(t, ok)
+	assert.Equal(t, "Semesterferien", h.LocalName)
+	assert.Equal(t, []string{SchoolHolidayType}, h.Types)
+	assert.False(t, h.Global)
+
+	_, ok = school.On("AT", "AT-3", time.Date(2025, time.February, 11, 0, 0, 0, 0, time.Local))
+	assert.False(t, ok)
+
+	// a nil SchoolHolidays has no school holidays
+	var none *SchoolHolidays
+	assert.Empty(t, none.Get("AT", "", 2025))
+}
@@ -0,0 +1,79 @@
+// Package i18n translates strings generated by the server, like the
+// summaries of free slots, to the language requested by the client.
+package i18n
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"golang.org/x/text/language"
+)
+
+// Language is a supported language.
+type Language string
+
+const (
+	German  Language = "de"
+	English Language = "en"
+)
+
+// DefaultLanguage is used if neither the request nor the configuration
+// select a language.
+const DefaultLanguage = German
+
+// supported must be in the same order as the tags passed to matcher.
+var (
+	supported = []Language{German, English}
+	matcher   = language.NewMatcher([]language.Tag{language.German, language.English})
+)
+
+// ParseLanguage parses a supported language code like "de" or "en-US".
+func ParseLanguage(s string) (Language, error) {
+	tag, err := language.Parse(strings.TrimSpace(s))
+	if err != nil {
+		return "", fmt.Errorf("invalid language %q: %w", s, err)
+	}
+
+	base, _ := tag.Base()
+	for _, l := range supported {
+		if base.String() == string(l) {
+			return l, nil
+		}
+	}
+
+	return "", fmt.Errorf("unsupported language %q", s)
+}
+
+// Match returns the supported language that matches the Accept-Language
+// header best. If none of the accepted languages is supported, fallback is
+// returned.
+func Match(acceptLanguage string, fallback Language) Language {
+	tags, _, err := language.ParseAcceptLanguage(acceptLanguage)
+	if err != nil || len(tags) == 0 {
+		return fallback
+	}
+
+	_, idx, confidence := matcher.Match(tags...)
+	if confidence == language.No {
+		return fallback
+	}
+
+	return supported[idx]
+}
+
+type languageKey struct{}
+
+// WithLanguage returns a new context that carries l.
+func WithLanguage(ctx context.Context, l Language) context.Context {
+	return context.WithValue(ctx, languageKey{}, l)
+}
+
+// FromContext returns the language of ctx or DefaultLanguage.
+func FromContext(ctx context.Context) Language {
+	if l, ok := ctx.Value(languageKey{}).(Language); ok {
+		return l
+	}
+
+	return DefaultLanguage
+}
@@ -0,0 +1,83 @@
+package i18n
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ParseLanguage(t *testing.T) {
+	l, err := ParseLanguage("en-US")
+	require.NoError(t, err)
+	assert.Equal(t, English, l)
+
+	l, err = ParseLanguage("de")
+	require.NoError(t, err)
+	assert.Equal(t, German, l)
+
+	_, err = ParseLanguage("fr")
+	assert.Error(t, err)
+
+	_, err = ParseLanguage("not a language")
+	assert.Error(t, err)
+}
+
+func Test_Match(t *testing.T) {
+	cases := []struct {
+		header   string
+		expected Language
+	}{
+		{"", German},
+		{"en-US,en;q=0.9", English},
+		{"de-AT", German},
+		{"fr-FR, en;q=0.5", English},
+		{"en;q=0.5, de;q=0.8", German},
+		{"fr", German},
+		{"invalid;;q", German},
+	}
+
+	for _, c := range cases {
+		assert.Equal(t, c.expected, Match(c.header, German), c.header)
+	}
+
+	assert.Equal(t, English, Match("fr", English))
+}
+
+func Test_Context(t *testing.T) {
+	assert.Equal(t, DefaultLanguage, FromContext(context.Background()))
+	assert.Equal(t, English, FromContext(WithLanguage(context.Background(), English)))
+}
+
+func Test_Sprintf(t *testing.T) {
+	assert.Equal(t, "Freier Slot für 1 Std.", German.Sprintf(FreeSlotSummary, German.Duration(time.Hour)))
+	assert.Equal(t, "Free slot for 1h", English.Sprintf(FreeSlotSummary, English.Duration(time.Hour)))
+
+	assert.Equal(t, "Abwesend", German.Sprintf(OutOfOfficeSummary))
+	assert.Equal(t, "Out of office", English.Sprintf(OutOfOfficeSummary))
+
+	// unsupported languages fall back to the default language
+	assert.Equal(t, "Abwesend", Language("fr").Sprintf(OutOfOfficeSummary))
+}
+
+func Test_Duration(t *testing.T) {
+	cases := []struct {
+		d      time.Duration
+		german string
+		en     string
+	}{
+		{90 * time.Minute, "1 Std. 30 Min.", "1h 30m"},
+		{2 * time.Hour, "2 Std.", "2h"},
+		{45 * time.Minute, "45 Min.", "45m"},
+		{0, "0 Min.", "0m"},
+		{29*time.Minute + 40*time.Second, "30 Min.", "30m"},
+		{26 * time.Hour, "26 Std.", "26h"},
+	}
+
+	for _, c := range cases {
+		assert.Equal(t, c.german, German.Duration(c.d), c.d.String())
+		assert.Equal(t, c.en, English.Duration(c.d), c.d.String())
+	}
+}
@@ -0,0 +1,128 @@
+package i18n
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Message identifies a translatable string. Messages are format strings for
+// fmt.Sprintf.
+type Message string
+
+const (
+	// FreeSlotSummary is the summary of free slots, the argument is the
+	// formatted duration of the slot.
+	FreeSlotSummary Message = "freeSlotSummary"
+
+	// OutOfOfficeSummary and FocusTimeSummary are used for absences
+	// without a summary.
+	OutOfOfficeSummary Message = "outOfOfficeSummary"
+	FocusTimeSummary   Message = "focusTimeSummary"
+
+	// CustomerDoubleBooking is returned if a customer already has an
+	// overlapping appointment. The arguments are the customer id and the
+	// conflicting events.
+	CustomerDoubleBooking Message = "customerDoubleBooking"
+
+	// BufferViolation is returned if an event starts within the buffer time
+	// of previous events, the argument are the previous events.
+	BufferViolation Message = "bufferViolation"
+
+	// EventTooShort and EventTooLong are returned if the duration of a
+	// timed event is out of bounds, the argument is the formatted limit.
+	EventTooShort Message = "eventTooShort"
+	EventTooLong  Message = "eventTooLong"
+
+	// EventTooFarAhead is returned if an event starts too far in the
+	// future, the argument is the last allowed date.
+	EventTooFarAhead Message = "eventTooFarAhead"
+
+	// MultiDayEventTooLong is returned if a full-day or multi-day event
+	// spans too many days, the argument is the maximum number of days.
+	MultiDayEventTooLong Message = "multiDayEventTooLong"
+
+	// PrivateEventSummary replaces the summary of private events for
+	// everyone but the owner of the calendar.
+	PrivateEventSummary Message = "privateEventSummary"
+
+	// HolidayCalendarName is the name of the virtual holiday calendar, the
+	// argument is the country code.
+	HolidayCalendarName Message = "holidayCalendarName"
+)
+
+var catalog = map[Language]map[Message]string{
+	German: {
+		FreeSlotSummary:       "Freier Slot für %s",
+		OutOfOfficeSummary:    "Abwesend",
+		FocusTimeSummary:      "Fokuszeit",
+		CustomerDoubleBooking: "Kunde %s hat bereits überschneidende Termine: %s",
+		BufferViolation:       "Termin beginnt während der Pufferzeit nach %s",
+		EventTooShort:         "Termin muss mindestens %s dauern",
+		EventTooLong:          "Termin darf nicht länger als %s dauern",
+		EventTooFarAhead:      "Termin darf nicht nach dem %s beginnen",
+		MultiDayEventTooLong:  "Ganztägige und mehrtägige Termine dürfen höchstens %d Tage umfassen",
+		PrivateEventSummary:   "Privat",
+		HolidayCalendarName:   "Feiertage (%s)",
+	},
+	English: {
+		FreeSlotSummary:       "Free slot for %s",
+		OutOfOfficeSummary:    "Out of office",
+		FocusTimeSummary:      "Focus time",
+		CustomerDoubleBooking: "customer %s already has overlapping appointments: %s",
+		BufferViolation:       "event starts within the buffer time after %s",
+		EventTooShort:         "event must last at least %s",
+		EventTooLong:          "event must not last longer than %s",
+		EventTooFarAhead:      "event must not start after %s",
+		MultiDayEventTooLong:  "full-day and multi-day events must not span more than %d days",
+		PrivateEventSummary:   "Private",
+		HolidayCalendarName:   "Public holidays (%s)",
+	},
+}
+
+// Sprintf formats msg in language l. Messages that are missing in l are
+// taken from DefaultLanguage.
+func (l Language) Sprintf(msg Message, args ...any) string {
+	format, ok := catalog[l][msg]
+	if !ok {
+		format, ok = catalog[DefaultLanguage][msg]
+	}
+
+	if !ok {
+		format = string(msg)
+	}
+
+	return fmt.Sprintf(format, args...)
+}
+
+// Duration formats d, rounded to minutes, like "1 Std. 30 Min." in German
+// and "1h 30m" in English.
+func (l Language) Duration(d time.Duration) string {
+	if _, ok := catalog[l]; !ok {
+		l = DefaultLanguage
+	}
+
+	d = d.Round(time.Minute)
+
+	hours := int(d / time.Hour)
+	minutes := int((d % time.Hour) / time.Minute)
+
+	hourUnit, minuteUnit := "h", "m"
+	sep := ""
+	if l == German {
+		hourUnit, minuteUnit = "Std.", "Min."
+		sep = " "
+	}
+
+	var parts []string
+	if hours != 0 {
+		parts = append(parts, strconv.Itoa(hours)+sep+hourUnit)
+	}
+
+	if minutes != 0 || hours == 0 {
+		parts = append(parts, strconv.Itoa(minutes)+sep+minuteUnit)
+	}
+
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,9 @@
+// Package integration contains integration tests that boot the full
+// CalendarService against a fake Google Calendar API and fake IDM and event
+// services.
+//
+// The tests are guarded by the integration build tag so unit tests stay
+// fast:
+//
+//	go test -tags integration ./internal/integration/...
+package integration
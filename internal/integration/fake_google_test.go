@@ -0,0 +1,323 @@
+//go:build integration
+
+package integration
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/api/calendar/v3"
+)
+
+// fakeEvent is an event stored by the fake google calendar API.
+type fakeEvent struct {
+	*calendar.Event
+
+	// seq is the change sequence number of the last modification.
+	seq     int
+	deleted bool
+}
+
+// fakeGoogle implements the subset of the Google Calendar API that is used by
+// the google calendar backend:
+//
+//   - calendarList.list
+//   - events.list (including sync tokens)
+//   - events.get, events.insert, events.update, events.move and events.delete
+//
+// Sync tokens are change sequence numbers so incremental syncs return all
+// events that changed since the token was issued, including deleted ones.
+type fakeGoogle struct {
+	l         sync.Mutex
+	seq       int
+	nextID    int
+	calendars []*calendar.CalendarListEntry
+	events    map[string]map[string]*fakeEvent
+
+	// syncs counts the number of incremental syncs per calendar.
+	syncs map[string]int
+}
+
+func newFakeGoogle(t *testing.T, calendars ...*calendar.CalendarListEntry) (*fakeGoogle, *httptest.Server) {
+	t.Helper()
+
+	f := &fakeGoogle{
+		calendars: calendars,
+		events:    make(map[string]map[string]*fakeEvent),
+		syncs:     make(map[string]int),
+	}
+
+	for _, c := range calendars {
+		f.events[c.Id] = make(map[string]*fakeEvent)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /users/me/calendarList", f.listCalendars)
+	mux.HandleFunc("GET /calendars/{calendarId}/events", f.listEvents)
+	mux.HandleFunc("POST /calendars/{calendarId}/events", f.insertEvent)
+	mux.HandleFunc("GET /calendars/{calendarId}/events/{eventId}", f.getEvent)
+	mux.HandleFunc("PUT /calendars/{calendarId}/events/{eventId}", f.updateEvent)
+	mux.HandleFunc("DELETE /calendars/{calendarId}/events/{eventId}", f.deleteEvent)
+	mux.HandleFunc("POST /calendars/{calendarId}/events/{eventId}/move", f.moveEvent)
+
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	return f, srv
+}
+
+// syncCount returns the number of incremental syncs of calID.
+func (f *fakeGoogle) syncCount(calID string) int {
+	f.l.Lock()
+	defer f.l.Unlock()
+
+	return f.syncs[calID]
+}
+
+// addEvent adds an event to calID as if it was created by a different client.
+func (f *fakeGoogle) addEvent(calID string, evt *calendar.Event) *calendar.Event {
+	f.l.Lock()
+	defer f.l.Unlock()
+
+	return f.store(calID, evt)
+}
+
+// removeEvent deletes an event as if it was deleted by a different client.
+func (f *fakeGoogle) removeEvent(calID, eventID string) {
+	f.l.Lock()
+	defer f.l.Unlock()
+
+	f.remove(calID, eventID)
+}
+
+// store stores evt in calID and assigns an ID if evt does not have one. The
+// caller must hold f.l.
+func (f *fakeGoogle) store(calID string, evt *calendar.Event) *calendar.Event {
+	if evt.Id == "" {
+		f.nextID++
+		evt.Id = "evt-" + strconv.Itoa(f.nextID)
+	}
+
+	f.seq++
+	evt.Status = "confirmed"
+	evt.Updated = time.Now().Format(time.RFC3339)
+	f.events[calID][evt.Id] = &fakeEvent{Event: evt, seq: f.seq}
+
+	return evt
+}
+
+// remove marks an event as deleted. The caller must hold f.l.
+func (f *fakeGoogle) remove(calID, eventID string) bool {
+	evt, ok := f.events[calID][eventID]
+	if !ok || evt.deleted {
+		return false
+	}
+
+	f.seq++
+	evt.seq = f.seq
+	evt.deleted = true
+
+	return true
+}
+
+func (f *fakeGoogle) listCalendars(w http.ResponseWriter, r *http.Request) {
+	f.l.Lock()
+	defer f.l.Unlock()
+
+	writeJSON(w, &calendar.CalendarList{Items: f.calendars})
+}
+
+func (f *fakeGoogle) listEvents(w http.ResponseWriter, r *http.Request) {
+	f.l.Lock()
+	defer f.l.Unlock()
+
+	calID := r.PathValue("calendarId")
+
+	events, ok := f.events[calID]
+	if !ok {
+		http.Error(w, "calendar not found", http.StatusNotFound)
+		return
+	}
+
+	query := r.URL.Query()
+
+	var (
+		sinceSeq = -1
+		min, max time.Time
+	)
+
+	if token := query.Get("syncToken"); token != "" {
+		seq, err := strconv.Atoi(token)
+		if err != nil {
+			http.Error(w, "invalid sync token", http.StatusGone)
+			return
+		}
+
+		sinceSeq = seq
+		f.syncs[calID]++
+	}
+
+	if v := query.Get("timeMin"); v != "" {
+		min, _ = time.Parse(time.RFC3339, v)
+	}
+	if v := query.Get("timeMax"); v != "" {
+		max, _ = time.Parse(time.RFC3339, v)
+	}
+
+	q := strings.ToLower(query.Get("q"))
+
+	result := &calendar.Events{
+		Items:         []*calendar.Event{},
+		NextSyncToken: strconv.Itoa(f.seq),
+	}
+
+	for _, evt := range events {
+		if sinceSeq >= 0 {
+			// incremental sync, report all changes including deletions
+			if evt.seq <= sinceSeq {
+				continue
+			}
+
+			if evt.deleted {
+				result.Items = append(result.Items, &calendar.Event{Id: evt.Id, Status: "cancelled"})
+				continue
+			}
+
+			result.Items = append(result.Items, evt.Event)
+			continue
+		}
+
+		if evt.deleted {
+			continue
+		}
+
+		start, _ := time.Parse(time.RFC3339, evt.Start.DateTime)
+		end, _ := time.Parse(time.RFC3339, evt.End.DateTime)
+
+		if !min.IsZero() && !end.After(min) {
+			continue
+		}
+		if !max.IsZero() && !start.Before(max) {
+			continue
+		}
+
+		if q != "" && !strings.Contains(strings.ToLower(evt.Summary+" "+evt.Description), q) {
+			continue
+		}
+
+		result.Items = append(result.Items, evt.Event)
+	}
+
+	sort.Slice(result.Items, func(i, j int) bool {
+		return result.Items[i].Id < result.Items[j].Id
+	})
+
+	writeJSON(w, result)
+}
+
+func (f *fakeGoogle) insertEvent(w http.ResponseWriter, r *http.Request) {
+	var evt calendar.Event
+	if err := json.NewDecoder(r.Body).Decode(&evt); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	f.l.Lock()
+	defer f.l.Unlock()
+
+	calID := r.PathValue("calendarId")
+	if _, ok := f.events[calID]; !ok {
+		http.Error(w, "calendar not found", http.StatusNotFound)
+		return
+	}
+
+	evt.Id = ""
+	writeJSON(w, f.store(calID, &evt))
+}
+
+func (f *fakeGoogle) getEvent(w http.ResponseWriter, r *http.Request) {
+	f.l.Lock()
+	defer f.l.Unlock()
+
+	evt, ok := f.events[r.PathValue("calendarId")][r.PathValue("eventId")]
+	if !ok || evt.deleted {
+		http.Error(w, "event not found", http.StatusNotFound)
+		return
+	}
+
+	writeJSON(w, evt.Event)
+}
+
+func (f *fakeGoogle) updateEvent(w http.ResponseWriter, r *http.Request) {
+	var evt calendar.Event
+	if err := json.NewDecoder(r.Body).Decode(&evt); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	f.l.Lock()
+	defer f.l.Unlock()
+
+	calID, eventID := r.PathValue("calendarId"), r.PathValue("eventId")
+
+	existing, ok := f.events[calID][eventID]
+	if !ok || existing.deleted {
+		http.Error(w, "event not found", http.StatusNotFound)
+		return
+	}
+
+	evt.Id = eventID
+	writeJSON(w, f.store(calID, &evt))
+}
+
+func (f *fakeGoogle) deleteEvent(w http.ResponseWriter, r *http.Request) {
+	f.l.Lock()
+	defer f.l.Unlock()
+
+	if !f.remove(r.PathValue("calendarId"), r.PathValue("eventId")) {
+		http.Error(w, "event not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (f *fakeGoogle) moveEvent(w http.ResponseWriter, r *http.Request) {
+	f.l.Lock()
+	defer f.l.Unlock()
+
+	calID, eventID := r.PathValue("calendarId"), r.PathValue("eventId")
+	target := r.URL.Query().Get("destination")
+
+	if _, ok := f.events[target]; !ok {
+		http.Error(w, "destination calendar not found", http.StatusNotFound)
+		return
+	}
+
+	existing, ok := f.events[calID][eventID]
+	if !ok || existing.deleted {
+		http.Error(w, "event not found", http.StatusNotFound)
+		return
+	}
+
+	moved := *existing.Event
+	f.remove(calID, eventID)
+
+	writeJSON(w, f.store(target, &moved))
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		http.Error(w, fmt.Sprintf("failed to encode response: %s", err), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,218 @@
+//go:build integration
+
+package integration
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/stretchr/testify/require"
+	calendarv1 "github.com/tierklinik-dobersberg/apis/gen/go/tkd/calendar/v1"
+	"github.com/tierklinik-dobersberg/apis/gen/go/tkd/calendar/v1/calendarv1connect"
+	eventsv1 "github.com/tierklinik-dobersberg/apis/gen/go/tkd/events/v1"
+	"github.com/tierklinik-dobersberg/apis/gen/go/tkd/events/v1/eventsv1connect"
+	idmv1 "github.com/tierklinik-dobersberg/apis/gen/go/tkd/idm/v1"
+	"github.com/tierklinik-dobersberg/apis/gen/go/tkd/idm/v1/idmv1connect"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/app"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/config"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/holidays"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/repo"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/services"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"google.golang.org/api/calendar/v3"
+	"google.golang.org/api/option"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// fakeUsers is an IDM user service without any users.
+type fakeUsers struct {
+	idmv1connect.UnimplementedUserServiceHandler
+}
+
+func (fakeUsers) ListUsers(context.Context, *connect.Request[idmv1.ListUsersRequest]) (*connect.Response[idmv1.ListUsersResponse], error) {
+	return connect.NewResponse(&idmv1.ListUsersResponse{}), nil
+}
+
+// fakeEvents records all calendar change events published by the event
+// caches.
+type fakeEvents struct {
+	eventsv1connect.UnimplementedEventServiceHandler
+
+	l       sync.Mutex
+	changes []*calendarv1.CalendarChangeEvent
+}
+
+func (f *fakeEvents) Publish(_ context.Context, req *connect.Request[eventsv1.Event]) (*connect.Response[emptypb.Empty], error) {
+	var change calendarv1.CalendarChangeEvent
+	if req.Msg.Event.MessageIs(&change) {
+		if err := req.Msg.Event.UnmarshalTo(&change); err != nil {
+			return nil, err
+		}
+
+		f.l.Lock()
+		f.changes = append(f.changes, &change)
+		f.l.Unlock()
+	}
+
+	return connect.NewResponse(new(emptypb.Empty)), nil
+}
+
+// deleted reports whether the deletion of eventID has been published.
+func (f *fakeEvents) deleted(eventID string) bool {
+	f.l.Lock()
+	defer f.l.Unlock()
+
+	for _, c := range f.changes {
+		if c.GetDeletedEventId() == eventID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// changed reports whether a change of eventID has been published.
+func (f *fakeEvents) changed(eventID string) bool {
+	f.l.Lock()
+	defer f.l.Unlock()
+
+	for _, c := range f.changes {
+		if c.GetEventChange().GetId() == eventID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// harness boots the full CalendarService against a fake Google Calendar API
+// and fake IDM and event services.
+type harness struct {
+	google  *fakeGoogle
+	events  *fakeEvents
+	backend repo.Service
+	client  calendarv1connect.CalendarServiceClient
+}
+
+func newHarness(t *testing.T, calendars ...*calendar.CalendarListEntry) *harness {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	google, googleSrv := newFakeGoogle(t, calendars...)
+
+	_, usersHandler := idmv1connect.NewUserServiceHandler(fakeUsers{})
+	idmSrv := httptest.NewServer(usersHandler)
+	t.Cleanup(idmSrv.Close)
+
+	// the event service client of the backend only speaks HTTP/2 without
+	// TLS.
+	events := new(fakeEvents)
+	_, eventsHandler := eventsv1connect.NewEventServiceHandler(events)
+	eventsSrv := httptest.NewServer(h2c.NewHandler(eventsHandler, new(http2.Server)))
+	t.Cleanup(eventsSrv.Close)
+
+	cfg := loadConfig(t, map[string]any{
+		"idmUrl":           idmSrv.URL,
+		"eventsServiceUrl": eventsSrv.URL,
+	})
+
+	// sync much faster than allowed by the configuration so changes made
+	// by other clients are picked up quickly.
+	cfg.Google.SyncInterval = config.Duration(100 * time.Millisecond)
+	cfg.Google.MaxBackoff = config.Duration(time.Second)
+
+	backend, err := repo.NewWithOptions(ctx, cfg,
+		option.WithHTTPClient(googleSrv.Client()),
+		option.WithEndpoint(googleSrv.URL+"/"),
+	)
+	require.NoError(t, err)
+
+	svc := services.New(ctx, &app.App{
+		Config:   cfg,
+		Service:  backend,
+		Users:    idmv1connect.NewUserServiceClient(idmSrv.Client(), idmSrv.URL),
+		Roles:    idmv1connect.NewRoleServiceClient(idmSrv.Client(), idmSrv.URL),
+		Holidays: holidays.NewFake(),
+	})
+
+	_, handler := calendarv1connect.NewCalendarServiceHandler(svc)
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+
+	h := &harness{
+		google:  google,
+		events:  events,
+		backend: backend,
+		client:  calendarv1connect.NewCalendarServiceClient(srv.Client(), srv.URL),
+	}
+
+	// wait for the calendar cache to be loaded
+	require.Eventually(t, func() bool {
+		res, err := h.client.ListCalendars(ctx, connect.NewRequest(&calendarv1.ListCalendarsRequest{}))
+
+		return err == nil && len(res.Msg.Calendars) == len(calendars)
+	}, 5*time.Second, 50*time.Millisecond)
+
+	return h
+}
+
+// loadConfig writes values to a configuration file and loads it so all
+// defaults are applied.
+func loadConfig(t *testing.T, values map[string]any) config.Config {
+	t.Helper()
+
+	content, err := json.Marshal(values)
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "config.json")
+	require.NoError(t, os.WriteFile(path, content, 0o600))
+
+	cfg, err := config.LoadConfig(path)
+	require.NoError(t, err)
+
+	return cfg
+}
+
+// listEvents returns all events of calID on the given date.
+func (h *harness) listEvents(t *testing.T, calID string, date time.Time) []*calendarv1.CalendarEvent {
+	t.Helper()
+
+	res, err := h.client.ListEvents(context.Background(), connect.NewRequest(&calendarv1.ListEventsRequest{
+		Source: &calendarv1.ListEventsRequest_Sources{
+			Sources: &calendarv1.EventSource{
+				CalendarIds: []string{calID},
+			},
+		},
+		SearchTime: &calendarv1.ListEventsRequest_Date{
+			Date: date.Format("2006-01-02"),
+		},
+	}))
+	require.NoError(t, err)
+
+	var result []*calendarv1.CalendarEvent
+	for _, r := range res.Msg.Results {
+		result = append(result, r.Events...)
+	}
+
+	return result
+}
+
+// eventIDs returns the IDs of events.
+func eventIDs(events []*calendarv1.CalendarEvent) []string {
+	ids := make([]string, len(events))
+	for idx, e := range events {
+		ids[idx] = e.Id
+	}
+
+	return ids
+}
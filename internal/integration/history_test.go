@@ -0,0 +1,47 @@
+//go:build integration
+
+package integration
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/calendar/v3"
+)
+
+func Test_ListEvents_History(t *testing.T) {
+	h := newHarness(t, testCalendars...)
+
+	now := time.Now()
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+
+	start := time.Date(now.Year()-2, now.Month(), now.Day(), 10, 0, 0, 0, time.Local)
+	old := h.google.addEvent("vet-1", &calendar.Event{
+		Summary: "Bello",
+		Start:   &calendar.EventDateTime{DateTime: start.Format(time.RFC3339)},
+		End:     &calendar.EventDateTime{DateTime: start.Add(30 * time.Minute).Format(time.RFC3339)},
+	})
+
+	// wait for the event cache so the query is not served like a query
+	// during the first sync
+	h.listEvents(t, "vet-1", now)
+	require.Eventually(t, func() bool {
+		states, err := h.backend.DumpCacheState(context.Background(), []string{"vet-1"}, time.Time{}, time.Time{})
+		return err == nil && len(states) == 1 && states[0].Ready
+	}, 5*time.Second, 50*time.Millisecond)
+
+	// events outside of the cached time range are loaded from google
+	require.Eventually(t, func() bool {
+		return assert.ObjectsAreEqual([]string{old.Id}, eventIDs(h.listEvents(t, "vet-1", start)))
+	}, 5*time.Second, 50*time.Millisecond)
+
+	// without extending the event cache
+	states, err := h.backend.DumpCacheState(context.Background(), []string{"vet-1"}, time.Time{}, time.Time{})
+	require.NoError(t, err)
+	require.Len(t, states, 1)
+	assert.True(t, states[0].MinTime.Equal(midnight), states[0].MinTime)
+	assert.Zero(t, states[0].EventCount)
+}
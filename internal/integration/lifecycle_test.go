@@ -0,0 +1,155 @@
+//go:build integration
+
+package integration
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	calendarv1 "github.com/tierklinik-dobersberg/apis/gen/go/tkd/calendar/v1"
+	"google.golang.org/api/calendar/v3"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+var testCalendars = []*calendar.CalendarListEntry{
+	{Id: "vet-1", Summary: "Vet 1", TimeZone: "Europe/Vienna", AccessRole: "owner"},
+	{Id: "vet-2", Summary: "Vet 2", TimeZone: "Europe/Vienna", AccessRole: "writer"},
+}
+
+// tomorrowAt returns the given time of tomorrow. Events are created in the
+// future so they are covered by the event caches.
+func tomorrowAt(hour, minute int) time.Time {
+	now := time.Now()
+
+	return time.Date(now.Year(), now.Month(), now.Day()+1, hour, minute, 0, 0, time.Local)
+}
+
+func Test_EventLifecycle(t *testing.T) {
+	h := newHarness(t, testCalendars...)
+	ctx := context.Background()
+
+	start := tomorrowAt(10, 0)
+
+	extra, err := anypb.New(&calendarv1.CustomerAnnotation{
+		CustomerSource: "vetinf",
+		CustomerId:     "1234",
+	})
+	require.NoError(t, err)
+
+	// create
+	created, err := h.client.CreateEvent(ctx, connect.NewRequest(&calendarv1.CreateEventRequest{
+		CalendarId: "vet-1",
+		Name:       "Bello",
+		Start:      timestamppb.New(start),
+		End:        timestamppb.New(start.Add(30 * time.Minute)),
+		ExtraData:  extra,
+	}))
+	require.NoError(t, err)
+
+	eventID := created.Msg.Event.Id
+	require.NotEmpty(t, eventID)
+
+	// list
+	require.Eventually(t, func() bool {
+		return assert.ObjectsAreEqual([]string{eventID}, eventIDs(h.listEvents(t, "vet-1", start)))
+	}, 5*time.Second, 50*time.Millisecond)
+
+	events := h.listEvents(t, "vet-1", start)
+	assert.Equal(t, "Bello", events[0].Summary)
+	assert.True(t, events[0].StartTime.AsTime().Equal(start))
+
+	var customer calendarv1.CustomerAnnotation
+	require.NoError(t, events[0].ExtraData.UnmarshalTo(&customer))
+	assert.Equal(t, "1234", customer.CustomerId)
+
+	// update
+	_, err = h.client.UpdateEvent(ctx, connect.NewRequest(&calendarv1.UpdateEventRequest{
+		CalendarId: "vet-1",
+		EventId:    eventID,
+		Name:       "Bello (Vaccination)",
+		Start:      timestamppb.New(start.Add(time.Hour)),
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"name", "start"}},
+	}))
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		events := h.listEvents(t, "vet-1", start)
+
+		return len(events) == 1 &&
+			events[0].Summary == "Bello (Vaccination)" &&
+			events[0].StartTime.AsTime().Equal(start.Add(time.Hour))
+	}, 5*time.Second, 50*time.Millisecond)
+
+	// move
+	moved, err := h.client.MoveEvent(ctx, connect.NewRequest(&calendarv1.MoveEventRequest{
+		Source:  &calendarv1.MoveEventRequest_SourceCalendarId{SourceCalendarId: "vet-1"},
+		EventId: eventID,
+		Target:  &calendarv1.MoveEventRequest_TargetCalendarId{TargetCalendarId: "vet-2"},
+	}))
+	require.NoError(t, err)
+	assert.Equal(t, "vet-2", moved.Msg.Event.CalendarId)
+
+	require.Eventually(t, func() bool {
+		return len(h.listEvents(t, "vet-1", start)) == 0 &&
+			assert.ObjectsAreEqual([]string{eventID}, eventIDs(h.listEvents(t, "vet-2", start)))
+	}, 5*time.Second, 50*time.Millisecond)
+
+	// delete
+	_, err = h.client.DeleteEvent(ctx, connect.NewRequest(&calendarv1.DeleteEventRequest{
+		CalendarId: "vet-2",
+		EventId:    eventID,
+	}))
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		return len(h.listEvents(t, "vet-2", start)) == 0
+	}, 5*time.Second, 50*time.Millisecond)
+
+	// deleting the event a second time fails
+	_, err = h.client.DeleteEvent(ctx, connect.NewRequest(&calendarv1.DeleteEventRequest{
+		CalendarId: "vet-2",
+		EventId:    eventID,
+	}))
+	assert.Error(t, err)
+}
+
+func Test_CacheSync(t *testing.T) {
+	h := newHarness(t, testCalendars...)
+
+	start := tomorrowAt(9, 0)
+
+	// the first request creates the event cache of the calendar
+	assert.Empty(t, h.listEvents(t, "vet-1", start))
+
+	// changes made by other clients are picked up by incremental syncs
+	evt := h.google.addEvent("vet-1", &calendar.Event{
+		Summary: "Minka",
+		Start:   &calendar.EventDateTime{DateTime: start.Format(time.RFC3339)},
+		End:     &calendar.EventDateTime{DateTime: start.Add(time.Hour).Format(time.RFC3339)},
+	})
+
+	require.Eventually(t, func() bool {
+		return assert.ObjectsAreEqual([]string{evt.Id}, eventIDs(h.listEvents(t, "vet-1", start)))
+	}, 5*time.Second, 50*time.Millisecond)
+
+	assert.Positive(t, h.google.syncCount("vet-1"))
+	assert.Eventually(t, func() bool {
+		return h.events.changed(evt.Id)
+	}, 5*time.Second, 50*time.Millisecond)
+
+	h.google.removeEvent("vet-1", evt.Id)
+
+	require.Eventually(t, func() bool {
+		return len(h.listEvents(t, "vet-1", start)) == 0
+	}, 5*time.Second, 50*time.Millisecond)
+
+	assert.Eventually(t, func() bool {
+		return h.events.deleted(evt.Id)
+	}, 5*time.Second, 50*time.Millisecond)
+}
@@ -0,0 +1,85 @@
+package repo
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type cacheTraceKey struct{}
+
+// CacheTrace records whether the events of a calendar have been served from
+// the event cache while handling a single request and whether open-ended
+// searches have been capped.
+type CacheTrace struct {
+	l         sync.Mutex
+	hits      map[string]bool
+	truncated map[string]time.Time
+}
+
+// WithCacheTrace returns a new context that records cache hits of ListEvents
+// calls in the returned trace.
+func WithCacheTrace(ctx context.Context) (context.Context, *CacheTrace) {
+	trace := &CacheTrace{
+		hits:      make(map[string]bool),
+		truncated: make(map[string]time.Time),
+	}
+
+	return context.WithValue(ctx, cacheTraceKey{}, trace), trace
+}
+
+// Hit reports whether the events of calID have been served from cache. If
+// the calendar has been queried multiple times, it is only reported as a hit
+// if all queries have been served from cache. ok is false if the calendar
+// has not been queried at all.
+func (t *CacheTrace) Hit(calID string) (hit bool, ok bool) {
+	t.l.Lock()
+	defer t.l.Unlock()
+
+	hit, ok = t.hits[calID]
+
+	return hit, ok
+}
+
+// Truncated returns the time an open-ended search of calID has been capped
+// at because the event cache of the calendar was not ready yet. Events
+// after that time are missing from the result. ok is false if no search of
+// calID has been capped.
+func (t *CacheTrace) Truncated(calID string) (until time.Time, ok bool) {
+	t.l.Lock()
+	defer t.l.Unlock()
+
+	until, ok = t.truncated[calID]
+
+	return until, ok
+}
+
+func recordTruncation(ctx context.Context, calID string, until time.Time) {
+	trace, ok := ctx.Value(cacheTraceKey{}).(*CacheTrace)
+	if !ok {
+		return
+	}
+
+	trace.l.Lock()
+	defer trace.l.Unlock()
+
+	if previous, ok := trace.truncated[calID]; !ok || until.Before(previous) {
+		trace.truncated[calID] = until
+	}
+}
+
+func recordCacheHit(ctx context.Context, calID string, hit bool) {
+	trace, ok := ctx.Value(cacheTraceKey{}).(*CacheTrace)
+	if !ok {
+		return
+	}
+
+	trace.l.Lock()
+	defer trace.l.Unlock()
+
+	if previous, ok := trace.hits[calID]; ok {
+		hit = hit && previous
+	}
+
+	trace.hits[calID] = hit
+}
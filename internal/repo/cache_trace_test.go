@@ -0,0 +1,32 @@
+package repo
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_CacheTrace(t *testing.T) {
+	// recording without a trace is a no-op
+	recordCacheHit(context.Background(), "cal-1", true)
+
+	ctx, trace := WithCacheTrace(context.Background())
+
+	recordCacheHit(ctx, "cal-1", true)
+	recordCacheHit(ctx, "cal-2", true)
+	recordCacheHit(ctx, "cal-2", false)
+	recordCacheHit(ctx, "cal-2", true)
+
+	hit, ok := trace.Hit("cal-1")
+	assert.True(t, ok)
+	assert.True(t, hit)
+
+	// a single miss marks the calendar as a miss
+	hit, ok = trace.Hit("cal-2")
+	assert.True(t, ok)
+	assert.False(t, hit)
+
+	_, ok = trace.Hit("cal-3")
+	assert.False(t, ok)
+}
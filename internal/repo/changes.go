@@ -0,0 +1,45 @@
+package repo
+
+import (
+	"sync"
+
+	calendarv1 "github.com/tierklinik-dobersberg/apis/gen/go/tkd/calendar/v1"
+)
+
+// ChangeListener is called with every event change detected by a backend.
+// Listeners are called synchronously from the sync loop of the calendar and
+// must not block.
+type ChangeListener func(change *calendarv1.CalendarChangeEvent)
+
+// ChangeNotifier may be implemented by backends that detect event changes,
+// like the google backend during incremental syncs.
+type ChangeNotifier interface {
+	OnChange(fn ChangeListener)
+}
+
+// changeListeners is a list of change listeners that is shared between a
+// backend and its event caches. A nil list does not notify anyone.
+type changeListeners struct {
+	l   sync.RWMutex
+	fns []ChangeListener
+}
+
+func (cl *changeListeners) add(fn ChangeListener) {
+	cl.l.Lock()
+	defer cl.l.Unlock()
+
+	cl.fns = append(cl.fns, fn)
+}
+
+func (cl *changeListeners) notify(change *calendarv1.CalendarChangeEvent) {
+	if cl == nil {
+		return
+	}
+
+	cl.l.RLock()
+	defer cl.l.RUnlock()
+
+	for _, fn := range cl.fns {
+		fn(change)
+	}
+}
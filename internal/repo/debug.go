@@ -0,0 +1,128 @@
+package repo
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"time"
+	"unsafe"
+)
+
+// CacheState is a snapshot of an event cache used for debugging.
+type CacheState struct {
+	CalendarID   string `json:"calendarId"`
+	CalendarName string `json:"calendarName,omitempty"`
+
+	// Backend is the name of the backend that owns the cache. It's set by
+	// the Registry.
+	Backend string `json:"backend,omitempty"`
+
+	Ready        bool      `json:"ready"`
+	MinTime      time.Time `json:"minTime"`
+	LastSync     time.Time `json:"lastSync"`
+	HasSyncToken bool      `json:"hasSyncToken"`
+	Version      int64     `json:"version"`
+
+	EventCount int        `json:"eventCount"`
+	FirstEvent *time.Time `json:"firstEvent,omitempty"`
+	LastEvent  *time.Time `json:"lastEvent,omitempty"`
+
+	// MemoryEstimate is a rough estimate of the memory used by the cached
+	// events in bytes.
+	MemoryEstimate int64 `json:"memoryEstimate"`
+
+	// Events are the cached events within the requested window.
+	Events []Event `json:"-"`
+}
+
+// estimateSize returns a rough estimate of the memory used by e. Shared
+// backing arrays are counted for every event.
+func estimateSize(e Event) int64 {
+	size := int64(unsafe.Sizeof(e))
+	size += int64(len(e.ID) + len(e.Summary) + len(e.Description) + len(e.CalendarID))
+	size += int64(len(e.OverlayOf) + len(e.Source) + len(e.EventType))
+	size += int64(len(e.Status) + len(e.StatusChangedBy))
+
+	for _, t := range e.Tags {
+		size += int64(unsafe.Sizeof(t)) + int64(len(t))
+	}
+
+	if e.EndTime != nil {
+		size += int64(unsafe.Sizeof(*e.EndTime))
+	}
+
+	if e.Data != nil {
+		size += int64(unsafe.Sizeof(*e.Data))
+		size += int64(len(e.Data.CustomerSource) + len(e.Data.CustomerID) + len(e.Data.CreatedBy))
+
+		for _, s := range e.Data.AnimalID {
+			size += int64(unsafe.Sizeof(s)) + int64(len(s))
+		}
+
+		for _, s := range e.Data.RequiredResources {
+			size += int64(unsafe.Sizeof(s)) + int64(len(s))
+		}
+	}
+
+	return size
+}
+
+// state returns a snapshot of the cache. The snapshot is taken under the
+// read lock so it is consistent with a single sync.
+func (ec *googleEventCache) state(from, to time.Time) CacheState {
+	ec.rw.RLock()
+	defer ec.rw.RUnlock()
+
+	res := CacheState{
+		CalendarID:   ec.calID,
+		CalendarName: ec.calendarName,
+		Ready:        ec.ready(),
+		MinTime:      ec.minTime,
+		LastSync:     ec.lastSync,
+		HasSyncToken: ec.syncToken != "",
+		Version:      ec.version,
+		EventCount:   len(ec.events),
+	}
+
+	// events are sorted by their start time after each sync
+	if len(ec.events) > 0 {
+		first := ec.events[0].StartTime
+		last := ec.events[len(ec.events)-1].StartTime
+
+		res.FirstEvent = &first
+		res.LastEvent = &last
+	}
+
+	for _, e := range ec.events {
+		res.MemoryEstimate += estimateSize(e)
+
+		if !from.IsZero() && !e.StartTime.Before(from) && (to.IsZero() || e.StartTime.Before(to)) {
+			res.Events = append(res.Events, e)
+		}
+	}
+
+	return res
+}
+
+func (svc *googleCalendarBackend) DumpCacheState(_ context.Context, calendarIDs []string, from, to time.Time) ([]CacheState, error) {
+	svc.cacheLock.Lock()
+	caches := make([]*googleEventCache, 0, len(svc.eventsCache))
+	for id, cache := range svc.eventsCache {
+		// dumping the state must not create new caches
+		if len(calendarIDs) == 0 || slices.Contains(calendarIDs, id) {
+			caches = append(caches, cache)
+		}
+	}
+	svc.cacheLock.Unlock()
+
+	res := make([]CacheState, 0, len(caches))
+	for _, cache := range caches {
+		res = append(res, cache.state(from, to))
+	}
+
+	slices.SortFunc(res, func(a, b CacheState) int {
+		return strings.Compare(a.CalendarID, b.CalendarID)
+	})
+
+	return res, nil
+}
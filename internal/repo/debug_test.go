@@ -0,0 +1,93 @@
+package repo
+
+import (
+	"context"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/clock"
+)
+
+func Test_DumpCacheState(t *testing.T) {
+	midnight := time.Date(2024, time.June, 3, 0, 0, 0, 0, time.UTC)
+	at := func(hour int) time.Time {
+		return midnight.Add(time.Duration(hour) * time.Hour)
+	}
+
+	ready := &googleEventCache{
+		calID:         "vet",
+		calendarName:  "Vet",
+		firstLoadDone: make(chan struct{}),
+		minTime:       midnight,
+		lastSync:      at(8),
+		syncToken:     "token",
+		version:       42,
+		clock:         clock.NewFake(at(8)),
+		events: []Event{
+			{ID: "1", StartTime: at(8), Data: &StructuredEvent{CustomerID: "1234"}},
+			{ID: "2", StartTime: at(10)},
+			{ID: "3", StartTime: at(30)},
+		},
+		log: slog.Default(),
+	}
+	close(ready.firstLoadDone)
+
+	syncing := &googleEventCache{
+		calID:         "surgery",
+		firstLoadDone: make(chan struct{}),
+		log:           slog.Default(),
+	}
+
+	backend := &googleCalendarBackend{
+		eventsCache: map[string]*googleEventCache{
+			"vet":     ready,
+			"surgery": syncing,
+		},
+	}
+
+	ctx := context.Background()
+
+	states, err := backend.DumpCacheState(ctx, nil, time.Time{}, time.Time{})
+	require.NoError(t, err)
+	require.Len(t, states, 2)
+
+	assert.Equal(t, "surgery", states[0].CalendarID)
+	assert.False(t, states[0].Ready)
+	assert.Zero(t, states[0].EventCount)
+	assert.Nil(t, states[0].FirstEvent)
+
+	vet := states[1]
+	assert.Equal(t, "vet", vet.CalendarID)
+	assert.Equal(t, "Vet", vet.CalendarName)
+	assert.True(t, vet.Ready)
+	assert.True(t, vet.HasSyncToken)
+	assert.Equal(t, int64(42), vet.Version)
+	assert.Equal(t, at(8), vet.LastSync)
+	assert.Equal(t, 3, vet.EventCount)
+	assert.Equal(t, at(8), *vet.FirstEvent)
+	assert.Equal(t, at(30), *vet.LastEvent)
+	assert.Positive(t, vet.MemoryEstimate)
+	// events are only included for a window
+	assert.Empty(t, vet.Events)
+
+	states, err = backend.DumpCacheState(ctx, []string{"vet", "unknown"}, midnight, midnight.AddDate(0, 0, 1))
+	require.NoError(t, err)
+	require.Len(t, states, 1)
+	require.Len(t, states[0].Events, 2)
+	assert.Equal(t, "1", states[0].Events[0].ID)
+	assert.Equal(t, "2", states[0].Events[1].ID)
+
+	// unknown calendars do not create new caches
+	assert.Len(t, backend.eventsCache, 2)
+
+	r := NewRegistry()
+	require.NoError(t, r.Register("google", backend))
+
+	states, err = r.DumpCacheState(ctx, []string{"surgery"}, time.Time{}, time.Time{})
+	require.NoError(t, err)
+	require.Len(t, states, 1)
+	assert.Equal(t, "google", states[0].Backend)
+}
@@ -10,14 +10,17 @@ import (
 	"net/http"
 	"os"
 	"slices"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/bufbuild/connect-go"
 	"github.com/sirupsen/logrus"
 	"github.com/tierklinik-dobersberg/apis/gen/go/tkd/events/v1/eventsv1connect"
 	"github.com/tierklinik-dobersberg/apis/pkg/cli"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/clock"
 	"github.com/tierklinik-dobersberg/cis-cal/internal/config"
 	"github.com/tierklinik-dobersberg/cis/pkg/trace"
 	"go.opentelemetry.io/otel"
@@ -32,27 +35,223 @@ import (
 
 type SearchOption func(*EventSearchOptions)
 
+// MoveOption configures a MoveEvent call.
+type MoveOption func(*MoveOptions)
+
+// MoveOptions are the options of a MoveEvent call.
+type MoveOptions struct {
+	// StartTime is set to change the time of the event as part of the
+	// move. EndTime may be nil for events without an end time.
+	StartTime *time.Time
+	EndTime   *time.Time
+}
+
+// WithEventTime changes the time of the moved event as part of the move.
+func WithEventTime(start time.Time, end *time.Time) MoveOption {
+	return func(mo *MoveOptions) {
+		mo.StartTime = &start
+		mo.EndTime = end
+	}
+}
+
+// CreateOption configures a CreateEvent call.
+type CreateOption func(*CreateOptions)
+
+// CreateOptions are the options of a CreateEvent call.
+type CreateOptions struct {
+	// FullDay creates a full-day event spanning the dates of the start
+	// time and the end time (exclusive).
+	FullDay bool
+
+	// ImportedFrom is the id of the event the new event is restored from.
+	ImportedFrom string
+
+	// Channel is the channel the event is created through.
+	Channel string
+
+	// Visibility is the visibility of the new event, like
+	// VisibilityPrivate.
+	Visibility string
+
+	// Tags are the tags of the new event.
+	Tags []string
+
+	// ColorID is the google calendar color of the new event.
+	ColorID string
+
+	// DescriptionFormat is the format the description has been written
+	// in. An empty value is richtext.FormatHTML.
+	DescriptionFormat string
+}
+
+// WithFullDay creates a full-day event.
+func WithFullDay() CreateOption {
+	return func(co *CreateOptions) {
+		co.FullDay = true
+	}
+}
+
+// WithImportedFrom records the id of the event the new event is restored
+// from so repeated imports can detect duplicates.
+func WithImportedFrom(eventID string) CreateOption {
+	return func(co *CreateOptions) {
+		co.ImportedFrom = eventID
+	}
+}
+
+// WithSourceChannel records the channel, like an online booking portal, the
+// event is created through.
+func WithSourceChannel(channel string) CreateOption {
+	return func(co *CreateOptions) {
+		co.Channel = channel
+	}
+}
+
+// WithVisibility sets the visibility of the new event.
+func WithVisibility(visibility string) CreateOption {
+	return func(co *CreateOptions) {
+		co.Visibility = visibility
+	}
+}
+
+// WithTags sets the tags of the new event.
+func WithTags(tags ...string) CreateOption {
+	return func(co *CreateOptions) {
+		co.Tags = tags
+	}
+}
+
+// WithColor sets the google calendar color of the new event.
+func WithColor(colorID string) CreateOption {
+	return func(co *CreateOptions) {
+		co.ColorID = colorID
+	}
+}
+
+// WithDescriptionFormat sets the format of the description of the new
+// event.
+func WithDescriptionFormat(format string) CreateOption {
+	return func(co *CreateOptions) {
+		co.DescriptionFormat = format
+	}
+}
+
 // Service allows to read and manipulate google
 // calendar events.
 type Service interface {
 	ListCalendars(ctx context.Context) ([]Calendar, error)
 	ListEvents(ctx context.Context, calendarID string, filter ...SearchOption) ([]Event, error)
 	LoadEvent(ctx context.Context, calendarID string, eventID string, ignoreCache bool) (*Event, error)
-	CreateEvent(ctx context.Context, calID, name, description string, startTime time.Time, duration time.Duration, data *StructuredEvent) (*Event, error)
+	CreateEvent(ctx context.Context, calID, name, description string, startTime time.Time, duration time.Duration, data *StructuredEvent, opts ...CreateOption) (*Event, error)
 	DeleteEvent(ctx context.Context, calID, eventID string) error
-	MoveEvent(ctx context.Context, originCalendarId, eventId, targetCalendarId string) (event *Event, err error)
+	MoveEvent(ctx context.Context, originCalendarId, eventId, targetCalendarId string, opts ...MoveOption) (event *Event, err error)
 	UpdateEvent(ctx context.Context, event Event) (*Event, error)
+
+	// Prewarm creates the event caches for the given calendars so
+	// the first request does not need to wait for a full sync.
+	Prewarm(calendarIDs ...string)
+
+	// CalendarVersion returns a version of the calendar that increases
+	// whenever events of the calendar are created, updated or deleted.
+	CalendarVersion(ctx context.Context, calendarID string) (int64, error)
+
+	// UpdateEventStatus changes the appointment status of an event and
+	// records who changed it. Invalid transitions are rejected with
+	// ErrInvalidEvent.
+	UpdateEventStatus(ctx context.Context, calendarID, eventID, status, changedBy string) (*Event, error)
+
+	// QuotaUsage returns the number of google calendar API calls of the
+	// last hours, the current hour first.
+	QuotaUsage() []QuotaUsage
+
+	// DumpCacheState returns a snapshot of the event caches of the given
+	// calendars, or of all event caches if no calendars are given. Cached
+	// events are included if they start within [from, to) and from is set.
+	DumpCacheState(ctx context.Context, calendarIDs []string, from, to time.Time) ([]CacheState, error)
+
+	// Close stops all background work of the service. It waits for
+	// pending work until ctx is done.
+	Close(ctx context.Context) error
 }
 
 type googleCalendarBackend struct {
 	*calendar.Service
 
+	// ctx is the lifetime context of the backend and is used for
+	// the event caches which are created lazily during requests.
+	// It's canceled by Close.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// publisher tracks the change events published by the event caches.
+	publisher publisher
+
+	// listeners are notified about the changes detected by the event
+	// caches.
+	listeners changeListeners
+
 	EventsClient    eventsv1connect.EventServiceClient
 	ignoreCalendars []string
+	syncInterval    time.Duration
+	maxBackoff      time.Duration
+
+	// clock is used by the event caches, clockSkew is how far before
+	// local midnight the event caches load events so requests that start
+	// slightly before midnight can be served from the cache.
+	clock     clock.Clock
+	clockSkew time.Duration
+
+	// backfillWindow is how far before the cached time range events are
+	// written back to the event caches. Older events are loaded for every
+	// request. Zero disables the limit.
+	backfillWindow time.Duration
+
+	// coldLoadWindow limits searches without an end that are not served
+	// by a ready event cache to the window after their start. Capped
+	// searches are logged and recorded in the CacheTrace of the request.
+	// Zero disables the limit.
+	coldLoadWindow time.Duration
+
+	// idempotentDeletes makes deleting events that no longer exist
+	// upstream succeed.
+	idempotentDeletes bool
+
+	// calendarTimezone is the timezone of created calendars, shareWith
+	// the access rules added to them.
+	calendarTimezone string
+	shareWith        []config.CalendarShare
+
+	// accessRoles maps calendar IDs to the access role of the service
+	// account as of the last calendar listing.
+	rolesLock   sync.RWMutex
+	accessRoles map[string]string
 
 	cacheLock   sync.Mutex
 	eventsCache map[string]*googleEventCache
-	loadGroup   singleflight.Group
+
+	// loadGroup deduplicates concurrent upstream loads. singleflight forgets
+	// a key as soon as the call completed so the key space is bounded by the
+	// number of in-flight loads which is tracked in pendingLoads.
+	loadGroup    singleflight.Group
+	pendingLoads atomic.Int64
+
+	// limiter bounds the number of concurrent upstream requests. Requests
+	// served from the cache do not need a slot.
+	limiter *upstreamLimiter
+
+	// quota records all calls to the google calendar API, see api.
+	quota *QuotaTracker
+}
+
+// api returns the instrumented facade that must be used for all calls to the
+// google calendar API.
+func (svc *googleCalendarBackend) api() googleAPI {
+	return googleAPI{svc: svc.Service, quota: svc.quota}
+}
+
+// QuotaUsage implements Service.
+func (svc *googleCalendarBackend) QuotaUsage() []QuotaUsage {
+	return svc.quota.Usage()
 }
 
 // New creates a new calendar service from cfg.
@@ -68,23 +267,47 @@ func New(ctx context.Context, cfg config.Config) (Service, error) {
 	}
 
 	client := creds.Client(ctx, token)
-	calSvc, err := calendar.NewService(ctx, option.WithHTTPClient(client))
+
+	return NewWithOptions(ctx, cfg, option.WithHTTPClient(client))
+}
+
+// NewWithOptions creates a new calendar service from cfg using opts for the
+// google calendar client. It does not read any credentials and is used to
+// run the backend against a fake calendar API.
+func NewWithOptions(ctx context.Context, cfg config.Config, opts ...option.ClientOption) (Service, error) {
+	calSvc, err := calendar.NewService(ctx, opts...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create calendar client: %w", err)
 	}
 
+	ctx, cancel := context.WithCancel(ctx)
+
 	svc := &googleCalendarBackend{
 		Service:         calSvc,
+		ctx:             ctx,
+		cancel:          cancel,
 		eventsCache:     make(map[string]*googleEventCache),
 		ignoreCalendars: cfg.IgnoreCalendars,
+		syncInterval:    cfg.Google.SyncInterval.AsDuration(),
+		maxBackoff:      cfg.Google.MaxBackoff.AsDuration(),
+		clock:           clock.Real{},
+		clockSkew:       cfg.Google.ClockSkew.AsDuration(),
+		backfillWindow:  cfg.Google.BackfillWindow.AsDuration(),
+		coldLoadWindow:  cfg.Google.ColdLoadWindow.AsDuration(),
+		limiter:         newUpstreamLimiter(cfg.Google.MaxConcurrentPerCalendar, cfg.Google.MaxConcurrent),
+		quota:           NewQuotaTracker(cfg.Google.QuotaWarnPerHour),
 		EventsClient:    eventsv1connect.NewEventServiceClient(cli.NewInsecureHttp2Client(), cfg.EventsServiceUrl),
-	}
 
-	// create a new eventCache for each calendar right now
-	if _, err := svc.ListCalendars(ctx); err != nil {
-		slog.Error("failed to start watching calendars", "erro", err)
+		idempotentDeletes: cfg.Google.IdempotentDeletes,
+		calendarTimezone:  cfg.CalendarAdmin.Timezone,
+		shareWith:         cfg.CalendarAdmin.ShareWith,
 	}
 
+	// only prewarm explicitly configured calendars, event caches for all
+	// other calendars are created on first use or when prewarmed by the
+	// caller.
+	svc.Prewarm(cfg.Google.PrewarmCalendars...)
+
 	return svc, nil
 }
 
@@ -108,7 +331,7 @@ func Authenticate(cfg config.Config) error {
 }
 
 func (svc *googleCalendarBackend) ListCalendars(ctx context.Context) ([]Calendar, error) {
-	res, err := svc.Service.CalendarList.List().ShowHidden(true).Do()
+	res, err := svc.api().ListCalendars(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to retrieve list of calendars: %w", err)
 	}
@@ -126,22 +349,146 @@ func (svc *googleCalendarBackend) ListCalendars(ctx context.Context) ([]Calendar
 		}
 
 		list = append(list, Calendar{
-			ID:       item.Id,
-			Name:     item.Summary,
-			Timezone: item.TimeZone,
-			Location: loc,
-			Color:    item.BackgroundColor,
+			ID:         item.Id,
+			Name:       item.Summary,
+			Timezone:   item.TimeZone,
+			Location:   loc,
+			Color:      item.BackgroundColor,
+			AccessRole: item.AccessRole,
+			Primary:    item.Primary,
+			Readonly:   isReadonlyAccessRole(item.AccessRole),
+			Hidden:     item.Hidden,
 		})
+	}
 
-		// immediately prepare the calendar cache
-		if _, err = svc.cacheFor(ctx, item.Id); err != nil {
-			logrus.Errorf("failed to perpare calendar event cache for %s: %s", item.Id, err)
-		}
+	roles := make(map[string]string, len(list))
+	for _, cal := range list {
+		roles[cal.ID] = cal.AccessRole
 	}
 
+	svc.rolesLock.Lock()
+	svc.accessRoles = roles
+	svc.rolesLock.Unlock()
+
 	return list, nil
 }
 
+// CanWrite reports whether the service account may write events of
+// calendarID based on the access role of the last calendar listing.
+// Calendars that have not been listed yet are left to google to decide.
+func (svc *googleCalendarBackend) CanWrite(calendarID string) bool {
+	svc.rolesLock.RLock()
+	defer svc.rolesLock.RUnlock()
+
+	role, ok := svc.accessRoles[calendarID]
+
+	return !ok || !isReadonlyAccessRole(role)
+}
+
+// CreateCalendar implements CalendarCreator. The new calendar is shared with
+// all configured principals. If sharing fails the calendar is returned
+// together with the error as it has already been created.
+func (svc *googleCalendarBackend) CreateCalendar(ctx context.Context, name string) (*Calendar, error) {
+	res, err := svc.api().InsertCalendar(ctx, &calendar.Calendar{
+		Summary:  name,
+		TimeZone: svc.calendarTimezone,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create calendar upstream: %w", err)
+	}
+
+	loc, err := time.LoadLocation(res.TimeZone)
+	if err != nil {
+		slog.Error("failed to parse timezone from calendar", "time-zone", res.TimeZone, "calendar-id", res.Id)
+	}
+
+	cal := &Calendar{
+		ID:         res.Id,
+		Name:       res.Summary,
+		Timezone:   res.TimeZone,
+		Location:   loc,
+		AccessRole: "owner",
+	}
+
+	for _, share := range svc.shareWith {
+		_, err := svc.api().InsertACL(ctx, cal.ID, &calendar.AclRule{
+			Role: share.Role,
+			Scope: &calendar.AclRuleScope{
+				Type:  share.Type,
+				Value: share.Value,
+			},
+		})
+		if err != nil {
+			return cal, fmt.Errorf("calendar %s has been created but could not be shared with %s %s: %w", cal.ID, share.Type, share.Value, err)
+		}
+	}
+
+	return cal, nil
+}
+
+// UpdateCalendar implements CalendarUpdater. The name is changed for all
+// users of the calendar while the color is only changed in the calendar
+// list of the service account.
+func (svc *googleCalendarBackend) UpdateCalendar(ctx context.Context, calendarID string, update CalendarUpdate) error {
+	if update.Name != "" {
+		if _, err := svc.api().PatchCalendar(ctx, calendarID, &calendar.Calendar{Summary: update.Name}); err != nil {
+			return calendarError(calendarID, "rename", err)
+		}
+	}
+
+	if update.Color != "" {
+		call := svc.CalendarList.Patch(calendarID, &calendar.CalendarListEntry{
+			BackgroundColor: update.Color,
+			ForegroundColor: foregroundColor(update.Color),
+		}).ColorRgbFormat(true)
+
+		if _, err := svc.api().PatchCalendarListEntry(ctx, call); err != nil {
+			return calendarError(calendarID, "change the color of", err)
+		}
+	}
+
+	return nil
+}
+
+// calendarError maps errors of the google calendar API for calendarID.
+func calendarError(calendarID, action string, err error) error {
+	if isNotFound(err) {
+		return connect.NewError(connect.CodeNotFound, fmt.Errorf("%w: calendar %q does not exist upstream", ErrNotFound, calendarID))
+	}
+
+	return fmt.Errorf("failed to %s calendar %s upstream: %w", action, calendarID, err)
+}
+
+// foregroundColor returns black or white, whichever is more readable on the
+// hex color background.
+func foregroundColor(background string) string {
+	rgb, err := strconv.ParseUint(strings.TrimPrefix(background, "#"), 16, 32)
+	if err != nil {
+		return "#000000"
+	}
+
+	r, g, b := (rgb>>16)&0xff, (rgb>>8)&0xff, rgb&0xff
+	if (r*299+g*587+b*114)/1000 >= 128 {
+		return "#000000"
+	}
+
+	return "#ffffff"
+}
+
+// OnChange registers fn to be called for every event change detected
+// while syncing the event caches.
+func (svc *googleCalendarBackend) OnChange(fn ChangeListener) {
+	svc.listeners.add(fn)
+}
+
+func (svc *googleCalendarBackend) Prewarm(calendarIDs ...string) {
+	for _, id := range calendarIDs {
+		if _, err := svc.cacheFor(svc.ctx, id); err != nil {
+			logrus.Errorf("failed to prepare calendar event cache for %s: %s", id, err)
+		}
+	}
+}
+
 func (svc *googleCalendarBackend) ListEvents(ctx context.Context, calendarID string, searchOpts ...SearchOption) ([]Event, error) {
 	opts := new(EventSearchOptions)
 
@@ -154,22 +501,29 @@ func (svc *googleCalendarBackend) ListEvents(ctx context.Context, calendarID str
 		logrus.Errorf("failed to get event cache for calendar %s: %s", calendarID, err)
 	}
 
-	events, ok := cache.tryLoadFromCache(ctx, opts)
-	if ok {
-		return events, nil
+	if cache != nil {
+		events, ok := cache.tryLoadFromCache(ctx, opts)
+		if ok {
+			recordCacheHit(ctx, calendarID, true)
+
+			return events, nil
+		}
 	}
 
+	recordCacheHit(ctx, calendarID, false)
+
 	return svc.loadEvents(ctx, calendarID, opts, cache)
 }
 
-func (svc *googleCalendarBackend) CreateEvent(ctx context.Context, calID, name, description string, startTime time.Time, duration time.Duration, data *StructuredEvent) (*Event, error) {
+func (svc *googleCalendarBackend) CreateEvent(ctx context.Context, calID, name, description string, startTime time.Time, duration time.Duration, data *StructuredEvent, opts ...CreateOption) (*Event, error) {
 	ctx, sp := otel.Tracer("").Start(ctx, "google.backend#CreateEvent")
 	defer sp.End()
 
+	// spans are exported to the tracing backend so the summary is redacted
+	// like in log lines and the description is never recorded.
 	sp.SetAttributes(
 		attribute.String("calendar.id", calID),
-		attribute.String("calendar.name", name),
-		attribute.String("calendar.description", description),
+		attribute.String("calendar.name", RedactSummary(name)),
 		attribute.String("calendar.start_time", startTime.String()),
 		attribute.String("calendar.duration", duration.String()),
 	)
@@ -187,25 +541,59 @@ func (svc *googleCalendarBackend) CreateEvent(ctx context.Context, calID, name,
 		description = strings.TrimSpace(description) + "\n\n[CIS]\n" + buf.String()
 	}
 
-	res, err := svc.Service.Events.Insert(calID, &calendar.Event{
-		Summary:     name,
-		Description: description,
-		Start: &calendar.EventDateTime{
-			DateTime: startTime.Format(time.RFC3339),
-		},
-		End: &calendar.EventDateTime{
-			DateTime: startTime.Add(duration).Format(time.RFC3339),
-		},
-		Status: "confirmed",
-	}).Context(ctx).Do()
+	var co CreateOptions
+	for _, fn := range opts {
+		fn(&co)
+	}
+
+	props, err := eventProperties(EventSourceCisCal, co.Tags, co.DescriptionFormat)
+	if err != nil {
+		return nil, err
+	}
+
+	props = withImportedFrom(props, co.ImportedFrom)
+	props = withChannel(props, co.Channel)
+
+	props, err = compactProperties(props)
+	if err != nil {
+		return nil, err
+	}
+
+	start := &calendar.EventDateTime{
+		DateTime: startTime.Format(time.RFC3339),
+	}
+	end := &calendar.EventDateTime{
+		DateTime: startTime.Add(duration).Format(time.RFC3339),
+	}
+
+	if co.FullDay {
+		start = &calendar.EventDateTime{Date: startTime.Format("2006-01-02")}
+		end = &calendar.EventDateTime{Date: startTime.Add(duration).Format("2006-01-02")}
+	}
+
+	res, err := svc.api().InsertEvent(ctx, calID, &calendar.Event{
+		Summary:            name,
+		Description:        description,
+		Start:              start,
+		End:                end,
+		Status:             "confirmed",
+		ColorId:            co.ColorID,
+		Visibility:         co.Visibility,
+		ExtendedProperties: props,
+	})
 	if err != nil {
 		trace.RecordAndLog(ctx, err)
 
+		if isReadOnly(err) {
+			return nil, readOnlyError(calID, err)
+		}
+
 		return nil, fmt.Errorf("failed to insert event upstream: %w", err)
 	}
 	logrus.Infof("created event with id %s", res.Id)
 
 	if cache, _ := svc.cacheFor(ctx, calID); cache != nil {
+		cache.touch()
 		cache.triggerSync()
 	}
 
@@ -213,23 +601,51 @@ func (svc *googleCalendarBackend) CreateEvent(ctx context.Context, calID, name,
 }
 
 func (svc *googleCalendarBackend) UpdateEvent(ctx context.Context, event Event) (*Event, error) {
-	evt, err := svc.Service.Events.Update(event.CalendarID, event.ID, &calendar.Event{
-		Summary:     event.Summary,
-		Description: event.Description,
-		Start: &calendar.EventDateTime{
-			DateTime: event.StartTime.Format(time.RFC3339),
-		},
-		End: &calendar.EventDateTime{
-			DateTime: event.EndTime.Format(time.RFC3339),
-		},
-		Status: "confirmed",
-	}).Context(ctx).Do()
+	props, err := eventProperties(event.Source, event.Tags, event.DescriptionFormat)
+	if err != nil {
+		return nil, err
+	}
+
+	props = withStatusProperties(props, event)
+	props = withImportedFrom(props, event.ImportedFrom)
+	props = withChannel(props, event.Channel)
+
+	props, err = compactProperties(props)
+	if err != nil {
+		return nil, err
+	}
+
+	start, end, endUnspecified := eventTimes(event)
+
+	evt, err := svc.api().UpdateEvent(ctx, event.CalendarID, event.ID, &calendar.Event{
+		Summary:            event.Summary,
+		Description:        event.Description,
+		Start:              start,
+		End:                end,
+		EndTimeUnspecified: endUnspecified,
+		Status:             "confirmed",
+		ColorId:            event.ColorID,
+		Visibility:         event.Visibility,
+		// Update replaces the whole event so the source tag, the event
+		// tags, the status, the color and the visibility must be written
+		// again.
+		ExtendedProperties: props,
+	})
 
 	if err != nil {
+		if isNotFound(err) {
+			return nil, svc.eventGone(ctx, event.CalendarID, event.ID, err)
+		}
+
+		if isReadOnly(err) {
+			return nil, readOnlyError(event.CalendarID, err)
+		}
+
 		return nil, err
 	}
 
 	if cache, err := svc.cacheFor(ctx, event.CalendarID); err == nil && cache != nil {
+		cache.touch()
 		cache.triggerSync()
 	} else {
 		logrus.Errorf("[update] failed to trigger sync for event calendar id %q: %s", event.CalendarID, err)
@@ -238,19 +654,157 @@ func (svc *googleCalendarBackend) UpdateEvent(ctx context.Context, event Event)
 	return googleEventToModel(ctx, event.CalendarID, evt)
 }
 
-func (svc *googleCalendarBackend) MoveEvent(ctx context.Context, originCalendarId string, eventId string, targetCalendarId string) (*Event, error) {
-	result, err := svc.Service.Events.Move(originCalendarId, eventId, targetCalendarId).Context(ctx).Do()
+// eventTimes returns the start and end of event as sent to google. Full-day
+// events only set the dates, timed events only the date and time, google
+// rejects events that mix both. Full-day events without an end last a
+// single day. Timed events without an end are written with an unspecified
+// end time.
+func eventTimes(event Event) (*calendar.EventDateTime, *calendar.EventDateTime, bool) {
+	if event.FullDayEvent {
+		end := event.StartTime.AddDate(0, 0, 1)
+		if event.EndTime != nil && event.EndTime.Format("2006-01-02") > event.StartTime.Format("2006-01-02") {
+			end = *event.EndTime
+		}
+
+		return &calendar.EventDateTime{Date: event.StartTime.Format("2006-01-02")},
+			&calendar.EventDateTime{Date: end.Format("2006-01-02")},
+			false
+	}
+
+	start := &calendar.EventDateTime{DateTime: event.StartTime.Format(time.RFC3339)}
+
+	// google still requires an end time if it is unspecified.
+	if event.EndTime == nil {
+		return start, &calendar.EventDateTime{DateTime: event.StartTime.Format(time.RFC3339)}, true
+	}
+
+	return start, &calendar.EventDateTime{DateTime: event.EndTime.Format(time.RFC3339)}, false
+}
+
+// UpdateEventStatus validates the status transition against the current
+// version of the event and patches the status properties. The update fails
+// with connect.CodeAborted if the event has been modified in the meantime.
+func (svc *googleCalendarBackend) UpdateEventStatus(ctx context.Context, calendarID, eventID, status, changedBy string) (*Event, error) {
+	current, err := svc.LoadEvent(ctx, calendarID, eventID, true)
 	if err != nil {
 		return nil, err
 	}
 
+	if err := ValidateStatusTransition(current.Status, status); err != nil {
+		return nil, err
+	}
+
+	current.Status = status
+	current.StatusChangedBy = changedBy
+	current.StatusChangedAt = svc.clock.Now()
+
+	// patch merges the private properties so only the status is sent
+	call := svc.Service.Events.Patch(calendarID, eventID, &calendar.Event{
+		ExtendedProperties: withStatusProperties(nil, *current),
+	})
+	if current.Etag != "" {
+		call.Header().Set("If-Match", current.Etag)
+	}
+
+	evt, err := svc.api().PatchEvent(withDefaultQuotaCaller(ctx, CallerStatus), call)
+	if err != nil {
+		var googleError *googleapi.Error
+		if errors.As(err, &googleError) && googleError.Code == http.StatusPreconditionFailed {
+			return nil, connect.NewError(connect.CodeAborted, fmt.Errorf("event %q has been modified concurrently", eventID))
+		}
+
+		if isReadOnly(err) {
+			return nil, readOnlyError(calendarID, err)
+		}
+
+		return nil, err
+	}
+
+	if cache, err := svc.cacheFor(ctx, calendarID); err == nil && cache != nil {
+		cache.touch()
+		cache.triggerSync()
+	} else {
+		logrus.Errorf("[status] failed to trigger sync for event calendar id %q: %s", calendarID, err)
+	}
+
+	return googleEventToModel(ctx, calendarID, evt)
+}
+
+// rollbackTimeout bounds rolling back a move that could not be completed.
+const rollbackTimeout = 30 * time.Second
+
+// MoveEvent moves the event to the target calendar. If a new time is set
+// using WithEventTime the event is patched right after the move. The move is
+// rolled back if the event cannot be patched so the event never stays in the
+// target calendar at its old time.
+func (svc *googleCalendarBackend) MoveEvent(ctx context.Context, originCalendarId string, eventId string, targetCalendarId string, opts ...MoveOption) (*Event, error) {
+	var mo MoveOptions
+	for _, fn := range opts {
+		fn(&mo)
+	}
+
+	result, err := svc.api().MoveEvent(ctx, originCalendarId, eventId, targetCalendarId)
+	if err != nil {
+		if isNotFound(err) {
+			return nil, svc.eventGone(ctx, originCalendarId, eventId, err)
+		}
+
+		// google does not tell which of the calendars is read-only
+		if isReadOnly(err) {
+			return nil, readOnlyError(originCalendarId+" or "+targetCalendarId, err)
+		}
+
+		return nil, err
+	}
+
+	if mo.StartTime != nil {
+		patch := &calendar.Event{
+			Start: &calendar.EventDateTime{
+				DateTime: mo.StartTime.Format(time.RFC3339),
+			},
+		}
+
+		if mo.EndTime != nil {
+			patch.End = &calendar.EventDateTime{
+				DateTime: mo.EndTime.Format(time.RFC3339),
+			}
+		}
+
+		patched, err := svc.api().PatchEvent(withDefaultQuotaCaller(ctx, CallerMove), svc.Service.Events.Patch(targetCalendarId, eventId, patch))
+		if err != nil {
+			// the request context may already be done, the rollback must
+			// still be tried.
+			rollbackCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), rollbackTimeout)
+			defer cancel()
+
+			if _, rollbackErr := svc.api().MoveEvent(rollbackCtx, targetCalendarId, eventId, originCalendarId); rollbackErr != nil {
+				logrus.Errorf("[move] failed to move event %q back to calendar %q: %s", eventId, originCalendarId, rollbackErr)
+
+				err = errors.Join(err, fmt.Errorf("failed to roll back move: %w", rollbackErr))
+			}
+
+			// the origin calendar may have changed even if the move has
+			// been rolled back
+			if cache, cacheErr := svc.cacheFor(ctx, originCalendarId); cacheErr == nil {
+				cache.touch()
+				cache.triggerSync()
+			}
+
+			return nil, fmt.Errorf("failed to change the time of the moved event: %w", err)
+		}
+
+		result = patched
+	}
+
 	if cache, err := svc.cacheFor(ctx, originCalendarId); err == nil && cache != nil {
+		cache.touch()
 		cache.triggerSync()
 	} else {
 		logrus.Errorf("[move] failed to trigger sync for origin calendar id %q: %s", originCalendarId, err)
 	}
 
 	if cache, err := svc.cacheFor(ctx, targetCalendarId); err == nil && cache != nil {
+		cache.touch()
 		cache.triggerSync()
 	} else {
 		logrus.Errorf("[move] failed to trigger sync for target calendar id %q: %s", targetCalendarId, err)
@@ -260,20 +814,81 @@ func (svc *googleCalendarBackend) MoveEvent(ctx context.Context, originCalendarI
 }
 
 func (svc *googleCalendarBackend) DeleteEvent(ctx context.Context, calID, eventID string) error {
-	err := svc.Service.Events.Delete(calID, eventID).Context(ctx).Do()
+	err := svc.api().DeleteEvent(ctx, calID, eventID)
 	if err != nil {
+		if isNotFound(err) {
+			gone := svc.eventGone(ctx, calID, eventID, err)
+			if svc.idempotentDeletes {
+				return nil
+			}
+
+			return gone
+		}
+
+		if isReadOnly(err) {
+			return readOnlyError(calID, err)
+		}
+
 		return fmt.Errorf("failed to delete event upstream: %w", err)
 	}
 
 	cache, err := svc.cacheFor(ctx, calID)
 	if err == nil {
+		// publish the deletion right away, the sync would only detect it
+		// with the next interval.
+		if _, ok := cache.forget(ctx, eventID); !ok {
+			cache.touch()
+			cache.publishDeleted(ctx, eventID)
+		}
+
 		cache.triggerSync()
 	}
 
 	return nil
 }
 
-func (svc *googleCalendarBackend) cacheFor(ctx context.Context, calID string) (*googleEventCache, error) {
+// CalendarVersion returns the version of the event cache of the calendar.
+// It waits for the first sync of the cache if required.
+func (svc *googleCalendarBackend) CalendarVersion(ctx context.Context, calendarID string) (int64, error) {
+	cache, err := svc.cacheFor(ctx, calendarID)
+	if err != nil {
+		return 0, err
+	}
+
+	select {
+	case <-cache.firstLoadDone:
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+
+	return cache.currentVersion(), nil
+}
+
+// Close stops the event caches. In-flight change events are given until
+// ctx is done to be published before they are canceled.
+func (svc *googleCalendarBackend) Close(ctx context.Context) error {
+	err := svc.publisher.close(ctx)
+
+	svc.cancel()
+
+	// canceled publishes return promptly
+	svc.publisher.wg.Wait()
+
+	svc.cacheLock.Lock()
+	caches := make([]*googleEventCache, 0, len(svc.eventsCache))
+	for _, cache := range svc.eventsCache {
+		caches = append(caches, cache)
+	}
+	svc.cacheLock.Unlock()
+
+	for _, cache := range caches {
+		cache.wg.Wait()
+	}
+
+	return err
+}
+
+func (svc *googleCalendarBackend) cacheFor(_ context.Context, calID string) (*googleEventCache, error) {
 	svc.cacheLock.Lock()
 	defer svc.cacheLock.Unlock()
 
@@ -284,7 +899,17 @@ func (svc *googleCalendarBackend) cacheFor(ctx context.Context, calID string) (*
 		return cache, nil
 	}
 
-	cache, err := newCache(ctx, calID, calID, svc.Service, svc.EventsClient)
+	// caches outlive the request that created them so make sure to use
+	// the lifetime context of the backend.
+	cache, err := newCache(svc.ctx, calID, calID, svc.Service, svc.EventsClient, cacheOptions{
+		quota:        svc.quota,
+		syncInterval: svc.syncInterval,
+		maxBackoff:   svc.maxBackoff,
+		clock:        svc.clock,
+		clockSkew:    svc.clockSkew,
+		publisher:    &svc.publisher,
+		listeners:    &svc.listeners,
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -308,14 +933,17 @@ func (svc *googleCalendarBackend) LoadEvent(ctx context.Context, calendarID, eve
 		}
 	}
 
-	evt, err := svc.Service.Events.Get(calendarID, eventID).Context(ctx).Do()
+	release, err := svc.limiter.acquire(ctx, calendarID)
 	if err != nil {
-		var googleError *googleapi.Error
-		if errors.As(err, &googleError) {
-			switch googleError.Code {
-			case http.StatusNotFound, http.StatusGone:
-				return nil, connect.NewError(connect.CodeNotFound, googleError)
-			}
+		return nil, err
+	}
+
+	evt, err := svc.api().GetEvent(ctx, calendarID, eventID)
+	release()
+
+	if err != nil {
+		if isNotFound(err) {
+			return nil, svc.eventGone(ctx, calendarID, eventID, err)
 		}
 
 		return nil, err
@@ -324,10 +952,93 @@ func (svc *googleCalendarBackend) LoadEvent(ctx context.Context, calendarID, eve
 	return googleEventToModel(ctx, calendarID, evt)
 }
 
+// isNotFound reports whether err is a google API error for a resource that
+// does not exist (anymore).
+func isNotFound(err error) bool {
+	var googleError *googleapi.Error
+
+	return errors.As(err, &googleError) && (googleError.Code == http.StatusNotFound || googleError.Code == http.StatusGone)
+}
+
+// isReadOnly reports whether err is a google API error for writing to a
+// calendar the service account may only read.
+func isReadOnly(err error) bool {
+	var googleError *googleapi.Error
+	if !errors.As(err, &googleError) || googleError.Code != http.StatusForbidden {
+		return false
+	}
+
+	// rate limits are reported as forbidden as well
+	return slices.ContainsFunc(googleError.Errors, func(item googleapi.ErrorItem) bool {
+		return item.Reason == "requiredAccessLevel"
+	})
+}
+
+// readOnlyError returns an ErrReadOnly for the calendar that rejected a
+// write with cause.
+func readOnlyError(calendar string, cause error) error {
+	return fmt.Errorf("%w: calendar %s: %w", ErrReadOnly, calendar, cause)
+}
+
+// eventGone handles requests for events that no longer exist upstream, like
+// events deleted directly in google while the cache still has them because
+// the sync lags behind. The event is removed from the cache and a sync is
+// triggered. The returned NotFound error carries the last cached version of
+// the event, if any, as error detail so clients can tell the user what has
+// been deleted.
+func (svc *googleCalendarBackend) eventGone(ctx context.Context, calendarID, eventID string, cause error) error {
+	connectErr := connect.NewError(connect.CodeNotFound, fmt.Errorf("%w: the event no longer exists upstream", ErrNotFound))
+
+	cache, err := svc.cacheFor(ctx, calendarID)
+	if err != nil || cache == nil {
+		return connectErr
+	}
+
+	defer cache.triggerSync()
+
+	snapshot, ok := cache.forget(ctx, eventID)
+	if !ok {
+		return connectErr
+	}
+
+	logrus.Infof("event %q of calendar %q no longer exists upstream: %s", eventID, calendarID, cause)
+
+	pb, err := snapshot.ToProto()
+	if err != nil {
+		logrus.Errorf("failed to convert cached event %q: %s", eventID, err)
+
+		return connectErr
+	}
+
+	detail, err := connect.NewErrorDetail(pb)
+	if err != nil {
+		logrus.Errorf("failed to attach cached event %q to error: %s", eventID, err)
+
+		return connectErr
+	}
+
+	connectErr.AddDetail(detail)
+
+	return connectErr
+}
+
 // trunk-ignore(golangci-lint/cyclop)
 func (svc *googleCalendarBackend) loadEvents(ctx context.Context, calendarID string, searchOpts *EventSearchOptions, cache *googleEventCache) ([]Event, error) {
 	call := svc.Events.List(calendarID).ShowDeleted(false).SingleEvents(true)
 
+	// the cache can only be used for write-back once it finished
+	// it's first sync.
+	warm := cache != nil && cache.ready()
+
+	// queries that start long before the cached time range, like looking
+	// up last year's appointments, are loaded with the requested bounds and
+	// are not written back so the cache does not grow beyond the backfill
+	// window.
+	if warm && svc.backfillWindow > 0 && searchOpts != nil && searchOpts.FromTime != nil &&
+		searchOpts.FromTime.Before(cache.currentMinTime().Add(-svc.backfillWindow)) {
+		warm = false
+	}
+
 	key := calendarID
 	if searchOpts != nil {
 		if searchOpts.FromTime != nil {
@@ -335,28 +1046,94 @@ func (svc *googleCalendarBackend) loadEvents(ctx context.Context, calendarID str
 			key += fmt.Sprintf("-%s", searchOpts.FromTime.Format(time.RFC3339))
 		}
 
-		upper := cache.currentMinTime()
+		var upper time.Time
+		if warm {
+			upper = cache.currentMinTime()
+		}
 
 		if searchOpts.ToTime != nil && searchOpts.ToTime.After(upper) {
 			upper = *searchOpts.ToTime
 		}
 
-		call = call.TimeMax(upper.Format(time.RFC3339))
-		key += fmt.Sprintf("-%s", upper.Format(time.RFC3339))
+		// without a ready cache a search without an end would page through
+		// the whole future of the calendar.
+		if upper.IsZero() && svc.coldLoadWindow > 0 {
+			start := svc.clock.Now()
+			if searchOpts.FromTime != nil {
+				start = *searchOpts.FromTime
+			}
+
+			upper = start.Add(svc.coldLoadWindow)
+
+			logrus.Warnf("open-ended search of calendar %s capped at %s while the event cache is not ready", calendarID, upper.Format(time.RFC3339))
+			recordTruncation(ctx, calendarID, upper)
+		}
+
+		if !upper.IsZero() {
+			call = call.TimeMax(upper.Format(time.RFC3339))
+			key += fmt.Sprintf("-%s", upper.Format(time.RFC3339))
+		}
 
 		if searchOpts.EventID != nil {
 			key += "-" + *searchOpts.EventID
 		}
+
+		// resources and customers are stored as part of the structured data in
+		// the event description so use a free-text query to let google
+		// pre-filter the events. Google supports only one query so the exact
+		// match for all filters is done below.
+		if searchOpts.Resource != nil {
+			call = call.Q(*searchOpts.Resource)
+			key += "-resource:" + *searchOpts.Resource
+		}
+
+		if searchOpts.CustomerID != nil {
+			call = call.Q(*searchOpts.CustomerID)
+			key += "-customer:" + *searchOpts.CustomerID
+		}
+
+		if searchOpts.Query != nil {
+			call = call.Q(*searchOpts.Query)
+			key += "-q:" + *searchOpts.Query
+		}
+
+		// tags, statuses and channels are filtered below so they must be part of
+		// the key as well.
+		if len(searchOpts.Tags) > 0 {
+			key += "-tags:" + strings.Join(searchOpts.Tags, ",")
+		}
+
+		if len(searchOpts.Statuses) > 0 {
+			key += "-status:" + strings.Join(searchOpts.Statuses, ",")
+		}
+
+		if len(searchOpts.Channels) > 0 {
+			key += "-channel:" + strings.Join(searchOpts.Channels, ",")
+		}
 	}
 
+	executed := false
 	res, err, _ := svc.loadGroup.Do(key, func() (interface{}, error) {
+		executed = true
+
+		svc.pendingLoads.Add(1)
+		defer svc.pendingLoads.Add(-1)
+
+		eventLoadCounter.Add(ctx, 1, loadExecuted)
+
+		release, err := svc.limiter.acquire(ctx, calendarID)
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+
 		var events []Event
 		var pageToken string
 		for {
 			if pageToken != "" {
 				call.PageToken(pageToken)
 			}
-			res, err := call.Context(ctx).Do()
+			res, err := svc.api().ListEvents(ctx, call)
 			if err != nil {
 				return nil, fmt.Errorf("failed to retrieve page from upstream: %w", err)
 			}
@@ -370,6 +1147,32 @@ func (svc *googleCalendarBackend) loadEvents(ctx context.Context, calendarID str
 					continue
 				}
 
+				if searchOpts.Resource != nil && !evt.HasResource(*searchOpts.Resource) {
+					continue
+				}
+
+				// google also matches on other fields like the location or
+				// attendees so make sure the event actually matches.
+				if searchOpts.Query != nil && !evt.EventMatches(*searchOpts.Query) {
+					continue
+				}
+
+				if searchOpts.CustomerID != nil && !evt.HasCustomer(*searchOpts.CustomerID) {
+					continue
+				}
+
+				if len(searchOpts.Tags) > 0 && !evt.HasTag(searchOpts.Tags...) {
+					continue
+				}
+
+				if len(searchOpts.Statuses) > 0 && !evt.HasStatus(searchOpts.Statuses...) {
+					continue
+				}
+
+				if len(searchOpts.Channels) > 0 && !evt.HasChannel(searchOpts.Channels...) {
+					continue
+				}
+
 				// if we're searching for a single event ID, we can check for that ID and
 				// exit early
 				if searchOpts.EventID != nil {
@@ -391,21 +1194,28 @@ func (svc *googleCalendarBackend) loadEvents(ctx context.Context, calendarID str
 			break
 		}
 
-		// if we got a cache, append the results to the cache
-		if searchOpts.FromTime != nil {
+		// if we got a cache, append the results to the cache. Results filtered
+		// by resource, customer or query are incomplete and must not be written back.
+		if warm && searchOpts.FromTime != nil && !searchOpts.filtered() {
 			cache.appendEvents(events, *searchOpts.FromTime)
 		}
 
 		return events, nil
 	})
 
+	// callers that did not execute the load got the result of a
+	// concurrent call with the same key.
+	if !executed {
+		eventLoadCounter.Add(ctx, 1, loadDeduplicated)
+	}
+
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch events: %w", err)
 	}
 
-	// if we did not have any search-opts, searched for a single event ID or do not have a start
-	// time we return the result immediately from the fetched result.
-	if searchOpts == nil || searchOpts.EventID != nil || searchOpts.FromTime == nil {
+	// if we did not have any search-opts, searched for a single event ID, do not have a start
+	// time or the cache is not yet ready we return the result immediately from the fetched result.
+	if searchOpts == nil || searchOpts.EventID != nil || searchOpts.FromTime == nil || searchOpts.filtered() || !warm {
 		// trunk-ignore(golangci-lint/forcetypeassert)
 		return res.([]Event), nil
 	}
@@ -420,6 +1230,12 @@ func (svc *googleCalendarBackend) loadEvents(ctx context.Context, calendarID str
 	return result, nil
 }
 
+// isReadonlyAccessRole reports whether events cannot be written with the
+// given Google calendar access role.
+func isReadonlyAccessRole(role string) bool {
+	return role == "reader" || role == "freeBusyReader"
+}
+
 func (svc *googleCalendarBackend) shouldIngore(item *calendar.CalendarListEntry) bool {
 	return slices.Contains(svc.ignoreCalendars, item.Id)
 }
@@ -0,0 +1,782 @@
+package repo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	calendarv1 "github.com/tierklinik-dobersberg/apis/gen/go/tkd/calendar/v1"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/clock"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/config"
+	"google.golang.org/api/calendar/v3"
+	"google.golang.org/api/option"
+)
+
+func newTestBackend(t *testing.T, handler http.Handler) *googleCalendarBackend {
+	t.Helper()
+
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	calSvc, err := calendar.NewService(ctx, option.WithHTTPClient(srv.Client()), option.WithEndpoint(srv.URL))
+	require.NoError(t, err)
+
+	return &googleCalendarBackend{
+		Service:      calSvc,
+		ctx:          ctx,
+		cancel:       cancel,
+		clock:        clock.Real{},
+		eventsCache:  make(map[string]*googleEventCache),
+		syncInterval: time.Minute,
+		maxBackoff:   time.Minute,
+		limiter:      newUpstreamLimiter(2, 8),
+	}
+}
+
+func Test_LoadEvents_KeySpaceIsBounded(t *testing.T) {
+	backend := newTestBackend(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"items": []}`)
+	}))
+
+	start := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 2000; i++ {
+		opts := new(EventSearchOptions).
+			From(start.Add(time.Duration(i) * time.Minute)).
+			To(start.Add(time.Duration(i) * time.Hour))
+
+		_, err := backend.loadEvents(context.Background(), "cal", opts, nil)
+		require.NoError(t, err)
+	}
+
+	assert.Equal(t, int64(0), backend.pendingLoads.Load())
+}
+
+func Test_LoadEvents_ByResource(t *testing.T) {
+	var query string
+
+	backend := newTestBackend(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query().Get("q")
+
+		fmt.Fprint(w, `{"items": [
+			{"id": "1", "start": {"dateTime": "2024-01-01T08:00:00Z"}, "end": {"dateTime": "2024-01-01T09:00:00Z"},
+			 "description": "Surgery\n\n[CIS]\n{\"RequiredResources\": [\"OP 1\"]}"},
+			{"id": "2", "start": {"dateTime": "2024-01-01T09:00:00Z"}, "end": {"dateTime": "2024-01-01T10:00:00Z"},
+			 "description": "mentions OP 1 in the text only"}
+		]}`)
+	}))
+
+	opts := new(EventSearchOptions).From(time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC))
+	WithResource("OP 1")(opts)
+
+	events, err := backend.loadEvents(context.Background(), "cal", opts, nil)
+	require.NoError(t, err)
+
+	assert.Equal(t, "OP 1", query)
+	require.Len(t, events, 1)
+	assert.Equal(t, "1", events[0].ID)
+	assert.Equal(t, "Surgery", events[0].Description)
+}
+
+func Test_LoadEvents_ByQuery(t *testing.T) {
+	var query string
+
+	backend := newTestBackend(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query().Get("q")
+
+		fmt.Fprint(w, `{"items": [
+			{"id": "1", "summary": "Bello Huber", "start": {"dateTime": "2024-01-01T08:00:00Z"}, "end": {"dateTime": "2024-01-01T09:00:00Z"}},
+			{"id": "2", "summary": "Minka", "location": "Huberstraße 1", "start": {"dateTime": "2024-01-01T09:00:00Z"}, "end": {"dateTime": "2024-01-01T10:00:00Z"}}
+		]}`)
+	}))
+
+	opts := new(EventSearchOptions).From(time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC))
+	WithQuery("huber")(opts)
+
+	events, err := backend.loadEvents(context.Background(), "cal", opts, nil)
+	require.NoError(t, err)
+
+	assert.Equal(t, "huber", query)
+	require.Len(t, events, 1)
+	assert.Equal(t, "1", events[0].ID)
+}
+
+func Test_LoadEvents_ReadThrough(t *testing.T) {
+	var timeMax string
+
+	backend := newTestBackend(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		timeMax = r.URL.Query().Get("timeMax")
+
+		fmt.Fprint(w, `{"items": [
+			{"id": "old", "start": {"dateTime": "2022-06-03T08:00:00Z"}, "end": {"dateTime": "2022-06-03T09:00:00Z"}}
+		]}`)
+	}))
+	backend.backfillWindow = 31 * 24 * time.Hour
+
+	midnight := time.Date(2024, time.June, 3, 0, 0, 0, 0, time.UTC)
+
+	cache := &googleEventCache{
+		firstLoadDone: make(chan struct{}),
+		minTime:       midnight,
+		clock:         clock.NewFake(midnight),
+		log:           slog.Default(),
+	}
+	close(cache.firstLoadDone)
+
+	// two years back, loaded with the requested bounds
+	from := midnight.AddDate(-2, 0, 0)
+	opts := new(EventSearchOptions).From(from).To(from.AddDate(0, 0, 1))
+
+	events, err := backend.loadEvents(context.Background(), "cal", opts, cache)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, "old", events[0].ID)
+	assert.Equal(t, from.AddDate(0, 0, 1).Format(time.RFC3339), timeMax)
+
+	// the cache is not extended
+	assert.Equal(t, midnight, cache.currentMinTime())
+	assert.Empty(t, cache.events)
+
+	// queries within the backfill window are written back
+	from = midnight.AddDate(0, 0, -7)
+	opts = new(EventSearchOptions).From(from).To(from.AddDate(0, 0, 1))
+
+	_, err = backend.loadEvents(context.Background(), "cal", opts, cache)
+	require.NoError(t, err)
+	assert.Equal(t, midnight.Format(time.RFC3339), timeMax)
+	assert.Equal(t, from, cache.currentMinTime())
+}
+
+func Test_LoadEvents_ColdCacheIsBounded(t *testing.T) {
+	var timeMax string
+
+	backend := newTestBackend(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		timeMax = r.URL.Query().Get("timeMax")
+
+		fmt.Fprint(w, `{"items": []}`)
+	}))
+	backend.coldLoadWindow = 92 * 24 * time.Hour
+
+	now := time.Date(2024, time.June, 3, 10, 0, 0, 0, time.UTC)
+	backend.clock = clock.NewFake(now)
+
+	cache := &googleEventCache{
+		firstLoadDone: make(chan struct{}),
+		clock:         backend.clock,
+		log:           slog.Default(),
+	}
+
+	// the cache did not finish its first sync yet
+	from := time.Date(2024, time.June, 1, 0, 0, 0, 0, time.UTC)
+
+	ctx, trace := WithCacheTrace(context.Background())
+
+	_, err := backend.loadEvents(ctx, "cal", new(EventSearchOptions).From(from), cache)
+	require.NoError(t, err)
+	assert.Equal(t, from.Add(backend.coldLoadWindow).Format(time.RFC3339), timeMax)
+
+	// the truncation is reported to the caller
+	until, ok := trace.Truncated("cal")
+	assert.True(t, ok)
+	assert.Equal(t, from.Add(backend.coldLoadWindow), until)
+
+	// searches without a start are bounded from now
+	_, err = backend.loadEvents(context.Background(), "cal", new(EventSearchOptions), nil)
+	require.NoError(t, err)
+	assert.Equal(t, now.Add(backend.coldLoadWindow).Format(time.RFC3339), timeMax)
+
+	// explicit bounds are kept
+	to := from.AddDate(1, 0, 0)
+
+	ctx, trace = WithCacheTrace(context.Background())
+
+	_, err = backend.loadEvents(ctx, "cal", new(EventSearchOptions).From(from).To(to), cache)
+	require.NoError(t, err)
+	assert.Equal(t, to.Format(time.RFC3339), timeMax)
+
+	_, ok = trace.Truncated("cal")
+	assert.False(t, ok)
+}
+
+func Test_MatchRank(t *testing.T) {
+	cases := []struct {
+		query, summary, description string
+		expected                    int
+	}{
+		{"huber", "Huber", "", ExactSummaryMatch},
+		{"huber", "Bello Huber", "", SummaryMatch},
+		{"huber", "Bello", "Owner: Mr. Huber", DescriptionMatch},
+		{"huber", "Bello", "", NoMatch},
+		{"", "Bello", "", NoMatch},
+	}
+
+	for _, c := range cases {
+		assert.Equal(t, c.expected, MatchRank(c.query, c.summary, c.description), "query=%q summary=%q", c.query, c.summary)
+	}
+}
+
+func Test_ListCalendars_AccessRole(t *testing.T) {
+	backend := newTestBackend(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"items": [
+			{"id": "owner", "summary": "Owner", "timeZone": "Europe/Vienna", "accessRole": "owner", "primary": true},
+			{"id": "writer", "summary": "Writer", "timeZone": "Europe/Vienna", "accessRole": "writer"},
+			{"id": "reader", "summary": "Reader", "timeZone": "Europe/Vienna", "accessRole": "reader"},
+			{"id": "free-busy", "summary": "Free/Busy", "timeZone": "Europe/Vienna", "accessRole": "freeBusyReader"}
+		]}`)
+	}))
+
+	calendars, err := backend.ListCalendars(context.Background())
+	require.NoError(t, err)
+	require.Len(t, calendars, 4)
+
+	readonly := make(map[string]bool)
+	for _, c := range calendars {
+		readonly[c.ID] = c.Readonly
+	}
+
+	assert.Equal(t, map[string]bool{
+		"owner":     false,
+		"writer":    false,
+		"reader":    true,
+		"free-busy": true,
+	}, readonly)
+
+	assert.True(t, calendars[0].Primary)
+	assert.Equal(t, "owner", calendars[0].AccessRole)
+	assert.False(t, calendars[1].Primary)
+	assert.Equal(t, "freeBusyReader", calendars[3].AccessRole)
+
+	assert.True(t, backend.CanWrite("owner"))
+	assert.True(t, backend.CanWrite("writer"))
+	assert.False(t, backend.CanWrite("reader"))
+	assert.False(t, backend.CanWrite("free-busy"))
+	// calendars that have not been listed are not rejected
+	assert.True(t, backend.CanWrite("unknown"))
+}
+
+func Test_CreateEvent_TagsSource(t *testing.T) {
+	var inserted calendar.Event
+
+	backend := newTestBackend(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			fmt.Fprint(w, `{"items": []}`)
+			return
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&inserted); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		inserted.Id = "1"
+		_ = json.NewEncoder(w).Encode(inserted)
+	}))
+
+	evt, err := backend.CreateEvent(context.Background(), "cal", "Bello", "", time.Date(2024, time.January, 1, 8, 0, 0, 0, time.UTC), time.Hour, nil, WithColor("11"))
+	require.NoError(t, err)
+
+	require.NotNil(t, inserted.ExtendedProperties)
+	assert.Equal(t, map[string]string{
+		"source":     EventSourceCisCal,
+		"apiVersion": SourceAPIVersion,
+	}, inserted.ExtendedProperties.Private)
+	assert.Equal(t, EventSourceCisCal, evt.Source)
+
+	assert.Equal(t, "11", inserted.ColorId)
+	assert.Equal(t, "11", evt.ColorID)
+}
+
+func Test_CreateEvent_Options(t *testing.T) {
+	var inserted calendar.Event
+
+	backend := newTestBackend(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			fmt.Fprint(w, `{"items": []}`)
+			return
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&inserted); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		inserted.Id = "1"
+		_ = json.NewEncoder(w).Encode(inserted)
+	}))
+
+	start := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	evt, err := backend.CreateEvent(context.Background(), "cal", "Holiday", "", start, 48*time.Hour, nil, WithFullDay(), WithImportedFrom("original"), WithSourceChannel("online"))
+	require.NoError(t, err)
+
+	assert.Equal(t, "2024-01-01", inserted.Start.Date)
+	assert.Equal(t, "2024-01-03", inserted.End.Date)
+	assert.Empty(t, inserted.Start.DateTime)
+	assert.Equal(t, "original", inserted.ExtendedProperties.Private[importedFromProperty])
+
+	assert.True(t, evt.FullDayEvent)
+	assert.Equal(t, "original", evt.ImportedFrom)
+	assert.Equal(t, "online", inserted.ExtendedProperties.Private[channelProperty])
+	assert.Equal(t, "online", evt.Channel)
+	assert.True(t, evt.HasChannel("phone", "online"))
+}
+
+func Test_UpdateEvent_Times(t *testing.T) {
+	var updated calendar.Event
+
+	backend := newTestBackend(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			fmt.Fprint(w, `{"items": []}`)
+			return
+		}
+
+		updated = calendar.Event{}
+		if err := json.NewDecoder(r.Body).Decode(&updated); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		updated.Id = "1"
+		_ = json.NewEncoder(w).Encode(updated)
+	}))
+
+	start := time.Date(2024, time.June, 3, 0, 0, 0, 0, time.UTC)
+	at := func(hour int) *time.Time {
+		t := start.Add(time.Duration(hour) * time.Hour)
+		return &t
+	}
+
+	cases := []struct {
+		name           string
+		event          Event
+		start, end     string
+		timed          bool
+		endUnspecified bool
+	}{
+		{"full-day", Event{StartTime: start, EndTime: at(48), FullDayEvent: true}, "2024-06-03", "2024-06-05", false, false},
+		{"full-day without end", Event{StartTime: start, FullDayEvent: true}, "2024-06-03", "2024-06-04", false, false},
+		{"full-day with an end on the same day", Event{StartTime: start, EndTime: at(10), FullDayEvent: true}, "2024-06-03", "2024-06-04", false, false},
+		{"timed", Event{StartTime: *at(10), EndTime: at(11)}, "2024-06-03T10:00:00Z", "2024-06-03T11:00:00Z", true, false},
+		{"timed without end", Event{StartTime: *at(10)}, "2024-06-03T10:00:00Z", "2024-06-03T10:00:00Z", true, true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			c.event.ID = "1"
+			c.event.CalendarID = "cal"
+			c.event.Summary = "Bello"
+
+			evt, err := backend.UpdateEvent(context.Background(), c.event)
+			require.NoError(t, err)
+
+			// date and date-time are never mixed
+			if c.timed {
+				assert.Equal(t, c.start, updated.Start.DateTime)
+				assert.Equal(t, c.end, updated.End.DateTime)
+				assert.Empty(t, updated.Start.Date)
+				assert.Empty(t, updated.End.Date)
+			} else {
+				assert.Equal(t, c.start, updated.Start.Date)
+				assert.Equal(t, c.end, updated.End.Date)
+				assert.Empty(t, updated.Start.DateTime)
+				assert.Empty(t, updated.End.DateTime)
+			}
+
+			assert.Equal(t, c.endUnspecified, updated.EndTimeUnspecified)
+			assert.Equal(t, !c.timed, evt.FullDayEvent)
+			assert.Equal(t, c.endUnspecified, evt.EndTime == nil)
+		})
+	}
+}
+
+func Test_EventSource(t *testing.T) {
+	start := &calendar.EventDateTime{DateTime: "2024-01-01T08:00:00Z"}
+	end := &calendar.EventDateTime{DateTime: "2024-01-01T09:00:00Z"}
+
+	evt, err := googleEventToModel(context.Background(), "cal", &calendar.Event{Id: "1", Start: start, End: end})
+	require.NoError(t, err)
+	assert.Equal(t, EventSourceExternal, evt.Source)
+
+	evt, err = googleEventToModel(context.Background(), "cal", &calendar.Event{
+		Id:                 "2",
+		Start:              start,
+		End:                end,
+		ExtendedProperties: sourceProperties(EventSourceCisCal),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, EventSourceCisCal, evt.Source)
+
+	assert.Nil(t, sourceProperties(EventSourceExternal))
+}
+
+func Test_EventICalUID(t *testing.T) {
+	start := &calendar.EventDateTime{DateTime: "2024-01-01T08:00:00Z"}
+	end := &calendar.EventDateTime{DateTime: "2024-01-01T09:00:00Z"}
+
+	evt, err := googleEventToModel(context.Background(), "vet", &calendar.Event{
+		Id:        "1",
+		ICalUID:   "1@google.com",
+		Start:     start,
+		End:       end,
+		Organizer: &calendar.EventOrganizer{Email: "vet", Self: true},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "1@google.com", evt.ICalUID)
+	assert.False(t, evt.Invited)
+
+	// the copy of an attendee
+	evt, err = googleEventToModel(context.Background(), "nurse", &calendar.Event{
+		Id:        "1",
+		ICalUID:   "1@google.com",
+		Start:     start,
+		End:       end,
+		Organizer: &calendar.EventOrganizer{Email: "vet"},
+	})
+	require.NoError(t, err)
+	assert.True(t, evt.Invited)
+}
+
+func Test_EventTags(t *testing.T) {
+	assert.Equal(t, []string{"surgery", "vaccination"}, NormalizeTags([]string{" Vaccination", "surgery", "", "SURGERY"}))
+
+	props, err := eventProperties(EventSourceExternal, nil, "")
+	require.NoError(t, err)
+	assert.Nil(t, props)
+
+	props, err = eventProperties(EventSourceCisCal, []string{"surgery", "vaccination"}, "")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"source":     EventSourceCisCal,
+		"apiVersion": SourceAPIVersion,
+		"tags":       `["surgery","vaccination"]`,
+	}, props.Private)
+
+	_, err = eventProperties(EventSourceCisCal, []string{strings.Repeat("x", MaxTagsSize)}, "")
+	assert.ErrorIs(t, err, ErrInvalidEvent)
+
+	evt, err := googleEventToModel(context.Background(), "cal", &calendar.Event{
+		Id:                 "1",
+		Start:              &calendar.EventDateTime{DateTime: "2024-01-01T08:00:00Z"},
+		End:                &calendar.EventDateTime{DateTime: "2024-01-01T09:00:00Z"},
+		ExtendedProperties: props,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"surgery", "vaccination"}, evt.Tags)
+	assert.True(t, evt.HasTag("grooming", "surgery"))
+	assert.False(t, evt.HasTag("grooming"))
+}
+
+func Test_EventDescription(t *testing.T) {
+	props, err := eventProperties(EventSourceExternal, nil, "markdown")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"descriptionFormat": "markdown"}, props.Private)
+
+	evt, err := googleEventToModel(context.Background(), "cal", &calendar.Event{
+		Id:                 "1",
+		Description:        "<b>Bello</b><script>alert(1)</script>\n\n[CIS]\n{\"CustomerID\": \"1\"}",
+		Start:              &calendar.EventDateTime{DateTime: "2024-01-01T08:00:00Z"},
+		End:                &calendar.EventDateTime{DateTime: "2024-01-01T09:00:00Z"},
+		ExtendedProperties: props,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "<b>Bello</b>", evt.Description)
+	assert.Equal(t, "markdown", evt.DescriptionFormat)
+	assert.Equal(t, "1", evt.Data.CustomerID)
+}
+
+func Test_EventTypes(t *testing.T) {
+	content, err := os.ReadFile("testdata/event_types.json")
+	require.NoError(t, err)
+
+	var list calendar.Events
+	require.NoError(t, json.Unmarshal(content, &list))
+
+	events := make(map[string]*Event)
+	for _, item := range list.Items {
+		evt, err := googleEventToModel(context.Background(), "cal", item)
+		require.NoError(t, err)
+
+		events[evt.ID] = evt
+	}
+
+	assert.Equal(t, EventTypeDefault, events["default"].EventType)
+	assert.Equal(t, "Bello", events["default"].Summary)
+	assert.False(t, events["default"].IsAbsence())
+
+	assert.Equal(t, EventTypeOutOfOffice, events["ooo"].EventType)
+	assert.Equal(t, DefaultOutOfOfficeSummary, events["ooo"].Summary)
+	assert.True(t, events["ooo"].GeneratedSummary)
+	assert.True(t, events["ooo"].IsAbsence())
+
+	assert.Equal(t, "Urlaub", events["ooo-named"].Summary)
+	assert.False(t, events["ooo-named"].GeneratedSummary)
+
+	assert.Equal(t, EventTypeFocusTime, events["focus"].EventType)
+	assert.Equal(t, DefaultFocusTimeSummary, events["focus"].Summary)
+	assert.True(t, events["focus"].IsAbsence())
+	assert.False(t, events["focus"].IsWorkingLocation())
+
+	assert.Equal(t, EventTypeWorkingLocation, events["home-office"].EventType)
+	assert.True(t, events["home-office"].IsWorkingLocation())
+	assert.False(t, events["home-office"].IsAbsence())
+	assert.False(t, events["home-office"].FullDayEvent)
+}
+
+func Test_EventGoneUpstream(t *testing.T) {
+	// the events have been deleted directly in google, google answers
+	// deletes of deleted events with 410 and everything else with 404.
+	backend := newTestBackend(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status := http.StatusNotFound
+		if r.Method == http.MethodDelete {
+			status = http.StatusGone
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		fmt.Fprintf(w, `{"error": {"code": %d, "message": "Not Found"}}`, status)
+	}))
+
+	start := time.Date(2024, time.June, 3, 8, 0, 0, 0, time.UTC)
+	end := start.Add(time.Hour)
+
+	cache := &googleEventCache{
+		calID:         "cal",
+		firstLoadDone: make(chan struct{}),
+		clock:         clock.NewFake(start),
+		listeners:     &backend.listeners,
+		log:           slog.Default(),
+	}
+	close(cache.firstLoadDone)
+
+	for _, id := range []string{"update", "move", "delete", "idempotent"} {
+		cache.events = append(cache.events, Event{ID: id, CalendarID: "cal", Summary: "Bello " + id, StartTime: start, EndTime: &end})
+	}
+
+	backend.eventsCache["cal"] = cache
+
+	var deleted []string
+	backend.OnChange(func(change *calendarv1.CalendarChangeEvent) {
+		deleted = append(deleted, change.GetDeletedEventId())
+	})
+
+	ctx := context.Background()
+
+	assertGone := func(err error, id string) {
+		t.Helper()
+
+		var connectErr *connect.Error
+		require.ErrorAs(t, err, &connectErr)
+		assert.Equal(t, connect.CodeNotFound, connectErr.Code())
+		assert.Equal(t, "not found: the event no longer exists upstream", connectErr.Message())
+
+		// the last cached version is attached
+		require.Len(t, connectErr.Details(), 1)
+
+		msg, err := connectErr.Details()[0].Value()
+		require.NoError(t, err)
+
+		snapshot, ok := msg.(*calendarv1.CalendarEvent)
+		require.True(t, ok)
+		assert.Equal(t, id, snapshot.Id)
+		assert.Equal(t, "Bello "+id, snapshot.Summary)
+	}
+
+	_, err := backend.UpdateEvent(ctx, Event{ID: "update", CalendarID: "cal", Summary: "Bello", StartTime: start, EndTime: &end})
+	assertGone(err, "update")
+
+	_, err = backend.MoveEvent(ctx, "cal", "move", "other")
+	assertGone(err, "move")
+
+	err = backend.DeleteEvent(ctx, "cal", "delete")
+	assertGone(err, "delete")
+
+	backend.idempotentDeletes = true
+	require.NoError(t, backend.DeleteEvent(ctx, "cal", "idempotent"))
+
+	// the events are removed from the cache and the deletion is reported
+	assert.Empty(t, cache.events)
+	assert.Equal(t, []string{"update", "move", "delete", "idempotent"}, deleted)
+
+	// events that are not cached do not have a snapshot
+	_, err = backend.LoadEvent(ctx, "cal", "unknown", true)
+	assert.Equal(t, connect.CodeNotFound, connect.CodeOf(err))
+	assert.Empty(t, err.(*connect.Error).Details())
+}
+
+func Test_DeleteEvent_PublishesChange(t *testing.T) {
+	backend := newTestBackend(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	start := time.Date(2024, time.June, 3, 8, 0, 0, 0, time.UTC)
+	end := start.Add(time.Hour)
+
+	cache := &googleEventCache{
+		calID:         "cal",
+		firstLoadDone: make(chan struct{}),
+		clock:         clock.NewFake(start),
+		listeners:     &backend.listeners,
+		log:           slog.Default(),
+		events:        []Event{{ID: "cached", CalendarID: "cal", StartTime: start, EndTime: &end}},
+	}
+	close(cache.firstLoadDone)
+
+	backend.eventsCache["cal"] = cache
+
+	var deleted []string
+	backend.OnChange(func(change *calendarv1.CalendarChangeEvent) {
+		deleted = append(deleted, change.GetDeletedEventId())
+	})
+
+	ctx := context.Background()
+
+	// the deletion is published without waiting for the next sync, even
+	// for events that are not cached yet
+	require.NoError(t, backend.DeleteEvent(ctx, "cal", "cached"))
+	require.NoError(t, backend.DeleteEvent(ctx, "cal", "uncached"))
+
+	assert.Empty(t, cache.events)
+	assert.Equal(t, []string{"cached", "uncached"}, deleted)
+}
+
+func Test_WriteReadOnlyCalendar(t *testing.T) {
+	reason := "requiredAccessLevel"
+
+	backend := newTestBackend(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			fmt.Fprint(w, `{"items": []}`)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprintf(w, `{"error": {"code": 403, "message": "Forbidden", "errors": [{"reason": %q}]}}`, reason)
+	}))
+
+	ctx := context.Background()
+	start := time.Date(2024, time.June, 3, 8, 0, 0, 0, time.UTC)
+
+	_, err := backend.CreateEvent(ctx, "cal", "Bello", "", start, time.Hour, nil)
+	assert.ErrorIs(t, err, ErrReadOnly)
+
+	err = backend.DeleteEvent(ctx, "cal", "evt")
+	assert.ErrorIs(t, err, ErrReadOnly)
+
+	_, err = backend.MoveEvent(ctx, "cal", "evt", "other")
+	assert.ErrorIs(t, err, ErrReadOnly)
+	assert.ErrorContains(t, err, "cal or other")
+
+	// rate limits are reported as forbidden as well
+	reason = "rateLimitExceeded"
+
+	err = backend.DeleteEvent(ctx, "cal", "evt")
+	require.Error(t, err)
+	assert.NotErrorIs(t, err, ErrReadOnly)
+}
+
+func Test_CreateCalendar(t *testing.T) {
+	var (
+		calls     []string
+		failShare bool
+	)
+
+	backend := newTestBackend(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls = append(calls, r.Method+" "+r.URL.Path)
+
+		switch {
+		case r.URL.Path == "/calendars":
+			var cal calendar.Calendar
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&cal))
+			assert.Equal(t, "Dr. Maier", cal.Summary)
+			assert.Equal(t, "Europe/Vienna", cal.TimeZone)
+
+			fmt.Fprint(w, `{"id": "new-cal", "summary": "Dr. Maier", "timeZone": "Europe/Vienna"}`)
+
+		case strings.HasSuffix(r.URL.Path, "/acl"):
+			if failShare {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+
+			var rule calendar.AclRule
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&rule))
+			assert.Equal(t, "writer", rule.Role)
+			assert.Equal(t, "group", rule.Scope.Type)
+
+			fmt.Fprint(w, `{}`)
+		}
+	}))
+	backend.calendarTimezone = "Europe/Vienna"
+	backend.shareWith = []config.CalendarShare{{Type: "group", Value: "reception@example.com", Role: "writer"}}
+
+	cal, err := backend.CreateCalendar(context.Background(), "Dr. Maier")
+	require.NoError(t, err)
+	assert.Equal(t, "new-cal", cal.ID)
+	assert.Equal(t, "Dr. Maier", cal.Name)
+	assert.Equal(t, []string{"POST /calendars", "POST /calendars/new-cal/acl"}, calls)
+
+	// the calendar is returned if it could not be shared
+	failShare = true
+
+	cal, err = backend.CreateCalendar(context.Background(), "Dr. Maier")
+	require.Error(t, err)
+	require.NotNil(t, cal)
+	assert.Equal(t, "new-cal", cal.ID)
+}
+
+func Test_UpdateCalendar(t *testing.T) {
+	var calls []string
+
+	backend := newTestBackend(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls = append(calls, r.Method+" "+r.URL.Path)
+
+		switch r.URL.Path {
+		case "/calendars/cal-1":
+			var cal calendar.Calendar
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&cal))
+			assert.Equal(t, "Dr. Maier", cal.Summary)
+
+			fmt.Fprint(w, `{}`)
+
+		case "/users/me/calendarList/cal-1":
+			assert.Equal(t, "true", r.URL.Query().Get("colorRgbFormat"))
+
+			var entry calendar.CalendarListEntry
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&entry))
+			assert.Equal(t, "#1e88e5", entry.BackgroundColor)
+			assert.Equal(t, "#ffffff", entry.ForegroundColor)
+
+			fmt.Fprint(w, `{}`)
+
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+
+	ctx := context.Background()
+
+	require.NoError(t, backend.UpdateCalendar(ctx, "cal-1", CalendarUpdate{Name: "Dr. Maier"}))
+	require.NoError(t, backend.UpdateCalendar(ctx, "cal-1", CalendarUpdate{Color: "#1e88e5"}))
+	assert.Equal(t, []string{"PATCH /calendars/cal-1", "PATCH /users/me/calendarList/cal-1"}, calls)
+
+	err := backend.UpdateCalendar(ctx, "unknown", CalendarUpdate{Name: "Dr. Maier"})
+	assert.Equal(t, connect.CodeNotFound, connect.CodeOf(err))
+}
+
+func Test_ForegroundColor(t *testing.T) {
+	assert.Equal(t, "#ffffff", foregroundColor("#1e88e5"))
+	assert.Equal(t, "#000000", foregroundColor("#fdd835"))
+	assert.Equal(t, "#000000", foregroundColor("invalid"))
+}
@@ -5,18 +5,18 @@ import (
 	"fmt"
 	"log/slog"
 	"net/http"
+	"slices"
 	"sort"
 	"sync"
 	"time"
 
-	connect "github.com/bufbuild/connect-go"
 	calendarv1 "github.com/tierklinik-dobersberg/apis/gen/go/tkd/calendar/v1"
-	eventsv1 "github.com/tierklinik-dobersberg/apis/gen/go/tkd/events/v1"
 	"github.com/tierklinik-dobersberg/apis/gen/go/tkd/events/v1/eventsv1connect"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/clock"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
 	"google.golang.org/api/calendar/v3"
 	"google.golang.org/api/googleapi"
-	"google.golang.org/protobuf/proto"
-	"google.golang.org/protobuf/types/known/anypb"
 )
 
 type googleEventCache struct {
@@ -26,42 +26,104 @@ type googleEventCache struct {
 	firstLoadDone chan struct{}
 	trigger       chan struct{}
 
+	// version increases whenever events of the calendar change. See
+	// bump for details.
+	version int64
+
+	// lastSync is the time of the last successful sync.
+	lastSync time.Time
+
 	calID        string
 	calendarName string
 	events       []Event
 	svc          *calendar.Service
+	quota        *QuotaTracker
 	eventService eventsv1connect.EventServiceClient
+	publisher    *publisher
+	listeners    *changeListeners
 	wg           sync.WaitGroup
+	syncInterval time.Duration
+	maxBackoff   time.Duration
+	clock        clock.Clock
+	clockSkew    time.Duration
 
 	log *slog.Logger
 }
 
+// cacheOptions configures a googleEventCache.
+type cacheOptions struct {
+	// quota records the calls to the google calendar API. It's owned by
+	// the backend.
+	quota *QuotaTracker
+
+	syncInterval time.Duration
+	maxBackoff   time.Duration
+
+	// clock defaults to the system clock.
+	clock clock.Clock
+
+	// publisher tracks change events published by the cache. It's owned
+	// by the backend.
+	publisher *publisher
+
+	// listeners are notified about detected changes. They are owned by
+	// the backend.
+	listeners *changeListeners
+
+	// clockSkew is the tolerance for requests that start slightly before
+	// local midnight, like requests from clients whose clock is behind.
+	// The cache loads and keeps events from midnight minus the tolerance
+	// so those requests can still be served completely.
+	clockSkew time.Duration
+}
+
 func (ec *googleEventCache) String() string {
 	return fmt.Sprintf("Cache<%s>", ec.calID)
 }
 
 // nolint:unparam
-func newCache(ctx context.Context, id string, name string, svc *calendar.Service, eventCli eventsv1connect.EventServiceClient) (*googleEventCache, error) {
+func newCache(ctx context.Context, id string, name string, svc *calendar.Service, eventCli eventsv1connect.EventServiceClient, opts cacheOptions) (*googleEventCache, error) {
+	if opts.clock == nil {
+		opts.clock = clock.Real{}
+	}
+
 	cache := &googleEventCache{
 		calID:         id,
 		calendarName:  name,
 		svc:           svc,
+		quota:         opts.quota,
 		firstLoadDone: make(chan struct{}),
 		trigger:       make(chan struct{}),
 		eventService:  eventCli,
+		publisher:     opts.publisher,
+		listeners:     opts.listeners,
+		syncInterval:  opts.syncInterval,
+		maxBackoff:    opts.maxBackoff,
+		clock:         opts.clock,
+		clockSkew:     opts.clockSkew,
 		log:           slog.With("calendar", name, "id", id),
 	}
 
 	cache.wg.Add(2)
 
+	// the first sync runs in the background, until it's done
+	// tryLoadFromCache reports a cache miss.
 	go cache.watch(ctx)
 	go cache.evicter(ctx)
 
-	<-cache.firstLoadDone
-
 	return cache, nil
 }
 
+// ready reports whether the first sync of the cache has finished.
+func (ec *googleEventCache) ready() bool {
+	select {
+	case <-ec.firstLoadDone:
+		return true
+	default:
+		return false
+	}
+}
+
 func (ec *googleEventCache) triggerSync() {
 	select {
 	case ec.trigger <- struct{}{}:
@@ -72,21 +134,21 @@ func (ec *googleEventCache) triggerSync() {
 func (ec *googleEventCache) watch(ctx context.Context) {
 	defer ec.wg.Done()
 
-	waitTime := time.Minute
+	waitTime := ec.syncInterval
 	firstLoad := true
 	for {
 		success := ec.loadEvents(ctx)
 
 		if success {
-			waitTime = time.Minute
+			waitTime = ec.syncInterval
 		} else {
 			// in case of consecutive failures do some exponential backoff
 			waitTime = 2 * waitTime
 		}
 
-		// cap at max 30 minute wait time
-		if waitTime > time.Minute*30 {
-			waitTime = time.Minute * 30
+		// cap at the configured maximum backoff
+		if waitTime > ec.maxBackoff {
+			waitTime = ec.maxBackoff
 		}
 
 		if firstLoad {
@@ -107,12 +169,20 @@ func (ec *googleEventCache) loadEvents(ctx context.Context) bool {
 	ec.rw.Lock()
 	defer ec.rw.Unlock()
 
+	// events are only counted as new if they show up in an incremental
+	// sync, the initial load contains all existing events.
+	incremental := ec.syncToken != ""
+
 	call := ec.svc.Events.List(ec.calID)
 	if ec.syncToken == "" {
+		now := ec.clock.Now().Local()
+
+		// events may have changed while we did not have a sync token
+		ec.bump(now)
+
 		ec.events = nil
-		now := time.Now().Local()
 		currentMidnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
-		ec.minTime = currentMidnight
+		ec.minTime = currentMidnight.Add(-ec.clockSkew)
 
 		call.ShowDeleted(false).SingleEvents(false).TimeMin(ec.minTime.Format(time.RFC3339))
 	} else {
@@ -126,7 +196,7 @@ func (ec *googleEventCache) loadEvents(ctx context.Context) bool {
 			call.PageToken(pageToken)
 		}
 
-		res, err := call.Context(ctx).Do()
+		res, err := googleAPI{svc: ec.svc, quota: ec.quota}.ListEvents(WithQuotaCaller(ctx, CallerCacheSync), call)
 		if err != nil {
 			if apiErr, ok := err.(*googleapi.Error); ok && apiErr.Code == http.StatusGone {
 				// start over without a sync token
@@ -148,6 +218,19 @@ func (ec *googleEventCache) loadEvents(ctx context.Context) bool {
 				continue
 			}
 
+			if incremental {
+				updated, err := time.Parse(time.RFC3339, item.Updated)
+				if err != nil {
+					updated = ec.clock.Now()
+				}
+
+				ec.bump(updated)
+			}
+
+			if incremental && change == "created" {
+				eventsCreatedCounter.Add(ctx, 1, metric.WithAttributes(attribute.String("source", evt.Source)))
+			}
+
 			req := &calendarv1.CalendarChangeEvent{
 				Calendar: ec.calID,
 			}
@@ -169,7 +252,8 @@ func (ec *googleEventCache) loadEvents(ctx context.Context) bool {
 			}
 
 			if req.Kind != nil {
-				PublishEvent(ec.eventService, req, false)
+				ec.publisher.PublishEvent(ctx, ec.eventService, req, false)
+				ec.listeners.notify(req)
 			}
 		}
 		updatesProcessed += len(res.Items)
@@ -202,6 +286,7 @@ func (ec *googleEventCache) loadEvents(ctx context.Context) bool {
 	}
 
 	sort.Sort(ByStartTime(ec.events))
+	ec.lastSync = ec.clock.Now()
 
 	return true
 }
@@ -235,6 +320,12 @@ func (ec *googleEventCache) syncEvent(ctx context.Context, item *calendar.Event)
 		return evt, "updated"
 	}
 
+	// deletions of events that are not cached, like events that have
+	// already been removed by forget, have nothing to sync.
+	if item.Start == nil && item.Status == "cancelled" {
+		return nil, ""
+	}
+
 	evt, err := googleEventToModel(ctx, ec.calID, item)
 	if err != nil {
 		ec.log.Error("failed to convert event", "event-id", item.Id, "error", err)
@@ -245,6 +336,76 @@ func (ec *googleEventCache) syncEvent(ctx context.Context, item *calendar.Event)
 	return evt, "created"
 }
 
+// bump increases the version of the cache to the unix milliseconds of t or,
+// if that is not greater than the current version, by one. Using the time
+// keeps the version increasing across restarts. The caller must hold ec.rw.
+func (ec *googleEventCache) bump(t time.Time) {
+	v := t.UnixMilli()
+	if v <= ec.version {
+		v = ec.version + 1
+	}
+
+	ec.version = v
+}
+
+// touch bumps the version of the cache after events have been modified
+// through this service, before the change shows up in the next sync.
+func (ec *googleEventCache) touch() {
+	ec.rw.Lock()
+	defer ec.rw.Unlock()
+
+	ec.bump(ec.clock.Now())
+}
+
+// forget removes a deleted event from the cache before the next sync, like
+// an event deleted through this service or directly in google. The deletion
+// is published as during a sync. It returns the last cached version of the
+// event.
+func (ec *googleEventCache) forget(ctx context.Context, eventID string) (*Event, bool) {
+	ec.rw.Lock()
+	defer ec.rw.Unlock()
+
+	idx := slices.IndexFunc(ec.events, func(e Event) bool {
+		return e.ID == eventID
+	})
+	if idx < 0 {
+		return nil, false
+	}
+
+	evt := ec.events[idx]
+	ec.events = slices.Delete(ec.events, idx, idx+1)
+	ec.bump(ec.clock.Now())
+
+	ec.publishDeleted(ctx, eventID)
+
+	ec.log.Info("removed deleted event from cache", "event-id", eventID)
+
+	return &evt, true
+}
+
+// publishDeleted publishes the deletion of an event right away instead of
+// waiting for the next sync to detect it.
+func (ec *googleEventCache) publishDeleted(ctx context.Context, eventID string) {
+	req := &calendarv1.CalendarChangeEvent{
+		Calendar: ec.calID,
+		Kind: &calendarv1.CalendarChangeEvent_DeletedEventId{
+			DeletedEventId: eventID,
+		},
+	}
+
+	// the request that deleted the event may be done before the event is
+	// published.
+	ec.publisher.PublishEvent(context.WithoutCancel(ctx), ec.eventService, req, false)
+	ec.listeners.notify(req)
+}
+
+func (ec *googleEventCache) currentVersion() int64 {
+	ec.rw.RLock()
+	defer ec.rw.RUnlock()
+
+	return ec.version
+}
+
 func (ec *googleEventCache) evicter(ctx context.Context) {
 	defer ec.wg.Done()
 
@@ -262,8 +423,9 @@ func (ec *googleEventCache) evicter(ctx context.Context) {
 }
 
 func (ec *googleEventCache) evictEvents() {
-	now := time.Now().Local()
+	now := ec.clock.Now().Local()
 	currentMidnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+	cutoff := currentMidnight.Add(-ec.clockSkew)
 
 	ec.rw.Lock()
 	defer ec.rw.Unlock()
@@ -278,19 +440,22 @@ func (ec *googleEventCache) evictEvents() {
 	filtered := make([]Event, 0, len(ec.events))
 
 	for _, evt := range ec.events {
-		if !currentMidnight.Before(evt.StartTime) {
+		// keep events that start today (minus the clock skew) or later ...
+		if !evt.StartTime.Before(cutoff) {
 			filtered = append(filtered, evt)
 			continue
 		}
 
-		if evt.EndTime != nil && !evt.EndTime.Before(currentMidnight) {
+		// ... or are still running
+
+		if evt.EndTime != nil && !evt.EndTime.Before(cutoff) {
 			filtered = append(filtered, evt)
 			continue
 		}
 	}
 
 	ec.events = filtered
-	ec.minTime = currentMidnight
+	ec.minTime = cutoff
 
 	if len(filtered) > 0 {
 		ec.log.Info("evicted events from cache", "evicted", countBefore-len(filtered), "cache-start-time", ec.minTime.Format(time.RFC3339), "cache-size", len(ec.events))
@@ -341,12 +506,19 @@ func (ec *googleEventCache) tryLoadFromCache(ctx context.Context, search *EventS
 		ec.log.Info("not using cache: search.from == nil")
 		return nil, false
 	}
+	if !ec.ready() {
+		ec.log.Info("not using cache: first sync not yet completed")
+		return nil, false
+	}
 
 	ec.rw.RLock()
 	defer ec.rw.RUnlock()
 
-	if search.FromTime.Before(ec.minTime) && !ec.minTime.IsZero() {
-		ec.log.Info("not using cache: search.from is before minTime", "search-time", search.FromTime, "min-time", ec.minTime)
+	// the cache starts at local midnight of the time it has been loaded,
+	// minus the clock skew tolerance, so requests from clients whose clock
+	// is slightly behind are still served completely.
+	if !ec.minTime.IsZero() && search.FromTime.Before(ec.minTime) {
+		ec.log.Info("not using cache: search.from is before minTime", "search-time", search.FromTime, "min-time", ec.minTime, "clock-skew", ec.clockSkew)
 
 		return nil, false
 	}
@@ -368,6 +540,30 @@ func (ec *googleEventCache) tryLoadFromCache(ctx context.Context, search *EventS
 			matches = false
 		}
 
+		if search.Resource != nil && !evt.HasResource(*search.Resource) {
+			matches = false
+		}
+
+		if search.Query != nil && !evt.EventMatches(*search.Query) {
+			matches = false
+		}
+
+		if search.CustomerID != nil && !evt.HasCustomer(*search.CustomerID) {
+			matches = false
+		}
+
+		if len(search.Tags) > 0 && !evt.HasTag(search.Tags...) {
+			matches = false
+		}
+
+		if len(search.Statuses) > 0 && !evt.HasStatus(search.Statuses...) {
+			matches = false
+		}
+
+		if len(search.Channels) > 0 && !evt.HasChannel(search.Channels...) {
+			matches = false
+		}
+
 		if matches {
 			if search.EventID != nil {
 				if evt.ID == *search.EventID {
@@ -384,20 +580,3 @@ func (ec *googleEventCache) tryLoadFromCache(ctx context.Context, search *EventS
 
 	return res, true
 }
-
-func PublishEvent(events eventsv1connect.EventServiceClient, msg proto.Message, retained bool) {
-	go func() {
-		pb, err := anypb.New(msg)
-		if err != nil {
-			slog.Error("failed to marshal protobuf message as anypb.Any", "error", err, "messageType", proto.MessageName(msg))
-			return
-		}
-
-		if _, err := events.Publish(context.Background(), connect.NewRequest(&eventsv1.Event{
-			Event:    pb,
-			Retained: retained,
-		})); err != nil {
-			slog.Error("failed to publish event", "error", err, "messageType", proto.MessageName(msg))
-		}
-	}()
-}
@@ -0,0 +1,247 @@
+package repo
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	eventsv1 "github.com/tierklinik-dobersberg/apis/gen/go/tkd/events/v1"
+	"github.com/tierklinik-dobersberg/apis/gen/go/tkd/events/v1/eventsv1connect"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/clock"
+	"google.golang.org/api/calendar/v3"
+	"google.golang.org/api/option"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+func Test_NewCache_DoesNotBlock(t *testing.T) {
+	release := make(chan struct{})
+
+	// a google calendar API that never answers until the test is done.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	t.Cleanup(srv.Close)
+	t.Cleanup(func() { close(release) })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	svc, err := calendar.NewService(ctx, option.WithHTTPClient(srv.Client()), option.WithEndpoint(srv.URL))
+	require.NoError(t, err)
+
+	done := make(chan *googleEventCache)
+	go func() {
+		cache, err := newCache(ctx, "cal", "cal", svc, nil, cacheOptions{syncInterval: time.Minute, maxBackoff: time.Minute})
+		assert.NoError(t, err)
+
+		done <- cache
+	}()
+
+	select {
+	case cache := <-done:
+		assert.False(t, cache.ready())
+
+		_, ok := cache.tryLoadFromCache(ctx, new(EventSearchOptions).From(time.Now()))
+		assert.False(t, ok, "cache must not serve requests before the first sync")
+
+	case <-time.After(time.Second):
+		t.Fatal("newCache blocked on the first sync")
+	}
+}
+
+// discardEvents is an events service client that drops all events.
+type discardEvents struct {
+	eventsv1connect.EventServiceClient
+}
+
+func (discardEvents) Publish(context.Context, *connect.Request[eventsv1.Event]) (*connect.Response[emptypb.Empty], error) {
+	return connect.NewResponse(new(emptypb.Empty)), nil
+}
+
+func Test_CalendarVersion(t *testing.T) {
+	var (
+		l       sync.Mutex
+		pending []string
+	)
+
+	backend := newTestBackend(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		event := `{"id": "1", "updated": "%s", "start": {"dateTime": "2024-01-01T08:00:00Z"}, "end": {"dateTime": "2024-01-01T09:00:00Z"}}`
+
+		switch r.Method {
+		case http.MethodGet:
+			l.Lock()
+			items := strings.Join(pending, ",")
+			pending = nil
+			l.Unlock()
+
+			fmt.Fprintf(w, `{"items": [%s], "nextSyncToken": "token"}`, items)
+
+		case http.MethodDelete:
+			w.WriteHeader(http.StatusNoContent)
+
+		default:
+			updated := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+
+			l.Lock()
+			pending = append(pending, fmt.Sprintf(event, updated))
+			l.Unlock()
+
+			fmt.Fprintf(w, event, updated)
+		}
+	}))
+
+	backend.EventsClient = discardEvents{}
+
+	ctx := context.Background()
+
+	v0, err := backend.CalendarVersion(ctx, "cal")
+	require.NoError(t, err)
+	assert.NotZero(t, v0)
+
+	evt, err := backend.CreateEvent(ctx, "cal", "Bello", "", time.Date(2024, time.January, 1, 8, 0, 0, 0, time.UTC), time.Hour, nil)
+	require.NoError(t, err)
+	assert.False(t, evt.UpdateTime.IsZero())
+
+	v1, err := backend.CalendarVersion(ctx, "cal")
+	require.NoError(t, err)
+	assert.Greater(t, v1, v0)
+
+	// the triggered sync picks up the change with a later update time
+	assert.Eventually(t, func() bool {
+		v, err := backend.CalendarVersion(ctx, "cal")
+		return err == nil && v > v1
+	}, time.Second, 10*time.Millisecond)
+
+	v2, err := backend.CalendarVersion(ctx, "cal")
+	require.NoError(t, err)
+
+	_, err = backend.UpdateEvent(ctx, *evt)
+	require.NoError(t, err)
+
+	v3, err := backend.CalendarVersion(ctx, "cal")
+	require.NoError(t, err)
+	assert.Greater(t, v3, v2)
+
+	require.NoError(t, backend.DeleteEvent(ctx, "cal", evt.ID))
+
+	v4, err := backend.CalendarVersion(ctx, "cal")
+	require.NoError(t, err)
+	assert.Greater(t, v4, v3)
+}
+
+func Test_EvictEvents(t *testing.T) {
+	now := time.Date(2024, 6, 3, 12, 0, 0, 0, time.Local)
+	midnight := time.Date(2024, 6, 3, 0, 0, 0, 0, time.Local)
+
+	ec := &googleEventCache{
+		clock: clock.NewFake(now),
+		log:   slog.Default(),
+	}
+
+	for i := 0; i < 500; i++ {
+		start := midnight.Add(time.Duration(i-250) * time.Hour)
+		end := start.Add(30 * time.Minute)
+
+		ec.events = append(ec.events, Event{ID: strconv.Itoa(i), StartTime: start, EndTime: &end})
+	}
+
+	// still running at midnight
+	end := midnight.Add(time.Hour)
+	ec.events = append(ec.events, Event{ID: "running", StartTime: midnight.Add(-time.Hour), EndTime: &end})
+
+	ec.evictEvents()
+
+	assert.Len(t, ec.events, 251)
+	assert.Equal(t, midnight, ec.minTime)
+
+	for _, evt := range ec.events {
+		assert.True(t, evt.EndTime.After(midnight), evt.ID)
+	}
+
+	// caches with less than 500 events are not evicted
+	ec.clock.(*clock.Fake).Advance(48 * time.Hour)
+	ec.evictEvents()
+	assert.Len(t, ec.events, 251)
+}
+
+func Test_TryLoadFromCache_ClockSkew(t *testing.T) {
+	midnight := time.Date(2024, 6, 3, 0, 0, 0, 0, time.Local)
+
+	var timeMin string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		timeMin = r.URL.Query().Get("timeMin")
+
+		// an event that ends within the clock skew tolerance before midnight
+		fmt.Fprintf(w, `{"items": [{"id": "late", "start": {"dateTime": "%s"}, "end": {"dateTime": "%s"}}], "nextSyncToken": "token"}`,
+			midnight.Add(-4*time.Minute).Format(time.RFC3339),
+			midnight.Add(-2*time.Minute).Format(time.RFC3339),
+		)
+	}))
+	t.Cleanup(srv.Close)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	svc, err := calendar.NewService(ctx, option.WithHTTPClient(srv.Client()), option.WithEndpoint(srv.URL))
+	require.NoError(t, err)
+
+	ec, err := newCache(ctx, "cal", "cal", svc, nil, cacheOptions{
+		syncInterval: time.Minute,
+		maxBackoff:   time.Minute,
+		clock:        clock.NewFake(midnight.Add(8 * time.Hour)),
+		clockSkew:    5 * time.Minute,
+	})
+	require.NoError(t, err)
+
+	require.Eventually(t, ec.ready, time.Second, 10*time.Millisecond)
+
+	// the upstream load covers the tolerance before midnight
+	assert.Equal(t, midnight.Add(-5*time.Minute).Format(time.RFC3339), timeMin)
+
+	// a client whose clock is three minutes behind
+	events, ok := ec.tryLoadFromCache(ctx, new(EventSearchOptions).From(midnight.Add(-3*time.Minute)))
+	require.True(t, ok)
+	require.Len(t, events, 1)
+	assert.Equal(t, "late", events[0].ID)
+
+	_, ok = ec.tryLoadFromCache(ctx, new(EventSearchOptions).From(midnight.Add(-10*time.Minute)))
+	assert.False(t, ok)
+}
+
+func Test_EvictEvents_ClockSkew(t *testing.T) {
+	midnight := time.Date(2024, 6, 3, 0, 0, 0, 0, time.Local)
+
+	ec := &googleEventCache{
+		clock:     clock.NewFake(midnight.Add(12 * time.Hour)),
+		clockSkew: 5 * time.Minute,
+		log:       slog.Default(),
+	}
+
+	for i := 0; i < 500; i++ {
+		start := midnight.Add(time.Duration(i) * time.Hour)
+		end := start.Add(30 * time.Minute)
+
+		ec.events = append(ec.events, Event{ID: strconv.Itoa(i), StartTime: start, EndTime: &end})
+	}
+
+	end := midnight.Add(-2 * time.Minute)
+	ec.events = append(ec.events, Event{ID: "late", StartTime: midnight.Add(-4 * time.Minute), EndTime: &end})
+
+	ec.evictEvents()
+
+	assert.Equal(t, midnight.Add(-5*time.Minute), ec.minTime)
+	assert.Len(t, ec.events, 501)
+}
@@ -0,0 +1,70 @@
+package repo
+
+import (
+	"context"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// upstreamLimiter limits the number of concurrent upstream requests per
+// calendar and across all calendars.
+type upstreamLimiter struct {
+	perCalendar int
+	global      chan struct{}
+
+	l         sync.Mutex
+	calendars map[string]chan struct{}
+}
+
+func newUpstreamLimiter(perCalendar, global int) *upstreamLimiter {
+	return &upstreamLimiter{
+		perCalendar: perCalendar,
+		global:      make(chan struct{}, global),
+		calendars:   make(map[string]chan struct{}),
+	}
+}
+
+func (ul *upstreamLimiter) semaphoreFor(calID string) chan struct{} {
+	ul.l.Lock()
+	defer ul.l.Unlock()
+
+	sem, ok := ul.calendars[calID]
+	if !ok {
+		sem = make(chan struct{}, ul.perCalendar)
+		ul.calendars[calID] = sem
+	}
+
+	return sem
+}
+
+// acquire waits until a slot for calID is available or ctx is cancelled.
+// The returned release function must be called once the upstream request
+// finished.
+func (ul *upstreamLimiter) acquire(ctx context.Context, calID string) (func(), error) {
+	sem := ul.semaphoreFor(calID)
+	attrs := metric.WithAttributes(attribute.String("calendar.id", calID))
+
+	upstreamQueueDepth.Add(ctx, 1, attrs)
+	defer upstreamQueueDepth.Add(ctx, -1, attrs)
+
+	select {
+	case sem <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	select {
+	case ul.global <- struct{}{}:
+	case <-ctx.Done():
+		<-sem
+
+		return nil, ctx.Err()
+	}
+
+	return func() {
+		<-ul.global
+		<-sem
+	}, nil
+}
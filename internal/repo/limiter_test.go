@@ -0,0 +1,102 @@
+package repo
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_LoadEvents_ConcurrencyBound(t *testing.T) {
+	var (
+		l           sync.Mutex
+		inFlight    = make(map[string]int)
+		maxPerCal   = make(map[string]int)
+		total       int
+		maxTotal    int
+		requestSeen int
+	)
+
+	backend := newTestBackend(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// path is /calendars/{calendarId}/events
+		calID := strings.Split(strings.TrimPrefix(r.URL.Path, "/calendars/"), "/")[0]
+
+		l.Lock()
+		requestSeen++
+		inFlight[calID]++
+		total++
+		maxPerCal[calID] = max(maxPerCal[calID], inFlight[calID])
+		maxTotal = max(maxTotal, total)
+		l.Unlock()
+
+		time.Sleep(10 * time.Millisecond)
+
+		l.Lock()
+		inFlight[calID]--
+		total--
+		l.Unlock()
+
+		fmt.Fprint(w, `{"items": []}`)
+	}))
+	backend.limiter = newUpstreamLimiter(2, 4)
+
+	base := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 60; i++ {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			opts := new(EventSearchOptions).ForDay(base.AddDate(0, 0, i))
+
+			_, err := backend.loadEvents(context.Background(), fmt.Sprintf("cal-%d", i%3), opts, nil)
+			assert.NoError(t, err)
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Equal(t, 60, requestSeen)
+	assert.LessOrEqual(t, maxTotal, 4)
+	for calID, m := range maxPerCal {
+		assert.LessOrEqual(t, m, 2, calID)
+	}
+	assert.Empty(t, backend.limiter.global)
+}
+
+func Test_UpstreamLimiter_Cancel(t *testing.T) {
+	limiter := newUpstreamLimiter(1, 1)
+
+	release, err := limiter.acquire(context.Background(), "cal")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	_, err = limiter.acquire(ctx, "cal")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// a different calendar waits for the global slot
+	ctx2, cancel2 := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel2()
+
+	_, err = limiter.acquire(ctx2, "other")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	release()
+
+	release, err = limiter.acquire(context.Background(), "other")
+	require.NoError(t, err)
+	release()
+
+	assert.Empty(t, limiter.global)
+	assert.Empty(t, limiter.semaphoreFor("cal"))
+	assert.Empty(t, limiter.semaphoreFor("other"))
+}
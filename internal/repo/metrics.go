@@ -0,0 +1,48 @@
+package repo
+
+import (
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+var (
+	meter = otel.Meter("")
+
+	// eventLoadCounter counts upstream event loads, either executed or
+	// deduplicated by the load group.
+	eventLoadCounter, _ = meter.Int64Counter(
+		"calendar.google.event_loads",
+		metric.WithDescription("Number of upstream event loads by result (executed or deduplicated)"),
+	)
+
+	loadExecuted     = metric.WithAttributes(attribute.String("result", "executed"))
+	loadDeduplicated = metric.WithAttributes(attribute.String("result", "deduplicated"))
+)
+
+var (
+	// upstreamQueueDepth tracks the number of requests waiting for a free
+	// upstream slot.
+	upstreamQueueDepth, _ = meter.Int64UpDownCounter(
+		"calendar.google.upstream_queue_depth",
+		metric.WithDescription("Number of upstream requests waiting for a free concurrency slot"),
+	)
+)
+
+var (
+	// eventsCreatedCounter counts new events seen during incremental cache
+	// syncs by their source (cis-cal or external).
+	eventsCreatedCounter, _ = meter.Int64Counter(
+		"calendar.events_created",
+		metric.WithDescription("Number of newly created events by source (cis-cal or external)"),
+	)
+)
+
+var (
+	// googleAPICallCounter counts google calendar API calls by method,
+	// caller and result.
+	googleAPICallCounter, _ = meter.Int64Counter(
+		"calendar.google.api_calls",
+		metric.WithDescription("Number of google calendar API calls by method, caller and result"),
+	)
+)
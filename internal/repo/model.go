@@ -5,17 +5,82 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"slices"
 	"strings"
 	"time"
 
 	"github.com/sirupsen/logrus"
 	calendarv1 "github.com/tierklinik-dobersberg/apis/gen/go/tkd/calendar/v1"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/richtext"
 	"google.golang.org/api/calendar/v3"
 	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/structpb"
 	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
-var ErrInvalidEvent = errors.New("invalid event")
+var (
+	ErrInvalidEvent = errors.New("invalid event")
+
+	// ErrReadOnly is returned if events of a calendar that is read-only
+	// for the service are written.
+	ErrReadOnly = errors.New("calendar is read-only")
+
+	// ErrNotFound is returned for calendars and events that do not exist
+	// (anymore).
+	ErrNotFound = errors.New("not found")
+)
+
+// Event sources. Events created through the calendar service are tagged
+// with a private extended property, all other events have been created
+// directly in the calendar backend.
+const (
+	EventSourceCisCal   = "cis-cal"
+	EventSourceExternal = "external"
+
+	// SourceAPIVersion is written along the source property.
+	SourceAPIVersion = "calendar/v1"
+
+	sourceProperty     = "source"
+	apiVersionProperty = "apiVersion"
+
+	// tagsProperty holds the JSON encoded tags of an event.
+	tagsProperty = "tags"
+
+	// descriptionFormatProperty holds the format the description has been
+	// written in if it is not HTML.
+	descriptionFormatProperty = "descriptionFormat"
+
+	// importedFromProperty holds the id of the event an event has been
+	// restored from by a calendar import.
+	importedFromProperty = "importedFrom"
+
+	// channelProperty holds the name of the channel, like an online
+	// booking portal, an event has been created through.
+	channelProperty = "channel"
+)
+
+// Google calendar event types. Events without an event type are default
+// events.
+const (
+	EventTypeDefault     = "default"
+	EventTypeOutOfOffice = "outOfOffice"
+	EventTypeFocusTime   = "focusTime"
+
+	// EventTypeWorkingLocation marks where a user works, like a home
+	// office. Those events never block the calendar.
+	EventTypeWorkingLocation = "workingLocation"
+)
+
+// Default summaries for absence events without a summary.
+const (
+	DefaultOutOfOfficeSummary = "Abwesend"
+	DefaultFocusTimeSummary   = "Fokuszeit"
+)
+
+// MaxTagsSize is the maximum size of the JSON encoded tags of an event.
+// Google limits the size of extended property values to 1024 bytes, larger
+// tags are split across multiple properties.
+const MaxTagsSize = 4 * maxPropertyValueSize
 
 type Calendar struct {
 	ID       string
@@ -23,6 +88,25 @@ type Calendar struct {
 	Timezone string
 	Location *time.Location
 	Color    string
+
+	// AccessRole is the access role of the service account as reported by
+	// the calendar backend (owner, writer, reader or freeBusyReader).
+	AccessRole string
+
+	// Primary is set if the calendar is the primary calendar of the account.
+	Primary bool
+
+	// Readonly is set if events of the calendar cannot be created, updated
+	// or deleted.
+	Readonly bool
+
+	// Hidden is set if the calendar has been hidden from the calendar list
+	// of the account.
+	Hidden bool
+
+	// Backend is the name of the backend that owns the calendar. It is set
+	// by the Registry.
+	Backend string
 }
 
 type Event struct {
@@ -35,17 +119,111 @@ type Event struct {
 	FullDayEvent bool
 	Data         *StructuredEvent
 	IsFree       bool
+
+	// Transparent is set for events that do not block time in the
+	// calendar, like reminders.
+	Transparent bool
+
+	// IsShift is set for synthetic events that mark the boundaries
+	// of a working window.
+	IsShift bool
+
+	// Slot is set for free-slot and shift events and describes the
+	// user and shift the event belongs to.
+	Slot *FreeSlotInfo
+
+	// OverlayOf is set to the source calendar id for read-only events
+	// that have been merged from an overlay calendar.
+	OverlayOf string
+
+	// Source is either EventSourceCisCal or EventSourceExternal.
+	Source string
+
+	// Tags categorize the event, like surgery or vaccination. Tags are
+	// normalized using NormalizeTags.
+	Tags []string
+
+	// EventType is the google calendar event type, like EventTypeDefault
+	// or EventTypeOutOfOffice.
+	EventType string
+
+	// GeneratedSummary is set if the event does not have a summary and
+	// one of the default summaries is used instead.
+	GeneratedSummary bool
+
+	// ColorID is the google calendar event color ("1" to "11"). Events
+	// without a color use the color of their calendar.
+	ColorID string
+
+	// DescriptionFormat is the format the description has been written in.
+	// The description itself is always stored as sanitized HTML. An empty
+	// value is richtext.FormatHTML.
+	DescriptionFormat string
+
+	// Etag is the google calendar etag of the event. It changes whenever
+	// the event is modified.
+	Etag string
+
+	// UpdateTime is the time the event has last been modified.
+	UpdateTime time.Time
+
+	// ImportedFrom is the id of the event this event has been restored
+	// from by a calendar import.
+	ImportedFrom string
+
+	// ICalUID is the iCalendar UID of the event. It is kept when the event
+	// is moved to another calendar and shared by all copies of an event
+	// with attendees.
+	ICalUID string
+
+	// Invited is set for the copy of an event that has been organized in
+	// another calendar, like an invitation of the calendar owner.
+	Invited bool
+
+	// Channel is the name of the channel the event has been created
+	// through, like an online booking portal.
+	Channel string
+
+	// Status is the appointment status, like StatusArrived. Planned
+	// events have an empty status. StatusChangedBy and StatusChangedAt
+	// record who changed the status and when.
+	Status          string
+	StatusChangedBy string
+	StatusChangedAt time.Time
+
+	// Visibility is the visibility of the event, like VisibilityPrivate.
+	// Events with the default visibility of their calendar have an empty
+	// visibility.
+	Visibility string
+}
+
+// FreeSlotInfo describes the owner and shift of a free slot or shift event.
+type FreeSlotInfo struct {
+	UserID      string
+	ShiftID     string
+	WorkShiftID string
+	ShiftName   string
 }
 
 type EventList []Event
 
 func (el EventList) Len() int { return len(el) }
+
+// Less orders events by their start time and then by their end time.
+// Events without an end time sort after all events with one.
 func (el EventList) Less(i, j int) bool {
-	if el[i].StartTime.Before(el[j].StartTime) {
-		return true
+	if !el[i].StartTime.Equal(el[j].StartTime) {
+		return el[i].StartTime.Before(el[j].StartTime)
 	}
 
-	return el[i].EndTime.Before(*el[j].EndTime)
+	switch a, b := el[i].EndTime, el[j].EndTime; {
+	case a == nil:
+		return false
+	case b == nil:
+		return true
+	default:
+		return a.Before(*b)
+	}
 }
 func (el EventList) Swap(i, j int) {
 	el[i], el[j] = el[j], el[i]
@@ -63,6 +241,27 @@ type EventSearchOptions struct {
 	FromTime *time.Time
 	ToTime   *time.Time
 	EventID  *string
+	Resource *string
+	Query    *string
+
+	CustomerID *string
+
+	// Tags limits the search to events that have at least one of the tags.
+	Tags []string
+
+	// Statuses limits the search to events with one of the appointment
+	// statuses.
+	Statuses []string
+
+	// Channels limits the search to events created through one of the
+	// channels.
+	Channels []string
+}
+
+// filtered reports whether the search filters events by anything else than
+// the time range or event id.
+func (s *EventSearchOptions) filtered() bool {
+	return s.Resource != nil || s.Query != nil || s.CustomerID != nil || len(s.Tags) > 0 || len(s.Statuses) > 0 || len(s.Channels) > 0
 }
 
 func (s *EventSearchOptions) From(t time.Time) *EventSearchOptions {
@@ -102,6 +301,185 @@ func WithEventId(id string) SearchOption {
 	}
 }
 
+// WithResource limits the search to events that require the given resource.
+func WithResource(name string) SearchOption {
+	return func(eso *EventSearchOptions) {
+		eso.Resource = &name
+	}
+}
+
+// WithQuery limits the search to events that match the free-text query.
+// See MatchRank for details.
+func WithQuery(query string) SearchOption {
+	return func(eso *EventSearchOptions) {
+		eso.Query = &query
+	}
+}
+
+// WithCustomerID limits the search to events booked for the given customer.
+func WithCustomerID(id string) SearchOption {
+	return func(eso *EventSearchOptions) {
+		eso.CustomerID = &id
+	}
+}
+
+// WithTag limits the search to events that have at least one of the given
+// tags.
+func WithTag(tags ...string) SearchOption {
+	return func(eso *EventSearchOptions) {
+		eso.Tags = NormalizeTags(tags)
+	}
+}
+
+// WithStatus limits the search to events with one of the given appointment
+// statuses.
+func WithStatus(statuses ...string) SearchOption {
+	return func(eso *EventSearchOptions) {
+		eso.Statuses = statuses
+	}
+}
+
+// WithChannel limits the search to events created through one of the given
+// channels.
+func WithChannel(channels ...string) SearchOption {
+	return func(eso *EventSearchOptions) {
+		eso.Channels = channels
+	}
+}
+
+// NormalizeTags trims and lower-cases tags and removes empty and duplicate
+// tags. The result is sorted.
+func NormalizeTags(tags []string) []string {
+	var result []string
+
+	for _, t := range tags {
+		t = strings.ToLower(strings.TrimSpace(t))
+		if t != "" && !slices.Contains(result, t) {
+			result = append(result, t)
+		}
+	}
+
+	slices.Sort(result)
+
+	return result
+}
+
+// ValidateTags checks that the encoded tags fit into an extended property.
+func ValidateTags(tags []string) error {
+	if len(tags) == 0 {
+		return nil
+	}
+
+	blob, err := json.Marshal(tags)
+	if err != nil {
+		return err
+	}
+
+	if len(blob) > MaxTagsSize {
+		return fmt.Errorf("%w: tags must not exceed %d bytes", ErrInvalidEvent, MaxTagsSize)
+	}
+
+	return nil
+}
+
+// HasChannel reports whether the event has been created through one of the
+// given channels.
+func (model *Event) HasChannel(channels ...string) bool {
+	return model.Channel != "" && slices.Contains(channels, model.Channel)
+}
+
+// HasTag reports whether the event has at least one of the given tags.
+func (model *Event) HasTag(tags ...string) bool {
+	for _, t := range tags {
+		if slices.Contains(model.Tags, t) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// HasCustomer reports whether the event has been booked for the customer id.
+func (model *Event) HasCustomer(id string) bool {
+	return model.Data != nil && model.Data.CustomerID == id
+}
+
+// Match ranks returned by MatchRank, higher is better.
+const (
+	NoMatch = iota
+	DescriptionMatch
+	SummaryMatch
+	ExactSummaryMatch
+)
+
+// MatchRank ranks how well an event with the given summary and description
+// matches query. Matching is case-insensitive, an exact summary match ranks
+// higher than a summary substring which ranks higher than a description
+// substring.
+func MatchRank(query, summary, description string) int {
+	query = strings.ToLower(strings.TrimSpace(query))
+	if query == "" {
+		return NoMatch
+	}
+
+	summary = strings.ToLower(strings.TrimSpace(summary))
+
+	switch {
+	case summary == query:
+		return ExactSummaryMatch
+	case strings.Contains(summary, query):
+		return SummaryMatch
+	case strings.Contains(strings.ToLower(description), query):
+		return DescriptionMatch
+	}
+
+	return NoMatch
+}
+
+// EventMatches reports whether the event matches the free-text query.
+func (model *Event) EventMatches(query string) bool {
+	return MatchRank(query, model.Summary, model.Description) > NoMatch
+}
+
+// OpenEnd reports whether evt is a timed event without an end time.
+func (model *Event) OpenEnd() bool {
+	return model.EndTime == nil && !model.FullDayEvent
+}
+
+// EndOrDefault returns the end time of the event. Open-ended events are
+// assumed to last for d. Nil is returned if the event has no end time and
+// d is not positive.
+func (model *Event) EndOrDefault(d time.Duration) *time.Time {
+	if !model.OpenEnd() || d <= 0 {
+		return model.EndTime
+	}
+
+	end := model.StartTime.Add(d)
+
+	return &end
+}
+
+// IsAbsence reports whether the event is an out-of-office or focus-time
+// event. Absences always block the calendar, even if they span whole days.
+func (model *Event) IsAbsence() bool {
+	return model.EventType == EventTypeOutOfOffice || model.EventType == EventTypeFocusTime
+}
+
+// IsWorkingLocation reports whether the event is a working-location marker.
+// Those events are excluded from free-slot and conflict computations.
+func (model *Event) IsWorkingLocation() bool {
+	return model.EventType == EventTypeWorkingLocation
+}
+
+// HasResource reports whether the event requires the resource name.
+func (model *Event) HasResource(name string) bool {
+	if model.Data == nil {
+		return false
+	}
+
+	return slices.Contains(model.Data.RequiredResources, name)
+}
+
 func googleEventToModel(_ context.Context, calid string, item *calendar.Event) (*Event, error) {
 	var (
 		err   error
@@ -113,9 +491,12 @@ func googleEventToModel(_ context.Context, calid string, item *calendar.Event) (
 		return nil, fmt.Errorf("%w: received nil item", ErrInvalidEvent)
 	}
 
+	item = withJoinedProperties(item)
+
 	if item.Start == nil {
 		logrus.WithFields(logrus.Fields{
-			"event": item,
+			"event-id": item.Id,
+			"summary":  RedactSummary(item.Summary),
 		}).Errorf("failed to process google calendar event: event.Start == nil")
 
 		return nil, fmt.Errorf("%w: event with ID %s does not have start time", ErrInvalidEvent, item.Id)
@@ -151,18 +532,200 @@ func googleEventToModel(_ context.Context, calid string, item *calendar.Event) (
 		item.Description = newDescription
 	}
 
+	eventType := item.EventType
+	if eventType == "" {
+		eventType = EventTypeDefault
+	}
+
+	summary := strings.TrimSpace(item.Summary)
+	if summary == "" {
+		switch eventType {
+		case EventTypeOutOfOffice:
+			summary = DefaultOutOfOfficeSummary
+		case EventTypeFocusTime:
+			summary = DefaultFocusTimeSummary
+		}
+	}
+
+	// the update time is informational only, ignore malformed values.
+	updated, _ := time.Parse(time.RFC3339, item.Updated)
+
+	status, statusChangedBy, statusChangedAt := eventStatus(item)
+
 	return &Event{
 		ID:           item.Id,
-		Summary:      strings.TrimSpace(item.Summary),
-		Description:  strings.TrimSpace(item.Description),
+		Summary:      summary,
+		Description:  richtext.Sanitize(strings.TrimSpace(item.Description)),
 		StartTime:    start,
 		EndTime:      end,
 		FullDayEvent: item.Start.DateTime == "" && item.Start.Date != "",
 		CalendarID:   calid,
 		Data:         data,
+		Source:       eventSource(item),
+		Tags:         eventTags(item),
+		EventType:    eventType,
+		ColorID:      item.ColorId,
+		Transparent:  item.Transparency == "transparent",
+		Visibility:   eventVisibility(item),
+
+		GeneratedSummary:  summary != strings.TrimSpace(item.Summary),
+		DescriptionFormat: eventDescriptionFormat(item),
+		Etag:              item.Etag,
+		UpdateTime:        updated,
+		Status:            status,
+		StatusChangedBy:   statusChangedBy,
+		StatusChangedAt:   statusChangedAt,
+		ImportedFrom:      eventImportedFrom(item),
+		Channel:           eventChannel(item),
+		ICalUID:           item.ICalUID,
+		Invited:           item.Organizer != nil && !item.Organizer.Self,
 	}, nil
 }
 
+// eventVisibility returns the visibility of item, the default visibility is
+// returned as an empty string.
+func eventVisibility(item *calendar.Event) string {
+	if item.Visibility == VisibilityDefault {
+		return ""
+	}
+
+	return item.Visibility
+}
+
+// eventSource returns the source of a google calendar event based on it's
+// private extended properties.
+func eventSource(item *calendar.Event) string {
+	if item.ExtendedProperties != nil && item.ExtendedProperties.Private[sourceProperty] == EventSourceCisCal {
+		return EventSourceCisCal
+	}
+
+	return EventSourceExternal
+}
+
+// sourceProperties returns the extended properties that tag an event as
+// created by cis-cal. It returns nil for all other sources.
+func sourceProperties(source string) *calendar.EventExtendedProperties {
+	if source != EventSourceCisCal {
+		return nil
+	}
+
+	return &calendar.EventExtendedProperties{
+		Private: map[string]string{
+			sourceProperty:     EventSourceCisCal,
+			apiVersionProperty: SourceAPIVersion,
+		},
+	}
+}
+
+// eventTags returns the tags stored in the private extended properties of
+// a google calendar event.
+func eventTags(item *calendar.Event) []string {
+	if item.ExtendedProperties == nil || item.ExtendedProperties.Private[tagsProperty] == "" {
+		return nil
+	}
+
+	var tags []string
+	if err := json.Unmarshal([]byte(item.ExtendedProperties.Private[tagsProperty]), &tags); err != nil {
+		logrus.Errorf("failed to parse tags of event %s: %s", item.Id, err)
+
+		return nil
+	}
+
+	return NormalizeTags(tags)
+}
+
+// eventDescriptionFormat returns the format the description of item has
+// been written in.
+func eventDescriptionFormat(item *calendar.Event) string {
+	if item.ExtendedProperties == nil {
+		return ""
+	}
+
+	return item.ExtendedProperties.Private[descriptionFormatProperty]
+}
+
+// eventImportedFrom returns the id of the event item has been restored
+// from.
+func eventImportedFrom(item *calendar.Event) string {
+	if item.ExtendedProperties == nil {
+		return ""
+	}
+
+	return item.ExtendedProperties.Private[importedFromProperty]
+}
+
+// withImportedFrom adds the id of the event an event has been restored from
+// to props.
+func withImportedFrom(props *calendar.EventExtendedProperties, eventID string) *calendar.EventExtendedProperties {
+	return withPrivateProperty(props, importedFromProperty, eventID)
+}
+
+// eventChannel returns the channel item has been created through.
+func eventChannel(item *calendar.Event) string {
+	if item.ExtendedProperties == nil {
+		return ""
+	}
+
+	return item.ExtendedProperties.Private[channelProperty]
+}
+
+// withChannel adds the channel an event has been created through to props.
+func withChannel(props *calendar.EventExtendedProperties, channel string) *calendar.EventExtendedProperties {
+	return withPrivateProperty(props, channelProperty, channel)
+}
+
+// withPrivateProperty sets the private extended property key to value. Empty
+// values are not written.
+func withPrivateProperty(props *calendar.EventExtendedProperties, key, value string) *calendar.EventExtendedProperties {
+	if value == "" {
+		return props
+	}
+
+	if props == nil {
+		props = &calendar.EventExtendedProperties{}
+	}
+
+	if props.Private == nil {
+		props.Private = make(map[string]string)
+	}
+
+	props.Private[key] = value
+
+	return props
+}
+
+// eventProperties returns the extended properties for an event with the
+// given source, tags and description format.
+func eventProperties(source string, tags []string, descriptionFormat string) (*calendar.EventExtendedProperties, error) {
+	props := sourceProperties(source)
+
+	if len(tags) == 0 && descriptionFormat == "" {
+		return props, nil
+	}
+
+	if props == nil {
+		props = &calendar.EventExtendedProperties{
+			Private: make(map[string]string),
+		}
+	}
+
+	if len(tags) > 0 {
+		if err := ValidateTags(tags); err != nil {
+			return nil, err
+		}
+
+		// ValidateTags already made sure the tags can be encoded
+		blob, _ := json.Marshal(tags)
+		props.Private[tagsProperty] = string(blob)
+	}
+
+	if descriptionFormat != "" {
+		props.Private[descriptionFormatProperty] = descriptionFormat
+	}
+
+	return props, nil
+}
+
 func parseDescription(desc string) (string, *StructuredEvent, error) {
 	allLines := strings.Split(desc, "\n")
 	var (
@@ -196,6 +759,17 @@ func parseDescription(desc string) (string, *StructuredEvent, error) {
 	return strippedDescr, &data, nil
 }
 
+// extraStruct returns fields as the ExtraData struct of the event. The
+// pinned CalendarEvent message has no field for events without an end time
+// so open-ended events are flagged with openEnd.
+func (model *Event) extraStruct(fields map[string]interface{}) (*structpb.Struct, error) {
+	if model.OpenEnd() {
+		fields["openEnd"] = true
+	}
+
+	return structpb.NewStruct(fields)
+}
+
 func (model *Event) ToProto() (*calendarv1.CalendarEvent, error) {
 	var endTime *timestamppb.Timestamp
 	var any *anypb.Any
@@ -219,6 +793,61 @@ func (model *Event) ToProto() (*calendarv1.CalendarEvent, error) {
 		}
 	}
 
+	if model.Slot != nil {
+		kind := "free-slot"
+		if model.IsShift {
+			kind = "shift"
+		}
+
+		slot, err := model.extraStruct(map[string]interface{}{
+			"kind":        kind,
+			"userId":      model.Slot.UserID,
+			"shiftId":     model.Slot.ShiftID,
+			"workShiftId": model.Slot.WorkShiftID,
+			"shiftName":   model.Slot.ShiftName,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		any, err = anypb.New(slot)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if model.OverlayOf != "" {
+		overlay, err := model.extraStruct(map[string]interface{}{
+			"kind":             "overlay",
+			"sourceCalendarId": model.OverlayOf,
+			"readOnly":         true,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		any, err = anypb.New(overlay)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// the customer annotation takes precedence, clients check the end time
+	// of annotated events instead.
+	if any == nil && model.OpenEnd() {
+		openEnd, err := model.extraStruct(map[string]interface{}{
+			"kind": "event",
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		any, err = anypb.New(openEnd)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return &calendarv1.CalendarEvent{
 		Id:          model.ID,
 		CalendarId:  model.CalendarID,
@@ -0,0 +1,69 @@
+package repo
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+func Test_EventList_Sort(t *testing.T) {
+	at := func(hour int) time.Time {
+		return time.Date(2024, time.June, 3, hour, 0, 0, 0, time.UTC)
+	}
+	end := func(hour int) *time.Time {
+		t := at(hour)
+		return &t
+	}
+
+	events := EventList{
+		{ID: "open-end", StartTime: at(8)},
+		{ID: "later", StartTime: at(9), EndTime: end(10)},
+		{ID: "long", StartTime: at(8), EndTime: end(11)},
+		{ID: "short", StartTime: at(8), EndTime: end(9)},
+		{ID: "open-end-early", StartTime: at(7)},
+	}
+
+	sort.Stable(events)
+
+	ids := make([]string, len(events))
+	for idx, e := range events {
+		ids[idx] = e.ID
+	}
+
+	assert.Equal(t, []string{"open-end-early", "short", "long", "open-end", "later"}, ids)
+}
+
+func Test_Event_ToProto_OpenEnd(t *testing.T) {
+	start := time.Date(2024, time.June, 3, 8, 0, 0, 0, time.UTC)
+
+	openEnd := func(e Event) bool {
+		pb, err := e.ToProto()
+		require.NoError(t, err)
+
+		if pb.ExtraData == nil {
+			return false
+		}
+
+		var extra structpb.Struct
+		if !pb.ExtraData.MessageIs(&extra) {
+			return false
+		}
+
+		require.NoError(t, pb.ExtraData.UnmarshalTo(&extra))
+
+		return extra.Fields["openEnd"].GetBoolValue()
+	}
+
+	assert.True(t, openEnd(Event{StartTime: start}))
+	assert.True(t, openEnd(Event{StartTime: start, OverlayOf: "hr"}))
+	end := start.Add(time.Hour)
+	assert.False(t, openEnd(Event{StartTime: start, EndTime: &end}))
+	assert.False(t, openEnd(Event{StartTime: start, FullDayEvent: true}))
+
+	// the customer annotation takes precedence
+	assert.False(t, openEnd(Event{StartTime: start, Data: &StructuredEvent{CustomerID: "huber"}}))
+}
@@ -0,0 +1,103 @@
+package repo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/calendar/v3"
+)
+
+// moveServer fakes the move and patch endpoints of the calendar API and
+// records the requests.
+type moveServer struct {
+	l         sync.Mutex
+	calls     []string
+	patched   *calendar.Event
+	failPatch bool
+}
+
+func (m *moveServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.l.Lock()
+	defer m.l.Unlock()
+
+	switch {
+	case r.Method == http.MethodGet:
+		// event caches created by the move
+		fmt.Fprint(w, `{"items": []}`)
+
+	case r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/move"):
+		m.calls = append(m.calls, "move "+strings.Split(r.URL.Path, "/")[2]+" "+r.URL.Query().Get("destination"))
+
+		fmt.Fprint(w, `{"id": "1", "start": {"dateTime": "2024-01-01T08:00:00Z"}, "end": {"dateTime": "2024-01-01T09:00:00Z"}}`)
+
+	case r.Method == http.MethodPatch:
+		m.calls = append(m.calls, "patch "+strings.Split(r.URL.Path, "/")[2])
+
+		if m.failPatch {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		var body calendar.Event
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		m.patched = &body
+
+		body.Id = "1"
+		_ = json.NewEncoder(w).Encode(body)
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func Test_MoveEvent_WithTime(t *testing.T) {
+	srv := new(moveServer)
+	backend := newTestBackend(t, srv)
+
+	start := time.Date(2024, time.January, 1, 10, 0, 0, 0, time.UTC)
+	end := start.Add(30 * time.Minute)
+
+	evt, err := backend.MoveEvent(context.Background(), "origin", "1", "target", WithEventTime(start, &end))
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"move origin target", "patch target"}, srv.calls)
+	assert.Equal(t, "2024-01-01T10:00:00Z", srv.patched.Start.DateTime)
+	assert.Equal(t, "2024-01-01T10:30:00Z", srv.patched.End.DateTime)
+
+	// the final event is returned
+	assert.Equal(t, "target", evt.CalendarID)
+	assert.True(t, start.Equal(evt.StartTime))
+	assert.True(t, end.Equal(*evt.EndTime))
+
+	// without a new time the event is only moved
+	srv.calls = nil
+
+	_, err = backend.MoveEvent(context.Background(), "origin", "1", "target")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"move origin target"}, srv.calls)
+}
+
+func Test_MoveEvent_Rollback(t *testing.T) {
+	srv := &moveServer{failPatch: true}
+	backend := newTestBackend(t, srv)
+
+	start := time.Date(2024, time.January, 1, 10, 0, 0, 0, time.UTC)
+
+	_, err := backend.MoveEvent(context.Background(), "origin", "1", "target", WithEventTime(start, nil))
+	require.Error(t, err)
+
+	// the event is moved back to the origin calendar
+	assert.Equal(t, []string{"move origin target", "patch target", "move target origin"}, srv.calls)
+}
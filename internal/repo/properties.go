@@ -0,0 +1,163 @@
+package repo
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"google.golang.org/api/calendar/v3"
+)
+
+// Limits of google calendar extended properties. Google silently drops
+// properties with longer keys and rejects events with larger values with an
+// opaque error, so the properties are checked before they are written.
+const (
+	maxPropertyKeySize   = 44
+	maxPropertyValueSize = 1024
+	maxPropertiesSize    = 32 * 1024
+
+	// maxPropertyParts is the maximum number of properties a single value
+	// is split into.
+	maxPropertyParts = 8
+)
+
+// propertyPartSeparator separates the key of a split property from the
+// number of the part. The first part is stored under the key itself so
+// values that fit into a single property are stored as before.
+const propertyPartSeparator = "#"
+
+// propertyPartKey returns the key of the idx-th part of the property key.
+func propertyPartKey(key string, idx int) string {
+	if idx == 0 {
+		return key
+	}
+
+	return key + propertyPartSeparator + strconv.Itoa(idx)
+}
+
+// splitPropertyValue splits value into parts of at most maxPropertyValueSize
+// bytes without splitting UTF-8 sequences.
+func splitPropertyValue(value string) []string {
+	var parts []string
+
+	for len(value) > maxPropertyValueSize {
+		cut := maxPropertyValueSize
+		for cut > 0 && !utf8.RuneStart(value[cut]) {
+			cut--
+		}
+
+		parts = append(parts, value[:cut])
+		value = value[cut:]
+	}
+
+	return append(parts, value)
+}
+
+// compactProperties drops empty private properties and splits values that
+// exceed the size of a single property across multiple numbered keys. It
+// returns an ErrInvalidEvent naming the property if a value does not fit
+// even when split or if all properties together exceed the limit.
+func compactProperties(props *calendar.EventExtendedProperties) (*calendar.EventExtendedProperties, error) {
+	if props == nil {
+		return nil, nil
+	}
+
+	private := make(map[string]string, len(props.Private))
+	total := 0
+
+	for key, value := range props.Private {
+		if value == "" {
+			continue
+		}
+
+		parts := splitPropertyValue(value)
+		if len(parts) > maxPropertyParts {
+			return nil, fmt.Errorf("%w: extended property %q must not exceed %d bytes", ErrInvalidEvent, key, maxPropertyParts*maxPropertyValueSize)
+		}
+
+		for idx, part := range parts {
+			partKey := propertyPartKey(key, idx)
+			if len(partKey) > maxPropertyKeySize {
+				return nil, fmt.Errorf("%w: extended property key %q is longer than %d bytes", ErrInvalidEvent, partKey, maxPropertyKeySize)
+			}
+
+			private[partKey] = part
+			total += len(partKey) + len(part)
+		}
+	}
+
+	for key, value := range props.Shared {
+		total += len(key) + len(value)
+	}
+
+	if total > maxPropertiesSize {
+		return nil, fmt.Errorf("%w: extended properties must not exceed %d bytes", ErrInvalidEvent, maxPropertiesSize)
+	}
+
+	return &calendar.EventExtendedProperties{
+		Private: private,
+		Shared:  props.Shared,
+	}, nil
+}
+
+// joinProperties reassembles private properties that have been split by
+// compactProperties. Incomplete parts are ignored.
+func joinProperties(private map[string]string) map[string]string {
+	var split bool
+	for key := range private {
+		if strings.Contains(key, propertyPartSeparator) {
+			split = true
+			break
+		}
+	}
+
+	if !split {
+		return private
+	}
+
+	joined := make(map[string]string, len(private))
+	for key, value := range private {
+		if strings.Contains(key, propertyPartSeparator) {
+			continue
+		}
+
+		var sb strings.Builder
+		sb.WriteString(value)
+
+		for idx := 1; ; idx++ {
+			part, ok := private[propertyPartKey(key, idx)]
+			if !ok {
+				break
+			}
+
+			sb.WriteString(part)
+		}
+
+		joined[key] = sb.String()
+	}
+
+	return joined
+}
+
+// withJoinedProperties returns a shallow copy of item with split private
+// properties reassembled.
+func withJoinedProperties(item *calendar.Event) *calendar.Event {
+	if item == nil || item.ExtendedProperties == nil {
+		return item
+	}
+
+	// joining drops the keys of all parts after the first one
+	private := joinProperties(item.ExtendedProperties.Private)
+	if len(private) == len(item.ExtendedProperties.Private) {
+		return item
+	}
+
+	cpy := *item
+	cpy.ExtendedProperties = &calendar.EventExtendedProperties{
+		Private: private,
+		Shared:  item.ExtendedProperties.Shared,
+	}
+
+	return &cpy
+}
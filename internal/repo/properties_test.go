@@ -0,0 +1,114 @@
+package repo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/calendar/v3"
+)
+
+// tagsOfSize returns a single tag that is encoded as exactly size bytes.
+func tagsOfSize(size int) []string {
+	// the JSON encoding adds brackets and quotes
+	return []string{strings.Repeat("x", size-4)}
+}
+
+func Test_CreateEvent_LargeProperties(t *testing.T) {
+	var (
+		inserted calendar.Event
+		requests int
+	)
+
+	backend := newTestBackend(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			fmt.Fprint(w, `{"items": []}`)
+			return
+		}
+
+		requests++
+
+		inserted = calendar.Event{}
+		if err := json.NewDecoder(r.Body).Decode(&inserted); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		inserted.Id = "1"
+		_ = json.NewEncoder(w).Encode(inserted)
+	}))
+
+	create := func(tags []string, channel string) (*Event, error) {
+		return backend.CreateEvent(context.Background(), "cal", "Bello", "", time.Date(2024, time.January, 1, 8, 0, 0, 0, time.UTC), time.Hour, nil, WithTags(tags...), WithSourceChannel(channel))
+	}
+
+	// tags just fit into a single property
+	evt, err := create(tagsOfSize(maxPropertyValueSize), "")
+	require.NoError(t, err)
+	assert.Len(t, inserted.ExtendedProperties.Private[tagsProperty], maxPropertyValueSize)
+	assert.NotContains(t, inserted.ExtendedProperties.Private, propertyPartKey(tagsProperty, 1))
+	assert.Equal(t, tagsOfSize(maxPropertyValueSize), evt.Tags)
+
+	// tags just over the size of a property are split and reassembled
+	evt, err = create(tagsOfSize(maxPropertyValueSize+1), "")
+	require.NoError(t, err)
+	assert.Len(t, inserted.ExtendedProperties.Private[tagsProperty], maxPropertyValueSize)
+	assert.Len(t, inserted.ExtendedProperties.Private[propertyPartKey(tagsProperty, 1)], 1)
+	assert.Equal(t, tagsOfSize(maxPropertyValueSize+1), evt.Tags)
+
+	// tags just under the limit
+	evt, err = create(tagsOfSize(MaxTagsSize), "")
+	require.NoError(t, err)
+	assert.Equal(t, tagsOfSize(MaxTagsSize), evt.Tags)
+
+	// values that do not fit even when split are rejected before they are
+	// sent upstream
+	sent := requests
+
+	_, err = create(tagsOfSize(MaxTagsSize+1), "")
+	assert.ErrorIs(t, err, ErrInvalidEvent)
+
+	_, err = create(nil, strings.Repeat("x", maxPropertyParts*maxPropertyValueSize+1))
+	assert.ErrorIs(t, err, ErrInvalidEvent)
+	assert.ErrorContains(t, err, `"channel"`)
+
+	assert.Equal(t, sent, requests)
+}
+
+func Test_CompactProperties(t *testing.T) {
+	props, err := compactProperties(nil)
+	require.NoError(t, err)
+	assert.Nil(t, props)
+
+	// multi-byte characters are never split
+	value := strings.Repeat("ä", maxPropertyValueSize)
+
+	props, err = compactProperties(&calendar.EventExtendedProperties{
+		Private: map[string]string{
+			channelProperty:      value,
+			importedFromProperty: "",
+		},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"channel":   strings.Repeat("ä", maxPropertyValueSize/2),
+		"channel#1": strings.Repeat("ä", maxPropertyValueSize/2),
+	}, props.Private)
+
+	assert.Equal(t, map[string]string{channelProperty: value}, joinProperties(props.Private))
+
+	// all properties together are limited as well
+	private := make(map[string]string)
+	for i := 0; i < 40; i++ {
+		private[fmt.Sprintf("p%d", i)] = strings.Repeat("x", maxPropertyValueSize)
+	}
+
+	_, err = compactProperties(&calendar.EventExtendedProperties{Private: private})
+	assert.ErrorIs(t, err, ErrInvalidEvent)
+}
@@ -0,0 +1,93 @@
+package repo
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	connect "github.com/bufbuild/connect-go"
+	eventsv1 "github.com/tierklinik-dobersberg/apis/gen/go/tkd/events/v1"
+	"github.com/tierklinik-dobersberg/apis/gen/go/tkd/events/v1/eventsv1connect"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+// publishTimeout bounds a single publish so an unresponsive events service
+// does not pile up goroutines.
+const publishTimeout = 10 * time.Second
+
+// publisher publishes events to the events service in the background and
+// tracks in-flight publishes so shutdown can wait for them. A nil publisher
+// publishes without tracking.
+type publisher struct {
+	l      sync.Mutex
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// PublishEvent publishes msg in the background. The publish is canceled once
+// ctx is done. Events are dropped if ctx is already done, events is nil or
+// the publisher has been closed.
+func (p *publisher) PublishEvent(ctx context.Context, events eventsv1connect.EventServiceClient, msg proto.Message, retained bool) {
+	if events == nil || ctx.Err() != nil {
+		return
+	}
+
+	if p != nil {
+		p.l.Lock()
+		if p.closed {
+			p.l.Unlock()
+			slog.Debug("dropping event during shutdown", "messageType", proto.MessageName(msg))
+			return
+		}
+
+		// Add must not race with Wait in close, so it's guarded by the
+		// same lock as closed.
+		p.wg.Add(1)
+		p.l.Unlock()
+	}
+
+	go func() {
+		if p != nil {
+			defer p.wg.Done()
+		}
+
+		pb, err := anypb.New(msg)
+		if err != nil {
+			slog.Error("failed to marshal protobuf message as anypb.Any", "error", err, "messageType", proto.MessageName(msg))
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(ctx, publishTimeout)
+		defer cancel()
+
+		if _, err := events.Publish(ctx, connect.NewRequest(&eventsv1.Event{
+			Event:    pb,
+			Retained: retained,
+		})); err != nil {
+			slog.Error("failed to publish event", "error", err, "messageType", proto.MessageName(msg))
+		}
+	}()
+}
+
+// close stops accepting new events and waits for in-flight publishes until
+// ctx is done. It returns ctx.Err() if publishes are still running by then.
+func (p *publisher) close(ctx context.Context) error {
+	p.l.Lock()
+	p.closed = true
+	p.l.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,124 @@
+package repo
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	calendarv1 "github.com/tierklinik-dobersberg/apis/gen/go/tkd/calendar/v1"
+	eventsv1 "github.com/tierklinik-dobersberg/apis/gen/go/tkd/events/v1"
+	"github.com/tierklinik-dobersberg/apis/gen/go/tkd/events/v1/eventsv1connect"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// blockingEvents is an events service client that blocks publishes until
+// their context is done.
+type blockingEvents struct {
+	eventsv1connect.EventServiceClient
+
+	calls atomic.Int64
+}
+
+func (b *blockingEvents) Publish(ctx context.Context, _ *connect.Request[eventsv1.Event]) (*connect.Response[emptypb.Empty], error) {
+	b.calls.Add(1)
+
+	<-ctx.Done()
+
+	return nil, ctx.Err()
+}
+
+func Test_Publisher(t *testing.T) {
+	var (
+		p      publisher
+		events = new(blockingEvents)
+		msg    = &calendarv1.CalendarChangeEvent{Calendar: "cal"}
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	for i := 0; i < 10; i++ {
+		p.PublishEvent(ctx, events, msg, false)
+	}
+
+	assert.Eventually(t, func() bool {
+		return events.calls.Load() == 10
+	}, time.Second, 10*time.Millisecond)
+
+	// close waits for in-flight publishes but is bounded
+	closeCtx, closeCancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer closeCancel()
+
+	assert.ErrorIs(t, p.close(closeCtx), context.DeadlineExceeded)
+
+	// closed publishers drop new events
+	p.PublishEvent(ctx, events, msg, false)
+
+	// canceling the context aborts in-flight publishes so no goroutine
+	// is left once close returns
+	cancel()
+
+	require.NoError(t, p.close(context.Background()))
+	assert.Equal(t, int64(10), events.calls.Load())
+}
+
+func Test_Publisher_CanceledContext(t *testing.T) {
+	var (
+		p      publisher
+		events = new(blockingEvents)
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	p.PublishEvent(ctx, events, &calendarv1.CalendarChangeEvent{}, false)
+	require.NoError(t, p.close(context.Background()))
+	assert.Zero(t, events.calls.Load())
+
+	// events without a client or publisher are dropped or untracked
+	p.PublishEvent(context.Background(), nil, &calendarv1.CalendarChangeEvent{}, false)
+
+	var untracked *publisher
+	untracked.PublishEvent(ctx, events, &calendarv1.CalendarChangeEvent{}, false)
+	assert.Zero(t, events.calls.Load())
+}
+
+func Test_Backend_Close(t *testing.T) {
+	backend := newTestBackend(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"items": [{"id": "1", "start": {"dateTime": "2024-01-01T08:00:00Z"}, "end": {"dateTime": "2024-01-01T09:00:00Z"}}], "nextSyncToken": "token"}`)
+	}))
+
+	events := new(blockingEvents)
+	backend.EventsClient = events
+
+	_, err := backend.CalendarVersion(context.Background(), "cal")
+	require.NoError(t, err)
+
+	// incremental syncs publish the change
+	assert.Eventually(t, func() bool {
+		backend.eventsCache["cal"].triggerSync()
+
+		return events.calls.Load() > 0
+	}, time.Second, 10*time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	// the publish blocks so it's canceled once the timeout is reached.
+	// Close waits for the publishes and the caches to stop.
+	assert.ErrorIs(t, backend.Close(ctx), context.DeadlineExceeded)
+	assert.ErrorIs(t, backend.ctx.Err(), context.Canceled)
+
+	// publishes after Close are dropped, otherwise they would block forever
+	backend.publisher.PublishEvent(context.Background(), events, &calendarv1.CalendarChangeEvent{}, false)
+
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	assert.NoError(t, backend.publisher.close(ctx))
+}
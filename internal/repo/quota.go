@@ -0,0 +1,319 @@
+package repo
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"google.golang.org/api/calendar/v3"
+)
+
+// quotaRetention is the number of hours the quota usage is kept for.
+const quotaRetention = 24
+
+// Callers of google calendar API requests as reported in the quota usage.
+// Requests made on behalf of other features can be labeled using
+// WithQuotaCaller.
+const (
+	CallerCacheSync    = "cache-sync"
+	CallerCalendarSync = "calendar-sync"
+	CallerListRequest  = "list-request"
+	CallerLoadEvent    = "load-event"
+	CallerCreate       = "create"
+	CallerUpdate       = "update"
+	CallerStatus       = "status"
+	CallerMove         = "move"
+	CallerDelete       = "delete"
+	CallerCalendars    = "calendars"
+)
+
+type quotaCallerKey struct{}
+
+// WithQuotaCaller returns a new context that reports all google calendar
+// API requests as made by caller, like "prefetch".
+func WithQuotaCaller(ctx context.Context, caller string) context.Context {
+	return context.WithValue(ctx, quotaCallerKey{}, caller)
+}
+
+// withDefaultQuotaCaller labels ctx with caller unless it's already
+// labeled.
+func withDefaultQuotaCaller(ctx context.Context, caller string) context.Context {
+	if _, ok := ctx.Value(quotaCallerKey{}).(string); ok {
+		return ctx
+	}
+
+	return WithQuotaCaller(ctx, caller)
+}
+
+// quotaCaller returns the caller of ctx or fallback if ctx is not labeled.
+func quotaCaller(ctx context.Context, fallback string) string {
+	if caller, ok := ctx.Value(quotaCallerKey{}).(string); ok && caller != "" {
+		return caller
+	}
+
+	return fallback
+}
+
+// QuotaCalls is the number of calls of a google calendar API method by a
+// single caller.
+type QuotaCalls struct {
+	Method string `json:"method"`
+	Caller string `json:"caller"`
+	Count  int    `json:"count"`
+	Errors int    `json:"errors,omitempty"`
+}
+
+// QuotaUsage is the number of google calendar API calls made within an hour.
+type QuotaUsage struct {
+	Backend string       `json:"backend,omitempty"`
+	Hour    time.Time    `json:"hour"`
+	Total   int          `json:"total"`
+	Calls   []QuotaCalls `json:"calls"`
+}
+
+type quotaKey struct {
+	method string
+	caller string
+}
+
+type quotaHour struct {
+	start  time.Time
+	total  int
+	calls  map[quotaKey]*QuotaCalls
+	warned bool
+}
+
+// QuotaTracker keeps a rolling per-hour tally of the google calendar API
+// calls. A nil tracker does not record anything.
+type QuotaTracker struct {
+	warnPerHour int
+	now         func() time.Time
+
+	l     sync.Mutex
+	hours []*quotaHour
+}
+
+// NewQuotaTracker returns a new quota tracker that logs a warning once the
+// calls within an hour exceed warnPerHour. Zero disables the warning.
+func NewQuotaTracker(warnPerHour int) *QuotaTracker {
+	return &QuotaTracker{
+		warnPerHour: warnPerHour,
+		now:         time.Now,
+	}
+}
+
+// record counts a call of method by caller.
+func (q *QuotaTracker) record(ctx context.Context, method, caller string, err error) {
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+
+	googleAPICallCounter.Add(ctx, 1, metric.WithAttributes(
+		attribute.String("method", method),
+		attribute.String("caller", caller),
+		attribute.String("result", result),
+	))
+
+	if q == nil {
+		return
+	}
+
+	q.l.Lock()
+	defer q.l.Unlock()
+
+	hour := q.current()
+	hour.total++
+
+	key := quotaKey{method, caller}
+	calls, ok := hour.calls[key]
+	if !ok {
+		calls = &QuotaCalls{Method: method, Caller: caller}
+		hour.calls[key] = calls
+	}
+
+	calls.Count++
+	if err != nil {
+		calls.Errors++
+	}
+
+	if q.warnPerHour > 0 && hour.total > q.warnPerHour && !hour.warned {
+		hour.warned = true
+
+		slog.Warn("google calendar API usage exceeds the configured threshold", "threshold", q.warnPerHour, "hour", hour.start, "calls", hour.sortedCalls())
+	}
+}
+
+// current returns the bucket of the current hour and drops buckets that are
+// older than quotaRetention. The caller must hold q.l.
+func (q *QuotaTracker) current() *quotaHour {
+	start := q.now().Truncate(time.Hour)
+
+	if n := len(q.hours); n > 0 && q.hours[n-1].start.Equal(start) {
+		return q.hours[n-1]
+	}
+
+	hour := &quotaHour{
+		start: start,
+		calls: make(map[quotaKey]*QuotaCalls),
+	}
+	q.hours = append(q.hours, hour)
+
+	cutoff := start.Add(-(quotaRetention - 1) * time.Hour)
+	for len(q.hours) > 0 && q.hours[0].start.Before(cutoff) {
+		q.hours = q.hours[1:]
+	}
+
+	return hour
+}
+
+// sortedCalls returns the calls of the hour, most frequent first.
+func (h *quotaHour) sortedCalls() []QuotaCalls {
+	calls := make([]QuotaCalls, 0, len(h.calls))
+	for _, c := range h.calls {
+		calls = append(calls, *c)
+	}
+
+	sort.Slice(calls, func(i, j int) bool {
+		if calls[i].Count != calls[j].Count {
+			return calls[i].Count > calls[j].Count
+		}
+
+		if calls[i].Method != calls[j].Method {
+			return calls[i].Method < calls[j].Method
+		}
+
+		return calls[i].Caller < calls[j].Caller
+	})
+
+	return calls
+}
+
+// Usage returns the usage of the last hours, the current hour first.
+func (q *QuotaTracker) Usage() []QuotaUsage {
+	if q == nil {
+		return nil
+	}
+
+	q.l.Lock()
+	defer q.l.Unlock()
+
+	cutoff := q.now().Truncate(time.Hour).Add(-(quotaRetention - 1) * time.Hour)
+
+	var res []QuotaUsage
+	for idx := len(q.hours) - 1; idx >= 0; idx-- {
+		hour := q.hours[idx]
+		if hour.start.Before(cutoff) {
+			break
+		}
+
+		res = append(res, QuotaUsage{
+			Hour:  hour.start,
+			Total: hour.total,
+			Calls: hour.sortedCalls(),
+		})
+	}
+
+	return res
+}
+
+// googleAPI is a thin facade over the google calendar API that records every
+// call in the quota tracker. Callers are taken from the request context,
+// each method has a default caller for unlabeled contexts.
+type googleAPI struct {
+	svc   *calendar.Service
+	quota *QuotaTracker
+}
+
+func (api googleAPI) ListCalendars(ctx context.Context) (*calendar.CalendarList, error) {
+	res, err := api.svc.CalendarList.List().ShowHidden(true).Context(ctx).Do()
+	api.quota.record(ctx, "calendarList.list", quotaCaller(ctx, CallerCalendarSync), err)
+
+	return res, err
+}
+
+// ListEvents executes a single page of call.
+func (api googleAPI) ListEvents(ctx context.Context, call *calendar.EventsListCall) (*calendar.Events, error) {
+	res, err := call.Context(ctx).Do()
+	api.quota.record(ctx, "events.list", quotaCaller(ctx, CallerListRequest), err)
+
+	return res, err
+}
+
+func (api googleAPI) GetEvent(ctx context.Context, calendarID, eventID string) (*calendar.Event, error) {
+	res, err := api.svc.Events.Get(calendarID, eventID).Context(ctx).Do()
+	api.quota.record(ctx, "events.get", quotaCaller(ctx, CallerLoadEvent), err)
+
+	return res, err
+}
+
+func (api googleAPI) InsertEvent(ctx context.Context, calendarID string, event *calendar.Event) (*calendar.Event, error) {
+	res, err := api.svc.Events.Insert(calendarID, event).Context(ctx).Do()
+	api.quota.record(ctx, "events.insert", quotaCaller(ctx, CallerCreate), err)
+
+	return res, err
+}
+
+func (api googleAPI) UpdateEvent(ctx context.Context, calendarID, eventID string, event *calendar.Event) (*calendar.Event, error) {
+	res, err := api.svc.Events.Update(calendarID, eventID, event).Context(ctx).Do()
+	api.quota.record(ctx, "events.update", quotaCaller(ctx, CallerUpdate), err)
+
+	return res, err
+}
+
+// PatchEvent executes call, it's passed in so callers can set headers like
+// If-Match.
+func (api googleAPI) PatchEvent(ctx context.Context, call *calendar.EventsPatchCall) (*calendar.Event, error) {
+	res, err := call.Context(ctx).Do()
+	api.quota.record(ctx, "events.patch", quotaCaller(ctx, CallerUpdate), err)
+
+	return res, err
+}
+
+func (api googleAPI) MoveEvent(ctx context.Context, calendarID, eventID, targetCalendarID string) (*calendar.Event, error) {
+	res, err := api.svc.Events.Move(calendarID, eventID, targetCalendarID).Context(ctx).Do()
+	api.quota.record(ctx, "events.move", quotaCaller(ctx, CallerMove), err)
+
+	return res, err
+}
+
+func (api googleAPI) DeleteEvent(ctx context.Context, calendarID, eventID string) error {
+	err := api.svc.Events.Delete(calendarID, eventID).Context(ctx).Do()
+	api.quota.record(ctx, "events.delete", quotaCaller(ctx, CallerDelete), err)
+
+	return err
+}
+
+func (api googleAPI) InsertCalendar(ctx context.Context, cal *calendar.Calendar) (*calendar.Calendar, error) {
+	res, err := api.svc.Calendars.Insert(cal).Context(ctx).Do()
+	api.quota.record(ctx, "calendars.insert", quotaCaller(ctx, CallerCalendars), err)
+
+	return res, err
+}
+
+func (api googleAPI) PatchCalendar(ctx context.Context, calendarID string, cal *calendar.Calendar) (*calendar.Calendar, error) {
+	res, err := api.svc.Calendars.Patch(calendarID, cal).Context(ctx).Do()
+	api.quota.record(ctx, "calendars.patch", quotaCaller(ctx, CallerCalendars), err)
+
+	return res, err
+}
+
+// PatchCalendarListEntry executes call, it's passed in so callers can set
+// parameters like colorRgbFormat.
+func (api googleAPI) PatchCalendarListEntry(ctx context.Context, call *calendar.CalendarListPatchCall) (*calendar.CalendarListEntry, error) {
+	res, err := call.Context(ctx).Do()
+	api.quota.record(ctx, "calendarList.patch", quotaCaller(ctx, CallerCalendars), err)
+
+	return res, err
+}
+
+func (api googleAPI) InsertACL(ctx context.Context, calendarID string, rule *calendar.AclRule) (*calendar.AclRule, error) {
+	res, err := api.svc.Acl.Insert(calendarID, rule).Context(ctx).Do()
+	api.quota.record(ctx, "acl.insert", quotaCaller(ctx, CallerCalendars), err)
+
+	return res, err
+}
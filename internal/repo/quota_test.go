@@ -0,0 +1,100 @@
+package repo
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/calendar/v3"
+)
+
+func Test_QuotaUsage_CountsCallsThroughFacade(t *testing.T) {
+	var (
+		l        sync.Mutex
+		requests []string
+	)
+
+	backend := newTestBackend(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		l.Lock()
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		l.Unlock()
+
+		switch {
+		case r.Method == http.MethodDelete:
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"error": {"code": 404, "message": "not found"}}`)
+		case r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/events") && r.URL.Query().Get("pageToken") == "":
+			fmt.Fprint(w, `{"items": [{"id": "1", "start": {"dateTime": "2024-06-03T08:00:00Z"}, "end": {"dateTime": "2024-06-03T08:30:00Z"}}], "nextPageToken": "page-2"}`)
+		case r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/events"):
+			fmt.Fprint(w, `{"items": [{"id": "2", "start": {"dateTime": "2024-06-03T09:00:00Z"}, "end": {"dateTime": "2024-06-03T09:30:00Z"}}]}`)
+		default:
+			fmt.Fprint(w, `{"id": "1"}`)
+		}
+	}))
+
+	now := time.Date(2024, time.June, 3, 8, 30, 0, 0, time.UTC)
+	quota := NewQuotaTracker(3)
+	quota.now = func() time.Time { return now }
+	backend.quota = quota
+
+	ctx := context.Background()
+
+	// both pages of the listing are counted
+	events, err := backend.loadEvents(ctx, "vet", new(EventSearchOptions).From(now), nil)
+	require.NoError(t, err)
+	assert.Len(t, events, 2)
+
+	_, err = backend.api().InsertEvent(WithQuotaCaller(ctx, "prefetch"), "vet", &calendar.Event{Summary: "Checkup"})
+	require.NoError(t, err)
+
+	err = backend.api().DeleteEvent(ctx, "vet", "unknown")
+	require.Error(t, err)
+
+	assert.Len(t, requests, 4)
+
+	usage := backend.QuotaUsage()
+	require.Len(t, usage, 1)
+	assert.Equal(t, now.Truncate(time.Hour), usage[0].Hour)
+	assert.Equal(t, 4, usage[0].Total)
+	assert.Equal(t, []QuotaCalls{
+		{Method: "events.list", Caller: CallerListRequest, Count: 2},
+		{Method: "events.delete", Caller: CallerDelete, Count: 1, Errors: 1},
+		{Method: "events.insert", Caller: "prefetch", Count: 1},
+	}, usage[0].Calls)
+
+	assert.True(t, quota.hours[0].warned, "the threshold of 3 calls per hour has been exceeded")
+
+	// the usage is tallied per hour and old hours are dropped
+	now = now.Add(time.Hour)
+	_, err = backend.api().GetEvent(ctx, "vet", "1")
+	require.NoError(t, err)
+
+	usage = backend.QuotaUsage()
+	require.Len(t, usage, 2)
+	assert.Equal(t, 1, usage[0].Total)
+	assert.Equal(t, 4, usage[1].Total)
+	assert.False(t, quota.hours[1].warned)
+
+	now = now.Add(quotaRetention * time.Hour)
+	assert.Empty(t, backend.QuotaUsage())
+}
+
+func Test_QuotaUsage_Registry(t *testing.T) {
+	r := NewRegistry()
+
+	quota := NewQuotaTracker(0)
+	quota.record(context.Background(), "events.list", CallerCacheSync, nil)
+
+	require.NoError(t, r.Register("google", &googleCalendarBackend{quota: quota}))
+
+	usage := r.QuotaUsage()
+	require.Len(t, usage, 1)
+	assert.Equal(t, "google", usage[0].Backend)
+	assert.Equal(t, 1, usage[0].Total)
+}
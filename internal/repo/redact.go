@@ -0,0 +1,84 @@
+package repo
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// MaxRedactionPause is the maximum duration redaction of log lines may be
+// paused for debugging.
+const MaxRedactionPause = time.Hour
+
+// Event summaries usually contain the names of patients and owners. They
+// must only be logged using RedactSummary. Descriptions and annotations are
+// never logged.
+var redaction struct {
+	disabled    atomic.Bool
+	pausedUntil atomic.Int64
+
+	now func() time.Time
+}
+
+func init() {
+	redaction.now = time.Now
+}
+
+// SetRedactPII configures whether event content is redacted in log lines.
+func SetRedactPII(enabled bool) {
+	redaction.disabled.Store(!enabled)
+}
+
+// PauseRedaction disables redaction of log lines for d, which must not
+// exceed MaxRedactionPause, and returns the time redaction is resumed at.
+// A duration of zero resumes redaction immediately.
+func PauseRedaction(d time.Duration) (time.Time, error) {
+	if d < 0 || d > MaxRedactionPause {
+		return time.Time{}, fmt.Errorf("redaction may only be paused for up to %s", MaxRedactionPause)
+	}
+
+	if d == 0 {
+		redaction.pausedUntil.Store(0)
+		return time.Time{}, nil
+	}
+
+	until := redaction.now().Add(d)
+	redaction.pausedUntil.Store(until.UnixNano())
+
+	return until, nil
+}
+
+// RedactionState returns whether event content is currently redacted in
+// log lines and, if redaction is paused, the time it is resumed at.
+func RedactionState() (bool, time.Time) {
+	if redaction.disabled.Load() {
+		return false, time.Time{}
+	}
+
+	if until := redaction.pausedUntil.Load(); until != 0 {
+		if t := time.Unix(0, until); redaction.now().Before(t) {
+			return false, t
+		}
+	}
+
+	return true, time.Time{}
+}
+
+// RedactSummary returns the summary of an event as it may be logged. Unless
+// redaction is disabled or paused, the summary is replaced by a short hash
+// so log lines of the same event can still be correlated.
+func RedactSummary(summary string) string {
+	if summary == "" {
+		return ""
+	}
+
+	if redact, _ := RedactionState(); !redact {
+		return summary
+	}
+
+	sum := sha256.Sum256([]byte(summary))
+
+	return "redacted:" + hex.EncodeToString(sum[:4])
+}
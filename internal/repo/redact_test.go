@@ -0,0 +1,143 @@
+package repo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"google.golang.org/api/calendar/v3"
+)
+
+func Test_RedactSummary(t *testing.T) {
+	now := time.Date(2024, time.June, 3, 8, 0, 0, 0, time.UTC)
+	redaction.now = func() time.Time { return now }
+
+	t.Cleanup(func() {
+		redaction.now = time.Now
+		redaction.pausedUntil.Store(0)
+		SetRedactPII(true)
+	})
+
+	redacted := RedactSummary("Bello (Maier)")
+	assert.NotContains(t, redacted, "Maier")
+	assert.Equal(t, redacted, RedactSummary("Bello (Maier)"))
+	assert.NotEqual(t, redacted, RedactSummary("Rex (Huber)"))
+	assert.Empty(t, RedactSummary(""))
+
+	// pausing is bounded
+	_, err := PauseRedaction(MaxRedactionPause + time.Minute)
+	assert.Error(t, err)
+
+	until, err := PauseRedaction(15 * time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, now.Add(15*time.Minute), until)
+	assert.Equal(t, "Bello (Maier)", RedactSummary("Bello (Maier)"))
+
+	redact, pausedUntil := RedactionState()
+	assert.False(t, redact)
+	assert.True(t, until.Equal(pausedUntil))
+
+	// redaction resumes automatically
+	now = now.Add(15 * time.Minute)
+	assert.Equal(t, redacted, RedactSummary("Bello (Maier)"))
+
+	_, err = PauseRedaction(time.Minute)
+	require.NoError(t, err)
+
+	_, err = PauseRedaction(0)
+	require.NoError(t, err)
+	assert.Equal(t, redacted, RedactSummary("Bello (Maier)"))
+
+	SetRedactPII(false)
+	assert.Equal(t, "Bello (Maier)", RedactSummary("Bello (Maier)"))
+}
+
+func Test_GoogleEventToModel_RedactsLogs(t *testing.T) {
+	logger, hook := logtest.NewNullLogger()
+
+	std := logrus.StandardLogger()
+	out, hooks := std.Out, std.Hooks
+	std.SetOutput(logger.Out)
+	std.ReplaceHooks(logrus.LevelHooks{})
+	std.AddHook(hook)
+
+	t.Cleanup(func() {
+		std.SetOutput(out)
+		std.ReplaceHooks(hooks)
+	})
+
+	_, err := googleEventToModel(context.Background(), "cal", &calendar.Event{
+		Id:          "1",
+		Summary:     "Bello (Maier)",
+		Description: "owner phone 0664 1234567",
+	})
+	require.ErrorIs(t, err, ErrInvalidEvent)
+
+	entry := hook.LastEntry()
+	require.NotNil(t, entry)
+	assert.Equal(t, "1", entry.Data["event-id"])
+	assert.Equal(t, RedactSummary("Bello (Maier)"), entry.Data["summary"])
+
+	line, err := entry.String()
+	require.NoError(t, err)
+	assert.NotContains(t, line, "Maier")
+	assert.NotContains(t, line, "0664")
+}
+
+func Test_CreateEvent_RedactsSpan(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+
+	provider := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	t.Cleanup(func() {
+		otel.SetTracerProvider(provider)
+	})
+
+	backend := newTestBackend(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			fmt.Fprint(w, `{"items": []}`)
+			return
+		}
+
+		var inserted calendar.Event
+		if err := json.NewDecoder(r.Body).Decode(&inserted); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		inserted.Id = "1"
+		_ = json.NewEncoder(w).Encode(inserted)
+	}))
+
+	_, err := backend.CreateEvent(context.Background(), "cal", "Bello (Maier)", "owner phone 0664 1234567", time.Date(2024, time.January, 1, 8, 0, 0, 0, time.UTC), time.Hour, nil)
+	require.NoError(t, err)
+
+	var found bool
+	for _, span := range exporter.GetSpans() {
+		if span.Name != "google.backend#CreateEvent" {
+			continue
+		}
+
+		found = true
+
+		for _, attr := range span.Attributes {
+			assert.NotContains(t, attr.Value.Emit(), "Maier", string(attr.Key))
+			assert.NotContains(t, attr.Value.Emit(), "0664", string(attr.Key))
+		}
+
+		assert.Contains(t, span.Attributes, attribute.String("calendar.name", RedactSummary("Bello (Maier)")))
+	}
+
+	assert.True(t, found, "CreateEvent span not exported")
+}
@@ -0,0 +1,447 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/bufbuild/connect-go"
+)
+
+// WriteChecker may be implemented by backends whose calendars differ in
+// writability, like CalDAV servers with read-only collections. CanWrite
+// reports whether events of calendarID can be created, updated or deleted.
+// Write operations of the Registry are only routed to the backend if it
+// reports true.
+type WriteChecker interface {
+	CanWrite(calendarID string) bool
+}
+
+// CalendarCreator may be implemented by backends that can create new
+// calendars. The Registry creates calendars in the first registered backend
+// that implements it.
+type CalendarCreator interface {
+	CreateCalendar(ctx context.Context, name string) (*Calendar, error)
+}
+
+// CalendarUpdate describes changes to the metadata of a calendar. Empty
+// fields are left unchanged.
+type CalendarUpdate struct {
+	Name string
+	// Color is the background color of the calendar in hex format, like
+	// #1e88e5.
+	Color string
+}
+
+// CalendarUpdater may be implemented by backends that can change the name
+// and color of their calendars. Updates are routed to the backend that owns
+// the calendar. Calendars that are read-only for events may still be
+// updated.
+type CalendarUpdater interface {
+	UpdateCalendar(ctx context.Context, calendarID string, update CalendarUpdate) error
+}
+
+type namedBackend struct {
+	name string
+	Service
+}
+
+// Registry is a Service that combines the calendars of multiple named
+// backends. Calendars are listed from all registered backends and event
+// operations are routed to the backend that owns the calendar.
+type Registry struct {
+	l        sync.RWMutex
+	backends []namedBackend
+
+	// owners maps calendar IDs to the name of the backend that owns the
+	// calendar. It is updated whenever calendars are listed.
+	owners map[string]string
+
+	// listeners are registered at all backends that implement
+	// ChangeNotifier, including backends registered later.
+	listeners []ChangeListener
+}
+
+// NewRegistry returns an empty backend registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		owners: make(map[string]string),
+	}
+}
+
+// Register adds a backend under name. Backends are queried in the order they
+// have been registered.
+func (r *Registry) Register(name string, backend Service) error {
+	r.l.Lock()
+	defer r.l.Unlock()
+
+	for _, b := range r.backends {
+		if b.name == name {
+			return fmt.Errorf("backend %q is already registered", name)
+		}
+	}
+
+	r.backends = append(r.backends, namedBackend{name: name, Service: backend})
+
+	if notifier, ok := backend.(ChangeNotifier); ok {
+		for _, fn := range r.listeners {
+			notifier.OnChange(fn)
+		}
+	}
+
+	return nil
+}
+
+// OnChange registers fn at all backends that detect event changes.
+func (r *Registry) OnChange(fn ChangeListener) {
+	r.l.Lock()
+	defer r.l.Unlock()
+
+	r.listeners = append(r.listeners, fn)
+
+	for _, b := range r.backends {
+		if notifier, ok := b.Service.(ChangeNotifier); ok {
+			notifier.OnChange(fn)
+		}
+	}
+}
+
+// Backends returns the names of all registered backends.
+func (r *Registry) Backends() []string {
+	r.l.RLock()
+	defer r.l.RUnlock()
+
+	names := make([]string, len(r.backends))
+	for idx, b := range r.backends {
+		names[idx] = b.name
+	}
+
+	return names
+}
+
+// ListCalendars returns the calendars of all registered backends. A failing
+// backend is skipped and the ownership of its calendars is kept from the
+// last successful listing. An error is only returned if all backends fail.
+func (r *Registry) ListCalendars(ctx context.Context) ([]Calendar, error) {
+	r.l.RLock()
+	backends := r.backends
+	r.l.RUnlock()
+
+	var (
+		result []Calendar
+		errs   []error
+		failed = make(map[string]bool)
+		owners = make(map[string]string)
+	)
+
+	for _, b := range backends {
+		calendars, err := b.ListCalendars(ctx)
+		if err != nil {
+			slog.Error("failed to list calendars", "backend", b.name, "error", err)
+
+			errs = append(errs, fmt.Errorf("%s: %w", b.name, err))
+			failed[b.name] = true
+
+			continue
+		}
+
+		checker, _ := b.Service.(WriteChecker)
+
+		for _, cal := range calendars {
+			cal.Backend = b.name
+			owners[cal.ID] = b.name
+
+			if checker != nil && !checker.CanWrite(cal.ID) {
+				cal.Readonly = true
+			}
+
+			result = append(result, cal)
+		}
+	}
+
+	if len(backends) > 0 && len(errs) == len(backends) {
+		return nil, errors.Join(errs...)
+	}
+
+	r.l.Lock()
+	defer r.l.Unlock()
+
+	for id, owner := range r.owners {
+		if _, ok := owners[id]; !ok && failed[owner] {
+			owners[id] = owner
+		}
+	}
+	r.owners = owners
+
+	return result, nil
+}
+
+// backendFor returns the backend that owns calID. If calID is unknown the
+// calendars are listed once more in case it has been created recently.
+func (r *Registry) backendFor(ctx context.Context, calID string) (namedBackend, error) {
+	if b, ok := r.lookup(calID); ok {
+		return b, nil
+	}
+
+	if _, err := r.ListCalendars(ctx); err != nil {
+		return namedBackend{}, err
+	}
+
+	if b, ok := r.lookup(calID); ok {
+		return b, nil
+	}
+
+	return namedBackend{}, connect.NewError(connect.CodeNotFound, fmt.Errorf("%w: calendar %q does not belong to any backend", ErrNotFound, calID))
+}
+
+// writerFor returns the backend that owns calID if events of calID can be
+// written.
+func (r *Registry) writerFor(ctx context.Context, calID string) (namedBackend, error) {
+	b, err := r.backendFor(ctx, calID)
+	if err != nil {
+		return namedBackend{}, err
+	}
+
+	if checker, ok := b.Service.(WriteChecker); ok && !checker.CanWrite(calID) {
+		return namedBackend{}, connect.NewError(connect.CodePermissionDenied, fmt.Errorf("%w: calendar %q is read-only in backend %q", ErrReadOnly, calID, b.name))
+	}
+
+	return b, nil
+}
+
+func (r *Registry) lookup(calID string) (namedBackend, bool) {
+	r.l.RLock()
+	defer r.l.RUnlock()
+
+	// there's nothing to route if only one backend is registered.
+	if len(r.backends) == 1 {
+		return r.backends[0], true
+	}
+
+	owner, ok := r.owners[calID]
+	if !ok {
+		return namedBackend{}, false
+	}
+
+	for _, b := range r.backends {
+		if b.name == owner {
+			return b, true
+		}
+	}
+
+	return namedBackend{}, false
+}
+
+// CreateCalendar implements CalendarCreator. The new calendar is owned by
+// the first backend that can create calendars.
+func (r *Registry) CreateCalendar(ctx context.Context, name string) (*Calendar, error) {
+	r.l.RLock()
+	backends := r.backends
+	r.l.RUnlock()
+
+	for _, b := range backends {
+		creator, ok := b.Service.(CalendarCreator)
+		if !ok {
+			continue
+		}
+
+		cal, err := creator.CreateCalendar(ctx, name)
+		if cal != nil {
+			cal.Backend = b.name
+
+			r.l.Lock()
+			r.owners[cal.ID] = b.name
+			r.l.Unlock()
+		}
+
+		return cal, err
+	}
+
+	return nil, connect.NewError(connect.CodeUnimplemented, fmt.Errorf("none of the backends can create calendars"))
+}
+
+// UpdateCalendar implements CalendarUpdater.
+func (r *Registry) UpdateCalendar(ctx context.Context, calendarID string, update CalendarUpdate) error {
+	b, err := r.backendFor(ctx, calendarID)
+	if err != nil {
+		return err
+	}
+
+	updater, ok := b.Service.(CalendarUpdater)
+	if !ok {
+		return connect.NewError(connect.CodeUnimplemented, fmt.Errorf("calendars of backend %q cannot be updated", b.name))
+	}
+
+	return updater.UpdateCalendar(ctx, calendarID, update)
+}
+
+func (r *Registry) ListEvents(ctx context.Context, calendarID string, filter ...SearchOption) ([]Event, error) {
+	b, err := r.backendFor(ctx, calendarID)
+	if err != nil {
+		return nil, err
+	}
+
+	return b.ListEvents(ctx, calendarID, filter...)
+}
+
+func (r *Registry) LoadEvent(ctx context.Context, calendarID string, eventID string, ignoreCache bool) (*Event, error) {
+	b, err := r.backendFor(ctx, calendarID)
+	if err != nil {
+		return nil, err
+	}
+
+	return b.LoadEvent(ctx, calendarID, eventID, ignoreCache)
+}
+
+func (r *Registry) CreateEvent(ctx context.Context, calID, name, description string, startTime time.Time, duration time.Duration, data *StructuredEvent, opts ...CreateOption) (*Event, error) {
+	b, err := r.writerFor(ctx, calID)
+	if err != nil {
+		return nil, err
+	}
+
+	return b.CreateEvent(ctx, calID, name, description, startTime, duration, data, opts...)
+}
+
+func (r *Registry) DeleteEvent(ctx context.Context, calID, eventID string) error {
+	b, err := r.writerFor(ctx, calID)
+	if err != nil {
+		return err
+	}
+
+	return b.DeleteEvent(ctx, calID, eventID)
+}
+
+// MoveEvent moves an event between calendars of the same backend.
+func (r *Registry) MoveEvent(ctx context.Context, originCalendarId, eventId, targetCalendarId string, opts ...MoveOption) (*Event, error) {
+	origin, err := r.writerFor(ctx, originCalendarId)
+	if err != nil {
+		return nil, err
+	}
+
+	target, err := r.writerFor(ctx, targetCalendarId)
+	if err != nil {
+		return nil, err
+	}
+
+	if origin.name != target.name {
+		return nil, connect.NewError(connect.CodeFailedPrecondition, fmt.Errorf("cannot move events from backend %q to %q", origin.name, target.name))
+	}
+
+	return origin.MoveEvent(ctx, originCalendarId, eventId, targetCalendarId, opts...)
+}
+
+func (r *Registry) UpdateEvent(ctx context.Context, event Event) (*Event, error) {
+	b, err := r.writerFor(ctx, event.CalendarID)
+	if err != nil {
+		return nil, err
+	}
+
+	return b.UpdateEvent(ctx, event)
+}
+
+func (r *Registry) UpdateEventStatus(ctx context.Context, calendarID, eventID, status, changedBy string) (*Event, error) {
+	b, err := r.writerFor(ctx, calendarID)
+	if err != nil {
+		return nil, err
+	}
+
+	return b.UpdateEventStatus(ctx, calendarID, eventID, status, changedBy)
+}
+
+func (r *Registry) CalendarVersion(ctx context.Context, calendarID string) (int64, error) {
+	b, err := r.backendFor(ctx, calendarID)
+	if err != nil {
+		return 0, err
+	}
+
+	return b.CalendarVersion(ctx, calendarID)
+}
+
+// DumpCacheState returns the event cache states of all backends. The
+// Backend field of each state is set to the name of the owning backend.
+func (r *Registry) DumpCacheState(ctx context.Context, calendarIDs []string, from, to time.Time) ([]CacheState, error) {
+	r.l.RLock()
+	backends := r.backends
+	r.l.RUnlock()
+
+	var res []CacheState
+	for _, b := range backends {
+		states, err := b.DumpCacheState(ctx, calendarIDs, from, to)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", b.name, err)
+		}
+
+		for idx := range states {
+			states[idx].Backend = b.name
+		}
+
+		res = append(res, states...)
+	}
+
+	return res, nil
+}
+
+// QuotaUsage returns the quota usage of all backends. The Backend field of
+// each entry is set to the name of the backend.
+func (r *Registry) QuotaUsage() []QuotaUsage {
+	r.l.RLock()
+	backends := r.backends
+	r.l.RUnlock()
+
+	var res []QuotaUsage
+	for _, b := range backends {
+		usage := b.QuotaUsage()
+		for idx := range usage {
+			usage[idx].Backend = b.name
+		}
+
+		res = append(res, usage...)
+	}
+
+	return res
+}
+
+// Close closes all registered backends. All backends are closed even if one
+// of them fails.
+func (r *Registry) Close(ctx context.Context) error {
+	r.l.RLock()
+	backends := r.backends
+	r.l.RUnlock()
+
+	var errs []error
+	for _, b := range backends {
+		if err := b.Close(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", b.name, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Prewarm prewarms the event caches of the given calendars in their owning
+// backends. Calendars that are not known yet are skipped.
+func (r *Registry) Prewarm(calendarIDs ...string) {
+	byBackend := make(map[string][]string)
+	for _, id := range calendarIDs {
+		b, ok := r.lookup(id)
+		if !ok {
+			slog.Debug("not prewarming unknown calendar", "calendar-id", id)
+			continue
+		}
+
+		byBackend[b.name] = append(byBackend[b.name], id)
+	}
+
+	r.l.RLock()
+	backends := r.backends
+	r.l.RUnlock()
+
+	for _, b := range backends {
+		if ids := byBackend[b.name]; len(ids) > 0 {
+			b.Prewarm(ids...)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	calendarv1 "github.com/tierklinik-dobersberg/apis/gen/go/tkd/calendar/v1"
+)
+
+// fakeBackend is a Service with a fixed set of calendars that records the
+// calendars events have been listed for.
+type fakeBackend struct {
+	Service
+
+	calendars []Calendar
+	err       error
+	listed    []string
+	prewarmed []string
+}
+
+func (f *fakeBackend) ListCalendars(context.Context) ([]Calendar, error) {
+	return f.calendars, f.err
+}
+
+func (f *fakeBackend) ListEvents(_ context.Context, calID string, _ ...SearchOption) ([]Event, error) {
+	f.listed = append(f.listed, calID)
+
+	return []Event{{ID: "evt", CalendarID: calID}}, nil
+}
+
+func (f *fakeBackend) Prewarm(ids ...string) {
+	f.prewarmed = append(f.prewarmed, ids...)
+}
+
+func Test_Registry(t *testing.T) {
+	ctx := context.Background()
+
+	google := &fakeBackend{calendars: []Calendar{{ID: "vet-1"}, {ID: "vet-2"}}}
+	ical := &fakeBackend{calendars: []Calendar{{ID: "holidays"}}}
+	third := &fakeBackend{calendars: []Calendar{{ID: "room-1"}}}
+
+	r := NewRegistry()
+	require.NoError(t, r.Register("google", google))
+	require.NoError(t, r.Register("ical", ical))
+	assert.Error(t, r.Register("google", third))
+
+	calendars, err := r.ListCalendars(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []Calendar{
+		{ID: "vet-1", Backend: "google"},
+		{ID: "vet-2", Backend: "google"},
+		{ID: "holidays", Backend: "ical"},
+	}, calendars)
+
+	// calendars of new backends appear automatically
+	require.NoError(t, r.Register("third", third))
+	assert.Equal(t, []string{"google", "ical", "third"}, r.Backends())
+
+	calendars, err = r.ListCalendars(ctx)
+	require.NoError(t, err)
+	require.Len(t, calendars, 4)
+	assert.Equal(t, Calendar{ID: "room-1", Backend: "third"}, calendars[3])
+
+	// events are loaded from the owning backend
+	_, err = r.ListEvents(ctx, "holidays")
+	require.NoError(t, err)
+	_, err = r.ListEvents(ctx, "room-1")
+	require.NoError(t, err)
+
+	assert.Empty(t, google.listed)
+	assert.Equal(t, []string{"holidays"}, ical.listed)
+	assert.Equal(t, []string{"room-1"}, third.listed)
+
+	_, err = r.ListEvents(ctx, "unknown")
+	assert.Equal(t, connect.CodeNotFound, connect.CodeOf(err))
+
+	// events cannot be moved between backends
+	_, err = r.MoveEvent(ctx, "vet-1", "evt", "room-1")
+	assert.Equal(t, connect.CodeFailedPrecondition, connect.CodeOf(err))
+
+	r.Prewarm("vet-2", "room-1", "unknown")
+	assert.Equal(t, []string{"vet-2"}, google.prewarmed)
+	assert.Equal(t, []string{"room-1"}, third.prewarmed)
+
+	// calendars of a failing backend are still routed
+	ical.err = errors.New("feed unreachable")
+
+	calendars, err = r.ListCalendars(ctx)
+	require.NoError(t, err)
+	assert.Len(t, calendars, 3)
+
+	_, err = r.ListEvents(ctx, "holidays")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"holidays", "holidays"}, ical.listed)
+}
+
+func Test_Registry_SingleBackend(t *testing.T) {
+	google := &fakeBackend{}
+
+	r := NewRegistry()
+	require.NoError(t, r.Register("google", google))
+
+	// with a single backend calendars do not need to be listed first
+	_, err := r.ListEvents(context.Background(), "vet-1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"vet-1"}, google.listed)
+}
+
+// mixedBackend is a fakeBackend with read-only calendars that records
+// created events.
+type mixedBackend struct {
+	fakeBackend
+
+	readonly map[string]bool
+	created  []string
+}
+
+func (m *mixedBackend) CanWrite(calendarID string) bool {
+	return !m.readonly[calendarID]
+}
+
+func (m *mixedBackend) CreateEvent(_ context.Context, calID, name, _ string, startTime time.Time, _ time.Duration, _ *StructuredEvent, _ ...CreateOption) (*Event, error) {
+	m.created = append(m.created, calID)
+
+	return &Event{ID: "evt", CalendarID: calID, Summary: name, StartTime: startTime}, nil
+}
+
+func (m *mixedBackend) DeleteEvent(context.Context, string, string) error {
+	return nil
+}
+
+func Test_Registry_WriteChecker(t *testing.T) {
+	ctx := context.Background()
+
+	caldav := &mixedBackend{
+		fakeBackend: fakeBackend{calendars: []Calendar{{ID: "vet-1"}, {ID: "shared"}}},
+		readonly:    map[string]bool{"shared": true},
+	}
+
+	r := NewRegistry()
+	require.NoError(t, r.Register("caldav", caldav))
+	require.NoError(t, r.Register("ical", &fakeBackend{calendars: []Calendar{{ID: "holidays"}}}))
+
+	calendars, err := r.ListCalendars(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []Calendar{
+		{ID: "vet-1", Backend: "caldav"},
+		{ID: "shared", Backend: "caldav", Readonly: true},
+		{ID: "holidays", Backend: "ical"},
+	}, calendars)
+
+	start := time.Date(2024, time.June, 3, 8, 0, 0, 0, time.UTC)
+
+	_, err = r.CreateEvent(ctx, "vet-1", "Bello", "", start, time.Hour, nil)
+	require.NoError(t, err)
+	require.NoError(t, r.DeleteEvent(ctx, "vet-1", "evt"))
+
+	// read-only calendars are rejected before reaching the backend
+	_, err = r.CreateEvent(ctx, "shared", "Bello", "", start, time.Hour, nil)
+	assert.Equal(t, connect.CodePermissionDenied, connect.CodeOf(err))
+	assert.Equal(t, []string{"vet-1"}, caldav.created)
+
+	err = r.DeleteEvent(ctx, "shared", "evt")
+	assert.Equal(t, connect.CodePermissionDenied, connect.CodeOf(err))
+
+	_, err = r.MoveEvent(ctx, "vet-1", "evt", "shared")
+	assert.Equal(t, connect.CodePermissionDenied, connect.CodeOf(err))
+
+	_, err = r.UpdateEventStatus(ctx, "shared", "evt", "confirmed", "")
+	assert.Equal(t, connect.CodePermissionDenied, connect.CodeOf(err))
+
+	// events of read-only calendars can still be listed
+	_, err = r.ListEvents(ctx, "shared")
+	require.NoError(t, err)
+}
+
+// notifyingBackend is a fakeBackend that reports changes to its listeners.
+type notifyingBackend struct {
+	fakeBackend
+
+	listeners changeListeners
+}
+
+func (n *notifyingBackend) OnChange(fn ChangeListener) {
+	n.listeners.add(fn)
+}
+
+func Test_Registry_OnChange(t *testing.T) {
+	google := &notifyingBackend{}
+	caldav := &notifyingBackend{}
+
+	r := NewRegistry()
+	require.NoError(t, r.Register("google", google))
+	require.NoError(t, r.Register("ical", &fakeBackend{}))
+
+	var changed []string
+	r.OnChange(func(change *calendarv1.CalendarChangeEvent) {
+		changed = append(changed, change.Calendar)
+	})
+
+	// listeners are registered at backends added later as well
+	require.NoError(t, r.Register("caldav", caldav))
+
+	google.listeners.notify(&calendarv1.CalendarChangeEvent{Calendar: "vet-1"})
+	caldav.listeners.notify(&calendarv1.CalendarChangeEvent{Calendar: "room-1"})
+
+	assert.Equal(t, []string{"vet-1", "room-1"}, changed)
+}
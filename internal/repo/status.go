@@ -0,0 +1,119 @@
+package repo
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"google.golang.org/api/calendar/v3"
+)
+
+// Appointment status of an event. Events without a status are planned.
+const (
+	StatusPlanned    = "planned"
+	StatusArrived    = "arrived"
+	StatusInProgress = "in-progress"
+	StatusDone       = "done"
+	StatusNoShow     = "no-show"
+)
+
+// Private extended properties that hold the appointment status of an event,
+// who changed it and when.
+const (
+	statusProperty          = "status"
+	statusChangedByProperty = "statusChangedBy"
+	statusChangedAtProperty = "statusChangedAt"
+)
+
+// statusTransitions lists the allowed status changes.
+var statusTransitions = map[string][]string{
+	StatusPlanned:    {StatusArrived, StatusNoShow},
+	StatusArrived:    {StatusInProgress},
+	StatusInProgress: {StatusDone},
+}
+
+// ParseStatus normalizes status and checks that it is a known appointment
+// status.
+func ParseStatus(status string) (string, error) {
+	status = strings.ToLower(strings.TrimSpace(status))
+
+	switch status {
+	case StatusPlanned, StatusArrived, StatusInProgress, StatusDone, StatusNoShow:
+		return status, nil
+	}
+
+	return "", fmt.Errorf("%w: unknown status %q", ErrInvalidEvent, status)
+}
+
+// ValidateStatusTransition checks that the status of an event may be changed
+// from one status to another. An empty status is StatusPlanned.
+func ValidateStatusTransition(from, to string) error {
+	if from == "" {
+		from = StatusPlanned
+	}
+
+	if !slices.Contains(statusTransitions[from], to) {
+		return fmt.Errorf("%w: status cannot change from %q to %q", ErrInvalidEvent, from, to)
+	}
+
+	return nil
+}
+
+// CurrentStatus returns the appointment status of the event.
+func (model *Event) CurrentStatus() string {
+	if model.Status == "" {
+		return StatusPlanned
+	}
+
+	return model.Status
+}
+
+// HasStatus reports whether the event has one of the given statuses.
+func (model *Event) HasStatus(statuses ...string) bool {
+	return slices.Contains(statuses, model.CurrentStatus())
+}
+
+// eventStatus reads the appointment status from the private extended
+// properties of item. Unknown values are ignored.
+func eventStatus(item *calendar.Event) (status, changedBy string, changedAt time.Time) {
+	if item.ExtendedProperties == nil {
+		return "", "", time.Time{}
+	}
+
+	props := item.ExtendedProperties.Private
+
+	status, err := ParseStatus(props[statusProperty])
+	if err != nil || status == StatusPlanned {
+		return "", "", time.Time{}
+	}
+
+	// the time is informational only, ignore malformed values.
+	changedAt, _ = time.Parse(time.RFC3339, props[statusChangedAtProperty])
+
+	return status, props[statusChangedByProperty], changedAt
+}
+
+// withStatusProperties adds the appointment status of event to props.
+func withStatusProperties(props *calendar.EventExtendedProperties, event Event) *calendar.EventExtendedProperties {
+	if event.Status == "" || event.Status == StatusPlanned {
+		return props
+	}
+
+	if props == nil {
+		props = &calendar.EventExtendedProperties{}
+	}
+
+	if props.Private == nil {
+		props.Private = make(map[string]string)
+	}
+
+	props.Private[statusProperty] = event.Status
+	props.Private[statusChangedByProperty] = event.StatusChangedBy
+
+	if !event.StatusChangedAt.IsZero() {
+		props.Private[statusChangedAtProperty] = event.StatusChangedAt.UTC().Format(time.RFC3339)
+	}
+
+	return props
+}
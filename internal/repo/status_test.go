@@ -0,0 +1,178 @@
+package repo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/calendar/v3"
+)
+
+func Test_ValidateStatusTransition(t *testing.T) {
+	valid := [][2]string{
+		{"", StatusArrived},
+		{StatusPlanned, StatusArrived},
+		{StatusArrived, StatusInProgress},
+		{StatusInProgress, StatusDone},
+		{StatusPlanned, StatusNoShow},
+	}
+
+	for _, tc := range valid {
+		assert.NoError(t, ValidateStatusTransition(tc[0], tc[1]), "%s -> %s", tc[0], tc[1])
+	}
+
+	invalid := [][2]string{
+		{StatusPlanned, StatusPlanned},
+		{StatusPlanned, StatusInProgress},
+		{StatusPlanned, StatusDone},
+		{StatusArrived, StatusNoShow},
+		{StatusArrived, StatusPlanned},
+		{StatusInProgress, StatusArrived},
+		{StatusDone, StatusInProgress},
+		{StatusNoShow, StatusArrived},
+	}
+
+	for _, tc := range invalid {
+		assert.ErrorIs(t, ValidateStatusTransition(tc[0], tc[1]), ErrInvalidEvent, "%s -> %s", tc[0], tc[1])
+	}
+
+	status, err := ParseStatus(" In-Progress")
+	require.NoError(t, err)
+	assert.Equal(t, StatusInProgress, status)
+
+	_, err = ParseStatus("waiting")
+	assert.ErrorIs(t, err, ErrInvalidEvent)
+}
+
+func Test_EventStatus_ExtendedProperties(t *testing.T) {
+	changedAt := time.Date(2024, time.January, 1, 8, 5, 0, 0, time.UTC)
+
+	props, err := eventProperties(EventSourceCisCal, []string{"surgery"}, "")
+	require.NoError(t, err)
+
+	props = withStatusProperties(props, Event{Status: StatusArrived, StatusChangedBy: "alice", StatusChangedAt: changedAt})
+	assert.Equal(t, map[string]string{
+		"source":          EventSourceCisCal,
+		"apiVersion":      SourceAPIVersion,
+		"tags":            `["surgery"]`,
+		"status":          StatusArrived,
+		"statusChangedBy": "alice",
+		"statusChangedAt": "2024-01-01T08:05:00Z",
+	}, props.Private)
+
+	evt, err := googleEventToModel(context.Background(), "cal", &calendar.Event{
+		Id:                 "1",
+		Start:              &calendar.EventDateTime{DateTime: "2024-01-01T08:00:00Z"},
+		End:                &calendar.EventDateTime{DateTime: "2024-01-01T09:00:00Z"},
+		ExtendedProperties: props,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, StatusArrived, evt.Status)
+	assert.Equal(t, "alice", evt.StatusChangedBy)
+	assert.True(t, changedAt.Equal(evt.StatusChangedAt))
+	assert.True(t, evt.HasStatus(StatusArrived, StatusInProgress))
+	assert.False(t, evt.HasStatus(StatusPlanned))
+
+	// planned events do not store a status
+	assert.Nil(t, withStatusProperties(nil, Event{Status: StatusPlanned}))
+
+	evt, err = googleEventToModel(context.Background(), "cal", &calendar.Event{
+		Id:    "2",
+		Start: &calendar.EventDateTime{DateTime: "2024-01-01T08:00:00Z"},
+		End:   &calendar.EventDateTime{DateTime: "2024-01-01T09:00:00Z"},
+		ExtendedProperties: &calendar.EventExtendedProperties{
+			Private: map[string]string{"status": "unknown"},
+		},
+	})
+	require.NoError(t, err)
+	assert.Empty(t, evt.Status)
+	assert.Equal(t, StatusPlanned, evt.CurrentStatus())
+}
+
+func Test_UpdateEventStatus(t *testing.T) {
+	var (
+		status  = StatusPlanned
+		ifMatch string
+		patched map[string]string
+	)
+
+	backend := newTestBackend(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && !strings.HasSuffix(r.URL.Path, "/events/1"):
+			// event caches created by the update
+			fmt.Fprint(w, `{"items": []}`)
+
+		case r.Method == http.MethodGet:
+			fmt.Fprintf(w, `{"id": "1", "etag": "\"1\"", "start": {"dateTime": "2024-01-01T08:00:00Z"}, "end": {"dateTime": "2024-01-01T09:00:00Z"}, "extendedProperties": {"private": {"status": %q}}}`, status)
+
+		case r.Method == http.MethodPatch:
+			if ifMatch = r.Header.Get("If-Match"); ifMatch != `"1"` {
+				w.WriteHeader(http.StatusPreconditionFailed)
+				return
+			}
+
+			var body calendar.Event
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+
+			patched = body.ExtendedProperties.Private
+
+			body.Id = "1"
+			body.Start = &calendar.EventDateTime{DateTime: "2024-01-01T08:00:00Z"}
+			body.End = &calendar.EventDateTime{DateTime: "2024-01-01T09:00:00Z"}
+
+			_ = json.NewEncoder(w).Encode(body)
+
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}))
+
+	ctx := context.Background()
+
+	evt, err := backend.UpdateEventStatus(ctx, "cal", "1", StatusArrived, "alice")
+	require.NoError(t, err)
+	assert.Equal(t, `"1"`, ifMatch)
+	assert.Equal(t, StatusArrived, patched["status"])
+	assert.Equal(t, "alice", patched["statusChangedBy"])
+	assert.NotEmpty(t, patched["statusChangedAt"])
+	assert.Equal(t, StatusArrived, evt.Status)
+	assert.Equal(t, "alice", evt.StatusChangedBy)
+
+	// invalid transitions are rejected before patching the event
+	patched = nil
+	_, err = backend.UpdateEventStatus(ctx, "cal", "1", StatusDone, "alice")
+	assert.ErrorIs(t, err, ErrInvalidEvent)
+	assert.Nil(t, patched)
+
+	// transitions start from the stored status
+	status = StatusArrived
+
+	_, err = backend.UpdateEventStatus(ctx, "cal", "1", StatusInProgress, "bob")
+	require.NoError(t, err)
+	assert.Equal(t, StatusInProgress, patched["status"])
+}
+
+func Test_UpdateEventStatus_Conflict(t *testing.T) {
+	backend := newTestBackend(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			fmt.Fprint(w, `{"id": "1", "etag": "\"1\"", "start": {"dateTime": "2024-01-01T08:00:00Z"}, "end": {"dateTime": "2024-01-01T09:00:00Z"}}`)
+		default:
+			// the event has been modified after it was loaded
+			w.WriteHeader(http.StatusPreconditionFailed)
+		}
+	}))
+
+	_, err := backend.UpdateEventStatus(context.Background(), "cal", "1", StatusArrived, "alice")
+	assert.Equal(t, connect.CodeAborted, connect.CodeOf(err))
+}
@@ -0,0 +1,37 @@
+package repo
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Google calendar event visibilities. Events without a visibility use the
+// default visibility of their calendar. Confidential is treated like
+// private.
+const (
+	VisibilityDefault      = "default"
+	VisibilityPublic       = "public"
+	VisibilityPrivate      = "private"
+	VisibilityConfidential = "confidential"
+)
+
+// ParseVisibility normalizes visibility and checks that it is a known event
+// visibility. VisibilityDefault is returned as an empty string.
+func ParseVisibility(visibility string) (string, error) {
+	visibility = strings.ToLower(strings.TrimSpace(visibility))
+
+	switch visibility {
+	case "", VisibilityDefault:
+		return "", nil
+	case VisibilityPublic, VisibilityPrivate, VisibilityConfidential:
+		return visibility, nil
+	}
+
+	return "", fmt.Errorf("%w: unknown visibility %q", ErrInvalidEvent, visibility)
+}
+
+// IsPrivate reports whether the details of the event may only be seen by
+// the owner of the calendar.
+func (e Event) IsPrivate() bool {
+	return e.Visibility == VisibilityPrivate || e.Visibility == VisibilityConfidential
+}
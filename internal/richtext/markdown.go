@@ -0,0 +1,212 @@
+package richtext
+
+import (
+	"html"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	xhtml "golang.org/x/net/html"
+)
+
+var (
+	unorderedItem = regexp.MustCompile(`^[-*]\s+`)
+	orderedItem   = regexp.MustCompile(`^\d+\.\s+`)
+
+	linkPattern   = regexp.MustCompile(`\[([^\]]+)\]\(([^)\s]+)\)`)
+	strongPattern = regexp.MustCompile(`\*\*([^*]+)\*\*`)
+	emPattern     = regexp.MustCompile(`\*([^*]+)\*`)
+)
+
+// MarkdownToHTML converts a small Markdown subset to HTML: paragraphs, line
+// breaks, unordered and ordered lists, **strong**, *emphasis*, `code` and
+// [links](https://example.com). Everything else is kept as text.
+func MarkdownToHTML(md string) string {
+	md = strings.ReplaceAll(md, "\r\n", "\n")
+
+	var buf strings.Builder
+	for _, block := range strings.Split(md, "\n\n") {
+		lines := strings.Split(strings.Trim(block, "\n"), "\n")
+		if len(lines) == 1 && strings.TrimSpace(lines[0]) == "" {
+			continue
+		}
+
+		switch {
+		case allMatch(lines, unorderedItem):
+			writeList(&buf, "ul", lines, unorderedItem)
+
+		case allMatch(lines, orderedItem):
+			writeList(&buf, "ol", lines, orderedItem)
+
+		default:
+			buf.WriteString("<p>")
+			for idx, line := range lines {
+				if idx > 0 {
+					buf.WriteString("<br>")
+				}
+				buf.WriteString(inlineToHTML(line))
+			}
+			buf.WriteString("</p>")
+		}
+	}
+
+	return buf.String()
+}
+
+func allMatch(lines []string, re *regexp.Regexp) bool {
+	for _, l := range lines {
+		if !re.MatchString(l) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func writeList(buf *strings.Builder, tag string, lines []string, marker *regexp.Regexp) {
+	buf.WriteString("<" + tag + ">")
+	for _, l := range lines {
+		buf.WriteString("<li>" + inlineToHTML(marker.ReplaceAllString(l, "")) + "</li>")
+	}
+	buf.WriteString("</" + tag + ">")
+}
+
+// inlineToHTML converts the inline elements of a single line.
+func inlineToHTML(line string) string {
+	var buf strings.Builder
+
+	// odd segments are code spans
+	for idx, segment := range strings.Split(line, "`") {
+		segment = html.EscapeString(segment)
+
+		if idx%2 == 1 {
+			buf.WriteString("<code>" + segment + "</code>")
+			continue
+		}
+
+		segment = linkPattern.ReplaceAllStringFunc(segment, func(m string) string {
+			parts := linkPattern.FindStringSubmatch(m)
+
+			// the URL has already been escaped
+			if !isSafeURL(html.UnescapeString(parts[2])) {
+				return parts[1]
+			}
+
+			return `<a href="` + parts[2] + `">` + parts[1] + "</a>"
+		})
+		segment = strongPattern.ReplaceAllString(segment, "<strong>$1</strong>")
+		segment = emPattern.ReplaceAllString(segment, "<em>$1</em>")
+
+		buf.WriteString(segment)
+	}
+
+	return buf.String()
+}
+
+// HTMLToMarkdown converts HTML back to Markdown. It supports the elements
+// created by MarkdownToHTML so descriptions written as Markdown can be
+// returned as Markdown. Other elements are removed and their text is kept.
+func HTMLToMarkdown(s string) string {
+	var (
+		buf strings.Builder
+
+		// lists holds the item counter of each open list, -1 for
+		// unordered lists.
+		lists []int
+
+		// links holds the href of each open link.
+		links []string
+	)
+
+	z := xhtml.NewTokenizer(strings.NewReader(s))
+
+	for {
+		tt := z.Next()
+		if tt == xhtml.ErrorToken {
+			if z.Err() != io.EOF {
+				return ""
+			}
+
+			break
+		}
+
+		token := z.Token()
+
+		switch tt {
+		case xhtml.TextToken:
+			buf.WriteString(token.Data)
+
+		case xhtml.StartTagToken, xhtml.SelfClosingTagToken:
+			switch token.Data {
+			case "br":
+				buf.WriteString("\n")
+			case "strong", "b":
+				buf.WriteString("**")
+			case "em", "i":
+				buf.WriteString("*")
+			case "code":
+				buf.WriteString("`")
+			case "ul":
+				lists = append(lists, -1)
+			case "ol":
+				lists = append(lists, 0)
+			case "li":
+				if len(lists) == 0 {
+					buf.WriteString("- ")
+					break
+				}
+
+				if n := lists[len(lists)-1]; n >= 0 {
+					lists[len(lists)-1]++
+					buf.WriteString(strconv.Itoa(n+1) + ". ")
+				} else {
+					buf.WriteString("- ")
+				}
+			case "a":
+				var href string
+				for _, attr := range token.Attr {
+					if attr.Key == "href" {
+						href = attr.Val
+					}
+				}
+
+				links = append(links, href)
+				buf.WriteString("[")
+			}
+
+		case xhtml.EndTagToken:
+			switch token.Data {
+			case "p":
+				buf.WriteString("\n\n")
+			case "strong", "b":
+				buf.WriteString("**")
+			case "em", "i":
+				buf.WriteString("*")
+			case "code":
+				buf.WriteString("`")
+			case "li":
+				buf.WriteString("\n")
+			case "ul", "ol":
+				if len(lists) > 0 {
+					lists = lists[:len(lists)-1]
+				}
+				buf.WriteString("\n")
+			case "a":
+				if len(links) == 0 {
+					break
+				}
+
+				buf.WriteString("](" + links[len(links)-1] + ")")
+				links = links[:len(links)-1]
+			}
+		}
+	}
+
+	result := strings.TrimRight(buf.String(), "\n")
+	for strings.Contains(result, "\n\n\n") {
+		result = strings.ReplaceAll(result, "\n\n\n", "\n\n")
+	}
+
+	return result
+}
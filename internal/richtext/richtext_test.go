@@ -0,0 +1,99 @@
+package richtext
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Sanitize(t *testing.T) {
+	cases := []struct {
+		name string
+		in   string
+		out  string
+	}{
+		{"plain text", "Bello\nImpfung & Kontrolle", "Bello\nImpfung & Kontrolle"},
+		{"entities are kept", "a &amp; b &lt;c&gt;", "a &amp; b &lt;c&gt;"},
+		{"allowed tags", "<b>Bello</b><br><ul><li>one</li></ul>", "<b>Bello</b><br><ul><li>one</li></ul>"},
+		{"script", "Bello<script>alert(1)</script>!", "Bello!"},
+		{"style", "<style>body{display:none}</style>Bello", "Bello"},
+		{"unknown tags keep their text", "<div><span>Bello</span></div>", "Bello"},
+		{"attributes", `<b onclick="alert(1)" style="color:red">Bello</b>`, "<b>Bello</b>"},
+		{"event handler on disallowed tag", `<img src=x onerror=alert(1)>`, ""},
+		{"svg", `<svg onload=alert(1)><circle/></svg>Bello`, "Bello"},
+		{"safe link", `<a href="https://example.com/?a=1&amp;b=2" target="_blank">x</a>`, `<a href="https://example.com/?a=1&amp;b=2">x</a>`},
+		{"javascript link", `<a href="javascript:alert(1)">x</a>`, "<a>x</a>"},
+		{"encoded javascript link", `<a href="jav&#x61;script:alert(1)">x</a>`, "<a>x</a>"},
+		{"javascript link with tab", "<a href=\"java\tscript:alert(1)\">x</a>", "<a>x</a>"},
+		{"data link", `<a href="data:text/html;base64,PHNjcmlwdD4=">x</a>`, "<a>x</a>"},
+		{"relative link", `<a href="/admin">x</a>`, "<a>x</a>"},
+		{"comments", "Bello<!-- <script>alert(1)</script> -->", "Bello"},
+		{"unclosed tags", "<b><i>Bello", "<b><i>Bello</i></b>"},
+		{"misnested tags", "<b><i>Bello</b></i>", "<b><i>Bello</i></b>"},
+		{"stray end tags", "Bello</b></p>", "Bello"},
+		{"tag formed by removed element", "<<script>x</script>img src=x onerror=alert(1)>", "&lt;img src=x onerror=alert(1)>"},
+		{"unterminated script", "Bello<script>alert(1)", "Bello"},
+		{"iframe", `<iframe src="https://evil.example"></iframe>Bello`, "Bello"},
+		{"uppercase", "<SCRIPT>alert(1)</SCRIPT><B>Bello</B>", "<b>Bello</b>"},
+	}
+
+	for _, c := range cases {
+		assert.Equal(t, c.out, Sanitize(c.in), c.name)
+	}
+}
+
+func Test_MarkdownToHTML(t *testing.T) {
+	cases := []struct {
+		name string
+		in   string
+		out  string
+	}{
+		{"paragraphs", "Bello\nImpfung\n\nKontrolle", "<p>Bello<br>Impfung</p><p>Kontrolle</p>"},
+		{"inline", "**OP** am *Montag* mit `Narkose`", "<p><strong>OP</strong> am <em>Montag</em> mit <code>Narkose</code></p>"},
+		{"lists", "- one\n- two\n\n1. first\n2. second", "<ul><li>one</li><li>two</li></ul><ol><li>first</li><li>second</li></ol>"},
+		{"link", "[Befund](https://example.com/a?b=1&c=2)", `<p><a href="https://example.com/a?b=1&amp;c=2">Befund</a></p>`},
+		{"unsafe link", "[click](javascript:void)", "<p>click</p>"},
+		{"html is escaped", "<script>alert(1)</script>", "<p>&lt;script&gt;alert(1)&lt;/script&gt;</p>"},
+		{"windows line endings", "a\r\nb", "<p>a<br>b</p>"},
+		{"empty", "", ""},
+	}
+
+	for _, c := range cases {
+		out := MarkdownToHTML(c.in)
+		assert.Equal(t, c.out, out, c.name)
+
+		// the output must survive sanitization
+		assert.Equal(t, out, Sanitize(out), c.name)
+	}
+}
+
+func Test_MarkdownRoundTrip(t *testing.T) {
+	cases := []string{
+		"Bello\nImpfung\n\nKontrolle",
+		"**OP** am *Montag* mit `Narkose`",
+		"- one\n- two\n\n1. first\n2. second",
+		"Befund: [PDF](https://example.com/a?b=1&c=2)",
+		"a < b & c",
+	}
+
+	for _, md := range cases {
+		assert.Equal(t, md, HTMLToMarkdown(MarkdownToHTML(md)))
+	}
+}
+
+func Test_ParseFormat(t *testing.T) {
+	for in, want := range map[string]Format{
+		"":         FormatHTML,
+		"text":     FormatHTML,
+		"HTML":     FormatHTML,
+		"markdown": FormatMarkdown,
+	} {
+		f, err := ParseFormat(in)
+		require.NoError(t, err, in)
+		assert.Equal(t, want, f, in)
+	}
+
+	_, err := ParseFormat("rtf")
+	assert.Error(t, err)
+}
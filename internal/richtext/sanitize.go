@@ -0,0 +1,160 @@
+// Package richtext sanitizes event descriptions and converts them between
+// Markdown and the HTML subset used by Google Calendar.
+package richtext
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"net/url"
+	"slices"
+	"strings"
+
+	xhtml "golang.org/x/net/html"
+)
+
+// Format is the format of an event description.
+type Format string
+
+const (
+	// FormatHTML descriptions are sanitized but otherwise stored as is.
+	// Plain text is a subset of FormatHTML.
+	FormatHTML Format = "html"
+
+	// FormatMarkdown descriptions are converted to HTML before they are
+	// stored.
+	FormatMarkdown Format = "markdown"
+)
+
+// ParseFormat parses a description format. An empty value is FormatHTML.
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(strings.ToLower(strings.TrimSpace(s))); f {
+	case "", "text", FormatHTML:
+		return FormatHTML, nil
+	case FormatMarkdown:
+		return f, nil
+	default:
+		return "", fmt.Errorf("unsupported description format %q", s)
+	}
+}
+
+// allowedTags are kept by Sanitize, all other tags are removed.
+var allowedTags = []string{"a", "b", "br", "code", "em", "i", "li", "ol", "p", "pre", "strong", "u", "ul"}
+
+// voidTags do not have an end tag.
+var voidTags = []string{"br"}
+
+// droppedTags are removed including their content.
+var droppedTags = []string{"iframe", "noembed", "noframes", "noscript", "object", "script", "style", "template", "textarea", "title", "xmp"}
+
+// allowedSchemes are the URL schemes allowed in links.
+var allowedSchemes = []string{"http", "https", "mailto", "tel"}
+
+// Sanitize removes all tags and attributes from s that are not part of a
+// small allow-list. The content of script, style and similar elements is
+// removed as well, comments are dropped and unclosed tags are closed. Text
+// is kept as is, except for less-than signs which are escaped.
+func Sanitize(s string) string {
+	var (
+		buf  strings.Builder
+		open []string
+
+		// skip is the dropped element whose content is currently
+		// skipped.
+		skip string
+	)
+
+	z := xhtml.NewTokenizer(strings.NewReader(s))
+
+	for {
+		tt := z.Next()
+		if tt == xhtml.ErrorToken {
+			if z.Err() != io.EOF {
+				// the tokenizer only fails on read errors which cannot
+				// happen for a strings.Reader
+				return ""
+			}
+
+			break
+		}
+
+		// Token may change the contents of Raw
+		raw := string(z.Raw())
+		token := z.Token()
+
+		if skip != "" {
+			if tt == xhtml.EndTagToken && token.Data == skip {
+				skip = ""
+			}
+
+			continue
+		}
+
+		switch tt {
+		case xhtml.TextToken:
+			// keep the raw text so entities are not unescaped. A
+			// less-than sign that did not start a tag must still be
+			// escaped as it might form a tag together with the text
+			// following a removed element.
+			buf.WriteString(strings.ReplaceAll(raw, "<", "&lt;"))
+
+		case xhtml.StartTagToken, xhtml.SelfClosingTagToken:
+			if slices.Contains(droppedTags, token.Data) {
+				if tt == xhtml.StartTagToken {
+					skip = token.Data
+				}
+
+				continue
+			}
+
+			if !slices.Contains(allowedTags, token.Data) {
+				continue
+			}
+
+			buf.WriteString("<" + token.Data)
+			if token.Data == "a" {
+				for _, attr := range token.Attr {
+					if attr.Key == "href" && attr.Namespace == "" && isSafeURL(attr.Val) {
+						buf.WriteString(` href="` + html.EscapeString(attr.Val) + `"`)
+					}
+				}
+			}
+			buf.WriteString(">")
+
+			if !slices.Contains(voidTags, token.Data) {
+				open = append(open, token.Data)
+			}
+
+		case xhtml.EndTagToken:
+			idx := slices.Index(open, token.Data)
+			if idx < 0 {
+				continue
+			}
+
+			// close all elements that have been opened afterwards
+			for i := len(open) - 1; i >= idx; i-- {
+				buf.WriteString("</" + open[i] + ">")
+			}
+			open = open[:idx]
+		}
+
+		// comments and doctypes are dropped
+	}
+
+	for i := len(open) - 1; i >= 0; i-- {
+		buf.WriteString("</" + open[i] + ">")
+	}
+
+	return buf.String()
+}
+
+// isSafeURL reports whether u is an absolute URL with one of the allowed
+// schemes.
+func isSafeURL(u string) bool {
+	parsed, err := url.Parse(strings.TrimSpace(u))
+	if err != nil {
+		return false
+	}
+
+	return slices.Contains(allowedSchemes, strings.ToLower(parsed.Scheme))
+}
@@ -0,0 +1,24 @@
+package services
+
+import (
+	"github.com/tierklinik-dobersberg/cis-cal/internal/repo"
+)
+
+// excludeAbsencesHeader may be set to true on ListEvents requests to
+// exclude out-of-office and focus-time events from the results. Absences
+// are still considered for free slots. The ListEventsRequest does not yet
+// have a field for it.
+const excludeAbsencesHeader = "X-Exclude-Absences"
+
+// withoutAbsences removes all out-of-office and focus-time events from
+// events.
+func withoutAbsences(events []repo.Event) []repo.Event {
+	result := events[:0]
+	for _, e := range events {
+		if !e.IsAbsence() {
+			result = append(result, e)
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,31 @@
+package services
+
+import (
+	"log/slog"
+	"net/http"
+	"slices"
+)
+
+// authorize checks the headers set by the forward-auth proxy for the plain
+// HTTP endpoints that are not covered by the auth interceptor. It replies
+// 401 Unauthorized if the request does not carry X-Remote-User-ID and 403
+// Forbidden if allowedRoles is not empty and the caller has none of them.
+// Denied requests are logged with the path and the calling user.
+func authorize(w http.ResponseWriter, r *http.Request, allowedRoles []string) bool {
+	user := r.Header.Get("X-Remote-User-ID")
+	if user == "" {
+		slog.Info("rejected unauthenticated request", "path", r.URL.Path)
+		http.Error(w, "authentication required", http.StatusUnauthorized)
+		return false
+	}
+
+	if len(allowedRoles) > 0 && !slices.ContainsFunc(r.Header.Values("X-Remote-Role"), func(role string) bool {
+		return slices.Contains(allowedRoles, role)
+	}) {
+		slog.Info("rejected request without an allowed role", "path", r.URL.Path, "user", user)
+		http.Error(w, "permission denied", http.StatusForbidden)
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,41 @@
+package services
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Authorize(t *testing.T) {
+	cases := []struct {
+		user         string
+		roles        []string
+		allowedRoles []string
+		code         int
+	}{
+		{"", []string{"admin"}, []string{"admin"}, http.StatusUnauthorized},
+		{"", nil, nil, http.StatusUnauthorized},
+		{"alice", nil, nil, http.StatusOK},
+		{"alice", []string{"vet"}, []string{"admin"}, http.StatusForbidden},
+		{"alice", []string{"vet", "admin"}, []string{"admin"}, http.StatusOK},
+	}
+
+	for _, c := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/debug/cache", nil)
+		if c.user != "" {
+			req.Header.Set("X-Remote-User-ID", c.user)
+		}
+		for _, role := range c.roles {
+			req.Header.Add("X-Remote-Role", role)
+		}
+
+		rec := httptest.NewRecorder()
+		if authorize(rec, req, c.allowedRoles) {
+			rec.WriteHeader(http.StatusOK)
+		}
+
+		assert.Equal(t, c.code, rec.Code, "%+v", c)
+	}
+}
@@ -0,0 +1,450 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sort"
+	"time"
+
+	calendarv1 "github.com/tierklinik-dobersberg/apis/gen/go/tkd/calendar/v1"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/repo"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+const (
+	// maxBackupRange is the maximum time range of a single calendar
+	// export.
+	maxBackupRange = 5 * 366 * 24 * time.Hour
+
+	// maxImportSize is the maximum size of an import in bytes.
+	maxImportSize = 32 << 20
+
+	backupFormatNDJSON = "ndjson"
+	backupFormatICS    = "ics"
+)
+
+// backupFormat returns the backup format requested by r. It defaults to
+// newline delimited JSON.
+func backupFormat(r *http.Request) (string, error) {
+	switch format := r.URL.Query().Get("format"); format {
+	case "", backupFormatNDJSON:
+		return backupFormatNDJSON, nil
+	case backupFormatICS:
+		return backupFormatICS, nil
+	default:
+		return "", fmt.Errorf("unsupported format %q, expected ndjson or ics", format)
+	}
+}
+
+// CalendarExportHandler streams all events of a calendar as a backup that
+// can be restored using the CalendarImportHandler:
+//
+//	GET /calendars/export?calendar=<id>&from=2024-01-01&to=2025-01-01&format=ndjson
+//
+// The ndjson format writes one backupRecord per line. The ics format writes
+// an iCalendar stream. Only callers with one of the allowed roles
+// (X-Remote-Role) may export calendars. Backups are never redacted since
+// private events could not be restored otherwise, so the allowed roles
+// must only be given to users that may see all events.
+type CalendarExportHandler struct {
+	svc          *CalendarService
+	allowedRoles []string
+}
+
+// NewCalendarExportHandler returns a new calendar export handler for svc.
+func NewCalendarExportHandler(svc *CalendarService, allowedRoles []string) *CalendarExportHandler {
+	return &CalendarExportHandler{
+		svc:          svc,
+		allowedRoles: allowedRoles,
+	}
+}
+
+func (h *CalendarExportHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !authorize(w, r, h.allowedRoles) {
+		return
+	}
+
+	query := r.URL.Query()
+
+	calID := query.Get("calendar")
+	if calID == "" {
+		http.Error(w, "missing value for calendar", http.StatusBadRequest)
+		return
+	}
+
+	cal, ok := h.svc.calendarById.Get(calID)
+	if !ok {
+		http.Error(w, fmt.Sprintf("calendar %q not found", calID), http.StatusNotFound)
+		return
+	}
+
+	from, err := time.ParseInLocation("2006-01-02", query.Get("from"), time.Local)
+	if err != nil {
+		http.Error(w, "invalid or missing value for from, expected YYYY-MM-DD", http.StatusBadRequest)
+		return
+	}
+
+	to, err := time.ParseInLocation("2006-01-02", query.Get("to"), time.Local)
+	if err != nil {
+		http.Error(w, "invalid or missing value for to, expected YYYY-MM-DD", http.StatusBadRequest)
+		return
+	}
+
+	if !to.After(from) || to.Sub(from) > maxBackupRange {
+		http.Error(w, fmt.Sprintf("to must be after from and the range must not exceed %s", maxBackupRange), http.StatusBadRequest)
+		return
+	}
+
+	format, err := backupFormat(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	events, err := h.svc.repo.ListEvents(r.Context(), calID, repo.WithEventsAfter(from), repo.WithEventsBefore(to))
+	if err != nil {
+		http.Error(w, err.Error(), httpStatus(err))
+		return
+	}
+
+	sort.Stable(repo.ByStartTime(events))
+
+	events = slices.DeleteFunc(events, func(e repo.Event) bool {
+		return !e.StartTime.Before(to)
+	})
+
+	filename := fmt.Sprintf("%s-%s-%s.%s", calID, from.Format("20060102"), to.Format("20060102"), format)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+
+	if format == backupFormatICS {
+		w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+
+		if err := writeICS(w, cal, events, time.Now()); err != nil {
+			slog.Error("failed to write calendar export", "calendar-id", calID, "error", err)
+		}
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+
+	bw := bufio.NewWriter(w)
+	for _, e := range events {
+		pb, err := e.ToProto()
+		if err != nil {
+			slog.Error("failed to convert event for export", "calendar-id", calID, "event-id", e.ID, "error", err)
+			return
+		}
+
+		blob, err := protojson.Marshal(pb)
+		if err != nil {
+			slog.Error("failed to encode event for export", "calendar-id", calID, "event-id", e.ID, "error", err)
+			return
+		}
+
+		line, err := json.Marshal(backupRecord{
+			Event:             blob,
+			Visibility:        e.Visibility,
+			Tags:              e.Tags,
+			ColorID:           e.ColorID,
+			DescriptionFormat: e.DescriptionFormat,
+			Channel:           e.Channel,
+		})
+		if err != nil {
+			slog.Error("failed to encode event for export", "calendar-id", calID, "event-id", e.ID, "error", err)
+			return
+		}
+
+		_, _ = bw.Write(line)
+		if err := bw.WriteByte('\n'); err != nil {
+			return
+		}
+	}
+
+	if err := bw.Flush(); err != nil {
+		slog.Error("failed to write calendar export", "calendar-id", calID, "error", err)
+	}
+}
+
+// backupRecord is a single line of a backup in the ndjson format. The
+// CalendarEvent message does not have fields for all properties of an
+// event so they are written next to it.
+type backupRecord struct {
+	// Event is the protojson encoded CalendarEvent.
+	Event json.RawMessage `json:"event"`
+
+	Visibility        string   `json:"visibility,omitempty"`
+	Tags              []string `json:"tags,omitempty"`
+	ColorID           string   `json:"colorId,omitempty"`
+	DescriptionFormat string   `json:"descriptionFormat,omitempty"`
+	Channel           string   `json:"channel,omitempty"`
+}
+
+// parseNDJSON parses events written by the CalendarExportHandler in the
+// ndjson format.
+func parseNDJSON(r io.Reader) ([]repo.Event, error) {
+	var events []repo.Event
+
+	br := bufio.NewReader(r)
+	for lineNo := 1; ; lineNo++ {
+		line, err := br.ReadBytes('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, err
+		}
+
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			var record backupRecord
+			if err := json.Unmarshal(line, &record); err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNo, err)
+			}
+
+			var pb calendarv1.CalendarEvent
+			if err := protojson.Unmarshal(record.Event, &pb); err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNo, err)
+			}
+
+			if pb.StartTime == nil {
+				return nil, fmt.Errorf("line %d: event %q does not have a start time", lineNo, pb.Id)
+			}
+
+			evt := repo.Event{
+				ID:                pb.Id,
+				Summary:           pb.Summary,
+				Description:       pb.Description,
+				StartTime:         pb.StartTime.AsTime(),
+				FullDayEvent:      pb.FullDay,
+				Visibility:        record.Visibility,
+				Tags:              record.Tags,
+				ColorID:           record.ColorID,
+				DescriptionFormat: record.DescriptionFormat,
+				Channel:           record.Channel,
+			}
+
+			if pb.EndTime != nil {
+				end := pb.EndTime.AsTime()
+				evt.EndTime = &end
+			}
+
+			if pb.ExtraData != nil {
+				var annotation calendarv1.CustomerAnnotation
+				if pb.ExtraData.MessageIs(&annotation) {
+					if err := pb.ExtraData.UnmarshalTo(&annotation); err != nil {
+						return nil, fmt.Errorf("line %d: %w", lineNo, err)
+					}
+
+					evt.Data = &repo.StructuredEvent{
+						CustomerSource: annotation.CustomerSource,
+						CustomerID:     annotation.CustomerId,
+						AnimalID:       annotation.AnimalIds,
+						CreatedBy:      annotation.CreatedByUserId,
+					}
+				}
+			}
+
+			events = append(events, evt)
+		}
+
+		if errors.Is(err, io.EOF) {
+			return events, nil
+		}
+	}
+}
+
+// importFailure describes an event that could not be imported.
+type importFailure struct {
+	EventID string `json:"eventId"`
+	Error   string `json:"error"`
+}
+
+// importResult is the response of the CalendarImportHandler.
+type importResult struct {
+	Created int             `json:"created"`
+	Skipped int             `json:"skipped"`
+	Failed  []importFailure `json:"failed,omitempty"`
+}
+
+// CalendarImportHandler restores events from a backup written by the
+// CalendarExportHandler:
+//
+//	POST /calendars/import?calendar=<id>&format=ndjson
+//
+// The id of each imported event is stored with the created event. Events
+// whose id matches an existing event or the origin of an imported event in
+// the target calendar are skipped so an import can be repeated safely.
+// Only callers with one of the allowed roles (X-Remote-Role) may import
+// calendars.
+type CalendarImportHandler struct {
+	svc          *CalendarService
+	allowedRoles []string
+}
+
+// NewCalendarImportHandler returns a new calendar import handler for svc.
+func NewCalendarImportHandler(svc *CalendarService, allowedRoles []string) *CalendarImportHandler {
+	return &CalendarImportHandler{
+		svc:          svc,
+		allowedRoles: allowedRoles,
+	}
+}
+
+func (h *CalendarImportHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !authorize(w, r, h.allowedRoles) {
+		return
+	}
+
+	calID := r.URL.Query().Get("calendar")
+	if calID == "" {
+		http.Error(w, "missing value for calendar", http.StatusBadRequest)
+		return
+	}
+
+	// fail before reading the backup so large uploads to read-only
+	// calendars are rejected early.
+	if err := h.svc.checkWritable(calID); err != nil {
+		http.Error(w, err.Error(), httpStatus(err))
+		return
+	}
+
+	format, err := backupFormat(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	body := http.MaxBytesReader(w, r.Body, maxImportSize)
+
+	var events []repo.Event
+	if format == backupFormatICS {
+		events, err = parseICS(body)
+	} else {
+		events, err = parseNDJSON(body)
+	}
+
+	if err != nil {
+		http.Error(w, "invalid backup: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := h.importEvents(r, calID, events)
+	if err != nil {
+		http.Error(w, err.Error(), httpStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		slog.Error("failed to encode import result", "error", err)
+	}
+}
+
+// importEvents creates events in calID unless they have already been
+// imported.
+func (h *CalendarImportHandler) importEvents(r *http.Request, calID string, events []repo.Event) (importResult, error) {
+	var result importResult
+
+	if len(events) == 0 {
+		return result, nil
+	}
+
+	// load the existing events within the time range of the backup to
+	// detect duplicates.
+	from, to := events[0].StartTime, events[0].StartTime
+	for _, e := range events {
+		from = minTime(from, e.StartTime)
+		to = maxTime(to, e.StartTime)
+
+		if e.EndTime != nil {
+			to = maxTime(to, *e.EndTime)
+		}
+	}
+
+	existing, err := h.svc.repo.ListEvents(r.Context(), calID, repo.WithEventsAfter(from.Add(-time.Second)), repo.WithEventsBefore(to.Add(time.Second)))
+	if err != nil {
+		return result, err
+	}
+
+	known := make(map[string]bool, 2*len(existing))
+	for _, e := range existing {
+		known[e.ID] = true
+
+		if e.ImportedFrom != "" {
+			known[e.ImportedFrom] = true
+		}
+	}
+
+	user := r.Header.Get("X-Remote-User-ID")
+
+	for _, e := range events {
+		if e.ID != "" && known[e.ID] {
+			result.Skipped++
+			continue
+		}
+
+		var duration time.Duration
+		if e.EndTime != nil {
+			duration = e.EndTime.Sub(e.StartTime)
+		}
+
+		opts := []repo.CreateOption{
+			repo.WithImportedFrom(e.ID),
+			repo.WithSourceChannel(e.Channel),
+			repo.WithVisibility(e.Visibility),
+			repo.WithTags(e.Tags...),
+			repo.WithColor(e.ColorID),
+			repo.WithDescriptionFormat(e.DescriptionFormat),
+		}
+		if e.FullDayEvent {
+			opts = append(opts, repo.WithFullDay())
+		}
+
+		created, err := h.svc.repo.CreateEvent(r.Context(), calID, e.Summary, e.Description, e.StartTime, duration, e.Data, opts...)
+		if err != nil {
+			slog.Error("failed to import event", "calendar-id", calID, "event-id", e.ID, "user", user, "error", err)
+			result.Failed = append(result.Failed, importFailure{EventID: e.ID, Error: err.Error()})
+
+			continue
+		}
+
+		if e.ID != "" {
+			known[e.ID] = true
+		}
+
+		slog.Info("imported event", "calendar-id", calID, "event-id", created.ID, "imported-from", e.ID, "user", user)
+		result.Created++
+	}
+
+	return result, nil
+}
+
+func minTime(a, b time.Time) time.Time {
+	if b.Before(a) {
+		return b
+	}
+
+	return a
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if b.After(a) {
+		return b
+	}
+
+	return a
+}
@@ -0,0 +1,275 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	idmv1 "github.com/tierklinik-dobersberg/apis/gen/go/tkd/idm/v1"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/app"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/cache"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/repo"
+)
+
+// memoryRepo is a bookingRepo that stores created events so they can be
+// listed again.
+type memoryRepo struct {
+	*bookingRepo
+}
+
+func (m *memoryRepo) CreateEvent(_ context.Context, calID, name, description string, startTime time.Time, duration time.Duration, data *repo.StructuredEvent, opts ...repo.CreateOption) (*repo.Event, error) {
+	var co repo.CreateOptions
+	for _, fn := range opts {
+		fn(&co)
+	}
+
+	end := startTime.Add(duration)
+	evt := repo.Event{
+		ID:           fmt.Sprintf("%s-%d", calID, len(m.events[calID])),
+		CalendarID:   calID,
+		Summary:      name,
+		Description:  description,
+		StartTime:    startTime,
+		EndTime:      &end,
+		FullDayEvent: co.FullDay,
+		Data:         data,
+		Tags:         co.Tags,
+		ColorID:      co.ColorID,
+		ImportedFrom: co.ImportedFrom,
+		Channel:      co.Channel,
+		Visibility:   co.Visibility,
+
+		DescriptionFormat: co.DescriptionFormat,
+	}
+
+	m.events[calID] = append(m.events[calID], evt)
+
+	return &evt, nil
+}
+
+func (m *memoryRepo) LoadEvent(_ context.Context, calID, eventID string, _ bool) (*repo.Event, error) {
+	for _, e := range m.events[calID] {
+		if e.ID == eventID {
+			return &e, nil
+		}
+	}
+
+	return nil, connect.NewError(connect.CodeNotFound, errors.New("event not found"))
+}
+
+func (m *memoryRepo) UpdateEvent(_ context.Context, event repo.Event) (*repo.Event, error) {
+	for idx, e := range m.events[event.CalendarID] {
+		if e.ID == event.ID {
+			m.events[event.CalendarID][idx] = event
+
+			return &event, nil
+		}
+	}
+
+	return nil, connect.NewError(connect.CodeNotFound, errors.New("event not found"))
+}
+
+func (m *memoryRepo) DeleteEvent(_ context.Context, calID, eventID string) error {
+	for idx, e := range m.events[calID] {
+		if e.ID == eventID {
+			m.events[calID] = append(m.events[calID][:idx:idx], m.events[calID][idx+1:]...)
+
+			return nil
+		}
+	}
+
+	return connect.NewError(connect.CodeNotFound, errors.New("event not found"))
+}
+
+func Test_CalendarBackup_RoundTrip(t *testing.T) {
+	for _, format := range []string{backupFormatNDJSON, backupFormatICS} {
+		t.Run(format, func(t *testing.T) {
+			svc, bookings := newBookingTestService(t)
+
+			at := func(day, hour int) time.Time {
+				return time.Date(2024, time.June, day, hour, 0, 0, 0, time.UTC)
+			}
+
+			fixture := []repo.Event{
+				{
+					ID:          "checkup",
+					CalendarID:  "vet-1",
+					Summary:     "Bello; checkup, vaccination",
+					Description: "first line\nsecond line with a long text that needs to be folded when written as an iCalendar stream",
+					StartTime:   at(3, 8),
+					EndTime:     ptr(at(3, 9)),
+					Data:        &repo.StructuredEvent{CustomerSource: "vetinf", CustomerID: "huber", AnimalID: []string{"1", "2"}, CreatedBy: "alice"},
+				},
+				{
+					ID:           "vacation",
+					CalendarID:   "vet-1",
+					Summary:      "Vacation",
+					StartTime:    at(10, 0),
+					EndTime:      ptr(at(12, 0)),
+					FullDayEvent: true,
+				},
+				{
+					ID:          "private",
+					CalendarID:  "vet-1",
+					Summary:     "Dr. Who",
+					Description: "<p><strong>personal</strong></p>",
+					StartTime:   at(14, 10),
+					EndTime:     ptr(at(14, 11)),
+					Visibility:  repo.VisibilityPrivate,
+					Tags:        []string{"surgery", "follow-up"},
+					ColorID:     "11",
+					Channel:     "online",
+
+					DescriptionFormat: "markdown",
+				},
+			}
+
+			bookings.events["vet-1"] = fixture
+			svc.repo = &app.App{Service: &memoryRepo{bookingRepo: bookings}, Config: svc.repo.Config}
+
+			export := NewCalendarExportHandler(svc, []string{"admin"})
+			restore := NewCalendarImportHandler(svc, []string{"admin"})
+
+			req := httptest.NewRequest(http.MethodGet, "/calendars/export?calendar=vet-1&from=2024-06-01&to=2024-07-01&format="+format, nil)
+			req.Header.Set("X-Remote-User-ID", "alice")
+			req.Header.Set("X-Remote-Role", "admin")
+
+			rec := httptest.NewRecorder()
+			export.ServeHTTP(rec, req)
+			require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+			backup := rec.Body.String()
+
+			importBackup := func(calID string) (*httptest.ResponseRecorder, importResult) {
+				req := httptest.NewRequest(http.MethodPost, "/calendars/import?calendar="+calID+"&format="+format, strings.NewReader(backup))
+				req.Header.Set("X-Remote-User-ID", "alice")
+				req.Header.Set("X-Remote-Role", "admin")
+
+				rec := httptest.NewRecorder()
+				restore.ServeHTTP(rec, req)
+
+				var result importResult
+				if rec.Code == http.StatusOK {
+					require.NoError(t, json.NewDecoder(rec.Body).Decode(&result))
+				}
+
+				return rec, result
+			}
+
+			rec, result := importBackup("vet-3")
+			require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+			assert.Equal(t, importResult{Created: 3}, result)
+
+			imported := bookings.events["vet-3"]
+			require.Len(t, imported, 3)
+
+			for idx, e := range imported {
+				want := fixture[idx]
+
+				assert.Equal(t, want.ID, e.ImportedFrom)
+				assert.Equal(t, want.Summary, e.Summary)
+				assert.Equal(t, want.Description, e.Description)
+				assert.True(t, want.StartTime.Equal(e.StartTime))
+				assert.True(t, want.EndTime.Equal(*e.EndTime))
+				assert.Equal(t, want.FullDayEvent, e.FullDayEvent)
+				assert.Equal(t, want.Data, e.Data)
+				assert.Equal(t, want.Visibility, e.Visibility)
+				assert.Equal(t, want.Tags, e.Tags)
+				assert.Equal(t, want.ColorID, e.ColorID)
+				assert.Equal(t, want.DescriptionFormat, e.DescriptionFormat)
+				assert.Equal(t, want.Channel, e.Channel)
+			}
+
+			// repeated imports are skipped
+			rec, result = importBackup("vet-3")
+			require.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, importResult{Skipped: 3}, result)
+			assert.Len(t, bookings.events["vet-3"], 3)
+
+			// so are imports into the original calendar
+			rec, result = importBackup("vet-1")
+			require.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, importResult{Skipped: 3}, result)
+		})
+	}
+}
+
+func Test_CalendarImport_Readonly(t *testing.T) {
+	svc, _ := newBookingTestService(t)
+	svc.calendarById.Update([]repo.Calendar{{ID: "holidays", Readonly: true, AccessRole: "reader"}})
+
+	handler := NewCalendarImportHandler(svc, []string{"admin"})
+
+	req := httptest.NewRequest(http.MethodPost, "/calendars/import?calendar=holidays", strings.NewReader("not a backup"))
+	req.Header.Set("X-Remote-User-ID", "alice")
+	req.Header.Set("X-Remote-Role", "admin")
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	// the body is never read
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	req = httptest.NewRequest(http.MethodPost, "/calendars/import?calendar=vet-1", strings.NewReader("not a backup"))
+	req.Header.Set("X-Remote-User-ID", "alice")
+	req.Header.Set("X-Remote-Role", "admin")
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func Test_CalendarExport_Private(t *testing.T) {
+	svc, bookings := newBookingTestService(t)
+
+	bookings.events["vet-1"] = []repo.Event{{
+		ID:          "private",
+		CalendarID:  "vet-1",
+		Summary:     "Dr. Who",
+		Description: "personal",
+		Visibility:  repo.VisibilityPrivate,
+		StartTime:   time.Date(2024, time.June, 3, 8, 0, 0, 0, time.UTC),
+		EndTime:     ptr(time.Date(2024, time.June, 3, 9, 0, 0, 0, time.UTC)),
+		Data:        &repo.StructuredEvent{CustomerID: "huber"},
+	}}
+
+	// alice owns vet-1
+	svc.userByCalId = cache.NewIndex(func(p *idmv1.Profile) (string, bool) {
+		return "vet-1", p.GetUser().GetId() == "alice"
+	})
+	svc.userByCalId.Update([]*idmv1.Profile{{User: &idmv1.User{Id: "alice"}}})
+
+	export := NewCalendarExportHandler(svc, []string{"admin"})
+
+	backup := func(user string) string {
+		req := httptest.NewRequest(http.MethodGet, "/calendars/export?calendar=vet-1&from=2024-06-01&to=2024-07-01", nil)
+		req.Header.Set("X-Remote-User-ID", user)
+		req.Header.Set("X-Remote-Role", "admin")
+
+		rec := httptest.NewRecorder()
+		export.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+		return rec.Body.String()
+	}
+
+	owner := backup("alice")
+	assert.Contains(t, owner, "Dr. Who")
+	assert.Contains(t, owner, "huber")
+
+	// backups are not redacted so the private event can be restored
+	other := backup("bob")
+	assert.Contains(t, other, "Dr. Who")
+	assert.Contains(t, other, "personal")
+	assert.Contains(t, other, "huber")
+	assert.Contains(t, other, `"visibility":"private"`)
+}
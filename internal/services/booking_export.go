@@ -0,0 +1,695 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bufbuild/connect-go"
+	calendarv1 "github.com/tierklinik-dobersberg/apis/gen/go/tkd/calendar/v1"
+	commonv1 "github.com/tierklinik-dobersberg/apis/gen/go/tkd/common/v1"
+	"github.com/tierklinik-dobersberg/apis/pkg/data"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/config"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/cron"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/holidays"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/repo"
+	"golang.org/x/exp/maps"
+)
+
+// bookingExportVersion is the version of the booking portal document. It
+// must be increased whenever the format changes in an incompatible way.
+const bookingExportVersion = 1
+
+// bookingPushTimeout is the maximum time a push to the booking portal may
+// take.
+const bookingPushTimeout = 30 * time.Second
+
+// bookingDocument is the document of available slots ingested by the
+// online booking portal. Days are dates as YYYY-MM-DD, To is the last
+// exported day.
+type bookingDocument struct {
+	Version     int              `json:"version"`
+	GeneratedAt time.Time        `json:"generatedAt"`
+	Timezone    string           `json:"timezone"`
+	From        string           `json:"from"`
+	To          string           `json:"to"`
+	Services    []bookingService `json:"services"`
+}
+
+type bookingService struct {
+	ID              string       `json:"id"`
+	Name            string       `json:"name"`
+	DurationMinutes int          `json:"durationMinutes"`
+	Days            []bookingDay `json:"days"`
+}
+
+// bookingDay holds the slots of a service on a single day. Every exported
+// day is listed, days without slots have an empty list.
+type bookingDay struct {
+	Date  string        `json:"date"`
+	Slots []bookingSlot `json:"slots"`
+}
+
+type bookingSlot struct {
+	Start      time.Time `json:"start"`
+	End        time.Time `json:"end"`
+	CalendarID string    `json:"calendarId"`
+}
+
+// bookingFreeSlot is a free slot of a calendar within a single day.
+type bookingFreeSlot struct {
+	calendarID string
+	start, end time.Time
+}
+
+// bookingExportDay holds the free slots and the ids of all events of an
+// exported day.
+type bookingExportDay struct {
+	slots  []bookingFreeSlot
+	events []string
+}
+
+// BookingExporter exports the free slots of the configured calendars as
+// bookable slots per service to the online booking portal. Free slots are
+// calculated using ListEvents so the roster, buffers and ignored event tags
+// are respected. Slots on public holidays are never exported.
+//
+// All days are exported whenever the schedule fires. Changes to events
+// re-export the affected days once no further changes arrived for the
+// debounce period. The latest document is kept in memory and pushed to the
+// booking portal if a push URL is configured.
+type BookingExporter struct {
+	services  []config.BookingService
+	days      int
+	debounce  time.Duration
+	schedule  *cron.Schedule
+	pushURL   string
+	pushToken string
+	client    *http.Client
+	loc       *time.Location
+
+	// calendars returns the exported calendars, watched reports whether
+	// changes to a calendar may affect the exported slots.
+	calendars func() []string
+	watched   func(calID string) bool
+
+	// list returns the events and free slots of calendars between from
+	// and to.
+	list func(ctx context.Context, calendars []string, from, to time.Time) ([]*calendarv1.CalendarEvent, error)
+
+	holidays     holidays.Getter
+	country      string
+	holidayTypes []string
+
+	ctx context.Context
+	now func() time.Time
+
+	// export serializes exports.
+	export sync.Mutex
+
+	l           sync.Mutex
+	exported    map[string]bookingExportDay
+	first       string
+	generatedAt time.Time
+	pending     map[string]struct{}
+	timer       *time.Timer
+}
+
+// NewBookingExporter returns a new booking exporter for the free slots of
+// svc.
+func NewBookingExporter(ctx context.Context, svc *CalendarService) (*BookingExporter, error) {
+	cfg := svc.repo.Config
+
+	ranges, err := newRelativeRanges(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &BookingExporter{
+		services:     cfg.BookingExport.Services,
+		days:         cfg.BookingExport.Days,
+		debounce:     cfg.BookingExport.Debounce.AsDuration(),
+		pushURL:      cfg.BookingExport.PushURL,
+		pushToken:    cfg.BookingExport.PushToken,
+		client:       &http.Client{Timeout: bookingPushTimeout},
+		loc:          ranges.loc,
+		holidays:     svc.holidays,
+		country:      cfg.DefaultCountry,
+		holidayTypes: cfg.FreeSlots.HolidayTypes,
+		ctx:          repo.WithQuotaCaller(ctx, "booking-export"),
+		now:          time.Now,
+		list:         svc.listBookingEvents,
+	}
+
+	if cfg.BookingExport.Schedule != "" {
+		schedule, err := cron.Parse(cfg.BookingExport.Schedule)
+		if err != nil {
+			return nil, err
+		}
+
+		e.schedule = &schedule
+	}
+
+	e.calendars = svc.userCalendarIds
+	if configured := cfg.BookingExport.Calendars; len(configured) > 0 {
+		e.calendars = func() []string {
+			return configured
+		}
+	}
+
+	// events of overlay sources block time in their target calendars.
+	e.watched = func(calID string) bool {
+		calendars := e.calendars()
+
+		if slices.Contains(calendars, calID) {
+			return true
+		}
+
+		return slices.ContainsFunc(cfg.Overlays, func(o config.Overlay) bool {
+			return o.Source == calID && slices.Contains(calendars, o.Target)
+		})
+	}
+
+	return e, nil
+}
+
+// listBookingEvents returns the events and free slots of calendars between
+// from and to.
+func (svc *CalendarService) listBookingEvents(ctx context.Context, calendars []string, from, to time.Time) ([]*calendarv1.CalendarEvent, error) {
+	res, err := svc.ListEvents(ctx, connect.NewRequest(&calendarv1.ListEventsRequest{
+		Source: &calendarv1.ListEventsRequest_Sources{
+			Sources: &calendarv1.EventSource{
+				CalendarIds: calendars,
+			},
+		},
+		SearchTime: &calendarv1.ListEventsRequest_TimeRange{
+			TimeRange: commonv1.NewTimeRange(from, to),
+		},
+		RequestKinds: []calendarv1.CalenarEventRequestKind{
+			calendarv1.CalenarEventRequestKind_CALENDAR_EVENT_REQUEST_KIND_EVENTS,
+			calendarv1.CalenarEventRequestKind_CALENDAR_EVENT_REQUEST_KIND_FREE_SLOTS,
+		},
+	}))
+	if err != nil {
+		return nil, err
+	}
+
+	var events []*calendarv1.CalendarEvent
+	for _, result := range res.Msg.Results {
+		events = append(events, result.Events...)
+	}
+
+	return events, nil
+}
+
+// Start exports all days and then runs the export whenever the schedule
+// fires until ctx is cancelled.
+func (e *BookingExporter) Start(ctx context.Context) {
+	ctx = repo.WithQuotaCaller(ctx, "booking-export")
+
+	go func() {
+		if _, err := e.Export(ctx); err != nil {
+			slog.Error("failed to export free slots for the booking portal", "error", err)
+		}
+
+		if e.schedule == nil {
+			return
+		}
+
+		for {
+			next := e.schedule.Next(e.now())
+			if next.IsZero() {
+				return
+			}
+
+			timer := time.NewTimer(time.Until(next))
+
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+
+			if _, err := e.Export(ctx); err != nil {
+				slog.Error("failed to export free slots for the booking portal", "error", err)
+			}
+		}
+	}()
+}
+
+// Export exports all days and returns the new document.
+func (e *BookingExporter) Export(ctx context.Context) (*bookingDocument, error) {
+	return e.exportDays(ctx, nil)
+}
+
+// Document returns the latest exported document. Nothing has been exported
+// yet if ok is false.
+func (e *BookingExporter) Document() (*bookingDocument, bool) {
+	e.l.Lock()
+	defer e.l.Unlock()
+
+	if e.exported == nil {
+		return nil, false
+	}
+
+	return e.document(), true
+}
+
+// Notify schedules a re-export of the days affected by change. It
+// implements repo.ChangeListener and does not block.
+func (e *BookingExporter) Notify(change *calendarv1.CalendarChangeEvent) {
+	if !e.watched(change.Calendar) {
+		return
+	}
+
+	e.l.Lock()
+	defer e.l.Unlock()
+
+	// the days an event has been exported on are affected as well since
+	// the event might have been moved or deleted.
+	var (
+		id       string
+		affected []string
+	)
+
+	switch kind := change.Kind.(type) {
+	case *calendarv1.CalendarChangeEvent_DeletedEventId:
+		id = kind.DeletedEventId
+
+	case *calendarv1.CalendarChangeEvent_EventChange:
+		id = kind.EventChange.GetId()
+
+		if start := kind.EventChange.GetStartTime(); start != nil {
+			end := start.AsTime()
+			if kind.EventChange.GetEndTime() != nil {
+				end = kind.EventChange.GetEndTime().AsTime()
+			}
+
+			affected = e.dates(start.AsTime(), end)
+		}
+	}
+
+	for date, day := range e.exported {
+		if id != "" && slices.Contains(day.events, id) {
+			affected = append(affected, date)
+		}
+	}
+
+	if len(affected) == 0 {
+		return
+	}
+
+	if e.pending == nil {
+		e.pending = make(map[string]struct{})
+	}
+
+	for _, date := range affected {
+		e.pending[date] = struct{}{}
+	}
+
+	if e.timer != nil {
+		e.timer.Stop()
+	}
+
+	e.timer = time.AfterFunc(e.debounce, e.flush)
+}
+
+// flush re-exports all days that are pending since the last flush.
+func (e *BookingExporter) flush() {
+	e.l.Lock()
+	dates := maps.Keys(e.pending)
+	e.pending = nil
+	e.timer = nil
+	e.l.Unlock()
+
+	if len(dates) == 0 {
+		return
+	}
+
+	if _, err := e.exportDays(e.ctx, dates); err != nil {
+		slog.Error("failed to re-export changed days for the booking portal", "days", len(dates), "error", err)
+	}
+}
+
+// exportDays exports the given dates, or all days if dates is empty, and
+// pushes the new document to the booking portal. All days are exported if
+// the first exported day is no longer today. Dates outside of the exported
+// days are ignored.
+func (e *BookingExporter) exportDays(ctx context.Context, dates []string) (*bookingDocument, error) {
+	e.export.Lock()
+	defer e.export.Unlock()
+
+	calendars := e.calendars()
+	if len(calendars) == 0 {
+		return nil, fmt.Errorf("no calendars to export")
+	}
+
+	today := e.day(e.now().In(e.loc), 0)
+	first := today.Format("2006-01-02")
+
+	e.l.Lock()
+	full := e.exported == nil || e.first != first || len(dates) == 0
+	e.l.Unlock()
+
+	days := make([]time.Time, 0, e.days)
+	for idx := 0; idx < e.days; idx++ {
+		day := e.day(today, idx)
+
+		if full || slices.Contains(dates, day.Format("2006-01-02")) {
+			days = append(days, day)
+		}
+	}
+
+	if len(days) == 0 {
+		e.l.Lock()
+		defer e.l.Unlock()
+
+		return e.document(), nil
+	}
+
+	loaded, err := e.load(ctx, calendars, days)
+	if err != nil {
+		return nil, err
+	}
+
+	e.l.Lock()
+	if full {
+		e.exported = make(map[string]bookingExportDay, len(loaded))
+		e.first = first
+	}
+
+	for date, day := range loaded {
+		e.exported[date] = day
+	}
+	e.generatedAt = e.now()
+
+	doc := e.document()
+	e.l.Unlock()
+
+	slog.Info("exported free slots for the booking portal", "days", len(days), "calendars", len(calendars), "full", full)
+
+	if e.pushURL != "" {
+		if err := e.push(ctx, doc); err != nil {
+			return doc, fmt.Errorf("failed to push free slots to the booking portal: %w", err)
+		}
+	}
+
+	return doc, nil
+}
+
+// load loads the free slots and events of calendars on days. Consecutive
+// days are loaded using a single request. Public holidays do not have any
+// free slots.
+func (e *BookingExporter) load(ctx context.Context, calendars []string, days []time.Time) (map[string]bookingExportDay, error) {
+	result := make(map[string]bookingExportDay, len(days))
+
+	closed := make(map[string]bool)
+	for _, check := range holidays.Check(ctx, e.holidays, e.country, days) {
+		if check.Err != nil {
+			slog.Error("failed to check for public holiday, exporting free slots", "error", check.Err, "date", check.Date.Format("2006-01-02"))
+		}
+
+		if check.IsHoliday() && data.ElemInBothSlices(check.Holiday.Types, e.holidayTypes) {
+			closed[check.Date.Format("2006-01-02")] = true
+		}
+	}
+
+	for idx := 0; idx < len(days); {
+		from := days[idx]
+
+		end := idx + 1
+		for end < len(days) && days[end].Equal(e.day(days[end-1], 1)) {
+			end++
+		}
+
+		to := e.day(days[end-1], 1)
+
+		events, err := e.list(ctx, calendars, from, to)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, day := range days[idx:end] {
+			result[day.Format("2006-01-02")] = bookingExportDay{}
+		}
+
+		for _, evt := range events {
+			start, end := evt.GetStartTime().AsTime(), evt.GetStartTime().AsTime()
+			if evt.GetEndTime() != nil {
+				end = evt.GetEndTime().AsTime()
+			}
+
+			for _, date := range e.dates(start, end) {
+				day, ok := result[date]
+				if !ok {
+					continue
+				}
+
+				if !strings.HasPrefix(evt.Id, freeSlotIDPrefix) {
+					day.events = append(day.events, evt.Id)
+				} else if !closed[date] {
+					dayStart, _ := time.ParseInLocation("2006-01-02", date, e.loc)
+					dayEnd := e.day(dayStart, 1)
+
+					// slots are only offered within a single day
+					slot := bookingFreeSlot{
+						calendarID: evt.CalendarId,
+						start:      start.In(e.loc),
+						end:        end.In(e.loc),
+					}
+					if slot.start.Before(dayStart) {
+						slot.start = dayStart
+					}
+					if slot.end.After(dayEnd) {
+						slot.end = dayEnd
+					}
+
+					day.slots = append(day.slots, slot)
+				}
+
+				result[date] = day
+			}
+		}
+
+		idx = end
+	}
+
+	return result, nil
+}
+
+// document builds the booking portal document from the exported days. The
+// caller must hold e.l.
+func (e *BookingExporter) document() *bookingDocument {
+	first, _ := time.ParseInLocation("2006-01-02", e.first, e.loc)
+
+	doc := &bookingDocument{
+		Version:     bookingExportVersion,
+		GeneratedAt: e.generatedAt.In(e.loc),
+		Timezone:    e.loc.String(),
+		From:        e.first,
+		To:          e.day(first, e.days-1).Format("2006-01-02"),
+		Services:    make([]bookingService, 0, len(e.services)),
+	}
+
+	for _, s := range e.services {
+		service := bookingService{
+			ID:              s.ID,
+			Name:            s.Name,
+			DurationMinutes: int(s.Duration.AsDuration() / time.Minute),
+			Days:            make([]bookingDay, 0, e.days),
+		}
+
+		for idx := 0; idx < e.days; idx++ {
+			day := e.day(first, idx)
+			date := day.Format("2006-01-02")
+
+			slots := []bookingSlot{}
+			for _, free := range e.exported[date].slots {
+				if len(s.Calendars) > 0 && !slices.Contains(s.Calendars, free.calendarID) {
+					continue
+				}
+
+				for _, start := range bookingStarts(day, free.start, free.end, s.Duration.AsDuration(), s.Step.AsDuration()) {
+					slots = append(slots, bookingSlot{
+						Start:      start,
+						End:        start.Add(s.Duration.AsDuration()),
+						CalendarID: free.calendarID,
+					})
+				}
+			}
+
+			sort.SliceStable(slots, func(i, j int) bool {
+				if !slots[i].Start.Equal(slots[j].Start) {
+					return slots[i].Start.Before(slots[j].Start)
+				}
+
+				return slots[i].CalendarID < slots[j].CalendarID
+			})
+
+			service.Days = append(service.Days, bookingDay{
+				Date:  date,
+				Slots: slots,
+			})
+		}
+
+		doc.Services = append(doc.Services, service)
+	}
+
+	return doc
+}
+
+// bookingStarts returns the start times of all appointments of duration
+// that fit between start and end. Appointments start every step on the wall
+// clock, beginning at midnight of day.
+func bookingStarts(day, start, end time.Time, duration, step time.Duration) []time.Time {
+	if step <= 0 {
+		return nil
+	}
+
+	at := func(n int) time.Time {
+		return time.Date(day.Year(), day.Month(), day.Day(), 0, 0, int(time.Duration(n)*step/time.Second), 0, day.Location())
+	}
+
+	// skip all steps before the first one that could fit
+	offset := time.Duration(start.Hour())*time.Hour + time.Duration(start.Minute())*time.Minute + time.Duration(start.Second())*time.Second
+	n := int(offset / step)
+	if n > 0 {
+		n--
+	}
+
+	var result []time.Time
+	for t := at(n); !t.Add(duration).After(end); t = at(n) {
+		if !t.Before(start) {
+			result = append(result, t)
+		}
+
+		n++
+	}
+
+	return result
+}
+
+// day returns midnight of the day that is days after t.
+func (e *BookingExporter) day(t time.Time, days int) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day()+days, 0, 0, 0, 0, e.loc)
+}
+
+// dates returns all dates between start and end, end is exclusive.
+func (e *BookingExporter) dates(start, end time.Time) []string {
+	start, end = start.In(e.loc), end.In(e.loc)
+
+	result := []string{start.Format("2006-01-02")}
+	for day := e.day(start, 1); day.Before(end); day = e.day(day, 1) {
+		result = append(result, day.Format("2006-01-02"))
+	}
+
+	return result
+}
+
+// push posts doc to the booking portal.
+func (e *BookingExporter) push(ctx context.Context, doc *bookingDocument) error {
+	body, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.pushURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	if e.pushToken != "" {
+		req.Header.Set("Authorization", "Bearer "+e.pushToken)
+	}
+
+	res, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	// drain the body so the connection can be reused.
+	_, _ = io.Copy(io.Discard, io.LimitReader(res.Body, 64<<10))
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("unexpected response status %s", res.Status)
+	}
+
+	return nil
+}
+
+// BookingExportHandler serves the free slots exported for the online
+// booking portal:
+//
+//	GET  /booking/slots   returns the latest exported document
+//	POST /booking/slots   exports and pushes all days now
+//
+// Only callers with one of the allowed roles (X-Remote-Role) may use the
+// endpoint.
+type BookingExportHandler struct {
+	exporter     *BookingExporter
+	allowedRoles []string
+}
+
+// NewBookingExportHandler returns a new handler for exporter.
+func NewBookingExportHandler(exporter *BookingExporter, allowedRoles []string) *BookingExportHandler {
+	return &BookingExportHandler{
+		exporter:     exporter,
+		allowedRoles: allowedRoles,
+	}
+}
+
+func (h *BookingExportHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !authorize(w, r, h.allowedRoles) {
+		return
+	}
+
+	var (
+		doc *bookingDocument
+		err error
+	)
+
+	switch r.Method {
+	case http.MethodGet:
+		var ok bool
+		if doc, ok = h.exporter.Document(); !ok {
+			doc, err = h.exporter.Export(r.Context())
+		}
+
+	case http.MethodPost:
+		slog.Info("booking portal export requested", "user", r.Header.Get("X-Remote-User-ID"))
+
+		doc, err = h.exporter.Export(r.Context())
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// the document is still served if only the push failed
+	if err != nil {
+		slog.Error("failed to export free slots for the booking portal", "error", err)
+
+		if doc == nil {
+			http.Error(w, "failed to export free slots", http.StatusServiceUnavailable)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(doc); err != nil {
+		slog.Error("failed to encode booking portal document", "error", err)
+	}
+}
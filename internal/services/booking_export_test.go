@@ -0,0 +1,358 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	calendarv1 "github.com/tierklinik-dobersberg/apis/gen/go/tkd/calendar/v1"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/config"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/holidays"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+var updateGolden = flag.Bool("update", false, "update the golden files in testdata")
+
+// fakeBookingEvents returns events that overlap the requested range and
+// records the requested ranges.
+type fakeBookingEvents struct {
+	l      sync.Mutex
+	events []*calendarv1.CalendarEvent
+	calls  [][2]string
+}
+
+func (f *fakeBookingEvents) set(events ...*calendarv1.CalendarEvent) {
+	f.l.Lock()
+	defer f.l.Unlock()
+
+	f.events = events
+}
+
+func (f *fakeBookingEvents) requests() [][2]string {
+	f.l.Lock()
+	defer f.l.Unlock()
+
+	return append([][2]string(nil), f.calls...)
+}
+
+func (f *fakeBookingEvents) list(_ context.Context, _ []string, from, to time.Time) ([]*calendarv1.CalendarEvent, error) {
+	f.l.Lock()
+	defer f.l.Unlock()
+
+	f.calls = append(f.calls, [2]string{from.Format("01-02"), to.Format("01-02")})
+
+	var result []*calendarv1.CalendarEvent
+	for _, e := range f.events {
+		if e.StartTime.AsTime().Before(to) && e.EndTime.AsTime().After(from) {
+			result = append(result, e)
+		}
+	}
+
+	return result, nil
+}
+
+func bookingEvent(t *testing.T, id, calID, start, end string) *calendarv1.CalendarEvent {
+	t.Helper()
+
+	loc, err := time.LoadLocation("Europe/Vienna")
+	require.NoError(t, err)
+
+	parse := func(value string) *timestamppb.Timestamp {
+		ts, err := time.ParseInLocation("2006-01-02 15:04", value, loc)
+		require.NoError(t, err)
+
+		return timestamppb.New(ts)
+	}
+
+	return &calendarv1.CalendarEvent{
+		Id:         id,
+		CalendarId: calID,
+		StartTime:  parse(start),
+		EndTime:    parse(end),
+	}
+}
+
+// newBookingExportTest returns an exporter for vet-1 and vet-2 that exports
+// three days starting on 2024-05-29. 2024-05-30 is a public holiday.
+func newBookingExportTest(t *testing.T) (*BookingExporter, *fakeBookingEvents) {
+	t.Helper()
+
+	loc, err := time.LoadLocation("Europe/Vienna")
+	require.NoError(t, err)
+
+	getter, err := holidays.NewFakeFromFile("testdata/holidays_AT_2024.json")
+	require.NoError(t, err)
+
+	fake := &fakeBookingEvents{}
+	fake.set(
+		bookingEvent(t, freeSlotIDPrefix+"1", "vet-1", "2024-05-29 08:00", "2024-05-29 09:10"),
+		bookingEvent(t, freeSlotIDPrefix+"2", "vet-2", "2024-05-29 09:20", "2024-05-29 10:00"),
+		bookingEvent(t, freeSlotIDPrefix+"3", "vet-1", "2024-05-30 08:00", "2024-05-30 12:00"),
+		bookingEvent(t, freeSlotIDPrefix+"4", "vet-2", "2024-05-31 22:30", "2024-06-01 02:00"),
+		bookingEvent(t, "evt-1", "vet-1", "2024-05-29 09:10", "2024-05-29 10:00"),
+	)
+
+	calendars := []string{"vet-1", "vet-2"}
+
+	e := &BookingExporter{
+		services: []config.BookingService{
+			{ID: "checkup", Name: "Checkup", Duration: config.Duration(30 * time.Minute), Step: config.Duration(15 * time.Minute)},
+			{ID: "surgery", Name: "Surgery", Duration: config.Duration(time.Hour), Step: config.Duration(time.Hour), Calendars: []string{"vet-1"}},
+		},
+		days:     3,
+		debounce: 10 * time.Millisecond,
+		client:   http.DefaultClient,
+		loc:      loc,
+		calendars: func() []string {
+			return calendars
+		},
+		watched: func(calID string) bool {
+			return calID == "vet-1" || calID == "vet-2"
+		},
+		list:         fake.list,
+		holidays:     getter,
+		country:      "AT",
+		holidayTypes: []string{"Public", "Bank"},
+		ctx:          context.Background(),
+		now: func() time.Time {
+			return time.Date(2024, time.May, 29, 7, 0, 0, 0, loc)
+		},
+	}
+
+	return e, fake
+}
+
+// waitForExport waits for a running export of e to complete.
+func waitForExport(e *BookingExporter) {
+	e.export.Lock()
+	defer e.export.Unlock()
+}
+
+func Test_BookingExport_Golden(t *testing.T) {
+	e, _ := newBookingExportTest(t)
+
+	doc, err := e.Export(context.Background())
+	require.NoError(t, err)
+
+	content, err := json.MarshalIndent(doc, "", "  ")
+	require.NoError(t, err)
+	content = append(content, '\n')
+
+	const golden = "testdata/booking_export.json"
+
+	if *updateGolden {
+		require.NoError(t, os.WriteFile(golden, content, 0o644))
+	}
+
+	expected, err := os.ReadFile(golden)
+	require.NoError(t, err)
+	assert.Equal(t, string(expected), string(content))
+}
+
+func Test_BookingStarts(t *testing.T) {
+	day := time.Date(2024, time.May, 29, 0, 0, 0, 0, time.UTC)
+	at := func(hour, minute int) time.Time {
+		return time.Date(2024, time.May, 29, hour, minute, 0, 0, time.UTC)
+	}
+
+	cases := []struct {
+		start, end     time.Time
+		duration, step time.Duration
+		expected       []time.Time
+	}{
+		// starts are aligned to the step
+		{at(8, 10), at(9, 0), 30 * time.Minute, 15 * time.Minute, []time.Time{at(8, 15), at(8, 30)}},
+		{at(8, 0), at(9, 0), time.Hour, 15 * time.Minute, []time.Time{at(8, 0)}},
+		// the slot is too short
+		{at(8, 10), at(8, 40), 30 * time.Minute, 15 * time.Minute, nil},
+		// steps longer than the duration leave gaps
+		{at(8, 0), at(11, 0), 30 * time.Minute, time.Hour, []time.Time{at(8, 0), at(9, 0), at(10, 0)}},
+	}
+
+	for _, c := range cases {
+		assert.Equal(t, c.expected, bookingStarts(day, c.start, c.end, c.duration, c.step), "%s-%s", c.start.Format("15:04"), c.end.Format("15:04"))
+	}
+}
+
+func Test_BookingExport_Notify(t *testing.T) {
+	e, fake := newBookingExportTest(t)
+
+	_, err := e.Export(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, [][2]string{{"05-29", "06-01"}}, fake.requests())
+
+	// changes to other calendars and to unknown events are ignored
+	e.Notify(&calendarv1.CalendarChangeEvent{Calendar: "other", Kind: &calendarv1.CalendarChangeEvent_EventChange{
+		EventChange: bookingEvent(t, "x", "other", "2024-05-29 08:00", "2024-05-29 09:00"),
+	}})
+	e.Notify(&calendarv1.CalendarChangeEvent{Calendar: "vet-1", Kind: &calendarv1.CalendarChangeEvent_DeletedEventId{
+		DeletedEventId: "unknown",
+	}})
+
+	// evt-1 is moved to the last day, the free slot on the first day grows
+	// and a new one is available on the last day.
+	moved := bookingEvent(t, "evt-1", "vet-1", "2024-05-31 08:00", "2024-05-31 09:00")
+	fake.set(
+		bookingEvent(t, freeSlotIDPrefix+"1", "vet-1", "2024-05-29 08:00", "2024-05-29 10:00"),
+		bookingEvent(t, freeSlotIDPrefix+"5", "vet-1", "2024-05-31 09:00", "2024-05-31 10:00"),
+		moved,
+	)
+
+	// changes within the debounce period are exported together
+	for i := 0; i < 3; i++ {
+		e.Notify(&calendarv1.CalendarChangeEvent{Calendar: "vet-1", Kind: &calendarv1.CalendarChangeEvent_EventChange{
+			EventChange: moved,
+		}})
+	}
+
+	require.Eventually(t, func() bool {
+		return len(fake.requests()) > 1
+	}, time.Second, 5*time.Millisecond)
+
+	waitForExport(e)
+
+	// only the affected days are loaded again
+	assert.ElementsMatch(t, [][2]string{{"05-29", "06-01"}, {"05-29", "05-30"}, {"05-31", "06-01"}}, fake.requests())
+
+	doc, ok := e.Document()
+	require.True(t, ok)
+
+	surgery := doc.Services[1]
+	require.Len(t, surgery.Days[0].Slots, 2)
+	assert.Equal(t, "09:00", surgery.Days[0].Slots[1].Start.Format("15:04"))
+	require.Len(t, surgery.Days[2].Slots, 1)
+	assert.Equal(t, "09:00", surgery.Days[2].Slots[0].Start.Format("15:04"))
+
+	// the slots of vet-2 on the last day are gone since the day has been
+	// loaded again.
+	for _, slot := range doc.Services[0].Days[2].Slots {
+		assert.Equal(t, "vet-1", slot.CalendarID)
+	}
+
+	// deleting the event re-exports the day it has been exported on
+	e.Notify(&calendarv1.CalendarChangeEvent{Calendar: "vet-1", Kind: &calendarv1.CalendarChangeEvent_DeletedEventId{
+		DeletedEventId: "evt-1",
+	}})
+
+	require.Eventually(t, func() bool {
+		return len(fake.requests()) == 4
+	}, time.Second, 5*time.Millisecond)
+	assert.Equal(t, [2]string{"05-31", "06-01"}, fake.requests()[3])
+
+	// all days are exported once the first day has passed
+	e.export.Lock()
+	e.now = func() time.Time {
+		return time.Date(2024, time.May, 30, 7, 0, 0, 0, e.loc)
+	}
+	e.export.Unlock()
+
+	e.Notify(&calendarv1.CalendarChangeEvent{Calendar: "vet-1", Kind: &calendarv1.CalendarChangeEvent_EventChange{
+		EventChange: moved,
+	}})
+
+	require.Eventually(t, func() bool {
+		return len(fake.requests()) == 5
+	}, time.Second, 5*time.Millisecond)
+	assert.Equal(t, [2]string{"05-30", "06-02"}, fake.requests()[4])
+
+	waitForExport(e)
+
+	doc, _ = e.Document()
+	assert.Equal(t, "2024-05-30", doc.From)
+	assert.Equal(t, "2024-06-01", doc.To)
+}
+
+func Test_BookingExport_Push(t *testing.T) {
+	var (
+		l             sync.Mutex
+		authorization string
+		received      bookingDocument
+		status        = http.StatusNoContent
+	)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		l.Lock()
+		defer l.Unlock()
+
+		authorization = r.Header.Get("Authorization")
+
+		body, _ := io.ReadAll(r.Body)
+		_ = json.Unmarshal(body, &received)
+
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	e, _ := newBookingExportTest(t)
+	e.pushURL = srv.URL
+	e.pushToken = "secret"
+
+	doc, err := e.Export(context.Background())
+	require.NoError(t, err)
+
+	l.Lock()
+	assert.Equal(t, "Bearer secret", authorization)
+	assert.Equal(t, doc.From, received.From)
+	assert.Len(t, received.Services, 2)
+	status = http.StatusBadGateway
+	l.Unlock()
+
+	// the document is still returned if the push fails
+	doc, err = e.Export(context.Background())
+	assert.ErrorContains(t, err, "502")
+	assert.NotNil(t, doc)
+}
+
+func Test_BookingExportHandler(t *testing.T) {
+	e, fake := newBookingExportTest(t)
+	h := NewBookingExportHandler(e, []string{"portal"})
+
+	request := func(method string, roles ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/booking/slots", nil)
+		req.Header.Set("X-Remote-User-ID", "alice")
+		for _, role := range roles {
+			req.Header.Add("X-Remote-Role", role)
+		}
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		return rec
+	}
+
+	assert.Equal(t, http.StatusForbidden, request(http.MethodGet).Code)
+	assert.Equal(t, http.StatusForbidden, request(http.MethodGet, "reception").Code)
+	assert.Equal(t, http.StatusMethodNotAllowed, request(http.MethodDelete, "portal").Code)
+	assert.Empty(t, fake.requests())
+
+	// the first request exports all days
+	rec := request(http.MethodGet, "portal")
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var doc bookingDocument
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&doc))
+	assert.Equal(t, "2024-05-29", doc.From)
+	assert.Len(t, fake.requests(), 1)
+
+	// later requests are served from memory
+	assert.Equal(t, http.StatusOK, request(http.MethodGet, "portal").Code)
+	assert.Len(t, fake.requests(), 1)
+
+	assert.Equal(t, http.StatusOK, request(http.MethodPost, "portal").Code)
+	assert.Len(t, fake.requests(), 2)
+
+	// nothing can be exported without calendars
+	e.calendars = func() []string {
+		return nil
+	}
+	assert.Equal(t, http.StatusServiceUnavailable, request(http.MethodPost, "portal").Code)
+}
@@ -0,0 +1,157 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/config"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/i18n"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/repo"
+)
+
+// buffers looks up the cleanup time after events.
+type buffers []config.Buffer
+
+// after returns the buffer after evt. If multiple buffers apply the longest
+// one is returned.
+func (b buffers) after(evt repo.Event) time.Duration {
+	if evt.FullDayEvent || evt.IsFree || evt.IsWorkingLocation() {
+		return 0
+	}
+
+	var result time.Duration
+	for _, buf := range b {
+		if buf.Calendar != "" && buf.Calendar != evt.CalendarID {
+			continue
+		}
+
+		// event tags are normalized when the event is loaded
+		if buf.Tag != "" && !evt.HasTag(strings.ToLower(strings.TrimSpace(buf.Tag))) {
+			continue
+		}
+
+		result = max(result, buf.Duration.AsDuration())
+	}
+
+	return result
+}
+
+// longest returns the longest configured buffer.
+func (b buffers) longest() time.Duration {
+	var result time.Duration
+	for _, buf := range b {
+		result = max(result, buf.Duration.AsDuration())
+	}
+
+	return result
+}
+
+// bufferLister is an eventLister that extends the end of each event by its
+// buffer. Events that end up to the longest buffer before the requested
+// range are included as their buffer might still overlap the range. It is
+// used to calculate free slots, where buffers are cut at the end of the
+// working window.
+type bufferLister struct {
+	eventLister
+
+	buffers buffers
+}
+
+func (l bufferLister) ListEvents(ctx context.Context, calendarID string, filter ...repo.SearchOption) ([]repo.Event, error) {
+	opts := new(repo.EventSearchOptions)
+	for _, fn := range filter {
+		fn(opts)
+	}
+
+	if opts.FromTime != nil {
+		filter = append(filter, repo.WithEventsAfter(opts.FromTime.Add(-l.buffers.longest())))
+	}
+
+	events, err := l.eventLister.ListEvents(ctx, calendarID, filter...)
+	if err != nil {
+		return nil, err
+	}
+
+	// the events might be shared with a cache so they are copied before
+	// they are changed
+	result := make([]repo.Event, len(events))
+	for idx, e := range events {
+		if d := l.buffers.after(e); d > 0 && e.EndTime != nil {
+			end := e.EndTime.Add(d)
+			e.EndTime = &end
+		}
+
+		result[idx] = e
+	}
+
+	return result, nil
+}
+
+// bufferConflicts returns all events in the calendar of evt whose buffer
+// evt starts in.
+func (svc *CalendarService) bufferConflicts(ctx context.Context, evt repo.Event) []repo.Event {
+	b := buffers(svc.repo.Config.FreeSlots.Buffers)
+
+	longest := b.longest()
+	if longest == 0 {
+		return nil
+	}
+
+	events, err := svc.repo.ListEvents(ctx, evt.CalendarID,
+		repo.WithEventsAfter(evt.StartTime.Add(-longest)),
+		repo.WithEventsBefore(evt.StartTime.Add(time.Second)),
+	)
+	if err != nil {
+		slog.Error("failed to check calendar for buffer conflicts", "calendar-id", evt.CalendarID, "error", err)
+
+		return nil
+	}
+
+	var conflicts []repo.Event
+	for _, e := range events {
+		if e.ID == evt.ID || e.EndTime == nil {
+			continue
+		}
+
+		d := b.after(e)
+		if d == 0 {
+			continue
+		}
+
+		// evt starts after e ended but before its buffer is over
+		if !evt.StartTime.Before(*e.EndTime) && evt.StartTime.Before(e.EndTime.Add(d)) {
+			conflicts = append(conflicts, e)
+		}
+	}
+
+	return conflicts
+}
+
+// checkBufferConflicts checks whether evt starts within the buffer of a
+// previous event. If buffer violations are rejected an error is returned,
+// otherwise a warning that should be sent to the caller.
+func (svc *CalendarService) checkBufferConflicts(ctx context.Context, evt repo.Event) (string, error) {
+	conflicts := svc.bufferConflicts(ctx, evt)
+	if len(conflicts) == 0 {
+		return "", nil
+	}
+
+	refs := make([]string, len(conflicts))
+	for idx, c := range conflicts {
+		refs[idx] = c.CalendarID + "/" + c.ID
+	}
+
+	msg := i18n.FromContext(ctx).Sprintf(i18n.BufferViolation, strings.Join(refs, ", "))
+
+	if !svc.repo.Config.Validation.RejectBufferViolation {
+		slog.Warn("buffer violation", "calendar-id", evt.CalendarID, "conflicts", refs)
+
+		return msg, nil
+	}
+
+	return "", connect.NewError(connect.CodeFailedPrecondition, fmt.Errorf("%s", msg))
+}
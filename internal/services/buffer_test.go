@@ -0,0 +1,136 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	rosterv1 "github.com/tierklinik-dobersberg/apis/gen/go/tkd/roster/v1"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/config"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/repo"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func Test_FreeSlotsForWindows_Buffers(t *testing.T) {
+	lister := &fakeLister{
+		events: []repo.Event{
+			// ends before the working window but its buffer does not
+			{StartTime: makeTime("06:00"), EndTime: ptr(makeTime("07:55")), Tags: []string{"surgery"}},
+			{StartTime: makeTime("09:00"), EndTime: ptr(makeTime("10:00")), Tags: []string{"surgery"}},
+			{StartTime: makeTime("10:30"), EndTime: ptr(makeTime("11:00"))},
+			// the buffer must not extend beyond the end of the shift
+			{StartTime: makeTime("11:30"), EndTime: ptr(makeTime("11:55")), Tags: []string{"surgery"}},
+		},
+	}
+
+	windows := mergeShifts([]*rosterv1.PlannedShift{{
+		UniqueId: "1",
+		From:     timestamppb.New(makeTime("08:00")),
+		To:       timestamppb.New(makeTime("12:00")),
+	}})
+
+	l := bufferLister{
+		eventLister: lister,
+		buffers: buffers{
+			{Tag: "Surgery", Duration: config.Duration(10 * time.Minute)},
+		},
+	}
+
+	slots, failed := freeSlotsForWindows(context.Background(), l, "cal", windows, 0, func(shift *rosterv1.PlannedShift) repo.FreeSlotInfo {
+		return repo.FreeSlotInfo{ShiftID: shift.UniqueId}
+	})
+	assert.Empty(t, failed)
+
+	got := make([]timeRange, 0, len(slots))
+	for _, s := range slots {
+		got = append(got, timeRange{s.StartTime.UTC(), s.EndTime.UTC()})
+	}
+
+	assert.Equal(t, []timeRange{
+		{makeTime("08:05"), makeTime("09:00")},
+		{makeTime("10:10"), makeTime("10:30")},
+		{makeTime("11:00"), makeTime("11:30")},
+	}, got)
+
+	// the events of the underlying lister must not be changed
+	assert.Equal(t, makeTime("10:00"), *lister.events[1].EndTime)
+}
+
+func Test_Buffers_After(t *testing.T) {
+	b := buffers{
+		{Tag: "surgery", Duration: config.Duration(10 * time.Minute)},
+		{Calendar: "vet-1", Duration: config.Duration(5 * time.Minute)},
+		{Tag: "surgery", Calendar: "vet-2", Duration: config.Duration(20 * time.Minute)},
+	}
+
+	cases := []struct {
+		name     string
+		evt      repo.Event
+		expected time.Duration
+	}{
+		{"unbuffered", repo.Event{CalendarID: "vet-3"}, 0},
+		{"tag", repo.Event{CalendarID: "vet-3", Tags: []string{"surgery"}}, 10 * time.Minute},
+		{"calendar", repo.Event{CalendarID: "vet-1"}, 5 * time.Minute},
+		{"longest", repo.Event{CalendarID: "vet-2", Tags: []string{"surgery"}}, 20 * time.Minute},
+		{"free", repo.Event{CalendarID: "vet-1", IsFree: true}, 0},
+	}
+
+	for _, c := range cases {
+		assert.Equal(t, c.expected, b.after(c.evt), c.name)
+	}
+
+	assert.Equal(t, 20*time.Minute, b.longest())
+}
+
+func Test_CreateEvent_BufferViolation(t *testing.T) {
+	cases := []struct {
+		name             string
+		start, end       string
+		tags             []string
+		expectedConflict bool
+	}{
+		{"within buffer", "12:05", "12:30", []string{"surgery"}, true},
+		{"after buffer", "12:10", "12:30", []string{"surgery"}, false},
+		{"unbuffered event", "13:05", "13:30", nil, false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			svc, fake := newBookingTestService(t)
+			svc.repo.Config.FreeSlots.Buffers = []config.Buffer{
+				{Tag: "surgery", Duration: config.Duration(10 * time.Minute)},
+			}
+
+			at := func(ts string) time.Time {
+				return time.Date(2024, time.June, 3, makeTime(ts).Hour(), makeTime(ts).Minute(), 0, 0, time.UTC)
+			}
+
+			fake.events["vet-1"] = []repo.Event{
+				{ID: "surgery", CalendarID: "vet-1", StartTime: at("11:00"), EndTime: ptr(at("12:00")), Tags: c.tags},
+				{ID: "checkup", CalendarID: "vet-1", StartTime: at("12:30"), EndTime: ptr(at("13:00"))},
+			}
+
+			res, err := svc.CreateEvent(context.Background(), createEventRequest(t, c.start, c.end, "maier"))
+			require.NoError(t, err)
+			require.Len(t, fake.created, 1)
+			assert.Equal(t, c.expectedConflict, res.Header().Get("Warning") != "")
+
+			svc.repo.Config.Validation.RejectBufferViolation = true
+
+			_, err = svc.CreateEvent(context.Background(), createEventRequest(t, c.start, c.end, "maier"))
+			if !c.expectedConflict {
+				require.NoError(t, err)
+				require.Len(t, fake.created, 2)
+
+				return
+			}
+
+			require.Error(t, err)
+			assert.Len(t, fake.created, 1)
+			assert.Equal(t, connect.CodeFailedPrecondition, connect.CodeOf(err))
+		})
+	}
+}
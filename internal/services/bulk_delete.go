@@ -0,0 +1,293 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/tierklinik-dobersberg/cis-cal/internal/repo"
+)
+
+const (
+	// maxBulkDeleteRange is the maximum time range of a single bulk
+	// deletion.
+	maxBulkDeleteRange = 366 * 24 * time.Hour
+
+	// bulkDeleteConcurrency is the number of events deleted concurrently.
+	bulkDeleteConcurrency = 4
+)
+
+// bulkDeleteRequest is the request body of the BulkDeleteHandler.
+type bulkDeleteRequest struct {
+	CalendarID   string    `json:"calendarId"`
+	From         time.Time `json:"from"`
+	To           time.Time `json:"to"`
+	SummaryRegex string    `json:"summaryRegex,omitempty"`
+	Tag          string    `json:"tag,omitempty"`
+	CreatedBy    string    `json:"createdBy,omitempty"`
+	ConfirmToken string    `json:"confirmToken,omitempty"`
+}
+
+// bulkDeleteEvent is an event matched by a bulk deletion.
+type bulkDeleteEvent struct {
+	ID        string     `json:"id"`
+	Summary   string     `json:"summary"`
+	Start     time.Time  `json:"start"`
+	End       *time.Time `json:"end,omitempty"`
+	Tags      []string   `json:"tags,omitempty"`
+	CreatedBy string     `json:"createdBy,omitempty"`
+}
+
+// bulkDeleteResult is the result of deleting a single event.
+type bulkDeleteResult struct {
+	EventID string `json:"eventId"`
+	Deleted bool   `json:"deleted"`
+	Error   string `json:"error,omitempty"`
+}
+
+// bulkDeleteResponse is the response of the BulkDeleteHandler. Results are
+// only set once the deletion has been confirmed.
+type bulkDeleteResponse struct {
+	ConfirmToken string             `json:"confirmToken,omitempty"`
+	Events       []bulkDeleteEvent  `json:"events"`
+	Results      []bulkDeleteResult `json:"results,omitempty"`
+}
+
+// bulkDeleteFilter matches the events of a bulk deletion. All configured
+// criteria must match.
+type bulkDeleteFilter struct {
+	summary   *regexp.Regexp
+	tag       string
+	createdBy string
+}
+
+// newBulkDeleteFilter returns the filter of req. At least one criteria is
+// required so a calendar cannot be wiped by accident.
+func newBulkDeleteFilter(req bulkDeleteRequest) (bulkDeleteFilter, error) {
+	if req.SummaryRegex == "" && req.Tag == "" && req.CreatedBy == "" {
+		return bulkDeleteFilter{}, errors.New("at least one of summaryRegex, tag or createdBy is required")
+	}
+
+	f := bulkDeleteFilter{
+		tag:       req.Tag,
+		createdBy: req.CreatedBy,
+	}
+
+	if req.SummaryRegex != "" {
+		re, err := regexp.Compile(req.SummaryRegex)
+		if err != nil {
+			return bulkDeleteFilter{}, fmt.Errorf("invalid value for summaryRegex: %w", err)
+		}
+
+		f.summary = re
+	}
+
+	return f, nil
+}
+
+func (f bulkDeleteFilter) match(e repo.Event) bool {
+	if f.summary != nil && !f.summary.MatchString(e.Summary) {
+		return false
+	}
+
+	if f.tag != "" && !e.HasTag(f.tag) {
+		return false
+	}
+
+	if f.createdBy != "" && (e.Data == nil || e.Data.CreatedBy != f.createdBy) {
+		return false
+	}
+
+	return true
+}
+
+// bulkDeleteToken returns the confirmation token of deleting events with
+// req. The token changes whenever the request or the set of matching
+// events changes so a confirmation never deletes events that have not been
+// listed to the caller.
+func bulkDeleteToken(req bulkDeleteRequest, events []bulkDeleteEvent) string {
+	h := sha256.New()
+
+	fmt.Fprintf(h, "%s\x00%s\x00%s\x00%s\x00%s\x00%s\x00",
+		req.CalendarID,
+		req.From.UTC().Format(time.RFC3339),
+		req.To.UTC().Format(time.RFC3339),
+		req.SummaryRegex,
+		req.Tag,
+		req.CreatedBy,
+	)
+
+	for _, e := range events {
+		fmt.Fprintf(h, "%s\x00", e.ID)
+	}
+
+	return hex.EncodeToString(h.Sum(nil)[:16])
+}
+
+// BulkDeleteHandler deletes all events of a calendar that match a filter,
+// like events of an external feed that has been imported by accident:
+//
+//	POST /events/bulk-delete {"calendarId": "<id>", "from": "...", "to": "...", "summaryRegex": "^Holiday"}
+//
+// The first request returns the matching events and a confirmation token.
+// Events are only deleted if the token is echoed back in confirmToken and
+// the matching events did not change in between. Every deletion is logged
+// with the calling user. Only callers with one of the allowed roles
+// (X-Remote-Role) may delete events in bulk.
+type BulkDeleteHandler struct {
+	svc          *CalendarService
+	allowedRoles []string
+}
+
+// NewBulkDeleteHandler returns a new bulk delete handler for svc.
+func NewBulkDeleteHandler(svc *CalendarService, allowedRoles []string) *BulkDeleteHandler {
+	return &BulkDeleteHandler{
+		svc:          svc,
+		allowedRoles: allowedRoles,
+	}
+}
+
+func (h *BulkDeleteHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !authorize(w, r, h.allowedRoles) {
+		return
+	}
+
+	var body bulkDeleteRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if body.CalendarID == "" {
+		http.Error(w, "missing value for calendarId", http.StatusBadRequest)
+		return
+	}
+
+	if !body.To.After(body.From) || body.To.Sub(body.From) > maxBulkDeleteRange {
+		http.Error(w, fmt.Sprintf("to must be after from and the range must not exceed %s", maxBulkDeleteRange), http.StatusBadRequest)
+		return
+	}
+
+	filter, err := newBulkDeleteFilter(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.svc.checkWritable(body.CalendarID); err != nil {
+		http.Error(w, err.Error(), httpStatus(err))
+		return
+	}
+
+	events, err := h.svc.repo.ListEvents(r.Context(), body.CalendarID, repo.WithEventsAfter(body.From), repo.WithEventsBefore(body.To))
+	if err != nil {
+		http.Error(w, err.Error(), httpStatus(err))
+		return
+	}
+
+	sort.Stable(repo.ByStartTime(events))
+
+	res := bulkDeleteResponse{
+		Events: make([]bulkDeleteEvent, 0, len(events)),
+	}
+
+	var matched []repo.Event
+	for _, e := range events {
+		if e.StartTime.Before(body.To) && filter.match(e) {
+			matched = append(matched, e)
+		}
+	}
+
+	// events are matched by their details but only listed with the details
+	// the caller may see.
+	matched = h.svc.redactor().redact(matched, r.Header.Get("X-Remote-User-ID"), requestLanguage(r, h.svc.repo.Config.DefaultLanguage))
+
+	for _, e := range matched {
+		match := bulkDeleteEvent{
+			ID:      e.ID,
+			Summary: e.Summary,
+			Start:   e.StartTime,
+			End:     e.EndTime,
+			Tags:    e.Tags,
+		}
+
+		if e.Data != nil {
+			match.CreatedBy = e.Data.CreatedBy
+		}
+
+		res.Events = append(res.Events, match)
+	}
+
+	token := bulkDeleteToken(body, res.Events)
+
+	switch {
+	case body.ConfirmToken == "":
+		res.ConfirmToken = token
+
+	case body.ConfirmToken != token:
+		http.Error(w, "the matching events changed since the confirmation token was issued", http.StatusConflict)
+		return
+
+	default:
+		res.Results = h.delete(r, body.CalendarID, res.Events)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		slog.Error("failed to encode bulk delete response", "error", err)
+	}
+}
+
+// delete deletes events of calID with bounded concurrency and returns the
+// result of each deletion in the order of events.
+func (h *BulkDeleteHandler) delete(r *http.Request, calID string, events []bulkDeleteEvent) []bulkDeleteResult {
+	user := r.Header.Get("X-Remote-User-ID")
+	results := make([]bulkDeleteResult, len(events))
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, bulkDeleteConcurrency)
+
+	for idx, e := range events {
+		wg.Add(1)
+		sem <- struct{}{}
+
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			results[idx] = bulkDeleteResult{EventID: e.ID}
+
+			if err := h.svc.repo.DeleteEvent(r.Context(), calID, e.ID); err != nil {
+				slog.Error("failed to bulk delete event", "calendar-id", calID, "event-id", e.ID, "user", user, "error", err)
+				results[idx].Error = err.Error()
+
+				return
+			}
+
+			if err := h.svc.notes.delete(calID, e.ID); err != nil {
+				slog.Error("failed to delete event note", "calendar-id", calID, "event-id", e.ID, "error", err)
+			}
+
+			slog.Info("bulk deleted event", "calendar-id", calID, "event-id", e.ID, "summary", repo.RedactSummary(e.Summary), "start", e.Start, "user", user)
+			results[idx].Deleted = true
+		}()
+	}
+
+	wg.Wait()
+
+	return results
+}
@@ -0,0 +1,192 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/app"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/repo"
+)
+
+// deleteRepo is a bookingRepo that records deleted events.
+type deleteRepo struct {
+	*bookingRepo
+
+	l       sync.Mutex
+	deleted []string
+	fail    map[string]bool
+}
+
+func (d *deleteRepo) DeleteEvent(_ context.Context, calID, eventID string) error {
+	d.l.Lock()
+	defer d.l.Unlock()
+
+	if d.fail[eventID] {
+		return errors.New("upstream failure")
+	}
+
+	d.deleted = append(d.deleted, eventID)
+
+	return nil
+}
+
+func Test_BulkDelete(t *testing.T) {
+	svc, bookings := newBookingTestService(t)
+
+	at := func(hour int) time.Time {
+		return time.Date(2024, time.June, 3, hour, 0, 0, 0, time.UTC)
+	}
+
+	bookings.events["vet-1"] = []repo.Event{
+		{ID: "h1", CalendarID: "vet-1", Summary: "Holiday: Whit Monday", StartTime: at(8), EndTime: ptr(at(9))},
+		{ID: "h2", CalendarID: "vet-1", Summary: "Holiday: Corpus Christi", StartTime: at(10), EndTime: ptr(at(11)), Tags: []string{"feed"}},
+		{ID: "appointment", CalendarID: "vet-1", Summary: "Bello", StartTime: at(12), EndTime: ptr(at(13)), Data: &repo.StructuredEvent{CreatedBy: "alice"}},
+	}
+
+	fake := &deleteRepo{bookingRepo: bookings, fail: map[string]bool{}}
+	svc.repo = &app.App{Service: fake, Config: svc.repo.Config}
+
+	handler := NewBulkDeleteHandler(svc, []string{"admin"})
+
+	send := func(body bulkDeleteRequest, roles ...string) (*httptest.ResponseRecorder, bulkDeleteResponse) {
+		payload, err := json.Marshal(body)
+		require.NoError(t, err)
+
+		req := httptest.NewRequest(http.MethodPost, "/events/bulk-delete", strings.NewReader(string(payload)))
+		req.Header.Set("X-Remote-User-ID", "alice")
+		for _, role := range roles {
+			req.Header.Add("X-Remote-Role", role)
+		}
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		var res bulkDeleteResponse
+		if rec.Code == http.StatusOK {
+			require.NoError(t, json.NewDecoder(rec.Body).Decode(&res))
+		}
+
+		return rec, res
+	}
+
+	body := bulkDeleteRequest{
+		CalendarID:   "vet-1",
+		From:         at(0),
+		To:           at(24),
+		SummaryRegex: "^Holiday",
+	}
+
+	rec, _ := send(body)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	// a filter is required
+	rec, _ = send(bulkDeleteRequest{CalendarID: "vet-1", From: at(0), To: at(24)}, "admin")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec, _ = send(bulkDeleteRequest{CalendarID: "vet-1", From: at(0), To: at(24), SummaryRegex: "("}, "admin")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	// the first request only lists the matching events
+	rec, res := send(body, "admin")
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Len(t, res.Events, 2)
+	assert.Equal(t, "h1", res.Events[0].ID)
+	assert.Equal(t, "h2", res.Events[1].ID)
+	assert.NotEmpty(t, res.ConfirmToken)
+	assert.Empty(t, res.Results)
+	assert.Empty(t, fake.deleted)
+
+	// a token of another filter is rejected
+	other := body
+	other.Tag = "feed"
+
+	_, otherRes := send(other, "admin")
+	require.Len(t, otherRes.Events, 1)
+
+	body.ConfirmToken = otherRes.ConfirmToken
+
+	rec, _ = send(body, "admin")
+	assert.Equal(t, http.StatusConflict, rec.Code)
+	assert.Empty(t, fake.deleted)
+
+	// the matching events are deleted once confirmed
+	logs := captureLogs(t)
+
+	fake.fail["h2"] = true
+	body.ConfirmToken = res.ConfirmToken
+
+	rec, res = send(body, "admin")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, []bulkDeleteResult{
+		{EventID: "h1", Deleted: true},
+		{EventID: "h2", Error: "upstream failure"},
+	}, res.Results)
+	assert.Equal(t, []string{"h1"}, fake.deleted)
+
+	// summaries are not logged in clear text
+	deleted := logs.attrs("bulk deleted event")
+	require.Len(t, deleted, 1)
+	assert.Equal(t, "h1", deleted[0]["event-id"])
+	assert.Equal(t, repo.RedactSummary("Holiday: Whit Monday"), deleted[0]["summary"])
+	assert.NotContains(t, deleted[0]["summary"], "Whit Monday")
+
+	// the token is invalidated once the matching events change
+	fake.deleted = nil
+	bookings.events["vet-1"] = append(bookings.events["vet-1"], repo.Event{ID: "h3", CalendarID: "vet-1", Summary: "Holiday: Assumption Day", StartTime: at(14), EndTime: ptr(at(15))})
+
+	rec, _ = send(body, "admin")
+	assert.Equal(t, http.StatusConflict, rec.Code)
+	assert.Empty(t, fake.deleted)
+}
+
+func Test_BulkDeleteFilter(t *testing.T) {
+	f, err := newBulkDeleteFilter(bulkDeleteRequest{Tag: "feed", CreatedBy: "alice"})
+	require.NoError(t, err)
+
+	assert.True(t, f.match(repo.Event{Tags: []string{"feed"}, Data: &repo.StructuredEvent{CreatedBy: "alice"}}))
+	assert.False(t, f.match(repo.Event{Tags: []string{"feed"}}))
+	assert.False(t, f.match(repo.Event{Data: &repo.StructuredEvent{CreatedBy: "alice"}}))
+}
+
+func Test_BulkDelete_Private(t *testing.T) {
+	svc, bookings := newBookingTestService(t)
+
+	at := func(hour int) time.Time {
+		return time.Date(2024, time.June, 3, hour, 0, 0, 0, time.UTC)
+	}
+
+	bookings.events["vet-1"] = []repo.Event{
+		{ID: "p1", CalendarID: "vet-1", Summary: "Holiday: Dr. Who", Visibility: repo.VisibilityPrivate, StartTime: at(8), EndTime: ptr(at(9)), Tags: []string{"feed"}},
+	}
+
+	handler := NewBulkDeleteHandler(svc, []string{"admin"})
+
+	payload, err := json.Marshal(bulkDeleteRequest{CalendarID: "vet-1", From: at(0), To: at(24), SummaryRegex: "^Holiday"})
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodPost, "/events/bulk-delete", strings.NewReader(string(payload)))
+	req.Header.Set("X-Remote-User-ID", "bob")
+	req.Header.Set("X-Remote-Role", "admin")
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	var res bulkDeleteResponse
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&res))
+
+	// private events still match but are listed without their details
+	require.Len(t, res.Events, 1)
+	assert.Equal(t, "p1", res.Events[0].ID)
+	assert.Equal(t, "Privat", res.Events[0].Summary)
+	assert.Empty(t, res.Events[0].Tags)
+}
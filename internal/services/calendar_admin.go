@@ -0,0 +1,347 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+
+	"github.com/bufbuild/connect-go"
+	idmv1 "github.com/tierklinik-dobersberg/apis/gen/go/tkd/idm/v1"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/repo"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// calendarIDExtraKey is the extra field of IDM users that holds the ID of
+// the calendar assigned to the user.
+const calendarIDExtraKey = "calendarID"
+
+// createCalendarRequest is the request body of the CreateCalendarHandler.
+type createCalendarRequest struct {
+	Name       string `json:"name"`
+	AssignUser string `json:"assignUser,omitempty"`
+}
+
+// assignCalendarRequest is the request body of the AssignCalendarHandler.
+type assignCalendarRequest struct {
+	CalendarID string `json:"calendarId"`
+	UserID     string `json:"userId"`
+}
+
+// hexColor matches colors in hex format, like #1e88e5.
+var hexColor = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+// updateCalendarRequest is the request body of the UpdateCalendarHandler.
+type updateCalendarRequest struct {
+	CalendarID string `json:"calendarId"`
+	Name       string `json:"name,omitempty"`
+	Color      string `json:"color,omitempty"`
+}
+
+// calendarAdminResponse is the response of the calendar admin endpoints.
+// ShareError and AssignError are set if the calendar has been created but
+// could not be shared with all configured principals or assigned to the
+// user.
+type calendarAdminResponse struct {
+	CalendarID  string `json:"calendarId"`
+	Name        string `json:"name"`
+	Color       string `json:"color,omitempty"`
+	AssignedTo  string `json:"assignedTo,omitempty"`
+	ShareError  string `json:"shareError,omitempty"`
+	AssignError string `json:"assignError,omitempty"`
+}
+
+// CreateCalendarHandler creates a new calendar, shares it with the
+// configured principals and optionally assigns it to a user:
+//
+//	POST /calendars/create {"name": "Dr. Maier", "assignUser": "<user-id>"}
+//
+// The user is checked before the calendar is created. If the calendar has
+// been created but the assignment failed the response reports the calendar
+// ID and the error in assignError so just the assignment can be retried
+// using the AssignCalendarHandler. Only callers with one of the allowed
+// roles (X-Remote-Role) may create calendars.
+type CreateCalendarHandler struct {
+	svc          *CalendarService
+	allowedRoles []string
+}
+
+// NewCreateCalendarHandler returns a new handler that creates calendars
+// using svc.
+func NewCreateCalendarHandler(svc *CalendarService, allowedRoles []string) *CreateCalendarHandler {
+	return &CreateCalendarHandler{
+		svc:          svc,
+		allowedRoles: allowedRoles,
+	}
+}
+
+func (h *CreateCalendarHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !authorize(w, r, h.allowedRoles) {
+		return
+	}
+
+	var body createCalendarRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if body.Name == "" {
+		http.Error(w, "missing value for name", http.StatusBadRequest)
+		return
+	}
+
+	creator, ok := h.svc.repo.Service.(repo.CalendarCreator)
+	if !ok {
+		http.Error(w, "calendars cannot be created", http.StatusNotImplemented)
+		return
+	}
+
+	// make sure the user exists so a typo does not leave an unassigned
+	// calendar behind.
+	if body.AssignUser != "" {
+		if _, err := h.svc.lookupUser(r.Context(), body.AssignUser); err != nil {
+			http.Error(w, err.Error(), httpStatus(err))
+			return
+		}
+	}
+
+	user := r.Header.Get("X-Remote-User-ID")
+
+	cal, err := creator.CreateCalendar(r.Context(), body.Name)
+	if cal == nil {
+		slog.Error("failed to create calendar", "name", body.Name, "user", user, "error", err)
+		http.Error(w, err.Error(), httpStatus(err))
+
+		return
+	}
+
+	slog.Info("created calendar", "calendar-id", cal.ID, "name", cal.Name, "user", user)
+
+	h.svc.upsertCalendar(*cal)
+
+	res := calendarAdminResponse{
+		CalendarID: cal.ID,
+		Name:       cal.Name,
+	}
+
+	if err != nil {
+		slog.Error("failed to share calendar", "calendar-id", cal.ID, "error", err)
+		res.ShareError = err.Error()
+	}
+
+	if body.AssignUser != "" {
+		if err := h.svc.assignCalendar(r.Context(), body.AssignUser, cal.ID); err != nil {
+			slog.Error("created calendar could not be assigned", "calendar-id", cal.ID, "user-id", body.AssignUser, "error", err)
+			res.AssignError = err.Error()
+		} else {
+			res.AssignedTo = body.AssignUser
+		}
+	}
+
+	writeCalendarAdminResponse(w, res)
+}
+
+// AssignCalendarHandler assigns an existing calendar to a user by writing
+// the calendarID extra field of the user:
+//
+//	POST /calendars/assign {"calendarId": "<id>", "userId": "<user-id>"}
+//
+// Only callers with one of the allowed roles (X-Remote-Role) may assign
+// calendars.
+type AssignCalendarHandler struct {
+	svc          *CalendarService
+	allowedRoles []string
+}
+
+// NewAssignCalendarHandler returns a new handler that assigns calendars to
+// users using svc.
+func NewAssignCalendarHandler(svc *CalendarService, allowedRoles []string) *AssignCalendarHandler {
+	return &AssignCalendarHandler{
+		svc:          svc,
+		allowedRoles: allowedRoles,
+	}
+}
+
+func (h *AssignCalendarHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !authorize(w, r, h.allowedRoles) {
+		return
+	}
+
+	var body assignCalendarRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if body.CalendarID == "" || body.UserID == "" {
+		http.Error(w, "missing value for calendarId or userId", http.StatusBadRequest)
+		return
+	}
+
+	cal, ok := h.svc.calendarById.Get(body.CalendarID)
+	if !ok {
+		http.Error(w, fmt.Sprintf("calendar %q not found", body.CalendarID), http.StatusNotFound)
+		return
+	}
+
+	if _, err := h.svc.lookupUser(r.Context(), body.UserID); err != nil {
+		http.Error(w, err.Error(), httpStatus(err))
+		return
+	}
+
+	if err := h.svc.assignCalendar(r.Context(), body.UserID, cal.ID); err != nil {
+		slog.Error("failed to assign calendar", "calendar-id", cal.ID, "user-id", body.UserID, "error", err)
+		http.Error(w, err.Error(), http.StatusBadGateway)
+
+		return
+	}
+
+	writeCalendarAdminResponse(w, calendarAdminResponse{
+		CalendarID: cal.ID,
+		Name:       cal.Name,
+		AssignedTo: body.UserID,
+	})
+}
+
+// UpdateCalendarHandler changes the name and color of a calendar:
+//
+//	POST /calendars/update {"calendarId": "<id>", "name": "Dr. Maier", "color": "#1e88e5"}
+//
+// Empty fields are left unchanged. Calendars that are read-only for events
+// may still be updated. Only callers with one of the allowed roles
+// (X-Remote-Role) may update calendars.
+type UpdateCalendarHandler struct {
+	svc          *CalendarService
+	allowedRoles []string
+}
+
+// NewUpdateCalendarHandler returns a new handler that updates calendars
+// using svc.
+func NewUpdateCalendarHandler(svc *CalendarService, allowedRoles []string) *UpdateCalendarHandler {
+	return &UpdateCalendarHandler{
+		svc:          svc,
+		allowedRoles: allowedRoles,
+	}
+}
+
+func (h *UpdateCalendarHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !authorize(w, r, h.allowedRoles) {
+		return
+	}
+
+	var body updateCalendarRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if body.CalendarID == "" {
+		http.Error(w, "missing value for calendarId", http.StatusBadRequest)
+		return
+	}
+
+	if body.Name == "" && body.Color == "" {
+		http.Error(w, "at least one of name or color is required", http.StatusBadRequest)
+		return
+	}
+
+	if body.Color != "" && !hexColor.MatchString(body.Color) {
+		http.Error(w, fmt.Sprintf("invalid value for color: %q is not a hex color like #1e88e5", body.Color), http.StatusBadRequest)
+		return
+	}
+
+	updater, ok := h.svc.repo.Service.(repo.CalendarUpdater)
+	if !ok {
+		http.Error(w, "calendars cannot be updated", http.StatusNotImplemented)
+		return
+	}
+
+	cal, ok := h.svc.calendarById.Get(body.CalendarID)
+	if !ok {
+		http.Error(w, fmt.Sprintf("calendar %q not found", body.CalendarID), http.StatusNotFound)
+		return
+	}
+
+	user := r.Header.Get("X-Remote-User-ID")
+
+	if err := updater.UpdateCalendar(r.Context(), cal.ID, repo.CalendarUpdate{Name: body.Name, Color: body.Color}); err != nil {
+		slog.Error("failed to update calendar", "calendar-id", cal.ID, "user", user, "error", err)
+		http.Error(w, err.Error(), httpStatus(err))
+
+		return
+	}
+
+	slog.Info("updated calendar", "calendar-id", cal.ID, "name", body.Name, "color", body.Color, "user", user)
+
+	if body.Name != "" {
+		cal.Name = body.Name
+	}
+
+	if body.Color != "" {
+		cal.Color = body.Color
+	}
+
+	h.svc.upsertCalendar(cal)
+
+	writeCalendarAdminResponse(w, calendarAdminResponse{
+		CalendarID: cal.ID,
+		Name:       cal.Name,
+		Color:      cal.Color,
+	})
+}
+
+func writeCalendarAdminResponse(w http.ResponseWriter, res calendarAdminResponse) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		slog.Error("failed to encode calendar admin response", "error", err)
+	}
+}
+
+// upsertCalendar adds or replaces cal in the calendar cache so changes are
+// visible right away and reloads the calendars.
+func (svc *CalendarService) upsertCalendar(cal repo.Calendar) {
+	svc.calendars.Upsert(cal, func(c repo.Calendar) bool {
+		return c.ID == cal.ID
+	})
+	svc.calendars.TriggerSync()
+}
+
+// assignCalendar stores calID in the calendarID extra field of the user and
+// reloads the profile of the user so the assignment is picked up.
+func (svc *CalendarService) assignCalendar(ctx context.Context, userID, calID string) error {
+	if _, err := svc.repo.Users.SetUserExtraKey(ctx, connect.NewRequest(&idmv1.SetUserExtraKeyRequest{
+		UserId: userID,
+		Path:   calendarIDExtraKey,
+		Value:  structpb.NewStringValue(calID),
+	})); err != nil {
+		return fmt.Errorf("failed to assign calendar %s to user %s: %w", calID, userID, err)
+	}
+
+	slog.Info("assigned calendar", "calendar-id", calID, "user-id", userID)
+
+	if _, err := svc.loadUser(ctx, userID); err != nil && svc.users != nil {
+		slog.Error("failed to reload assigned user", "user-id", userID, "error", err)
+		svc.users.TriggerSync()
+	}
+
+	return nil
+}
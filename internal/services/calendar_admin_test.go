@@ -0,0 +1,178 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	idmv1 "github.com/tierklinik-dobersberg/apis/gen/go/tkd/idm/v1"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/cache"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/repo"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+func (f *fakeIDM) SetUserExtraKey(_ context.Context, req *connect.Request[idmv1.SetUserExtraKeyRequest]) (*connect.Response[idmv1.SetUserExtraKeyResponse], error) {
+	if f.setErr != nil {
+		return nil, f.setErr
+	}
+
+	profile, ok := f.profiles[req.Msg.UserId]
+	if !ok {
+		return nil, connect.NewError(connect.CodeNotFound, fmt.Errorf("user not found"))
+	}
+
+	if profile.User.Extra == nil {
+		profile.User.Extra = &structpb.Struct{Fields: map[string]*structpb.Value{}}
+	}
+	profile.User.Extra.Fields[req.Msg.Path] = req.Msg.Value
+
+	return connect.NewResponse(&idmv1.SetUserExtraKeyResponse{}), nil
+}
+
+// creatorRepo is a repository that can create and update calendars.
+type creatorRepo struct {
+	repo.Service
+
+	created []string
+	updated []repo.CalendarUpdate
+}
+
+func (c *creatorRepo) UpdateCalendar(_ context.Context, calID string, update repo.CalendarUpdate) error {
+	c.updated = append(c.updated, update)
+
+	return nil
+}
+
+func (c *creatorRepo) CreateCalendar(_ context.Context, name string) (*repo.Calendar, error) {
+	c.created = append(c.created, name)
+
+	return &repo.Calendar{ID: fmt.Sprintf("new-cal-%d", len(c.created)), Name: name}, nil
+}
+
+func newCalendarAdminTestService() (*CalendarService, *fakeIDM, *creatorRepo) {
+	svc, idm := newUserLookupTestService()
+
+	creator := &creatorRepo{Service: svc.repo.Service}
+	svc.repo.Service = creator
+
+	svc.calendarById = cache.CreateIndex(svc.calendars, func(c repo.Calendar) (string, bool) {
+		return c.ID, true
+	})
+
+	return svc, idm, creator
+}
+
+func calendarAdminRequest(t *testing.T, h http.Handler, body string) (int, calendarAdminResponse) {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodPost, "/calendars", strings.NewReader(body))
+	req.Header.Set("X-Remote-User-ID", "alice")
+	req.Header.Set("X-Remote-Role", "admin")
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	var res calendarAdminResponse
+	if rec.Code == http.StatusOK {
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&res))
+	}
+
+	return rec.Code, res
+}
+
+func Test_CreateCalendarHandler(t *testing.T) {
+	svc, idm, creator := newCalendarAdminTestService()
+	h := NewCreateCalendarHandler(svc, []string{"admin"})
+
+	code, res := calendarAdminRequest(t, h, `{"name": "Dr. Maier", "assignUser": "no-calendar"}`)
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, calendarAdminResponse{CalendarID: "new-cal-1", Name: "Dr. Maier", AssignedTo: "no-calendar"}, res)
+
+	assert.Equal(t, "new-cal-1", idm.profiles["no-calendar"].User.Extra.Fields[calendarIDExtraKey].GetStringValue())
+
+	// the calendar and the assignment can be used right away
+	_, ok := svc.calendarById.Get("new-cal-1")
+	assert.True(t, ok)
+
+	calID, err := svc.resolveUserCalendar(context.Background(), "no-calendar")
+	require.NoError(t, err)
+	assert.Equal(t, "new-cal-1", calID)
+
+	// unknown users are rejected before the calendar is created
+	code, _ = calendarAdminRequest(t, h, `{"name": "Dr. Huber", "assignUser": "unknown"}`)
+	assert.Equal(t, http.StatusNotFound, code)
+	assert.Equal(t, []string{"Dr. Maier"}, creator.created)
+
+	code, _ = calendarAdminRequest(t, h, `{}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+}
+
+func Test_CreateCalendarHandler_AssignmentFails(t *testing.T) {
+	svc, idm, _ := newCalendarAdminTestService()
+	idm.setErr = connect.NewError(connect.CodeUnavailable, errors.New("idm down"))
+
+	code, res := calendarAdminRequest(t, NewCreateCalendarHandler(svc, []string{"admin"}), `{"name": "Dr. Maier", "assignUser": "new"}`)
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "new-cal-1", res.CalendarID)
+	assert.Empty(t, res.AssignedTo)
+	assert.Contains(t, res.AssignError, "idm down")
+
+	// just the assignment is retried
+	assign := NewAssignCalendarHandler(svc, []string{"admin"})
+
+	code, _ = calendarAdminRequest(t, assign, `{"calendarId": "new-cal-1", "userId": "new"}`)
+	assert.Equal(t, http.StatusBadGateway, code)
+
+	idm.setErr = nil
+
+	code, res = calendarAdminRequest(t, assign, `{"calendarId": "new-cal-1", "userId": "new"}`)
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, calendarAdminResponse{CalendarID: "new-cal-1", Name: "Dr. Maier", AssignedTo: "new"}, res)
+
+	code, _ = calendarAdminRequest(t, assign, `{"calendarId": "unknown", "userId": "new"}`)
+	assert.Equal(t, http.StatusNotFound, code)
+}
+
+func Test_UpdateCalendarHandler(t *testing.T) {
+	svc, _, creator := newCalendarAdminTestService()
+	svc.calendars.Upsert(repo.Calendar{ID: "cal-1", Name: "Dr. Maier", Color: "#ffffff", Readonly: true}, func(repo.Calendar) bool { return false })
+
+	h := NewUpdateCalendarHandler(svc, []string{"admin"})
+
+	// read-only calendars can be renamed
+	code, res := calendarAdminRequest(t, h, `{"calendarId": "cal-1", "name": "Dr. Maier-Huber"}`)
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, calendarAdminResponse{CalendarID: "cal-1", Name: "Dr. Maier-Huber", Color: "#ffffff"}, res)
+
+	code, res = calendarAdminRequest(t, h, `{"calendarId": "cal-1", "color": "#1e88e5"}`)
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "#1e88e5", res.Color)
+
+	// the change is visible right away
+	cal, ok := svc.calendarById.Get("cal-1")
+	require.True(t, ok)
+	assert.Equal(t, "Dr. Maier-Huber", cal.Name)
+	assert.Equal(t, "#1e88e5", cal.Color)
+
+	assert.Equal(t, []repo.CalendarUpdate{{Name: "Dr. Maier-Huber"}, {Color: "#1e88e5"}}, creator.updated)
+
+	cases := map[string]int{
+		`{"calendarId": "cal-1"}`:                    http.StatusBadRequest,
+		`{"calendarId": "cal-1", "color": "blue"}`:   http.StatusBadRequest,
+		`{"name": "Dr. Maier"}`:                      http.StatusBadRequest,
+		`{"calendarId": "unknown", "name": "Dr. X"}`: http.StatusNotFound,
+	}
+
+	for body, expected := range cases {
+		code, _ := calendarAdminRequest(t, h, body)
+		assert.Equal(t, expected, code, body)
+	}
+}
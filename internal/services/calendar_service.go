@@ -2,10 +2,13 @@ package services
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+	"net/http"
 	"slices"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -13,22 +16,111 @@ import (
 	"github.com/mennanov/fmutils"
 	calendarv1 "github.com/tierklinik-dobersberg/apis/gen/go/tkd/calendar/v1"
 	"github.com/tierklinik-dobersberg/apis/gen/go/tkd/calendar/v1/calendarv1connect"
-	commonv1 "github.com/tierklinik-dobersberg/apis/gen/go/tkd/common/v1"
 	idmv1 "github.com/tierklinik-dobersberg/apis/gen/go/tkd/idm/v1"
 	rosterv1 "github.com/tierklinik-dobersberg/apis/gen/go/tkd/roster/v1"
-	"github.com/tierklinik-dobersberg/apis/pkg/data"
-	"github.com/tierklinik-dobersberg/apis/pkg/discovery/consuldiscover"
-	"github.com/tierklinik-dobersberg/apis/pkg/discovery/wellknown"
 	"github.com/tierklinik-dobersberg/apis/pkg/log"
+	"github.com/tierklinik-dobersberg/apis/pkg/privacy"
 	"github.com/tierklinik-dobersberg/cis-cal/internal/app"
 	"github.com/tierklinik-dobersberg/cis-cal/internal/cache"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/holidays"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/i18n"
 	"github.com/tierklinik-dobersberg/cis-cal/internal/repo"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/richtext"
 	"golang.org/x/exp/maps"
+	"google.golang.org/protobuf/encoding/protojson"
 	"google.golang.org/protobuf/types/known/anypb"
 	"google.golang.org/protobuf/types/known/fieldmaskpb"
 	"google.golang.org/protobuf/types/known/structpb"
 )
 
+// writableOnlyHeader may be set to true on ListCalendars requests to hide
+// calendars that are read-only for the service account.
+const writableOnlyHeader = "X-Writable-Only"
+
+// excludeCalendarHeader and excludeUserHeader may be set multiple times on
+// ListEvents requests to exclude calendars from the requested sources.
+const (
+	excludeCalendarHeader = "X-Exclude-Calendar-Id"
+	excludeUserHeader     = "X-Exclude-User-Id"
+)
+
+// eventTagHeader may be set multiple times. On CreateEvent and UpdateEvent
+// (update-mask path "tags") it sets the tags of the event, on ListEvents
+// only events with at least one of the tags are returned. The request
+// messages do not yet have fields for tags.
+const eventTagHeader = "X-Event-Tag"
+
+// eventQueryHeader may be set on ListEvents requests to only return events
+// whose summary or description match the free-text query, see
+// repo.MatchRank. The ListEventsRequest does not yet have a field for it.
+const eventQueryHeader = "X-Event-Query"
+
+// eventResourceHeader may be set on ListEvents requests to only return
+// events that require the resource, like an operating room. The
+// ListEventsRequest does not yet have a field for it.
+const eventResourceHeader = "X-Event-Resource"
+
+// eventStatusHeader may be set multiple times on ListEvents requests to only
+// return events with one of the appointment statuses, like "arrived" for a
+// waiting-room view. The status of each returned event that is not planned
+// is reported in eventStatusResultHeader, once per event, as
+// "<calendar-id> <event-id> <status>". The event messages do not yet have
+// a status field.
+const (
+	eventStatusHeader       = "X-Event-Status"
+	eventStatusResultHeader = "X-Event-Status-Result"
+)
+
+// moveStartHeader and moveEndHeader may be set on MoveEvent requests to
+// change the time of the event as part of the move. Both are RFC3339
+// timestamps. If only the start is set the event keeps its duration. The
+// MoveEventRequest does not yet have fields for the time.
+const (
+	moveStartHeader = "X-Move-Start"
+	moveEndHeader   = "X-Move-End"
+)
+
+// allowPartialHeader may be set on ListEvents requests to control whether
+// a failing calendar aborts the whole request. It defaults to true when
+// querying all calendars or users and to false otherwise. Failed calendars
+// are reported in calendarErrorHeader, once per calendar, as
+// "<calendar-id> <code> <quoted message>".
+const (
+	allowPartialHeader  = "X-Allow-Partial"
+	calendarErrorHeader = "X-Calendar-Error"
+)
+
+// allowMissingHeader may be set to true on DeleteEvent requests to treat
+// events that have already been deleted as successfully deleted.
+// deletedEventHeader is set on DeleteEvent responses to the protojson
+// encoded event that has been deleted so clients can offer to undo it. It
+// is omitted if the event exceeds maxDeletedEventHeader bytes as proxies
+// limit the size of headers. The request and response messages do not yet
+// have fields for them.
+const (
+	allowMissingHeader    = "X-Allow-Missing"
+	deletedEventHeader    = "X-Deleted-Event"
+	maxDeletedEventHeader = 4 << 10
+)
+
+// unresolvedSourceHeader is set on ListEvents responses once per requested
+// source that did not resolve to a calendar, as "<kind> <id> <reason>":
+//
+//	user <id> not-found     the user does not exist
+//	user <id> no-calendar   the user has no calendar assigned
+//	calendar <id> not-found the calendar is unknown (only with allow-partial)
+//
+// The ListEventsResponse does not yet have a field for them.
+const unresolvedSourceHeader = "X-Unresolved-Source"
+
+// eventsTruncatedHeader is set on ListEvents responses once per calendar
+// whose events have been loaded with a time range that has no end while its
+// event cache was not ready yet, as "<calendar-id> <RFC3339 end>". Those
+// searches are capped at the cold load window so events after the reported
+// end are missing and should be requested again later. The
+// ListEventsResponse does not yet have a field for this.
+const eventsTruncatedHeader = "X-Events-Truncated"
+
 type CalendarService struct {
 	calendarv1connect.UnimplementedCalendarServiceHandler
 
@@ -38,18 +130,54 @@ type CalendarService struct {
 	byUserId    *cache.Index[string, *idmv1.Profile]
 	userByCalId *cache.Index[string, *idmv1.Profile]
 
+	// disabledByCalId holds the calendars of disabled users. Those are
+	// skipped by userByCalId unless IncludeDisabledUsers is configured.
+	disabledByCalId *cache.Index[string, *idmv1.Profile]
+
 	// Calendar cache and various indexes.
 	calendars    *cache.Cache[repo.Calendar]
 	calendarById *cache.Index[string, repo.Calendar]
 
+	holidays holidays.Getter
+	roster   *rosterFetcher
+
+	// events loads calendar events including overlay events.
+	events eventLister
+
+	// colors are applied to created and updated events.
+	colors colorRules
+
+	// channels add their prefix and suffix to the summary of events
+	// created through them.
+	channels channels
+
+	// conflicts detects overlapping events within a calendar.
+	conflicts *conflictDetector
+
+	// slotLocks are advisory locks on time ranges held while booking.
+	slotLocks *slotLocks
+
+	// protos caches the protobuf representation of events, nil if
+	// disabled.
+	protos *protoCache
+
+	// notes are internal remarks for events, nil if disabled.
+	notes *NoteStore
+
+	// userLookups deduplicates IDM lookups of users missing from the
+	// profile cache.
+	userLookups userLookups
+
 	repo *app.App
 }
 
 func New(ctx context.Context, svc *app.App) *CalendarService {
 
 	// create a new user profile cache.
-	profileCache := cache.NewCache("profiles", time.Minute*5, cache.LoaderFunc[*idmv1.Profile](func(ctx context.Context) ([]*idmv1.Profile, error) {
+	profileCache := cache.NewCache("profiles", svc.Config.Cache.ProfilesTTL.AsDuration(), cache.LoaderFunc[*idmv1.Profile](func(ctx context.Context) ([]*idmv1.Profile, error) {
 		res, err := svc.Users.ListUsers(ctx, connect.NewRequest(&idmv1.ListUsersRequest{
+			// the extra data holds the calendar id and the disabled flag
+			// of each user.
 			FieldMask: &fieldmaskpb.FieldMask{
 				Paths: []string{"users.user.extra", "users.user.id", "users.user.username"},
 			},
@@ -63,21 +191,31 @@ func New(ctx context.Context, svc *app.App) *CalendarService {
 	}))
 	profileCache.Start(ctx)
 
+	// make sure the event caches for all calendars that are assigned to
+	// users are prewarmed, other calendar caches are created on first use.
+	profileCache.AddIndex(prewarmIndexer{ctx: ctx, repo: svc})
+
 	// create a new calendar cache
-	calendarCache := cache.NewCache("calendars", time.Minute*5, cache.LoaderFunc[repo.Calendar](svc.ListCalendars))
+	calendarCache := cache.NewCache("calendars", svc.Config.Cache.CalendarsTTL.AsDuration(), cache.LoaderFunc[repo.Calendar](svc.ListCalendars))
 	calendarCache.Start(ctx)
 
 	s := &CalendarService{
-		repo:  svc,
-		users: profileCache,
+		repo:      svc,
+		users:     profileCache,
+		holidays:  svc.Holidays,
+		roster:    newRosterFetcher(svc),
+		events:    newOverlayLister(svc, svc.Config.Overlays),
+		colors:    newColorRules(svc.Config.ColorRules),
+		channels:  channels(svc.Config.Channels),
+		slotLocks: newSlotLocks(svc.Config.SlotLocks.TTL.AsDuration()),
 
 		byUserId: cache.CreateIndex(profileCache, func(p *idmv1.Profile) (string, bool) {
 			return p.User.Id, true
 		}),
-		userByCalId: cache.CreateIndex(profileCache, func(p *idmv1.Profile) (string, bool) {
-			calId := extractCalendarId(ctx, p)
-			return calId, calId != ""
-		}),
+		userByCalId: cache.CreateIndex(profileCache, userCalendarIndexer(ctx, func(p *idmv1.Profile) bool {
+			return svc.Config.IncludeDisabledUsers || !isDisabledUser(p, svc.Config.DisabledUserField)
+		})),
+		disabledByCalId: cache.CreateIndex(profileCache, userCalendarIndexer(ctx, disabledUsers(svc.Config.DisabledUserField))),
 
 		calendars: calendarCache,
 		calendarById: cache.CreateIndex(calendarCache, func(c repo.Calendar) (string, bool) {
@@ -85,17 +223,59 @@ func New(ctx context.Context, svc *app.App) *CalendarService {
 		}),
 	}
 
+	if svc.Config.Cache.EventProtos {
+		s.protos = newProtoCache()
+	}
+
+	newPrefetcher(svc, svc.Config.Prefetch, s.userCalendarIds).Start(ctx)
+
+	s.conflicts = &conflictDetector{
+		events:    svc,
+		calendars: s.userCalendarIds,
+		openEnd:   svc.Config.FreeSlots.OpenEndDuration.AsDuration(),
+		days:      svc.Config.Conflicts.Days,
+		now:       time.Now,
+	}
+
+	if interval := svc.Config.Conflicts.Interval.AsDuration(); interval > 0 {
+		s.conflicts.Start(ctx, interval)
+	}
+
 	return s
 }
 
+// SetNoteStore enables internal event notes. It must be called before the
+// service handles requests.
+func (svc *CalendarService) SetNoteStore(notes *NoteStore) {
+	svc.notes = notes
+}
+
 func (svc *CalendarService) ListCalendars(ctx context.Context, req *connect.Request[calendarv1.ListCalendarsRequest]) (*connect.Response[calendarv1.ListCalendarsResponse], error) {
 	res, _ := svc.calendars.Get()
 
+	// ListCalendarsRequest does not have any fields yet so clients opt into
+	// hiding read-only calendars using a header.
+	writableOnly, _ := strconv.ParseBool(req.Header().Get(writableOnlyHeader))
+	includeDisabled := svc.includeDisabledUsers(req.Header())
+
 	response := &calendarv1.ListCalendarsResponse{}
 
+	var disabled []string
 	for _, cal := range res {
+		if writableOnly && cal.Readonly {
+			continue
+		}
+
 		var userId string
-		if user, ok := svc.userByCalId.Get(cal.ID); ok {
+		if user, isDisabled, ok := svc.calendarOwner(cal.ID); ok {
+			if isDisabled {
+				if !includeDisabled {
+					continue
+				}
+
+				disabled = append(disabled, cal.ID)
+			}
+
 			userId = user.User.Id
 		}
 
@@ -108,7 +288,12 @@ func (svc *CalendarService) ListCalendars(ctx context.Context, req *connect.Requ
 		})
 	}
 
-	return connect.NewResponse(response), nil
+	resp := connect.NewResponse(response)
+	for _, id := range disabled {
+		resp.Header().Add(disabledCalendarHeader, id)
+	}
+
+	return resp, nil
 }
 
 func (svc *CalendarService) ListEvents(ctx context.Context, req *connect.Request[calendarv1.ListEventsRequest]) (*connect.Response[calendarv1.ListEventsResponse], error) {
@@ -118,7 +303,37 @@ func (svc *CalendarService) ListEvents(ctx context.Context, req *connect.Request
 		end   time.Time
 	)
 
+	ctx, cancel, err := withRequestTimeout(ctx, req.Header())
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	budget := newDeadlineBudget(ctx)
+
+	relativeRange := req.Header().Get(relativeRangeHeader)
+	if relativeRange != "" && req.Msg.SearchTime != nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("%s cannot be combined with date or time_range", relativeRangeHeader))
+	}
+
 	switch v := req.Msg.SearchTime.(type) {
+	case nil:
+		if relativeRange == "" {
+			break
+		}
+
+		ranges, err := newRelativeRanges(svc.repo.Config)
+		if err != nil {
+			return nil, err
+		}
+
+		start, end, err = ranges.resolve(relativeRange, time.Now())
+		if err != nil {
+			return nil, connect.NewError(connect.CodeInvalidArgument, err)
+		}
+
+		opts = append(opts, repo.WithEventsAfter(start), repo.WithEventsBefore(end))
+
 	case *calendarv1.ListEventsRequest_Date:
 		var (
 			day time.Time
@@ -135,7 +350,8 @@ func (svc *CalendarService) ListEvents(ctx context.Context, req *connect.Request
 			return nil, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("invalid format for date field, expected YYYY-MM-DD or YYYY/MM/DD"))
 		}
 
-		nextDay := day.Add(time.Hour * 24)
+		// days with a DST transition do not have 24 hours
+		nextDay := day.AddDate(0, 0, 1)
 
 		start = day
 		end = nextDay
@@ -157,107 +373,245 @@ func (svc *CalendarService) ListEvents(ctx context.Context, req *connect.Request
 		}
 	}
 
-	readMask := []string{"results.calendar", "results.events"}
-	if req.Msg.ReadMask != nil && len(req.Msg.ReadMask.Paths) > 0 {
-		readMask = req.Msg.ReadMask.Paths
+	if tags := req.Header().Values(eventTagHeader); len(tags) > 0 {
+		opts = append(opts, repo.WithTag(tags...))
 	}
 
-	var (
-		mustLoadCalendars bool
-		mustLoadEvents    bool
-	)
-	for _, path := range readMask {
-		switch {
-		case strings.HasPrefix(path, "results.calendar"):
-			mustLoadCalendars = true
-		case strings.HasPrefix(path, "results.events"):
-			mustLoadEvents = true
-		case path == "results":
-			mustLoadCalendars = true
-			mustLoadEvents = true
+	if query := strings.TrimSpace(req.Header().Get(eventQueryHeader)); query != "" {
+		opts = append(opts, repo.WithQuery(query))
+	}
+
+	if resource := strings.TrimSpace(req.Header().Get(eventResourceHeader)); resource != "" {
+		opts = append(opts, repo.WithResource(resource))
+	}
+
+	if values := req.Header().Values(eventStatusHeader); len(values) > 0 {
+		statuses := make([]string, len(values))
+		for idx, v := range values {
+			status, err := repo.ParseStatus(v)
+			if err != nil {
+				return nil, connect.NewError(connect.CodeInvalidArgument, err)
+			}
+
+			statuses[idx] = status
+		}
+
+		opts = append(opts, repo.WithStatus(statuses...))
+	}
+
+	if values := req.Header().Values(eventChannelHeader); len(values) > 0 {
+		opts = append(opts, repo.WithChannel(values...))
+	}
+
+	format, err := descriptionFormat(req.Header())
+	if err != nil {
+		return nil, err
+	}
+
+	readMask := parseListEventsMask(req.Msg.GetReadMask().GetPaths())
+
+	// the trace reports cache hits for diagnostics and open-ended searches
+	// that have been capped.
+	ctx, trace := repo.WithCacheTrace(ctx)
+
+	var diag *queryDiagnostics
+	if wantsDiagnostics(req.Header(), readMask) {
+		diag = newQueryDiagnostics(start, end)
+		diag.Range = relativeRange
+
+		if left, ok := budget.remaining(); ok {
+			diag.Timings.Deadline = left.String()
 		}
 	}
 
 	// get a list of all calendars from cache
 	allCalendars, _ := svc.calendars.Get()
 
-	// get a list of calendar ids to fetch
+	// get a list of calendar ids to fetch. Calendars in explicit are never
+	// excluded. sources records why each calendar has been selected.
 	calendarIds := make(map[string]struct{})
+	explicit := make(map[string]struct{})
+	sources := make(map[string][]string)
+	implicitExcludes := false
+
+	// unresolved lists the requested sources that do not resolve to a
+	// calendar in the format of the unresolvedSourceHeader.
+	var unresolved []string
+
+	addCalendar := func(calId, source string) {
+		calendarIds[calId] = struct{}{}
+		sources[calId] = append(sources[calId], source)
+	}
 	if req.Msg.Source == nil {
 		// only load the calendar assigned to the user
 
 		log.L(ctx).Infof("no calendar ids specified, loading user profile ...")
-		user, ok := svc.byUserId.Get(req.Header().Get("X-Remote-User-ID"))
-		if !ok {
-			return nil, connect.NewError(connect.CodeInternal, fmt.Errorf("failed to get authenticated user profile"))
+		userId := req.Header().Get("X-Remote-User-ID")
+
+		user, err := svc.lookupUser(ctx, userId)
+		if err != nil {
+			if connect.CodeOf(err) == connect.CodeNotFound {
+				return nil, connect.NewError(connect.CodePermissionDenied, fmt.Errorf("unknown authenticated user %q", userId))
+			}
+
+			return nil, err
 		}
 
 		if calId := extractCalendarId(ctx, user); calId != "" {
-			calendarIds[calId] = struct{}{}
+			addCalendar(calId, sourceSelf)
 		}
 	} else {
 
 		switch v := req.Msg.Source.(type) {
 		case *calendarv1.ListEventsRequest_Sources:
 			for _, id := range v.Sources.CalendarIds {
-				calendarIds[id] = struct{}{}
+				addCalendar(id, sourceCalendar)
+				explicit[id] = struct{}{}
 			}
 
-			if len(v.Sources.UserIds) > 0 {
-				// build a lookup map for the users
-				userSet := make(map[string]struct{})
-				for _, usr := range v.Sources.UserIds {
-					userSet[usr] = struct{}{}
+			seen := make(map[string]struct{})
+			for _, usr := range v.Sources.UserIds {
+				if _, ok := seen[usr]; ok {
+					continue
 				}
+				seen[usr] = struct{}{}
 
-				profiles, _ := svc.users.Get()
-				for _, profile := range profiles {
-					if _, ok := userSet[profile.User.Id]; !ok {
-						continue
+				profile, err := svc.lookupUser(ctx, usr)
+				if err != nil {
+					if connect.CodeOf(err) != connect.CodeNotFound {
+						return nil, err
 					}
 
-					calId := extractCalendarId(ctx, profile)
-					if calId != "" {
-						calendarIds[calId] = struct{}{}
-					}
+					unresolved = append(unresolved, "user "+usr+" not-found")
+					continue
+				}
+
+				calId := extractCalendarId(ctx, profile)
+				if calId == "" {
+					unresolved = append(unresolved, "user "+usr+" no-calendar")
+					continue
 				}
+
+				addCalendar(calId, "user:"+usr)
 			}
 
 		case *calendarv1.ListEventsRequest_AllCalendars:
 			for _, cal := range allCalendars {
-				calendarIds[cal.ID] = struct{}{}
+				addCalendar(cal.ID, sourceAllCalendars)
 			}
+			implicitExcludes = true
 
 		case *calendarv1.ListEventsRequest_AllUsers:
 			for calId := range svc.userByCalId.Keys() {
-				calendarIds[calId] = struct{}{}
+				addCalendar(calId, sourceAllUsers)
 			}
 
+			if svc.disabledByCalId != nil && svc.includeDisabledUsers(req.Header()) {
+				for calId := range svc.disabledByCalId.Keys() {
+					addCalendar(calId, sourceAllUsers)
+				}
+			}
+			implicitExcludes = true
+
 		default:
 			return nil, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("unsupported source specification"))
 		}
 	}
 
+	if includeHolidays, _ := strconv.ParseBool(req.Header().Get(includeHolidaysHeader)); includeHolidays {
+		addCalendar(holidayCalendarID, sourceHolidays)
+		explicit[holidayCalendarID] = struct{}{}
+	}
+
+	svc.excludeCalendars(ctx, calendarIds, explicit, implicitExcludes, req.Header())
+
+	allowPartial := implicitExcludes
+	if v, err := strconv.ParseBool(req.Header().Get(allowPartialHeader)); err == nil {
+		allowPartial = v
+	}
+
+	// unknown calendars are skipped instead of failing the request. The
+	// calendar list is only trusted once it has been loaded.
+	if lastFetch, _ := svc.calendars.LastFetch(); allowPartial && !lastFetch.IsZero() {
+		for _, id := range maps.Keys(calendarIds) {
+			if _, ok := holidayCountry(id, ""); ok {
+				continue
+			}
+
+			if _, ok := svc.calendarById.Get(id); !ok {
+				delete(calendarIds, id)
+				unresolved = append(unresolved, "calendar "+id+" not-found")
+			}
+		}
+	}
+
+	if len(unresolved) > 0 {
+		slices.Sort(unresolved)
+		slog.Warn("some requested sources did not resolve to a calendar", "unresolved", unresolved)
+	}
+
 	if len(calendarIds) == 0 {
+		if len(unresolved) > 0 {
+			return nil, connect.NewError(connect.CodeAborted, fmt.Errorf("no calendars to query, unresolved sources: %s", strings.Join(unresolved, ", ")))
+		}
+
 		return nil, connect.NewError(connect.CodeAborted, fmt.Errorf("no calendars to query"))
 	}
 
 	calendarIdList := maps.Keys(calendarIds)
 	sort.Stable(sort.StringSlice(calendarIdList))
 
+	if diag != nil {
+		diag.resolved(calendarIdList, sources)
+	}
+
 	freeSlots := slices.Contains(req.Msg.RequestKinds, calendarv1.CalenarEventRequestKind_CALENDAR_EVENT_REQUEST_KIND_FREE_SLOTS)
-	onlyFreeSlots := !slices.Contains(req.Msg.RequestKinds, calendarv1.CalenarEventRequestKind_CALENDAR_EVENT_REQUEST_KIND_EVENTS)
+	shiftBounds, _ := strconv.ParseBool(req.Header().Get(shiftBoundsHeader))
+	onlyRosterEvents := !slices.Contains(req.Msg.RequestKinds, calendarv1.CalenarEventRequestKind_CALENDAR_EVENT_REQUEST_KIND_EVENTS)
 
-	shiftsByCalendarId := make(map[string][]*rosterv1.PlannedShift)
+	// free slots and shift bounds are returned as events so there's no need to
+	// query the roster if events are masked-out.
+	withRoster := readMask.events && (freeSlots || shiftBounds)
+	excludeOverlays, _ := strconv.ParseBool(req.Header().Get(excludeOverlaysHeader))
+	excludeAbsences, _ := strconv.ParseBool(req.Header().Get(excludeAbsencesHeader))
+
+	var (
+		shiftsByCalendarId = make(map[string][]*rosterv1.PlannedShift)
+		shiftDefinitions   map[string]*rosterv1.WorkShift
+	)
 
 	// get the working-staff for those days and create a lookup map for all shifts, grouped-by date, grouped by calendar id.
-	if freeSlots {
-		shifts, err := svc.fetchRoster(ctx, start, end)
+	if withRoster {
+		rosterStart := time.Now()
+
+		rosterCtx, cancel := budget.child(ctx, rosterShare)
+		shifts, definitions, err := svc.fetchRoster(rosterCtx, start, end)
+		cancel()
+
+		if diag != nil {
+			diag.Roster = &rosterDiagnostics{
+				Days:     len(shifts),
+				State:    svc.RosterState(),
+				Duration: time.Since(rosterStart).String(),
+			}
+			if err != nil {
+				diag.Roster.Error = err.Error()
+			}
+
+			diag.Timings.Roster = diag.Roster.Duration
+		}
+
 		if err != nil {
 			slog.Error("failed to fetch roster for the requested date", "error", err)
 		} else {
 			slog.Info("got working shifts", "number-of-days", len(shifts))
 
+			shiftDefinitions = definitions
+
+			// a shift must only be added once per calendar even if multiple
+			// assigned users share the same calendar.
+			seen := make(map[string]struct{})
+
 			for _, shifts := range shifts {
 				for _, shift := range shifts {
 					for _, user := range shift.AssignedUserIds {
@@ -273,6 +627,12 @@ func (svc *CalendarService) ListEvents(ctx context.Context, req *connect.Request
 							continue
 						}
 
+						key := calendarId + "/" + shift.UniqueId
+						if _, ok := seen[key]; ok && shift.UniqueId != "" {
+							continue
+						}
+						seen[key] = struct{}{}
+
 						shiftsByCalendarId[calendarId] = append(shiftsByCalendarId[calendarId], shift)
 					}
 				}
@@ -280,155 +640,405 @@ func (svc *CalendarService) ListEvents(ctx context.Context, req *connect.Request
 		}
 	}
 
-	response := &calendarv1.ListEventsResponse{}
-	for _, calId := range calendarIdList {
+	var (
+		response    = &calendarv1.ListEventsResponse{}
+		totalEvents int
+		failed      []calendarError
+		eventsStart = time.Now()
+
+		diff              = newDifferential(ctx, req)
+		etags             []string
+		notModified       []string
+		statuses          []string
+		notes             []string
+		disabledCalendars []string
+		withNotes         = svc.notes.visibleTo(req.Header().Values("X-Remote-Role"))
+		skipped           int
+		slotsTime         time.Duration
+		loaded            []loadedCalendar
+	)
+
+	for calIdx, calId := range calendarIdList {
 		var (
-			events []repo.Event
-			err    error
+			events   []repo.Event
+			err      error
+			calStart = time.Now()
 		)
 
-		if mustLoadEvents || freeSlots {
-			events, err = svc.repo.ListEvents(ctx, calId, opts...)
-			if err != nil {
-				return nil, err
+		country, isHolidayCalendar := holidayCountry(calId, svc.repo.Config.DefaultCountry)
+
+		// do not start querying calendars that cannot complete before the
+		// client gives up.
+		if budget.exhausted() {
+			if !allowPartial {
+				return nil, connect.NewError(connect.CodeDeadlineExceeded, fmt.Errorf("request deadline exhausted after %d of %d calendars", calIdx, len(calendarIdList)))
 			}
 
-			sort.Stable(repo.EventList(events))
+			for idx, id := range calendarIdList[calIdx:] {
+				failed = append(failed, calendarError{calendarId: id, err: connect.NewError(connect.CodeDeadlineExceeded, errDeadlineExhausted)})
 
-			var slots []repo.Event
-			if freeSlots {
-				shifts, ok := shiftsByCalendarId[calId]
-				if ok {
-					for _, shift := range shifts {
-						var username string
-						profile, ok := svc.userByCalId.Get(calId)
-						if ok {
-							username = profile.User.Username
-						}
+				if diag != nil {
+					diag.Calendars[calIdx+idx].Error = errDeadlineExhausted.Error()
+				}
+			}
 
-						slog.Info("getting free slots for shift", "user", username, "shift-id", shift.UniqueId, "workshift-id", shift.WorkShiftId, "start", shift.From.AsTime(), "to", shift.To.AsTime(), "calendar-id", calId)
+			skipped = len(calendarIdList) - calIdx
+			slog.Warn("request deadline nearly exhausted, skipping remaining calendars", "skipped", skipped)
 
-						_, free, err := calculateFreeSlots(calId, shift.From.AsTime().Local(), shift.To.AsTime().Local(), events)
-						if err != nil {
-							slog.Error("failed to calculate free slots", "error", err, "calendar-id", calId)
-						} else {
-							slots = append(slots, free...)
-						}
+			break
+		}
+
+		if diff != nil && !isHolidayCalendar {
+			if etag, ok := diff.etag(ctx, svc, calId, !excludeOverlays); ok {
+				etags = append(etags, calId+"="+etag)
+
+				if diff.notModified(calId, etag) {
+					notModified = append(notModified, calId)
+					continue
+				}
+			}
+		}
+
+		if readMask.events {
+			// free slots are calculated with the events of each working window
+			// so there's no need to load the requested range if only roster
+			// based events are requested.
+			switch {
+			case isHolidayCalendar:
+				from, to := holidayRange(start, end, time.Now())
+
+				events, err = holidayEvents(ctx, svc.holidays, calId, country, from, to, i18n.FromContext(ctx))
+				if err != nil {
+					err = connect.NewError(connect.CodeUnavailable, err)
+
+					if !allowPartial {
+						return nil, err
 					}
-				} else {
-					slog.Warn("no shifts for the given calendar id", "calendar-id", calId)
+
+					slog.Error("failed to list public holidays, skipping calendar", "calendar-id", calId, "error", err)
+					failed = append(failed, calendarError{calendarId: calId, err: err})
+
+					if diag != nil {
+						diag.Calendars[calIdx].Error = err.Error()
+					}
+
+					continue
 				}
 
-				if onlyFreeSlots {
-					events = slots
-				} else {
+			case !withRoster || !onlyRosterEvents:
+				calCtx, cancel := budget.child(ctx, 1)
+				events, err = svc.events.ListEvents(calCtx, calId, opts...)
+				err = deadlineError(calCtx, err)
+				cancel()
+
+				if err != nil {
+					if !allowPartial || ctx.Err() != nil {
+						return nil, repoError(err)
+					}
+
+					slog.Error("failed to list events, skipping calendar", "calendar-id", calId, "error", err)
+					failed = append(failed, calendarError{calendarId: calId, err: err})
+
+					if diag != nil {
+						diag.Calendars[calIdx].Error = err.Error()
+						diag.Calendars[calIdx].Duration = time.Since(calStart).String()
+					}
+
+					continue
+				}
+
+				if excludeOverlays {
+					events = withoutOverlays(events)
+				}
+
+				if excludeAbsences {
+					events = withoutAbsences(events)
+				}
+
+				if format == richtext.FormatMarkdown {
+					events = markdownDescriptions(events)
+				}
+
+				events = localizeSummaries(events, i18n.FromContext(ctx))
+				events = svc.redactor().redact(events, req.Header().Get("X-Remote-User-ID"), i18n.FromContext(ctx))
+
+				sort.Stable(repo.EventList(events))
+			}
+
+			if withRoster && !isHolidayCalendar {
+				if onlyRosterEvents {
+					events = nil
+				}
+
+				if shifts, ok := shiftsByCalendarId[calId]; ok {
+					slotsStart := time.Now()
+
+					slotsCtx, cancel := budget.child(ctx, 1)
+					slots, bounds := svc.rosterEvents(slotsCtx, calId, shifts, shiftDefinitions, freeSlots, shiftBounds)
+					if slotsCtx.Err() == context.DeadlineExceeded {
+						slog.Warn("request deadline exceeded while calculating free slots", "calendar-id", calId)
+					}
+					cancel()
+
+					slotsTime += time.Since(slotsStart)
+
 					events = append(events, slots...)
+					events = append(events, bounds...)
+				} else {
+					slog.Warn("no shifts for the given calendar id", "calendar-id", calId)
 				}
 
 				sort.Stable(repo.ByStartTime(events))
 			}
 		}
 
+		if diag != nil {
+			diag.Calendars[calIdx].Events = len(events)
+			diag.Calendars[calIdx].Duration = time.Since(calStart).String()
+		}
+
+		// make sure we do not build pathological responses, check before
+		// converting the events.
+		totalEvents += len(events)
+		if maxEvents := svc.repo.Config.Limits.MaxEvents; maxEvents > 0 && totalEvents > maxEvents {
+			return nil, connect.NewError(connect.CodeResourceExhausted, fmt.Errorf("request matches more than %d events, please narrow the time range or query fewer calendars per request", maxEvents))
+		}
+
+		loaded = append(loaded, loadedCalendar{
+			id:      calId,
+			country: country,
+			holiday: isHolidayCalendar,
+			events:  events,
+		})
+	}
+
+	// a moved event may be returned by both calendars until the source
+	// calendar synced the move.
+	duplicates := dedupMovedEvents(loaded)
+	if diag != nil {
+		diag.Duplicates = duplicates
+	}
+
+	for _, cal := range loaded {
+		calId, events := cal.id, cal.events
+
 		calendarEvents := &calendarv1.CalendarEventList{
 			Events: make([]*calendarv1.CalendarEvent, len(events)),
 		}
 
-		if cal, ok := svc.calendarById.Get(calId); mustLoadCalendars && ok {
-			var userId string
-			if user, ok := svc.userByCalId.Get(calId); ok {
-				userId = user.User.Id
-			}
+		if readMask.calendars {
+			if cal.holiday {
+				calendarEvents.Calendar = holidayCalendar(calId, cal.country, i18n.FromContext(ctx))
+			} else if cal, ok := svc.calendarById.Get(calId); ok {
+				var userId string
+				if user, _, ok := svc.calendarOwner(calId); ok {
+					userId = user.User.Id
+				}
 
-			calendarEvents.Calendar = &calendarv1.Calendar{
-				Id:       cal.ID,
-				Name:     cal.Name,
-				Timezone: cal.Timezone,
-				Color:    cal.Color,
-				UserId:   userId,
+				calendarEvents.Calendar = &calendarv1.Calendar{
+					Id:       cal.ID,
+					Name:     cal.Name,
+					Timezone: cal.Timezone,
+					Color:    cal.Color,
+					UserId:   userId,
+				}
 			}
 		}
 
+		convert := readMask.eventToProto
+		if readMask.eventFields == nil {
+			// complete events may be served from the proto cache
+			convert = svc.protos.toProto
+		}
+
 		for idx, e := range events {
-			protoEvent, err := e.ToProto()
+			protoEvent, err := convert(e)
 			if err != nil {
 				return nil, err
 			}
 
 			calendarEvents.Events[idx] = protoEvent
+
+			if e.Status != "" {
+				statuses = append(statuses, calId+" "+e.ID+" "+e.Status)
+			}
+
+			if withNotes && !svc.redactor().hidesDetails(e, req.Header().Get("X-Remote-User-ID")) {
+				if note, ok := svc.notes.get(calId, e.ID); ok {
+					notes = append(notes, noteHeaderValue(note))
+				}
+			}
 		}
 
 		// do not add empty messages
 		if calendarEvents.Calendar != nil || len(calendarEvents.Events) > 0 {
 			response.Results = append(response.Results, calendarEvents)
+
+			if svc.hasDisabledOwner(calId) {
+				disabledCalendars = append(disabledCalendars, calId)
+			}
 		}
 	}
 
+	if len(failed) > 0 && len(failed) == len(calendarIdList) {
+		return nil, failed[0].err
+	}
+
 	// make sure we don't include any values that weren't requested
-	fmutils.Filter(response, readMask)
+	fmutils.Filter(response, readMask.paths)
 
-	return connect.NewResponse(response), nil
-}
+	res := connect.NewResponse(response)
+	for _, f := range failed {
+		res.Header().Add(calendarErrorHeader, f.String())
+	}
 
-func (svc *CalendarService) fetchRoster(ctx context.Context, start, end time.Time) (map[string][]*rosterv1.PlannedShift, error) {
-	// fetch all rosters of the configured type for the whole time range
-	// we use consuldiscover here
-	disc, err := consuldiscover.NewFromEnv()
-	if err != nil {
-		return nil, fmt.Errorf("failed to get consul discovery client: %w", err)
+	for _, etag := range etags {
+		res.Header().Add(calendarETagHeader, etag)
 	}
 
-	rosterClient, err := wellknown.RosterService.Create(ctx, disc)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get roster service client: %w", err)
+	for _, id := range notModified {
+		res.Header().Add(notModifiedHeader, id)
 	}
 
-	shiftClient, err := wellknown.WorkShiftService.Create(ctx, disc)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get workshift service client: %w", err)
+	for _, status := range statuses {
+		res.Header().Add(eventStatusResultHeader, status)
 	}
 
-	// TODO(ppacher): perform the following calles in parallel
+	for _, note := range notes {
+		res.Header().Add(eventNoteHeader, note)
+	}
 
-	res, err := rosterClient.GetWorkingStaff2(ctx, connect.NewRequest(&rosterv1.GetWorkingStaffRequest2{
-		Query: &rosterv1.GetWorkingStaffRequest2_TimeRange{
-			TimeRange: commonv1.NewTimeRange(start, end),
-		},
-		RosterTypeName: svc.repo.Config.FreeSlots.RosterTypeName,
-	}))
+	for _, source := range unresolved {
+		res.Header().Add(unresolvedSourceHeader, source)
+	}
 
-	if err != nil {
-		return nil, fmt.Errorf("failed to retrieve working staff: %w", err)
+	for _, id := range disabledCalendars {
+		res.Header().Add(disabledCalendarHeader, id)
 	}
 
-	// load shift definitions as well
-	shiftDefRes, err := shiftClient.ListWorkShifts(ctx, connect.NewRequest(&rosterv1.ListWorkShiftsRequest{}))
-	if err != nil {
-		return nil, fmt.Errorf("failed to get work shift definitions: %w", err)
+	for _, id := range calendarIdList {
+		if until, ok := trace.Truncated(id); ok {
+			res.Header().Add(eventsTruncatedHeader, id+" "+until.Format(time.RFC3339))
+		}
 	}
 
-	// create a lookup map for the shift definitions
-	lm := data.IndexSlice(shiftDefRes.Msg.WorkShifts, func(item *rosterv1.WorkShift) string {
-		return item.Id
-	})
+	if skipped > 0 {
+		setWarning(res.Header(), fmt.Sprintf("deadline exceeded, %d of %d calendars have not been queried", skipped, len(calendarIdList)))
+	}
 
-	shifts := make(map[string][]*rosterv1.PlannedShift, len(res.Msg.CurrentShifts))
-	for _, s := range res.Msg.CurrentShifts {
-		def, ok := lm[s.WorkShiftId]
-		if !ok {
-			slog.Warn("failed to get workshift definition", "workshift-id", s.WorkShiftId)
-			continue
+	if len(failed) > skipped {
+		setWarning(res.Header(), fmt.Sprintf("failed to load %d of %d calendars", len(failed)-skipped, len(calendarIdList)))
+	}
+
+	if diag != nil {
+		if withRoster && freeSlots {
+			diag.Timings.FreeSlots = slotsTime.String()
 		}
 
-		// skip on-call shifts
-		if data.ElemInBothSlices(def.Tags, svc.repo.Config.FreeSlots.IgnoreShiftTags) {
-			continue
+		blob, err := diag.finish(trace, eventsStart)
+		if err != nil {
+			slog.Error("failed to encode query diagnostics", "error", err)
+		} else {
+			res.Header().Set(queryDiagnosticsHeader, blob)
+		}
+	}
+
+	return res, nil
+}
+
+// calendarError is a failure to list the events of a single calendar.
+type calendarError struct {
+	calendarId string
+	err        error
+}
+
+func (ce calendarError) String() string {
+	msg := ce.err.Error()
+
+	var connectErr *connect.Error
+	if errors.As(ce.err, &connectErr) {
+		msg = connectErr.Message()
+	}
+
+	return fmt.Sprintf("%s %s %q", ce.calendarId, connect.CodeOf(ce.err), msg)
+}
+
+// rosterEvents returns the free slots and/or the shift boundary events for the
+// given shifts of calId.
+func (svc *CalendarService) rosterEvents(ctx context.Context, calId string, shifts []*rosterv1.PlannedShift, definitions map[string]*rosterv1.WorkShift, freeSlots, shiftBounds bool) (slots []repo.Event, bounds []repo.Event) {
+	var username, userId string
+	if profile, ok := svc.userByCalId.Get(calId); ok {
+		username = profile.User.Username
+		userId = profile.User.Id
+	}
+
+	shiftInfo := func(shift *rosterv1.PlannedShift) repo.FreeSlotInfo {
+		info := repo.FreeSlotInfo{
+			UserID:      userId,
+			ShiftID:     shift.UniqueId,
+			WorkShiftID: shift.WorkShiftId,
+		}
+
+		if def, ok := definitions[shift.WorkShiftId]; ok {
+			info.ShiftName = def.DisplayName
+			if info.ShiftName == "" {
+				info.ShiftName = def.Name
+			}
+		}
+
+		return info
+	}
+
+	// merge overlapping and adjacent shifts so free slots are not
+	// split at shift boundaries.
+	windows := mergeShifts(shifts)
+
+	if shiftBounds {
+		bounds = shiftBoundEvents(calId, windows, shiftInfo)
+	}
+
+	if !freeSlots {
+		return nil, bounds
+	}
+
+	slog.Info("getting free slots for working windows", "user", username, "shifts", len(shifts), "windows", len(windows), "calendar-id", calId)
+
+	var lister eventLister = svc.events
+	if tags := svc.repo.Config.FreeSlots.IgnoreEventTags; len(tags) > 0 {
+		lister = ignoreTagsLister{eventLister: lister, tags: repo.NormalizeTags(tags)}
+	}
+
+	if b := svc.repo.Config.FreeSlots.Buffers; len(b) > 0 {
+		lister = bufferLister{eventLister: lister, buffers: b}
+	}
+
+	slots, failed := freeSlotsForWindows(ctx, lister, calId, windows, svc.repo.Config.FreeSlots.OpenEndDuration.AsDuration(), shiftInfo)
+
+	for _, window := range failed {
+		slog.Warn("free slots missing for working window", "user", username, "calendar-id", calId, "date", window.timeRange[0].Format("2006-01-02"))
+	}
+
+	if cfg := svc.repo.Config.FreeSlots; cfg.SkipHolidays {
+		loc := time.Local
+		if cal, ok := svc.calendarById.Get(calId); ok && cal.Location != nil {
+			loc = cal.Location
 		}
 
-		k := s.From.AsTime().Format("2006-01-02")
-		shifts[k] = append(shifts[k], s)
+		slots = suppressHolidaySlots(ctx, svc.holidays, svc.repo.Config.DefaultCountry, loc, cfg.HolidayTypes, slots)
 	}
 
-	return shifts, nil
+	return slots, bounds
+}
+
+// fetchRoster returns all planned shifts between start and end grouped by date
+// together with a lookup map for the work-shift definitions.
+func (svc *CalendarService) fetchRoster(ctx context.Context, start, end time.Time) (map[string][]*rosterv1.PlannedShift, map[string]*rosterv1.WorkShift, error) {
+	return svc.roster.Fetch(ctx, start, end)
+}
+
+// RosterState returns the state of the roster circuit breaker.
+func (svc *CalendarService) RosterState() RosterState {
+	return svc.roster.State()
 }
 
 func (svc *CalendarService) CreateEvent(ctx context.Context, req *connect.Request[calendarv1.CreateEventRequest]) (*connect.Response[calendarv1.CreateEventResponse], error) {
@@ -437,8 +1047,34 @@ func (svc *CalendarService) CreateEvent(ctx context.Context, req *connect.Reques
 		Summary:     req.Msg.Name,
 		Description: req.Msg.Description,
 		StartTime:   req.Msg.Start.AsTime(),
+		Tags:        repo.NormalizeTags(req.Header().Values(eventTagHeader)),
 	}
 
+	if err := repo.ValidateTags(m.Tags); err != nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, err)
+	}
+
+	channel, ok, err := svc.channels.forRequest(req.Header())
+	if err != nil {
+		return nil, err
+	}
+
+	if ok {
+		m.Channel = channel.Name
+		m.Summary = svc.channels.summary(channel.Name, m.Summary)
+	}
+
+	m.Visibility, err = visibilityFromHeader(req.Header())
+	if err != nil {
+		return nil, err
+	}
+
+	format, err := descriptionFormat(req.Header())
+	if err != nil {
+		return nil, err
+	}
+	m.Description, m.DescriptionFormat = storedDescription(m.Description, format)
+
 	var duration time.Duration
 	if end := req.Msg.End; end != nil {
 		if err := end.CheckValid(); err != nil {
@@ -454,6 +1090,23 @@ func (svc *CalendarService) CreateEvent(ctx context.Context, req *connect.Reques
 		m.FullDayEvent = true
 	}
 
+	if err := svc.checkWritable(m.CalendarID); err != nil {
+		return nil, err
+	}
+
+	if err := svc.checkEventLimits(ctx, req.Header(), m); err != nil {
+		return nil, err
+	}
+
+	// full-day events are never booked from free slots so they are not
+	// checked against slot locks.
+	lockToken := req.Header().Get(slotLockHeader)
+	if m.EndTime != nil {
+		if err := svc.slotLocks.check(m.CalendarID, m.StartTime, *m.EndTime, lockToken); err != nil {
+			return nil, err
+		}
+	}
+
 	if extra := req.Msg.ExtraData; extra != nil {
 		var err error
 
@@ -463,24 +1116,51 @@ func (svc *CalendarService) CreateEvent(ctx context.Context, req *connect.Reques
 		}
 	}
 
-	newEvent, err := svc.repo.CreateEvent(ctx, m.CalendarID, m.Summary, m.Description, m.StartTime, duration, m.Data)
+	warning, err := svc.checkCustomerDoubleBooking(ctx, m)
 	if err != nil {
 		return nil, err
 	}
 
+	bufferWarning, err := svc.checkBufferConflicts(ctx, m)
+	if err != nil {
+		return nil, err
+	}
+
+	m.ColorID = svc.colors.colorFor(m)
+
+	newEvent, err := svc.repo.CreateEvent(ctx, m.CalendarID, m.Summary, m.Description, m.StartTime, duration, m.Data,
+		repo.WithTags(m.Tags...),
+		repo.WithColor(m.ColorID),
+		repo.WithDescriptionFormat(m.DescriptionFormat),
+		repo.WithSourceChannel(m.Channel),
+		repo.WithVisibility(m.Visibility),
+	)
+	if err != nil {
+		return nil, repoError(err)
+	}
+
+	// the slot has been booked so the lock is not needed anymore
+	if lockToken != "" {
+		svc.slotLocks.release(lockToken)
+	}
+
 	protoEvent, err := newEvent.ToProto()
 	if err != nil {
 		return nil, err
 	}
 
-	return connect.NewResponse(&calendarv1.CreateEventResponse{
+	res := connect.NewResponse(&calendarv1.CreateEventResponse{
 		Event: protoEvent,
-	}), nil
+	})
+	setWarning(res.Header(), warning)
+	setWarning(res.Header(), bufferWarning)
+
+	return res, nil
 }
 
 func (svc *CalendarService) convertExtraData(_ context.Context, extra *anypb.Any) (*repo.StructuredEvent, error) {
-	switch extra.TypeUrl {
-	case (string(new(calendarv1.CustomerAnnotation).ProtoReflect().Descriptor().FullName())):
+	switch {
+	case extra.MessageIs(new(calendarv1.CustomerAnnotation)):
 		var msg calendarv1.CustomerAnnotation
 
 		if err := extra.UnmarshalTo(&msg); err != nil {
@@ -502,9 +1182,21 @@ func (svc *CalendarService) convertExtraData(_ context.Context, extra *anypb.Any
 func (svc *CalendarService) UpdateEvent(ctx context.Context, req *connect.Request[calendarv1.UpdateEventRequest]) (*connect.Response[calendarv1.UpdateEventResponse], error) {
 	msg := req.Msg
 
+	if err := pseudoEventError(msg.EventId); err != nil {
+		return nil, err
+	}
+
+	if isOverlayEvent(msg.EventId) {
+		return nil, overlayReadOnlyError()
+	}
+
+	if err := svc.checkWritable(msg.CalendarId); err != nil {
+		return nil, err
+	}
+
 	evt, err := svc.repo.LoadEvent(ctx, msg.CalendarId, msg.EventId, true)
 	if err != nil {
-		return nil, err
+		return nil, repoError(err)
 	}
 
 	paths := []string{
@@ -519,6 +1211,8 @@ func (svc *CalendarService) UpdateEvent(ctx context.Context, req *connect.Reques
 		paths = um
 	}
 
+	wasFullDay := evt.FullDayEvent
+
 	for _, p := range paths {
 		switch p {
 		case "name":
@@ -526,10 +1220,17 @@ func (svc *CalendarService) UpdateEvent(ctx context.Context, req *connect.Reques
 				return nil, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("name field is required"))
 			}
 
-			evt.Summary = msg.Name
+			// events created through a channel keep the prefix and
+			// suffix of the channel.
+			evt.Summary = svc.channels.summary(evt.Channel, msg.Name)
 
 		case "description":
-			evt.Description = msg.Description
+			format, err := descriptionFormat(req.Header())
+			if err != nil {
+				return nil, err
+			}
+
+			evt.Description, evt.DescriptionFormat = storedDescription(msg.Description, format)
 
 		case "start":
 			if err := msg.Start.CheckValid(); err != nil {
@@ -562,18 +1263,72 @@ func (svc *CalendarService) UpdateEvent(ctx context.Context, req *connect.Reques
 		case "extra_data":
 			if extra := msg.ExtraData; extra != nil {
 				evt.Data, err = svc.convertExtraData(ctx, msg.ExtraData)
+				if err != nil {
+					return nil, err
+				}
 			} else {
 				evt.Data = nil
 			}
 
+		case "tags":
+			// tags are not part of the default paths as they are set
+			// using the eventTagHeader.
+			evt.Tags = repo.NormalizeTags(req.Header().Values(eventTagHeader))
+
+			if err := repo.ValidateTags(evt.Tags); err != nil {
+				return nil, connect.NewError(connect.CodeInvalidArgument, err)
+			}
+
+		case "visibility":
+			// the visibility is not part of the default paths as it is set
+			// using the eventVisibilityHeader.
+			evt.Visibility, err = visibilityFromHeader(req.Header())
+			if err != nil {
+				return nil, err
+			}
+
+		case fullDayPath:
+			// applied once start and end are known, see applyFullDay.
+
 		default:
 			return nil, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("invalid update_mask path %q", p))
 		}
 	}
 
+	if err := applyFullDay(evt, wasFullDay, paths, req.Header()); err != nil {
+		return nil, err
+	}
+
+	timeChanged := slices.Contains(paths, "start") || slices.Contains(paths, "end") || slices.Contains(paths, fullDayPath)
+
+	if timeChanged {
+		if err := svc.checkEventLimits(ctx, req.Header(), *evt); err != nil {
+			return nil, err
+		}
+	}
+
+	// only check for double bookings if the time or the customer changed
+	var warning string
+	if timeChanged || slices.Contains(paths, "extra_data") {
+		warning, err = svc.checkCustomerDoubleBooking(ctx, *evt)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var bufferWarning string
+	if slices.Contains(paths, "start") {
+		bufferWarning, err = svc.checkBufferConflicts(ctx, *evt)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	evt.ColorID = svc.colors.colorFor(*evt)
+
 	updatedEvent, err := svc.repo.UpdateEvent(ctx, *evt)
 	if err != nil {
-		return nil, err
+		return nil, repoError(err)
 	}
 
 	protoEvent, err := updatedEvent.ToProto()
@@ -581,12 +1336,24 @@ func (svc *CalendarService) UpdateEvent(ctx context.Context, req *connect.Reques
 		return nil, err
 	}
 
-	return connect.NewResponse(&calendarv1.UpdateEventResponse{
+	res := connect.NewResponse(&calendarv1.UpdateEventResponse{
 		Event: protoEvent,
-	}), nil
+	})
+	setWarning(res.Header(), warning)
+	setWarning(res.Header(), bufferWarning)
+
+	return res, nil
 }
 
 func (svc *CalendarService) MoveEvent(ctx context.Context, req *connect.Request[calendarv1.MoveEventRequest]) (*connect.Response[calendarv1.MoveEventResponse], error) {
+	if err := pseudoEventError(req.Msg.EventId); err != nil {
+		return nil, err
+	}
+
+	if isOverlayEvent(req.Msg.EventId) {
+		return nil, overlayReadOnlyError()
+	}
+
 	originCalendarID := req.Msg.GetSourceCalendarId()
 	if originCalendarID == "" {
 		var err error
@@ -605,9 +1372,66 @@ func (svc *CalendarService) MoveEvent(ctx context.Context, req *connect.Request[
 		}
 	}
 
-	event, err := svc.repo.MoveEvent(ctx, originCalendarID, req.Msg.EventId, targetCalendarID)
+	for _, id := range []string{originCalendarID, targetCalendarID} {
+		if err := svc.checkWritable(id); err != nil {
+			return nil, err
+		}
+	}
+
+	shift := req.Header().Get(moveStartHeader) != "" || req.Header().Get(moveEndHeader) != ""
+
+	var (
+		opts          []repo.MoveOption
+		warning       string
+		bufferWarning string
+	)
+
+	// events outside of the limits, like those created before the limits
+	// were configured, are rejected even if the move keeps their time.
+	if shift || newEventLimits(svc.repo.Config).enabled() {
+		evt, err := svc.repo.LoadEvent(ctx, originCalendarID, req.Msg.EventId, shift)
+		if err != nil {
+			return nil, repoError(err)
+		}
+
+		evt.CalendarID = targetCalendarID
+
+		if shift {
+			if err := applyMoveTime(req.Header(), evt); err != nil {
+				return nil, err
+			}
+
+			opts = append(opts, repo.WithEventTime(evt.StartTime, evt.EndTime))
+		}
+
+		if err := svc.checkEventLimits(ctx, req.Header(), *evt); err != nil {
+			return nil, err
+		}
+
+		// the event is rescheduled in the target calendar so it's checked
+		// like an updated event.
+		if shift {
+			warning, err = svc.checkCustomerDoubleBooking(ctx, *evt)
+			if err != nil {
+				return nil, err
+			}
+
+			bufferWarning, err = svc.checkBufferConflicts(ctx, *evt)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	event, err := svc.repo.MoveEvent(ctx, originCalendarID, req.Msg.EventId, targetCalendarID, opts...)
 	if err != nil {
-		return nil, err
+		return nil, repoError(err)
+	}
+
+	// the event has been moved already so a failure to move the note is
+	// only logged.
+	if err := svc.notes.move(originCalendarID, req.Msg.EventId, event.CalendarID, event.ID); err != nil {
+		slog.Error("failed to move event note", "calendar", originCalendarID, "event", req.Msg.EventId, "error", err)
 	}
 
 	protoEvent, err := event.ToProto()
@@ -615,31 +1439,214 @@ func (svc *CalendarService) MoveEvent(ctx context.Context, req *connect.Request[
 		return nil, err
 	}
 
-	return connect.NewResponse(&calendarv1.MoveEventResponse{
+	res := connect.NewResponse(&calendarv1.MoveEventResponse{
 		Event: protoEvent,
-	}), nil
+	})
+	setWarning(res.Header(), warning)
+	setWarning(res.Header(), bufferWarning)
+
+	return res, nil
 }
 
-func (svc *CalendarService) resolveUserCalendar(ctx context.Context, id string) (string, error) {
-	user, ok := svc.byUserId.Get(id)
+// applyMoveTime sets the time of evt from the moveStartHeader and
+// moveEndHeader. If only the start is set the event keeps its duration.
+func applyMoveTime(header http.Header, evt *repo.Event) error {
+	if evt.FullDayEvent {
+		return connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("the time of full-day events cannot be changed when moving"))
+	}
+
+	start := evt.StartTime
+	end := evt.EndTime
+
+	if v := header.Get(moveStartHeader); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("invalid value for %s: %w", moveStartHeader, err))
+		}
+
+		if end != nil {
+			shifted := end.Add(t.Sub(start))
+			end = &shifted
+		}
+
+		start = t
+	}
+
+	if v := header.Get(moveEndHeader); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("invalid value for %s: %w", moveEndHeader, err))
+		}
 
-	if !ok {
-		return "", fmt.Errorf("failed to get user profile for id %q", id)
+		end = &t
+	}
+
+	if end != nil && !end.After(start) {
+		return connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("the end of the event must be after its start"))
+	}
+
+	evt.StartTime = start
+	evt.EndTime = end
+
+	return nil
+}
+
+// excludeCalendars removes the calendars and user calendars listed in the
+// exclude headers from calendarIds. ListEventsRequest does not yet have
+// fields for exclusions. Calendars in explicit are never removed. If implicit
+// is set hidden calendars are removed as well.
+func (svc *CalendarService) excludeCalendars(ctx context.Context, calendarIds, explicit map[string]struct{}, implicit bool, header http.Header) {
+	excludes := make(map[string]struct{})
+	for _, id := range header.Values(excludeCalendarHeader) {
+		excludes[id] = struct{}{}
+	}
+
+	for _, id := range header.Values(excludeUserHeader) {
+		if user, ok := svc.byUserId.Get(id); ok {
+			if calId := extractCalendarId(ctx, user); calId != "" {
+				excludes[calId] = struct{}{}
+			}
+		}
+	}
+
+	includeDisabled := svc.includeDisabledUsers(header)
+
+	for id := range calendarIds {
+		if _, ok := explicit[id]; ok {
+			continue
+		}
+
+		_, excluded := excludes[id]
+		if !excluded && implicit {
+			cal, ok := svc.calendarById.Get(id)
+			excluded = ok && cal.Hidden
+		}
+
+		// calendars of former employees are only part of the implicit
+		// sources if requested.
+		if !excluded && implicit && !includeDisabled {
+			excluded = svc.hasDisabledOwner(id)
+		}
+
+		if excluded {
+			delete(calendarIds, id)
+		}
+	}
+}
+
+// checkWritable returns a PermissionDenied error if the calendar is known
+// to be read-only. Unknown calendars are left to the backend.
+func (svc *CalendarService) checkWritable(calendarID string) error {
+	if _, ok := holidayCountry(calendarID, ""); ok {
+		return repoError(fmt.Errorf("%w: calendar %q is a virtual holiday calendar", repo.ErrReadOnly, calendarID))
+	}
+
+	if cal, ok := svc.calendarById.Get(calendarID); ok && cal.Readonly {
+		return repoError(fmt.Errorf("%w: calendar %q has access role %q", repo.ErrReadOnly, calendarID, cal.AccessRole))
+	}
+
+	return nil
+}
+
+func (svc *CalendarService) resolveUserCalendar(ctx context.Context, id string) (string, error) {
+	user, err := svc.lookupUser(ctx, id)
+	if err != nil {
+		return "", err
 	}
 
 	if cal := extractCalendarId(ctx, user); cal != "" {
 		return cal, nil
 	}
 
-	return "", fmt.Errorf("no calendar associated with user %q", id)
+	return "", connect.NewError(connect.CodeNotFound, fmt.Errorf("no calendar associated with user %q", id))
 }
 
 func (svc *CalendarService) DeleteEvent(ctx context.Context, req *connect.Request[calendarv1.DeleteEventRequest]) (*connect.Response[calendarv1.DeleteEventResponse], error) {
-	if err := svc.repo.DeleteEvent(ctx, req.Msg.CalendarId, req.Msg.EventId); err != nil {
+	if err := pseudoEventError(req.Msg.EventId); err != nil {
+		return nil, err
+	}
+
+	if isOverlayEvent(req.Msg.EventId) {
+		return nil, overlayReadOnlyError()
+	}
+
+	if err := svc.checkWritable(req.Msg.CalendarId); err != nil {
+		return nil, err
+	}
+
+	allowMissing, _ := strconv.ParseBool(req.Header().Get(allowMissingHeader))
+
+	res := connect.NewResponse(new(calendarv1.DeleteEventResponse))
+
+	event, err := svc.repo.LoadEvent(ctx, req.Msg.CalendarId, req.Msg.EventId, false)
+	if err != nil {
+		err = repoError(err)
+		if allowMissing && connect.CodeOf(err) == connect.CodeNotFound {
+			return res, nil
+		}
+
+		return nil, err
+	}
+
+	if err := repoError(svc.repo.DeleteEvent(ctx, req.Msg.CalendarId, req.Msg.EventId)); err != nil {
+		// the event may have been deleted concurrently
+		if allowMissing && connect.CodeOf(err) == connect.CodeNotFound {
+			return res, nil
+		}
+
 		return nil, err
 	}
 
-	return connect.NewResponse(new(calendarv1.DeleteEventResponse)), nil
+	if err := svc.notes.delete(req.Msg.CalendarId, req.Msg.EventId); err != nil {
+		slog.Error("failed to delete event note", "calendar", req.Msg.CalendarId, "event", req.Msg.EventId, "error", err)
+	}
+
+	if blob, err := deletedEventBlob(event, req.Header()); err != nil {
+		slog.Error("failed to encode deleted event", "calendar", req.Msg.CalendarId, "event", req.Msg.EventId, "error", err)
+	} else if len(blob) <= maxDeletedEventHeader {
+		res.Header().Set(deletedEventHeader, blob)
+	}
+
+	return res, nil
+}
+
+// deletedEventBlob returns event as protojson for the deletedEventHeader.
+// Headers are not covered by the privacy interceptor so the privacy rules
+// for the caller are applied here.
+func deletedEventBlob(event *repo.Event, header http.Header) (string, error) {
+	pb, err := event.ToProto()
+	if err != nil {
+		return "", err
+	}
+
+	if err := privacy.FilterAllowedFields(pb, header.Get("X-Remote-User-ID"), header.Values("X-Remote-Role")); err != nil {
+		return "", err
+	}
+
+	blob, err := protojson.Marshal(pb)
+	if err != nil {
+		return "", err
+	}
+
+	return string(blob), nil
+}
+
+// prewarmIndexer is a cache.Indexer that prewarms the event caches
+// of all calendars assigned to user profiles.
+type prewarmIndexer struct {
+	ctx  context.Context
+	repo repo.Service
+}
+
+func (p prewarmIndexer) Update(profiles []*idmv1.Profile) {
+	ids := make([]string, 0, len(profiles))
+	for _, profile := range profiles {
+		if calId := extractCalendarId(p.ctx, profile); calId != "" {
+			ids = append(ids, calId)
+		}
+	}
+
+	p.repo.Prewarm(ids...)
 }
 
 func extractCalendarId(ctx context.Context, profile *idmv1.Profile) string {
@@ -649,7 +1656,7 @@ func extractCalendarId(ctx context.Context, profile *idmv1.Profile) string {
 
 	extrapb := profile.User.Extra
 	if extrapb != nil {
-		calVal := extrapb.Fields["calendarID"]
+		calVal := extrapb.Fields[calendarIDExtraKey]
 		if calVal != nil {
 			switch v := calVal.Kind.(type) {
 			case *structpb.Value_StringValue:
@@ -0,0 +1,301 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	calendarv1 "github.com/tierklinik-dobersberg/apis/gen/go/tkd/calendar/v1"
+	idmv1 "github.com/tierklinik-dobersberg/apis/gen/go/tkd/idm/v1"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/app"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/cache"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/config"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/repo"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func newReadonlyTestService(t *testing.T) (*CalendarService, *bookingRepo) {
+	t.Helper()
+
+	calendars := cache.NewCache("calendars", time.Minute, cache.LoaderFunc[repo.Calendar](func(ctx context.Context) ([]repo.Calendar, error) {
+		return []repo.Calendar{
+			{ID: "writer", AccessRole: "writer"},
+			{ID: "reader", AccessRole: "reader", Readonly: true},
+		}, nil
+	}))
+
+	fake := new(bookingRepo)
+
+	svc := &CalendarService{
+		repo:      &app.App{Service: fake},
+		calendars: calendars,
+		slotLocks: newSlotLocks(time.Minute),
+		calendarById: cache.CreateIndex(calendars, func(c repo.Calendar) (string, bool) {
+			return c.ID, true
+		}),
+		userByCalId: cache.NewIndex(func(p *idmv1.Profile) (string, bool) {
+			return "", false
+		}),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	calendars.Start(ctx)
+	require.Eventually(t, func() bool {
+		_, ok := svc.calendarById.Get("reader")
+		return ok
+	}, time.Second, 10*time.Millisecond)
+
+	return svc, fake
+}
+
+func Test_ListCalendars_WritableOnly(t *testing.T) {
+	svc, _ := newReadonlyTestService(t)
+
+	res, err := svc.ListCalendars(context.Background(), connect.NewRequest(&calendarv1.ListCalendarsRequest{}))
+	require.NoError(t, err)
+	assert.Len(t, res.Msg.Calendars, 2)
+
+	req := connect.NewRequest(&calendarv1.ListCalendarsRequest{})
+	req.Header().Set(writableOnlyHeader, "true")
+
+	res, err = svc.ListCalendars(context.Background(), req)
+	require.NoError(t, err)
+	require.Len(t, res.Msg.Calendars, 1)
+	assert.Equal(t, "writer", res.Msg.Calendars[0].Id)
+}
+
+func Test_CreateEvent_ReadonlyCalendar(t *testing.T) {
+	svc, fake := newReadonlyTestService(t)
+
+	start := time.Date(2024, time.June, 3, 10, 0, 0, 0, time.UTC)
+	req := func(calendarID string) *connect.Request[calendarv1.CreateEventRequest] {
+		return connect.NewRequest(&calendarv1.CreateEventRequest{
+			CalendarId: calendarID,
+			Name:       "Bello",
+			Start:      timestamppb.New(start),
+			End:        timestamppb.New(start.Add(time.Hour)),
+		})
+	}
+
+	_, err := svc.CreateEvent(context.Background(), req("reader"))
+	assert.Equal(t, connect.CodePermissionDenied, connect.CodeOf(err))
+	assert.Empty(t, fake.created)
+
+	_, err = svc.CreateEvent(context.Background(), req("writer"))
+	require.NoError(t, err)
+	assert.Len(t, fake.created, 1)
+}
+
+func Test_MutatePseudoEvents(t *testing.T) {
+	svc, _ := newReadonlyTestService(t)
+	ctx := context.Background()
+
+	for _, id := range []string{"free-slot-3", "free-slot-shift-1-end", "shift-1"} {
+		_, err := svc.UpdateEvent(ctx, connect.NewRequest(&calendarv1.UpdateEventRequest{
+			CalendarId: "writer",
+			EventId:    id,
+		}))
+		assert.Equal(t, connect.CodeInvalidArgument, connect.CodeOf(err), id)
+
+		_, err = svc.DeleteEvent(ctx, connect.NewRequest(&calendarv1.DeleteEventRequest{
+			CalendarId: "writer",
+			EventId:    id,
+		}))
+		assert.Equal(t, connect.CodeInvalidArgument, connect.CodeOf(err), id)
+
+		_, err = svc.MoveEvent(ctx, connect.NewRequest(&calendarv1.MoveEventRequest{
+			EventId: id,
+			Source: &calendarv1.MoveEventRequest_SourceCalendarId{
+				SourceCalendarId: "writer",
+			},
+			Target: &calendarv1.MoveEventRequest_TargetCalendarId{
+				TargetCalendarId: "writer",
+			},
+		}))
+		assert.Equal(t, connect.CodeInvalidArgument, connect.CodeOf(err), id)
+	}
+}
+
+func Test_ExcludeCalendars(t *testing.T) {
+	calendarById := cache.NewIndex(func(c repo.Calendar) (string, bool) {
+		return c.ID, true
+	})
+	calendarById.Update([]repo.Calendar{{ID: "vet-1"}, {ID: "vet-2"}, {ID: "waiting-room", Hidden: true}, {ID: "hr"}})
+
+	byUserId := cache.NewIndex(func(p *idmv1.Profile) (string, bool) {
+		return p.User.Id, true
+	})
+	byUserId.Update([]*idmv1.Profile{
+		{
+			User: &idmv1.User{
+				Id: "maier",
+				Extra: &structpb.Struct{Fields: map[string]*structpb.Value{
+					"calendarID": structpb.NewStringValue("vet-2"),
+				}},
+			},
+		},
+	})
+
+	svc := &CalendarService{
+		calendarById: calendarById,
+		byUserId:     byUserId,
+	}
+
+	ids := func(list ...string) map[string]struct{} {
+		m := make(map[string]struct{})
+		for _, id := range list {
+			m[id] = struct{}{}
+		}
+
+		return m
+	}
+
+	header := http.Header{}
+	header.Add(excludeCalendarHeader, "hr")
+	header.Add(excludeUserHeader, "maier")
+
+	// all calendars, hidden calendars are excluded implicitly
+	calendarIds := ids("vet-1", "vet-2", "waiting-room", "hr")
+	svc.excludeCalendars(context.Background(), calendarIds, nil, true, header)
+	assert.Equal(t, ids("vet-1"), calendarIds)
+
+	// explicitly requested calendars win over exclusions
+	calendarIds = ids("vet-1", "vet-2", "waiting-room", "hr")
+	svc.excludeCalendars(context.Background(), calendarIds, ids("hr", "waiting-room"), false, header)
+	assert.Equal(t, ids("vet-1", "waiting-room", "hr"), calendarIds)
+}
+
+// searchRecorder is an eventLister that records the search options of the
+// last ListEvents call.
+type searchRecorder struct {
+	opts repo.EventSearchOptions
+}
+
+func (s *searchRecorder) ListEvents(_ context.Context, _ string, opts ...repo.SearchOption) ([]repo.Event, error) {
+	s.opts = repo.EventSearchOptions{}
+	for _, opt := range opts {
+		opt(&s.opts)
+	}
+
+	return nil, nil
+}
+
+func Test_ListEvents_Query(t *testing.T) {
+	svc, _ := newMaskTestService(1, 0)
+
+	recorder := new(searchRecorder)
+	svc.events = recorder
+
+	req := listEventsRequest(1)
+	req.Header().Set(eventQueryHeader, " huber ")
+
+	_, err := svc.ListEvents(context.Background(), req)
+	require.NoError(t, err)
+	require.NotNil(t, recorder.opts.Query)
+	assert.Equal(t, "huber", *recorder.opts.Query)
+
+	_, err = svc.ListEvents(context.Background(), listEventsRequest(1))
+	require.NoError(t, err)
+	assert.Nil(t, recorder.opts.Query)
+}
+
+func Test_ListEvents_Resource(t *testing.T) {
+	svc, _ := newMaskTestService(1, 0)
+
+	recorder := new(searchRecorder)
+	svc.events = recorder
+
+	req := listEventsRequest(1)
+	req.Header().Set(eventResourceHeader, "OP 1")
+
+	_, err := svc.ListEvents(context.Background(), req)
+	require.NoError(t, err)
+	require.NotNil(t, recorder.opts.Resource)
+	assert.Equal(t, "OP 1", *recorder.opts.Resource)
+}
+
+func Test_CreateEvent_Tags(t *testing.T) {
+	svc, fake := newBookingTestService(t)
+
+	req := createEventRequest(t, "14:00", "15:00", "huber")
+	req.Header().Add(eventTagHeader, "Surgery")
+	req.Header().Add(eventTagHeader, " vaccination ")
+	req.Header().Add(eventTagHeader, "surgery")
+
+	_, err := svc.CreateEvent(context.Background(), req)
+	require.NoError(t, err)
+
+	require.Len(t, fake.created, 1)
+	assert.Equal(t, []string{"surgery", "vaccination"}, fake.created[0].Tags)
+
+	// tags must fit into a google extended property
+	req = createEventRequest(t, "16:00", "17:00", "huber")
+	req.Header().Add(eventTagHeader, strings.Repeat("x", repo.MaxTagsSize))
+
+	_, err = svc.CreateEvent(context.Background(), req)
+	require.Error(t, err)
+	assert.Equal(t, connect.CodeInvalidArgument, connect.CodeOf(err))
+	assert.Len(t, fake.created, 1)
+}
+
+func Test_CreateEvent_ColorRules(t *testing.T) {
+	svc, fake := newBookingTestService(t)
+	svc.colors = newColorRules([]config.ColorRule{
+		{Tag: "^surgery$", Color: "11"},
+	})
+
+	req := createEventRequest(t, "14:00", "15:00", "huber")
+	req.Header().Add(eventTagHeader, "surgery")
+
+	_, err := svc.CreateEvent(context.Background(), req)
+	require.NoError(t, err)
+
+	_, err = svc.CreateEvent(context.Background(), createEventRequest(t, "16:00", "17:00", "huber"))
+	require.NoError(t, err)
+
+	require.Len(t, fake.created, 2)
+	assert.Equal(t, "11", fake.created[0].ColorID)
+	assert.Empty(t, fake.created[1].ColorID)
+}
+
+func Test_CreateEvent_Description(t *testing.T) {
+	svc, fake := newBookingTestService(t)
+
+	req := createEventRequest(t, "14:00", "15:00", "huber")
+	req.Msg.Description = `<b onclick="alert(1)">Bello</b><script>alert(1)</script>`
+
+	_, err := svc.CreateEvent(context.Background(), req)
+	require.NoError(t, err)
+
+	req = createEventRequest(t, "16:00", "17:00", "huber")
+	req.Msg.Description = "**OP** morgen"
+	req.Header().Set(descriptionFormatHeader, "markdown")
+
+	_, err = svc.CreateEvent(context.Background(), req)
+	require.NoError(t, err)
+
+	require.Len(t, fake.created, 2)
+	assert.Equal(t, "<b>Bello</b>", fake.created[0].Description)
+	assert.Empty(t, fake.created[0].DescriptionFormat)
+	assert.Equal(t, "<p><strong>OP</strong> morgen</p>", fake.created[1].Description)
+	assert.Equal(t, "markdown", fake.created[1].DescriptionFormat)
+
+	// descriptions written as Markdown are returned as Markdown
+	events := markdownDescriptions(fake.created)
+	assert.Equal(t, "<b>Bello</b>", events[0].Description)
+	assert.Equal(t, "**OP** morgen", events[1].Description)
+
+	req = createEventRequest(t, "18:00", "19:00", "huber")
+	req.Header().Set(descriptionFormatHeader, "rtf")
+
+	_, err = svc.CreateEvent(context.Background(), req)
+	assert.Equal(t, connect.CodeInvalidArgument, connect.CodeOf(err))
+}
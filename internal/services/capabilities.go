@@ -0,0 +1,190 @@
+package services
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"sync"
+
+	"github.com/tierklinik-dobersberg/cis-cal/internal/config"
+)
+
+// capability describes whether an optional feature is supported by this
+// deployment.
+type capability struct {
+	Name    string            `json:"name"`
+	Enabled bool              `json:"enabled"`
+	Reason  string            `json:"reason,omitempty"`
+	Details map[string]string `json:"details,omitempty"`
+}
+
+// buildInfo describes the binary serving the request.
+type buildInfo struct {
+	Version   string `json:"version"`
+	Revision  string `json:"revision,omitempty"`
+	Time      string `json:"time,omitempty"`
+	Modified  bool   `json:"modified,omitempty"`
+	GoVersion string `json:"goVersion"`
+}
+
+type capabilitiesResponse struct {
+	Build        buildInfo        `json:"build"`
+	Capabilities []capability     `json:"capabilities"`
+	Maintenance  maintenanceState `json:"maintenance"`
+}
+
+var readBuildInfo = sync.OnceValue(func() buildInfo {
+	res := buildInfo{
+		Version: "(devel)",
+	}
+
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return res
+	}
+
+	res.GoVersion = info.GoVersion
+	if info.Main.Version != "" {
+		res.Version = info.Main.Version
+	}
+
+	for _, s := range info.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			res.Revision = s.Value
+		case "vcs.time":
+			res.Time = s.Value
+		case "vcs.modified":
+			res.Modified = s.Value == "true"
+		}
+	}
+
+	return res
+})
+
+// roleCapability reports a feature that is enabled by configuring the
+// roles that may use it.
+func roleCapability(name string, roles []string) capability {
+	c := capability{
+		Name:    name,
+		Enabled: len(roles) > 0,
+	}
+
+	if !c.Enabled {
+		c.Reason = "no roles configured"
+	}
+
+	return c
+}
+
+// staticCapabilities returns the capabilities that only depend on the
+// configuration and thus never change while the service is running.
+func staticCapabilities(cfg config.Config) []capability {
+	holidays := capability{
+		Name:    "holidays",
+		Enabled: true,
+		Details: map[string]string{
+			"country": cfg.DefaultCountry,
+		},
+	}
+
+	school := capability{
+		Name:    "school_holidays",
+		Enabled: len(cfg.SchoolHolidays) > 0,
+	}
+	if !school.Enabled {
+		school.Reason = "no school holidays configured"
+	}
+
+	conflicts := capability{
+		Name:    "conflict_check",
+		Enabled: cfg.Conflicts.Interval.AsDuration() > 0,
+	}
+	if !conflicts.Enabled {
+		conflicts.Reason = "no check interval configured"
+	}
+
+	bookings := roleCapability("booking_export", cfg.BookingExport.AllowedRoles)
+	if len(cfg.BookingExport.Services) == 0 {
+		bookings = capability{Name: bookings.Name, Reason: "no booking services configured"}
+	}
+
+	return []capability{
+		holidays,
+		school,
+		conflicts,
+		{Name: "waiting_room", Enabled: true},
+		{Name: "slot_locks", Enabled: true},
+		roleCapability("notes", cfg.Notes.AllowedRoles),
+		roleCapability("export", cfg.Export.AllowedRoles),
+		roleCapability("heatmap", cfg.Heatmap.AllowedRoles),
+		roleCapability("backup", cfg.Backup.AllowedRoles),
+		roleCapability("bulk_delete", cfg.BulkDelete.AllowedRoles),
+		roleCapability("webhooks", cfg.Webhooks.AllowedRoles),
+		bookings,
+		// resources and deleted events are only supported by deployments
+		// with a database backend, the Google Calendar backend has neither.
+		{Name: "resources", Reason: "no resource backend configured"},
+		{Name: "trash", Reason: "deleted events are not kept"},
+	}
+}
+
+// CapabilitiesHandler serves the optional features supported by this
+// deployment so the UI can hide what is not available instead of probing
+// for it:
+//
+//	GET /capabilities
+//
+// Everything but the free slots, which depend on the roster service being
+// reachable, and the maintenance mode is computed once when the handler is
+// created.
+type CapabilitiesHandler struct {
+	static      []capability
+	build       buildInfo
+	roster      func() RosterState
+	maintenance func() maintenanceState
+}
+
+// NewCapabilitiesHandler returns a new capabilities handler for svc.
+func NewCapabilitiesHandler(svc *CalendarService, maintenance *Maintenance) *CapabilitiesHandler {
+	return &CapabilitiesHandler{
+		static:      staticCapabilities(svc.repo.Config),
+		build:       readBuildInfo(),
+		roster:      svc.RosterState,
+		maintenance: maintenance.State,
+	}
+}
+
+func (h *CapabilitiesHandler) capabilities() capabilitiesResponse {
+	freeSlots := capability{
+		Name:    "free_slots",
+		Enabled: true,
+	}
+
+	if h.roster().Open {
+		freeSlots.Enabled = false
+		freeSlots.Reason = "roster service unreachable"
+	}
+
+	return capabilitiesResponse{
+		Build:        h.build,
+		Capabilities: append([]capability{freeSlots}, h.static...),
+		Maintenance:  h.maintenance(),
+	}
+}
+
+func (h *CapabilitiesHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	// the free slots and the maintenance mode may change at any time
+	w.Header().Set("Cache-Control", "max-age=60")
+
+	if err := json.NewEncoder(w).Encode(h.capabilities()); err != nil {
+		slog.Error("failed to encode capabilities", "error", err)
+	}
+}
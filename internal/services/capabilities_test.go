@@ -0,0 +1,72 @@
+package services
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/config"
+)
+
+func Test_CapabilitiesHandler(t *testing.T) {
+	var cfg config.Config
+	cfg.DefaultCountry = "AT"
+	cfg.Notes.AllowedRoles = []string{"staff"}
+	cfg.BookingExport.AllowedRoles = []string{"portal"}
+
+	state := RosterState{}
+	h := &CapabilitiesHandler{
+		static: staticCapabilities(cfg),
+		build:  readBuildInfo(),
+		roster: func() RosterState { return state },
+		maintenance: func() maintenanceState {
+			return maintenanceState{Enabled: true, Message: "migration"}
+		},
+	}
+
+	get := func() map[string]capability {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/capabilities", nil))
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		var res capabilitiesResponse
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&res))
+		assert.NotEmpty(t, res.Build.Version)
+		assert.Equal(t, "migration", res.Maintenance.Message)
+
+		byName := make(map[string]capability, len(res.Capabilities))
+		for _, c := range res.Capabilities {
+			byName[c.Name] = c
+		}
+
+		return byName
+	}
+
+	caps := get()
+	assert.True(t, caps["free_slots"].Enabled)
+	assert.True(t, caps["waiting_room"].Enabled)
+	assert.True(t, caps["notes"].Enabled)
+	assert.Equal(t, "AT", caps["holidays"].Details["country"])
+
+	// features that are not configured or not supported are reported as
+	// disabled together with the reason
+	for _, name := range []string{"resources", "trash", "export", "school_holidays", "booking_export"} {
+		if assert.Contains(t, caps, name) {
+			assert.False(t, caps[name].Enabled, name)
+			assert.NotEmpty(t, caps[name].Reason, name)
+		}
+	}
+
+	state = RosterState{Open: true, OpenUntil: time.Now().Add(time.Minute)}
+	caps = get()
+	assert.False(t, caps["free_slots"].Enabled)
+	assert.Equal(t, "roster service unreachable", caps["free_slots"].Reason)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/capabilities", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
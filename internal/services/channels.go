@@ -0,0 +1,79 @@
+package services
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/config"
+)
+
+// eventChannelHeader may be set on CreateEvent requests to the name of the
+// channel, like an online booking portal, the event is created through. If
+// unset the channel is derived from the roles of the caller. On ListEvents
+// it may be set multiple times to only return events created through one of
+// the channels. The request messages do not yet have a field for it.
+const eventChannelHeader = "X-Event-Channel"
+
+// channels are the configured booking channels.
+type channels []config.Channel
+
+// byName returns the channel with name.
+func (c channels) byName(name string) (config.Channel, bool) {
+	idx := slices.IndexFunc(c, func(ch config.Channel) bool {
+		return ch.Name == name
+	})
+
+	if idx < 0 {
+		return config.Channel{}, false
+	}
+
+	return c[idx], true
+}
+
+// forRequest returns the channel named in the eventChannelHeader or the
+// first channel that matches one of the roles of the caller. It returns
+// false if the event is not created through any channel.
+func (c channels) forRequest(header http.Header) (config.Channel, bool, error) {
+	if name := strings.TrimSpace(header.Get(eventChannelHeader)); name != "" {
+		ch, ok := c.byName(name)
+		if !ok {
+			return ch, false, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("unknown channel %q", name))
+		}
+
+		return ch, true, nil
+	}
+
+	roles := header.Values("X-Remote-Role")
+
+	for _, ch := range c {
+		if slices.ContainsFunc(ch.Roles, func(role string) bool {
+			return slices.Contains(roles, role)
+		}) {
+			return ch, true, nil
+		}
+	}
+
+	return config.Channel{}, false, nil
+}
+
+// summary adds the prefix and suffix of the channel to summary unless it
+// already has them, so summaries edited without them keep them.
+func (c channels) summary(channel, summary string) string {
+	ch, ok := c.byName(channel)
+	if !ok {
+		return summary
+	}
+
+	if ch.Prefix != "" && !strings.HasPrefix(summary, ch.Prefix) {
+		summary = ch.Prefix + summary
+	}
+
+	if ch.Suffix != "" && !strings.HasSuffix(summary, ch.Suffix) {
+		summary += ch.Suffix
+	}
+
+	return summary
+}
@@ -0,0 +1,165 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	calendarv1 "github.com/tierklinik-dobersberg/apis/gen/go/tkd/calendar/v1"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/app"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/cache"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/repo"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+)
+
+func newChannelTestService(t *testing.T) (*CalendarService, *memoryRepo) {
+	svc, bookings := newBookingTestService(t)
+
+	fake := &memoryRepo{bookingRepo: bookings}
+	svc.repo = &app.App{Service: fake, Config: svc.repo.Config}
+	svc.events = fake
+	svc.calendars = cache.NewCache[repo.Calendar]("calendars", time.Minute, nil)
+	svc.channels = channels{
+		{Name: "online", Prefix: "[Online] ", Roles: []string{"booking-portal"}},
+		{Name: "phone", Suffix: " (Tel.)"},
+	}
+
+	return svc, fake
+}
+
+func Test_CreateEvent_Channel(t *testing.T) {
+	svc, fake := newChannelTestService(t)
+
+	create := func(name string, header ...string) (*calendarv1.CalendarEvent, error) {
+		req := createEventRequest(t, "14:00", "15:00", "huber")
+		req.Msg.Name = name
+
+		for idx := 0; idx < len(header); idx += 2 {
+			req.Header().Add(header[idx], header[idx+1])
+		}
+
+		res, err := svc.CreateEvent(context.Background(), req)
+		if err != nil {
+			return nil, err
+		}
+
+		return res.Msg.Event, nil
+	}
+
+	// the channel is derived from the role of the caller
+	evt, err := create("Bello", "X-Remote-Role", "booking-portal")
+	require.NoError(t, err)
+	assert.Equal(t, "[Online] Bello", evt.Summary)
+
+	// or named explicitly
+	_, err = create("Bello", eventChannelHeader, "phone", "X-Remote-Role", "booking-portal")
+	require.NoError(t, err)
+
+	// summaries that already have the prefix are not changed
+	_, err = create("[Online] Bello", eventChannelHeader, "online")
+	require.NoError(t, err)
+
+	_, err = create("Bello")
+	require.NoError(t, err)
+
+	events := fake.events["vet-1"]
+	require.Len(t, events, 4)
+
+	assert.Equal(t, "online", events[0].Channel)
+	assert.Equal(t, "phone", events[1].Channel)
+	assert.Equal(t, "Bello (Tel.)", events[1].Summary)
+	assert.Equal(t, "[Online] Bello", events[2].Summary)
+	assert.Empty(t, events[3].Channel)
+	assert.Equal(t, "Bello", events[3].Summary)
+
+	_, err = create("Bello", eventChannelHeader, "fax")
+	assert.Equal(t, connect.CodeInvalidArgument, connect.CodeOf(err))
+}
+
+func Test_UpdateEvent_Channel(t *testing.T) {
+	svc, fake := newChannelTestService(t)
+
+	req := createEventRequest(t, "14:00", "15:00", "huber")
+	req.Header().Set(eventChannelHeader, "online")
+
+	res, err := svc.CreateEvent(context.Background(), req)
+	require.NoError(t, err)
+
+	update := func(name string) string {
+		res, err := svc.UpdateEvent(context.Background(), connect.NewRequest(&calendarv1.UpdateEventRequest{
+			CalendarId: "vet-1",
+			EventId:    res.Msg.Event.Id,
+			Name:       name,
+			UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"name"}},
+		}))
+		require.NoError(t, err)
+
+		return res.Msg.Event.Summary
+	}
+
+	// the prefix is kept if the summary is edited without it
+	assert.Equal(t, "[Online] Bello, Checkup", update("Bello, Checkup"))
+	assert.Equal(t, "[Online] Bello", update("[Online] Bello"))
+	assert.Equal(t, "online", fake.events["vet-1"][0].Channel)
+}
+
+func Test_ListEvents_Channel(t *testing.T) {
+	svc, _ := newChannelTestService(t)
+
+	for _, channel := range []string{"online", "phone", ""} {
+		req := createEventRequest(t, "14:00", "15:00", "huber")
+		req.Header().Set(eventChannelHeader, channel)
+
+		_, err := svc.CreateEvent(context.Background(), req)
+		require.NoError(t, err)
+	}
+
+	list := func(channels ...string) []string {
+		req := connect.NewRequest(&calendarv1.ListEventsRequest{
+			Source: &calendarv1.ListEventsRequest_Sources{
+				Sources: &calendarv1.EventSource{CalendarIds: []string{"vet-1"}},
+			},
+			SearchTime: &calendarv1.ListEventsRequest_Date{Date: "2024-06-03"},
+		})
+
+		for _, ch := range channels {
+			req.Header().Add(eventChannelHeader, ch)
+		}
+
+		res, err := svc.ListEvents(context.Background(), req)
+		require.NoError(t, err)
+		require.Len(t, res.Msg.Results, 1)
+
+		var summaries []string
+		for _, e := range res.Msg.Results[0].Events {
+			summaries = append(summaries, e.Summary)
+		}
+
+		return summaries
+	}
+
+	// the channel is matched without looking at the summary
+	assert.Equal(t, []string{"[Online] Bello"}, list("online"))
+	assert.Equal(t, []string{"[Online] Bello", "Bello (Tel.)"}, list("online", "phone"))
+	assert.Len(t, list(), 3)
+}
+
+func Test_ChannelSummary(t *testing.T) {
+	c := channels{{Name: "online", Prefix: "[Online] ", Suffix: " *"}}
+
+	assert.Equal(t, "[Online] Bello *", c.summary("online", "Bello"))
+	assert.Equal(t, "[Online] Bello *", c.summary("online", "[Online] Bello *"))
+	assert.Equal(t, "Bello", c.summary("", "Bello"))
+
+	// events of channels that have been removed from the config are kept
+	// as they are
+	assert.Equal(t, "Bello", c.summary("phone", "Bello"))
+
+	_, ok, err := c.forRequest(http.Header{})
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
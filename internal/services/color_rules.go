@@ -0,0 +1,79 @@
+package services
+
+import (
+	"regexp"
+	"slices"
+
+	"github.com/tierklinik-dobersberg/cis-cal/internal/config"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/repo"
+)
+
+// colorRule is a compiled config.ColorRule. Unset patterns are nil and match
+// any event.
+type colorRule struct {
+	summary, description, tag, customer *regexp.Regexp
+	color                               string
+}
+
+// colorRules colors events by the first matching rule.
+type colorRules []colorRule
+
+// compilePattern compiles a color rule pattern. An empty pattern returns
+// nil.
+func compilePattern(pattern string) *regexp.Regexp {
+	if pattern == "" {
+		return nil
+	}
+
+	// patterns have already been validated when loading the configuration.
+	return regexp.MustCompile(pattern)
+}
+
+func newColorRules(cfg []config.ColorRule) colorRules {
+	rules := make(colorRules, len(cfg))
+	for idx, r := range cfg {
+		rules[idx] = colorRule{
+			summary:     compilePattern(r.Summary),
+			description: compilePattern(r.Description),
+			tag:         compilePattern(r.Tag),
+			customer:    compilePattern(r.Customer),
+			color:       r.Color,
+		}
+	}
+
+	return rules
+}
+
+func (r colorRule) matches(evt repo.Event) bool {
+	if r.summary != nil && !r.summary.MatchString(evt.Summary) {
+		return false
+	}
+
+	if r.description != nil && !r.description.MatchString(evt.Description) {
+		return false
+	}
+
+	if r.tag != nil && !slices.ContainsFunc(evt.Tags, r.tag.MatchString) {
+		return false
+	}
+
+	if r.customer != nil {
+		if evt.Data == nil || !r.customer.MatchString(evt.Data.CustomerID) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// colorFor returns the color of the first rule that matches evt. If no rule
+// matches the color of evt is returned unchanged.
+func (rules colorRules) colorFor(evt repo.Event) string {
+	for _, r := range rules {
+		if r.matches(evt) {
+			return r.color
+		}
+	}
+
+	return evt.ColorID
+}
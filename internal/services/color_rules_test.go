@@ -0,0 +1,40 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/config"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/repo"
+)
+
+func Test_ColorRules(t *testing.T) {
+	rules := newColorRules([]config.ColorRule{
+		{Summary: `(?i)\bOP\b`, Color: "11"},
+		{Tag: "^surgery$", Color: "6"},
+		{Customer: ".+", Color: "10"},
+		{Summary: "Impfung", Description: "Tollwut", Color: "2"},
+	})
+
+	cases := []struct {
+		name  string
+		event repo.Event
+		color string
+	}{
+		{"summary", repo.Event{Summary: "op Bello"}, "11"},
+		{"first rule wins", repo.Event{Summary: "OP Bello", Data: &repo.StructuredEvent{CustomerID: "1"}}, "11"},
+		{"tag", repo.Event{Summary: "Bello", Tags: []string{"vaccination", "surgery"}}, "6"},
+		{"customer", repo.Event{Summary: "Bello", Data: &repo.StructuredEvent{CustomerID: "1"}}, "10"},
+		{"empty customer", repo.Event{Summary: "Bello", Data: &repo.StructuredEvent{}}, ""},
+		{"all patterns", repo.Event{Summary: "Impfung", Description: "Tollwut"}, "2"},
+		{"partial match", repo.Event{Summary: "Impfung", Description: "Staupe"}, ""},
+		{"no match keeps color", repo.Event{Summary: "Shopping", ColorID: "5"}, "5"},
+	}
+
+	for _, c := range cases {
+		assert.Equal(t, c.color, rules.colorFor(c.event), c.name)
+	}
+
+	// without rules the color is never changed
+	assert.Equal(t, "3", colorRules(nil).colorFor(repo.Event{ColorID: "3"}))
+}
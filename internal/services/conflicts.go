@@ -0,0 +1,252 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/tierklinik-dobersberg/cis-cal/internal/i18n"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/repo"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+var conflictCounter, _ = otel.Meter("").Int64Counter(
+	"calendar.conflicts_detected",
+	metric.WithDescription("Number of newly detected overlapping events per calendar"),
+)
+
+// conflictEvent is one side of a conflict.
+type conflictEvent struct {
+	ID      string    `json:"id"`
+	Summary string    `json:"summary"`
+	Start   time.Time `json:"start"`
+	End     time.Time `json:"end"`
+}
+
+// conflict is a pair of overlapping events in the same calendar.
+type conflict struct {
+	CalendarID string        `json:"calendarId"`
+	First      conflictEvent `json:"first"`
+	Second     conflictEvent `json:"second"`
+}
+
+// key identifies the conflict independent of the event times.
+func (c conflict) key() string {
+	return c.CalendarID + "/" + c.First.ID + "/" + c.Second.ID
+}
+
+// findOverlaps returns all pairs of overlapping events. Full-day, free and
+// transparent events as well as working-location markers, overlays and
+// synthetic events never conflict. Events without an end time are assumed
+// to last for openEnd.
+func findOverlaps(calID string, events []repo.Event, openEnd time.Duration) []conflict {
+	type candidate struct {
+		repo.Event
+		end time.Time
+	}
+
+	var candidates []candidate
+	for _, e := range events {
+		if e.FullDayEvent || e.IsFree || e.Transparent || e.IsWorkingLocation() || e.IsShift || e.Slot != nil || e.OverlayOf != "" {
+			continue
+		}
+
+		end := e.EndOrDefault(openEnd)
+		if end == nil || !end.After(e.StartTime) {
+			continue
+		}
+
+		candidates = append(candidates, candidate{Event: e, end: *end})
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].StartTime.Before(candidates[j].StartTime)
+	})
+
+	var result []conflict
+	for i, a := range candidates {
+		for _, b := range candidates[i+1:] {
+			// candidates are sorted by start time so no later event can
+			// overlap with a
+			if !b.StartTime.Before(a.end) {
+				break
+			}
+
+			result = append(result, conflict{
+				CalendarID: calID,
+				First:      conflictEvent{ID: a.ID, Summary: a.Summary, Start: a.StartTime, End: a.end},
+				Second:     conflictEvent{ID: b.ID, Summary: b.Summary, Start: b.StartTime, End: b.end},
+			})
+		}
+	}
+
+	return result
+}
+
+// conflictDetector periodically scans the calendars assigned to users for
+// overlapping events. Each conflict is only announced once as long as it
+// persists.
+type conflictDetector struct {
+	events    eventLister
+	calendars func() []string
+	openEnd   time.Duration
+	days      int
+
+	now func() time.Time
+
+	l     sync.Mutex
+	known map[string]struct{}
+}
+
+// conflicts returns the overlapping events of calIDs between from and to.
+// The events are passed through redact before they are compared.
+// Calendars that fail to load are skipped.
+func (d *conflictDetector) conflicts(ctx context.Context, calIDs []string, from, to time.Time, redact func([]repo.Event) []repo.Event) []conflict {
+	var result []conflict
+
+	ctx = repo.WithQuotaCaller(ctx, "conflicts")
+
+	for _, calID := range calIDs {
+		// open-ended events are only matched by their start time
+		events, err := d.events.ListEvents(ctx, calID, repo.WithEventsAfter(from.Add(-d.openEnd)), repo.WithEventsBefore(to))
+		if err != nil {
+			slog.Error("failed to check calendar for overlapping events", "calendar-id", calID, "error", err)
+			continue
+		}
+
+		result = append(result, findOverlaps(calID, redact(events), d.openEnd)...)
+	}
+
+	return result
+}
+
+// check scans the next days and announces new conflicts. Conflicts that have
+// been resolved are forgotten so they are announced again if they reappear.
+func (d *conflictDetector) check(ctx context.Context) []conflict {
+	now := d.now()
+	start := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+
+	// conflicts are only logged by their event ids so private events are
+	// redacted like for anonymous callers.
+	conflicts := d.conflicts(ctx, d.calendars(), start, start.AddDate(0, 0, d.days), func(events []repo.Event) []repo.Event {
+		return eventRedactor{}.redact(events, "", i18n.DefaultLanguage)
+	})
+
+	d.l.Lock()
+	defer d.l.Unlock()
+
+	current := make(map[string]struct{}, len(conflicts))
+
+	var announced []conflict
+	for _, c := range conflicts {
+		key := c.key()
+		current[key] = struct{}{}
+
+		if _, ok := d.known[key]; ok {
+			continue
+		}
+
+		slog.Warn("overlapping events detected", "calendar-id", c.CalendarID, "first", c.First.ID, "second", c.Second.ID, "start", c.Second.Start)
+		conflictCounter.Add(ctx, 1, metric.WithAttributes(attribute.String("calendar", c.CalendarID)))
+
+		announced = append(announced, c)
+	}
+
+	d.known = current
+
+	return announced
+}
+
+// Start checks for conflicts every interval until ctx is cancelled.
+func (d *conflictDetector) Start(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			d.check(ctx)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// ConflictsHandler serves the overlapping events of a single day as JSON:
+//
+//	GET /conflicts?date=2024-06-03&calendar=<id>
+//
+// Without calendar parameters all calendars assigned to users are checked,
+// without date the current day is checked.
+type ConflictsHandler struct {
+	detector     *conflictDetector
+	allowedRoles []string
+	redactor     eventRedactor
+	language     string
+}
+
+// NewConflictsHandler returns a new conflicts handler for svc. If
+// allowedRoles is empty all authenticated users may see conflicts.
+func NewConflictsHandler(svc *CalendarService, allowedRoles []string) *ConflictsHandler {
+	return &ConflictsHandler{
+		detector:     svc.conflicts,
+		allowedRoles: allowedRoles,
+		redactor:     svc.redactor(),
+		language:     svc.repo.Config.DefaultLanguage,
+	}
+}
+
+func (h *ConflictsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !authorize(w, r, h.allowedRoles) {
+		return
+	}
+
+	query := r.URL.Query()
+
+	now := h.detector.now()
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+
+	if v := query.Get("date"); v != "" {
+		var err error
+		day, err = time.ParseInLocation("2006-01-02", v, time.Local)
+		if err != nil {
+			http.Error(w, "invalid value for date, expected YYYY-MM-DD", http.StatusBadRequest)
+			return
+		}
+	}
+
+	calendars := query["calendar"]
+	if len(calendars) == 0 {
+		calendars = h.detector.calendars()
+	}
+
+	userID := r.Header.Get("X-Remote-User-ID")
+	lang := requestLanguage(r, h.language)
+
+	conflicts := h.detector.conflicts(r.Context(), calendars, day, day.AddDate(0, 0, 1), func(events []repo.Event) []repo.Event {
+		return h.redactor.redact(events, userID, lang)
+	})
+	if conflicts == nil {
+		conflicts = []conflict{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(conflicts); err != nil {
+		slog.Error("failed to encode conflicts", "error", err)
+	}
+}
@@ -0,0 +1,171 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/app"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/repo"
+)
+
+func conflictIDs(conflicts []conflict) [][2]string {
+	result := make([][2]string, len(conflicts))
+	for idx, c := range conflicts {
+		result[idx] = [2]string{c.First.ID, c.Second.ID}
+	}
+
+	return result
+}
+
+func Test_FindOverlaps(t *testing.T) {
+	events := []repo.Event{
+		{ID: "b", StartTime: makeTime("09:30"), EndTime: ptr(makeTime("10:30"))},
+		{ID: "a", StartTime: makeTime("09:00"), EndTime: ptr(makeTime("10:00"))},
+		// touching is not a conflict
+		{ID: "c", StartTime: makeTime("10:30"), EndTime: ptr(makeTime("11:00"))},
+		// open-ended, assumed to last 30 minutes
+		{ID: "d", StartTime: makeTime("10:45")},
+		{ID: "full-day", StartTime: makeTime("00:00"), EndTime: ptr(makeTime("23:59")), FullDayEvent: true},
+		{ID: "transparent", StartTime: makeTime("09:00"), EndTime: ptr(makeTime("12:00")), Transparent: true},
+		{ID: "free", StartTime: makeTime("09:00"), EndTime: ptr(makeTime("12:00")), IsFree: true},
+		{ID: "location", StartTime: makeTime("00:00"), EndTime: ptr(makeTime("23:59")), EventType: repo.EventTypeWorkingLocation},
+		{ID: "overlay-1", StartTime: makeTime("09:00"), EndTime: ptr(makeTime("12:00")), OverlayOf: "hr"},
+	}
+
+	conflicts := findOverlaps("vet", events, 30*time.Minute)
+
+	assert.Equal(t, [][2]string{{"a", "b"}, {"c", "d"}}, conflictIDs(conflicts))
+	assert.Equal(t, "vet", conflicts[0].CalendarID)
+	assert.Equal(t, makeTime("11:15"), conflicts[1].Second.End)
+
+	assert.Empty(t, findOverlaps("vet", events[2:], 0))
+}
+
+func Test_ConflictDetector_Debounce(t *testing.T) {
+	events := calendarLister{
+		"vet": {
+			{ID: "a", StartTime: makeTime("09:00"), EndTime: ptr(makeTime("10:00"))},
+			{ID: "b", StartTime: makeTime("09:30"), EndTime: ptr(makeTime("10:30"))},
+		},
+	}
+
+	d := &conflictDetector{
+		events:    events,
+		calendars: func() []string { return []string{"vet"} },
+		days:      1,
+		now:       func() time.Time { return makeTime("08:00") },
+	}
+
+	assert.Len(t, d.check(context.Background()), 1)
+
+	// the same conflict is not announced again
+	assert.Empty(t, d.check(context.Background()))
+
+	// once resolved, a reappearing conflict is announced again
+	events["vet"] = events["vet"][:1]
+	assert.Empty(t, d.check(context.Background()))
+
+	events["vet"] = append(events["vet"], repo.Event{ID: "b", StartTime: makeTime("09:45"), EndTime: ptr(makeTime("10:30"))})
+	assert.Len(t, d.check(context.Background()), 1)
+}
+
+func Test_ConflictsHandler(t *testing.T) {
+	svc := &CalendarService{
+		repo: &app.App{},
+		conflicts: &conflictDetector{
+			events: calendarLister{
+				"vet": {
+					{ID: "a", StartTime: makeTime("09:00"), EndTime: ptr(makeTime("10:00"))},
+					{ID: "b", StartTime: makeTime("09:30"), EndTime: ptr(makeTime("10:30"))},
+				},
+			},
+			calendars: func() []string { return []string{"vet", "other"} },
+			now:       time.Now,
+		},
+	}
+
+	h := NewConflictsHandler(svc, []string{"vet"})
+
+	get := func(target string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.Header.Set("X-Remote-User-ID", "alice")
+		req.Header.Set("X-Remote-Role", "vet")
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		return rec
+	}
+
+	rec := get("/conflicts?date=2000-01-01")
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var conflicts []conflict
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&conflicts))
+	assert.Equal(t, [][2]string{{"a", "b"}}, conflictIDs(conflicts))
+
+	rec = get("/conflicts?calendar=other")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, "[]", rec.Body.String())
+
+	rec = get("/conflicts?date=tomorrow")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	// anonymous callers and callers without an allowed role are rejected
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/conflicts", nil))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	req := httptest.NewRequest(http.MethodGet, "/conflicts", nil)
+	req.Header.Set("X-Remote-User-ID", "bob")
+	req.Header.Set("X-Remote-Role", "reception")
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+}
+
+func Test_ConflictsHandler_Private(t *testing.T) {
+	h := &ConflictsHandler{
+		detector: &conflictDetector{
+			events: calendarLister{
+				"vet": {
+					{ID: "a", CalendarID: "vet", Summary: "Dr. Who", Visibility: repo.VisibilityConfidential, StartTime: makeTime("09:00"), EndTime: ptr(makeTime("10:00"))},
+					{ID: "b", CalendarID: "vet", Summary: "Checkup", StartTime: makeTime("09:30"), EndTime: ptr(makeTime("10:30"))},
+				},
+			},
+			calendars: func() []string { return []string{"vet"} },
+			now:       time.Now,
+		},
+		redactor: ownerRedactor(map[string]string{"vet": "alice"}),
+	}
+
+	get := func(user string) conflict {
+		req := httptest.NewRequest(http.MethodGet, "/conflicts?date=2000-01-01", nil)
+		req.Header.Set("X-Remote-User-ID", user)
+		req.Header.Set("X-Remote-Role", "admin")
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		var conflicts []conflict
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&conflicts))
+		require.Len(t, conflicts, 1)
+
+		return conflicts[0]
+	}
+
+	owner := get("alice")
+	assert.Equal(t, "Dr. Who", owner.First.Summary)
+
+	other := get("bob")
+	assert.Equal(t, "Privat", other.First.Summary)
+	assert.Equal(t, "Checkup", other.Second.Summary)
+}
@@ -0,0 +1,210 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+
+	"github.com/tierklinik-dobersberg/cis-cal/internal/repo"
+)
+
+// currentEvent is a running or upcoming event.
+type currentEvent struct {
+	ID         string    `json:"id"`
+	Summary    string    `json:"summary"`
+	Start      time.Time `json:"start"`
+	End        time.Time `json:"end"`
+	FullDay    bool      `json:"fullDay,omitempty"`
+	OpenEnd    bool      `json:"openEnd,omitempty"`
+	CustomerID string    `json:"customerId,omitempty"`
+}
+
+// calendarNow holds the running events of a calendar and the next event
+// that starts within the lookahead, if any.
+type calendarNow struct {
+	CalendarID string         `json:"calendarId"`
+	Current    []currentEvent `json:"current"`
+	Next       *currentEvent  `json:"next"`
+}
+
+func newCurrentEvent(e repo.Event, end time.Time) currentEvent {
+	res := currentEvent{
+		ID:      e.ID,
+		Summary: e.Summary,
+		Start:   e.StartTime,
+		End:     end,
+		FullDay: e.FullDayEvent,
+		OpenEnd: e.OpenEnd(),
+	}
+
+	if e.Data != nil {
+		res.CustomerID = e.Data.CustomerID
+	}
+
+	return res
+}
+
+// eventsAt returns the events that are running at now and the first timed
+// event that starts after now but not later than now+lookahead. Events
+// without an end time are assumed to last for openEnd, full-day events
+// without an end time for the whole day. Working-location markers, overlays
+// and synthetic events are ignored.
+func eventsAt(calID string, events []repo.Event, now time.Time, lookahead, openEnd time.Duration) calendarNow {
+	res := calendarNow{
+		CalendarID: calID,
+		Current:    []currentEvent{},
+	}
+
+	for _, e := range events {
+		if e.IsWorkingLocation() || e.Slot != nil || e.OverlayOf != "" {
+			continue
+		}
+
+		var end time.Time
+		switch {
+		case e.EndTime != nil:
+			end = *e.EndTime
+		case e.FullDayEvent:
+			end = e.StartTime.AddDate(0, 0, 1)
+		default:
+			end = e.StartTime.Add(openEnd)
+		}
+
+		if !e.StartTime.After(now) && end.After(now) {
+			res.Current = append(res.Current, newCurrentEvent(e, end))
+			continue
+		}
+
+		if e.FullDayEvent || !e.StartTime.After(now) || e.StartTime.After(now.Add(lookahead)) {
+			continue
+		}
+
+		if res.Next == nil || e.StartTime.Before(res.Next.Start) {
+			next := newCurrentEvent(e, end)
+			res.Next = &next
+		}
+	}
+
+	sort.SliceStable(res.Current, func(i, j int) bool {
+		return res.Current[i].Start.Before(res.Current[j].Start)
+	})
+
+	return res
+}
+
+// queryCalendars returns the calendars passed in the calendar query
+// parameter and the calendars of the users passed in the user parameter.
+// Without either, all calendars are returned.
+func queryCalendars(ctx context.Context, query url.Values, resolve func(context.Context, string) (string, error), all func() []string) ([]string, error) {
+	calendars := query["calendar"]
+	for _, user := range query["user"] {
+		calID, err := resolve(ctx, user)
+		if err != nil {
+			return nil, err
+		}
+
+		calendars = append(calendars, calID)
+	}
+
+	if len(calendars) == 0 {
+		calendars = all()
+	}
+
+	return calendars, nil
+}
+
+// CurrentEventsHandler serves the events that are currently running, like
+// for showing context on incoming phone calls:
+//
+//	GET /events/now?calendar=<id>&user=<id>&lookahead=30m
+//
+// Without calendar or user parameters all calendars assigned to users are
+// returned. Events are queried from local midnight on so the request is
+// served from the event caches.
+type CurrentEventsHandler struct {
+	events       eventLister
+	calendars    func() []string
+	resolve      func(ctx context.Context, userID string) (string, error)
+	lookahead    time.Duration
+	openEnd      time.Duration
+	allowedRoles []string
+	redactor     eventRedactor
+	language     string
+
+	now func() time.Time
+}
+
+// NewCurrentEventsHandler returns a new current events handler for svc.
+func NewCurrentEventsHandler(svc *CalendarService) *CurrentEventsHandler {
+	return &CurrentEventsHandler{
+		events:       svc.repo,
+		calendars:    svc.userCalendarIds,
+		resolve:      svc.resolveUserCalendar,
+		lookahead:    svc.repo.Config.CurrentEvents.Lookahead.AsDuration(),
+		openEnd:      svc.repo.Config.FreeSlots.OpenEndDuration.AsDuration(),
+		allowedRoles: svc.repo.Config.CurrentEvents.AllowedRoles,
+		redactor:     svc.redactor(),
+		language:     svc.repo.Config.DefaultLanguage,
+		now:          time.Now,
+	}
+}
+
+func (h *CurrentEventsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !authorize(w, r, h.allowedRoles) {
+		return
+	}
+
+	query := r.URL.Query()
+
+	lookahead := h.lookahead
+	if v := query.Get("lookahead"); v != "" {
+		var err error
+		lookahead, err = time.ParseDuration(v)
+		if err != nil || lookahead < 0 {
+			http.Error(w, "invalid value for lookahead, expected a positive duration", http.StatusBadRequest)
+			return
+		}
+	}
+
+	calendars, err := queryCalendars(r.Context(), query, h.resolve, h.calendars)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	// the event caches start at local midnight
+	now := h.now().Local()
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+
+	userID := r.Header.Get("X-Remote-User-ID")
+	lang := requestLanguage(r, h.language)
+
+	result := make([]calendarNow, 0, len(calendars))
+	for _, calID := range calendars {
+		events, err := h.events.ListEvents(r.Context(), calID, repo.WithEventsAfter(midnight), repo.WithEventsBefore(now.Add(lookahead)))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("failed to load events of calendar %q: %s", calID, err), httpStatus(err))
+			return
+		}
+
+		events = h.redactor.redact(events, userID, lang)
+
+		result = append(result, eventsAt(calID, events, now, lookahead, h.openEnd))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		slog.Error("failed to encode current events", "error", err)
+	}
+}
@@ -0,0 +1,179 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/repo"
+)
+
+func currentIDs(res calendarNow) []string {
+	ids := []string{}
+	for _, e := range res.Current {
+		ids = append(ids, e.ID)
+	}
+
+	return ids
+}
+
+func Test_EventsAt(t *testing.T) {
+	day := time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	events := []repo.Event{
+		{ID: "running", StartTime: makeTime("09:30"), EndTime: ptr(makeTime("10:30")), Data: &repo.StructuredEvent{CustomerID: "1234"}},
+		{ID: "open-end", StartTime: makeTime("09:45")},
+		{ID: "open-end-over", StartTime: makeTime("09:00")},
+		{ID: "full-day", StartTime: day, EndTime: ptr(day.AddDate(0, 0, 1)), FullDayEvent: true},
+		{ID: "full-day-tomorrow", StartTime: day.AddDate(0, 0, 1), FullDayEvent: true},
+		{ID: "earlier", StartTime: makeTime("08:00"), EndTime: ptr(makeTime("10:00"))},
+		{ID: "later", StartTime: makeTime("11:00"), EndTime: ptr(makeTime("11:30"))},
+		{ID: "next", StartTime: makeTime("10:15"), EndTime: ptr(makeTime("10:45"))},
+		{ID: "overlay", StartTime: makeTime("09:00"), EndTime: ptr(makeTime("11:00")), OverlayOf: "hr"},
+	}
+
+	res := eventsAt("vet", events, makeTime("10:00"), time.Hour, 30*time.Minute)
+	assert.Equal(t, "vet", res.CalendarID)
+	assert.Equal(t, []string{"full-day", "running", "open-end"}, currentIDs(res))
+	assert.Equal(t, "1234", res.Current[1].CustomerID)
+	assert.True(t, res.Current[2].OpenEnd)
+	assert.Equal(t, makeTime("10:15"), res.Current[2].End)
+
+	require.NotNil(t, res.Next)
+	assert.Equal(t, "next", res.Next.ID)
+
+	// nothing starts within the lookahead
+	res = eventsAt("vet", events, makeTime("10:00"), 10*time.Minute, 30*time.Minute)
+	assert.Nil(t, res.Next)
+
+	// events ending at now are not running anymore
+	res = eventsAt("vet", events, makeTime("10:30"), 0, 30*time.Minute)
+	assert.Equal(t, []string{"full-day", "next"}, currentIDs(res))
+}
+
+func Test_CurrentEventsHandler(t *testing.T) {
+	now := time.Now()
+
+	h := &CurrentEventsHandler{
+		events: calendarLister{
+			"vet": {
+				{ID: "a", StartTime: now.Add(-time.Minute), EndTime: ptr(now.Add(time.Minute))},
+				{ID: "b", StartTime: now.Add(time.Hour), EndTime: ptr(now.Add(2 * time.Hour))},
+			},
+		},
+		calendars: func() []string { return []string{"vet", "other"} },
+		resolve: func(_ context.Context, user string) (string, error) {
+			if user == "alice" {
+				return "vet", nil
+			}
+
+			return "", fmt.Errorf("no calendar associated with user %q", user)
+		},
+		lookahead: 2 * time.Hour,
+		now:       func() time.Time { return now },
+	}
+
+	get := func(target string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.Header.Set("X-Remote-User-ID", "alice")
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		return rec
+	}
+
+	rec := get("/events/now")
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var res []calendarNow
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&res))
+	require.Len(t, res, 2)
+	assert.Equal(t, []string{"a"}, currentIDs(res[0]))
+	require.NotNil(t, res[0].Next)
+	assert.Equal(t, "b", res[0].Next.ID)
+	assert.Empty(t, res[1].Current)
+	assert.Nil(t, res[1].Next)
+
+	rec = get("/events/now?user=alice&lookahead=30m")
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	res = nil
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&res))
+	require.Len(t, res, 1)
+	assert.Equal(t, "vet", res[0].CalendarID)
+	assert.Nil(t, res[0].Next)
+
+	rec = get("/events/now?user=bob")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = get("/events/now?lookahead=soon")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	// anonymous callers are rejected
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/events/now", nil))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	h.allowedRoles = []string{"reception"}
+	assert.Equal(t, http.StatusForbidden, get("/events/now").Code)
+}
+
+// ownerRedactor is an event redactor for calendars owned by the users in
+// owners.
+func ownerRedactor(owners map[string]string) eventRedactor {
+	return eventRedactor{isOwner: func(calID, userID string) bool {
+		return owners[calID] == userID
+	}}
+}
+
+func Test_CurrentEventsHandler_Private(t *testing.T) {
+	now := time.Now()
+
+	h := &CurrentEventsHandler{
+		events: calendarLister{
+			"vet": {
+				{ID: "a", CalendarID: "vet", Summary: "Dr. Who", Visibility: repo.VisibilityPrivate, StartTime: now.Add(-time.Minute), EndTime: ptr(now.Add(time.Minute)), Data: &repo.StructuredEvent{CustomerID: "1234"}},
+			},
+		},
+		calendars: func() []string { return []string{"vet"} },
+		redactor:  ownerRedactor(map[string]string{"vet": "alice"}),
+		lookahead: time.Hour,
+		now:       func() time.Time { return now },
+	}
+
+	get := func(user string, roles ...string) currentEvent {
+		req := httptest.NewRequest(http.MethodGet, "/events/now", nil)
+		req.Header.Set("X-Remote-User-ID", user)
+		req.Header.Set("Accept-Language", "en")
+		for _, role := range roles {
+			req.Header.Add("X-Remote-Role", role)
+		}
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		var res []calendarNow
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&res))
+		require.Len(t, res, 1)
+		require.Len(t, res[0].Current, 1)
+
+		return res[0].Current[0]
+	}
+
+	owner := get("alice")
+	assert.Equal(t, "Dr. Who", owner.Summary)
+	assert.Equal(t, "1234", owner.CustomerID)
+
+	// roles do not matter, only the owner sees the details
+	other := get("bob", "admin")
+	assert.Equal(t, "Private", other.Summary)
+	assert.Empty(t, other.CustomerID)
+}
@@ -0,0 +1,105 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/bufbuild/connect-go"
+)
+
+// requestTimeoutHeader may be set on ListEvents requests to a duration like
+// "5s" to limit the time spent on the request. It is applied in addition to
+// the deadline of the connect request, the earlier one wins.
+const requestTimeoutHeader = "X-Request-Timeout"
+
+// The roster lookup may use rosterShare of the remaining request time, the
+// rest is left for the calendars. responseMargin is kept free of the
+// deadline to build and send the response and no further calendars are
+// queried once less than minCalendarBudget remains.
+const (
+	rosterShare       = 0.3
+	responseMargin    = 50 * time.Millisecond
+	minCalendarBudget = 20 * time.Millisecond
+)
+
+// withRequestTimeout applies the requestTimeoutHeader to ctx.
+func withRequestTimeout(ctx context.Context, header http.Header) (context.Context, context.CancelFunc, error) {
+	v := header.Get(requestTimeoutHeader)
+	if v == "" {
+		return ctx, func() {}, nil
+	}
+
+	timeout, err := time.ParseDuration(v)
+	if err != nil || timeout <= 0 {
+		return nil, nil, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("invalid value for %s, expected a positive duration like \"5s\"", requestTimeoutHeader))
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+
+	return ctx, cancel, nil
+}
+
+// deadlineBudget splits the time left until the deadline of a request
+// between the phases of the request.
+type deadlineBudget struct {
+	deadline time.Time
+	now      func() time.Time
+}
+
+func newDeadlineBudget(ctx context.Context) deadlineBudget {
+	deadline, _ := ctx.Deadline()
+
+	return deadlineBudget{
+		deadline: deadline,
+		now:      time.Now,
+	}
+}
+
+// remaining returns the time left until the deadline, excluding the
+// responseMargin. It returns false if there is no deadline.
+func (b deadlineBudget) remaining() (time.Duration, bool) {
+	if b.deadline.IsZero() {
+		return 0, false
+	}
+
+	return b.deadline.Sub(b.now()) - responseMargin, true
+}
+
+// child returns a context that ends after share of the remaining time.
+func (b deadlineBudget) child(ctx context.Context, share float64) (context.Context, context.CancelFunc) {
+	left, ok := b.remaining()
+	if !ok {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, time.Duration(float64(left)*share))
+}
+
+// exhausted reports whether too little time is left to query another
+// calendar.
+func (b deadlineBudget) exhausted() bool {
+	left, ok := b.remaining()
+
+	return ok && left < minCalendarBudget
+}
+
+// errDeadlineExhausted is reported for calendars that have not been queried
+// because the request deadline was nearly exhausted.
+var errDeadlineExhausted = errors.New("request deadline exhausted before the calendar was queried")
+
+// deadlineError converts err to a DeadlineExceeded error if ctx has run
+// out of time, otherwise err is returned unchanged.
+func deadlineError(ctx context.Context, err error) error {
+	if err == nil || ctx.Err() != context.DeadlineExceeded {
+		return err
+	}
+
+	if connect.CodeOf(err) == connect.CodeDeadlineExceeded {
+		return err
+	}
+
+	return connect.NewError(connect.CodeDeadlineExceeded, err)
+}
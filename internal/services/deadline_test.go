@@ -0,0 +1,100 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/repo"
+)
+
+// slowLister takes delay to list the events of each calendar unless the
+// context ends first.
+type slowLister struct {
+	eventLister
+
+	delay time.Duration
+	calls int
+}
+
+func (s *slowLister) ListEvents(ctx context.Context, calID string, opts ...repo.SearchOption) ([]repo.Event, error) {
+	s.calls++
+
+	select {
+	case <-time.After(s.delay):
+		return s.eventLister.ListEvents(ctx, calID, opts...)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func Test_ListEvents_Deadline(t *testing.T) {
+	svc, fake := newMaskTestService(5, 2)
+	slow := &slowLister{eventLister: fake, delay: 100 * time.Millisecond}
+	svc.events = slow
+
+	// without partial results the request fails once the deadline is
+	// nearly exhausted
+	ctx, cancel := context.WithTimeout(context.Background(), 250*time.Millisecond)
+	defer cancel()
+
+	_, err := svc.ListEvents(ctx, listEventsRequest(5))
+	require.Error(t, err)
+	assert.Equal(t, connect.CodeDeadlineExceeded, connect.CodeOf(err))
+	assert.NoError(t, ctx.Err(), "the request must return before the client deadline")
+
+	// with partial results the queried calendars are returned
+	slow.calls = 0
+
+	ctx, cancel = context.WithTimeout(context.Background(), 250*time.Millisecond)
+	defer cancel()
+
+	req := listEventsRequest(5)
+	req.Header().Set(allowPartialHeader, "true")
+	req.Header().Set(diagnosticsHeader, "true")
+
+	res, err := svc.ListEvents(ctx, req)
+	require.NoError(t, err)
+	assert.NoError(t, ctx.Err(), "the request must return before the client deadline")
+
+	assert.Less(t, slow.calls, 5, "remaining calendars must not be queried")
+	assert.NotEmpty(t, res.Msg.Results)
+	assert.Less(t, len(res.Msg.Results), 5)
+
+	errs := res.Header().Values(calendarErrorHeader)
+	assert.Len(t, errs, 5-len(res.Msg.Results))
+	for _, e := range errs {
+		assert.Contains(t, e, " deadline_exceeded ")
+	}
+	assert.Contains(t, res.Header().Get("Warning"), "deadline exceeded")
+
+	var diag queryDiagnostics
+	require.NoError(t, json.Unmarshal([]byte(res.Header().Get(queryDiagnosticsHeader)), &diag))
+	assert.NotEmpty(t, diag.Timings.Deadline)
+	assert.NotEmpty(t, diag.Timings.Events)
+}
+
+func Test_ListEvents_RequestTimeoutHeader(t *testing.T) {
+	svc, fake := newMaskTestService(3, 2)
+	svc.events = &slowLister{eventLister: fake, delay: 100 * time.Millisecond}
+
+	req := listEventsRequest(3)
+	req.Header().Set(requestTimeoutHeader, "150ms")
+	req.Header().Set(allowPartialHeader, "true")
+
+	start := time.Now()
+	res, err := svc.ListEvents(context.Background(), req)
+	require.NoError(t, err)
+	assert.Less(t, time.Since(start), 150*time.Millisecond)
+	assert.NotEmpty(t, res.Header().Values(calendarErrorHeader))
+
+	req = listEventsRequest(1)
+	req.Header().Set(requestTimeoutHeader, "soon")
+
+	_, err = svc.ListEvents(context.Background(), req)
+	assert.Equal(t, connect.CodeInvalidArgument, connect.CodeOf(err))
+}
@@ -0,0 +1,327 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/tierklinik-dobersberg/cis-cal/internal/repo"
+)
+
+// maxDebugRange is the maximum time range of events included in a cache
+// dump.
+const maxDebugRange = 7 * 24 * time.Hour
+
+// cacheAge describes a top-level cache of the service.
+type cacheAge struct {
+	LastFetch time.Time `json:"lastFetch"`
+	Age       string    `json:"age,omitempty"`
+	Count     int       `json:"count"`
+}
+
+// debugEvent is a cached event as included in a cache dump.
+type debugEvent struct {
+	ID        string     `json:"id"`
+	Summary   string     `json:"summary"`
+	Start     time.Time  `json:"start"`
+	End       *time.Time `json:"end,omitempty"`
+	FullDay   bool       `json:"fullDay,omitempty"`
+	Status    string     `json:"status,omitempty"`
+	Source    string     `json:"source,omitempty"`
+	Tags      []string   `json:"tags,omitempty"`
+	EventType string     `json:"eventType,omitempty"`
+}
+
+// debugCacheState is the state of an event cache as included in a cache
+// dump.
+type debugCacheState struct {
+	repo.CacheState
+
+	Events []debugEvent `json:"events,omitempty"`
+}
+
+// cacheDump is the response of the DebugCacheHandler.
+type cacheDump struct {
+	Calendars   cacheAge          `json:"calendars"`
+	Profiles    cacheAge          `json:"profiles"`
+	EventCaches []debugCacheState `json:"eventCaches"`
+}
+
+// DebugCacheHandler dumps the state of the service caches for debugging
+// sync issues:
+//
+//	GET /debug/cache?calendar=<id>&events=true&from=2024-06-01&to=2024-06-02
+//
+// Without calendar parameters all event caches are dumped. Cached events
+// are only included if events is set and default to today. Only callers
+// with one of the allowed roles (X-Remote-Role) may dump the caches.
+type DebugCacheHandler struct {
+	svc          *CalendarService
+	allowedRoles []string
+
+	now func() time.Time
+}
+
+// NewDebugCacheHandler returns a new cache debug handler for svc.
+func NewDebugCacheHandler(svc *CalendarService, allowedRoles []string) *DebugCacheHandler {
+	return &DebugCacheHandler{
+		svc:          svc,
+		allowedRoles: allowedRoles,
+		now:          time.Now,
+	}
+}
+
+// age returns the age of a cache that has last been loaded at lastFetch.
+func (h *DebugCacheHandler) age(lastFetch time.Time, count int) cacheAge {
+	res := cacheAge{
+		LastFetch: lastFetch,
+		Count:     count,
+	}
+
+	if !lastFetch.IsZero() {
+		res.Age = h.now().Sub(lastFetch).Truncate(time.Second).String()
+	}
+
+	return res
+}
+
+func (h *DebugCacheHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !authorize(w, r, h.allowedRoles) {
+		return
+	}
+
+	query := r.URL.Query()
+
+	var from, to time.Time
+	if v := query.Get("events"); v != "" {
+		withEvents, err := strconv.ParseBool(v)
+		if err != nil {
+			http.Error(w, "invalid value for events", http.StatusBadRequest)
+			return
+		}
+
+		if withEvents {
+			if from, to, err = h.window(query.Get("from"), query.Get("to")); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+	}
+
+	states, err := h.svc.repo.DumpCacheState(r.Context(), query["calendar"], from, to)
+	if err != nil {
+		http.Error(w, err.Error(), httpStatus(err))
+		return
+	}
+
+	res := cacheDump{
+		EventCaches: make([]debugCacheState, 0, len(states)),
+	}
+
+	if h.svc.calendars != nil {
+		res.Calendars = h.age(h.svc.calendars.LastFetch())
+	}
+
+	if h.svc.users != nil {
+		res.Profiles = h.age(h.svc.users.LastFetch())
+	}
+
+	for _, state := range states {
+		entry := debugCacheState{CacheState: state}
+
+		for _, e := range state.Events {
+			entry.Events = append(entry.Events, debugEvent{
+				ID:        e.ID,
+				Summary:   e.Summary,
+				Start:     e.StartTime,
+				End:       e.EndTime,
+				FullDay:   e.FullDayEvent,
+				Status:    e.Status,
+				Source:    e.Source,
+				Tags:      e.Tags,
+				EventType: e.EventType,
+			})
+		}
+
+		res.EventCaches = append(res.EventCaches, entry)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		slog.Error("failed to encode cache dump", "error", err)
+	}
+}
+
+// window parses the window of events included in the dump. It defaults to
+// today and to one day after from.
+func (h *DebugCacheHandler) window(fromValue, toValue string) (time.Time, time.Time, error) {
+	now := h.now().Local()
+	from := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+
+	if fromValue != "" {
+		var err error
+		if from, err = time.ParseInLocation("2006-01-02", fromValue, time.Local); err != nil {
+			return time.Time{}, time.Time{}, errors.New("invalid value for from, expected YYYY-MM-DD")
+		}
+	}
+
+	to := from.AddDate(0, 0, 1)
+	if toValue != "" {
+		var err error
+		if to, err = time.ParseInLocation("2006-01-02", toValue, time.Local); err != nil {
+			return time.Time{}, time.Time{}, errors.New("invalid value for to, expected YYYY-MM-DD")
+		}
+	}
+
+	if !to.After(from) || to.Sub(from) > maxDebugRange {
+		return time.Time{}, time.Time{}, fmt.Errorf("to must be after from and the range must not exceed %s", maxDebugRange)
+	}
+
+	return from, to, nil
+}
+
+// DebugQuotaHandler reports the number of google calendar API calls per
+// hour, method and caller:
+//
+//	GET /debug/quota?hours=3
+//
+// Without hours the usage of the last 24 hours is returned. Only callers
+// with one of the allowed roles (X-Remote-Role) may read the usage.
+type DebugQuotaHandler struct {
+	svc          *CalendarService
+	allowedRoles []string
+}
+
+// NewDebugQuotaHandler returns a new quota usage handler for svc.
+func NewDebugQuotaHandler(svc *CalendarService, allowedRoles []string) *DebugQuotaHandler {
+	return &DebugQuotaHandler{
+		svc:          svc,
+		allowedRoles: allowedRoles,
+	}
+}
+
+func (h *DebugQuotaHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !authorize(w, r, h.allowedRoles) {
+		return
+	}
+
+	hours := 0
+	if v := r.URL.Query().Get("hours"); v != "" {
+		var err error
+		if hours, err = strconv.Atoi(v); err != nil || hours <= 0 {
+			http.Error(w, "invalid value for hours, expected a positive number", http.StatusBadRequest)
+			return
+		}
+	}
+
+	usage := h.svc.repo.QuotaUsage()
+	if hours > 0 {
+		// the usage of multiple backends is concatenated, each starting
+		// with the current hour.
+		cutoff := time.Now().Truncate(time.Hour).Add(-time.Duration(hours-1) * time.Hour)
+
+		usage = slices.DeleteFunc(usage, func(u repo.QuotaUsage) bool {
+			return u.Hour.Before(cutoff)
+		})
+	}
+
+	if usage == nil {
+		usage = []repo.QuotaUsage{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(usage); err != nil {
+		slog.Error("failed to encode quota usage", "error", err)
+	}
+}
+
+// redactionState is the response of the DebugRedactionHandler.
+type redactionState struct {
+	RedactPII   bool       `json:"redactPII"`
+	PausedUntil *time.Time `json:"pausedUntil,omitempty"`
+}
+
+// DebugRedactionHandler reports whether event content is redacted in log
+// lines and allows to pause redaction for up to repo.MaxRedactionPause
+// while debugging:
+//
+//	GET  /debug/redaction
+//	POST /debug/redaction?duration=15m
+//
+// A duration of zero resumes redaction. Only callers with one of the
+// allowed roles (X-Remote-Role) may use the endpoint.
+type DebugRedactionHandler struct {
+	allowedRoles []string
+}
+
+// NewDebugRedactionHandler returns a new redaction debug handler.
+func NewDebugRedactionHandler(allowedRoles []string) *DebugRedactionHandler {
+	return &DebugRedactionHandler{
+		allowedRoles: allowedRoles,
+	}
+}
+
+func (h *DebugRedactionHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !authorize(w, r, h.allowedRoles) {
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		d, err := time.ParseDuration(r.URL.Query().Get("duration"))
+		if err != nil {
+			http.Error(w, "invalid value for duration", http.StatusBadRequest)
+			return
+		}
+
+		until, err := repo.PauseRedaction(d)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		user := r.Header.Get("X-Remote-User-ID")
+		if until.IsZero() {
+			slog.Warn("redaction of log lines resumed", "user", user)
+		} else {
+			slog.Warn("redaction of log lines paused", "user", user, "until", until)
+		}
+	}
+
+	redact, until := repo.RedactionState()
+
+	res := redactionState{
+		RedactPII: redact,
+	}
+
+	if !until.IsZero() {
+		res.PausedUntil = &until
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		slog.Error("failed to encode redaction state", "error", err)
+	}
+}
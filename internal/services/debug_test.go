@@ -0,0 +1,208 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/app"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/repo"
+)
+
+// dumpRepo is a repo.Service that records the arguments of DumpCacheState.
+type dumpRepo struct {
+	repo.Service
+
+	calendarIDs []string
+	from, to    time.Time
+}
+
+func (d *dumpRepo) DumpCacheState(_ context.Context, calendarIDs []string, from, to time.Time) ([]repo.CacheState, error) {
+	d.calendarIDs = calendarIDs
+	d.from, d.to = from, to
+
+	state := repo.CacheState{CalendarID: "vet", Ready: true, EventCount: 1}
+	if !from.IsZero() {
+		state.Events = []repo.Event{{ID: "1", Summary: "Checkup", StartTime: from, Status: repo.StatusArrived}}
+	}
+
+	return []repo.CacheState{state}, nil
+}
+
+func Test_DebugCacheHandler(t *testing.T) {
+	fake := new(dumpRepo)
+
+	h := NewDebugCacheHandler(&CalendarService{repo: &app.App{Service: fake}}, []string{"admin"})
+	h.now = func() time.Time {
+		return time.Date(2024, time.June, 3, 8, 0, 0, 0, time.Local)
+	}
+
+	get := func(target string, roles ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.Header.Set("X-Remote-User-ID", "alice")
+		for _, role := range roles {
+			req.Header.Add("X-Remote-Role", role)
+		}
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		return rec
+	}
+
+	assert.Equal(t, http.StatusForbidden, get("/debug/cache").Code)
+	assert.Equal(t, http.StatusForbidden, get("/debug/cache", "user").Code)
+
+	rec := get("/debug/cache?calendar=vet", "user", "admin")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Equal(t, []string{"vet"}, fake.calendarIDs)
+	assert.True(t, fake.from.IsZero())
+
+	var dump cacheDump
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&dump))
+	require.Len(t, dump.EventCaches, 1)
+	assert.Equal(t, "vet", dump.EventCaches[0].CalendarID)
+	assert.True(t, dump.EventCaches[0].Ready)
+	assert.Empty(t, dump.EventCaches[0].Events)
+
+	// events default to today
+	rec = get("/debug/cache?events=true", "admin")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Equal(t, time.Date(2024, time.June, 3, 0, 0, 0, 0, time.Local), fake.from)
+	assert.Equal(t, time.Date(2024, time.June, 4, 0, 0, 0, 0, time.Local), fake.to)
+
+	dump = cacheDump{}
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&dump))
+	require.Len(t, dump.EventCaches[0].Events, 1)
+	assert.Equal(t, "Checkup", dump.EventCaches[0].Events[0].Summary)
+	assert.Equal(t, repo.StatusArrived, dump.EventCaches[0].Events[0].Status)
+
+	rec = get("/debug/cache?events=true&from=2024-06-01&to=2024-06-05", "admin")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, time.Date(2024, time.June, 5, 0, 0, 0, 0, time.Local), fake.to)
+
+	// the window is bounded
+	assert.Equal(t, http.StatusBadRequest, get("/debug/cache?events=true&from=2024-06-01&to=2024-07-01", "admin").Code)
+	assert.Equal(t, http.StatusBadRequest, get("/debug/cache?events=maybe", "admin").Code)
+}
+
+// logCapture is a slog.Handler that records all log records.
+type logCapture struct {
+	l       sync.Mutex
+	records []slog.Record
+}
+
+// captureLogs replaces the default logger for the duration of the test.
+func captureLogs(t *testing.T) *logCapture {
+	t.Helper()
+
+	c := &logCapture{}
+
+	prev := slog.Default()
+	slog.SetDefault(slog.New(c))
+	t.Cleanup(func() { slog.SetDefault(prev) })
+
+	return c
+}
+
+func (c *logCapture) Enabled(context.Context, slog.Level) bool { return true }
+func (c *logCapture) WithAttrs([]slog.Attr) slog.Handler       { return c }
+func (c *logCapture) WithGroup(string) slog.Handler            { return c }
+
+func (c *logCapture) Handle(_ context.Context, r slog.Record) error {
+	c.l.Lock()
+	defer c.l.Unlock()
+
+	c.records = append(c.records, r)
+
+	return nil
+}
+
+// attrs returns the attributes of all records with the given message.
+func (c *logCapture) attrs(msg string) []map[string]string {
+	c.l.Lock()
+	defer c.l.Unlock()
+
+	var res []map[string]string
+	for _, r := range c.records {
+		if r.Message != msg {
+			continue
+		}
+
+		attrs := make(map[string]string)
+		r.Attrs(func(a slog.Attr) bool {
+			attrs[a.Key] = a.Value.String()
+			return true
+		})
+
+		res = append(res, attrs)
+	}
+
+	return res
+}
+
+func Test_DebugRedactionHandler(t *testing.T) {
+	t.Cleanup(func() {
+		_, _ = repo.PauseRedaction(0)
+	})
+
+	logs := captureLogs(t)
+	handler := NewDebugRedactionHandler([]string{"admin"})
+
+	send := func(method, query string, roles ...string) (*httptest.ResponseRecorder, redactionState) {
+		req := httptest.NewRequest(method, "/debug/redaction"+query, nil)
+		req.Header.Set("X-Remote-User-ID", "alice")
+		for _, role := range roles {
+			req.Header.Add("X-Remote-Role", role)
+		}
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		var res redactionState
+		if rec.Code == http.StatusOK {
+			require.NoError(t, json.NewDecoder(rec.Body).Decode(&res))
+		}
+
+		return rec, res
+	}
+
+	rec, _ := send(http.MethodPost, "?duration=15m")
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	rec, res := send(http.MethodGet, "", "admin")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.True(t, res.RedactPII)
+	assert.Nil(t, res.PausedUntil)
+
+	// pausing is bounded
+	rec, _ = send(http.MethodPost, "?duration=2h", "admin")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec, _ = send(http.MethodPost, "?duration=soon", "admin")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec, res = send(http.MethodPost, "?duration=15m", "admin")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.False(t, res.RedactPII)
+	require.NotNil(t, res.PausedUntil)
+	assert.WithinDuration(t, time.Now().Add(15*time.Minute), *res.PausedUntil, time.Minute)
+	assert.Equal(t, "Bello", repo.RedactSummary("Bello"))
+
+	paused := logs.attrs("redaction of log lines paused")
+	require.Len(t, paused, 1)
+	assert.Equal(t, "alice", paused[0]["user"])
+
+	rec, res = send(http.MethodPost, "?duration=0s", "admin")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.True(t, res.RedactPII)
+	assert.NotEqual(t, "Bello", repo.RedactSummary("Bello"))
+	assert.Len(t, logs.attrs("redaction of log lines resumed"), 1)
+}
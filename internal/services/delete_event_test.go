@@ -0,0 +1,52 @@
+package services
+
+import (
+	"context"
+	"testing"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	calendarv1 "github.com/tierklinik-dobersberg/apis/gen/go/tkd/calendar/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+func Test_DeleteEvent_AllowMissing(t *testing.T) {
+	svc, fake := newChannelTestService(t)
+
+	res, err := svc.CreateEvent(context.Background(), createEventRequest(t, "14:00", "15:00", "huber"))
+	require.NoError(t, err)
+
+	id := res.Msg.Event.Id
+
+	deleteEvent := func(allowMissing bool) (*connect.Response[calendarv1.DeleteEventResponse], error) {
+		req := connect.NewRequest(&calendarv1.DeleteEventRequest{
+			CalendarId: "vet-1",
+			EventId:    id,
+		})
+
+		if allowMissing {
+			req.Header().Set(allowMissingHeader, "true")
+		}
+
+		return svc.DeleteEvent(context.Background(), req)
+	}
+
+	del, err := deleteEvent(false)
+	require.NoError(t, err)
+	assert.Empty(t, fake.events["vet-1"])
+
+	// the deleted event is returned so clients can offer to undo it
+	var deleted calendarv1.CalendarEvent
+	require.NoError(t, protojson.Unmarshal([]byte(del.Header().Get(deletedEventHeader)), &deleted))
+	assert.Equal(t, id, deleted.Id)
+	assert.Equal(t, "Bello", deleted.Summary)
+
+	// deleting the event again fails unless missing events are allowed
+	_, err = deleteEvent(false)
+	assert.Equal(t, connect.CodeNotFound, connect.CodeOf(err))
+
+	del, err = deleteEvent(true)
+	require.NoError(t, err)
+	assert.Empty(t, del.Header().Get(deletedEventHeader))
+}
@@ -0,0 +1,48 @@
+package services
+
+import (
+	"net/http"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/repo"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/richtext"
+)
+
+// descriptionFormatHeader may be set to html (the default) or markdown. On
+// CreateEvent and UpdateEvent it is the format of the description in the
+// request, on ListEvents descriptions that have been written as Markdown are
+// returned as Markdown. The request messages do not yet have a field for
+// the format.
+const descriptionFormatHeader = "X-Description-Format"
+
+// descriptionFormat returns the description format requested in header.
+func descriptionFormat(header http.Header) (richtext.Format, error) {
+	format, err := richtext.ParseFormat(header.Get(descriptionFormatHeader))
+	if err != nil {
+		return "", connect.NewError(connect.CodeInvalidArgument, err)
+	}
+
+	return format, nil
+}
+
+// storedDescription returns the sanitized HTML that is stored for desc
+// together with the format marker that must be stored along.
+func storedDescription(desc string, format richtext.Format) (string, string) {
+	if format == richtext.FormatMarkdown {
+		return richtext.MarkdownToHTML(desc), string(format)
+	}
+
+	return richtext.Sanitize(desc), ""
+}
+
+// markdownDescriptions converts the descriptions of events that have been
+// written as Markdown back to Markdown.
+func markdownDescriptions(events []repo.Event) []repo.Event {
+	for idx, e := range events {
+		if e.DescriptionFormat == string(richtext.FormatMarkdown) {
+			events[idx].Description = richtext.HTMLToMarkdown(e.Description)
+		}
+	}
+
+	return events
+}
@@ -0,0 +1,156 @@
+package services
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/tierklinik-dobersberg/cis-cal/internal/repo"
+)
+
+// diagnosticsHeader may be set to true on ListEvents requests to receive
+// information about how the query has been resolved and executed. The
+// diagnostics are returned as JSON in queryDiagnosticsHeader as the
+// ListEventsResponse does not have a field for them. Requesting the
+// "diagnostics" read-mask path has the same effect.
+const (
+	diagnosticsHeader      = "X-Diagnostics"
+	queryDiagnosticsHeader = "X-Query-Diagnostics"
+	diagnosticsMaskPath    = "diagnostics"
+)
+
+// Calendar sources reported in the query diagnostics. Calendars resolved from
+// user IDs are reported as "user:<id>".
+const (
+	sourceSelf         = "self"
+	sourceCalendar     = "calendar"
+	sourceAllCalendars = "all-calendars"
+	sourceAllUsers     = "all-users"
+	sourceHolidays     = "holidays"
+)
+
+// queryDiagnostics describes how a ListEvents request has been executed.
+type queryDiagnostics struct {
+	// Range is the relative range From and To have been resolved from.
+	Range     string                `json:"range,omitempty"`
+	From      *time.Time            `json:"from,omitempty"`
+	To        *time.Time            `json:"to,omitempty"`
+	Calendars []calendarDiagnostics `json:"calendars"`
+	Excluded  []string              `json:"excluded,omitempty"`
+	Roster    *rosterDiagnostics    `json:"roster,omitempty"`
+	Timings   queryTimings          `json:"timings"`
+	// Duplicates lists the copies of events that have been removed
+	// because the event is returned by another calendar as well.
+	Duplicates []duplicateDiagnostics `json:"duplicates,omitempty"`
+
+	start time.Time
+}
+
+// calendarDiagnostics describes a single queried calendar.
+type calendarDiagnostics struct {
+	ID       string   `json:"id"`
+	Sources  []string `json:"sources"`
+	CacheHit *bool    `json:"cacheHit,omitempty"`
+	Events   int      `json:"events"`
+	Error    string   `json:"error,omitempty"`
+	Duration string   `json:"duration"`
+}
+
+// duplicateDiagnostics describes a copy of an event that has not been
+// returned as the event has been written to KeptCalendar more recently.
+type duplicateDiagnostics struct {
+	CalendarID   string    `json:"calendarId"`
+	EventID      string    `json:"eventId"`
+	ICalUID      string    `json:"iCalUid,omitempty"`
+	UpdateTime   time.Time `json:"updateTime"`
+	KeptCalendar string    `json:"keptCalendar"`
+}
+
+// rosterDiagnostics describes the roster lookup for free slots and shift
+// boundaries.
+type rosterDiagnostics struct {
+	Days     int         `json:"days"`
+	Error    string      `json:"error,omitempty"`
+	State    RosterState `json:"state"`
+	Duration string      `json:"duration"`
+}
+
+// queryTimings are the durations of the phases of a ListEvents request.
+// Deadline is the time that was left until the request deadline when the
+// request started, FreeSlots is included in Events.
+type queryTimings struct {
+	Deadline  string `json:"deadline,omitempty"`
+	Resolve   string `json:"resolve"`
+	Roster    string `json:"roster,omitempty"`
+	Events    string `json:"events"`
+	FreeSlots string `json:"freeSlots,omitempty"`
+	Total     string `json:"total"`
+}
+
+// wantsDiagnostics reports whether diagnostics have been requested either
+// using diagnosticsHeader or the read mask.
+func wantsDiagnostics(header http.Header, mask listEventsMask) bool {
+	if v, err := strconv.ParseBool(header.Get(diagnosticsHeader)); err == nil && v {
+		return true
+	}
+
+	return slices.Contains(mask.paths, diagnosticsMaskPath)
+}
+
+func newQueryDiagnostics(start, end time.Time) *queryDiagnostics {
+	diag := &queryDiagnostics{
+		start: time.Now(),
+	}
+
+	if !start.IsZero() {
+		diag.From = &start
+	}
+
+	if !end.IsZero() {
+		diag.To = &end
+	}
+
+	return diag
+}
+
+// resolved records the calendars that are going to be queried and the
+// calendars that have been excluded from the resolved sources.
+func (diag *queryDiagnostics) resolved(calendarIds []string, sources map[string][]string) {
+	for _, id := range calendarIds {
+		diag.Calendars = append(diag.Calendars, calendarDiagnostics{
+			ID:      id,
+			Sources: sources[id],
+		})
+	}
+
+	for id := range sources {
+		if !slices.Contains(calendarIds, id) {
+			diag.Excluded = append(diag.Excluded, id)
+		}
+	}
+	slices.Sort(diag.Excluded)
+
+	diag.Timings.Resolve = time.Since(diag.start).String()
+}
+
+// finish completes the per-calendar diagnostics and returns the JSON encoded
+// diagnostics.
+func (diag *queryDiagnostics) finish(trace *repo.CacheTrace, eventsStart time.Time) (string, error) {
+	for idx, cal := range diag.Calendars {
+		if hit, ok := trace.Hit(cal.ID); ok {
+			diag.Calendars[idx].CacheHit = &hit
+		}
+	}
+
+	diag.Timings.Events = time.Since(eventsStart).String()
+	diag.Timings.Total = time.Since(diag.start).String()
+
+	blob, err := json.Marshal(diag)
+	if err != nil {
+		return "", err
+	}
+
+	return string(blob), nil
+}
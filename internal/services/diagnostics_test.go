@@ -0,0 +1,48 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ListEvents_Diagnostics(t *testing.T) {
+	svc, _ := newMaskTestService(2, 3)
+
+	// diagnostics are not returned by default
+	res, err := svc.ListEvents(context.Background(), listEventsRequest(2))
+	require.NoError(t, err)
+	assert.Empty(t, res.Header().Get(queryDiagnosticsHeader))
+
+	req := listEventsRequest(2)
+	req.Header().Set(diagnosticsHeader, "true")
+
+	res, err = svc.ListEvents(context.Background(), req)
+	require.NoError(t, err)
+	require.Len(t, res.Msg.Results, 2)
+
+	var diag queryDiagnostics
+	require.NoError(t, json.Unmarshal([]byte(res.Header().Get(queryDiagnosticsHeader)), &diag))
+
+	require.NotNil(t, diag.From)
+	require.NotNil(t, diag.To)
+	assert.Equal(t, "2024-06-03", diag.From.Format("2006-01-02"))
+	assert.Nil(t, diag.Roster)
+
+	require.Len(t, diag.Calendars, 2)
+	for idx, cal := range diag.Calendars {
+		assert.Equal(t, []string{"cal-0", "cal-1"}[idx], cal.ID)
+		assert.Equal(t, []string{sourceCalendar}, cal.Sources)
+		assert.Equal(t, 3, cal.Events)
+		assert.NotEmpty(t, cal.Duration)
+	}
+
+	// diagnostics may be requested using the read mask as well
+	res, err = svc.ListEvents(context.Background(), listEventsRequest(2, "results", diagnosticsMaskPath))
+	require.NoError(t, err)
+	assert.Len(t, res.Msg.Results, 2)
+	assert.NotEmpty(t, res.Header().Get(queryDiagnosticsHeader))
+}
@@ -0,0 +1,144 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/bufbuild/connect-go"
+	calendarv1 "github.com/tierklinik-dobersberg/apis/gen/go/tkd/calendar/v1"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/i18n"
+	"google.golang.org/protobuf/proto"
+)
+
+// Differential ListEvents responses are not yet part of the
+// ListEventsRequest and ListEventsResponse so they use headers:
+//
+// Clients that poll the same query set differentialHeader and receive an
+// etag for each calendar of the response in calendarETagHeader, formatted
+// as "<calendar-id>=<etag>". Subsequent requests pass those values in
+// ifNoneMatchHeader. Calendars whose etag did not change are omitted from
+// the results and listed in notModifiedHeader instead.
+const (
+	differentialHeader = "X-Differential"
+	ifNoneMatchHeader  = "X-Calendar-If-None-Match"
+	calendarETagHeader = "X-Calendar-ETag"
+	notModifiedHeader  = "X-Not-Modified-Calendar"
+)
+
+// differential computes the per-calendar etags of a ListEvents request.
+type differential struct {
+	// query is a hash of everything but the calendar that changes the
+	// results of a calendar.
+	query string
+
+	// known holds the etags sent by the client, by calendar id.
+	known map[string]string
+}
+
+// newDifferential returns the differential state of req or nil if the client
+// did not ask for differential responses or the results depend on data that
+// is not covered by calendar versions, like free slots calculated from the
+// roster.
+func newDifferential(ctx context.Context, req *connect.Request[calendarv1.ListEventsRequest]) *differential {
+	if ok, _ := strconv.ParseBool(req.Header().Get(differentialHeader)); !ok {
+		return nil
+	}
+
+	if slices.Contains(req.Msg.RequestKinds, calendarv1.CalenarEventRequestKind_CALENDAR_EVENT_REQUEST_KIND_FREE_SLOTS) {
+		return nil
+	}
+
+	if shiftBounds, _ := strconv.ParseBool(req.Header().Get(shiftBoundsHeader)); shiftBounds {
+		return nil
+	}
+
+	msg, err := proto.MarshalOptions{Deterministic: true}.Marshal(req.Msg)
+	if err != nil {
+		return nil
+	}
+
+	h := sha256.New()
+	h.Write(msg)
+
+	// headers that change the events of a calendar, the language is taken
+	// from the context as it's negotiated by the language interceptor.
+	for _, key := range []string{eventTagHeader, eventQueryHeader, eventResourceHeader, eventStatusHeader, eventChannelHeader, descriptionFormatHeader, excludeOverlaysHeader, excludeAbsencesHeader, "X-Remote-User-ID", "X-Remote-Role"} {
+		values := slices.Clone(req.Header().Values(key))
+		slices.Sort(values)
+
+		h.Write([]byte("\n" + key + ":" + strings.Join(values, ",")))
+	}
+	h.Write([]byte("\nlang:" + string(i18n.FromContext(ctx))))
+
+	d := &differential{
+		query: hex.EncodeToString(h.Sum(nil)),
+		known: make(map[string]string),
+	}
+
+	for _, value := range req.Header().Values(ifNoneMatchHeader) {
+		for _, entry := range strings.Split(value, ",") {
+			// calendar ids may contain '=' but etags never do
+			idx := strings.LastIndex(entry, "=")
+			if idx <= 0 {
+				continue
+			}
+
+			d.known[strings.TrimSpace(entry[:idx])] = strings.TrimSpace(entry[idx+1:])
+		}
+	}
+
+	return d
+}
+
+// etag returns the etag of the results for calId. It reports false if the
+// version of the calendar or one of its overlay sources is unknown in which
+// case the calendar must always be sent.
+func (d *differential) etag(ctx context.Context, svc *CalendarService, calId string, withOverlays bool) (string, bool) {
+	ids := []string{calId}
+	if withOverlays {
+		for _, o := range svc.repo.Config.Overlays {
+			if o.Target == calId {
+				ids = append(ids, o.Source)
+			}
+		}
+	}
+
+	h := sha256.New()
+	h.Write([]byte(d.query))
+
+	for _, id := range ids {
+		version, err := svc.repo.CalendarVersion(ctx, id)
+		if err != nil {
+			return "", false
+		}
+
+		h.Write([]byte("\n" + id + ":" + strconv.FormatInt(version, 10)))
+	}
+
+	// calendar metadata is part of the results as well
+	if cal, ok := svc.calendarById.Get(calId); ok {
+		h.Write([]byte("\n" + cal.Name + "\n" + cal.Color + "\n" + cal.Timezone))
+	}
+
+	if user, disabled, ok := svc.calendarOwner(calId); ok {
+		h.Write([]byte("\n" + user.User.Id))
+
+		if disabled {
+			h.Write([]byte("\ndisabled"))
+		}
+	}
+
+	return hex.EncodeToString(h.Sum(nil))[:32], true
+}
+
+// notModified reports whether the client already has the results for calId
+// with the given etag.
+func (d *differential) notModified(calId, etag string) bool {
+	known, ok := d.known[calId]
+
+	return ok && known == etag
+}
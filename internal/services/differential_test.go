@@ -0,0 +1,106 @@
+package services
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	calendarv1 "github.com/tierklinik-dobersberg/apis/gen/go/tkd/calendar/v1"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/app"
+)
+
+// versionedRepo is a countingRepo with calendar versions.
+type versionedRepo struct {
+	*countingRepo
+
+	versions map[string]int64
+}
+
+func (v versionedRepo) CalendarVersion(ctx context.Context, calID string) (int64, error) {
+	return versionRepo{versions: v.versions}.CalendarVersion(ctx, calID)
+}
+
+func Test_ListEvents_Differential(t *testing.T) {
+	svc, fake := newMaskTestService(3, 2)
+
+	versions := map[string]int64{"cal-0": 1, "cal-1": 1, "cal-2": 1}
+	svc.repo = &app.App{Service: versionedRepo{countingRepo: fake, versions: versions}}
+
+	req := listEventsRequest(3)
+	req.Header().Set(differentialHeader, "true")
+
+	res, err := svc.ListEvents(context.Background(), req)
+	require.NoError(t, err)
+	require.Len(t, res.Msg.Results, 3)
+	assert.Empty(t, res.Header().Values(notModifiedHeader))
+
+	etags := res.Header().Values(calendarETagHeader)
+	require.Len(t, etags, 3)
+
+	// nothing changed
+	for _, etag := range etags {
+		req.Header().Add(ifNoneMatchHeader, etag)
+	}
+
+	fake.calls = 0
+	res, err = svc.ListEvents(context.Background(), req)
+	require.NoError(t, err)
+	assert.Empty(t, res.Msg.Results)
+	assert.Equal(t, []string{"cal-0", "cal-1", "cal-2"}, res.Header().Values(notModifiedHeader))
+	assert.Equal(t, etags, res.Header().Values(calendarETagHeader))
+	assert.Zero(t, fake.calls)
+
+	// only the changed calendar is sent
+	versions["cal-1"] = 2
+
+	res, err = svc.ListEvents(context.Background(), req)
+	require.NoError(t, err)
+	require.Len(t, res.Msg.Results, 1)
+	assert.Equal(t, "cal-1", res.Msg.Results[0].Calendar.Id)
+	assert.Len(t, res.Msg.Results[0].Events, 2)
+	assert.Equal(t, []string{"cal-0", "cal-2"}, res.Header().Values(notModifiedHeader))
+	assert.NotEqual(t, etags[1], res.Header().Values(calendarETagHeader)[1])
+
+	// a different query does not match the etags
+	other := listEventsRequest(3, "results.calendar")
+	other.Header().Set(differentialHeader, "true")
+	other.Header()[ifNoneMatchHeader] = req.Header().Values(ifNoneMatchHeader)
+
+	res, err = svc.ListEvents(context.Background(), other)
+	require.NoError(t, err)
+	assert.Len(t, res.Msg.Results, 3)
+
+	// calendars with unknown versions are always sent
+	delete(versions, "cal-0")
+
+	res, err = svc.ListEvents(context.Background(), req)
+	require.NoError(t, err)
+	require.Len(t, res.Msg.Results, 2)
+	assert.Equal(t, "cal-0", res.Msg.Results[0].Calendar.Id)
+	assert.Len(t, res.Header().Values(calendarETagHeader), 2)
+
+	// without the header etags are neither checked nor sent
+	req.Header().Del(differentialHeader)
+
+	res, err = svc.ListEvents(context.Background(), req)
+	require.NoError(t, err)
+	assert.Len(t, res.Msg.Results, 3)
+	assert.Empty(t, res.Header().Values(calendarETagHeader))
+}
+
+func Test_NewDifferential_Roster(t *testing.T) {
+	req := listEventsRequest(1)
+	req.Header().Set(differentialHeader, "true")
+	assert.NotNil(t, newDifferential(context.Background(), req))
+
+	// shift bounds depend on the roster which has no calendar versions
+	req.Header().Set(shiftBoundsHeader, "true")
+	assert.Nil(t, newDifferential(context.Background(), req))
+
+	req.Header().Set(shiftBoundsHeader, "false")
+	assert.NotNil(t, newDifferential(context.Background(), req))
+
+	req.Msg.RequestKinds = append(req.Msg.RequestKinds, calendarv1.CalenarEventRequestKind_CALENDAR_EVENT_REQUEST_KIND_FREE_SLOTS)
+	assert.Nil(t, newDifferential(context.Background(), req))
+}
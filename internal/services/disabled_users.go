@@ -0,0 +1,102 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	idmv1 "github.com/tierklinik-dobersberg/apis/gen/go/tkd/idm/v1"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/cache"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/config"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// includeDisabledUsersHeader may be set to true on ListCalendars and
+// ListEvents requests to include the calendars of disabled users, like in
+// HR views. Those calendars are reported in disabledCalendarHeader, once per
+// calendar, so the UI can grey them out. Neither the requests nor the
+// Calendar message have fields for this yet.
+const (
+	includeDisabledUsersHeader = "X-Include-Disabled-Users"
+	disabledCalendarHeader     = "X-Disabled-Calendar"
+)
+
+// isDisabledUser reports whether the user of profile has been disabled,
+// like former employees. The IDM does not have a disabled state so users
+// are marked by setting field of their extra data to true, see
+// config.Config.DisabledUserField.
+func isDisabledUser(profile *idmv1.Profile, field string) bool {
+	if profile == nil || profile.User == nil || profile.User.Extra == nil {
+		return false
+	}
+
+	v, ok := profile.User.Extra.Fields[field].GetKind().(*structpb.Value_BoolValue)
+
+	return ok && v.BoolValue
+}
+
+// disabledUsers returns a function that reports whether a user has been
+// disabled using the given extra data field.
+func disabledUsers(field string) func(*idmv1.Profile) bool {
+	return func(p *idmv1.Profile) bool {
+		return isDisabledUser(p, field)
+	}
+}
+
+// disabledUserField returns the configured field of the user extra data
+// that marks disabled users.
+func (svc *CalendarService) disabledUserField() string {
+	if svc.repo == nil || svc.repo.Config.DisabledUserField == "" {
+		return config.DefaultDisabledUserField
+	}
+
+	return svc.repo.Config.DisabledUserField
+}
+
+// userCalendarIndexer indexes the profiles for which keep returns true by
+// the id of their calendar.
+func userCalendarIndexer(ctx context.Context, keep func(*idmv1.Profile) bool) func(*idmv1.Profile) (string, bool) {
+	return func(p *idmv1.Profile) (string, bool) {
+		if !keep(p) {
+			return "", false
+		}
+
+		calId := extractCalendarId(ctx, p)
+		return calId, calId != ""
+	}
+}
+
+// includeDisabledUsers reports whether calendars of disabled users should be
+// part of the AllCalendars and AllUsers sources and the calendar list.
+func (svc *CalendarService) includeDisabledUsers(header http.Header) bool {
+	if svc.repo != nil && svc.repo.Config.IncludeDisabledUsers {
+		return true
+	}
+
+	include, _ := strconv.ParseBool(header.Get(includeDisabledUsersHeader))
+
+	return include
+}
+
+// calendarOwner returns the user calID is assigned to and whether the user
+// has been disabled.
+func (svc *CalendarService) calendarOwner(calID string) (*idmv1.Profile, bool, bool) {
+	for _, index := range []*cache.Index[string, *idmv1.Profile]{svc.userByCalId, svc.disabledByCalId} {
+		if index == nil {
+			continue
+		}
+
+		if user, ok := index.Get(calID); ok {
+			return user, isDisabledUser(user, svc.disabledUserField()), true
+		}
+	}
+
+	return nil, false, false
+}
+
+// hasDisabledOwner reports whether calID is assigned to a disabled user.
+func (svc *CalendarService) hasDisabledOwner(calID string) bool {
+	_, disabled, _ := svc.calendarOwner(calID)
+
+	return disabled
+}
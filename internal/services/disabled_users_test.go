@@ -0,0 +1,162 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	calendarv1 "github.com/tierklinik-dobersberg/apis/gen/go/tkd/calendar/v1"
+	idmv1 "github.com/tierklinik-dobersberg/apis/gen/go/tkd/idm/v1"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/cache"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/config"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/repo"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// newDisabledUsersTestService returns a service with two calendars, cal-0 is
+// assigned to maier and cal-1 to huber who has been disabled.
+func newDisabledUsersTestService(t *testing.T, includeDisabled bool) *CalendarService {
+	t.Helper()
+
+	svc, _ := newMaskTestService(2, 1)
+	svc.repo.Config.IncludeDisabledUsers = includeDisabled
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	svc.calendars = cache.NewCache[repo.Calendar]("calendars", time.Minute, cache.LoaderFunc[repo.Calendar](func(context.Context) ([]repo.Calendar, error) {
+		return []repo.Calendar{{ID: "cal-0", Name: "Maier"}, {ID: "cal-1", Name: "Huber"}}, nil
+	}))
+	svc.calendars.Start(ctx)
+
+	require.Eventually(t, func() bool {
+		cals, _ := svc.calendars.Get()
+		return len(cals) == 2
+	}, time.Second, 10*time.Millisecond)
+
+	profiles := []*idmv1.Profile{
+		{User: &idmv1.User{Id: "maier", Extra: &structpb.Struct{Fields: map[string]*structpb.Value{
+			"calendarID": structpb.NewStringValue("cal-0"),
+		}}}},
+		{User: &idmv1.User{Id: "huber", Extra: &structpb.Struct{Fields: map[string]*structpb.Value{
+			"calendarID": structpb.NewStringValue("cal-1"),
+			"disabled":   structpb.NewBoolValue(true),
+		}}}},
+	}
+
+	svc.userByCalId = cache.NewIndex(userCalendarIndexer(ctx, func(p *idmv1.Profile) bool {
+		return includeDisabled || !isDisabledUser(p, config.DefaultDisabledUserField)
+	}))
+	svc.userByCalId.Update(profiles)
+
+	svc.disabledByCalId = cache.NewIndex(userCalendarIndexer(ctx, disabledUsers(config.DefaultDisabledUserField)))
+	svc.disabledByCalId.Update(profiles)
+
+	return svc
+}
+
+func resultCalendars(res *connect.Response[calendarv1.ListEventsResponse]) []string {
+	var ids []string
+	for _, r := range res.Msg.Results {
+		ids = append(ids, r.Calendar.GetId())
+	}
+
+	return ids
+}
+
+func Test_ListEvents_DisabledUsers(t *testing.T) {
+	svc := newDisabledUsersTestService(t, false)
+
+	request := func(calendars int, source string, include bool) *connect.Request[calendarv1.ListEventsRequest] {
+		req := listEventsRequest(calendars)
+
+		switch source {
+		case sourceAllUsers:
+			req.Msg.Source = &calendarv1.ListEventsRequest_AllUsers{AllUsers: true}
+		case sourceAllCalendars:
+			req.Msg.Source = &calendarv1.ListEventsRequest_AllCalendars{AllCalendars: true}
+		}
+
+		if include {
+			req.Header().Set(includeDisabledUsersHeader, "true")
+		}
+
+		return req
+	}
+
+	for _, source := range []string{sourceAllUsers, sourceAllCalendars} {
+		res, err := svc.ListEvents(context.Background(), request(0, source, false))
+		require.NoError(t, err)
+		assert.Equal(t, []string{"cal-0"}, resultCalendars(res))
+		assert.Empty(t, res.Header().Values(disabledCalendarHeader))
+
+		res, err = svc.ListEvents(context.Background(), request(0, source, true))
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []string{"cal-0", "cal-1"}, resultCalendars(res))
+		assert.Equal(t, []string{"cal-1"}, res.Header().Values(disabledCalendarHeader))
+
+		for _, r := range res.Msg.Results {
+			if r.Calendar.Id == "cal-1" {
+				assert.Equal(t, "huber", r.Calendar.UserId)
+			}
+		}
+	}
+
+	// explicitly requested calendars are never excluded
+	res, err := svc.ListEvents(context.Background(), request(2, sourceCalendar, false))
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"cal-0", "cal-1"}, resultCalendars(res))
+	assert.Equal(t, []string{"cal-1"}, res.Header().Values(disabledCalendarHeader))
+}
+
+func Test_ListEvents_DisabledUsers_Configured(t *testing.T) {
+	svc := newDisabledUsersTestService(t, true)
+
+	req := listEventsRequest(0)
+	req.Msg.Source = &calendarv1.ListEventsRequest_AllUsers{AllUsers: true}
+
+	res, err := svc.ListEvents(context.Background(), req)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"cal-0", "cal-1"}, resultCalendars(res))
+	assert.Equal(t, []string{"cal-1"}, res.Header().Values(disabledCalendarHeader))
+}
+
+func Test_ListCalendars_DisabledUsers(t *testing.T) {
+	svc := newDisabledUsersTestService(t, false)
+
+	res, err := svc.ListCalendars(context.Background(), connect.NewRequest(&calendarv1.ListCalendarsRequest{}))
+	require.NoError(t, err)
+	require.Len(t, res.Msg.Calendars, 1)
+	assert.Equal(t, "cal-0", res.Msg.Calendars[0].Id)
+	assert.Equal(t, "maier", res.Msg.Calendars[0].UserId)
+
+	req := connect.NewRequest(&calendarv1.ListCalendarsRequest{})
+	req.Header().Set(includeDisabledUsersHeader, "true")
+
+	res, err = svc.ListCalendars(context.Background(), req)
+	require.NoError(t, err)
+	require.Len(t, res.Msg.Calendars, 2)
+	assert.Equal(t, "huber", res.Msg.Calendars[1].UserId)
+	assert.Equal(t, []string{"cal-1"}, res.Header().Values(disabledCalendarHeader))
+}
+
+func Test_IsDisabledUser(t *testing.T) {
+	assert.False(t, isDisabledUser(nil, "disabled"))
+	assert.False(t, isDisabledUser(&idmv1.Profile{User: &idmv1.User{}}, "disabled"))
+	assert.False(t, isDisabledUser(&idmv1.Profile{User: &idmv1.User{Extra: &structpb.Struct{Fields: map[string]*structpb.Value{
+		"disabled": structpb.NewStringValue("true"),
+	}}}}, "disabled"))
+	assert.True(t, isDisabledUser(&idmv1.Profile{User: &idmv1.User{Extra: &structpb.Struct{Fields: map[string]*structpb.Value{
+		"disabled": structpb.NewBoolValue(true),
+	}}}}, "disabled"))
+
+	// the field is configurable
+	formerEmployee := &idmv1.Profile{User: &idmv1.User{Extra: &structpb.Struct{Fields: map[string]*structpb.Value{
+		"formerEmployee": structpb.NewBoolValue(true),
+	}}}}
+	assert.False(t, isDisabledUser(formerEmployee, "disabled"))
+	assert.True(t, isDisabledUser(formerEmployee, "formerEmployee"))
+}
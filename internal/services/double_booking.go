@@ -0,0 +1,112 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/i18n"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/repo"
+)
+
+// customerConflicts returns all events in other calendars that are booked for
+// the same customer as evt and overlap with it. Events that only touch evt
+// are not considered a conflict. Events without an end time are assumed to
+// last for the configured open-end duration.
+func (svc *CalendarService) customerConflicts(ctx context.Context, evt repo.Event) []repo.Event {
+	if evt.Data == nil || evt.Data.CustomerID == "" || evt.EndTime == nil {
+		return nil
+	}
+
+	openEnd := svc.repo.Config.FreeSlots.OpenEndDuration.AsDuration()
+
+	var conflicts []repo.Event
+	for calId := range svc.calendarById.Keys() {
+		if calId == evt.CalendarID {
+			continue
+		}
+
+		// open-ended events are only matched by their start time so
+		// extend the search range accordingly
+		events, err := svc.repo.ListEvents(ctx, calId,
+			repo.WithEventsAfter(evt.StartTime.Add(-openEnd)),
+			repo.WithEventsBefore(*evt.EndTime),
+			repo.WithCustomerID(evt.Data.CustomerID),
+		)
+		if err != nil {
+			slog.Error("failed to check calendar for customer double booking", "calendar-id", calId, "error", err)
+
+			continue
+		}
+
+		for _, e := range events {
+			end := e.EndOrDefault(openEnd)
+			if e.ID == evt.ID || end == nil || e.IsWorkingLocation() || !e.HasCustomer(evt.Data.CustomerID) {
+				continue
+			}
+
+			if e.StartTime.Before(*evt.EndTime) && end.After(evt.StartTime) {
+				conflicts = append(conflicts, e)
+			}
+		}
+	}
+
+	sort.Stable(repo.ByStartTime(conflicts))
+
+	return conflicts
+}
+
+// checkCustomerDoubleBooking checks evt for customer double bookings. If
+// double bookings are rejected an error with the conflicting events as
+// details is returned. Otherwise a warning is returned that should be sent
+// to the caller.
+func (svc *CalendarService) checkCustomerDoubleBooking(ctx context.Context, evt repo.Event) (string, error) {
+	conflicts := svc.customerConflicts(ctx, evt)
+	if len(conflicts) == 0 {
+		return "", nil
+	}
+
+	refs := make([]string, len(conflicts))
+	for idx, c := range conflicts {
+		refs[idx] = c.CalendarID + "/" + c.ID
+	}
+
+	msg := i18n.FromContext(ctx).Sprintf(i18n.CustomerDoubleBooking, evt.Data.CustomerID, strings.Join(refs, ", "))
+
+	if !svc.repo.Config.Validation.RejectCustomerDoubleBooking {
+		slog.Warn("customer double booking", "calendar-id", evt.CalendarID, "customer-id", evt.Data.CustomerID, "conflicts", refs)
+
+		return msg, nil
+	}
+
+	connectErr := connect.NewError(connect.CodeFailedPrecondition, fmt.Errorf("%s", msg))
+	for _, c := range conflicts {
+		pb, err := c.ToProto()
+		if err != nil {
+			return "", err
+		}
+
+		detail, err := connect.NewErrorDetail(pb)
+		if err != nil {
+			return "", err
+		}
+
+		connectErr.AddDetail(detail)
+	}
+
+	return "", connectErr
+}
+
+// setWarning adds msg as a Warning header to the response. Multiple
+// warnings are sent as separate headers.
+func setWarning(header http.Header, msg string) {
+	if msg == "" {
+		return
+	}
+
+	header.Add("Warning", fmt.Sprintf("199 - %q", msg))
+}
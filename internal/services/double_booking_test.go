@@ -0,0 +1,235 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	calendarv1 "github.com/tierklinik-dobersberg/apis/gen/go/tkd/calendar/v1"
+	idmv1 "github.com/tierklinik-dobersberg/apis/gen/go/tkd/idm/v1"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/app"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/cache"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/config"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/repo"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// bookingRepo is a repo.Service that stores events per calendar and applies
+// the time range and customer search options.
+type bookingRepo struct {
+	repo.Service
+
+	events  map[string][]repo.Event
+	created []repo.Event
+}
+
+func (b *bookingRepo) ListEvents(_ context.Context, calID string, filter ...repo.SearchOption) ([]repo.Event, error) {
+	opts := new(repo.EventSearchOptions)
+	for _, fn := range filter {
+		fn(opts)
+	}
+
+	var result []repo.Event
+	for _, e := range b.events[calID] {
+		// like the event cache, events without an end time are matched
+		// by their start time
+		end := e.StartTime
+		if e.EndTime != nil {
+			end = *e.EndTime
+		}
+
+		if opts.FromTime != nil && !end.After(*opts.FromTime) {
+			continue
+		}
+
+		if opts.ToTime != nil && e.StartTime.After(*opts.ToTime) {
+			continue
+		}
+
+		if opts.CustomerID != nil && !e.HasCustomer(*opts.CustomerID) {
+			continue
+		}
+
+		if len(opts.Tags) > 0 && !e.HasTag(opts.Tags...) {
+			continue
+		}
+
+		if len(opts.Channels) > 0 && !e.HasChannel(opts.Channels...) {
+			continue
+		}
+
+		result = append(result, e)
+	}
+
+	return result, nil
+}
+
+func (b *bookingRepo) CreateEvent(_ context.Context, calID, name, description string, startTime time.Time, duration time.Duration, data *repo.StructuredEvent, opts ...repo.CreateOption) (*repo.Event, error) {
+	var co repo.CreateOptions
+	for _, fn := range opts {
+		fn(&co)
+	}
+
+	end := startTime.Add(duration)
+	evt := repo.Event{
+		ID:          "new",
+		CalendarID:  calID,
+		Summary:     name,
+		Description: description,
+		StartTime:   startTime,
+		EndTime:     &end,
+		Data:        data,
+		Tags:        co.Tags,
+		ColorID:     co.ColorID,
+
+		DescriptionFormat: co.DescriptionFormat,
+	}
+
+	b.created = append(b.created, evt)
+
+	return &evt, nil
+}
+
+func newBookingTestService(t *testing.T) (*CalendarService, *bookingRepo) {
+	t.Helper()
+
+	at := func(ts string) time.Time {
+		return time.Date(2024, time.June, 3, makeTime(ts).Hour(), makeTime(ts).Minute(), 0, 0, time.UTC)
+	}
+
+	fake := &bookingRepo{
+		events: map[string][]repo.Event{
+			"vet-2": {
+				{
+					ID:         "existing",
+					CalendarID: "vet-2",
+					StartTime:  at("10:00"),
+					EndTime:    ptr(at("11:00")),
+					Data:       &repo.StructuredEvent{CustomerSource: "vetinf", CustomerID: "huber"},
+				},
+			},
+		},
+	}
+
+	calendarById := cache.NewIndex(func(c repo.Calendar) (string, bool) {
+		return c.ID, true
+	})
+	calendarById.Update([]repo.Calendar{{ID: "vet-1"}, {ID: "vet-2"}})
+
+	svc := &CalendarService{
+		repo:         &app.App{Service: fake},
+		calendarById: calendarById,
+		slotLocks:    newSlotLocks(time.Minute),
+		userByCalId: cache.NewIndex(func(p *idmv1.Profile) (string, bool) {
+			return "", false
+		}),
+	}
+
+	return svc, fake
+}
+
+func createEventRequest(t *testing.T, start, end, customer string) *connect.Request[calendarv1.CreateEventRequest] {
+	t.Helper()
+
+	extra, err := anypb.New(&calendarv1.CustomerAnnotation{
+		CustomerSource: "vetinf",
+		CustomerId:     customer,
+	})
+	require.NoError(t, err)
+
+	at := func(ts string) *timestamppb.Timestamp {
+		return timestamppb.New(time.Date(2024, time.June, 3, makeTime(ts).Hour(), makeTime(ts).Minute(), 0, 0, time.UTC))
+	}
+
+	return connect.NewRequest(&calendarv1.CreateEventRequest{
+		CalendarId: "vet-1",
+		Name:       "Bello",
+		Start:      at(start),
+		End:        at(end),
+		ExtraData:  extra,
+	})
+}
+
+func Test_CreateEvent_CustomerDoubleBooking(t *testing.T) {
+	cases := []struct {
+		name             string
+		start, end       string
+		customer         string
+		expectedConflict bool
+	}{
+		{"overlap", "10:30", "11:30", "huber", true},
+		{"back-to-back", "11:00", "12:00", "huber", false},
+		{"different customer", "10:30", "11:30", "maier", false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			svc, fake := newBookingTestService(t)
+
+			// without rejection the event is created with a warning
+			res, err := svc.CreateEvent(context.Background(), createEventRequest(t, c.start, c.end, c.customer))
+			require.NoError(t, err)
+			require.Len(t, fake.created, 1)
+			assert.Equal(t, c.expectedConflict, res.Header().Get("Warning") != "")
+
+			// with rejection enabled the event is not created
+			svc.repo.Config.Validation.RejectCustomerDoubleBooking = true
+
+			_, err = svc.CreateEvent(context.Background(), createEventRequest(t, c.start, c.end, c.customer))
+			if !c.expectedConflict {
+				require.NoError(t, err)
+				require.Len(t, fake.created, 2)
+
+				return
+			}
+
+			require.Error(t, err)
+			assert.Len(t, fake.created, 1)
+
+			var connectErr *connect.Error
+			require.ErrorAs(t, err, &connectErr)
+			assert.Equal(t, connect.CodeFailedPrecondition, connectErr.Code())
+
+			require.Len(t, connectErr.Details(), 1)
+			detail, err := connectErr.Details()[0].Value()
+			require.NoError(t, err)
+
+			conflict, ok := detail.(*calendarv1.CalendarEvent)
+			require.True(t, ok)
+			assert.Equal(t, "existing", conflict.Id)
+			assert.Equal(t, "vet-2", conflict.CalendarId)
+		})
+	}
+}
+
+func Test_CustomerConflicts_OpenEnd(t *testing.T) {
+	svc, fake := newBookingTestService(t)
+
+	start := time.Date(2024, time.June, 3, 14, 0, 0, 0, time.UTC)
+	fake.events["vet-2"] = append(fake.events["vet-2"], repo.Event{
+		ID:         "open",
+		CalendarID: "vet-2",
+		StartTime:  start,
+		Data:       &repo.StructuredEvent{CustomerSource: "vetinf", CustomerID: "huber"},
+	})
+
+	end := start.Add(45 * time.Minute)
+	evt := repo.Event{
+		CalendarID: "vet-1",
+		StartTime:  start.Add(15 * time.Minute),
+		EndTime:    &end,
+		Data:       &repo.StructuredEvent{CustomerID: "huber"},
+	}
+
+	assert.Empty(t, svc.customerConflicts(context.Background(), evt))
+
+	svc.repo.Config.FreeSlots.OpenEndDuration = config.Duration(30 * time.Minute)
+
+	conflicts := svc.customerConflicts(context.Background(), evt)
+	require.Len(t, conflicts, 1)
+	assert.Equal(t, "open", conflicts[0].ID)
+}
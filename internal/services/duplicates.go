@@ -0,0 +1,119 @@
+package services
+
+import (
+	"sort"
+
+	"github.com/tierklinik-dobersberg/cis-cal/internal/repo"
+)
+
+// loadedCalendar holds the events of a calendar queried by ListEvents
+// before they are converted.
+type loadedCalendar struct {
+	id      string
+	country string
+	holiday bool
+	events  []repo.Event
+}
+
+// duplicateKey returns the key that identifies the copies of an event in
+// different calendars. Google keeps both the id and the iCalUID when an
+// event is moved, instances of recurring events share the iCalUID only and
+// ids are only unique within a calendar. Events without an iCalUID,
+// synthetic events, overlays and the copies of invited attendees are never
+// duplicates.
+func duplicateKey(e repo.Event) (string, bool) {
+	if e.ID == "" || e.ICalUID == "" || e.Slot != nil || e.IsShift || e.OverlayOf != "" || e.Invited {
+		return "", false
+	}
+
+	return e.ICalUID + "/" + e.ID, true
+}
+
+// dedupMovedEvents removes copies of the same event from all but one
+// calendar. After a move the target calendar may return the event before
+// the source calendar synced the deletion, the copy that has been written
+// most recently is kept. The removed copies are returned for the query
+// diagnostics.
+func dedupMovedEvents(loaded []loadedCalendar) []duplicateDiagnostics {
+	type eventRef struct {
+		cal, idx int
+	}
+
+	copies := make(map[string][]eventRef)
+	for calIdx, cal := range loaded {
+		if cal.holiday {
+			continue
+		}
+
+		for idx, e := range cal.events {
+			if key, ok := duplicateKey(e); ok {
+				copies[key] = append(copies[key], eventRef{calIdx, idx})
+			}
+		}
+	}
+
+	var (
+		result  []duplicateDiagnostics
+		removed = make(map[eventRef]struct{})
+	)
+
+	for _, refs := range copies {
+		if len(refs) < 2 {
+			continue
+		}
+
+		kept := refs[0]
+		for _, ref := range refs[1:] {
+			if loaded[ref.cal].events[ref.idx].UpdateTime.After(loaded[kept.cal].events[kept.idx].UpdateTime) {
+				kept = ref
+			}
+		}
+
+		for _, ref := range refs {
+			if ref == kept {
+				continue
+			}
+
+			removed[ref] = struct{}{}
+
+			e := loaded[ref.cal].events[ref.idx]
+			result = append(result, duplicateDiagnostics{
+				CalendarID:   e.CalendarID,
+				EventID:      e.ID,
+				ICalUID:      e.ICalUID,
+				UpdateTime:   e.UpdateTime,
+				KeptCalendar: loaded[kept.cal].id,
+			})
+		}
+	}
+
+	if len(removed) == 0 {
+		return nil
+	}
+
+	// the event slices may be shared with the caches, never filter them
+	// in place.
+	for calIdx := range loaded {
+		var events []repo.Event
+
+		for idx, e := range loaded[calIdx].events {
+			if _, ok := removed[eventRef{calIdx, idx}]; !ok {
+				events = append(events, e)
+			}
+		}
+
+		if len(events) != len(loaded[calIdx].events) {
+			loaded[calIdx].events = events
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].CalendarID != result[j].CalendarID {
+			return result[i].CalendarID < result[j].CalendarID
+		}
+
+		return result[i].EventID < result[j].EventID
+	})
+
+	return result
+}
@@ -0,0 +1,126 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/repo"
+)
+
+func Test_ListEvents_MovedEventDuplicate(t *testing.T) {
+	svc, _ := newMaskTestService(3, 0)
+
+	start := time.Date(2024, 6, 3, 9, 0, 0, 0, time.Local)
+	end := start.Add(30 * time.Minute)
+	movedAt := time.Date(2024, 6, 2, 17, 0, 0, 0, time.UTC)
+
+	bello := repo.Event{
+		ID:         "bello",
+		ICalUID:    "bello@google.com",
+		Summary:    "Bello",
+		StartTime:  start,
+		EndTime:    &end,
+		UpdateTime: movedAt.Add(-time.Hour),
+	}
+
+	// the moved event has already been synced by the target calendar while
+	// the source calendar still returns the event it has been moved from.
+	stale := bello
+	stale.CalendarID = "cal-0"
+
+	moved := bello
+	moved.CalendarID = "cal-1"
+	moved.UpdateTime = movedAt
+
+	// invitations share the id and iCalUID with the event of the
+	// organizer and must be kept.
+	invited := bello
+	invited.CalendarID = "cal-2"
+	invited.Invited = true
+
+	// events without an iCalUID are never deduplicated
+	other := repo.Event{ID: "other", CalendarID: "cal-0", StartTime: start, EndTime: &end}
+	otherCopy := other
+	otherCopy.CalendarID = "cal-2"
+
+	svc.events = calendarLister{
+		"cal-0": {stale, other},
+		"cal-1": {moved},
+		"cal-2": {invited, otherCopy},
+	}
+
+	req := listEventsRequest(3)
+	req.Header().Set(diagnosticsHeader, "true")
+
+	res, err := svc.ListEvents(context.Background(), req)
+	require.NoError(t, err)
+
+	ids := make(map[string][]string)
+	for _, r := range res.Msg.Results {
+		for _, e := range r.Events {
+			ids[r.Calendar.Id] = append(ids[r.Calendar.Id], e.Id)
+		}
+	}
+
+	assert.Equal(t, map[string][]string{
+		"cal-0": {"other"},
+		"cal-1": {"bello"},
+		"cal-2": {"bello", "other"},
+	}, ids)
+
+	// the suppressed copy is only reported in the diagnostics
+	var diag queryDiagnostics
+	require.NoError(t, json.Unmarshal([]byte(res.Header().Get(queryDiagnosticsHeader)), &diag))
+	require.Len(t, diag.Duplicates, 1)
+	assert.Equal(t, "cal-0", diag.Duplicates[0].CalendarID)
+	assert.Equal(t, "bello", diag.Duplicates[0].EventID)
+	assert.Equal(t, "bello@google.com", diag.Duplicates[0].ICalUID)
+	assert.Equal(t, "cal-1", diag.Duplicates[0].KeptCalendar)
+
+	// once the source calendar synced the move there is nothing to
+	// deduplicate.
+	svc.events = calendarLister{
+		"cal-0": {other},
+		"cal-1": {moved},
+	}
+
+	res, err = svc.ListEvents(context.Background(), req)
+	require.NoError(t, err)
+
+	var synced queryDiagnostics
+	require.NoError(t, json.Unmarshal([]byte(res.Header().Get(queryDiagnosticsHeader)), &synced))
+	assert.Empty(t, synced.Duplicates)
+}
+
+func Test_DedupMovedEvents(t *testing.T) {
+	at := func(minute int) time.Time {
+		return time.Date(2024, 6, 3, 10, minute, 0, 0, time.UTC)
+	}
+
+	event := func(calID, id string, updated time.Time) repo.Event {
+		return repo.Event{ID: id, ICalUID: id + "@google.com", CalendarID: calID, UpdateTime: updated}
+	}
+
+	source := []repo.Event{event("cal-0", "a", at(0)), event("cal-0", "b", at(5))}
+	loaded := []loadedCalendar{
+		{id: "cal-0", events: source},
+		{id: "cal-1", events: []repo.Event{event("cal-1", "a", at(1)), event("cal-1", "b", at(2))}},
+		{id: "holidays", holiday: true, events: []repo.Event{event("holidays", "a", at(9))}},
+	}
+
+	duplicates := dedupMovedEvents(loaded)
+	require.Len(t, duplicates, 2)
+	assert.Equal(t, duplicateDiagnostics{CalendarID: "cal-0", EventID: "a", ICalUID: "a@google.com", UpdateTime: at(0), KeptCalendar: "cal-1"}, duplicates[0])
+	assert.Equal(t, duplicateDiagnostics{CalendarID: "cal-1", EventID: "b", ICalUID: "b@google.com", UpdateTime: at(2), KeptCalendar: "cal-0"}, duplicates[1])
+
+	assert.Equal(t, []repo.Event{event("cal-0", "b", at(5))}, loaded[0].events)
+	assert.Equal(t, []repo.Event{event("cal-1", "a", at(1))}, loaded[1].events)
+	assert.Len(t, loaded[2].events, 1)
+
+	// the events of the calendar are not modified in place
+	assert.Equal(t, "a", source[0].ID)
+}
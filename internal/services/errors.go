@@ -0,0 +1,91 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/repo"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+)
+
+// repoErrorDomain is the domain of the ErrorInfo details attached by
+// repoError.
+const repoErrorDomain = "calendar.tkd"
+
+// repoErrorCodes maps errors of the repository to the codes returned to
+// clients and the reasons of the attached ErrorInfo details.
+var repoErrorCodes = []struct {
+	err    error
+	code   connect.Code
+	reason string
+}{
+	{repo.ErrReadOnly, connect.CodePermissionDenied, "READ_ONLY"},
+	{repo.ErrNotFound, connect.CodeNotFound, "NOT_FOUND"},
+	{repo.ErrInvalidEvent, connect.CodeInvalidArgument, "INVALID_EVENT"},
+}
+
+// repoError maps errors returned by the repository to connect errors so
+// clients get the same code regardless of the code path that failed. An
+// ErrorInfo detail with the reason is attached, details of the original
+// error are kept. Other errors are returned unchanged.
+func repoError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	for _, m := range repoErrorCodes {
+		if !errors.Is(err, m.err) {
+			continue
+		}
+
+		var res *connect.Error
+
+		if connectErr, ok := err.(*connect.Error); ok {
+			if connectErr.Code() == m.code && hasErrorInfo(connectErr) {
+				return err
+			}
+
+			res = connect.NewError(m.code, connectErr.Unwrap())
+			for _, detail := range connectErr.Details() {
+				res.AddDetail(detail)
+			}
+		} else {
+			res = connect.NewError(m.code, err)
+		}
+
+		if detail, err := connect.NewErrorDetail(&errdetails.ErrorInfo{
+			Reason: m.reason,
+			Domain: repoErrorDomain,
+		}); err == nil {
+			res.AddDetail(detail)
+		}
+
+		return res
+	}
+
+	return err
+}
+
+// hasErrorInfo reports whether repoError already attached an ErrorInfo
+// detail to err.
+func hasErrorInfo(err *connect.Error) bool {
+	for _, detail := range err.Details() {
+		msg, err := detail.Value()
+		if err != nil {
+			continue
+		}
+
+		if info, ok := msg.(*errdetails.ErrorInfo); ok && info.Domain == repoErrorDomain {
+			return true
+		}
+	}
+
+	return false
+}
+
+// overlayReadOnlyError is returned for attempts to change read-only overlay
+// events.
+func overlayReadOnlyError() error {
+	return repoError(fmt.Errorf("%w: overlay events are read-only", repo.ErrReadOnly))
+}
@@ -0,0 +1,210 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	calendarv1 "github.com/tierklinik-dobersberg/apis/gen/go/tkd/calendar/v1"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/app"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/repo"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// failingRepo is a repo.Service that fails loading events with loadErr and
+// all other calls with err.
+type failingRepo struct {
+	repo.Service
+
+	loadErr error
+	err     error
+}
+
+func (f *failingRepo) ListEvents(context.Context, string, ...repo.SearchOption) ([]repo.Event, error) {
+	return nil, f.loadErr
+}
+
+func (f *failingRepo) LoadEvent(_ context.Context, calID, eventID string, _ bool) (*repo.Event, error) {
+	if f.loadErr != nil {
+		return nil, f.loadErr
+	}
+
+	start := time.Date(2024, time.June, 3, 8, 0, 0, 0, time.UTC)
+	end := start.Add(time.Hour)
+
+	return &repo.Event{ID: eventID, CalendarID: calID, Summary: "Bello", StartTime: start, EndTime: &end}, nil
+}
+
+func (f *failingRepo) CreateEvent(context.Context, string, string, string, time.Time, time.Duration, *repo.StructuredEvent, ...repo.CreateOption) (*repo.Event, error) {
+	return nil, f.err
+}
+
+func (f *failingRepo) UpdateEvent(context.Context, repo.Event) (*repo.Event, error) {
+	return nil, f.err
+}
+
+func (f *failingRepo) MoveEvent(context.Context, string, string, string, ...repo.MoveOption) (*repo.Event, error) {
+	return nil, f.err
+}
+
+func (f *failingRepo) DeleteEvent(context.Context, string, string) error {
+	return f.err
+}
+
+func Test_RepoError_Handlers(t *testing.T) {
+	start := time.Date(2024, time.June, 3, 8, 0, 0, 0, time.UTC)
+
+	handlers := []struct {
+		name string
+		// load is set if the handler loads the event before it is written
+		load bool
+		// write is set if the handler writes the event
+		write bool
+		call  func(*CalendarService) error
+	}{
+		{"ListEvents", true, false, func(svc *CalendarService) error {
+			_, err := svc.ListEvents(context.Background(), listEventsRequest(1))
+			return err
+		}},
+		{"CreateEvent", false, true, func(svc *CalendarService) error {
+			_, err := svc.CreateEvent(context.Background(), connect.NewRequest(&calendarv1.CreateEventRequest{
+				CalendarId: "cal-0",
+				Name:       "Bello",
+				Start:      timestamppb.New(start),
+				End:        timestamppb.New(start.Add(time.Hour)),
+			}))
+			return err
+		}},
+		{"UpdateEvent", true, true, func(svc *CalendarService) error {
+			_, err := svc.UpdateEvent(context.Background(), connect.NewRequest(&calendarv1.UpdateEventRequest{
+				CalendarId: "cal-0",
+				EventId:    "1",
+				Name:       "Rex",
+				UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"name"}},
+			}))
+			return err
+		}},
+		{"MoveEvent", false, true, func(svc *CalendarService) error {
+			_, err := svc.MoveEvent(context.Background(), connect.NewRequest(&calendarv1.MoveEventRequest{
+				EventId: "1",
+				Source:  &calendarv1.MoveEventRequest_SourceCalendarId{SourceCalendarId: "cal-0"},
+				Target:  &calendarv1.MoveEventRequest_TargetCalendarId{TargetCalendarId: "cal-1"},
+			}))
+			return err
+		}},
+		{"DeleteEvent", true, true, func(svc *CalendarService) error {
+			_, err := svc.DeleteEvent(context.Background(), connect.NewRequest(&calendarv1.DeleteEventRequest{
+				CalendarId: "cal-0",
+				EventId:    "1",
+			}))
+			return err
+		}},
+	}
+
+	repoErrors := []struct {
+		name   string
+		err    error
+		code   connect.Code
+		reason string
+	}{
+		{"read-only", fmt.Errorf("%w: calendar cal-0", repo.ErrReadOnly), connect.CodePermissionDenied, "READ_ONLY"},
+		{"read-only registry", connect.NewError(connect.CodePermissionDenied, fmt.Errorf("%w: calendar cal-0", repo.ErrReadOnly)), connect.CodePermissionDenied, "READ_ONLY"},
+		{"not found", connect.NewError(connect.CodeNotFound, fmt.Errorf("%w: gone", repo.ErrNotFound)), connect.CodeNotFound, "NOT_FOUND"},
+		{"invalid event", fmt.Errorf("%w: tags too large", repo.ErrInvalidEvent), connect.CodeInvalidArgument, "INVALID_EVENT"},
+		{"other", errors.New("upstream failure"), connect.CodeUnknown, ""},
+	}
+
+	for _, h := range handlers {
+		for _, e := range repoErrors {
+			for _, stage := range []string{"load", "write"} {
+				if (stage == "load" && !h.load) || (stage == "write" && !h.write) {
+					continue
+				}
+
+				t.Run(h.name+"/"+e.name+"/"+stage, func(t *testing.T) {
+					svc, _ := newMaskTestService(2, 0)
+
+					fake := new(failingRepo)
+					if stage == "load" {
+						fake.loadErr = e.err
+					} else {
+						fake.err = e.err
+					}
+
+					svc.repo = &app.App{Service: fake}
+					svc.events = fake
+					svc.slotLocks = newSlotLocks(time.Minute)
+
+					err := h.call(svc)
+					require.Error(t, err)
+					assert.Equal(t, e.code, connect.CodeOf(err))
+
+					if e.reason != "" {
+						assert.Equal(t, e.reason, errorInfoReason(t, err))
+					}
+				})
+			}
+		}
+	}
+}
+
+// errorInfoReason returns the reason of the ErrorInfo detail of err.
+func errorInfoReason(t *testing.T, err error) string {
+	t.Helper()
+
+	var connectErr *connect.Error
+	require.ErrorAs(t, err, &connectErr)
+
+	var reasons []string
+	for _, detail := range connectErr.Details() {
+		msg, err := detail.Value()
+		require.NoError(t, err)
+
+		if info, ok := msg.(*errdetails.ErrorInfo); ok {
+			reasons = append(reasons, info.Reason)
+		}
+	}
+
+	require.Len(t, reasons, 1)
+
+	return reasons[0]
+}
+
+func Test_RepoError(t *testing.T) {
+	assert.NoError(t, repoError(nil))
+
+	// mapping is idempotent
+	err := repoError(repoError(fmt.Errorf("%w: calendar cal-0", repo.ErrReadOnly)))
+	assert.Equal(t, connect.CodePermissionDenied, connect.CodeOf(err))
+	assert.Equal(t, "READ_ONLY", errorInfoReason(t, err))
+	assert.ErrorIs(t, err, repo.ErrReadOnly)
+
+	// details of the original error are kept
+	gone := connect.NewError(connect.CodeNotFound, fmt.Errorf("%w: gone", repo.ErrNotFound))
+	detail, derr := connect.NewErrorDetail(&calendarv1.CalendarEvent{Id: "1"})
+	require.NoError(t, derr)
+	gone.AddDetail(detail)
+
+	var connectErr *connect.Error
+	require.ErrorAs(t, repoError(gone), &connectErr)
+	assert.Len(t, connectErr.Details(), 2)
+	assert.Equal(t, "not found: gone", connectErr.Message())
+
+	// connect errors without repository errors are left alone
+	err = connect.NewError(connect.CodeAborted, errors.New("modified concurrently"))
+	assert.Same(t, err, repoError(err))
+
+	// HTTP handlers use the same mapping
+	assert.Equal(t, http.StatusForbidden, httpStatus(fmt.Errorf("%w: calendar cal-0", repo.ErrReadOnly)))
+	assert.Equal(t, http.StatusNotFound, httpStatus(fmt.Errorf("%w: calendar cal-0", repo.ErrNotFound)))
+	assert.Equal(t, http.StatusBadRequest, httpStatus(fmt.Errorf("%w: tags too large", repo.ErrInvalidEvent)))
+	assert.Equal(t, http.StatusForbidden, httpStatus(overlayReadOnlyError()))
+}
@@ -0,0 +1,139 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/config"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/i18n"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/repo"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+)
+
+// eventLimits bounds the duration and the start of created, updated and
+// moved events to catch typos like 10 hour vaccinations or appointments
+// years ahead. Zero values disable a limit.
+type eventLimits struct {
+	minDuration time.Duration
+	maxDuration time.Duration
+	maxHorizon  time.Duration
+	maxSpanDays int
+}
+
+// newEventLimits returns the event limits of cfg.
+func newEventLimits(cfg config.Config) eventLimits {
+	return eventLimits{
+		minDuration: cfg.Validation.MinDuration.AsDuration(),
+		maxDuration: cfg.Validation.MaxEventDuration.AsDuration(),
+		maxHorizon:  cfg.Validation.MaxFutureHorizon.AsDuration(),
+		maxSpanDays: cfg.Validation.MaxFullDaySpanDays,
+	}
+}
+
+// enabled reports whether any limit is configured.
+func (l eventLimits) enabled() bool {
+	return l != eventLimits{}
+}
+
+// spanDays returns the number of days evt spans in the time zone of its
+// start time. The end of full-day events is exclusive.
+func spanDays(evt repo.Event) int {
+	if evt.EndTime == nil {
+		return 1
+	}
+
+	start := evt.StartTime
+	end := evt.EndTime.In(start.Location())
+
+	// compare dates in UTC so daylight saving changes do not matter
+	startDate := time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, time.UTC)
+	endDate := time.Date(end.Year(), end.Month(), end.Day(), 0, 0, 0, 0, time.UTC)
+
+	days := int(endDate.Sub(startDate) / (24 * time.Hour))
+	if !end.Equal(time.Date(end.Year(), end.Month(), end.Day(), 0, 0, 0, 0, end.Location())) {
+		days++
+	}
+
+	return max(days, 1)
+}
+
+// check returns the first limit violated by evt or nil. Timed events longer
+// than a day are multi-day events and are checked against the span limit
+// instead of the duration limits.
+func (l eventLimits) check(lang i18n.Language, evt repo.Event, now time.Time) *errdetails.BadRequest_FieldViolation {
+	if l.maxHorizon > 0 {
+		if last := now.Add(l.maxHorizon); evt.StartTime.After(last) {
+			return &errdetails.BadRequest_FieldViolation{
+				Field:       "start",
+				Description: lang.Sprintf(i18n.EventTooFarAhead, last.Format("2006-01-02")),
+			}
+		}
+	}
+
+	if evt.EndTime == nil && !evt.FullDayEvent {
+		// open-ended events do not have a duration
+		return nil
+	}
+
+	if evt.FullDayEvent || evt.EndTime.Sub(evt.StartTime) > 24*time.Hour {
+		if l.maxSpanDays > 0 && spanDays(evt) > l.maxSpanDays {
+			return &errdetails.BadRequest_FieldViolation{
+				Field:       "end",
+				Description: lang.Sprintf(i18n.MultiDayEventTooLong, l.maxSpanDays),
+			}
+		}
+
+		return nil
+	}
+
+	duration := evt.EndTime.Sub(evt.StartTime)
+
+	if l.minDuration > 0 && duration < l.minDuration {
+		return &errdetails.BadRequest_FieldViolation{
+			Field:       "end",
+			Description: lang.Sprintf(i18n.EventTooShort, lang.Duration(l.minDuration)),
+		}
+	}
+
+	if l.maxDuration > 0 && duration > l.maxDuration {
+		return &errdetails.BadRequest_FieldViolation{
+			Field:       "end",
+			Description: lang.Sprintf(i18n.EventTooLong, lang.Duration(l.maxDuration)),
+		}
+	}
+
+	return nil
+}
+
+// checkEventLimits checks evt against the configured event limits. The
+// violated limit is returned as an InvalidArgument error with a BadRequest
+// detail. Callers with one of the bypass roles are not checked.
+func (svc *CalendarService) checkEventLimits(ctx context.Context, header http.Header, evt repo.Event) error {
+	if slices.ContainsFunc(header.Values("X-Remote-Role"), func(role string) bool {
+		return slices.Contains(svc.repo.Config.Validation.BypassRoles, role)
+	}) {
+		return nil
+	}
+
+	violation := newEventLimits(svc.repo.Config).check(i18n.FromContext(ctx), evt, time.Now())
+	if violation == nil {
+		return nil
+	}
+
+	slog.Info("rejected event outside of limits", "calendar-id", evt.CalendarID, "event-id", evt.ID, "field", violation.Field, "reason", violation.Description)
+
+	connectErr := connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("%s", violation.Description))
+
+	if detail, err := connect.NewErrorDetail(&errdetails.BadRequest{
+		FieldViolations: []*errdetails.BadRequest_FieldViolation{violation},
+	}); err == nil {
+		connectErr.AddDetail(detail)
+	}
+
+	return connectErr
+}
@@ -0,0 +1,117 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/config"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/i18n"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/repo"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+)
+
+func Test_EventLimits(t *testing.T) {
+	now := time.Date(2024, time.June, 3, 8, 0, 0, 0, time.UTC)
+	midnight := time.Date(2024, time.June, 3, 0, 0, 0, 0, time.UTC)
+
+	limits := eventLimits{
+		minDuration: 5 * time.Minute,
+		maxDuration: 8 * time.Hour,
+		maxHorizon:  365 * 24 * time.Hour,
+		maxSpanDays: 14,
+	}
+
+	timed := func(start time.Time, d time.Duration) repo.Event {
+		return repo.Event{StartTime: start, EndTime: ptr(start.Add(d))}
+	}
+
+	fullDay := func(days int) repo.Event {
+		return repo.Event{StartTime: midnight, EndTime: ptr(midnight.AddDate(0, 0, days)), FullDayEvent: true}
+	}
+
+	cases := []struct {
+		name   string
+		limits eventLimits
+		event  repo.Event
+		field  string
+	}{
+		{"minimum duration", limits, timed(now, 5*time.Minute), ""},
+		{"too short", limits, timed(now, 5*time.Minute-time.Second), "end"},
+		{"end before start", limits, timed(now, -time.Hour), "end"},
+		{"maximum duration", limits, timed(now, 8*time.Hour), ""},
+		{"too long", limits, timed(now, 8*time.Hour+time.Minute), "end"},
+		{"one day", limits, timed(now, 24*time.Hour), "end"},
+		{"multi-day", limits, timed(now, 24*time.Hour+time.Minute), ""},
+		{"multi-day too long", limits, timed(now, 14*24*time.Hour), "end"},
+		{"open end", limits, repo.Event{StartTime: now}, ""},
+		{"full-day without end", limits, repo.Event{StartTime: midnight, FullDayEvent: true}, ""},
+		{"full-day maximum span", limits, fullDay(14), ""},
+		{"full-day too long", limits, fullDay(15), "end"},
+		{"maximum horizon", limits, timed(now.Add(365*24*time.Hour), time.Hour), ""},
+		{"too far ahead", limits, timed(now.Add(365*24*time.Hour+time.Minute), time.Hour), "start"},
+		{"past", limits, timed(now.AddDate(-20, 0, 0), time.Hour), ""},
+		{"no limits", eventLimits{}, timed(now.AddDate(20, 0, 0), 100*time.Hour), ""},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			violation := c.limits.check(i18n.English, c.event, now)
+			if c.field == "" {
+				assert.Nil(t, violation)
+				return
+			}
+
+			require.NotNil(t, violation)
+			assert.Equal(t, c.field, violation.Field)
+			assert.NotEmpty(t, violation.Description)
+		})
+	}
+}
+
+func Test_SpanDays(t *testing.T) {
+	vienna, err := time.LoadLocation("Europe/Vienna")
+	require.NoError(t, err)
+
+	at := func(month time.Month, day, hour int) time.Time {
+		return time.Date(2024, month, day, hour, 0, 0, 0, vienna)
+	}
+
+	assert.Equal(t, 1, spanDays(repo.Event{StartTime: at(time.June, 3, 0)}))
+	assert.Equal(t, 1, spanDays(repo.Event{StartTime: at(time.June, 3, 0), EndTime: ptr(at(time.June, 4, 0))}))
+	assert.Equal(t, 2, spanDays(repo.Event{StartTime: at(time.June, 3, 8), EndTime: ptr(at(time.June, 4, 9))}))
+	// across the change to daylight saving time
+	assert.Equal(t, 3, spanDays(repo.Event{StartTime: at(time.March, 30, 0), EndTime: ptr(at(time.April, 2, 0))}))
+}
+
+func Test_CreateEvent_Limits(t *testing.T) {
+	svc, fake := newBookingTestService(t)
+
+	svc.repo.Config.Validation.MaxEventDuration = config.Duration(time.Hour)
+	svc.repo.Config.Validation.BypassRoles = []string{"admin"}
+
+	_, err := svc.CreateEvent(context.Background(), createEventRequest(t, "10:00", "12:00", "maier"))
+	require.Error(t, err)
+	assert.Equal(t, connect.CodeInvalidArgument, connect.CodeOf(err))
+	assert.Empty(t, fake.created)
+
+	var connectErr *connect.Error
+	require.ErrorAs(t, err, &connectErr)
+	require.Len(t, connectErr.Details(), 1)
+
+	detail, err := connectErr.Details()[0].Value()
+	require.NoError(t, err)
+	require.IsType(t, &errdetails.BadRequest{}, detail)
+	assert.Equal(t, "end", detail.(*errdetails.BadRequest).FieldViolations[0].Field)
+
+	// admins may bypass the limits
+	req := createEventRequest(t, "10:00", "12:00", "maier")
+	req.Header().Add("X-Remote-Role", "admin")
+
+	_, err = svc.CreateEvent(context.Background(), req)
+	require.NoError(t, err)
+	assert.Len(t, fake.created, 1)
+}
@@ -0,0 +1,262 @@
+package services
+
+import (
+	"encoding/csv"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tierklinik-dobersberg/cis-cal/internal/repo"
+)
+
+// exportColumn describes a column of the CSV event export.
+type exportColumn struct {
+	name  string
+	value func(cal repo.Calendar, e repo.Event) string
+}
+
+var exportColumns = []exportColumn{
+	{"calendar", func(cal repo.Calendar, _ repo.Event) string { return cal.Name }},
+	{"calendar_id", func(cal repo.Calendar, _ repo.Event) string { return cal.ID }},
+	{"event_id", func(_ repo.Calendar, e repo.Event) string { return e.ID }},
+	{"start", func(_ repo.Calendar, e repo.Event) string { return e.StartTime.Format(time.RFC3339) }},
+	{"end", func(_ repo.Calendar, e repo.Event) string {
+		if e.EndTime == nil {
+			return ""
+		}
+
+		return e.EndTime.Format(time.RFC3339)
+	}},
+	{"duration_minutes", func(_ repo.Calendar, e repo.Event) string {
+		if e.EndTime == nil {
+			return ""
+		}
+
+		return strconv.Itoa(int(e.EndTime.Sub(e.StartTime).Minutes()))
+	}},
+	{"full_day", func(_ repo.Calendar, e repo.Event) string { return strconv.FormatBool(e.FullDayEvent) }},
+	{"summary", func(_ repo.Calendar, e repo.Event) string { return e.Summary }},
+	{"customer_source", func(_ repo.Calendar, e repo.Event) string {
+		if e.Data == nil {
+			return ""
+		}
+
+		return e.Data.CustomerSource
+	}},
+	{"customer_id", func(_ repo.Calendar, e repo.Event) string {
+		if e.Data == nil {
+			return ""
+		}
+
+		return e.Data.CustomerID
+	}},
+	{"animal_ids", func(_ repo.Calendar, e repo.Event) string {
+		if e.Data == nil {
+			return ""
+		}
+
+		return strings.Join(e.Data.AnimalID, ",")
+	}},
+	{"created_by", func(_ repo.Calendar, e repo.Event) string {
+		if e.Data == nil {
+			return ""
+		}
+
+		return e.Data.CreatedBy
+	}},
+	{"source", func(_ repo.Calendar, e repo.Event) string { return e.Source }},
+	{"tags", func(_ repo.Calendar, e repo.Event) string { return strings.Join(e.Tags, ",") }},
+}
+
+var defaultExportColumns = []string{"calendar", "event_id", "start", "end", "duration_minutes", "customer_id", "created_by"}
+
+// maxExportRange is the maximum time range of a single export.
+const maxExportRange = 366 * 24 * time.Hour
+
+// ExportHandler serves a CSV export of all events in a time range:
+//
+//	GET /export/events.csv?from=2024-06-01&to=2024-07-01&calendar=<id>&columns=calendar,start
+//
+// Without calendar parameters all calendars are exported. Only callers
+// with one of the allowed roles (X-Remote-Role) may export events. Private
+// events are redacted unless the caller owns their calendar. Full-day
+// events are exported with their dates at midnight and a duration of whole
+// days. The export is streamed one calendar at a time and the response is
+// aborted if a calendar fails to load after the first one has been sent.
+type ExportHandler struct {
+	svc          *CalendarService
+	allowedRoles []string
+}
+
+// NewExportHandler returns a new export handler for svc.
+func NewExportHandler(svc *CalendarService, allowedRoles []string) *ExportHandler {
+	return &ExportHandler{
+		svc:          svc,
+		allowedRoles: allowedRoles,
+	}
+}
+
+func (h *ExportHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !authorize(w, r, h.allowedRoles) {
+		return
+	}
+
+	query := r.URL.Query()
+
+	from, err := time.ParseInLocation("2006-01-02", query.Get("from"), time.Local)
+	if err != nil {
+		http.Error(w, "invalid or missing value for from, expected YYYY-MM-DD", http.StatusBadRequest)
+		return
+	}
+
+	to, err := time.ParseInLocation("2006-01-02", query.Get("to"), time.Local)
+	if err != nil {
+		http.Error(w, "invalid or missing value for to, expected YYYY-MM-DD", http.StatusBadRequest)
+		return
+	}
+
+	if !to.After(from) || to.Sub(from) > maxExportRange {
+		http.Error(w, fmt.Sprintf("to must be after from and the range must not exceed %s", maxExportRange), http.StatusBadRequest)
+		return
+	}
+
+	columns, err := parseExportColumns(query.Get("columns"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	calendars := h.svc.selectCalendars(query["calendar"])
+	if len(calendars) == 0 {
+		http.Error(w, "no calendars to export", http.StatusNotFound)
+		return
+	}
+
+	lang := requestLanguage(r, h.svc.repo.Config.DefaultLanguage)
+	userID := r.Header.Get("X-Remote-User-ID")
+
+	csvWriter := csv.NewWriter(w)
+	flusher, _ := w.(http.Flusher)
+	row := make([]string, len(columns))
+
+	// calendars are loaded and written one at a time so large exports are
+	// streamed instead of buffered.
+	for idx, cal := range calendars {
+		events, err := h.svc.repo.ListEvents(r.Context(), cal.ID, repo.WithEventsAfter(from), repo.WithEventsBefore(to))
+		if err != nil {
+			slog.Error("failed to load events for export", "calendar-id", cal.ID, "error", err)
+
+			if idx == 0 {
+				http.Error(w, fmt.Sprintf("failed to load events of calendar %q", cal.ID), httpStatus(err))
+				return
+			}
+
+			// part of the export has already been sent so the response is
+			// aborted instead of ending it like a complete export.
+			panic(http.ErrAbortHandler)
+		}
+
+		sort.Stable(repo.ByStartTime(events))
+		events = localizeSummaries(events, lang)
+		events = h.svc.redactor().redact(events, userID, lang)
+
+		if idx == 0 {
+			w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+			w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=events-%s-%s.csv", from.Format("20060102"), to.Format("20060102")))
+
+			for i, c := range columns {
+				row[i] = c.name
+			}
+
+			if err := csvWriter.Write(row); err != nil {
+				return
+			}
+		}
+
+		for _, e := range events {
+			if !e.StartTime.Before(to) {
+				continue
+			}
+
+			for i, c := range columns {
+				row[i] = escapeFormula(c.value(cal, e))
+			}
+
+			if err := csvWriter.Write(row); err != nil {
+				return
+			}
+		}
+
+		csvWriter.Flush()
+		if err := csvWriter.Error(); err != nil {
+			slog.Error("failed to write event export", "error", err)
+			return
+		}
+
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+}
+
+// selectCalendars returns the requested calendars sorted by name. If ids is
+// empty all calendars are returned.
+func (svc *CalendarService) selectCalendars(ids []string) []repo.Calendar {
+	all, _ := svc.calendars.Get()
+
+	result := make([]repo.Calendar, 0, len(all))
+	for _, cal := range all {
+		if len(ids) == 0 || slices.Contains(ids, cal.ID) {
+			result = append(result, cal)
+		}
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+
+	return result
+}
+
+// parseExportColumns parses a comma separated list of column names.
+func parseExportColumns(value string) ([]exportColumn, error) {
+	names := defaultExportColumns
+	if value != "" {
+		names = strings.Split(value, ",")
+	}
+
+	result := make([]exportColumn, 0, len(names))
+	for _, name := range names {
+		idx := slices.IndexFunc(exportColumns, func(c exportColumn) bool {
+			return c.name == strings.TrimSpace(name)
+		})
+
+		if idx < 0 {
+			return nil, fmt.Errorf("unknown export column %q", name)
+		}
+
+		result = append(result, exportColumns[idx])
+	}
+
+	return result, nil
+}
+
+// escapeFormula prefixes values that spreadsheet applications would
+// interpret as a formula.
+func escapeFormula(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+
+	return value
+}
@@ -0,0 +1,254 @@
+package services
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	idmv1 "github.com/tierklinik-dobersberg/apis/gen/go/tkd/idm/v1"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/app"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/cache"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/repo"
+)
+
+func newExportTestHandler(t *testing.T) *ExportHandler {
+	t.Helper()
+
+	at := func(day, hour int) time.Time {
+		return time.Date(2024, time.June, day, hour, 0, 0, 0, time.Local)
+	}
+
+	fake := &bookingRepo{
+		events: map[string][]repo.Event{
+			"vet-2": {
+				{
+					ID:        "4",
+					Summary:   "Minka",
+					StartTime: at(3, 11),
+					EndTime:   ptr(at(3, 12)),
+				},
+			},
+			"vet-1": {
+				{
+					ID:        "1",
+					Summary:   "=HYPERLINK(\"http://example.com\")",
+					StartTime: at(3, 8),
+					EndTime:   ptr(at(3, 9)),
+					Data:      &repo.StructuredEvent{CustomerID: "huber", CreatedBy: "alice"},
+				},
+				{
+					ID:        "2",
+					Summary:   "Bello, \"the dog\"",
+					StartTime: at(4, 10),
+					EndTime:   ptr(at(4, 10).Add(45 * time.Minute)),
+				},
+				{
+					ID:         "3",
+					CalendarID: "vet-1",
+					Summary:    "Dr. Who",
+					Visibility: repo.VisibilityPrivate,
+					StartTime:  at(5, 9),
+					EndTime:    ptr(at(5, 10)),
+					Data:       &repo.StructuredEvent{CustomerID: "meier"},
+				},
+				{
+					ID:           "vacation",
+					Summary:      "Vacation",
+					StartTime:    at(6, 0),
+					EndTime:      ptr(at(7, 0)),
+					FullDayEvent: true,
+				},
+				{
+					ID:        "outside",
+					StartTime: at(30, 10),
+					EndTime:   ptr(at(30, 11)),
+				},
+			},
+		},
+	}
+
+	calendars := cache.NewCache("calendars", time.Minute, cache.LoaderFunc[repo.Calendar](func(ctx context.Context) ([]repo.Calendar, error) {
+		return []repo.Calendar{{ID: "vet-2", Name: "Dr. Zeller"}, {ID: "vet-1", Name: "Dr. Maier"}}, nil
+	}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	calendars.Start(ctx)
+	require.Eventually(t, func() bool {
+		list, _ := calendars.Get()
+		return len(list) > 0
+	}, time.Second, 10*time.Millisecond)
+
+	// alice owns vet-1
+	owners := cache.NewIndex(func(p *idmv1.Profile) (string, bool) {
+		return "vet-1", p.GetUser().GetId() == "alice"
+	})
+	owners.Update([]*idmv1.Profile{{User: &idmv1.User{Id: "alice"}}})
+
+	svc := &CalendarService{
+		repo:        &app.App{Service: fake},
+		calendars:   calendars,
+		userByCalId: owners,
+	}
+
+	return NewExportHandler(svc, []string{"admin"})
+}
+
+func exportRequest(h http.Handler, query string, roles ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/export/events.csv?"+query, nil)
+	req.Header.Set("X-Remote-User-ID", "reception")
+	for _, r := range roles {
+		req.Header.Add("X-Remote-Role", r)
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	return rec
+}
+
+func Test_ExportHandler(t *testing.T) {
+	h := newExportTestHandler(t)
+
+	rec := exportRequest(h, "from=2024-06-01&to=2024-06-10&columns=calendar,event_id,duration_minutes,summary,customer_id,created_by", "admin")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Equal(t, "text/csv; charset=utf-8", rec.Header().Get("Content-Type"))
+
+	records, err := csv.NewReader(rec.Body).ReadAll()
+	require.NoError(t, err)
+
+	assert.Equal(t, [][]string{
+		{"calendar", "event_id", "duration_minutes", "summary", "customer_id", "created_by"},
+		{"Dr. Maier", "1", "60", "'=HYPERLINK(\"http://example.com\")", "huber", "alice"},
+		{"Dr. Maier", "2", "45", "Bello, \"the dog\"", "", ""},
+		{"Dr. Maier", "3", "60", "Privat", "", ""},
+		{"Dr. Maier", "vacation", "1440", "Vacation", "", ""},
+		{"Dr. Zeller", "4", "60", "Minka", "", ""},
+	}, records)
+}
+
+func Test_ExportHandler_FullDay(t *testing.T) {
+	h := newExportTestHandler(t)
+
+	rec := exportRequest(h, "from=2024-06-06&to=2024-06-07&calendar=vet-1&columns=event_id,full_day,duration_minutes", "admin")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	records, err := csv.NewReader(rec.Body).ReadAll()
+	require.NoError(t, err)
+
+	assert.Equal(t, [][]string{
+		{"event_id", "full_day", "duration_minutes"},
+		{"vacation", "true", "1440"},
+	}, records)
+}
+
+func Test_ExportHandler_Private(t *testing.T) {
+	h := newExportTestHandler(t)
+
+	export := func(user string) []string {
+		req := httptest.NewRequest(http.MethodGet, "/export/events.csv?from=2024-06-05&to=2024-06-06&columns=event_id,summary,customer_id", nil)
+		req.Header.Set("X-Remote-User-ID", user)
+		req.Header.Set("X-Remote-Role", "admin")
+		req.Header.Set("Accept-Language", "en")
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+		records, err := csv.NewReader(rec.Body).ReadAll()
+		require.NoError(t, err)
+		require.Len(t, records, 2)
+
+		return records[1]
+	}
+
+	assert.Equal(t, []string{"3", "Dr. Who", "meier"}, export("alice"))
+
+	// the export role does not reveal private events of other calendars
+	assert.Equal(t, []string{"3", "Private", ""}, export("bob"))
+}
+
+func Test_ExportHandler_Errors(t *testing.T) {
+	h := newExportTestHandler(t)
+
+	cases := []struct {
+		query string
+		roles []string
+		code  int
+	}{
+		{"from=2024-06-01&to=2024-06-10", nil, http.StatusForbidden},
+		{"from=2024-06-01&to=2024-06-10", []string{"reception"}, http.StatusForbidden},
+		{"to=2024-06-10", []string{"admin"}, http.StatusBadRequest},
+		{"from=2024-06-10&to=2024-06-01", []string{"admin"}, http.StatusBadRequest},
+		{"from=2024-06-01&to=2026-06-01", []string{"admin"}, http.StatusBadRequest},
+		{"from=2024-06-01&to=2024-06-10&columns=password", []string{"admin"}, http.StatusBadRequest},
+		{"from=2024-06-01&to=2024-06-10&calendar=unknown", []string{"admin"}, http.StatusNotFound},
+	}
+
+	for _, c := range cases {
+		rec := exportRequest(h, c.query, c.roles...)
+		assert.Equal(t, c.code, rec.Code, c.query)
+	}
+}
+
+// failingCalendarRepo fails to list the events of one calendar.
+type failingCalendarRepo struct {
+	repo.Service
+
+	calendarID string
+}
+
+func (f failingCalendarRepo) ListEvents(ctx context.Context, calID string, filter ...repo.SearchOption) ([]repo.Event, error) {
+	if calID == f.calendarID {
+		return nil, errors.New("upstream unavailable")
+	}
+
+	return f.Service.ListEvents(ctx, calID, filter...)
+}
+
+func Test_ExportHandler_LoadFailure(t *testing.T) {
+	h := newExportTestHandler(t)
+	h.svc.repo = &app.App{Service: failingCalendarRepo{Service: h.svc.repo.Service, calendarID: "vet-1"}}
+
+	rec := exportRequest(h, "from=2024-06-01&to=2024-06-10", "admin")
+
+	// the failure is reported instead of sending a truncated export
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	assert.NotEqual(t, "text/csv; charset=utf-8", rec.Header().Get("Content-Type"))
+	assert.NotContains(t, rec.Body.String(), "calendar,event_id")
+	assert.Contains(t, rec.Body.String(), "vet-1")
+}
+
+func Test_ExportHandler_LoadFailureWhileStreaming(t *testing.T) {
+	h := newExportTestHandler(t)
+	h.svc.repo = &app.App{Service: failingCalendarRepo{Service: h.svc.repo.Service, calendarID: "vet-2"}}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Header.Set("X-Remote-User-ID", "reception")
+		r.Header.Set("X-Remote-Role", "admin")
+
+		h.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+
+	res, err := http.Get(srv.URL + "/export/events.csv?from=2024-06-01&to=2024-06-10")
+	require.NoError(t, err)
+	defer res.Body.Close()
+
+	// the first calendar has already been sent so the stream is aborted
+	// instead of ending like a complete export.
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	body, err := io.ReadAll(res.Body)
+	assert.Error(t, err)
+	assert.Contains(t, string(body), "calendar,event_id")
+	assert.NotContains(t, string(body), "Minka")
+}
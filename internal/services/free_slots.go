@@ -1,28 +1,105 @@
 package services
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"log/slog"
+	"slices"
 	"sort"
-	"strconv"
+	"strings"
 	"time"
 
+	"github.com/bufbuild/connect-go"
+	rosterv1 "github.com/tierklinik-dobersberg/apis/gen/go/tkd/roster/v1"
+	"github.com/tierklinik-dobersberg/apis/pkg/data"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/holidays"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/i18n"
 	"github.com/tierklinik-dobersberg/cis-cal/internal/repo"
 )
 
+// shiftBoundsHeader may be set to true on ListEvents requests to add
+// synthetic events for the working windows of each calendar. The
+// ListEventsRequest does not yet have a request kind for shift bounds.
+const shiftBoundsHeader = "X-Include-Shift-Bounds"
+
+// Id prefixes of synthetic free-slot and shift events. Those events only
+// exist in ListEvents responses and cannot be modified. Free-slot ids are
+// opaque, see freeSlotID.
+const (
+	freeSlotIDPrefix = "free-slot-"
+	shiftIDPrefix    = "shift-"
+)
+
+// pseudoEventError returns an InvalidArgument error if id belongs to a
+// synthetic free-slot, shift or holiday event.
+func pseudoEventError(id string) error {
+	if strings.HasPrefix(id, freeSlotIDPrefix) || strings.HasPrefix(id, shiftIDPrefix) {
+		return connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("%q is a synthetic free-slot or shift event and cannot be modified", id))
+	}
+
+	if strings.HasPrefix(id, holidayIDPrefix) {
+		return connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("%q is a public holiday and cannot be modified", id))
+	}
+
+	return nil
+}
+
+// freeSlotID returns the id of the free slot of calID between start and
+// end. The id only depends on the calendar and the bounds of the slot so the
+// same slot keeps its id across requests, even if other events are added or
+// removed. Clients must treat the id as opaque.
+func freeSlotID(calID string, start, end time.Time) string {
+	h := sha256.New()
+
+	fmt.Fprintf(h, "%s\x00%s\x00%s",
+		calID,
+		start.UTC().Format(time.RFC3339Nano),
+		end.UTC().Format(time.RFC3339Nano),
+	)
+
+	return freeSlotIDPrefix + hex.EncodeToString(h.Sum(nil)[:12])
+}
+
 type timeRange [2]time.Time
 
 func (tr timeRange) includes(t time.Time) bool {
 	return (tr[0].Equal(t) || tr[0].Before(t)) && tr[1].After(t)
 }
 
-func calculateFreeSlots(calID string, start time.Time, end time.Time, events []repo.Event) ([]repo.Event, []repo.Event, error) {
+// calculateFreeSlots returns the free slots between start and end. Events
+// without an end time are assumed to last for openEnd. The summaries of the
+// free slots are written in lang.
+func calculateFreeSlots(calID string, start time.Time, end time.Time, events []repo.Event, openEnd time.Duration, lang i18n.Language) ([]repo.Event, []repo.Event, error) {
+	summary := func(from, to time.Time) string {
+		return lang.Sprintf(i18n.FreeSlotSummary, lang.Duration(to.Sub(from)))
+	}
+
 	// find all events that are within start/end
 	filtered := make(repo.EventList, 0, len(events))
 
 	// get all events that are within start and end.
 	bounds := timeRange{start, end}
 	for _, evt := range events {
+		// working-location markers span whole days but do not block the
+		// calendar, even if they are timed events.
+		if evt.IsWorkingLocation() {
+			continue
+		}
+
+		// full-day absences block the calendar for the whole days. The
+		// dates of full-day events are parsed as UTC.
+		if evt.FullDayEvent && evt.IsAbsence() && evt.EndTime != nil {
+			evt.StartTime = time.Date(evt.StartTime.Year(), evt.StartTime.Month(), evt.StartTime.Day(), 0, 0, 0, 0, start.Location())
+			endOfAbsence := time.Date(evt.EndTime.Year(), evt.EndTime.Month(), evt.EndTime.Day(), 0, 0, 0, 0, start.Location())
+
+			evt.EndTime = &endOfAbsence
+			evt.FullDayEvent = false
+		}
+
+		evt.EndTime = evt.EndOrDefault(openEnd)
+
 		// skip full day events and events without an end date
 		if evt.EndTime == nil || evt.FullDayEvent || evt.EndTime.IsZero() {
 			continue
@@ -83,8 +160,8 @@ func calculateFreeSlots(calID string, start time.Time, end time.Time, events []r
 				CalendarID: calID,
 				StartTime:  startOfSlot,
 				EndTime:    &endOfSlot,
-				ID:         "free-slot-" + strconv.Itoa(i),
-				Summary:    "Freier Slot für " + endOfSlot.Sub(startOfSlot).String(),
+				ID:         freeSlotID(calID, startOfSlot, endOfSlot),
+				Summary:    summary(startOfSlot, endOfSlot),
 				IsFree:     true,
 			})
 		}
@@ -95,22 +172,22 @@ func calculateFreeSlots(calID string, start time.Time, end time.Time, events []r
 			slog.Info("found free slot at the end")
 
 			slots = append(slots, repo.Event{
-				ID:         "free-slot-end",
+				ID:         freeSlotID(calID, *last.EndTime, end),
 				CalendarID: calID,
 				StartTime:  *last.EndTime,
 				EndTime:    &end,
-				Summary:    "Freier Slot für " + end.Sub(*last.EndTime).String(),
+				Summary:    summary(*last.EndTime, end),
 				IsFree:     true,
 			})
 		}
 	} else {
 		// there are no filtered slots at all, so it seems like the whole time-range is free
 		slots = append(slots, repo.Event{
-			ID:         "free-slot-end",
+			ID:         freeSlotID(calID, start, end),
 			CalendarID: calID,
 			StartTime:  start,
 			EndTime:    &end,
-			Summary:    "Freier Slot für " + end.Sub(start).String(),
+			Summary:    summary(start, end),
 			IsFree:     true,
 		})
 	}
@@ -122,3 +199,226 @@ func calculateFreeSlots(calID string, start time.Time, end time.Time, events []r
 
 	return result, slots, nil
 }
+
+// workingWindow is a continuous time range built from one or more
+// overlapping or adjacent shifts.
+type workingWindow struct {
+	timeRange
+
+	// shifts holds all shifts of the window sorted by start time.
+	shifts []*rosterv1.PlannedShift
+}
+
+// shiftAt returns the shift of the window that covers t. If multiple
+// shifts cover t, the one that started last is returned.
+func (w workingWindow) shiftAt(t time.Time) *rosterv1.PlannedShift {
+	result := w.shifts[0]
+	for _, shift := range w.shifts[1:] {
+		if shift.From.AsTime().After(t) {
+			break
+		}
+
+		result = shift
+	}
+
+	return result
+}
+
+// mergeShifts merges overlapping and adjacent shifts into continuous
+// working windows. Shifts with the same unique id are only considered
+// once.
+func mergeShifts(shifts []*rosterv1.PlannedShift) []workingWindow {
+	sorted := make([]*rosterv1.PlannedShift, 0, len(shifts))
+	seen := make(map[string]struct{}, len(shifts))
+	for _, shift := range shifts {
+		if _, ok := seen[shift.UniqueId]; ok && shift.UniqueId != "" {
+			continue
+		}
+		seen[shift.UniqueId] = struct{}{}
+
+		sorted = append(sorted, shift)
+	}
+
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].From.AsTime().Before(sorted[j].From.AsTime())
+	})
+
+	var windows []workingWindow
+	for _, shift := range sorted {
+		from := shift.From.AsTime().Local()
+		to := shift.To.AsTime().Local()
+
+		if len(windows) > 0 {
+			last := &windows[len(windows)-1]
+
+			// the shift overlaps or touches the previous window
+			if !from.After(last.timeRange[1]) {
+				if to.After(last.timeRange[1]) {
+					last.timeRange[1] = to
+				}
+
+				last.shifts = append(last.shifts, shift)
+
+				continue
+			}
+		}
+
+		windows = append(windows, workingWindow{
+			timeRange: timeRange{from, to},
+			shifts:    []*rosterv1.PlannedShift{shift},
+		})
+	}
+
+	return windows
+}
+
+// dedupeSlots removes free slots that cover exactly the same time range.
+func dedupeSlots(slots []repo.Event) []repo.Event {
+	seen := make(map[[2]int64]struct{}, len(slots))
+	result := slots[:0]
+
+	for _, slot := range slots {
+		key := [2]int64{slot.StartTime.UnixNano(), slot.EndTime.UnixNano()}
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+
+		result = append(result, slot)
+	}
+
+	return result
+}
+
+// annotateFreeSlots records the owning user and shift on each free slot.
+// The slot ids are left untouched so slots keep the same id with and
+// without a roster.
+func annotateFreeSlots(slots []repo.Event, window workingWindow, info func(*rosterv1.PlannedShift) repo.FreeSlotInfo) {
+	for idx := range slots {
+		slotInfo := info(window.shiftAt(slots[idx].StartTime))
+
+		slots[idx].Slot = &slotInfo
+	}
+}
+
+// eventLister loads the events of a calendar.
+type eventLister interface {
+	ListEvents(ctx context.Context, calendarID string, filter ...repo.SearchOption) ([]repo.Event, error)
+}
+
+// ignoreTagsLister is an eventLister that drops all events with one of the
+// ignored tags. It is used to calculate free slots if events with some tags
+// should not count as busy.
+type ignoreTagsLister struct {
+	eventLister
+
+	tags []string
+}
+
+func (l ignoreTagsLister) ListEvents(ctx context.Context, calendarID string, filter ...repo.SearchOption) ([]repo.Event, error) {
+	events, err := l.eventLister.ListEvents(ctx, calendarID, filter...)
+	if err != nil {
+		return nil, err
+	}
+
+	result := events[:0]
+	for _, e := range events {
+		if !e.HasTag(l.tags...) {
+			result = append(result, e)
+		}
+	}
+
+	return result, nil
+}
+
+// freeSlotsForWindows loads the events of calID for exactly each working window
+// and returns the annotated free slots. Events without an end time are
+// assumed to last for openEnd. Windows for which events could not be
+// loaded or slots could not be calculated are returned in failed.
+func freeSlotsForWindows(ctx context.Context, lister eventLister, calID string, windows []workingWindow, openEnd time.Duration, info func(*rosterv1.PlannedShift) repo.FreeSlotInfo) (slots []repo.Event, failed []workingWindow) {
+	for _, window := range windows {
+		// open-ended events are only matched by their start time so extend
+		// the search range to include those that start before the window
+		events, err := lister.ListEvents(ctx, calID, repo.WithEventsAfter(window.timeRange[0].Add(-openEnd)), repo.WithEventsBefore(window.timeRange[1]))
+		if err != nil {
+			slog.Error("failed to load events for working window", "error", err, "calendar-id", calID, "start", window.timeRange[0], "end", window.timeRange[1])
+			failed = append(failed, window)
+
+			continue
+		}
+
+		_, free, err := calculateFreeSlots(calID, window.timeRange[0], window.timeRange[1], events, openEnd, i18n.FromContext(ctx))
+		if err != nil {
+			slog.Error("failed to calculate free slots", "error", err, "calendar-id", calID, "start", window.timeRange[0], "end", window.timeRange[1])
+			failed = append(failed, window)
+
+			continue
+		}
+
+		annotateFreeSlots(free, window, info)
+
+		slots = append(slots, free...)
+	}
+
+	return dedupeSlots(slots), failed
+}
+
+// suppressHolidaySlots removes all free slots that start on a holiday of one of
+// the given holiday types in country. The day of a slot is determined in loc.
+func suppressHolidaySlots(ctx context.Context, getter holidays.Getter, country string, loc *time.Location, types []string, slots []repo.Event) []repo.Event {
+	days := make([]time.Time, len(slots))
+	for idx, slot := range slots {
+		days[idx] = slot.StartTime.In(loc)
+	}
+
+	checks := holidays.Check(ctx, getter, country, days)
+	result := slots[:0]
+
+	for idx, slot := range slots {
+		check := checks[idx]
+
+		if check.Err != nil {
+			slog.Error("failed to check for public holiday, keeping free slot", "error", check.Err, "date", check.Date.Format("2006-01-02"))
+		}
+
+		if check.IsHoliday() && data.ElemInBothSlices(check.Holiday.Types, types) {
+			slog.Info("suppressing free slot on holiday", "calendar-id", slot.CalendarID, "date", check.Date.Format("2006-01-02"), "holiday", check.Holiday.LocalName)
+
+			continue
+		}
+
+		result = append(result, slot)
+	}
+
+	return result
+}
+
+// shiftBoundEvents returns a synthetic event for each working window. The
+// event ids are derived from the unique id of the first shift of the window.
+func shiftBoundEvents(calID string, windows []workingWindow, info func(*rosterv1.PlannedShift) repo.FreeSlotInfo) []repo.Event {
+	result := make([]repo.Event, 0, len(windows))
+
+	for _, window := range windows {
+		names := make([]string, 0, len(window.shifts))
+		for _, shift := range window.shifts {
+			if name := info(shift).ShiftName; name != "" && !slices.Contains(names, name) {
+				names = append(names, name)
+			}
+		}
+
+		slotInfo := info(window.shifts[0])
+		end := window.timeRange[1]
+
+		result = append(result, repo.Event{
+			ID:         shiftIDPrefix + window.shifts[0].UniqueId,
+			CalendarID: calID,
+			StartTime:  window.timeRange[0],
+			EndTime:    &end,
+			Summary:    strings.Join(names, ", "),
+			IsShift:    true,
+			Slot:       &slotInfo,
+		})
+	}
+
+	return result
+}
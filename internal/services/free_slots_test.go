@@ -1,12 +1,20 @@
 package services
 
 import (
+	"context"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	rosterv1 "github.com/tierklinik-dobersberg/apis/gen/go/tkd/roster/v1"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/holidays"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/i18n"
 	"github.com/tierklinik-dobersberg/cis-cal/internal/repo"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 func makeTime(ts string) time.Time {
@@ -95,7 +103,7 @@ func Test_FreeSlots(t *testing.T) {
 			})
 		}
 
-		_, result, err := calculateFreeSlots("", c.Range[0], c.Range[1], events)
+		_, result, err := calculateFreeSlots("", c.Range[0], c.Range[1], events, 0, i18n.German)
 		require.NoError(t, err)
 
 		slots := make([]timeRange, 0, len(result))
@@ -106,3 +114,492 @@ func Test_FreeSlots(t *testing.T) {
 		assert.Equal(t, c.Slots, slots)
 	}
 }
+
+func makeShift(id, start, end string) *rosterv1.PlannedShift {
+	from := makeTime(start)
+	to := makeTime(end)
+
+	// shifts ending before they start cross midnight
+	if to.Before(from) {
+		to = to.AddDate(0, 0, 1)
+	}
+
+	return &rosterv1.PlannedShift{
+		UniqueId:    id,
+		WorkShiftId: "workshift-" + id,
+		From:        timestamppb.New(from),
+		To:          timestamppb.New(to),
+	}
+}
+
+func Test_MergeShifts(t *testing.T) {
+	nextDay := func(ts string) time.Time {
+		return makeTime(ts).AddDate(0, 0, 1)
+	}
+
+	cases := []struct {
+		Name    string
+		Shifts  []*rosterv1.PlannedShift
+		Windows []timeRange
+		IDs     [][]string
+	}{
+		{
+			"adjacent",
+			[]*rosterv1.PlannedShift{makeShift("2", "12:00", "16:00"), makeShift("1", "08:00", "12:00")},
+			[]timeRange{makeRange("08:00", "16:00")},
+			[][]string{{"1", "2"}},
+		},
+		{
+			"overlapping",
+			[]*rosterv1.PlannedShift{makeShift("1", "08:00", "12:00"), makeShift("2", "11:00", "14:00")},
+			[]timeRange{makeRange("08:00", "14:00")},
+			[][]string{{"1", "2"}},
+		},
+		{
+			"contained",
+			[]*rosterv1.PlannedShift{makeShift("1", "08:00", "16:00"), makeShift("2", "10:00", "12:00")},
+			[]timeRange{makeRange("08:00", "16:00")},
+			[][]string{{"1", "2"}},
+		},
+		{
+			"disjoint",
+			[]*rosterv1.PlannedShift{makeShift("1", "08:00", "10:00"), makeShift("2", "14:00", "16:00")},
+			[]timeRange{makeRange("08:00", "10:00"), makeRange("14:00", "16:00")},
+			[][]string{{"1"}, {"2"}},
+		},
+		{
+			"crossing midnight",
+			[]*rosterv1.PlannedShift{makeShift("1", "20:00", "02:00"), makeShift("2", "16:00", "20:00")},
+			[]timeRange{{makeTime("16:00"), nextDay("02:00")}},
+			[][]string{{"2", "1"}},
+		},
+		{
+			"duplicate",
+			[]*rosterv1.PlannedShift{makeShift("1", "08:00", "12:00"), makeShift("1", "08:00", "12:00")},
+			[]timeRange{makeRange("08:00", "12:00")},
+			[][]string{{"1"}},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			windows := mergeShifts(c.Shifts)
+			require.Len(t, windows, len(c.Windows))
+
+			for idx, w := range windows {
+				assert.True(t, c.Windows[idx][0].Equal(w.timeRange[0]), "start %s != %s", c.Windows[idx][0], w.timeRange[0])
+				assert.True(t, c.Windows[idx][1].Equal(w.timeRange[1]), "end %s != %s", c.Windows[idx][1], w.timeRange[1])
+
+				ids := make([]string, 0, len(w.shifts))
+				for _, s := range w.shifts {
+					ids = append(ids, s.UniqueId)
+				}
+
+				assert.Equal(t, c.IDs[idx], ids)
+			}
+		})
+	}
+}
+
+func Test_FreeSlots_OpenEnd(t *testing.T) {
+	events := []repo.Event{
+		{StartTime: makeTime("09:00")},
+	}
+
+	// without a default duration open-ended events are ignored
+	_, slots, err := calculateFreeSlots("cal", makeTime("08:00"), makeTime("12:00"), events, 0, i18n.German)
+	require.NoError(t, err)
+	require.Len(t, slots, 1)
+
+	_, slots, err = calculateFreeSlots("cal", makeTime("08:00"), makeTime("12:00"), events, 30*time.Minute, i18n.German)
+	require.NoError(t, err)
+	require.Len(t, slots, 2)
+
+	assert.Equal(t, makeTime("08:00"), slots[0].StartTime)
+	assert.Equal(t, makeTime("09:00"), *slots[0].EndTime)
+	assert.Equal(t, makeTime("09:30"), slots[1].StartTime)
+	assert.Equal(t, makeTime("12:00"), *slots[1].EndTime)
+
+	// the original event is not modified
+	assert.Nil(t, events[0].EndTime)
+
+	pb, err := events[0].ToProto()
+	require.NoError(t, err)
+	assert.Nil(t, pb.EndTime)
+	assert.False(t, pb.FullDay)
+}
+
+func Test_AnnotateFreeSlots(t *testing.T) {
+	events := []repo.Event{
+		{StartTime: makeTime("06:00"), EndTime: ptr(makeTime("07:00"))},
+		{StartTime: makeTime("08:00"), EndTime: ptr(makeTime("09:00"))},
+	}
+
+	windows := mergeShifts([]*rosterv1.PlannedShift{
+		makeShift("shift-1", "06:00", "08:30"),
+		makeShift("shift-2", "08:30", "12:00"),
+	})
+	require.Len(t, windows, 1)
+
+	_, slots, err := calculateFreeSlots("cal", windows[0].timeRange[0], windows[0].timeRange[1], events, 0, i18n.German)
+	require.NoError(t, err)
+	require.Len(t, slots, 2)
+
+	ids := []string{slots[0].ID, slots[1].ID}
+
+	annotateFreeSlots(slots, windows[0], func(shift *rosterv1.PlannedShift) repo.FreeSlotInfo {
+		return repo.FreeSlotInfo{
+			UserID:      "user-1",
+			ShiftID:     shift.UniqueId,
+			WorkShiftID: shift.WorkShiftId,
+			ShiftName:   "Dienst " + shift.UniqueId,
+		}
+	})
+
+	// slots keep their ids and are attributed to the shift they start in
+	assert.Equal(t, ids, []string{slots[0].ID, slots[1].ID})
+	assert.Equal(t, freeSlotID("cal", makeTime("07:00"), makeTime("08:00")), slots[0].ID)
+	assert.Equal(t, freeSlotID("cal", makeTime("09:00"), makeTime("12:00")), slots[1].ID)
+
+	require.NotNil(t, slots[1].Slot)
+	assert.Equal(t, repo.FreeSlotInfo{
+		UserID:      "user-1",
+		ShiftID:     "shift-2",
+		WorkShiftID: "workshift-shift-2",
+		ShiftName:   "Dienst shift-2",
+	}, *slots[1].Slot)
+
+	// make sure the annotation is available in the protobuf representation
+	pb, err := slots[0].ToProto()
+	require.NoError(t, err)
+
+	var extra structpb.Struct
+	require.NoError(t, pb.ExtraData.UnmarshalTo(&extra))
+
+	assert.Equal(t, "user-1", extra.Fields["userId"].GetStringValue())
+	assert.Equal(t, "shift-1", extra.Fields["shiftId"].GetStringValue())
+	assert.Equal(t, "workshift-shift-1", extra.Fields["workShiftId"].GetStringValue())
+	assert.Equal(t, "Dienst shift-1", extra.Fields["shiftName"].GetStringValue())
+}
+
+func Test_FreeSlotIDs_AreStable(t *testing.T) {
+	events := []repo.Event{
+		{StartTime: makeTime("09:00"), EndTime: ptr(makeTime("10:00"))},
+	}
+
+	_, before, err := calculateFreeSlots("cal", makeTime("08:00"), makeTime("18:00"), events, 0, i18n.German)
+	require.NoError(t, err)
+	require.Len(t, before, 2)
+
+	// an unrelated event later in the day splits the last slot but does not
+	// change the id of the first one.
+	events = append(events, repo.Event{StartTime: makeTime("15:00"), EndTime: ptr(makeTime("16:00"))})
+
+	_, after, err := calculateFreeSlots("cal", makeTime("08:00"), makeTime("18:00"), events, 0, i18n.German)
+	require.NoError(t, err)
+	require.Len(t, after, 3)
+
+	assert.Equal(t, before[0].ID, after[0].ID)
+	assert.NotEqual(t, before[1].ID, after[1].ID)
+	assert.NotEqual(t, after[1].ID, after[2].ID)
+
+	// the end-of-list slot gets the same id as if it was found between events
+	assert.Equal(t, freeSlotID("cal", makeTime("16:00"), makeTime("18:00")), after[2].ID)
+
+	// the ids differ between calendars
+	_, other, err := calculateFreeSlots("other", makeTime("08:00"), makeTime("18:00"), events, 0, i18n.German)
+	require.NoError(t, err)
+	assert.NotEqual(t, after[0].ID, other[0].ID)
+	assert.True(t, strings.HasPrefix(other[0].ID, freeSlotIDPrefix))
+}
+
+func Test_DedupeSlots(t *testing.T) {
+	slots := []repo.Event{
+		{ID: "a", StartTime: makeTime("08:00"), EndTime: ptr(makeTime("09:00"))},
+		{ID: "b", StartTime: makeTime("08:00"), EndTime: ptr(makeTime("09:00"))},
+		{ID: "c", StartTime: makeTime("08:00"), EndTime: ptr(makeTime("10:00"))},
+	}
+
+	result := dedupeSlots(slots)
+
+	require.Len(t, result, 2)
+	assert.Equal(t, "a", result[0].ID)
+	assert.Equal(t, "c", result[1].ID)
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+type fakeLister struct {
+	events   []repo.Event
+	requests []timeRange
+}
+
+func (f *fakeLister) ListEvents(_ context.Context, _ string, filter ...repo.SearchOption) ([]repo.Event, error) {
+	opts := new(repo.EventSearchOptions)
+	for _, fn := range filter {
+		fn(opts)
+	}
+
+	f.requests = append(f.requests, timeRange{*opts.FromTime, *opts.ToTime})
+
+	var result []repo.Event
+	for _, e := range f.events {
+		if e.EndTime.After(*opts.FromTime) && e.StartTime.Before(*opts.ToTime) {
+			result = append(result, e)
+		}
+	}
+
+	return result, nil
+}
+
+func Test_FreeSlotsForWindows_MultipleDays(t *testing.T) {
+	day := func(offset int, ts string) time.Time {
+		return makeTime(ts).AddDate(0, 0, offset)
+	}
+
+	shiftOn := func(offset int) *rosterv1.PlannedShift {
+		id := strconv.Itoa(offset)
+
+		return &rosterv1.PlannedShift{
+			UniqueId: id,
+			From:     timestamppb.New(day(offset, "08:00")),
+			To:       timestamppb.New(day(offset, "12:00")),
+		}
+	}
+
+	// one event per day of the week, shifts only on three of them.
+	lister := new(fakeLister)
+	for i := 0; i < 7; i++ {
+		lister.events = append(lister.events, repo.Event{
+			StartTime: day(i, "09:00"),
+			EndTime:   ptr(day(i, "10:00")),
+		})
+	}
+
+	windows := mergeShifts([]*rosterv1.PlannedShift{shiftOn(0), shiftOn(2), shiftOn(5)})
+
+	slots, failed := freeSlotsForWindows(context.Background(), lister, "cal", windows, 0, func(shift *rosterv1.PlannedShift) repo.FreeSlotInfo {
+		return repo.FreeSlotInfo{ShiftID: shift.UniqueId}
+	})
+
+	assert.Empty(t, failed)
+
+	// events must be loaded for exactly each working window
+	require.Len(t, lister.requests, 3)
+	for idx, w := range windows {
+		assert.True(t, w.timeRange[0].Equal(lister.requests[idx][0]))
+		assert.True(t, w.timeRange[1].Equal(lister.requests[idx][1]))
+	}
+
+	got := make([]timeRange, 0, len(slots))
+	for _, s := range slots {
+		got = append(got, timeRange{s.StartTime.UTC(), s.EndTime.UTC()})
+	}
+
+	assert.Equal(t, []timeRange{
+		{day(0, "08:00"), day(0, "09:00")},
+		{day(0, "10:00"), day(0, "12:00")},
+		{day(2, "08:00"), day(2, "09:00")},
+		{day(2, "10:00"), day(2, "12:00")},
+		{day(5, "08:00"), day(5, "09:00")},
+		{day(5, "10:00"), day(5, "12:00")},
+	}, got)
+}
+
+func Test_FreeSlotsForWindows_IgnoreTags(t *testing.T) {
+	lister := &fakeLister{
+		events: []repo.Event{
+			{StartTime: makeTime("09:00"), EndTime: ptr(makeTime("10:00")), Tags: []string{"surgery"}},
+			{StartTime: makeTime("10:00"), EndTime: ptr(makeTime("11:00")), Tags: []string{"admin"}},
+		},
+	}
+
+	windows := mergeShifts([]*rosterv1.PlannedShift{{
+		UniqueId: "1",
+		From:     timestamppb.New(makeTime("08:00")),
+		To:       timestamppb.New(makeTime("12:00")),
+	}})
+
+	slots, failed := freeSlotsForWindows(context.Background(), ignoreTagsLister{eventLister: lister, tags: []string{"admin"}}, "cal", windows, 0, func(shift *rosterv1.PlannedShift) repo.FreeSlotInfo {
+		return repo.FreeSlotInfo{ShiftID: shift.UniqueId}
+	})
+	assert.Empty(t, failed)
+
+	got := make([]timeRange, 0, len(slots))
+	for _, s := range slots {
+		got = append(got, timeRange{s.StartTime.UTC(), s.EndTime.UTC()})
+	}
+
+	// the admin event does not block the calendar
+	assert.Equal(t, []timeRange{
+		{makeTime("08:00"), makeTime("09:00")},
+		{makeTime("10:00"), makeTime("12:00")},
+	}, got)
+}
+
+func Test_CalculateFreeSlots_Absences(t *testing.T) {
+	day := makeTime("00:00")
+	nextDay := day.AddDate(0, 0, 1)
+
+	// full-day events do not block the calendar, full-day absences do
+	_, free, err := calculateFreeSlots("cal", makeTime("08:00"), makeTime("12:00"), []repo.Event{
+		{StartTime: day, EndTime: &nextDay, FullDayEvent: true, EventType: repo.EventTypeDefault},
+	}, 0, i18n.German)
+	require.NoError(t, err)
+	assert.Len(t, free, 1)
+
+	_, free, err = calculateFreeSlots("cal", makeTime("08:00"), makeTime("12:00"), []repo.Event{
+		{StartTime: day, EndTime: &nextDay, FullDayEvent: true, EventType: repo.EventTypeOutOfOffice},
+	}, 0, i18n.German)
+	require.NoError(t, err)
+	assert.Empty(t, free)
+
+	// timed focus-time events block like any other event
+	_, free, err = calculateFreeSlots("cal", makeTime("08:00"), makeTime("12:00"), []repo.Event{
+		{StartTime: makeTime("08:00"), EndTime: ptr(makeTime("10:00")), EventType: repo.EventTypeFocusTime},
+	}, 0, i18n.German)
+	require.NoError(t, err)
+	require.Len(t, free, 1)
+	assert.True(t, free[0].StartTime.Equal(makeTime("10:00")))
+
+	assert.Len(t, withoutAbsences([]repo.Event{
+		{ID: "1", EventType: repo.EventTypeDefault},
+		{ID: "2", EventType: repo.EventTypeOutOfOffice},
+		{ID: "3", EventType: repo.EventTypeFocusTime},
+	}), 1)
+}
+
+func Test_ListEvents_ExcludeAbsences(t *testing.T) {
+	svc, fake := newMaskTestService(1, 3)
+	fake.events[1].EventType = repo.EventTypeOutOfOffice
+
+	req := listEventsRequest(1)
+
+	res, err := svc.ListEvents(context.Background(), req)
+	require.NoError(t, err)
+	require.Len(t, res.Msg.Results, 1)
+	assert.Len(t, res.Msg.Results[0].Events, 3)
+
+	req.Header().Set(excludeAbsencesHeader, "true")
+
+	res, err = svc.ListEvents(context.Background(), req)
+	require.NoError(t, err)
+	require.Len(t, res.Msg.Results, 1)
+	assert.Len(t, res.Msg.Results[0].Events, 2)
+}
+
+func Test_FreeSlotsForWindows_WorkingLocation(t *testing.T) {
+	// a timed working-location marker that covers the whole shift
+	lister := &fakeLister{
+		events: []repo.Event{
+			{StartTime: makeTime("00:00"), EndTime: ptr(makeTime("23:59")), EventType: repo.EventTypeWorkingLocation},
+			{StartTime: makeTime("09:00"), EndTime: ptr(makeTime("10:00")), EventType: repo.EventTypeDefault},
+		},
+	}
+
+	windows := mergeShifts([]*rosterv1.PlannedShift{{
+		UniqueId: "1",
+		From:     timestamppb.New(makeTime("08:00")),
+		To:       timestamppb.New(makeTime("12:00")),
+	}})
+
+	slots, failed := freeSlotsForWindows(context.Background(), lister, "cal", windows, 0, func(shift *rosterv1.PlannedShift) repo.FreeSlotInfo {
+		return repo.FreeSlotInfo{ShiftID: shift.UniqueId}
+	})
+	assert.Empty(t, failed)
+
+	got := make([]timeRange, 0, len(slots))
+	for _, s := range slots {
+		got = append(got, timeRange{s.StartTime.UTC(), s.EndTime.UTC()})
+	}
+
+	assert.Equal(t, []timeRange{
+		{makeTime("08:00"), makeTime("09:00")},
+		{makeTime("10:00"), makeTime("12:00")},
+	}, got)
+}
+
+func Test_SuppressHolidaySlots(t *testing.T) {
+	vienna, err := time.LoadLocation("Europe/Vienna")
+	require.NoError(t, err)
+
+	getter := holidays.NewFake(
+		holidays.PublicHoliday{Date: "2000-01-01", LocalName: "Neujahr", CountryCode: "AT", Types: []string{"Public"}},
+		holidays.PublicHoliday{Date: "2000-01-03", LocalName: "Gedenktag", CountryCode: "AT", Types: []string{"Observance"}},
+	)
+
+	slot := func(id string, start time.Time) repo.Event {
+		return repo.Event{ID: id, StartTime: start, EndTime: ptr(start.Add(time.Hour))}
+	}
+
+	slots := []repo.Event{
+		// 31.12.1999 23:30 UTC is already new year in Vienna
+		slot("new-year-local", time.Date(1999, time.December, 31, 23, 30, 0, 0, time.UTC)),
+		slot("new-year", time.Date(2000, time.January, 1, 10, 0, 0, 0, time.UTC)),
+		slot("workday", time.Date(2000, time.January, 2, 10, 0, 0, 0, time.UTC)),
+		slot("observance", time.Date(2000, time.January, 3, 10, 0, 0, 0, time.UTC)),
+	}
+
+	result := suppressHolidaySlots(context.Background(), getter, "AT", vienna, []string{"Public", "Bank"}, slots)
+
+	ids := make([]string, 0, len(result))
+	for _, r := range result {
+		ids = append(ids, r.ID)
+	}
+
+	assert.Equal(t, []string{"workday", "observance"}, ids)
+}
+
+func Test_ShiftBoundEvents(t *testing.T) {
+	windows := mergeShifts([]*rosterv1.PlannedShift{
+		makeShift("1", "08:00", "12:00"),
+		makeShift("2", "12:00", "16:00"),
+		makeShift("3", "18:00", "20:00"),
+	})
+
+	names := map[string]string{"1": "Vormittag", "2": "Nachmittag", "3": "Vormittag"}
+
+	events := shiftBoundEvents("cal", windows, func(shift *rosterv1.PlannedShift) repo.FreeSlotInfo {
+		return repo.FreeSlotInfo{ShiftID: shift.UniqueId, ShiftName: names[shift.UniqueId]}
+	})
+
+	require.Len(t, events, 2)
+
+	assert.Equal(t, "shift-1", events[0].ID)
+	assert.Equal(t, "Vormittag, Nachmittag", events[0].Summary)
+	assert.True(t, events[0].StartTime.Equal(makeTime("08:00")))
+	assert.True(t, events[0].EndTime.Equal(makeTime("16:00")))
+
+	assert.Equal(t, "shift-3", events[1].ID)
+	assert.Equal(t, "Vormittag", events[1].Summary)
+
+	for _, e := range events {
+		assert.True(t, e.IsShift)
+		assert.False(t, e.IsFree)
+	}
+}
+
+func Test_CalculateFreeSlots_Summary(t *testing.T) {
+	events := []repo.Event{
+		{StartTime: makeTime("09:00"), EndTime: ptr(makeTime("10:30"))},
+	}
+
+	cases := map[i18n.Language][]string{
+		i18n.German:  {"Freier Slot für 1 Std.", "Freier Slot für 1 Std. 30 Min."},
+		i18n.English: {"Free slot for 1h", "Free slot for 1h 30m"},
+	}
+
+	for lang, expected := range cases {
+		_, slots, err := calculateFreeSlots("cal", makeTime("08:00"), makeTime("12:00"), events, 0, lang)
+		require.NoError(t, err)
+
+		summaries := make([]string, 0, len(slots))
+		for _, s := range slots {
+			summaries = append(summaries, s.Summary)
+		}
+
+		assert.Equal(t, expected, summaries, lang)
+	}
+}
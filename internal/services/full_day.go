@@ -0,0 +1,132 @@
+package services
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/repo"
+)
+
+// fullDayPath is the UpdateEvent update-mask path that converts an event
+// between a timed and a full-day event. The new value is set using the
+// fullDayHeader as the UpdateEventRequest does not have a field for it yet.
+const (
+	fullDayPath   = "full_day"
+	fullDayHeader = "X-Full-Day"
+)
+
+// applyFullDay recomputes whether evt is a full-day event after the paths
+// of an update have been applied. wasFullDay is the state before the update.
+//
+// Without the fullDayPath a full-day event stays a full-day event as long
+// as its start and end are at midnight and becomes a timed event otherwise,
+// timed events always stay timed events. With the fullDayPath the event is
+// converted as requested: full-day events cover all days between start and
+// end and timed events keep the time of start and end, dates of a former
+// full-day event start at local midnight.
+func applyFullDay(evt *repo.Event, wasFullDay bool, paths []string, header http.Header) error {
+	var (
+		explicit = slices.Contains(paths, fullDayPath)
+		changed  = slices.Contains(paths, "start") || slices.Contains(paths, "end")
+		fullDay  = wasFullDay
+	)
+
+	if explicit {
+		v, err := strconv.ParseBool(header.Get(fullDayHeader))
+		if err != nil {
+			return connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("invalid or missing value for %s, required for update_mask path %q", fullDayHeader, fullDayPath))
+		}
+
+		fullDay = v
+	} else if !changed || !wasFullDay {
+		return nil
+	}
+
+	if !fullDay {
+		if wasFullDay {
+			if !slices.Contains(paths, "start") {
+				evt.StartTime = localDate(evt.StartTime)
+			}
+
+			if evt.EndTime != nil && !slices.Contains(paths, "end") {
+				end := localDate(*evt.EndTime)
+				evt.EndTime = &end
+			}
+		}
+
+		evt.FullDayEvent = false
+
+		return nil
+	}
+
+	start, ok := eventDate(evt.StartTime)
+	if !ok && !explicit {
+		evt.FullDayEvent = false
+		return nil
+	}
+
+	if !ok {
+		start = dateOf(evt.StartTime.In(time.Local))
+	}
+
+	end := start.AddDate(0, 0, 1)
+	if evt.EndTime != nil {
+		date, ok := eventDate(*evt.EndTime)
+
+		switch {
+		case !ok && !explicit:
+			evt.FullDayEvent = false
+			return nil
+
+		case !ok:
+			// the day of the end is covered as well
+			date = dateOf(evt.EndTime.In(time.Local)).AddDate(0, 0, 1)
+		}
+
+		if date.After(start) {
+			end = date
+		}
+	}
+
+	evt.StartTime = start
+	evt.EndTime = &end
+	evt.FullDayEvent = true
+
+	return nil
+}
+
+// eventDate returns the date of t as midnight UTC, the way the dates of
+// full-day events are returned by the backend. t must be at midnight in UTC
+// or in the local timezone.
+func eventDate(t time.Time) (time.Time, bool) {
+	if isMidnight(t.UTC()) {
+		return t.UTC(), true
+	}
+
+	if local := t.In(time.Local); isMidnight(local) {
+		return dateOf(local), true
+	}
+
+	return time.Time{}, false
+}
+
+// localDate returns midnight in the local timezone of the date of a
+// full-day event.
+func localDate(t time.Time) time.Time {
+	t = t.UTC()
+
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.Local)
+}
+
+// dateOf returns the date of t as midnight UTC.
+func dateOf(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+func isMidnight(t time.Time) bool {
+	return t.Hour() == 0 && t.Minute() == 0 && t.Second() == 0 && t.Nanosecond() == 0
+}
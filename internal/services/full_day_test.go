@@ -0,0 +1,152 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	calendarv1 "github.com/tierklinik-dobersberg/apis/gen/go/tkd/calendar/v1"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/app"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/repo"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func Test_UpdateEvent_FullDay(t *testing.T) {
+	day := func(d int) time.Time {
+		return time.Date(2024, time.June, d, 0, 0, 0, 0, time.UTC)
+	}
+
+	at := func(d, hour, minute int) time.Time {
+		return time.Date(2024, time.June, d, hour, minute, 0, 0, time.UTC)
+	}
+
+	cases := []struct {
+		name       string
+		fullDay    bool
+		start, end time.Time
+		paths      []string
+		header     string
+		newStart   time.Time
+		newEnd     *time.Time
+
+		expectedFullDay bool
+		expectedStart   time.Time
+		expectedEnd     *time.Time
+	}{
+		{
+			name:    "full-day to full-day",
+			fullDay: true, start: day(3), end: day(4),
+			paths: []string{"start", "end"}, newStart: day(5), newEnd: ptr(day(7)),
+			expectedFullDay: true, expectedStart: day(5), expectedEnd: ptr(day(7)),
+		},
+		{
+			name:    "full-day to full-day without an end",
+			fullDay: true, start: day(3), end: day(5),
+			paths: []string{"start", "end"}, newStart: day(10),
+			expectedFullDay: true, expectedStart: day(10), expectedEnd: ptr(day(11)),
+		},
+		{
+			name:    "full-day to timed by setting a time",
+			fullDay: true, start: day(3), end: day(4),
+			paths: []string{"start", "end"}, newStart: at(3, 10, 15), newEnd: ptr(at(3, 11, 15)),
+			expectedStart: at(3, 10, 15), expectedEnd: ptr(at(3, 11, 15)),
+		},
+		{
+			name:    "full-day to timed using the update mask",
+			fullDay: true, start: day(3), end: day(4),
+			paths: []string{fullDayPath}, header: "false",
+			expectedStart: time.Date(2024, time.June, 3, 0, 0, 0, 0, time.Local),
+			expectedEnd:   ptr(time.Date(2024, time.June, 4, 0, 0, 0, 0, time.Local)),
+		},
+		{
+			name:  "timed to full-day using the update mask",
+			start: at(3, 10, 15), end: at(3, 11, 15),
+			paths: []string{fullDayPath}, header: "true",
+			expectedFullDay: true, expectedStart: day(3), expectedEnd: ptr(day(4)),
+		},
+		{
+			name:  "timed to full-day covers all days",
+			start: at(3, 10, 15), end: at(3, 11, 15),
+			paths: []string{"start", "end", fullDayPath}, header: "true", newStart: at(3, 12, 15), newEnd: ptr(at(5, 9, 15)),
+			expectedFullDay: true, expectedStart: day(3), expectedEnd: ptr(day(6)),
+		},
+		{
+			name:  "timed to timed at midnight",
+			start: at(3, 10, 15), end: at(3, 11, 15),
+			paths: []string{"start", "end"}, newStart: day(4), newEnd: ptr(day(5)),
+			expectedStart: day(4), expectedEnd: ptr(day(5)),
+		},
+		{
+			name:  "timed to timed without an end",
+			start: at(3, 10, 15), end: at(3, 11, 15),
+			paths:         []string{"end"},
+			expectedStart: at(3, 10, 15),
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			svc, bookings := newBookingTestService(t)
+
+			fake := &memoryRepo{bookingRepo: bookings}
+			fake.events["vet-1"] = []repo.Event{{
+				ID:           "1",
+				CalendarID:   "vet-1",
+				Summary:      "Bello",
+				StartTime:    c.start,
+				EndTime:      ptr(c.end),
+				FullDayEvent: c.fullDay,
+			}}
+
+			svc.repo = &app.App{Service: fake}
+			svc.events = fake
+
+			req := connect.NewRequest(&calendarv1.UpdateEventRequest{
+				CalendarId: "vet-1",
+				EventId:    "1",
+				Start:      timestamppb.New(c.newStart),
+				UpdateMask: &fieldmaskpb.FieldMask{Paths: c.paths},
+			})
+			if c.newEnd != nil {
+				req.Msg.End = timestamppb.New(*c.newEnd)
+			}
+			if c.header != "" {
+				req.Header().Set(fullDayHeader, c.header)
+			}
+
+			res, err := svc.UpdateEvent(context.Background(), req)
+			require.NoError(t, err)
+
+			stored := fake.events["vet-1"][0]
+			assert.Equal(t, c.expectedFullDay, stored.FullDayEvent)
+			assert.Equal(t, c.expectedFullDay, res.Msg.Event.FullDay)
+			assert.True(t, c.expectedStart.Equal(stored.StartTime), "start %s", stored.StartTime)
+
+			if c.expectedEnd == nil {
+				assert.Nil(t, stored.EndTime)
+			} else if assert.NotNil(t, stored.EndTime) {
+				assert.True(t, c.expectedEnd.Equal(*stored.EndTime), "end %s", stored.EndTime)
+			}
+		})
+	}
+}
+
+func Test_UpdateEvent_FullDayHeader(t *testing.T) {
+	svc, bookings := newBookingTestService(t)
+
+	fake := &memoryRepo{bookingRepo: bookings}
+	svc.repo = &app.App{Service: fake}
+	svc.events = fake
+
+	_, err := svc.UpdateEvent(context.Background(), connect.NewRequest(&calendarv1.UpdateEventRequest{
+		CalendarId: "vet-2",
+		EventId:    "existing",
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{fullDayPath}},
+	}))
+	assert.Equal(t, connect.CodeInvalidArgument, connect.CodeOf(err))
+	assert.ErrorContains(t, err, fullDayHeader)
+}
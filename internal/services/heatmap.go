@@ -0,0 +1,194 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/tierklinik-dobersberg/cis-cal/internal/repo"
+)
+
+// maxHeatmapRange is the maximum time range of a single utilization
+// heatmap.
+const maxHeatmapRange = 53 * 7 * 24 * time.Hour
+
+// utilizationHeatmap holds the booked minutes per weekday and hour. The
+// first index of Minutes is the weekday, starting with monday, the second
+// one the hour of the day.
+type utilizationHeatmap struct {
+	CalendarID   string     `json:"calendarId,omitempty"`
+	CalendarName string     `json:"calendarName,omitempty"`
+	Minutes      [7][24]int `json:"minutes"`
+	booked       heatmapTime
+}
+
+type heatmapTime [7][24]time.Duration
+
+// add adds the time booked by e between from and to to the buckets. Only
+// events that actually block time are counted.
+func (b *heatmapTime) add(e repo.Event, from, to time.Time) {
+	if e.EndTime == nil || e.FullDayEvent || e.Transparent || e.IsAbsence() || e.IsWorkingLocation() {
+		return
+	}
+
+	start, end := e.StartTime, *e.EndTime
+	if start.Before(from) {
+		start = from
+	}
+
+	if end.After(to) {
+		end = to
+	}
+
+	for t := start.In(from.Location()); t.Before(end); {
+		next := time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+
+		// the hour may not exist at daylight saving time changes
+		if !next.After(t) {
+			next = t.Add(time.Hour)
+		}
+
+		if next.After(end) {
+			next = end
+		}
+
+		b[weekdayIndex(t.Weekday())][t.Hour()] += next.Sub(t)
+		t = next
+	}
+}
+
+func (h *utilizationHeatmap) finish() {
+	for day := range h.booked {
+		for hour, d := range h.booked[day] {
+			h.Minutes[day][hour] = int(d.Round(time.Minute) / time.Minute)
+		}
+	}
+}
+
+// weekdayIndex returns the index of day in a week starting on monday.
+func weekdayIndex(day time.Weekday) int {
+	return (int(day) + 6) % 7
+}
+
+// HeatmapHandler serves the booked minutes per weekday and hour over a
+// time range, used to plan opening hours:
+//
+//	GET /events/heatmap?from=2024-04-01&to=2024-06-01&calendar=<id>&aggregate=true
+//
+// Without calendar parameters all calendars are included. If aggregate is
+// set a single heatmap over all calendars is returned. Full-day,
+// transparent, out-of-office and working-location events are not counted.
+// Days holds the number of times each weekday is part of the range so
+// clients can compute averages. Only callers with one of the allowed roles
+// (X-Remote-Role) may request the heatmap.
+type HeatmapHandler struct {
+	svc          *CalendarService
+	allowedRoles []string
+}
+
+// NewHeatmapHandler returns a new heatmap handler for svc.
+func NewHeatmapHandler(svc *CalendarService, allowedRoles []string) *HeatmapHandler {
+	return &HeatmapHandler{
+		svc:          svc,
+		allowedRoles: allowedRoles,
+	}
+}
+
+func (h *HeatmapHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !authorize(w, r, h.allowedRoles) {
+		return
+	}
+
+	query := r.URL.Query()
+
+	from, err := time.ParseInLocation("2006-01-02", query.Get("from"), time.Local)
+	if err != nil {
+		http.Error(w, "invalid or missing value for from, expected YYYY-MM-DD", http.StatusBadRequest)
+		return
+	}
+
+	to, err := time.ParseInLocation("2006-01-02", query.Get("to"), time.Local)
+	if err != nil {
+		http.Error(w, "invalid or missing value for to, expected YYYY-MM-DD", http.StatusBadRequest)
+		return
+	}
+
+	if !to.After(from) || to.Sub(from) > maxHeatmapRange {
+		http.Error(w, fmt.Sprintf("to must be after from and the range must not exceed %s", maxHeatmapRange), http.StatusBadRequest)
+		return
+	}
+
+	var aggregate bool
+	if v := query.Get("aggregate"); v != "" {
+		if aggregate, err = strconv.ParseBool(v); err != nil {
+			http.Error(w, "invalid value for aggregate", http.StatusBadRequest)
+			return
+		}
+	}
+
+	calendars := h.svc.selectCalendars(query["calendar"])
+	if len(calendars) == 0 {
+		http.Error(w, "no calendars found", http.StatusNotFound)
+		return
+	}
+
+	result := struct {
+		From      string                `json:"from"`
+		To        string                `json:"to"`
+		Days      [7]int                `json:"days"`
+		Calendars []*utilizationHeatmap `json:"calendars"`
+	}{
+		From: from.Format("2006-01-02"),
+		To:   to.Format("2006-01-02"),
+	}
+
+	for d := from; d.Before(to); d = d.AddDate(0, 0, 1) {
+		result.Days[weekdayIndex(d.Weekday())]++
+	}
+
+	total := new(utilizationHeatmap)
+
+	for _, cal := range calendars {
+		// the events of each calendar are only kept until they are counted,
+		// the heatmap never holds more than one calendar.
+		events, err := h.svc.repo.ListEvents(r.Context(), cal.ID, repo.WithEventsAfter(from), repo.WithEventsBefore(to))
+		if err != nil {
+			slog.Error("failed to load events for heatmap", "calendar-id", cal.ID, "error", err)
+			http.Error(w, "failed to load events", http.StatusInternalServerError)
+
+			return
+		}
+
+		heatmap := total
+		if !aggregate {
+			heatmap = &utilizationHeatmap{CalendarID: cal.ID, CalendarName: cal.Name}
+			result.Calendars = append(result.Calendars, heatmap)
+		}
+
+		for _, e := range events {
+			heatmap.booked.add(e, from, to)
+		}
+	}
+
+	if aggregate {
+		result.Calendars = []*utilizationHeatmap{total}
+	}
+
+	for _, heatmap := range result.Calendars {
+		heatmap.finish()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		slog.Error("failed to encode heatmap", "error", err)
+	}
+}
@@ -0,0 +1,151 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/app"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/cache"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/repo"
+)
+
+func newHeatmapTestHandler(t *testing.T) *HeatmapHandler {
+	t.Helper()
+
+	// 2024-06-03 is a monday
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2024, time.June, day, hour, minute, 0, 0, time.Local)
+	}
+
+	fake := &bookingRepo{
+		events: map[string][]repo.Event{
+			"vet-1": {
+				// spans two hours
+				{ID: "1", StartTime: at(3, 8, 30), EndTime: ptr(at(3, 9, 15))},
+				{ID: "2", StartTime: at(10, 8, 0), EndTime: ptr(at(10, 9, 0))},
+				// not counted
+				{ID: "transparent", StartTime: at(3, 10, 0), EndTime: ptr(at(3, 11, 0)), Transparent: true},
+				{ID: "ooo", StartTime: at(3, 10, 0), EndTime: ptr(at(3, 11, 0)), EventType: repo.EventTypeOutOfOffice},
+				{ID: "full-day", StartTime: at(4, 0, 0), EndTime: ptr(at(5, 0, 0)), FullDayEvent: true},
+				// clipped to the range
+				{ID: "late", StartTime: at(16, 23, 30), EndTime: ptr(at(17, 0, 30))},
+			},
+			"vet-2": {
+				{ID: "3", StartTime: at(3, 8, 0), EndTime: ptr(at(3, 8, 20))},
+			},
+		},
+	}
+
+	calendars := cache.NewCache("calendars", time.Minute, cache.LoaderFunc[repo.Calendar](func(ctx context.Context) ([]repo.Calendar, error) {
+		return []repo.Calendar{{ID: "vet-1", Name: "Dr. Maier"}, {ID: "vet-2", Name: "Dr. Huber"}}, nil
+	}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	calendars.Start(ctx)
+	require.Eventually(t, func() bool {
+		list, _ := calendars.Get()
+		return len(list) > 0
+	}, time.Second, 10*time.Millisecond)
+
+	svc := &CalendarService{
+		repo:      &app.App{Service: fake},
+		calendars: calendars,
+	}
+
+	return NewHeatmapHandler(svc, []string{"management"})
+}
+
+type heatmapResponse struct {
+	Days      [7]int               `json:"days"`
+	Calendars []utilizationHeatmap `json:"calendars"`
+}
+
+func heatmapRequest(t *testing.T, h http.Handler, query string, roles ...string) (*httptest.ResponseRecorder, heatmapResponse) {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodGet, "/events/heatmap?"+query, nil)
+	req.Header.Set("X-Remote-User-ID", "alice")
+	for _, r := range roles {
+		req.Header.Add("X-Remote-Role", r)
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	var res heatmapResponse
+	if rec.Code == http.StatusOK {
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+	}
+
+	return rec, res
+}
+
+func Test_HeatmapHandler(t *testing.T) {
+	h := newHeatmapTestHandler(t)
+
+	rec, res := heatmapRequest(t, h, "from=2024-06-03&to=2024-06-17", "management")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	assert.Equal(t, [7]int{2, 2, 2, 2, 2, 2, 2}, res.Days)
+	require.Len(t, res.Calendars, 2)
+
+	// calendars are sorted by name
+	assert.Equal(t, "vet-2", res.Calendars[0].CalendarID)
+	assert.Equal(t, 20, res.Calendars[0].Minutes[0][8])
+
+	vet1 := res.Calendars[1]
+	assert.Equal(t, "Dr. Maier", vet1.CalendarName)
+	assert.Equal(t, 90, vet1.Minutes[0][8])
+	assert.Equal(t, 15, vet1.Minutes[0][9])
+	assert.Equal(t, 30, vet1.Minutes[6][23])
+
+	var total int
+	for _, day := range vet1.Minutes {
+		for _, minutes := range day {
+			total += minutes
+		}
+	}
+	assert.Equal(t, 135, total)
+
+	rec, res = heatmapRequest(t, h, "from=2024-06-03&to=2024-06-17&aggregate=true", "management")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	require.Len(t, res.Calendars, 1)
+	assert.Empty(t, res.Calendars[0].CalendarID)
+	assert.Equal(t, 110, res.Calendars[0].Minutes[0][8])
+
+	rec, res = heatmapRequest(t, h, "from=2024-06-03&to=2024-06-17&calendar=vet-2", "management")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	require.Len(t, res.Calendars, 1)
+	assert.Equal(t, "vet-2", res.Calendars[0].CalendarID)
+}
+
+func Test_HeatmapHandler_Errors(t *testing.T) {
+	h := newHeatmapTestHandler(t)
+
+	cases := []struct {
+		query string
+		roles []string
+		code  int
+	}{
+		{"from=2024-06-03&to=2024-06-17", nil, http.StatusForbidden},
+		{"from=2024-06-03&to=2024-06-17", []string{"reception"}, http.StatusForbidden},
+		{"to=2024-06-17", []string{"management"}, http.StatusBadRequest},
+		{"from=2024-06-17&to=2024-06-03", []string{"management"}, http.StatusBadRequest},
+		{"from=2024-06-03&to=2026-06-03", []string{"management"}, http.StatusBadRequest},
+		{"from=2024-06-03&to=2024-06-17&aggregate=maybe", []string{"management"}, http.StatusBadRequest},
+		{"from=2024-06-03&to=2024-06-17&calendar=unknown", []string{"management"}, http.StatusNotFound},
+	}
+
+	for _, c := range cases {
+		rec, _ := heatmapRequest(t, h, c.query, c.roles...)
+		assert.Equal(t, c.code, rec.Code, c.query)
+	}
+}
@@ -0,0 +1,111 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	calendarv1 "github.com/tierklinik-dobersberg/apis/gen/go/tkd/calendar/v1"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/holidays"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/i18n"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/repo"
+)
+
+// holidayCalendarID is a virtual, read-only calendar that contains the public
+// holidays of the configured default country as full-day events. The
+// holidays of another country are available as "holidays:<country>", like
+// "holidays:DE". The holiday calendar is never part of the AllCalendars or
+// AllUsers sources unless includeHolidaysHeader is set.
+const holidayCalendarID = "holidays"
+
+// includeHolidaysHeader may be set to true on ListEvents requests to add the
+// holiday calendar of the default country to the results, independent of
+// the requested source. The ListEventsRequest does not yet have a field for
+// it.
+const includeHolidaysHeader = "X-Include-Holidays"
+
+// holidayIDPrefix is the id prefix of the events of the holiday calendar.
+const holidayIDPrefix = "holiday-"
+
+// holidayCountry returns the country of the virtual holiday calendar calID.
+// It returns false if calID is not a holiday calendar.
+func holidayCountry(calID, defaultCountry string) (string, bool) {
+	if calID == holidayCalendarID {
+		return defaultCountry, true
+	}
+
+	country, ok := strings.CutPrefix(calID, holidayCalendarID+":")
+	if !ok || country == "" {
+		return "", false
+	}
+
+	return strings.ToUpper(country), true
+}
+
+// holidayRange returns the days to list holidays for. Open ends of the
+// requested range are limited to a year.
+func holidayRange(start, end, now time.Time) (time.Time, time.Time) {
+	switch {
+	case start.IsZero() && end.IsZero():
+		start = time.Date(now.Year(), time.January, 1, 0, 0, 0, 0, time.Local)
+		end = start.AddDate(1, 0, 0)
+	case start.IsZero():
+		start = end.AddDate(-1, 0, 0)
+	case end.IsZero():
+		end = start.AddDate(1, 0, 0)
+	}
+
+	return start.Local(), end.Local()
+}
+
+// holidayEvents returns the public holidays of country between start and end
+// as full-day events of calID. Holidays are named in lang.
+func holidayEvents(ctx context.Context, getter holidays.Getter, calID, country string, start, end time.Time, lang i18n.Language) ([]repo.Event, error) {
+	var events []repo.Event
+
+	for year := start.Year(); year <= end.Year(); year++ {
+		list, err := getter.Get(ctx, country, year)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load public holidays for %s in %d: %w", country, year, err)
+		}
+
+		for _, h := range list {
+			day, err := time.ParseInLocation("2006-01-02", h.Date, time.Local)
+			if err != nil {
+				return nil, fmt.Errorf("invalid public holiday date %q: %w", h.Date, err)
+			}
+
+			nextDay := day.AddDate(0, 0, 1)
+			if !nextDay.After(start) || !day.Before(end) {
+				continue
+			}
+
+			name := h.LocalName
+			if lang != i18n.German && h.Name != "" {
+				name = h.Name
+			}
+
+			events = append(events, repo.Event{
+				ID:           holidayIDPrefix + country + "-" + h.Date,
+				CalendarID:   calID,
+				Summary:      name,
+				StartTime:    day,
+				EndTime:      &nextDay,
+				FullDayEvent: true,
+				Transparent:  true,
+			})
+		}
+	}
+
+	return events, nil
+}
+
+// holidayCalendar returns the metadata of the virtual holiday calendar.
+func holidayCalendar(calID, country string, lang i18n.Language) *calendarv1.Calendar {
+	return &calendarv1.Calendar{
+		Id:       calID,
+		Name:     lang.Sprintf(i18n.HolidayCalendarName, country),
+		Timezone: time.Local.String(),
+	}
+}
@@ -0,0 +1,135 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	calendarv1 "github.com/tierklinik-dobersberg/apis/gen/go/tkd/calendar/v1"
+	commonv1 "github.com/tierklinik-dobersberg/apis/gen/go/tkd/common/v1"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/cache"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/holidays"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/i18n"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/repo"
+)
+
+func newHolidayCalendarTestService() (*CalendarService, *countingRepo) {
+	svc, fake := newMaskTestService(2, 1)
+
+	svc.repo.Config.DefaultCountry = "AT"
+	svc.holidays = holidays.NewFake(
+		holidays.PublicHoliday{Date: "2024-12-24", LocalName: "Heiliger Abend", Name: "Christmas Eve", CountryCode: "AT"},
+		holidays.PublicHoliday{Date: "2024-12-25", LocalName: "Christtag", Name: "Christmas Day", CountryCode: "AT"},
+		holidays.PublicHoliday{Date: "2024-12-26", LocalName: "Stefanitag", Name: "St. Stephen's Day", CountryCode: "AT"},
+		holidays.PublicHoliday{Date: "2025-01-01", LocalName: "Neujahr", Name: "New Year's Day", CountryCode: "AT"},
+		holidays.PublicHoliday{Date: "2025-01-06", LocalName: "Heilige Drei Könige", Name: "Epiphany", CountryCode: "AT"},
+		holidays.PublicHoliday{Date: "2025-01-01", LocalName: "Neujahr", Name: "New Year's Day", CountryCode: "DE"},
+	)
+
+	return svc, fake
+}
+
+func holidayRangeRequest(source *calendarv1.EventSource, kinds ...calendarv1.CalenarEventRequestKind) *connect.Request[calendarv1.ListEventsRequest] {
+	return connect.NewRequest(&calendarv1.ListEventsRequest{
+		Source: &calendarv1.ListEventsRequest_Sources{Sources: source},
+		SearchTime: &calendarv1.ListEventsRequest_TimeRange{
+			TimeRange: commonv1.NewTimeRange(
+				time.Date(2024, 12, 25, 0, 0, 0, 0, time.Local),
+				time.Date(2025, 1, 2, 0, 0, 0, 0, time.Local),
+			),
+		},
+		RequestKinds: append(kinds, calendarv1.CalenarEventRequestKind_CALENDAR_EVENT_REQUEST_KIND_EVENTS),
+	})
+}
+
+func Test_ListEvents_HolidayCalendar(t *testing.T) {
+	svc, fake := newHolidayCalendarTestService()
+
+	res, err := svc.ListEvents(context.Background(), holidayRangeRequest(&calendarv1.EventSource{
+		CalendarIds: []string{holidayCalendarID},
+	}))
+	require.NoError(t, err)
+	require.Len(t, res.Msg.Results, 1)
+	assert.Zero(t, fake.calls, "the holiday calendar must not be queried from the backend")
+
+	result := res.Msg.Results[0]
+	assert.Equal(t, holidayCalendarID, result.Calendar.Id)
+	assert.Equal(t, "Feiertage (AT)", result.Calendar.Name)
+
+	var names []string
+	for _, e := range result.Events {
+		names = append(names, e.Summary)
+		assert.True(t, e.FullDay, e.Summary)
+	}
+	assert.Equal(t, []string{"Christtag", "Stefanitag", "Neujahr"}, names)
+	assert.Equal(t, "holiday-AT-2025-01-01", result.Events[2].Id)
+
+	// holidays are named in the requested language and the country may be
+	// set in the calendar id.
+	ctx := i18n.WithLanguage(context.Background(), i18n.English)
+
+	res, err = svc.ListEvents(ctx, holidayRangeRequest(&calendarv1.EventSource{
+		CalendarIds: []string{"holidays:de"},
+	}))
+	require.NoError(t, err)
+	require.Len(t, res.Msg.Results, 1)
+	require.Len(t, res.Msg.Results[0].Events, 1)
+	assert.Equal(t, "New Year's Day", res.Msg.Results[0].Events[0].Summary)
+	assert.Equal(t, "Public holidays (DE)", res.Msg.Results[0].Calendar.Name)
+}
+
+func Test_ListEvents_HolidayCalendar_AllCalendars(t *testing.T) {
+	svc, _ := newHolidayCalendarTestService()
+
+	all := func(kinds ...calendarv1.CalenarEventRequestKind) *connect.Request[calendarv1.ListEventsRequest] {
+		req := holidayRangeRequest(nil, kinds...)
+		req.Msg.Source = &calendarv1.ListEventsRequest_AllCalendars{AllCalendars: true}
+
+		return req
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	svc.calendars = cache.NewCache[repo.Calendar]("calendars", time.Minute, cache.LoaderFunc[repo.Calendar](func(context.Context) ([]repo.Calendar, error) {
+		return []repo.Calendar{{ID: "cal-0"}, {ID: "cal-1"}}, nil
+	}))
+	svc.calendars.Start(ctx)
+
+	require.Eventually(t, func() bool {
+		cals, _ := svc.calendars.Get()
+		return len(cals) == 2
+	}, time.Second, 10*time.Millisecond)
+
+	res, err := svc.ListEvents(context.Background(), all())
+	require.NoError(t, err)
+	require.Len(t, res.Msg.Results, 2)
+	for _, r := range res.Msg.Results {
+		assert.NotEqual(t, holidayCalendarID, r.Calendar.GetId())
+	}
+
+	req := all()
+	req.Header().Set(includeHolidaysHeader, "true")
+
+	res, err = svc.ListEvents(context.Background(), req)
+	require.NoError(t, err)
+
+	var found bool
+	for _, r := range res.Msg.Results {
+		found = found || r.Calendar.GetId() == holidayCalendarID
+	}
+	assert.True(t, found, "the holiday calendar must be included if requested")
+}
+
+func Test_HolidayCalendar_ReadOnly(t *testing.T) {
+	svc, _ := newHolidayCalendarTestService()
+
+	assert.Equal(t, connect.CodePermissionDenied, connect.CodeOf(svc.checkWritable(holidayCalendarID)))
+	assert.Equal(t, connect.CodePermissionDenied, connect.CodeOf(svc.checkWritable("holidays:DE")))
+	assert.NoError(t, svc.checkWritable("cal-0"))
+
+	assert.Equal(t, connect.CodeInvalidArgument, connect.CodeOf(pseudoEventError("holiday-AT-2025-01-01")))
+}
@@ -2,8 +2,11 @@ package services
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"slices"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -12,25 +15,53 @@ import (
 	"github.com/tierklinik-dobersberg/apis/gen/go/tkd/calendar/v1/calendarv1connect"
 	commonv1 "github.com/tierklinik-dobersberg/apis/gen/go/tkd/common/v1"
 	"github.com/tierklinik-dobersberg/apis/pkg/log"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/holidays"
+)
+
+// IsHoliday only considers school holidays if schoolHolidaysHeader is set
+// to true. GetHoliday always includes them. If holidayRegionHeader is set to
+// an ISO 3166-2 region, like AT-3, only the school holidays of the region
+// are reported. The requests do not yet have fields for both.
+const (
+	schoolHolidaysHeader = "X-Include-School-Holidays"
+	holidayRegionHeader  = "X-Holiday-Region"
+)
+
+// workDayBreakdownHeader may be set to true on NumberOfWorkDays requests to
+// receive the per-day breakdown as a JSON list of {"date", "kind", "holiday"}
+// objects in workDayBreakdownResultHeader. As proxies limit the size of
+// headers the range may span at most maxBreakdownDays days. The response
+// does not yet have a field for it.
+const (
+	workDayBreakdownHeader       = "X-Work-Day-Breakdown"
+	workDayBreakdownResultHeader = "X-Work-Day-Breakdown-Result"
+	maxBreakdownDays             = 92
 )
 
 type HolidayService struct {
 	calendarv1connect.UnimplementedHolidayServiceHandler
 
 	country string
-	getter  HolidayGetter
+	getter  holidays.Getter
+	school  *holidays.SchoolHolidays
 }
 
-func NewHolidayService(country string) *HolidayService {
-	getter := NewHolidayCache()
-
+// NewHolidayService returns a new holiday service that uses getter to
+// retrieve holidays and defaults to country.
+func NewHolidayService(country string, getter holidays.Getter) *HolidayService {
 	return &HolidayService{
 		country: country,
 		getter:  getter,
 	}
 }
 
-func holidayToProto(ctx context.Context, p PublicHoliday) *calendarv1.PublicHoliday {
+// SetSchoolHolidays configures the school holidays reported in addition to
+// the public holidays.
+func (svc *HolidayService) SetSchoolHolidays(school *holidays.SchoolHolidays) {
+	svc.school = school
+}
+
+func holidayToProto(ctx context.Context, p holidays.PublicHoliday) *calendarv1.PublicHoliday {
 	var protoType calendarv1.HolidayType
 
 	if slices.Contains(p.Types, "Public") {
@@ -79,6 +110,15 @@ func (svc *HolidayService) GetHoliday(ctx context.Context, req *connect.Request[
 		return nil, err
 	}
 
+	if school := svc.school.Get(svc.country, req.Header().Get(holidayRegionHeader), int(req.Msg.GetYear())); len(school) > 0 {
+		// public holidays are reported before school holidays on the same
+		// day.
+		holidays = append(slices.Clone(holidays), school...)
+		sort.SliceStable(holidays, func(i, j int) bool {
+			return holidays[i].Date < holidays[j].Date
+		})
+	}
+
 	prefix := fmt.Sprintf("%d-", req.Msg.GetYear())
 	if req.Msg.Month > 0 {
 		prefix += fmt.Sprintf("%02d-", req.Msg.GetMonth())
@@ -117,6 +157,13 @@ func (svc *HolidayService) IsHoliday(ctx context.Context, req *connect.Request[c
 		QueriedDate: date,
 	}
 
+	if !isHoliday {
+		if v, _ := strconv.ParseBool(req.Header().Get(schoolHolidaysHeader)); v {
+			holiday, isHoliday = svc.school.On(svc.country, req.Header().Get(holidayRegionHeader), t.Local())
+			res.IsHoliday = isHoliday
+		}
+	}
+
 	if isHoliday {
 		res.Holiday = holidayToProto(ctx, *holiday)
 	}
@@ -124,36 +171,101 @@ func (svc *HolidayService) IsHoliday(ctx context.Context, req *connect.Request[c
 	return connect.NewResponse(res), nil
 }
 
+// dayKind classifies a day for NumberOfWorkDays.
+type dayKind string
+
+const (
+	dayKindWorkday dayKind = "workday"
+	dayKindWeekend dayKind = "weekend"
+	dayKindHoliday dayKind = "holiday"
+)
+
+// workDay is a single day of the NumberOfWorkDays breakdown.
+type workDay struct {
+	Date    string  `json:"date"`
+	Kind    dayKind `json:"kind"`
+	Holiday string  `json:"holiday,omitempty"`
+}
+
+// workDayBreakdown classifies each day between from and to (inclusive).
+// Weekends take precedence over holidays.
+func (svc *HolidayService) workDayBreakdown(ctx context.Context, country string, from, to time.Time) ([]workDay, error) {
+	var result []workDay
+
+	for iter := from; !iter.After(to); iter = iter.AddDate(0, 0, 1) {
+		day := workDay{
+			Date: iter.Format("2006-01-02"),
+			Kind: dayKindWorkday,
+		}
+
+		switch iter.Weekday() {
+		case time.Saturday, time.Sunday:
+			day.Kind = dayKindWeekend
+		default:
+			isHoliday, holiday, err := svc.getter.IsHoliday(ctx, country, iter)
+			if err != nil {
+				return nil, fmt.Errorf("failed to check holiday for %s: %w", day.Date, err)
+			}
+
+			if isHoliday {
+				day.Kind = dayKindHoliday
+				if holiday != nil {
+					day.Holiday = holiday.LocalName
+				}
+			}
+		}
+
+		result = append(result, day)
+	}
+
+	return result, nil
+}
+
+// NumberOfWorkDays counts the workdays, weekend days and holidays between
+// from and to. The per-day breakdown is reported in
+// workDayBreakdownResultHeader if requested.
 func (svc *HolidayService) NumberOfWorkDays(ctx context.Context, req *connect.Request[calendarv1.NumberOfWorkDaysRequest]) (*connect.Response[calendarv1.NumberOfWorkDaysResponse], error) {
 	from := req.Msg.From.AsTime()
 	to := req.Msg.To.AsTime()
 
-	response := &calendarv1.NumberOfWorkDaysResponse{}
-
 	country := req.Msg.Country
 	if country == "" {
 		country = svc.country
 	}
 
-L:
-	for iter := from; iter.Before(to) || iter.Equal(to); iter = iter.AddDate(0, 0, 1) {
-		switch iter.Weekday() {
-		case time.Saturday, time.Sunday:
+	breakdown, _ := strconv.ParseBool(req.Header().Get(workDayBreakdownHeader))
+	if breakdown && to.Sub(from) >= maxBreakdownDays*24*time.Hour {
+		return nil, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("the breakdown is limited to %d days", maxBreakdownDays))
+	}
+
+	days, err := svc.workDayBreakdown(ctx, country, from, to)
+	if err != nil {
+		// counts for a partial range would be silently wrong
+		return nil, connect.NewError(connect.CodeUnavailable, err)
+	}
+
+	response := &calendarv1.NumberOfWorkDaysResponse{}
+	for _, day := range days {
+		switch day.Kind {
+		case dayKindWeekend:
 			response.NumberOfWeekendDays++
-			continue
+		case dayKindHoliday:
+			response.NumberOfHolidays++
 		default:
-			isHoliday, _, err := svc.getter.IsHoliday(ctx, country, iter)
-			if err != nil {
-				break L
-			}
+			response.NumberOfWorkDays++
+		}
+	}
 
-			if isHoliday {
-				response.NumberOfHolidays++
-			} else {
-				response.NumberOfWorkDays++
-			}
+	res := connect.NewResponse(response)
+
+	if breakdown {
+		blob, err := json.Marshal(days)
+		if err != nil {
+			return nil, connect.NewError(connect.CodeInternal, err)
 		}
+
+		res.Header().Set(workDayBreakdownResultHeader, string(blob))
 	}
 
-	return connect.NewResponse(response), nil
+	return res, nil
 }
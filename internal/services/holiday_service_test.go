@@ -0,0 +1,207 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	calendarv1 "github.com/tierklinik-dobersberg/apis/gen/go/tkd/calendar/v1"
+	commonv1 "github.com/tierklinik-dobersberg/apis/gen/go/tkd/common/v1"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/config"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/holidays"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func newTestHolidayService(t *testing.T) *HolidayService {
+	t.Helper()
+
+	getter, err := holidays.NewFakeFromFile("testdata/holidays_AT_2024.json")
+	require.NoError(t, err)
+
+	return NewHolidayService("AT", getter)
+}
+
+func Test_GetHoliday_MonthFilter(t *testing.T) {
+	svc := newTestHolidayService(t)
+
+	res, err := svc.GetHoliday(context.Background(), connect.NewRequest(&calendarv1.GetHolidayRequest{
+		Year: 2024,
+	}))
+	require.NoError(t, err)
+	assert.Len(t, res.Msg.Holidays, 10)
+
+	res, err = svc.GetHoliday(context.Background(), connect.NewRequest(&calendarv1.GetHolidayRequest{
+		Year:  2024,
+		Month: 5,
+	}))
+	require.NoError(t, err)
+
+	var dates []string
+	for _, h := range res.Msg.Holidays {
+		dates = append(dates, h.Date)
+	}
+
+	assert.Equal(t, []string{"2024-05-01", "2024-05-09", "2024-05-20", "2024-05-30"}, dates)
+	assert.Equal(t, calendarv1.HolidayType_PUBLIC, res.Msg.Holidays[0].Type)
+}
+
+func Test_NumberOfWorkDays(t *testing.T) {
+	svc := newTestHolidayService(t)
+
+	// 2024-12-23 (Mon) - 2024-12-31 (Tue): 24th, 25th and 26th are holidays
+	res, err := svc.NumberOfWorkDays(context.Background(), connect.NewRequest(&calendarv1.NumberOfWorkDaysRequest{
+		From: timestamppb.New(time.Date(2024, time.December, 23, 0, 0, 0, 0, time.Local)),
+		To:   timestamppb.New(time.Date(2024, time.December, 31, 0, 0, 0, 0, time.Local)),
+	}))
+	require.NoError(t, err)
+
+	assert.Equal(t, uint32(3), res.Msg.NumberOfHolidays)
+	assert.Equal(t, uint32(2), res.Msg.NumberOfWeekendDays)
+	assert.Equal(t, uint32(4), res.Msg.NumberOfWorkDays)
+}
+
+// failingGetter fails for all holidays in a given month.
+type failingGetter struct {
+	holidays.Getter
+
+	month time.Month
+}
+
+func (f failingGetter) IsHoliday(ctx context.Context, country string, d time.Time) (bool, *holidays.PublicHoliday, error) {
+	if d.Month() == f.month {
+		return false, nil, errors.New("holiday API unavailable")
+	}
+
+	return f.Getter.IsHoliday(ctx, country, d)
+}
+
+func Test_WorkDayBreakdown_EasterMonday(t *testing.T) {
+	svc := newTestHolidayService(t)
+
+	// 2024-03-29 (Fri) - 2024-04-02 (Tue): Easter weekend and Easter Monday
+	days, err := svc.workDayBreakdown(context.Background(), "AT",
+		time.Date(2024, time.March, 29, 0, 0, 0, 0, time.Local),
+		time.Date(2024, time.April, 2, 0, 0, 0, 0, time.Local),
+	)
+	require.NoError(t, err)
+
+	assert.Equal(t, []workDay{
+		{Date: "2024-03-29", Kind: dayKindWorkday},
+		{Date: "2024-03-30", Kind: dayKindWeekend},
+		{Date: "2024-03-31", Kind: dayKindWeekend},
+		{Date: "2024-04-01", Kind: dayKindHoliday, Holiday: "Ostermontag"},
+		{Date: "2024-04-02", Kind: dayKindWorkday},
+	}, days)
+}
+
+func Test_NumberOfWorkDays_Breakdown(t *testing.T) {
+	svc := newTestHolidayService(t)
+
+	request := func(from, to time.Time, breakdown bool) (*connect.Response[calendarv1.NumberOfWorkDaysResponse], error) {
+		req := connect.NewRequest(&calendarv1.NumberOfWorkDaysRequest{
+			From: timestamppb.New(from),
+			To:   timestamppb.New(to),
+		})
+		req.Header().Set(workDayBreakdownHeader, strconv.FormatBool(breakdown))
+
+		return svc.NumberOfWorkDays(context.Background(), req)
+	}
+
+	from := time.Date(2024, time.March, 29, 0, 0, 0, 0, time.Local)
+	to := time.Date(2024, time.April, 2, 0, 0, 0, 0, time.Local)
+
+	res, err := request(from, to, true)
+	require.NoError(t, err)
+	assert.Equal(t, uint32(2), res.Msg.NumberOfWorkDays)
+	assert.JSONEq(t, `[
+		{"date": "2024-03-29", "kind": "workday"},
+		{"date": "2024-03-30", "kind": "weekend"},
+		{"date": "2024-03-31", "kind": "weekend"},
+		{"date": "2024-04-01", "kind": "holiday", "holiday": "Ostermontag"},
+		{"date": "2024-04-02", "kind": "workday"}
+	]`, res.Header().Get(workDayBreakdownResultHeader))
+
+	// existing callers do not receive the breakdown
+	res, err = request(from, to, false)
+	require.NoError(t, err)
+	assert.Empty(t, res.Header().Get(workDayBreakdownResultHeader))
+
+	// long ranges would exceed the header size limits of proxies
+	_, err = request(from, from.AddDate(1, 0, 0), true)
+	assert.Equal(t, connect.CodeInvalidArgument, connect.CodeOf(err))
+
+	_, err = request(from, from.AddDate(1, 0, 0), false)
+	require.NoError(t, err)
+}
+
+func Test_NumberOfWorkDays_GetterError(t *testing.T) {
+	getter, err := holidays.NewFakeFromFile("testdata/holidays_AT_2024.json")
+	require.NoError(t, err)
+
+	svc := NewHolidayService("AT", failingGetter{Getter: getter, month: time.April})
+
+	_, err = svc.NumberOfWorkDays(context.Background(), connect.NewRequest(&calendarv1.NumberOfWorkDaysRequest{
+		From: timestamppb.New(time.Date(2024, time.March, 25, 0, 0, 0, 0, time.Local)),
+		To:   timestamppb.New(time.Date(2024, time.April, 5, 0, 0, 0, 0, time.Local)),
+	}))
+	require.Error(t, err)
+	assert.Equal(t, connect.CodeUnavailable, connect.CodeOf(err))
+}
+
+func Test_HolidayService_SchoolHolidays(t *testing.T) {
+	svc := newTestHolidayService(t)
+
+	school, err := holidays.NewSchoolHolidays([]config.SchoolHoliday{
+		{Name: "Ascension holidays", LocalName: "Schulautonom frei", Country: "AT", From: "2024-05-09", To: "2024-05-10"},
+	})
+	require.NoError(t, err)
+	svc.SetSchoolHolidays(school)
+
+	res, err := svc.GetHoliday(context.Background(), connect.NewRequest(&calendarv1.GetHolidayRequest{
+		Year:  2024,
+		Month: 5,
+	}))
+	require.NoError(t, err)
+
+	var dates []string
+	for _, h := range res.Msg.Holidays {
+		dates = append(dates, h.Date+" "+h.Type.String())
+	}
+
+	// one entry per day, public holidays first
+	assert.Equal(t, []string{
+		"2024-05-01 PUBLIC",
+		"2024-05-09 PUBLIC",
+		"2024-05-09 SCHOOL",
+		"2024-05-10 SCHOOL",
+		"2024-05-20 PUBLIC",
+		"2024-05-30 PUBLIC",
+	}, dates)
+	assert.Equal(t, "Schulautonom frei", res.Msg.Holidays[3].LocalName)
+
+	// IsHoliday only considers school holidays if asked to
+	isHoliday := func(include bool) *calendarv1.IsHolidayResponse {
+		req := connect.NewRequest(&calendarv1.IsHolidayRequest{
+			Date: commonv1.FromTime(time.Date(2024, time.May, 10, 0, 0, 0, 0, time.Local)),
+		})
+		if include {
+			req.Header().Set(schoolHolidaysHeader, "true")
+		}
+
+		res, err := svc.IsHoliday(context.Background(), req)
+		require.NoError(t, err)
+
+		return res.Msg
+	}
+
+	assert.False(t, isHoliday(false).IsHoliday)
+
+	res2 := isHoliday(true)
+	assert.True(t, res2.IsHoliday)
+	assert.Equal(t, calendarv1.HolidayType_SCHOOL, res2.Holiday.Type)
+}
@@ -0,0 +1,384 @@
+package services
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/tierklinik-dobersberg/cis-cal/internal/repo"
+)
+
+const (
+	icsDateFormat     = "20060102"
+	icsDateTimeFormat = "20060102T150405Z"
+	icsLocalFormat    = "20060102T150405"
+
+	// icsLineLength is the maximum length of a content line in octets
+	// before it must be folded.
+	icsLineLength = 75
+)
+
+// icsWriter writes content lines of an iCalendar stream.
+type icsWriter struct {
+	w   *bufio.Writer
+	err error
+}
+
+// line writes a single content line and folds it if it is too long.
+func (iw *icsWriter) line(name, value string) {
+	if iw.err != nil {
+		return
+	}
+
+	line := name + ":" + value
+
+	for len(line) > icsLineLength {
+		// do not split multi-byte characters
+		cut := icsLineLength
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+
+		if _, iw.err = iw.w.WriteString(line[:cut] + "\r\n "); iw.err != nil {
+			return
+		}
+
+		line = line[cut:]
+	}
+
+	_, iw.err = iw.w.WriteString(line + "\r\n")
+}
+
+// escapeICSText escapes a TEXT value.
+func escapeICSText(value string) string {
+	return strings.NewReplacer(
+		`\`, `\\`,
+		";", `\;`,
+		",", `\,`,
+		"\r\n", `\n`,
+		"\n", `\n`,
+	).Replace(value)
+}
+
+// unescapeICSText reverts escapeICSText.
+func unescapeICSText(value string) string {
+	return strings.NewReplacer(
+		`\\`, `\`,
+		`\;`, ";",
+		`\,`, ",",
+		`\n`, "\n",
+		`\N`, "\n",
+	).Replace(value)
+}
+
+// writeICS writes events of cal as an iCalendar stream. Customer data, the
+// channel, the color and the description format are written to X-CIS-*
+// properties and tags to CATEGORIES so the stream can be imported again
+// without losing information.
+func writeICS(w io.Writer, cal repo.Calendar, events []repo.Event, now time.Time) error {
+	iw := &icsWriter{w: bufio.NewWriter(w)}
+
+	iw.line("BEGIN", "VCALENDAR")
+	iw.line("VERSION", "2.0")
+	iw.line("PRODID", "-//tierklinik-dobersberg//cis-cal//EN")
+
+	if cal.Name != "" {
+		iw.line("X-WR-CALNAME", escapeICSText(cal.Name))
+	}
+
+	for _, e := range events {
+		iw.line("BEGIN", "VEVENT")
+		iw.line("UID", escapeICSText(e.ID))
+		iw.line("DTSTAMP", now.UTC().Format(icsDateTimeFormat))
+
+		if e.FullDayEvent {
+			iw.line("DTSTART;VALUE=DATE", e.StartTime.Format(icsDateFormat))
+
+			if e.EndTime != nil {
+				iw.line("DTEND;VALUE=DATE", e.EndTime.Format(icsDateFormat))
+			}
+		} else {
+			iw.line("DTSTART", e.StartTime.UTC().Format(icsDateTimeFormat))
+
+			if e.EndTime != nil {
+				iw.line("DTEND", e.EndTime.UTC().Format(icsDateTimeFormat))
+			}
+		}
+
+		iw.line("SUMMARY", escapeICSText(e.Summary))
+
+		if e.Description != "" {
+			iw.line("DESCRIPTION", escapeICSText(e.Description))
+		}
+
+		if len(e.Tags) > 0 {
+			iw.line("CATEGORIES", joinICSList(e.Tags))
+		}
+
+		if e.Data != nil {
+			for _, prop := range []struct{ name, value string }{
+				{"X-CIS-CUSTOMER-SOURCE", escapeICSText(e.Data.CustomerSource)},
+				{"X-CIS-CUSTOMER-ID", escapeICSText(e.Data.CustomerID)},
+				{"X-CIS-ANIMAL-IDS", joinICSList(e.Data.AnimalID)},
+				{"X-CIS-CREATED-BY", escapeICSText(e.Data.CreatedBy)},
+			} {
+				if prop.value != "" {
+					iw.line(prop.name, prop.value)
+				}
+			}
+		}
+
+		if e.Channel != "" {
+			iw.line("X-CIS-CHANNEL", escapeICSText(e.Channel))
+		}
+
+		if e.ColorID != "" {
+			iw.line("X-CIS-COLOR", escapeICSText(e.ColorID))
+		}
+
+		if e.DescriptionFormat != "" {
+			iw.line("X-CIS-DESCRIPTION-FORMAT", escapeICSText(e.DescriptionFormat))
+		}
+
+		if e.Visibility != "" {
+			iw.line("CLASS", strings.ToUpper(e.Visibility))
+		}
+
+		iw.line("END", "VEVENT")
+	}
+
+	iw.line("END", "VCALENDAR")
+
+	if iw.err != nil {
+		return iw.err
+	}
+
+	return iw.w.Flush()
+}
+
+// icsProperty is a content line of an iCalendar stream.
+type icsProperty struct {
+	name   string
+	params map[string]string
+	value  string
+}
+
+// parseICSLine parses an unfolded content line.
+func parseICSLine(line string) (icsProperty, error) {
+	idx := strings.IndexByte(line, ':')
+	if idx < 0 {
+		return icsProperty{}, fmt.Errorf("invalid content line %q", line)
+	}
+
+	parts := strings.Split(line[:idx], ";")
+
+	prop := icsProperty{
+		name:   strings.ToUpper(parts[0]),
+		params: make(map[string]string, len(parts)-1),
+		value:  line[idx+1:],
+	}
+
+	for _, p := range parts[1:] {
+		key, value, _ := strings.Cut(p, "=")
+		prop.params[strings.ToUpper(key)] = strings.Trim(value, `"`)
+	}
+
+	return prop, nil
+}
+
+// parseICSTime parses a DATE or DATE-TIME value and reports whether it is a
+// date.
+func parseICSTime(prop icsProperty) (time.Time, bool, error) {
+	if prop.params["VALUE"] == "DATE" || len(prop.value) == len(icsDateFormat) {
+		t, err := time.Parse(icsDateFormat, prop.value)
+		return t, true, err
+	}
+
+	if strings.HasSuffix(prop.value, "Z") {
+		t, err := time.Parse(icsDateTimeFormat, prop.value)
+		return t, false, err
+	}
+
+	loc := time.Local
+	if tzid := prop.params["TZID"]; tzid != "" {
+		l, err := time.LoadLocation(tzid)
+		if err != nil {
+			return time.Time{}, false, fmt.Errorf("unknown time zone %q: %w", tzid, err)
+		}
+
+		loc = l
+	}
+
+	t, err := time.ParseInLocation(icsLocalFormat, prop.value, loc)
+	return t, false, err
+}
+
+// parseICS parses the events of an iCalendar stream as written by
+// writeICS. The UID of each event is returned as the event id. Recurring
+// events are not expanded.
+func parseICS(r io.Reader) ([]repo.Event, error) {
+	var (
+		lines   []string
+		scanner = bufio.NewScanner(r)
+	)
+
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+
+		// unfold continuation lines
+		if len(lines) > 0 && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	var (
+		events []repo.Event
+		evt    *repo.Event
+	)
+
+	for _, line := range lines {
+		prop, err := parseICSLine(line)
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case prop.name == "BEGIN" && prop.value == "VEVENT":
+			evt = new(repo.Event)
+			continue
+
+		case prop.name == "END" && prop.value == "VEVENT":
+			if evt == nil {
+				continue
+			}
+
+			if evt.StartTime.IsZero() {
+				return nil, fmt.Errorf("event %q does not have a start time", evt.ID)
+			}
+
+			events = append(events, *evt)
+			evt = nil
+			continue
+		}
+
+		if evt == nil {
+			continue
+		}
+
+		data := func() *repo.StructuredEvent {
+			if evt.Data == nil {
+				evt.Data = new(repo.StructuredEvent)
+			}
+
+			return evt.Data
+		}
+
+		switch prop.name {
+		case "UID":
+			evt.ID = unescapeICSText(prop.value)
+
+		case "DTSTART", "DTEND":
+			t, isDate, err := parseICSTime(prop)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value for %s: %w", prop.name, err)
+			}
+
+			if prop.name == "DTSTART" {
+				evt.StartTime = t
+				evt.FullDayEvent = isDate
+			} else {
+				evt.EndTime = &t
+			}
+
+		case "SUMMARY":
+			evt.Summary = unescapeICSText(prop.value)
+
+		case "DESCRIPTION":
+			evt.Description = unescapeICSText(prop.value)
+
+		case "CATEGORIES":
+			for _, tag := range splitICSList(prop.value) {
+				evt.Tags = append(evt.Tags, unescapeICSText(tag))
+			}
+
+		case "X-CIS-CUSTOMER-SOURCE":
+			data().CustomerSource = unescapeICSText(prop.value)
+
+		case "X-CIS-CUSTOMER-ID":
+			data().CustomerID = unescapeICSText(prop.value)
+
+		case "X-CIS-ANIMAL-IDS":
+			for _, id := range splitICSList(prop.value) {
+				data().AnimalID = append(data().AnimalID, unescapeICSText(id))
+			}
+
+		case "X-CIS-CREATED-BY":
+			data().CreatedBy = unescapeICSText(prop.value)
+
+		case "X-CIS-CHANNEL":
+			evt.Channel = unescapeICSText(prop.value)
+
+		case "X-CIS-COLOR":
+			evt.ColorID = unescapeICSText(prop.value)
+
+		case "X-CIS-DESCRIPTION-FORMAT":
+			evt.DescriptionFormat = unescapeICSText(prop.value)
+
+		case "CLASS":
+			// unknown classifications are treated as private as
+			// recommended by RFC 5545.
+			visibility, err := repo.ParseVisibility(prop.value)
+			if err != nil {
+				visibility = repo.VisibilityPrivate
+			}
+
+			evt.Visibility = visibility
+		}
+	}
+
+	return events, nil
+}
+
+// joinICSList escapes and joins the values of a list value.
+func joinICSList(values []string) string {
+	escaped := make([]string, len(values))
+	for idx, v := range values {
+		escaped[idx] = escapeICSText(v)
+	}
+
+	return strings.Join(escaped, ",")
+}
+
+// splitICSList splits a list value at unescaped commas.
+func splitICSList(value string) []string {
+	var (
+		result []string
+		start  int
+	)
+
+	for idx := 0; idx < len(value); idx++ {
+		switch value[idx] {
+		case '\\':
+			idx++
+		case ',':
+			result = append(result, value[start:idx])
+			start = idx + 1
+		}
+	}
+
+	return append(result, value[start:])
+}
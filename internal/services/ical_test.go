@@ -0,0 +1,64 @@
+package services
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/repo"
+)
+
+func Test_WriteICS_Folding(t *testing.T) {
+	start := time.Date(2024, time.June, 3, 8, 0, 0, 0, time.UTC)
+
+	var buf bytes.Buffer
+	require.NoError(t, writeICS(&buf, repo.Calendar{Name: "Dr. Maier"}, []repo.Event{{
+		ID:        "1",
+		Summary:   strings.Repeat("Ä", 60),
+		StartTime: start,
+	}}, start))
+
+	for _, line := range strings.Split(strings.TrimSuffix(buf.String(), "\r\n"), "\r\n") {
+		assert.LessOrEqual(t, len(line), icsLineLength+1, line)
+	}
+
+	events, err := parseICS(&buf)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, strings.Repeat("Ä", 60), events[0].Summary)
+	assert.Nil(t, events[0].EndTime)
+}
+
+func Test_ParseICS(t *testing.T) {
+	ics := strings.Join([]string{
+		"BEGIN:VCALENDAR",
+		"BEGIN:VEVENT",
+		"UID:external",
+		`DTSTART;TZID="Europe/Vienna":20240603T080000`,
+		"DTEND;TZID=Europe/Vienna:20240603T090000",
+		`SUMMARY:Checkup\, Bello`,
+		"CATEGORIES:surgery,follow-up",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"UID:missing-start",
+		"END:VEVENT",
+		"END:VCALENDAR",
+	}, "\r\n")
+
+	_, err := parseICS(strings.NewReader(ics))
+	require.Error(t, err)
+
+	events, err := parseICS(strings.NewReader(strings.Replace(ics, "UID:missing-start", "DTSTART:20240604", 1)))
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+
+	assert.Equal(t, "Checkup, Bello", events[0].Summary)
+	assert.Equal(t, []string{"surgery", "follow-up"}, events[0].Tags)
+	assert.Equal(t, "2024-06-03T06:00:00Z", events[0].StartTime.UTC().Format(time.RFC3339))
+	assert.Equal(t, "2024-06-03T07:00:00Z", events[0].EndTime.UTC().Format(time.RFC3339))
+
+	assert.True(t, events[1].FullDayEvent)
+}
@@ -0,0 +1,42 @@
+package services
+
+import (
+	"context"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/i18n"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/repo"
+)
+
+// NewLanguageInterceptor returns a unary interceptor that selects the
+// language of server-generated strings from the Accept-Language header of
+// the request. If none of the accepted languages is supported, fallback is
+// used.
+func NewLanguageInterceptor(fallback i18n.Language) connect.UnaryInterceptorFunc {
+	return func(next connect.UnaryFunc) connect.UnaryFunc {
+		return func(ctx context.Context, req connect.AnyRequest) (connect.AnyResponse, error) {
+			lang := i18n.Match(req.Header().Get("Accept-Language"), fallback)
+
+			return next(i18n.WithLanguage(ctx, lang), req)
+		}
+	}
+}
+
+// localizeSummaries translates the default summaries of absences without a
+// summary to lang.
+func localizeSummaries(events []repo.Event, lang i18n.Language) []repo.Event {
+	for idx, e := range events {
+		if !e.GeneratedSummary {
+			continue
+		}
+
+		switch e.EventType {
+		case repo.EventTypeOutOfOffice:
+			events[idx].Summary = lang.Sprintf(i18n.OutOfOfficeSummary)
+		case repo.EventTypeFocusTime:
+			events[idx].Summary = lang.Sprintf(i18n.FocusTimeSummary)
+		}
+	}
+
+	return events
+}
@@ -0,0 +1,82 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tierklinik-dobersberg/apis/gen/go/tkd/calendar/v1/calendarv1connect"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/i18n"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/repo"
+)
+
+func Test_ListEvents_AcceptLanguage(t *testing.T) {
+	svc, fake := newMaskTestService(1, 1)
+	fake.events[0].Summary = repo.DefaultOutOfOfficeSummary
+	fake.events[0].EventType = repo.EventTypeOutOfOffice
+	fake.events[0].GeneratedSummary = true
+
+	mux := http.NewServeMux()
+	mux.Handle(calendarv1connect.NewCalendarServiceHandler(svc,
+		connect.WithInterceptors(NewLanguageInterceptor(i18n.German)),
+	))
+
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	cli := calendarv1connect.NewCalendarServiceClient(http.DefaultClient, srv.URL)
+
+	cases := map[string]string{
+		"":               "Abwesend",
+		"de-AT":          "Abwesend",
+		"en-US,en;q=0.8": "Out of office",
+		"fr":             "Abwesend",
+	}
+
+	for header, expected := range cases {
+		req := listEventsRequest(1)
+		if header != "" {
+			req.Header().Set("Accept-Language", header)
+		}
+
+		res, err := cli.ListEvents(context.Background(), req)
+		require.NoError(t, err)
+		require.Len(t, res.Msg.Results, 1)
+		require.Len(t, res.Msg.Results[0].Events, 1)
+
+		assert.Equal(t, expected, res.Msg.Results[0].Events[0].Summary, header)
+	}
+}
+
+func Test_LocalizeSummaries(t *testing.T) {
+	events := []repo.Event{
+		{Summary: "Urlaub", EventType: repo.EventTypeOutOfOffice},
+		{Summary: repo.DefaultOutOfOfficeSummary, EventType: repo.EventTypeOutOfOffice, GeneratedSummary: true},
+		{Summary: repo.DefaultFocusTimeSummary, EventType: repo.EventTypeFocusTime, GeneratedSummary: true},
+	}
+
+	events = localizeSummaries(events, i18n.English)
+
+	// summaries written by users are never translated
+	assert.Equal(t, "Urlaub", events[0].Summary)
+	assert.Equal(t, "Out of office", events[1].Summary)
+	assert.Equal(t, "Focus time", events[2].Summary)
+}
+
+func Test_CustomerDoubleBooking_Language(t *testing.T) {
+	svc, _ := newBookingTestService(t)
+
+	req := createEventRequest(t, "10:30", "11:30", "huber")
+
+	res, err := svc.CreateEvent(context.Background(), req)
+	require.NoError(t, err)
+	assert.Contains(t, res.Header().Get("Warning"), "hat bereits überschneidende Termine")
+
+	res, err = svc.CreateEvent(i18n.WithLanguage(context.Background(), i18n.English), req)
+	require.NoError(t, err)
+	assert.Contains(t, res.Header().Get("Warning"), "already has overlapping appointments")
+}
@@ -0,0 +1,287 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/config"
+)
+
+// defaultMaintenanceMessage is returned to callers if the maintenance mode
+// was enabled without a message.
+const defaultMaintenanceMessage = "the calendar is in maintenance mode, changes are not possible right now"
+
+// maintenanceState is the persisted state of the maintenance mode.
+type maintenanceState struct {
+	Enabled bool       `json:"enabled"`
+	Message string     `json:"message,omitempty"`
+	Until   *time.Time `json:"until,omitempty"`
+	SetBy   string     `json:"setBy,omitempty"`
+	SetAt   time.Time  `json:"setAt"`
+}
+
+// Maintenance rejects all changes to calendars while enabled, reads keep
+// working. The mode is lifted automatically once its deadline passed.
+type Maintenance struct {
+	storeFile string
+	now       func() time.Time
+
+	l     sync.Mutex
+	state maintenanceState
+}
+
+// NewMaintenance returns a new maintenance mode and loads its state from
+// the store file of cfg, if any.
+func NewMaintenance(cfg config.Maintenance) (*Maintenance, error) {
+	m := &Maintenance{
+		storeFile: cfg.StoreFile,
+		now:       time.Now,
+	}
+
+	if m.storeFile == "" {
+		return m, nil
+	}
+
+	blob, err := os.ReadFile(m.storeFile)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return m, nil
+		}
+
+		return nil, fmt.Errorf("failed to read maintenance mode: %w", err)
+	}
+
+	if err := json.Unmarshal(blob, &m.state); err != nil {
+		return nil, fmt.Errorf("failed to decode maintenance mode from %s: %w", m.storeFile, err)
+	}
+
+	return m, nil
+}
+
+// State returns the current state of the maintenance mode. A mode whose
+// deadline passed is reported as disabled.
+func (m *Maintenance) State() maintenanceState {
+	m.l.Lock()
+	defer m.l.Unlock()
+
+	return m.stateLocked()
+}
+
+// stateLocked is like State but the caller must hold m.l.
+func (m *Maintenance) stateLocked() maintenanceState {
+	if m.state.Enabled && m.state.Until != nil && !m.now().Before(*m.state.Until) {
+		slog.Info("maintenance mode lifted", "until", *m.state.Until)
+
+		m.state = maintenanceState{
+			SetAt: *m.state.Until,
+		}
+	}
+
+	return m.state
+}
+
+// Set enables or disables the maintenance mode and persists the new state.
+// An enabled mode without a deadline stays active until it is disabled.
+func (m *Maintenance) Set(state maintenanceState) (maintenanceState, error) {
+	m.l.Lock()
+	defer m.l.Unlock()
+
+	state.SetAt = m.now()
+
+	if !state.Enabled {
+		state.Message = ""
+		state.Until = nil
+	} else if state.Until != nil && !state.Until.After(state.SetAt) {
+		return maintenanceState{}, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("invalid value for until: %s is not in the future", state.Until.Format(time.RFC3339)))
+	}
+
+	if err := m.save(state); err != nil {
+		return maintenanceState{}, fmt.Errorf("failed to persist maintenance mode: %w", err)
+	}
+
+	m.state = state
+
+	slog.Info("maintenance mode changed", "enabled", state.Enabled, "until", state.Until, "by", state.SetBy)
+
+	return state, nil
+}
+
+// save writes state to the store file. The caller must hold m.l.
+func (m *Maintenance) save(state maintenanceState) error {
+	if m.storeFile == "" {
+		return nil
+	}
+
+	blob, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// write to a temporary file first so a crash does not leave a
+	// truncated store behind.
+	tmp, err := os.CreateTemp(filepath.Dir(m.storeFile), ".maintenance-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(blob); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), m.storeFile)
+}
+
+// check returns the message of an active maintenance mode and the time
+// after which it is lifted, if it has a deadline. ok is false if changes
+// are allowed.
+func (m *Maintenance) check() (message string, retryAfter time.Duration, ok bool) {
+	m.l.Lock()
+	defer m.l.Unlock()
+
+	state := m.stateLocked()
+	if !state.Enabled {
+		return "", 0, false
+	}
+
+	message = state.Message
+	if message == "" {
+		message = defaultMaintenanceMessage
+	}
+
+	if state.Until != nil {
+		retryAfter = state.Until.Sub(m.now())
+	}
+
+	return message, retryAfter, true
+}
+
+// NewMaintenanceInterceptor returns a unary interceptor that rejects all
+// mutating requests with CodeUnavailable while the maintenance mode is
+// enabled.
+func NewMaintenanceInterceptor(m *Maintenance) connect.UnaryInterceptorFunc {
+	return func(next connect.UnaryFunc) connect.UnaryFunc {
+		return func(ctx context.Context, req connect.AnyRequest) (connect.AnyResponse, error) {
+			if !slices.Contains(mutatingProcedures, req.Spec().Procedure) {
+				return next(ctx, req)
+			}
+
+			message, retryAfter, active := m.check()
+			if !active {
+				return next(ctx, req)
+			}
+
+			connectErr := connect.NewError(connect.CodeUnavailable, errors.New(message))
+			if retryAfter > 0 {
+				connectErr.Meta().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			}
+
+			return nil, connectErr
+		}
+	}
+}
+
+// Wrap returns a handler that rejects all requests but GET and HEAD with
+// 503 Service Unavailable while the maintenance mode is enabled. It is used
+// for plain HTTP endpoints that change calendars.
+func (m *Maintenance) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		message, retryAfter, active := m.check()
+		if !active {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if retryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		}
+
+		http.Error(w, message, http.StatusServiceUnavailable)
+	})
+}
+
+// MaintenanceHandler shows and changes the maintenance mode:
+//
+//	GET  /admin/maintenance
+//	POST /admin/maintenance {"enabled": true, "message": "...", "until": "<RFC 3339>"}
+type MaintenanceHandler struct {
+	maintenance  *Maintenance
+	allowedRoles []string
+}
+
+// NewMaintenanceHandler returns a new maintenance handler for m.
+func NewMaintenanceHandler(m *Maintenance, allowedRoles []string) *MaintenanceHandler {
+	return &MaintenanceHandler{
+		maintenance:  m,
+		allowedRoles: allowedRoles,
+	}
+}
+
+func (h *MaintenanceHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !authorize(w, r, h.allowedRoles) {
+		return
+	}
+
+	var state maintenanceState
+
+	switch r.Method {
+	case http.MethodGet:
+		state = h.maintenance.State()
+
+	case http.MethodPost:
+		var body struct {
+			Enabled bool       `json:"enabled"`
+			Message string     `json:"message"`
+			Until   *time.Time `json:"until"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		var err error
+		state, err = h.maintenance.Set(maintenanceState{
+			Enabled: body.Enabled,
+			Message: body.Message,
+			Until:   body.Until,
+			SetBy:   r.Header.Get("X-Remote-User-ID"),
+		})
+		if err != nil {
+			http.Error(w, err.Error(), httpStatus(err))
+			return
+		}
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(state); err != nil {
+		slog.Error("failed to encode maintenance mode", "error", err)
+	}
+}
@@ -0,0 +1,216 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	calendarv1 "github.com/tierklinik-dobersberg/apis/gen/go/tkd/calendar/v1"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/config"
+)
+
+func Test_Maintenance_Persist(t *testing.T) {
+	storeFile := filepath.Join(t.TempDir(), "maintenance.json")
+
+	m, err := NewMaintenance(config.Maintenance{StoreFile: storeFile})
+	require.NoError(t, err)
+	assert.False(t, m.State().Enabled)
+
+	until := time.Now().Add(time.Hour).Truncate(time.Second)
+	_, err = m.Set(maintenanceState{Enabled: true, Message: "migration", Until: &until, SetBy: "alice"})
+	require.NoError(t, err)
+
+	// the mode survives a restart
+	m, err = NewMaintenance(config.Maintenance{StoreFile: storeFile})
+	require.NoError(t, err)
+
+	state := m.State()
+	assert.True(t, state.Enabled)
+	assert.Equal(t, "migration", state.Message)
+	assert.Equal(t, "alice", state.SetBy)
+	require.NotNil(t, state.Until)
+	assert.True(t, until.Equal(*state.Until))
+
+	// deadlines must be in the future
+	past := time.Now().Add(-time.Minute)
+	_, err = m.Set(maintenanceState{Enabled: true, Until: &past})
+	assert.Equal(t, connect.CodeInvalidArgument, connect.CodeOf(err))
+
+	state, err = m.Set(maintenanceState{Message: "ignored", Until: &until})
+	require.NoError(t, err)
+	assert.False(t, state.Enabled)
+	assert.Empty(t, state.Message)
+	assert.Nil(t, state.Until)
+}
+
+func Test_MaintenanceInterceptor(t *testing.T) {
+	now := time.Date(2024, time.June, 3, 10, 0, 0, 0, time.UTC)
+
+	m, err := NewMaintenance(config.Maintenance{})
+	require.NoError(t, err)
+	m.now = func() time.Time { return now }
+
+	next := NewMaintenanceInterceptor(m)(func(context.Context, connect.AnyRequest) (connect.AnyResponse, error) {
+		return connect.NewResponse(&calendarv1.DeleteEventResponse{}), nil
+	})
+
+	call := func(procedure string) error {
+		_, err := next(context.Background(), specRequest{connect.NewRequest(&calendarv1.DeleteEventRequest{}), procedure})
+		return err
+	}
+
+	require.NoError(t, call("/tkd.calendar.v1.CalendarService/DeleteEvent"))
+
+	until := now.Add(90 * time.Second)
+	_, err = m.Set(maintenanceState{Enabled: true, Message: "data migration until 10:01", Until: &until})
+	require.NoError(t, err)
+
+	for _, procedure := range []string{
+		"/tkd.calendar.v1.CalendarService/CreateEvent",
+		"/tkd.calendar.v1.CalendarService/UpdateEvent",
+		"/tkd.calendar.v1.CalendarService/MoveEvent",
+		"/tkd.calendar.v1.CalendarService/DeleteEvent",
+	} {
+		err := call(procedure)
+		assert.Equal(t, connect.CodeUnavailable, connect.CodeOf(err), procedure)
+		assert.ErrorContains(t, err, "data migration until 10:01", procedure)
+
+		var connectErr *connect.Error
+		if assert.ErrorAs(t, err, &connectErr) {
+			assert.Equal(t, "90", connectErr.Meta().Get("Retry-After"))
+		}
+	}
+
+	// reads keep working
+	require.NoError(t, call("/tkd.calendar.v1.CalendarService/ListEvents"))
+
+	// the mode is lifted at the deadline
+	now = until
+	require.NoError(t, call("/tkd.calendar.v1.CalendarService/DeleteEvent"))
+	assert.False(t, m.State().Enabled)
+}
+
+func Test_Maintenance_Wrap(t *testing.T) {
+	m, err := NewMaintenance(config.Maintenance{})
+	require.NoError(t, err)
+
+	h := m.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	_, err = m.Set(maintenanceState{Enabled: true})
+	require.NoError(t, err)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/event-status", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Equal(t, defaultMaintenanceMessage, strings.TrimSpace(rec.Body.String()))
+	assert.Empty(t, rec.Header().Get("Retry-After"))
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/event-status", nil))
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+}
+
+func Test_MaintenanceHandler(t *testing.T) {
+	m, err := NewMaintenance(config.Maintenance{})
+	require.NoError(t, err)
+
+	h := NewMaintenanceHandler(m, []string{"admin"})
+
+	request := func(method, body string, roles ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/admin/maintenance", strings.NewReader(body))
+		for _, role := range roles {
+			req.Header.Add("X-Remote-Role", role)
+		}
+		req.Header.Set("X-Remote-User-ID", "alice")
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		return rec
+	}
+
+	assert.Equal(t, http.StatusForbidden, request(http.MethodGet, "", "staff").Code)
+
+	rec := request(http.MethodPost, `{"enabled": true, "message": "migration"}`, "admin")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"setBy":"alice"`)
+
+	state := m.State()
+	assert.True(t, state.Enabled)
+	assert.Equal(t, "migration", state.Message)
+
+	rec = request(http.MethodPost, `{"enabled": true, "until": "2000-01-01T00:00:00Z"}`, "admin")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = request(http.MethodGet, "", "admin")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"enabled":true`)
+
+	assert.Equal(t, http.StatusMethodNotAllowed, request(http.MethodDelete, "", "admin").Code)
+}
+
+func Test_Maintenance_Wrap_NotesAndWebhooks(t *testing.T) {
+	m, err := NewMaintenance(config.Maintenance{})
+	require.NoError(t, err)
+
+	svc, _ := newChannelTestService(t)
+	svc.notes = newTestNoteStore(t)
+
+	res, err := svc.CreateEvent(context.Background(), createEventRequest(t, "14:00", "15:00", "huber"))
+	require.NoError(t, err)
+
+	webhooks, err := NewWebhookDispatcher(context.Background(), config.Webhooks{
+		StoreFile: filepath.Join(t.TempDir(), "webhooks.json"),
+	})
+	require.NoError(t, err)
+
+	notesHandler := m.Wrap(NewNoteHandler(svc))
+	webhookHandler := m.Wrap(NewWebhookHandler(webhooks, []string{"admin"}))
+
+	send := func(h http.Handler, method, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("X-Remote-User-ID", "alice")
+		req.Header.Set("X-Remote-Role", "admin")
+		req.Header.Add("X-Remote-Role", "vet")
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		return rec
+	}
+
+	_, err = m.Set(maintenanceState{Enabled: true})
+	require.NoError(t, err)
+
+	note := `{"calendarId": "vet-1", "eventId": "` + res.Msg.Event.Id + `", "note": "aggressive dog"}`
+	assert.Equal(t, http.StatusServiceUnavailable, send(notesHandler, http.MethodPut, "/events/note", note).Code)
+
+	_, ok := svc.notes.get("vet-1", res.Msg.Event.Id)
+	assert.False(t, ok)
+
+	hook := `{"url": "https://portal.example.com/hook", "calendars": ["vet-1"]}`
+	assert.Equal(t, http.StatusServiceUnavailable, send(webhookHandler, http.MethodPost, "/webhooks", hook).Code)
+	assert.Equal(t, http.StatusServiceUnavailable, send(webhookHandler, http.MethodDelete, "/webhooks?id=x", "").Code)
+
+	// reads keep working, the note has not been stored
+	assert.Equal(t, http.StatusNotFound, send(notesHandler, http.MethodGet, "/events/note?calendar=vet-1&event="+res.Msg.Event.Id, "").Code)
+
+	rec := send(webhookHandler, http.MethodGet, "/webhooks", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, "[]", rec.Body.String())
+
+	_, err = m.Set(maintenanceState{})
+	require.NoError(t, err)
+
+	assert.Equal(t, http.StatusOK, send(notesHandler, http.MethodPut, "/events/note", note).Code)
+	assert.Equal(t, http.StatusCreated, send(webhookHandler, http.MethodPost, "/webhooks", hook).Code)
+}
@@ -0,0 +1,135 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	calendarv1 "github.com/tierklinik-dobersberg/apis/gen/go/tkd/calendar/v1"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/app"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/config"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/repo"
+)
+
+// moveRepo is a bookingRepo that moves a single event and records the move
+// options.
+type moveRepo struct {
+	*bookingRepo
+
+	event repo.Event
+	moved *repo.MoveOptions
+}
+
+func (m *moveRepo) LoadEvent(_ context.Context, calID, eventID string, _ bool) (*repo.Event, error) {
+	evt := m.event
+	return &evt, nil
+}
+
+func (m *moveRepo) MoveEvent(_ context.Context, originCalendarId, eventId, targetCalendarId string, opts ...repo.MoveOption) (*repo.Event, error) {
+	m.moved = new(repo.MoveOptions)
+	for _, fn := range opts {
+		fn(m.moved)
+	}
+
+	evt := m.event
+	evt.CalendarID = targetCalendarId
+
+	if m.moved.StartTime != nil {
+		evt.StartTime = *m.moved.StartTime
+		evt.EndTime = m.moved.EndTime
+	}
+
+	return &evt, nil
+}
+
+func Test_MoveEvent_Time(t *testing.T) {
+	svc, bookings := newBookingTestService(t)
+
+	start := time.Date(2024, time.June, 3, 8, 0, 0, 0, time.UTC)
+	fake := &moveRepo{
+		bookingRepo: bookings,
+		event:       repo.Event{ID: "1", CalendarID: "vet-1", StartTime: start, EndTime: ptr(start.Add(30 * time.Minute))},
+	}
+	svc.repo = &app.App{Service: fake}
+
+	move := func(headers ...string) (*connect.Response[calendarv1.MoveEventResponse], error) {
+		req := connect.NewRequest(&calendarv1.MoveEventRequest{
+			EventId: "1",
+			Source: &calendarv1.MoveEventRequest_SourceCalendarId{
+				SourceCalendarId: "vet-1",
+			},
+			Target: &calendarv1.MoveEventRequest_TargetCalendarId{
+				TargetCalendarId: "vet-2",
+			},
+		})
+
+		for i := 0; i < len(headers); i += 2 {
+			req.Header().Set(headers[i], headers[i+1])
+		}
+
+		return svc.MoveEvent(context.Background(), req)
+	}
+
+	// the event keeps its duration
+	res, err := move(moveStartHeader, "2024-06-03T10:00:00Z")
+	require.NoError(t, err)
+	assert.Equal(t, "2024-06-03T10:00:00Z", res.Msg.Event.StartTime.AsTime().Format(time.RFC3339))
+	assert.Equal(t, "2024-06-03T10:30:00Z", res.Msg.Event.EndTime.AsTime().Format(time.RFC3339))
+	assert.Equal(t, "vet-2", res.Msg.Event.CalendarId)
+
+	res, err = move(moveStartHeader, "2024-06-03T10:00:00Z", moveEndHeader, "2024-06-03T11:00:00Z")
+	require.NoError(t, err)
+	assert.Equal(t, "2024-06-03T11:00:00Z", res.Msg.Event.EndTime.AsTime().Format(time.RFC3339))
+
+	res, err = move(moveEndHeader, "2024-06-03T09:00:00Z")
+	require.NoError(t, err)
+	assert.Equal(t, "2024-06-03T08:00:00Z", res.Msg.Event.StartTime.AsTime().Format(time.RFC3339))
+	assert.Equal(t, "2024-06-03T09:00:00Z", res.Msg.Event.EndTime.AsTime().Format(time.RFC3339))
+
+	// without headers the time is not changed
+	_, err = move()
+	require.NoError(t, err)
+	assert.Nil(t, fake.moved.StartTime)
+
+	// invalid times are rejected before moving
+	fake.moved = nil
+
+	for _, headers := range [][]string{
+		{moveStartHeader, "tomorrow"},
+		{moveEndHeader, "2024-06-03T07:00:00Z"},
+	} {
+		_, err = move(headers...)
+		assert.Equal(t, connect.CodeInvalidArgument, connect.CodeOf(err), headers)
+	}
+
+	// the new time is checked against the limits
+	svc.repo.Config.Validation.MaxEventDuration = config.Duration(time.Hour)
+
+	_, err = move(moveEndHeader, "2024-06-03T10:00:00Z")
+	assert.Equal(t, connect.CodeInvalidArgument, connect.CodeOf(err))
+	assert.Nil(t, fake.moved)
+
+	// the new time conflicts with an appointment of the customer in
+	// another calendar
+	fake.event.Data = &repo.StructuredEvent{CustomerSource: "vetinf", CustomerID: "huber"}
+	bookings.events["vet-3"] = []repo.Event{{
+		ID:         "other",
+		CalendarID: "vet-3",
+		StartTime:  start.Add(2 * time.Hour),
+		EndTime:    ptr(start.Add(3 * time.Hour)),
+		Data:       fake.event.Data,
+	}}
+	svc.calendarById.Update([]repo.Calendar{{ID: "vet-1"}, {ID: "vet-2"}, {ID: "vet-3"}})
+
+	res, err = move(moveStartHeader, "2024-06-03T10:15:00Z")
+	require.NoError(t, err)
+	assert.NotEmpty(t, res.Header().Get("Warning"))
+
+	fake.event.FullDayEvent = true
+
+	_, err = move(moveStartHeader, "2024-06-04T00:00:00Z")
+	assert.Equal(t, connect.CodeInvalidArgument, connect.CodeOf(err))
+}
@@ -0,0 +1,359 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tierklinik-dobersberg/cis-cal/internal/config"
+)
+
+// eventNoteHeader is set on ListEvents responses once per event that has an
+// internal note as "<calendar-id> <event-id> <quoted note>". Notes are
+// only reported to callers with one of the configured notes roles. The
+// event messages do not yet have a field for notes.
+const eventNoteHeader = "X-Event-Note"
+
+// maxNoteLength is the maximum length of a note in bytes.
+const maxNoteLength = 2000
+
+// eventNote is an internal remark for an event that is never synced to the
+// calendar backend.
+type eventNote struct {
+	CalendarID string    `json:"calendarId"`
+	EventID    string    `json:"eventId"`
+	Text       string    `json:"text"`
+	UpdatedBy  string    `json:"updatedBy,omitempty"`
+	UpdatedAt  time.Time `json:"updatedAt"`
+}
+
+type noteKey struct {
+	calendarID string
+	eventID    string
+}
+
+// NoteStore keeps the internal notes of events keyed by calendar and event
+// id. Notes are persisted to the configured store file.
+type NoteStore struct {
+	storeFile    string
+	allowedRoles []string
+	now          func() time.Time
+
+	l     sync.Mutex
+	notes map[noteKey]eventNote
+}
+
+// NewNoteStore returns a new note store and loads the notes from the store
+// file, if any.
+func NewNoteStore(cfg config.Notes) (*NoteStore, error) {
+	s := &NoteStore{
+		storeFile:    cfg.StoreFile,
+		allowedRoles: cfg.AllowedRoles,
+		now:          time.Now,
+		notes:        make(map[noteKey]eventNote),
+	}
+
+	if s.storeFile == "" {
+		return s, nil
+	}
+
+	blob, err := os.ReadFile(s.storeFile)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return s, nil
+		}
+
+		return nil, fmt.Errorf("failed to read event notes: %w", err)
+	}
+
+	var notes []eventNote
+	if err := json.Unmarshal(blob, &notes); err != nil {
+		return nil, fmt.Errorf("failed to decode event notes from %s: %w", s.storeFile, err)
+	}
+
+	for _, n := range notes {
+		s.notes[noteKey{n.CalendarID, n.EventID}] = n
+	}
+
+	return s, nil
+}
+
+// visibleTo reports whether notes are shown to a caller with roles. A nil
+// store has notes disabled.
+func (s *NoteStore) visibleTo(roles []string) bool {
+	if s == nil {
+		return false
+	}
+
+	return slices.ContainsFunc(roles, func(role string) bool {
+		return slices.Contains(s.allowedRoles, role)
+	})
+}
+
+// get returns the note of an event.
+func (s *NoteStore) get(calendarID, eventID string) (eventNote, bool) {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	n, ok := s.notes[noteKey{calendarID, eventID}]
+
+	return n, ok
+}
+
+// set stores the note of an event, an empty text removes the note. The
+// text must have been validated by the caller.
+func (s *NoteStore) set(calendarID, eventID, text, userID string) (eventNote, error) {
+	key := noteKey{calendarID, eventID}
+	note := eventNote{
+		CalendarID: calendarID,
+		EventID:    eventID,
+		Text:       text,
+		UpdatedBy:  userID,
+		UpdatedAt:  s.now(),
+	}
+
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	prev, existed := s.notes[key]
+
+	if text == "" {
+		delete(s.notes, key)
+	} else {
+		s.notes[key] = note
+	}
+
+	if err := s.save(); err != nil {
+		if existed {
+			s.notes[key] = prev
+		} else {
+			delete(s.notes, key)
+		}
+
+		return eventNote{}, fmt.Errorf("failed to store event notes: %w", err)
+	}
+
+	return note, nil
+}
+
+// move re-keys the note of an event that has been moved to another
+// calendar. Moving an event without a note is a no-op.
+func (s *NoteStore) move(fromCalendar, fromEvent, toCalendar, toEvent string) error {
+	if s == nil {
+		return nil
+	}
+
+	from := noteKey{fromCalendar, fromEvent}
+	to := noteKey{toCalendar, toEvent}
+
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	note, ok := s.notes[from]
+	if !ok || from == to {
+		return nil
+	}
+
+	note.CalendarID = toCalendar
+	note.EventID = toEvent
+
+	delete(s.notes, from)
+	s.notes[to] = note
+
+	if err := s.save(); err != nil {
+		delete(s.notes, to)
+		s.notes[from] = note
+
+		return fmt.Errorf("failed to store event notes: %w", err)
+	}
+
+	return nil
+}
+
+// delete removes the note of a deleted event.
+func (s *NoteStore) delete(calendarID, eventID string) error {
+	if s == nil {
+		return nil
+	}
+
+	key := noteKey{calendarID, eventID}
+
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	note, ok := s.notes[key]
+	if !ok {
+		return nil
+	}
+
+	delete(s.notes, key)
+
+	if err := s.save(); err != nil {
+		s.notes[key] = note
+
+		return fmt.Errorf("failed to store event notes: %w", err)
+	}
+
+	return nil
+}
+
+// save writes all notes to the store file. The caller must hold s.l.
+func (s *NoteStore) save() error {
+	if s.storeFile == "" {
+		return nil
+	}
+
+	notes := make([]eventNote, 0, len(s.notes))
+	for _, n := range s.notes {
+		notes = append(notes, n)
+	}
+
+	sort.Slice(notes, func(i, j int) bool {
+		if notes[i].CalendarID != notes[j].CalendarID {
+			return notes[i].CalendarID < notes[j].CalendarID
+		}
+
+		return notes[i].EventID < notes[j].EventID
+	})
+
+	blob, err := json.MarshalIndent(notes, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// write to a temporary file first so a crash does not leave a
+	// truncated store behind.
+	tmp, err := os.CreateTemp(filepath.Dir(s.storeFile), ".notes-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(blob); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), s.storeFile)
+}
+
+// noteHeaderValue returns the eventNoteHeader value for note.
+func noteHeaderValue(note eventNote) string {
+	return note.CalendarID + " " + note.EventID + " " + strconv.Quote(note.Text)
+}
+
+// NoteHandler reads and sets the internal notes of events. Notes are only
+// stored by this service and never synced to the calendar backend:
+//
+//	GET /events/note?calendar=<id>&event=<id>
+//	PUT /events/note {"calendarId": "<id>", "eventId": "<id>", "note": "aggressive dog, muzzle!"}
+//
+// An empty note removes the note of the event. Only callers with one of the
+// configured notes roles (X-Remote-Role) may read or set notes.
+type NoteHandler struct {
+	svc *CalendarService
+}
+
+// NewNoteHandler returns a new note handler for svc.
+func NewNoteHandler(svc *CalendarService) *NoteHandler {
+	return &NoteHandler{svc: svc}
+}
+
+func (h *NoteHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !h.svc.notes.visibleTo(r.Header.Values("X-Remote-Role")) {
+		http.Error(w, "not allowed to access event notes", http.StatusForbidden)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		calID := r.URL.Query().Get("calendar")
+		eventID := r.URL.Query().Get("event")
+
+		if calID == "" || eventID == "" {
+			http.Error(w, "missing value for calendar or event", http.StatusBadRequest)
+			return
+		}
+
+		note, ok := h.svc.notes.get(calID, eventID)
+		if !ok {
+			http.Error(w, "event has no note", http.StatusNotFound)
+			return
+		}
+
+		writeNoteJSON(w, note)
+
+	case http.MethodPut:
+		var body struct {
+			CalendarID string `json:"calendarId"`
+			EventID    string `json:"eventId"`
+			Note       string `json:"note"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if body.CalendarID == "" || body.EventID == "" {
+			http.Error(w, "missing value for calendarId or eventId", http.StatusBadRequest)
+			return
+		}
+
+		body.Note = strings.TrimSpace(body.Note)
+		if len(body.Note) > maxNoteLength {
+			http.Error(w, fmt.Sprintf("notes must not be longer than %d bytes", maxNoteLength), http.StatusBadRequest)
+			return
+		}
+
+		if err := pseudoEventError(body.EventID); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if isOverlayEvent(body.EventID) {
+			err := overlayReadOnlyError()
+			http.Error(w, err.Error(), httpStatus(err))
+			return
+		}
+
+		// notes can only be added to existing events
+		if _, err := h.svc.repo.LoadEvent(r.Context(), body.CalendarID, body.EventID, false); err != nil {
+			http.Error(w, err.Error(), httpStatus(err))
+			return
+		}
+
+		note, err := h.svc.notes.set(body.CalendarID, body.EventID, body.Note, r.Header.Get("X-Remote-User-ID"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		writeNoteJSON(w, note)
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func writeNoteJSON(w http.ResponseWriter, note eventNote) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(note); err != nil {
+		slog.Error("failed to encode event note", "error", err)
+	}
+}
@@ -0,0 +1,188 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	calendarv1 "github.com/tierklinik-dobersberg/apis/gen/go/tkd/calendar/v1"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/app"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/config"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/repo"
+)
+
+func newTestNoteStore(t *testing.T) *NoteStore {
+	t.Helper()
+
+	notes, err := NewNoteStore(config.Notes{
+		AllowedRoles: []string{"vet"},
+		StoreFile:    filepath.Join(t.TempDir(), "notes.json"),
+	})
+	require.NoError(t, err)
+
+	return notes
+}
+
+func Test_NoteStore(t *testing.T) {
+	notes := newTestNoteStore(t)
+
+	_, err := notes.set("vet-1", "1", "aggressive dog, muzzle!", "alice")
+	require.NoError(t, err)
+
+	require.NoError(t, notes.move("vet-1", "1", "vet-2", "1"))
+
+	_, ok := notes.get("vet-1", "1")
+	assert.False(t, ok)
+
+	// notes are persisted
+	reloaded, err := NewNoteStore(config.Notes{StoreFile: notes.storeFile})
+	require.NoError(t, err)
+
+	note, ok := reloaded.get("vet-2", "1")
+	require.True(t, ok)
+	assert.Equal(t, "aggressive dog, muzzle!", note.Text)
+	assert.Equal(t, "alice", note.UpdatedBy)
+
+	require.NoError(t, notes.delete("vet-2", "1"))
+
+	reloaded, err = NewNoteStore(config.Notes{StoreFile: notes.storeFile})
+	require.NoError(t, err)
+
+	_, ok = reloaded.get("vet-2", "1")
+	assert.False(t, ok)
+
+	// a disabled store ignores moves and deletions
+	var disabled *NoteStore
+	assert.NoError(t, disabled.move("vet-1", "1", "vet-2", "1"))
+	assert.NoError(t, disabled.delete("vet-1", "1"))
+	assert.False(t, disabled.visibleTo([]string{"vet"}))
+}
+
+func Test_MoveEvent_MovesNote(t *testing.T) {
+	svc, bookings := newBookingTestService(t)
+	svc.notes = newTestNoteStore(t)
+
+	start := time.Date(2024, time.June, 3, 8, 0, 0, 0, time.UTC)
+	svc.repo = &app.App{Service: &moveRepo{
+		bookingRepo: bookings,
+		event:       repo.Event{ID: "1", CalendarID: "vet-1", StartTime: start, EndTime: ptr(start.Add(30 * time.Minute))},
+	}}
+
+	_, err := svc.notes.set("vet-1", "1", "aggressive dog, muzzle!", "alice")
+	require.NoError(t, err)
+
+	_, err = svc.MoveEvent(context.Background(), connect.NewRequest(&calendarv1.MoveEventRequest{
+		EventId: "1",
+		Source: &calendarv1.MoveEventRequest_SourceCalendarId{
+			SourceCalendarId: "vet-1",
+		},
+		Target: &calendarv1.MoveEventRequest_TargetCalendarId{
+			TargetCalendarId: "vet-2",
+		},
+	}))
+	require.NoError(t, err)
+
+	_, ok := svc.notes.get("vet-1", "1")
+	assert.False(t, ok)
+
+	note, ok := svc.notes.get("vet-2", "1")
+	require.True(t, ok)
+	assert.Equal(t, "vet-2", note.CalendarID)
+	assert.Equal(t, "aggressive dog, muzzle!", note.Text)
+}
+
+func Test_DeleteEvent_DeletesNote(t *testing.T) {
+	svc, _ := newChannelTestService(t)
+	svc.notes = newTestNoteStore(t)
+
+	res, err := svc.CreateEvent(context.Background(), createEventRequest(t, "14:00", "15:00", "huber"))
+	require.NoError(t, err)
+
+	id := res.Msg.Event.Id
+
+	_, err = svc.notes.set("vet-1", id, "aggressive dog, muzzle!", "alice")
+	require.NoError(t, err)
+
+	_, err = svc.DeleteEvent(context.Background(), connect.NewRequest(&calendarv1.DeleteEventRequest{
+		CalendarId: "vet-1",
+		EventId:    id,
+	}))
+	require.NoError(t, err)
+
+	_, ok := svc.notes.get("vet-1", id)
+	assert.False(t, ok)
+}
+
+func Test_ListEvents_Notes(t *testing.T) {
+	svc, _ := newMaskTestService(2, 2)
+	svc.notes = newTestNoteStore(t)
+
+	_, err := svc.notes.set("cal-1", "0", "aggressive dog, muzzle!", "alice")
+	require.NoError(t, err)
+
+	list := func(roles ...string) []string {
+		req := listEventsRequest(2)
+		for _, role := range roles {
+			req.Header().Add("X-Remote-Role", role)
+		}
+
+		res, err := svc.ListEvents(context.Background(), req)
+		require.NoError(t, err)
+
+		return res.Header().Values(eventNoteHeader)
+	}
+
+	assert.Equal(t, []string{`cal-1 0 "aggressive dog, muzzle!"`}, list("vet"))
+	assert.Empty(t, list("reception"))
+	assert.Empty(t, list())
+}
+
+func Test_NoteHandler(t *testing.T) {
+	svc, _ := newChannelTestService(t)
+	svc.notes = newTestNoteStore(t)
+
+	res, err := svc.CreateEvent(context.Background(), createEventRequest(t, "14:00", "15:00", "huber"))
+	require.NoError(t, err)
+
+	h := NewNoteHandler(svc)
+
+	put := func(body string, roles ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPut, "/events/note", bytes.NewBufferString(body))
+		req.Header.Set("X-Remote-User-ID", "alice")
+		for _, role := range roles {
+			req.Header.Add("X-Remote-Role", role)
+		}
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		return rec
+	}
+
+	body := `{"calendarId": "vet-1", "eventId": "` + res.Msg.Event.Id + `", "note": "aggressive dog, muzzle!"}`
+
+	assert.Equal(t, http.StatusForbidden, put(body, "reception").Code)
+	assert.Equal(t, http.StatusOK, put(body, "vet").Code)
+
+	note, ok := svc.notes.get("vet-1", res.Msg.Event.Id)
+	require.True(t, ok)
+	assert.Equal(t, "alice", note.UpdatedBy)
+
+	// notes can only be added to existing events
+	assert.Equal(t, http.StatusNotFound, put(`{"calendarId": "vet-1", "eventId": "unknown", "note": "x"}`, "vet").Code)
+
+	req := httptest.NewRequest(http.MethodGet, "/events/note?calendar=vet-1&event="+res.Msg.Event.Id, nil)
+	req.Header.Set("X-Remote-Role", "vet")
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "aggressive dog, muzzle!")
+}
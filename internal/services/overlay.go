@@ -0,0 +1,84 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/tierklinik-dobersberg/cis-cal/internal/config"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/repo"
+)
+
+// excludeOverlaysHeader may be set to true on ListEvents requests to
+// exclude read-only overlay events from the results. Overlay events are
+// still considered for free slots. The ListEventsRequest does not yet have
+// a field for it.
+const excludeOverlaysHeader = "X-Exclude-Overlays"
+
+// overlayIDPrefix is prepended to the ids of overlay events so they can be
+// told apart from the events of the target calendar.
+const overlayIDPrefix = "overlay-"
+
+// overlayLister is an eventLister that merges the events of all configured
+// overlay calendars into the events of their target calendar.
+type overlayLister struct {
+	eventLister
+
+	sources map[string][]string
+}
+
+func newOverlayLister(lister eventLister, overlays []config.Overlay) *overlayLister {
+	sources := make(map[string][]string)
+	for _, o := range overlays {
+		sources[o.Target] = append(sources[o.Target], o.Source)
+	}
+
+	return &overlayLister{
+		eventLister: lister,
+		sources:     sources,
+	}
+}
+
+func (ol *overlayLister) ListEvents(ctx context.Context, calendarID string, filter ...repo.SearchOption) ([]repo.Event, error) {
+	events, err := ol.eventLister.ListEvents(ctx, calendarID, filter...)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, source := range ol.sources[calendarID] {
+		overlay, err := ol.eventLister.ListEvents(ctx, source, filter...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load overlay events from %s: %w", source, err)
+		}
+
+		for _, e := range overlay {
+			e.ID = overlayIDPrefix + e.ID
+			e.CalendarID = calendarID
+			e.OverlayOf = source
+
+			// customer data of the source calendar is not exposed
+			e.Data = nil
+
+			events = append(events, e)
+		}
+	}
+
+	return events, nil
+}
+
+// isOverlayEvent reports whether id belongs to a read-only overlay event.
+func isOverlayEvent(id string) bool {
+	return strings.HasPrefix(id, overlayIDPrefix)
+}
+
+// withoutOverlays removes all overlay events from events.
+func withoutOverlays(events []repo.Event) []repo.Event {
+	result := events[:0]
+	for _, e := range events {
+		if e.OverlayOf == "" {
+			result = append(result, e)
+		}
+	}
+
+	return result
+}
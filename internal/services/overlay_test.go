@@ -0,0 +1,121 @@
+package services
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	calendarv1 "github.com/tierklinik-dobersberg/apis/gen/go/tkd/calendar/v1"
+	rosterv1 "github.com/tierklinik-dobersberg/apis/gen/go/tkd/roster/v1"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/config"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/repo"
+)
+
+// calendarLister returns the events of each calendar by id.
+type calendarLister map[string][]repo.Event
+
+func (c calendarLister) ListEvents(_ context.Context, calID string, _ ...repo.SearchOption) ([]repo.Event, error) {
+	// callers may modify the events, like for redaction
+	return slices.Clone(c[calID]), nil
+}
+
+func Test_OverlayLister(t *testing.T) {
+	lister := newOverlayLister(calendarLister{
+		"vet": {
+			{ID: "1", CalendarID: "vet", Summary: "Bello", StartTime: makeTime("08:00"), EndTime: ptr(makeTime("09:00"))},
+		},
+		"hr": {
+			{ID: "2", CalendarID: "hr", Summary: "Urlaub", StartTime: makeTime("10:00"), EndTime: ptr(makeTime("12:00")), Data: &repo.StructuredEvent{CustomerID: "1"}},
+		},
+	}, []config.Overlay{{Target: "vet", Source: "hr"}})
+
+	events, err := lister.ListEvents(context.Background(), "vet")
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+
+	overlay := events[1]
+	assert.Equal(t, "overlay-2", overlay.ID)
+	assert.Equal(t, "vet", overlay.CalendarID)
+	assert.Equal(t, "hr", overlay.OverlayOf)
+	assert.Nil(t, overlay.Data)
+	assert.True(t, isOverlayEvent(overlay.ID))
+
+	// the source calendar itself is not changed
+	events, err = lister.ListEvents(context.Background(), "hr")
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, "2", events[0].ID)
+
+	assert.Len(t, withoutOverlays([]repo.Event{{ID: "1"}, overlay}), 1)
+}
+
+func Test_OverlayLister_BlocksFreeSlots(t *testing.T) {
+	lister := newOverlayLister(calendarLister{
+		"hr": {
+			{ID: "vacation", StartTime: makeTime("10:00"), EndTime: ptr(makeTime("12:00"))},
+		},
+	}, []config.Overlay{{Target: "vet", Source: "hr"}})
+
+	windows := mergeShifts([]*rosterv1.PlannedShift{makeShift("1", "08:00", "14:00")})
+
+	slots, failed := freeSlotsForWindows(context.Background(), lister, "vet", windows, 0, func(shift *rosterv1.PlannedShift) repo.FreeSlotInfo {
+		return repo.FreeSlotInfo{ShiftID: shift.UniqueId}
+	})
+	require.Empty(t, failed)
+
+	var got []timeRange
+	for _, s := range slots {
+		got = append(got, timeRange{s.StartTime.UTC(), s.EndTime.UTC()})
+	}
+
+	assert.Equal(t, []timeRange{
+		makeRange("08:00", "10:00"),
+		makeRange("12:00", "14:00"),
+	}, got)
+}
+
+func Test_ListEvents_ExcludeOverlays(t *testing.T) {
+	svc, fake := newMaskTestService(2, 3)
+	svc.events = newOverlayLister(fake, []config.Overlay{{Target: "cal-0", Source: "cal-1"}})
+
+	req := listEventsRequest(1)
+
+	res, err := svc.ListEvents(context.Background(), req)
+	require.NoError(t, err)
+	require.Len(t, res.Msg.Results, 1)
+	assert.Len(t, res.Msg.Results[0].Events, 6)
+
+	req.Header().Set(excludeOverlaysHeader, "true")
+
+	res, err = svc.ListEvents(context.Background(), req)
+	require.NoError(t, err)
+	require.Len(t, res.Msg.Results, 1)
+	assert.Len(t, res.Msg.Results[0].Events, 3)
+}
+
+func Test_OverlayEvents_AreReadOnly(t *testing.T) {
+	svc, _ := newMaskTestService(1, 0)
+
+	_, err := svc.UpdateEvent(context.Background(), connect.NewRequest(&calendarv1.UpdateEventRequest{
+		CalendarId: "cal-0",
+		EventId:    "overlay-1",
+		Name:       "changed",
+	}))
+	assert.Equal(t, connect.CodePermissionDenied, connect.CodeOf(err))
+
+	_, err = svc.DeleteEvent(context.Background(), connect.NewRequest(&calendarv1.DeleteEventRequest{
+		CalendarId: "cal-0",
+		EventId:    "overlay-1",
+	}))
+	assert.Equal(t, connect.CodePermissionDenied, connect.CodeOf(err))
+
+	_, err = svc.MoveEvent(context.Background(), connect.NewRequest(&calendarv1.MoveEventRequest{
+		EventId: "overlay-1",
+		Source:  &calendarv1.MoveEventRequest_SourceCalendarId{SourceCalendarId: "cal-0"},
+		Target:  &calendarv1.MoveEventRequest_TargetCalendarId{TargetCalendarId: "cal-1"},
+	}))
+	assert.Equal(t, connect.CodePermissionDenied, connect.CodeOf(err))
+}
@@ -0,0 +1,159 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	calendarv1 "github.com/tierklinik-dobersberg/apis/gen/go/tkd/calendar/v1"
+	idmv1 "github.com/tierklinik-dobersberg/apis/gen/go/tkd/idm/v1"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/cache"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/repo"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// failingLister fails to list the events of the calendars in failing.
+type failingLister struct {
+	eventLister
+
+	failing map[string]bool
+}
+
+func (f failingLister) ListEvents(ctx context.Context, calID string, opts ...repo.SearchOption) ([]repo.Event, error) {
+	if f.failing[calID] {
+		return nil, connect.NewError(connect.CodeUnavailable, errors.New("feed unreachable"))
+	}
+
+	return f.eventLister.ListEvents(ctx, calID, opts...)
+}
+
+func Test_ListEvents_PartialFailure(t *testing.T) {
+	svc, fake := newMaskTestService(3, 2)
+	svc.events = failingLister{eventLister: fake, failing: map[string]bool{"cal-1": true}}
+
+	// explicitly listed calendars fail the whole request by default
+	_, err := svc.ListEvents(context.Background(), listEventsRequest(3))
+	require.Error(t, err)
+	assert.Equal(t, connect.CodeUnavailable, connect.CodeOf(err))
+
+	// unless partial results are allowed
+	req := listEventsRequest(3)
+	req.Header().Set(allowPartialHeader, "true")
+
+	res, err := svc.ListEvents(context.Background(), req)
+	require.NoError(t, err)
+	require.Len(t, res.Msg.Results, 2)
+	assert.Equal(t, "cal-0", res.Msg.Results[0].Calendar.Id)
+	assert.Equal(t, "cal-2", res.Msg.Results[1].Calendar.Id)
+	assert.Equal(t, []string{`cal-1 unavailable "feed unreachable"`}, res.Header().Values(calendarErrorHeader))
+	assert.NotEmpty(t, res.Header().Get("Warning"))
+
+	// the error header survives read masks
+	req = listEventsRequest(3, "results.events.id")
+	req.Header().Set(allowPartialHeader, "true")
+
+	res, err = svc.ListEvents(context.Background(), req)
+	require.NoError(t, err)
+	assert.Len(t, res.Msg.Results, 2)
+	assert.Len(t, res.Header().Values(calendarErrorHeader), 1)
+
+	// partial results are the default when querying all calendars
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	svc.calendars = cache.NewCache[repo.Calendar]("calendars", time.Minute, cache.LoaderFunc[repo.Calendar](func(context.Context) ([]repo.Calendar, error) {
+		return []repo.Calendar{{ID: "cal-0"}, {ID: "cal-1"}, {ID: "cal-2"}}, nil
+	}))
+	svc.calendars.Start(ctx)
+
+	require.Eventually(t, func() bool {
+		cals, _ := svc.calendars.Get()
+		return len(cals) == 3
+	}, time.Second, 10*time.Millisecond)
+
+	res, err = svc.ListEvents(context.Background(), connect.NewRequest(&calendarv1.ListEventsRequest{
+		Source:     &calendarv1.ListEventsRequest_AllCalendars{AllCalendars: true},
+		SearchTime: &calendarv1.ListEventsRequest_Date{Date: "2024-06-03"},
+	}))
+	require.NoError(t, err)
+	assert.Len(t, res.Msg.Results, 2)
+	assert.Len(t, res.Header().Values(calendarErrorHeader), 1)
+
+	// if all calendars fail the request fails
+	svc.events = failingLister{eventLister: fake, failing: map[string]bool{"cal-0": true, "cal-1": true, "cal-2": true}}
+
+	req = listEventsRequest(3)
+	req.Header().Set(allowPartialHeader, "true")
+
+	_, err = svc.ListEvents(context.Background(), req)
+	assert.Equal(t, connect.CodeUnavailable, connect.CodeOf(err))
+}
+
+func Test_ListEvents_UnresolvedSources(t *testing.T) {
+	svc, _ := newMaskTestService(2, 1)
+
+	svc.byUserId = cache.NewIndex(func(p *idmv1.Profile) (string, bool) {
+		return p.User.Id, true
+	})
+	svc.byUserId.Update([]*idmv1.Profile{
+		{User: &idmv1.User{Id: "maier", Extra: &structpb.Struct{Fields: map[string]*structpb.Value{
+			"calendarID": structpb.NewStringValue("cal-0"),
+		}}}},
+		{User: &idmv1.User{Id: "huber"}},
+	})
+
+	request := func(calendarIds []string, userIds []string) *connect.Request[calendarv1.ListEventsRequest] {
+		return connect.NewRequest(&calendarv1.ListEventsRequest{
+			Source: &calendarv1.ListEventsRequest_Sources{Sources: &calendarv1.EventSource{
+				CalendarIds: calendarIds,
+				UserIds:     userIds,
+			}},
+			SearchTime: &calendarv1.ListEventsRequest_Date{Date: "2024-06-03"},
+		})
+	}
+
+	// unknown users and users without a calendar are reported
+	res, err := svc.ListEvents(context.Background(), request(nil, []string{"maier", "huber", "unknown", "unknown"}))
+	require.NoError(t, err)
+	require.Len(t, res.Msg.Results, 1)
+	assert.Equal(t, "cal-0", res.Msg.Results[0].Calendar.Id)
+	assert.Equal(t, []string{"user huber no-calendar", "user unknown not-found"}, res.Header().Values(unresolvedSourceHeader))
+
+	// if no source resolves the request fails
+	_, err = svc.ListEvents(context.Background(), request(nil, []string{"unknown"}))
+	assert.Equal(t, connect.CodeAborted, connect.CodeOf(err))
+	assert.Contains(t, err.Error(), "user unknown not-found")
+
+	// unknown calendars are only skipped with partial results once the
+	// calendar list has been loaded
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	svc.calendars = cache.NewCache[repo.Calendar]("calendars", time.Minute, cache.LoaderFunc[repo.Calendar](func(context.Context) ([]repo.Calendar, error) {
+		return []repo.Calendar{{ID: "cal-0"}, {ID: "cal-1"}}, nil
+	}))
+	svc.calendars.Start(ctx)
+
+	require.Eventually(t, func() bool {
+		last, _ := svc.calendars.LastFetch()
+		return !last.IsZero()
+	}, time.Second, 10*time.Millisecond)
+
+	req := request([]string{"cal-1", "cal-9"}, []string{"maier"})
+	req.Header().Set(allowPartialHeader, "true")
+
+	res, err = svc.ListEvents(context.Background(), req)
+	require.NoError(t, err)
+	assert.Len(t, res.Msg.Results, 2)
+	assert.Equal(t, []string{"calendar cal-9 not-found"}, res.Header().Values(unresolvedSourceHeader))
+	assert.Empty(t, res.Header().Values(calendarErrorHeader))
+
+	// without partial results unknown calendars are left to the backend
+	res, err = svc.ListEvents(context.Background(), request([]string{"cal-1", "cal-9"}, nil))
+	require.NoError(t, err)
+	assert.Empty(t, res.Header().Values(unresolvedSourceHeader))
+}
@@ -0,0 +1,140 @@
+package services
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/tierklinik-dobersberg/cis-cal/internal/config"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/cron"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/repo"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+var prefetchDuration, _ = otel.Meter("").Float64Histogram(
+	"calendar.prefetch_duration",
+	metric.WithDescription("Duration of scheduled event cache prefetches"),
+	metric.WithUnit("s"),
+)
+
+// prefetchJob is a parsed config.Prefetch entry.
+type prefetchJob struct {
+	schedule  cron.Schedule
+	days      int
+	calendars []string
+}
+
+// prefetcher warms the event caches for configured ranges so views that are
+// requested at well-known times are served from the cache. Upstream loads
+// use the regular ListEvents path and are thus bounded by the upstream
+// limiter.
+type prefetcher struct {
+	events eventLister
+	jobs   []prefetchJob
+
+	// userCalendars returns the calendars assigned to users and is used
+	// for jobs without explicit calendars.
+	userCalendars func() []string
+
+	now func() time.Time
+}
+
+func newPrefetcher(events eventLister, cfg []config.Prefetch, userCalendars func() []string) *prefetcher {
+	p := &prefetcher{
+		events:        events,
+		userCalendars: userCalendars,
+		now:           time.Now,
+	}
+
+	for _, c := range cfg {
+		// the schedule has already been validated when loading the
+		// configuration
+		schedule, err := cron.Parse(c.Schedule)
+		if err != nil {
+			slog.Error("invalid prefetch schedule", "schedule", c.Schedule, "error", err)
+			continue
+		}
+
+		p.jobs = append(p.jobs, prefetchJob{
+			schedule:  schedule,
+			days:      c.Days,
+			calendars: c.Calendars,
+		})
+	}
+
+	return p
+}
+
+// Start runs each prefetch job whenever it's schedule fires until ctx is
+// cancelled.
+func (p *prefetcher) Start(ctx context.Context) {
+	for _, job := range p.jobs {
+		go p.run(ctx, job)
+	}
+}
+
+func (p *prefetcher) run(ctx context.Context, job prefetchJob) {
+	ctx = repo.WithQuotaCaller(ctx, "prefetch")
+
+	for {
+		next := job.schedule.Next(p.now())
+		if next.IsZero() {
+			return
+		}
+
+		timer := time.NewTimer(time.Until(next))
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		p.prefetch(ctx, job)
+	}
+}
+
+// prefetch loads the events of all calendars of job starting today.
+func (p *prefetcher) prefetch(ctx context.Context, job prefetchJob) {
+	calendars := job.calendars
+	if len(calendars) == 0 {
+		calendars = p.userCalendars()
+	}
+
+	now := p.now()
+	start := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	end := start.AddDate(0, 0, job.days)
+
+	begin := time.Now()
+	failed := 0
+
+	for _, calID := range calendars {
+		if ctx.Err() != nil {
+			return
+		}
+
+		if _, err := p.events.ListEvents(ctx, calID, repo.WithEventsAfter(start), repo.WithEventsBefore(end)); err != nil {
+			slog.Error("failed to prefetch calendar events", "calendar-id", calID, "error", err)
+			failed++
+		}
+	}
+
+	duration := time.Since(begin)
+
+	result := "success"
+	if failed > 0 {
+		result = "failed"
+	}
+	prefetchDuration.Record(ctx, duration.Seconds(), metric.WithAttributes(attribute.String("result", result)))
+
+	slog.Info("prefetched calendar events", "calendars", len(calendars), "failed", failed, "days", job.days, "duration", duration)
+}
+
+// userCalendarIds returns the sorted ids of all calendars assigned to users.
+func (svc *CalendarService) userCalendarIds() []string {
+	return slices.Sorted(svc.userByCalId.Keys())
+}
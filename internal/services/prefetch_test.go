@@ -0,0 +1,64 @@
+package services
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/config"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/repo"
+)
+
+// rangeRecorder records the calendar and search range of each ListEvents
+// call.
+type rangeRecorder struct {
+	l     sync.Mutex
+	calls map[string]repo.EventSearchOptions
+}
+
+func (r *rangeRecorder) ListEvents(_ context.Context, calID string, filter ...repo.SearchOption) ([]repo.Event, error) {
+	opts := new(repo.EventSearchOptions)
+	for _, fn := range filter {
+		fn(opts)
+	}
+
+	r.l.Lock()
+	defer r.l.Unlock()
+
+	r.calls[calID] = *opts
+
+	return nil, nil
+}
+
+func Test_Prefetcher(t *testing.T) {
+	rec := &rangeRecorder{calls: make(map[string]repo.EventSearchOptions)}
+
+	p := newPrefetcher(rec, []config.Prefetch{
+		{Schedule: "0 5 * * 1-5", Days: 14},
+		{Schedule: "0 3 * * *", Days: 1, Calendars: []string{"op"}},
+	}, func() []string {
+		return []string{"vet-1", "vet-2"}
+	})
+	require.Len(t, p.jobs, 2)
+
+	now := time.Date(2024, time.June, 3, 5, 0, 0, 0, time.UTC)
+	p.now = func() time.Time { return now }
+
+	// jobs without calendars prefetch all user calendars
+	p.prefetch(context.Background(), p.jobs[0])
+
+	require.Len(t, rec.calls, 2)
+	opts := rec.calls["vet-1"]
+	require.NotNil(t, opts.FromTime)
+	require.NotNil(t, opts.ToTime)
+	assert.Equal(t, time.Date(2024, time.June, 3, 0, 0, 0, 0, time.UTC), *opts.FromTime)
+	assert.Equal(t, time.Date(2024, time.June, 17, 0, 0, 0, 0, time.UTC), *opts.ToTime)
+
+	p.prefetch(context.Background(), p.jobs[1])
+
+	require.Len(t, rec.calls, 3)
+	assert.Equal(t, time.Date(2024, time.June, 4, 0, 0, 0, 0, time.UTC), *rec.calls["op"].ToTime)
+}
@@ -0,0 +1,229 @@
+package services
+
+import (
+	"bytes"
+	_ "embed"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bufbuild/connect-go"
+	calendarv1 "github.com/tierklinik-dobersberg/apis/gen/go/tkd/calendar/v1"
+	"github.com/tierklinik-dobersberg/apis/pkg/privacy"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/i18n"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/richtext"
+)
+
+//go:embed templates/print_day.html
+var defaultPrintTemplate string
+
+// printDay is passed to the print template.
+type printDay struct {
+	Date    time.Time
+	Title   string
+	Columns []printColumn
+}
+
+// printColumn holds the events of a single calendar.
+type printColumn struct {
+	ID     string
+	Name   string
+	Color  string
+	Events []printEvent
+}
+
+type printEvent struct {
+	Start    string
+	End      string
+	FullDay  bool
+	Summary  string
+	Customer string
+
+	// Description is sanitized so it can be rendered as HTML.
+	Description template.HTML
+}
+
+// PrintHandler renders the events of a single day as a printable HTML page
+// with one column per calendar:
+//
+//	GET /print/day?date=2024-06-03&calendars=<id>,<id>
+//
+// Without calendars the calendars of all users are printed, without date the
+// current day. Events are loaded using ListEvents so the same filters and
+// privacy rules apply as for the requesting user.
+type PrintHandler struct {
+	svc  *CalendarService
+	tmpl *template.Template
+	now  func() time.Time
+}
+
+// NewPrintHandler returns a new print handler. If templatePath is empty the
+// built-in template is used.
+func NewPrintHandler(svc *CalendarService, templatePath string) (*PrintHandler, error) {
+	var (
+		tmpl *template.Template
+		err  error
+	)
+
+	if templatePath != "" {
+		tmpl, err = template.ParseFiles(templatePath)
+	} else {
+		tmpl, err = template.New("print_day.html").Parse(defaultPrintTemplate)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &PrintHandler{
+		svc:  svc,
+		tmpl: tmpl,
+		now:  time.Now,
+	}, nil
+}
+
+func (h *PrintHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+
+	now := h.now()
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+
+	if v := query.Get("date"); v != "" {
+		var err error
+		day, err = time.ParseInLocation("2006-01-02", v, time.Local)
+		if err != nil {
+			http.Error(w, "invalid value for date, expected YYYY-MM-DD", http.StatusBadRequest)
+			return
+		}
+	}
+
+	var calendarIds []string
+	for _, v := range query["calendars"] {
+		for _, id := range strings.Split(v, ",") {
+			if id = strings.TrimSpace(id); id != "" {
+				calendarIds = append(calendarIds, id)
+			}
+		}
+	}
+
+	req := connect.NewRequest(&calendarv1.ListEventsRequest{
+		SearchTime: &calendarv1.ListEventsRequest_Date{
+			Date: day.Format("2006-01-02"),
+		},
+		RequestKinds: []calendarv1.CalenarEventRequestKind{
+			calendarv1.CalenarEventRequestKind_CALENDAR_EVENT_REQUEST_KIND_EVENTS,
+		},
+	})
+
+	if len(calendarIds) > 0 {
+		req.Msg.Source = &calendarv1.ListEventsRequest_Sources{
+			Sources: &calendarv1.EventSource{CalendarIds: calendarIds},
+		}
+	} else {
+		req.Msg.Source = &calendarv1.ListEventsRequest_AllUsers{AllUsers: true}
+	}
+
+	for _, key := range []string{"X-Remote-User-ID", "X-Remote-Role", eventTagHeader} {
+		for _, v := range r.Header.Values(key) {
+			req.Header().Add(key, v)
+		}
+	}
+
+	lang := i18n.Match(r.Header.Get("Accept-Language"), i18n.Language(h.svc.repo.Config.DefaultLanguage))
+	ctx := i18n.WithLanguage(r.Context(), lang)
+
+	res, err := h.svc.ListEvents(ctx, req)
+	if err != nil {
+		http.Error(w, err.Error(), httpStatus(err))
+		return
+	}
+
+	// ListEvents is called directly so the privacy interceptor does not
+	// run.
+	if err := privacy.FilterAllowedFields(res.Msg, r.Header.Get("X-Remote-User-ID"), r.Header.Values("X-Remote-Role")); err != nil {
+		slog.Error("failed to apply privacy rules to print view", "error", err)
+		http.Error(w, "failed to apply privacy rules", http.StatusInternalServerError)
+
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := h.tmpl.Execute(&buf, newPrintDay(day, res.Msg)); err != nil {
+		slog.Error("failed to render print template", "error", err)
+		http.Error(w, "failed to render template", http.StatusInternalServerError)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, _ = w.Write(buf.Bytes())
+}
+
+// newPrintDay converts a ListEvents response to the print template data.
+func newPrintDay(day time.Time, res *calendarv1.ListEventsResponse) printDay {
+	result := printDay{
+		Date:  day,
+		Title: day.Format("02.01.2006"),
+	}
+
+	for _, list := range res.Results {
+		col := printColumn{}
+		if cal := list.Calendar; cal != nil {
+			col.ID = cal.Id
+			col.Name = cal.Name
+			col.Color = cal.Color
+		}
+
+		for _, e := range list.Events {
+			if col.ID == "" {
+				col.ID = e.CalendarId
+				col.Name = e.CalendarId
+			}
+
+			evt := printEvent{
+				Start:       e.StartTime.AsTime().In(time.Local).Format("15:04"),
+				FullDay:     e.FullDay,
+				Summary:     e.Summary,
+				Description: template.HTML(richtext.Sanitize(e.Description)),
+			}
+
+			if e.EndTime != nil {
+				evt.End = e.EndTime.AsTime().In(time.Local).Format("15:04")
+			}
+
+			var customer calendarv1.CustomerAnnotation
+			if e.ExtraData != nil && e.ExtraData.MessageIs(&customer) && e.ExtraData.UnmarshalTo(&customer) == nil {
+				evt.Customer = customer.CustomerId
+			}
+
+			col.Events = append(col.Events, evt)
+		}
+
+		result.Columns = append(result.Columns, col)
+	}
+
+	return result
+}
+
+// httpStatus returns the HTTP status code for a ListEvents or repository
+// error.
+func httpStatus(err error) int {
+	switch connect.CodeOf(repoError(err)) {
+	case connect.CodeInvalidArgument, connect.CodeResourceExhausted:
+		return http.StatusBadRequest
+	case connect.CodeNotFound, connect.CodeAborted:
+		// ListEvents aborts if there are no calendars to query
+		return http.StatusNotFound
+	case connect.CodePermissionDenied:
+		return http.StatusForbidden
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -0,0 +1,62 @@
+package services
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_PrintHandler(t *testing.T) {
+	svc, _ := newMaskTestService(2, 2)
+
+	h, err := NewPrintHandler(svc, "")
+	require.NoError(t, err)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/print/day?date=2024-06-03&calendars=cal-0,cal-1", nil))
+
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "text/html; charset=utf-8", rec.Header().Get("Content-Type"))
+
+	body := rec.Body.String()
+	assert.Contains(t, body, "03.06.2024")
+	assert.Contains(t, body, "Calendar 0")
+	assert.Contains(t, body, "Calendar 1")
+	assert.Contains(t, body, "08:00")
+	assert.Contains(t, body, "Appointment 1")
+	assert.Contains(t, body, "referral letter")
+	assert.Contains(t, body, `<div class="customer">1234</div>`)
+
+	// invalid dates are rejected
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/print/day?date=03.06.2024", nil))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/print/day", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
+
+func Test_PrintHandler_Template(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "day.html")
+	require.NoError(t, os.WriteFile(path, []byte(`{{ .Title }}{{ range .Columns }}|{{ .Name }}:{{ len .Events }}{{ end }}`), 0o600))
+
+	svc, _ := newMaskTestService(1, 3)
+
+	h, err := NewPrintHandler(svc, path)
+	require.NoError(t, err)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/print/day?date=2024-06-03&calendars=cal-0", nil))
+
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "03.06.2024|Calendar 0:3", rec.Body.String())
+
+	_, err = NewPrintHandler(svc, filepath.Join(t.TempDir(), "missing.html"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,115 @@
+package services
+
+import (
+	"sync"
+
+	calendarv1 "github.com/tierklinik-dobersberg/apis/gen/go/tkd/calendar/v1"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/repo"
+)
+
+// maxProtoCacheEntries limits the size of the proto cache. The cache is
+// reset once it grows beyond that, which also drops deleted events.
+const maxProtoCacheEntries = 50000
+
+type protoCacheKey struct {
+	calendarID string
+	eventID    string
+	overlayOf  string
+}
+
+type protoCacheEntry struct {
+	event repo.Event
+	pb    *calendarv1.CalendarEvent
+}
+
+// protoCache caches the protobuf representation of events so unchanged
+// events are not converted, and their extra data is not marshaled, on every
+// ListEvents request.
+//
+// Entries are keyed by calendar and event id and are only used if the etag
+// and all fields used by Event.ToProto still match, so events that are
+// modified before the conversion, like localized summaries, are converted
+// again. A nil protoCache is valid and disables caching.
+type protoCache struct {
+	l       sync.Mutex
+	entries map[protoCacheKey]protoCacheEntry
+}
+
+func newProtoCache() *protoCache {
+	return &protoCache{
+		entries: make(map[protoCacheKey]protoCacheEntry),
+	}
+}
+
+// cacheable reports whether the protobuf representation of e can be cached.
+// Synthetic events like free slots do not have an etag.
+func cacheable(e repo.Event) bool {
+	return e.Etag != "" && e.Slot == nil
+}
+
+// sameProto reports whether a and b have the same protobuf representation.
+// The customer annotation is not compared since it is part of the etag.
+func sameProto(a, b repo.Event) bool {
+	if a.Etag != b.Etag ||
+		a.Summary != b.Summary ||
+		a.Description != b.Description ||
+		a.FullDayEvent != b.FullDayEvent ||
+		a.IsFree != b.IsFree ||
+		!a.StartTime.Equal(b.StartTime) ||
+		(a.Data == nil) != (b.Data == nil) {
+		return false
+	}
+
+	if a.EndTime == nil || b.EndTime == nil {
+		return a.EndTime == b.EndTime
+	}
+
+	return a.EndTime.Equal(*b.EndTime)
+}
+
+// toProto converts e to it's protobuf representation. The result may share
+// its timestamps and extra data with other results so callers must not
+// modify nested messages. Top-level fields may be set or cleared.
+func (c *protoCache) toProto(e repo.Event) (*calendarv1.CalendarEvent, error) {
+	if c == nil || !cacheable(e) {
+		return e.ToProto()
+	}
+
+	key := protoCacheKey{
+		calendarID: e.CalendarID,
+		eventID:    e.ID,
+		overlayOf:  e.OverlayOf,
+	}
+
+	c.l.Lock()
+	entry, ok := c.entries[key]
+	c.l.Unlock()
+
+	if !ok || !sameProto(entry.event, e) {
+		pb, err := e.ToProto()
+		if err != nil {
+			return nil, err
+		}
+
+		entry = protoCacheEntry{event: e, pb: pb}
+
+		c.l.Lock()
+		if len(c.entries) >= maxProtoCacheEntries {
+			c.entries = make(map[protoCacheKey]protoCacheEntry)
+		}
+		c.entries[key] = entry
+		c.l.Unlock()
+	}
+
+	return &calendarv1.CalendarEvent{
+		Id:          entry.pb.Id,
+		CalendarId:  entry.pb.CalendarId,
+		StartTime:   entry.pb.StartTime,
+		EndTime:     entry.pb.EndTime,
+		FullDay:     entry.pb.FullDay,
+		ExtraData:   entry.pb.ExtraData,
+		Summary:     entry.pb.Summary,
+		Description: entry.pb.Description,
+		IsFree:      entry.pb.IsFree,
+	}, nil
+}
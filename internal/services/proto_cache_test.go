@@ -0,0 +1,142 @@
+package services
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/repo"
+	"google.golang.org/protobuf/proto"
+)
+
+func Test_ProtoCache(t *testing.T) {
+	end := time.Date(2024, 6, 3, 9, 0, 0, 0, time.UTC)
+	evt := repo.Event{
+		ID:          "1",
+		CalendarID:  "cal-1",
+		Summary:     "Vaccination",
+		Description: "annual",
+		StartTime:   time.Date(2024, 6, 3, 8, 0, 0, 0, time.UTC),
+		EndTime:     &end,
+		Etag:        "etag-1",
+		Data: &repo.StructuredEvent{
+			CustomerSource: "vetinf",
+			CustomerID:     "1234",
+		},
+	}
+
+	c := newProtoCache()
+
+	first, err := c.toProto(evt)
+	require.NoError(t, err)
+
+	expected, err := evt.ToProto()
+	require.NoError(t, err)
+	assert.True(t, proto.Equal(expected, first))
+
+	// unchanged events share the nested messages
+	second, err := c.toProto(evt)
+	require.NoError(t, err)
+	assert.True(t, proto.Equal(expected, second))
+	assert.NotSame(t, first, second)
+	assert.Same(t, first.ExtraData, second.ExtraData)
+
+	// clearing top-level fields does not modify the cache
+	second.Description = ""
+	second.ExtraData = nil
+
+	third, err := c.toProto(evt)
+	require.NoError(t, err)
+	assert.True(t, proto.Equal(expected, third))
+
+	// changed events are converted again
+	changed := evt
+	changed.Summary = "Surgery"
+
+	pb, err := c.toProto(changed)
+	require.NoError(t, err)
+	assert.Equal(t, "Surgery", pb.Summary)
+	assert.NotSame(t, first.ExtraData, pb.ExtraData)
+
+	changed = evt
+	changed.Etag = "etag-2"
+	changed.Data = &repo.StructuredEvent{CustomerID: "5678"}
+
+	pb, err = c.toProto(changed)
+	require.NoError(t, err)
+
+	expected, err = changed.ToProto()
+	require.NoError(t, err)
+	assert.True(t, proto.Equal(expected, pb))
+
+	// events without etag are never cached
+	evt.Etag = ""
+	_, err = c.toProto(evt)
+	require.NoError(t, err)
+	assert.Len(t, c.entries, 1)
+
+	// a nil cache converts every event
+	var disabled *protoCache
+	pb, err = disabled.toProto(changed)
+	require.NoError(t, err)
+	assert.True(t, proto.Equal(expected, pb))
+}
+
+func Test_ListEvents_ProtoCache(t *testing.T) {
+	svc, fake := newMaskTestService(2, 3)
+	for idx := range fake.events {
+		fake.events[idx].Etag = "etag-" + strconv.Itoa(idx)
+	}
+
+	expected, err := svc.ListEvents(context.Background(), listEventsRequest(2))
+	require.NoError(t, err)
+
+	svc.protos = newProtoCache()
+
+	for i := 0; i < 2; i++ {
+		res, err := svc.ListEvents(context.Background(), listEventsRequest(2))
+		require.NoError(t, err)
+		assert.True(t, proto.Equal(expected.Msg, res.Msg))
+	}
+
+	// masked requests do not use the cache
+	res, err := svc.ListEvents(context.Background(), listEventsRequest(2, "results.events.summary"))
+	require.NoError(t, err)
+	assert.Empty(t, res.Msg.Results[0].Events[0].Description)
+	assert.NotEmpty(t, svc.protos.entries)
+}
+
+// benchmarkWeekView repeatedly queries the same calendars like clients that
+// poll the week view do.
+func benchmarkWeekView(b *testing.B, cached bool) {
+	svc, fake := newMaskTestService(10, 50)
+	for idx := range fake.events {
+		fake.events[idx].Etag = "etag-" + strconv.Itoa(idx)
+	}
+
+	if cached {
+		svc.protos = newProtoCache()
+	}
+
+	req := listEventsRequest(10)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := svc.ListEvents(context.Background(), req); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func Benchmark_ListEvents_WeekView(b *testing.B) {
+	benchmarkWeekView(b, false)
+}
+
+func Benchmark_ListEvents_WeekView_ProtoCache(b *testing.B) {
+	benchmarkWeekView(b, true)
+}
@@ -0,0 +1,208 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/tierklinik-dobersberg/apis/gen/go/tkd/calendar/v1/calendarv1connect"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/config"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"golang.org/x/time/rate"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+var mutationCounter, _ = otel.Meter("").Int64Counter(
+	"calendar.mutations",
+	metric.WithDescription("Number of mutating requests per caller by result (allowed or limited)"),
+)
+
+// mutatingProcedures are subject to rate limiting.
+var mutatingProcedures = []string{
+	calendarv1connect.CalendarServiceCreateEventProcedure,
+	calendarv1connect.CalendarServiceUpdateEventProcedure,
+	calendarv1connect.CalendarServiceMoveEventProcedure,
+	calendarv1connect.CalendarServiceDeleteEventProcedure,
+}
+
+// limiterIdleTimeout is the time after which the bucket of an idle caller is
+// dropped.
+const limiterIdleTimeout = 10 * time.Minute
+
+type callerLimiter struct {
+	*rate.Limiter
+	lastSeen time.Time
+}
+
+// rateLimiter keeps a token bucket per caller.
+type rateLimiter struct {
+	cfg config.RateLimit
+	now func() time.Time
+
+	l         sync.Mutex
+	callers   map[string]*callerLimiter
+	lastPrune time.Time
+}
+
+func newRateLimiter(cfg config.RateLimit) *rateLimiter {
+	return &rateLimiter{
+		cfg:     cfg,
+		now:     time.Now,
+		callers: make(map[string]*callerLimiter),
+	}
+}
+
+// limitFor returns the most generous limit of all roles of the caller. The
+// global limit is used if no role specific limit applies.
+func (rl *rateLimiter) limitFor(roles []string) (rate.Limit, int) {
+	perMinute, burst := rl.cfg.PerMinute, rl.cfg.Burst
+
+	for _, r := range rl.cfg.Roles {
+		if slices.Contains(roles, r.Role) && r.PerMinute > perMinute {
+			perMinute, burst = r.PerMinute, r.Burst
+		}
+	}
+
+	return rate.Limit(perMinute / 60), burst
+}
+
+// allow reports whether the caller may issue another request. If not, the
+// duration after which the next request would be allowed is returned.
+func (rl *rateLimiter) allow(caller string, roles []string) (bool, time.Duration) {
+	if slices.ContainsFunc(roles, func(role string) bool {
+		return slices.Contains(rl.cfg.BypassRoles, role)
+	}) {
+		return true, 0
+	}
+
+	limit, burst := rl.limitFor(roles)
+	now := rl.now()
+
+	rl.l.Lock()
+	defer rl.l.Unlock()
+
+	if now.Sub(rl.lastPrune) > limiterIdleTimeout {
+		for key, c := range rl.callers {
+			if now.Sub(c.lastSeen) > limiterIdleTimeout {
+				delete(rl.callers, key)
+			}
+		}
+
+		rl.lastPrune = now
+	}
+
+	c, ok := rl.callers[caller]
+	if !ok {
+		c = &callerLimiter{Limiter: rate.NewLimiter(limit, burst)}
+		rl.callers[caller] = c
+	}
+	c.lastSeen = now
+
+	// the roles of a caller might have changed
+	if c.Limit() != limit {
+		c.SetLimitAt(now, limit)
+	}
+	if c.Burst() != burst {
+		c.SetBurstAt(now, burst)
+	}
+
+	r := c.ReserveN(now, 1)
+	if !r.OK() {
+		return false, time.Duration(math.MaxInt64)
+	}
+
+	if delay := r.DelayFrom(now); delay > 0 {
+		r.CancelAt(now)
+
+		return false, delay
+	}
+
+	return true, 0
+}
+
+// RateLimiter limits the number of requests per caller (X-Remote-User-ID).
+// It is shared by the Connect interceptor and the REST facade. If no global
+// limit is configured all requests are allowed.
+type RateLimiter struct {
+	limiter *rateLimiter
+}
+
+// NewRateLimiter returns a new rate limiter for cfg.
+func NewRateLimiter(cfg config.RateLimit) *RateLimiter {
+	return &RateLimiter{
+		limiter: newRateLimiter(cfg),
+	}
+}
+
+// limit returns a CodeResourceExhausted error if the bucket of key is
+// exhausted. The error carries the time after which the next request would
+// be allowed.
+func (l *RateLimiter) limit(key string, roles []string) *connect.Error {
+	if l.limiter.cfg.PerMinute <= 0 {
+		return nil
+	}
+
+	ok, retryAfter := l.limiter.allow(key, roles)
+	if ok {
+		return nil
+	}
+
+	slog.Warn("rate limit exceeded", "caller", key, "retry-after", retryAfter)
+
+	connectErr := connect.NewError(connect.CodeResourceExhausted, fmt.Errorf("too many requests, retry after %s", retryAfter.Round(time.Second)))
+	connectErr.Meta().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+
+	if detail, err := connect.NewErrorDetail(&errdetails.RetryInfo{
+		RetryDelay: durationpb.New(retryAfter),
+	}); err == nil {
+		connectErr.AddDetail(detail)
+	}
+
+	return connectErr
+}
+
+// Interceptor returns a unary interceptor that limits the number of
+// mutating requests per caller.
+func (l *RateLimiter) Interceptor() connect.UnaryInterceptorFunc {
+	return func(next connect.UnaryFunc) connect.UnaryFunc {
+		return func(ctx context.Context, req connect.AnyRequest) (connect.AnyResponse, error) {
+			if l.limiter.cfg.PerMinute <= 0 || !slices.Contains(mutatingProcedures, req.Spec().Procedure) {
+				return next(ctx, req)
+			}
+
+			caller := req.Header().Get("X-Remote-User-ID")
+			err := l.limit(caller, req.Header().Values("X-Remote-Role"))
+
+			result := "allowed"
+			if err != nil {
+				result = "limited"
+			}
+			mutationCounter.Add(ctx, 1, metric.WithAttributes(
+				attribute.String("caller", caller),
+				attribute.String("result", result),
+			))
+
+			if err != nil {
+				return nil, err
+			}
+
+			return next(ctx, req)
+		}
+	}
+}
+
+// NewRateLimitInterceptor returns a unary interceptor that limits the number
+// of mutating requests per caller (X-Remote-User-ID). If no global limit is
+// configured the interceptor is a no-op.
+func NewRateLimitInterceptor(cfg config.RateLimit) connect.UnaryInterceptorFunc {
+	return NewRateLimiter(cfg).Interceptor()
+}
@@ -0,0 +1,114 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	calendarv1 "github.com/tierklinik-dobersberg/apis/gen/go/tkd/calendar/v1"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/config"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+)
+
+func Test_RateLimiter_Burst(t *testing.T) {
+	now := time.Date(2024, 6, 3, 10, 0, 0, 0, time.UTC)
+
+	rl := newRateLimiter(config.RateLimit{
+		PerMinute: 60,
+		Burst:     5,
+		Roles: []config.RoleRateLimit{
+			{Role: "integration", PerMinute: 600, Burst: 20},
+		},
+		BypassRoles: []string{"service"},
+	})
+	rl.now = func() time.Time { return now }
+
+	// the burst is allowed, the next request is limited
+	for i := 0; i < 5; i++ {
+		ok, _ := rl.allow("alice", nil)
+		require.True(t, ok, "request %d", i)
+	}
+
+	ok, retryAfter := rl.allow("alice", nil)
+	assert.False(t, ok)
+	assert.Equal(t, time.Second, retryAfter)
+
+	// buckets are per caller
+	ok, _ = rl.allow("bob", nil)
+	assert.True(t, ok)
+
+	// a rejected request does not consume a token
+	now = now.Add(time.Second)
+	ok, _ = rl.allow("alice", nil)
+	assert.True(t, ok)
+	ok, _ = rl.allow("alice", nil)
+	assert.False(t, ok)
+
+	// role specific limits
+	allowed := 0
+	for i := 0; i < 30; i++ {
+		if ok, _ := rl.allow("integration", []string{"integration"}); ok {
+			allowed++
+		}
+	}
+	assert.Equal(t, 20, allowed)
+
+	// bypass roles are never limited
+	for i := 0; i < 100; i++ {
+		ok, _ := rl.allow("svc", []string{"service"})
+		require.True(t, ok)
+	}
+}
+
+func Test_RateLimitInterceptor(t *testing.T) {
+	interceptor := NewRateLimitInterceptor(config.RateLimit{PerMinute: 1, Burst: 1})
+
+	calls := 0
+	next := interceptor(func(context.Context, connect.AnyRequest) (connect.AnyResponse, error) {
+		calls++
+		return connect.NewResponse(&calendarv1.DeleteEventResponse{}), nil
+	})
+
+	call := func(procedure string) error {
+		req := connect.NewRequest(&calendarv1.DeleteEventRequest{})
+		req.Header().Set("X-Remote-User-ID", "alice")
+
+		_, err := next(context.Background(), specRequest{req, procedure})
+		return err
+	}
+
+	require.NoError(t, call("/tkd.calendar.v1.CalendarService/DeleteEvent"))
+
+	err := call("/tkd.calendar.v1.CalendarService/DeleteEvent")
+	require.Error(t, err)
+	assert.Equal(t, connect.CodeResourceExhausted, connect.CodeOf(err))
+
+	var connectErr *connect.Error
+	require.ErrorAs(t, err, &connectErr)
+	assert.Equal(t, "60", connectErr.Meta().Get("Retry-After"))
+
+	require.Len(t, connectErr.Details(), 1)
+	detail, err := connectErr.Details()[0].Value()
+	require.NoError(t, err)
+	assert.InDelta(t, time.Minute, detail.(*errdetails.RetryInfo).RetryDelay.AsDuration(), float64(time.Second))
+
+	// non-mutating requests are not limited
+	require.NoError(t, call("/tkd.calendar.v1.CalendarService/ListEvents"))
+	assert.Equal(t, 2, calls)
+}
+
+// specRequest overwrites the procedure of a request.
+type specRequest struct {
+	connect.AnyRequest
+	procedure string
+}
+
+func (s specRequest) Spec() connect.Spec {
+	spec := s.AnyRequest.Spec()
+	spec.Procedure = s.procedure
+
+	return spec
+}
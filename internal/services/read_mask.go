@@ -0,0 +1,108 @@
+package services
+
+import (
+	"strings"
+
+	"github.com/mennanov/fmutils"
+	calendarv1 "github.com/tierklinik-dobersberg/apis/gen/go/tkd/calendar/v1"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/repo"
+)
+
+// listEventsMask describes which parts of a ListEventsResponse have been
+// requested so masked-out data is neither fetched nor converted.
+type listEventsMask struct {
+	paths     []string
+	calendars bool
+	events    bool
+
+	// eventFields holds the requested top-level event fields and eventPaths
+	// the requested paths relative to the event message. A nil map means that
+	// all event fields are requested.
+	eventFields map[string]struct{}
+	eventPaths  []string
+}
+
+func parseListEventsMask(paths []string) listEventsMask {
+	if len(paths) == 0 {
+		paths = []string{"results.calendar", "results.events"}
+	}
+
+	mask := listEventsMask{
+		paths: paths,
+	}
+
+	allEventFields := false
+	for _, path := range paths {
+		switch {
+		case path == "results":
+			mask.calendars = true
+			mask.events = true
+			allEventFields = true
+
+		case path == "results.calendar" || strings.HasPrefix(path, "results.calendar."):
+			mask.calendars = true
+
+		case path == "results.events":
+			mask.events = true
+			allEventFields = true
+
+		case strings.HasPrefix(path, "results.events."):
+			mask.events = true
+
+			if mask.eventFields == nil {
+				mask.eventFields = make(map[string]struct{})
+			}
+
+			eventPath := strings.TrimPrefix(path, "results.events.")
+			mask.eventPaths = append(mask.eventPaths, eventPath)
+
+			field, _, _ := strings.Cut(eventPath, ".")
+			mask.eventFields[field] = struct{}{}
+		}
+	}
+
+	if allEventFields {
+		mask.eventFields = nil
+		mask.eventPaths = nil
+	}
+
+	return mask
+}
+
+// includesEventField reports whether the event field has been requested.
+func (m listEventsMask) includesEventField(field string) bool {
+	if m.eventFields == nil {
+		return true
+	}
+
+	_, ok := m.eventFields[field]
+
+	return ok
+}
+
+// eventToProto converts e to it's protobuf representation. Expensive fields
+// that are masked-out are dropped before the conversion, all other fields are
+// filtered on the resulting event message.
+func (m listEventsMask) eventToProto(e repo.Event) (*calendarv1.CalendarEvent, error) {
+	if !m.includesEventField("description") {
+		e.Description = ""
+	}
+
+	// skip marshaling the customer annotation or free-slot information
+	// into an anypb.Any
+	if !m.includesEventField("extra_data") {
+		e.Data = nil
+		e.Slot = nil
+	}
+
+	pb, err := e.ToProto()
+	if err != nil {
+		return nil, err
+	}
+
+	if m.eventPaths != nil {
+		fmutils.Filter(pb, m.eventPaths)
+	}
+
+	return pb, nil
+}
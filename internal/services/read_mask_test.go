@@ -0,0 +1,192 @@
+package services
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	calendarv1 "github.com/tierklinik-dobersberg/apis/gen/go/tkd/calendar/v1"
+	idmv1 "github.com/tierklinik-dobersberg/apis/gen/go/tkd/idm/v1"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/app"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/cache"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/repo"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+)
+
+// countingRepo is a repo.Service that returns a fixed set of events for each
+// calendar and counts the number of ListEvents calls.
+type countingRepo struct {
+	repo.Service
+
+	events []repo.Event
+	calls  int
+}
+
+func (c *countingRepo) ListEvents(context.Context, string, ...repo.SearchOption) ([]repo.Event, error) {
+	c.calls++
+
+	return c.events, nil
+}
+
+func newMaskTestService(calendars int, events int) (*CalendarService, *countingRepo) {
+	fake := new(countingRepo)
+
+	start := time.Date(2024, 6, 3, 8, 0, 0, 0, time.Local)
+	for i := 0; i < events; i++ {
+		end := start.Add(time.Duration(i+1) * 15 * time.Minute)
+
+		fake.events = append(fake.events, repo.Event{
+			ID:          strconv.Itoa(i),
+			Summary:     "Appointment " + strconv.Itoa(i),
+			Description: strings.Repeat("referral letter ", 256),
+			StartTime:   start.Add(time.Duration(i) * 15 * time.Minute),
+			EndTime:     &end,
+			Data: &repo.StructuredEvent{
+				CustomerSource: "vetinf",
+				CustomerID:     "1234",
+				AnimalID:       []string{"1", "2"},
+			},
+		})
+	}
+
+	var cals []repo.Calendar
+	for i := 0; i < calendars; i++ {
+		cals = append(cals, repo.Calendar{ID: "cal-" + strconv.Itoa(i), Name: "Calendar " + strconv.Itoa(i)})
+	}
+
+	calendarById := cache.NewIndex(func(c repo.Calendar) (string, bool) {
+		return c.ID, true
+	})
+	calendarById.Update(cals)
+
+	svc := &CalendarService{
+		repo:         &app.App{Service: fake},
+		events:       fake,
+		calendars:    cache.NewCache[repo.Calendar]("calendars", time.Minute, nil),
+		calendarById: calendarById,
+		userByCalId: cache.NewIndex(func(p *idmv1.Profile) (string, bool) {
+			return "", false
+		}),
+	}
+
+	return svc, fake
+}
+
+func listEventsRequest(calendars int, paths ...string) *connect.Request[calendarv1.ListEventsRequest] {
+	var ids []string
+	for i := 0; i < calendars; i++ {
+		ids = append(ids, "cal-"+strconv.Itoa(i))
+	}
+
+	req := &calendarv1.ListEventsRequest{
+		Source: &calendarv1.ListEventsRequest_Sources{
+			Sources: &calendarv1.EventSource{
+				CalendarIds: ids,
+			},
+		},
+		SearchTime: &calendarv1.ListEventsRequest_Date{
+			Date: "2024-06-03",
+		},
+	}
+
+	if len(paths) > 0 {
+		req.ReadMask = &fieldmaskpb.FieldMask{Paths: paths}
+	}
+
+	return connect.NewRequest(req)
+}
+
+func Test_ListEvents_ReadMask(t *testing.T) {
+	svc, fake := newMaskTestService(2, 3)
+
+	// only calendars are requested so events must not be loaded
+	res, err := svc.ListEvents(context.Background(), listEventsRequest(2, "results.calendar"))
+	require.NoError(t, err)
+	require.Len(t, res.Msg.Results, 2)
+	assert.Equal(t, 0, fake.calls)
+	assert.NotNil(t, res.Msg.Results[0].Calendar)
+	assert.Empty(t, res.Msg.Results[0].Events)
+
+	// only event summaries are requested
+	res, err = svc.ListEvents(context.Background(), listEventsRequest(2, "results.events.summary"))
+	require.NoError(t, err)
+	require.Len(t, res.Msg.Results, 2)
+	assert.Equal(t, 2, fake.calls)
+	assert.Nil(t, res.Msg.Results[0].Calendar)
+	require.Len(t, res.Msg.Results[0].Events, 3)
+	assert.Equal(t, "Appointment 0", res.Msg.Results[0].Events[0].Summary)
+	assert.Empty(t, res.Msg.Results[0].Events[0].Description)
+	assert.Nil(t, res.Msg.Results[0].Events[0].ExtraData)
+
+	// without a read mask everything is returned
+	res, err = svc.ListEvents(context.Background(), listEventsRequest(1))
+	require.NoError(t, err)
+	require.Len(t, res.Msg.Results, 1)
+	assert.NotNil(t, res.Msg.Results[0].Calendar)
+	require.Len(t, res.Msg.Results[0].Events, 3)
+	assert.NotEmpty(t, res.Msg.Results[0].Events[0].Description)
+	assert.NotNil(t, res.Msg.Results[0].Events[0].ExtraData)
+}
+
+func Test_ListEventsMask_EventFields(t *testing.T) {
+	mask := parseListEventsMask([]string{"results.events.summary", "results.events.start_time"})
+
+	assert.True(t, mask.events)
+	assert.False(t, mask.calendars)
+	assert.True(t, mask.includesEventField("summary"))
+	assert.False(t, mask.includesEventField("description"))
+
+	end := time.Date(2024, 6, 3, 9, 0, 0, 0, time.UTC)
+	pb, err := mask.eventToProto(repo.Event{
+		ID:          "1",
+		Summary:     "Bello",
+		Description: "referral letter",
+		StartTime:   time.Date(2024, 6, 3, 8, 0, 0, 0, time.UTC),
+		EndTime:     &end,
+		Data:        &repo.StructuredEvent{CustomerID: "1"},
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, "Bello", pb.Summary)
+	assert.NotNil(t, pb.StartTime)
+	assert.Empty(t, pb.Id)
+	assert.Empty(t, pb.Description)
+	assert.Nil(t, pb.EndTime)
+	assert.Nil(t, pb.ExtraData)
+
+	// requesting all events disables event level filtering
+	mask = parseListEventsMask([]string{"results.events.summary", "results.events"})
+	assert.True(t, mask.includesEventField("description"))
+	assert.Nil(t, mask.eventPaths)
+}
+
+func benchmarkListEvents(b *testing.B, paths ...string) {
+	svc, _ := newMaskTestService(10, 50)
+	req := listEventsRequest(10, paths...)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := svc.ListEvents(context.Background(), req); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func Benchmark_ListEvents_NoMask(b *testing.B) {
+	benchmarkListEvents(b)
+}
+
+func Benchmark_ListEvents_CalendarsOnly(b *testing.B) {
+	benchmarkListEvents(b, "results.calendar")
+}
+
+func Benchmark_ListEvents_EventSummaries(b *testing.B) {
+	benchmarkListEvents(b, "results.events.summary", "results.events.start_time", "results.events.end_time")
+}
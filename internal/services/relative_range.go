@@ -0,0 +1,135 @@
+package services
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tierklinik-dobersberg/cis-cal/internal/config"
+)
+
+// relativeRangeHeader may be set on ListEvents requests without a search
+// time to list the events of a range relative to today, like this_week, or
+// of an ISO week, like 2024-W01. The ListEventsRequest does not have a field
+// for relative ranges yet. The resolved range is reported in the query
+// diagnostics.
+const relativeRangeHeader = "X-Relative-Range"
+
+// Relative ranges supported by relativeRangeHeader. Dashes may be used
+// instead of underscores.
+const (
+	rangeToday     = "today"
+	rangeTomorrow  = "tomorrow"
+	rangeThisWeek  = "this_week"
+	rangeNextWeek  = "next_week"
+	rangeThisMonth = "this_month"
+)
+
+// relativeRanges resolves relative ranges in the configured timezone.
+type relativeRanges struct {
+	loc       *time.Location
+	weekStart time.Weekday
+}
+
+// newRelativeRanges returns the relative ranges configured in cfg. The
+// timezone defaults to the local one and weeks start on Monday.
+func newRelativeRanges(cfg config.Config) (relativeRanges, error) {
+	r := relativeRanges{
+		loc:       time.Local,
+		weekStart: time.Monday,
+	}
+
+	if cfg.Ranges.Timezone != "" {
+		loc, err := time.LoadLocation(cfg.Ranges.Timezone)
+		if err != nil {
+			return r, err
+		}
+
+		r.loc = loc
+	}
+
+	if cfg.Ranges.WeekStart != "" {
+		day, err := config.ParseWeekday(cfg.Ranges.WeekStart)
+		if err != nil {
+			return r, err
+		}
+
+		r.weekStart = day
+	}
+
+	return r, nil
+}
+
+// day returns midnight of the day that is days after t. Days are added to
+// the date so days with a DST transition are not cut short.
+func (r relativeRanges) day(t time.Time, days int) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day()+days, 0, 0, 0, 0, r.loc)
+}
+
+// resolve returns the start and end of the relative range value at now.
+func (r relativeRanges) resolve(value string, now time.Time) (time.Time, time.Time, error) {
+	if year, week, ok := parseISOWeek(value); ok {
+		return r.isoWeek(year, week)
+	}
+
+	today := r.day(now.In(r.loc), 0)
+	name := strings.ReplaceAll(strings.ToLower(value), "-", "_")
+
+	switch name {
+	case rangeToday:
+		return today, r.day(today, 1), nil
+
+	case rangeTomorrow:
+		return r.day(today, 1), r.day(today, 2), nil
+
+	case rangeThisWeek, rangeNextWeek:
+		start := r.day(today, -((int(today.Weekday()) - int(r.weekStart) + 7) % 7))
+		if name == rangeNextWeek {
+			start = r.day(start, 7)
+		}
+
+		return start, r.day(start, 7), nil
+
+	case rangeThisMonth:
+		start := time.Date(today.Year(), today.Month(), 1, 0, 0, 0, 0, r.loc)
+
+		return start, start.AddDate(0, 1, 0), nil
+	}
+
+	return time.Time{}, time.Time{}, fmt.Errorf("invalid value for %s, expected one of today, tomorrow, this_week, next_week, this_month or an ISO week like 2024-W01", relativeRangeHeader)
+}
+
+// isoWeek returns the start and end of an ISO week. ISO weeks always start
+// on Monday, the first week of a year is the one with the first Thursday.
+func (r relativeRanges) isoWeek(year, week int) (time.Time, time.Time, error) {
+	jan4 := time.Date(year, time.January, 4, 0, 0, 0, 0, r.loc)
+	start := r.day(jan4, -((int(jan4.Weekday())+6)%7)+(week-1)*7)
+
+	// only some years have a 53rd week
+	if y, w := r.day(start, 3).ISOWeek(); y != year || w != week {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid value for %s, %d does not have week %d", relativeRangeHeader, year, week)
+	}
+
+	return start, r.day(start, 7), nil
+}
+
+// parseISOWeek parses an ISO week in format YYYY-Www.
+func parseISOWeek(value string) (int, int, bool) {
+	year, week, ok := strings.Cut(strings.ToUpper(value), "-W")
+	if !ok || len(year) != 4 || len(week) != 2 {
+		return 0, 0, false
+	}
+
+	y, err := strconv.Atoi(year)
+	if err != nil {
+		return 0, 0, false
+	}
+
+	w, err := strconv.Atoi(week)
+	if err != nil || w < 1 || w > 53 {
+		return 0, 0, false
+	}
+
+	return y, w, true
+}
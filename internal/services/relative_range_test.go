@@ -0,0 +1,137 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/config"
+)
+
+func Test_RelativeRanges(t *testing.T) {
+	vienna, err := time.LoadLocation("Europe/Vienna")
+	require.NoError(t, err)
+
+	ranges := relativeRanges{loc: vienna, weekStart: time.Monday}
+
+	date := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, 0, 0, 0, 0, vienna)
+	}
+
+	cases := []struct {
+		name     string
+		value    string
+		now      time.Time
+		from, to time.Time
+	}{
+		{"today", "today", time.Date(2024, time.June, 5, 13, 0, 0, 0, vienna), date(2024, time.June, 5), date(2024, time.June, 6)},
+		// late in the evening in UTC is already tomorrow in vienna
+		{"today utc", "today", time.Date(2024, time.June, 4, 23, 30, 0, 0, time.UTC), date(2024, time.June, 5), date(2024, time.June, 6)},
+		{"tomorrow", "tomorrow", time.Date(2024, time.June, 5, 13, 0, 0, 0, vienna), date(2024, time.June, 6), date(2024, time.June, 7)},
+		{"this week", "this_week", time.Date(2024, time.June, 5, 13, 0, 0, 0, vienna), date(2024, time.June, 3), date(2024, time.June, 10)},
+		{"this week dashes", "this-week", time.Date(2024, time.June, 5, 13, 0, 0, 0, vienna), date(2024, time.June, 3), date(2024, time.June, 10)},
+		{"this week on monday", "this_week", date(2024, time.June, 3), date(2024, time.June, 3), date(2024, time.June, 10)},
+		{"this week on sunday", "this_week", time.Date(2024, time.June, 9, 23, 0, 0, 0, vienna), date(2024, time.June, 3), date(2024, time.June, 10)},
+		{"next week", "next_week", time.Date(2024, time.June, 5, 13, 0, 0, 0, vienna), date(2024, time.June, 10), date(2024, time.June, 17)},
+		{"this month", "this_month", time.Date(2024, time.December, 31, 13, 0, 0, 0, vienna), date(2024, time.December, 1), date(2025, time.January, 1)},
+
+		// DST starts on the last sunday in march and ends on the last
+		// sunday in october
+		{"today dst start", "today", time.Date(2024, time.March, 31, 12, 0, 0, 0, vienna), date(2024, time.March, 31), date(2024, time.April, 1)},
+		{"today dst end", "today", time.Date(2024, time.October, 27, 12, 0, 0, 0, vienna), date(2024, time.October, 27), date(2024, time.October, 28)},
+		{"tomorrow dst start", "tomorrow", time.Date(2024, time.March, 30, 23, 30, 0, 0, vienna), date(2024, time.March, 31), date(2024, time.April, 1)},
+		{"this week dst sunday", "this_week", time.Date(2024, time.March, 31, 23, 30, 0, 0, vienna), date(2024, time.March, 25), date(2024, time.April, 1)},
+		{"next week dst sunday", "next_week", time.Date(2024, time.October, 21, 8, 0, 0, 0, vienna), date(2024, time.October, 28), date(2024, time.November, 4)},
+
+		// ISO weeks around the year boundary
+		{"iso week 1 starts in previous year", "2025-W01", time.Time{}, date(2024, time.December, 30), date(2025, time.January, 6)},
+		{"iso week 53", "2020-W53", time.Time{}, date(2020, time.December, 28), date(2021, time.January, 4)},
+		{"iso week 1 after week 53", "2021-w01", time.Time{}, date(2021, time.January, 4), date(2021, time.January, 11)},
+		{"this week across years", "this_week", time.Date(2025, time.January, 1, 9, 0, 0, 0, vienna), date(2024, time.December, 30), date(2025, time.January, 6)},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			from, to, err := ranges.resolve(c.value, c.now)
+			require.NoError(t, err)
+			assert.True(t, c.from.Equal(from), "from: expected %s, got %s", c.from, from)
+			assert.True(t, c.to.Equal(to), "to: expected %s, got %s", c.to, to)
+		})
+	}
+
+	// ranges containing the DST transition are shorter or longer
+	from, to, err := ranges.resolve("this_week", time.Date(2024, time.March, 31, 12, 0, 0, 0, vienna))
+	require.NoError(t, err)
+	assert.Equal(t, 7*24*time.Hour-time.Hour, to.Sub(from))
+
+	for _, value := range []string{"2021-W53", "2024-W00", "yesterday", ""} {
+		_, _, err := ranges.resolve(value, time.Now())
+		assert.Error(t, err, value)
+	}
+
+	// the first day of the week is configurable
+	sunday := relativeRanges{loc: vienna, weekStart: time.Sunday}
+
+	from, to, err = sunday.resolve("this_week", time.Date(2024, time.June, 5, 13, 0, 0, 0, vienna))
+	require.NoError(t, err)
+	assert.True(t, date(2024, time.June, 2).Equal(from))
+	assert.True(t, date(2024, time.June, 9).Equal(to))
+
+	// ISO weeks always start on monday
+	from, _, err = sunday.resolve("2024-W23", time.Time{})
+	require.NoError(t, err)
+	assert.True(t, date(2024, time.June, 3).Equal(from))
+}
+
+func Test_NewRelativeRanges(t *testing.T) {
+	ranges, err := newRelativeRanges(config.Config{})
+	require.NoError(t, err)
+	assert.Equal(t, time.Local, ranges.loc)
+	assert.Equal(t, time.Monday, ranges.weekStart)
+
+	var cfg config.Config
+	cfg.Ranges.Timezone = "Europe/Vienna"
+	cfg.Ranges.WeekStart = "sunday"
+
+	ranges, err = newRelativeRanges(cfg)
+	require.NoError(t, err)
+	assert.Equal(t, "Europe/Vienna", ranges.loc.String())
+	assert.Equal(t, time.Sunday, ranges.weekStart)
+}
+
+func Test_ListEvents_RelativeRange(t *testing.T) {
+	svc, _ := newMaskTestService(1, 0)
+	svc.repo.Config.Ranges.Timezone = "Europe/Vienna"
+
+	req := listEventsRequest(1)
+	req.Header().Set(relativeRangeHeader, "this_week")
+
+	// the range cannot be combined with a search time
+	_, err := svc.ListEvents(context.Background(), req)
+	assert.Equal(t, connect.CodeInvalidArgument, connect.CodeOf(err))
+
+	req.Msg.SearchTime = nil
+	req.Header().Set(relativeRangeHeader, "next-month")
+
+	_, err = svc.ListEvents(context.Background(), req)
+	assert.Equal(t, connect.CodeInvalidArgument, connect.CodeOf(err))
+
+	req.Header().Set(relativeRangeHeader, "2025-W01")
+	req.Header().Set(diagnosticsHeader, "true")
+
+	res, err := svc.ListEvents(context.Background(), req)
+	require.NoError(t, err)
+
+	var diag queryDiagnostics
+	require.NoError(t, json.Unmarshal([]byte(res.Header().Get(queryDiagnosticsHeader)), &diag))
+	assert.Equal(t, "2025-W01", diag.Range)
+	require.NotNil(t, diag.From)
+	require.NotNil(t, diag.To)
+	assert.Equal(t, "2024-12-30T00:00:00+01:00", diag.From.Format(time.RFC3339))
+	assert.Equal(t, "2025-01-06T00:00:00+01:00", diag.To.Format(time.RFC3339))
+	assert.Len(t, res.Msg.Results, 1)
+}
@@ -0,0 +1,30 @@
+package services
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/bufbuild/connect-go"
+	"google.golang.org/protobuf/proto"
+)
+
+// NewResponseSizeInterceptor returns a unary interceptor that logs a warning
+// for responses that are larger than warnSize bytes (before compression).
+func NewResponseSizeInterceptor(warnSize int) connect.UnaryInterceptorFunc {
+	return func(next connect.UnaryFunc) connect.UnaryFunc {
+		return func(ctx context.Context, req connect.AnyRequest) (connect.AnyResponse, error) {
+			res, err := next(ctx, req)
+			if err != nil || warnSize <= 0 || res == nil {
+				return res, err
+			}
+
+			if msg, ok := res.Any().(proto.Message); ok {
+				if size := proto.Size(msg); size > warnSize {
+					slog.Warn("large response", "procedure", req.Spec().Procedure, "size", size, "threshold", warnSize)
+				}
+			}
+
+			return res, err
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tierklinik-dobersberg/apis/gen/go/tkd/calendar/v1/calendarv1connect"
+)
+
+type recordingTransport struct {
+	encodings []string
+}
+
+func (rt *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	res, err := http.DefaultTransport.RoundTrip(req)
+	if err == nil {
+		rt.encodings = append(rt.encodings, res.Header.Get("Content-Encoding"))
+	}
+
+	return res, err
+}
+
+func Test_ListEvents_CompressionAndLimits(t *testing.T) {
+	svc, _ := newMaskTestService(2, 3)
+
+	mux := http.NewServeMux()
+	mux.Handle(calendarv1connect.NewCalendarServiceHandler(svc,
+		connect.WithInterceptors(NewResponseSizeInterceptor(1)),
+		connect.WithCompressMinBytes(0),
+	))
+
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	transport := new(recordingTransport)
+	cli := calendarv1connect.NewCalendarServiceClient(&http.Client{Transport: transport}, srv.URL)
+
+	res, err := cli.ListEvents(context.Background(), listEventsRequest(2))
+	require.NoError(t, err)
+	require.Len(t, res.Msg.Results, 2)
+	assert.Equal(t, []string{"gzip"}, transport.encodings)
+
+	// 6 events exceed the limit
+	svc.repo.Config.Limits.MaxEvents = 5
+
+	_, err = cli.ListEvents(context.Background(), listEventsRequest(2))
+	require.Error(t, err)
+	assert.Equal(t, connect.CodeResourceExhausted, connect.CodeOf(err))
+}
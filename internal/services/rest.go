@@ -0,0 +1,288 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bufbuild/connect-go"
+	calendarv1 "github.com/tierklinik-dobersberg/apis/gen/go/tkd/calendar/v1"
+	commonv1 "github.com/tierklinik-dobersberg/apis/gen/go/tkd/common/v1"
+	"github.com/tierklinik-dobersberg/apis/pkg/privacy"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/i18n"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// restError is the JSON body of failed REST requests.
+type restError struct {
+	Error struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// restStatus returns the HTTP status code for a connect error code.
+func restStatus(code connect.Code) int {
+	switch code {
+	case connect.CodeInvalidArgument, connect.CodeOutOfRange, connect.CodeFailedPrecondition:
+		return http.StatusBadRequest
+	case connect.CodeUnauthenticated:
+		return http.StatusUnauthorized
+	case connect.CodePermissionDenied:
+		return http.StatusForbidden
+	case connect.CodeNotFound:
+		return http.StatusNotFound
+	case connect.CodeAlreadyExists, connect.CodeAborted:
+		return http.StatusConflict
+	case connect.CodeResourceExhausted:
+		return http.StatusTooManyRequests
+	case connect.CodeCanceled:
+		return http.StatusRequestTimeout
+	case connect.CodeUnimplemented:
+		return http.StatusNotImplemented
+	case connect.CodeUnavailable:
+		return http.StatusServiceUnavailable
+	case connect.CodeDeadlineExceeded:
+		return http.StatusGatewayTimeout
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// RESTHandler is a read-only JSON facade for integrations that cannot speak
+// Connect or gRPC, like phone systems:
+//
+//	GET /api/v1/calendars
+//	GET /api/v1/calendars/{id}/events?from=2024-06-03&to=2024-06-04
+//	GET /api/v1/holidays?year=2024
+//
+// Requests are translated to calls of the calendar and holiday services.
+// As for Connect requests, requests without X-Remote-User-ID are rejected,
+// the X-Remote-User-ID and X-Remote-Role headers are forwarded, the privacy
+// rules apply and callers are rate limited. Responses are the protojson
+// encoded service responses. Errors are returned as a JSON body with a
+// matching HTTP status code.
+type RESTHandler struct {
+	svc      *CalendarService
+	holidays *HolidayService
+	limiter  *RateLimiter
+	mux      *http.ServeMux
+}
+
+// NewRESTHandler returns a new REST handler for svc and holidays. REST
+// requests count against their own bucket of limiter.
+func NewRESTHandler(svc *CalendarService, holidays *HolidayService, limiter *RateLimiter) *RESTHandler {
+	h := &RESTHandler{
+		svc:      svc,
+		holidays: holidays,
+		limiter:  limiter,
+		mux:      http.NewServeMux(),
+	}
+
+	h.mux.HandleFunc("/api/v1/calendars", h.listCalendars)
+	h.mux.HandleFunc("/api/v1/calendars/{id}/events", h.listEvents)
+	h.mux.HandleFunc("/api/v1/holidays", h.listHolidays)
+	h.mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		writeRESTError(w, connect.NewError(connect.CodeNotFound, fmt.Errorf("no such endpoint %s", r.URL.Path)))
+	})
+
+	return h
+}
+
+func (h *RESTHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeRESTStatus(w, http.StatusMethodNotAllowed, "method_not_allowed", "method not allowed")
+
+		return
+	}
+
+	userID := r.Header.Get("X-Remote-User-ID")
+	if userID == "" {
+		writeRESTError(w, connect.NewError(connect.CodeUnauthenticated, errors.New("authentication required")))
+		return
+	}
+
+	if err := h.limiter.limit("rest/"+userID, r.Header.Values("X-Remote-Role")); err != nil {
+		w.Header().Set("Retry-After", err.Meta().Get("Retry-After"))
+		writeRESTError(w, err)
+
+		return
+	}
+
+	h.mux.ServeHTTP(w, r)
+}
+
+func (h *RESTHandler) listCalendars(w http.ResponseWriter, r *http.Request) {
+	req := newRESTRequest(r, &calendarv1.ListCalendarsRequest{})
+
+	res, err := h.svc.ListCalendars(h.context(r), req)
+	if err != nil {
+		writeRESTError(w, err)
+		return
+	}
+
+	writeRESTResponse(w, r, res.Msg)
+}
+
+func (h *RESTHandler) listEvents(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	now := time.Now()
+	from, err := parseRESTTime(query.Get("from"), time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local))
+	if err != nil {
+		writeRESTError(w, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("invalid value for from: %w", err)))
+		return
+	}
+
+	to, err := parseRESTTime(query.Get("to"), from.AddDate(0, 0, 1))
+	if err != nil {
+		writeRESTError(w, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("invalid value for to: %w", err)))
+		return
+	}
+
+	if !to.After(from) {
+		writeRESTError(w, connect.NewError(connect.CodeInvalidArgument, errors.New("to must be after from")))
+		return
+	}
+
+	req := newRESTRequest(r, &calendarv1.ListEventsRequest{
+		SearchTime: &calendarv1.ListEventsRequest_TimeRange{
+			TimeRange: commonv1.NewTimeRange(from, to),
+		},
+		Source: &calendarv1.ListEventsRequest_Sources{
+			Sources: &calendarv1.EventSource{CalendarIds: []string{r.PathValue("id")}},
+		},
+		RequestKinds: []calendarv1.CalenarEventRequestKind{
+			calendarv1.CalenarEventRequestKind_CALENDAR_EVENT_REQUEST_KIND_EVENTS,
+		},
+	})
+
+	res, err := h.svc.ListEvents(h.context(r), req)
+	if err != nil {
+		writeRESTError(w, err)
+		return
+	}
+
+	writeRESTResponse(w, r, res.Msg)
+}
+
+func (h *RESTHandler) listHolidays(w http.ResponseWriter, r *http.Request) {
+	year := time.Now().Year()
+
+	if v := r.URL.Query().Get("year"); v != "" {
+		parsed, err := strconv.Atoi(v)
+		if err != nil || parsed < 1 || parsed > 9999 {
+			writeRESTError(w, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("invalid value for year %q", v)))
+			return
+		}
+
+		year = parsed
+	}
+
+	req := newRESTRequest(r, &calendarv1.GetHolidayRequest{Year: uint64(year)})
+
+	res, err := h.holidays.GetHoliday(h.context(r), req)
+	if err != nil {
+		writeRESTError(w, err)
+		return
+	}
+
+	writeRESTResponse(w, r, res.Msg)
+}
+
+// context returns the request context with the language of the caller.
+func (h *RESTHandler) context(r *http.Request) context.Context {
+	lang := i18n.Match(r.Header.Get("Accept-Language"), i18n.Language(h.svc.repo.Config.DefaultLanguage))
+
+	return i18n.WithLanguage(r.Context(), lang)
+}
+
+// newRESTRequest returns a connect request for msg with the auth headers
+// of r.
+func newRESTRequest[T any](r *http.Request, msg *T) *connect.Request[T] {
+	req := connect.NewRequest(msg)
+
+	for _, key := range []string{"X-Remote-User-ID", "X-Remote-Role"} {
+		for _, v := range r.Header.Values(key) {
+			req.Header().Add(key, v)
+		}
+	}
+
+	return req
+}
+
+// parseRESTTime parses a date (YYYY-MM-DD) in the local time zone or an
+// RFC3339 timestamp. Empty values return def.
+func parseRESTTime(value string, def time.Time) (time.Time, error) {
+	if value == "" {
+		return def, nil
+	}
+
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return t, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("expected YYYY-MM-DD or RFC3339, got %q", value)
+	}
+
+	return t, nil
+}
+
+// writeRESTResponse applies the privacy rules for the caller of r to msg
+// and writes it as JSON. Handlers are called directly so the privacy
+// interceptor does not run.
+func writeRESTResponse(w http.ResponseWriter, r *http.Request, msg proto.Message) {
+	if err := privacy.FilterAllowedFields(msg, r.Header.Get("X-Remote-User-ID"), r.Header.Values("X-Remote-Role")); err != nil {
+		slog.Error("failed to apply privacy rules to REST response", "error", err)
+		writeRESTError(w, connect.NewError(connect.CodeInternal, errors.New("failed to apply privacy rules")))
+
+		return
+	}
+
+	blob, err := protojson.Marshal(msg)
+	if err != nil {
+		slog.Error("failed to encode REST response", "error", err)
+		writeRESTError(w, connect.NewError(connect.CodeInternal, errors.New("failed to encode response")))
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(blob)
+}
+
+// writeRESTError writes err as a JSON error body.
+func writeRESTError(w http.ResponseWriter, err error) {
+	code := connect.CodeOf(err)
+
+	msg := err.Error()
+
+	var connectErr *connect.Error
+	if errors.As(err, &connectErr) {
+		msg = connectErr.Message()
+	}
+
+	writeRESTStatus(w, restStatus(code), code.String(), msg)
+}
+
+func writeRESTStatus(w http.ResponseWriter, status int, code, msg string) {
+	var body restError
+	body.Error.Code = code
+	body.Error.Message = msg
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		slog.Error("failed to encode REST error", "error", err)
+	}
+}
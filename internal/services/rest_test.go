@@ -0,0 +1,150 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	calendarv1 "github.com/tierklinik-dobersberg/apis/gen/go/tkd/calendar/v1"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/config"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+func Test_RESTHandler(t *testing.T) {
+	svc, _ := newMaskTestService(2, 2)
+	h := NewRESTHandler(svc, newTestHolidayService(t), NewRateLimiter(config.RateLimit{}))
+
+	get := func(target string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.Header.Set("X-Remote-User-ID", "alice")
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		return rec
+	}
+
+	rec := get("/api/v1/calendars/cal-0/events?from=2024-06-03&to=2024-06-04")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+	var events calendarv1.ListEventsResponse
+	require.NoError(t, protojson.Unmarshal(rec.Body.Bytes(), &events))
+	require.Len(t, events.Results, 1)
+	assert.Len(t, events.Results[0].Events, 2)
+	assert.Equal(t, "Appointment 0", events.Results[0].Events[0].Summary)
+
+	// RFC3339 timestamps are accepted as well
+	rec = get("/api/v1/calendars/cal-0/events?from=2024-06-03T08:10:00Z&to=2024-06-03T23:00:00Z")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	rec = get("/api/v1/holidays?year=2024")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	var holidays calendarv1.GetHolidayResponse
+	require.NoError(t, protojson.Unmarshal(rec.Body.Bytes(), &holidays))
+	assert.Len(t, holidays.Holidays, 10)
+
+	rec = get("/api/v1/calendars")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	cases := []struct {
+		target string
+		status int
+		code   string
+	}{
+		{"/api/v1/calendars/cal-0/events?from=03.06.2024", http.StatusBadRequest, "invalid_argument"},
+		{"/api/v1/calendars/cal-0/events?from=2024-06-04&to=2024-06-03", http.StatusBadRequest, "invalid_argument"},
+		{"/api/v1/holidays?year=next", http.StatusBadRequest, "invalid_argument"},
+		{"/api/v1/holidays?year=-1", http.StatusBadRequest, "invalid_argument"},
+		{"/api/v1/unknown", http.StatusNotFound, "not_found"},
+	}
+
+	for _, c := range cases {
+		rec := get(c.target)
+		assert.Equal(t, c.status, rec.Code, c.target)
+
+		var body restError
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&body), c.target)
+		assert.Equal(t, c.code, body.Error.Code, c.target)
+		assert.NotEmpty(t, body.Error.Message, c.target)
+	}
+
+	// the facade is read-only
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/calendars", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+	assert.Equal(t, http.MethodGet, rec.Header().Get("Allow"))
+}
+
+func Test_RESTHandler_Auth(t *testing.T) {
+	svc, _ := newMaskTestService(1, 1)
+	h := NewRESTHandler(svc, newTestHolidayService(t), NewRateLimiter(config.RateLimit{PerMinute: 1, Burst: 1}))
+
+	get := func(userID string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/calendars", nil)
+		if userID != "" {
+			req.Header.Set("X-Remote-User-ID", userID)
+		}
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		return rec
+	}
+
+	rec := get("")
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	var body restError
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&body))
+	assert.Equal(t, "unauthenticated", body.Error.Code)
+
+	require.Equal(t, http.StatusOK, get("alice").Code)
+
+	rec = get("alice")
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "60", rec.Header().Get("Retry-After"))
+
+	// callers have their own buckets
+	assert.Equal(t, http.StatusOK, get("bob").Code)
+}
+
+func Test_WriteRESTError(t *testing.T) {
+	cases := []struct {
+		err    error
+		status int
+		code   string
+	}{
+		{connect.NewError(connect.CodePermissionDenied, errors.New("denied")), http.StatusForbidden, "permission_denied"},
+		{connect.NewError(connect.CodeUnauthenticated, errors.New("login")), http.StatusUnauthorized, "unauthenticated"},
+		{connect.NewError(connect.CodeResourceExhausted, errors.New("slow down")), http.StatusTooManyRequests, "resource_exhausted"},
+		{connect.NewError(connect.CodeFailedPrecondition, errors.New("read-only")), http.StatusBadRequest, "failed_precondition"},
+		{connect.NewError(connect.CodeUnavailable, errors.New("down")), http.StatusServiceUnavailable, "unavailable"},
+		{errors.New("plain"), http.StatusInternalServerError, "unknown"},
+	}
+
+	for _, c := range cases {
+		rec := httptest.NewRecorder()
+		writeRESTError(rec, c.err)
+
+		assert.Equal(t, c.status, rec.Code, c.code)
+
+		var body restError
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&body))
+		assert.Equal(t, c.code, body.Error.Code)
+	}
+
+	// the message does not repeat the code
+	rec := httptest.NewRecorder()
+	writeRESTError(rec, connect.NewError(connect.CodeNotFound, errors.New("calendar not found")))
+
+	var body restError
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&body))
+	assert.Equal(t, "calendar not found", body.Error.Message)
+}
@@ -0,0 +1,277 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/bufbuild/connect-go"
+	commonv1 "github.com/tierklinik-dobersberg/apis/gen/go/tkd/common/v1"
+	rosterv1 "github.com/tierklinik-dobersberg/apis/gen/go/tkd/roster/v1"
+	"github.com/tierklinik-dobersberg/apis/pkg/data"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/app"
+)
+
+// maxCachedRosterDays is the maximum number of days for which the working
+// staff is fetched and cached day by day. Larger ranges are fetched in one
+// uncached request.
+const maxCachedRosterDays = 31
+
+// ErrRosterUnavailable is returned while the roster circuit breaker is open.
+var ErrRosterUnavailable = errors.New("roster service unavailable")
+
+type rosterClient interface {
+	GetWorkingStaff2(context.Context, *connect.Request[rosterv1.GetWorkingStaffRequest2]) (*connect.Response[rosterv1.GetWorkingStaffResponse], error)
+}
+
+type workShiftClient interface {
+	ListWorkShifts(context.Context, *connect.Request[rosterv1.ListWorkShiftsRequest]) (*connect.Response[rosterv1.ListWorkShiftsResponse], error)
+}
+
+// RosterState describes the state of the roster circuit breaker.
+type RosterState struct {
+	Open                bool      `json:"open"`
+	ConsecutiveFailures int       `json:"consecutiveFailures"`
+	OpenUntil           time.Time `json:"openUntil,omitempty"`
+}
+
+type rosterCacheEntry struct {
+	shifts []*rosterv1.PlannedShift
+	loaded time.Time
+}
+
+type definitionsCacheEntry struct {
+	definitions map[string]*rosterv1.WorkShift
+	loaded      time.Time
+}
+
+// rosterFetcher fetches planned shifts from the roster service. Working staff
+// responses are cached per roster type and day and roster lookups are skipped
+// for a cooldown period after consecutive failures.
+type rosterFetcher struct {
+	clients    func(context.Context) (rosterClient, workShiftClient, error)
+	rosterType string
+	ignoreTags []string
+	ttl        time.Duration
+	threshold  int
+	cooldown   time.Duration
+	now        func() time.Time
+
+	// loc is the time zone used to bucket shifts by day.
+	loc *time.Location
+
+	l           sync.Mutex
+	days        map[string]rosterCacheEntry
+	definitions definitionsCacheEntry
+	failures    int
+	openUntil   time.Time
+}
+
+func newRosterFetcher(svc *app.App) *rosterFetcher {
+	return &rosterFetcher{
+		clients: func(ctx context.Context) (rosterClient, workShiftClient, error) {
+			return svc.RosterClients(ctx)
+		},
+		rosterType: svc.Config.FreeSlots.RosterTypeName,
+		ignoreTags: svc.Config.FreeSlots.IgnoreShiftTags,
+		ttl:        svc.Config.Roster.CacheTTL.AsDuration(),
+		threshold:  svc.Config.Roster.FailureThreshold,
+		cooldown:   svc.Config.Roster.Cooldown.AsDuration(),
+		now:        time.Now,
+		loc:        time.Local,
+		days:       make(map[string]rosterCacheEntry),
+	}
+}
+
+// State returns the current state of the circuit breaker.
+func (r *rosterFetcher) State() RosterState {
+	r.l.Lock()
+	defer r.l.Unlock()
+
+	return RosterState{
+		Open:                r.now().Before(r.openUntil),
+		ConsecutiveFailures: r.failures,
+		OpenUntil:           r.openUntil,
+	}
+}
+
+// Fetch returns all planned shifts between start and end grouped by the
+// local date they start on together with a lookup map for the work-shift
+// definitions. Each shift is returned only once, even if it spans multiple
+// days.
+func (r *rosterFetcher) Fetch(ctx context.Context, start, end time.Time) (map[string][]*rosterv1.PlannedShift, map[string]*rosterv1.WorkShift, error) {
+	r.l.Lock()
+	isOpen := r.now().Before(r.openUntil)
+	r.l.Unlock()
+
+	if isOpen {
+		return nil, nil, ErrRosterUnavailable
+	}
+
+	roster, workShifts, err := r.clients(ctx)
+	if err != nil {
+		r.recordFailure(ctx, err)
+
+		return nil, nil, err
+	}
+
+	definitions, err := r.fetchDefinitions(ctx, workShifts)
+	if err != nil {
+		r.recordFailure(ctx, err)
+
+		return nil, nil, fmt.Errorf("failed to get work shift definitions: %w", err)
+	}
+
+	planned, err := r.fetchShifts(ctx, roster, start, end)
+	if err != nil {
+		r.recordFailure(ctx, err)
+
+		return nil, nil, fmt.Errorf("failed to retrieve working staff: %w", err)
+	}
+
+	r.recordResult(nil)
+
+	shifts := make(map[string][]*rosterv1.PlannedShift, len(planned))
+	seen := make(map[string]struct{}, len(planned))
+	for _, s := range planned {
+		// shifts crossing midnight are returned for each day they cover
+		if s.UniqueId != "" {
+			if _, ok := seen[s.UniqueId]; ok {
+				continue
+			}
+			seen[s.UniqueId] = struct{}{}
+		}
+
+		def, ok := definitions[s.WorkShiftId]
+		if !ok {
+			slog.Warn("failed to get workshift definition", "workshift-id", s.WorkShiftId)
+			continue
+		}
+
+		// skip on-call shifts
+		if data.ElemInBothSlices(def.Tags, r.ignoreTags) {
+			continue
+		}
+
+		k := s.From.AsTime().In(r.loc).Format("2006-01-02")
+		shifts[k] = append(shifts[k], s)
+	}
+
+	return shifts, definitions, nil
+}
+
+func (r *rosterFetcher) fetchDefinitions(ctx context.Context, cli workShiftClient) (map[string]*rosterv1.WorkShift, error) {
+	r.l.Lock()
+	entry := r.definitions
+	r.l.Unlock()
+
+	if entry.definitions != nil && r.now().Sub(entry.loaded) < r.ttl {
+		return entry.definitions, nil
+	}
+
+	res, err := cli.ListWorkShifts(ctx, connect.NewRequest(&rosterv1.ListWorkShiftsRequest{}))
+	if err != nil {
+		return nil, err
+	}
+
+	definitions := data.IndexSlice(res.Msg.WorkShifts, func(item *rosterv1.WorkShift) string {
+		return item.Id
+	})
+
+	r.l.Lock()
+	r.definitions = definitionsCacheEntry{definitions: definitions, loaded: r.now()}
+	r.l.Unlock()
+
+	return definitions, nil
+}
+
+func (r *rosterFetcher) fetchShifts(ctx context.Context, cli rosterClient, start, end time.Time) ([]*rosterv1.PlannedShift, error) {
+	start = start.In(r.loc)
+	startDay := time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, r.loc)
+
+	// open or very large ranges are fetched in one go and not cached.
+	if start.IsZero() || end.IsZero() || !end.After(start) || end.Sub(startDay) > maxCachedRosterDays*24*time.Hour {
+		return r.fetchRange(ctx, cli, start, end)
+	}
+
+	var result []*rosterv1.PlannedShift
+	for day := startDay; day.Before(end); day = day.AddDate(0, 0, 1) {
+		key := r.rosterType + "/" + day.Format("2006-01-02")
+
+		r.l.Lock()
+		entry, ok := r.days[key]
+		r.l.Unlock()
+
+		if !ok || r.now().Sub(entry.loaded) >= r.ttl {
+			shifts, err := r.fetchRange(ctx, cli, day, day.AddDate(0, 0, 1))
+			if err != nil {
+				return nil, err
+			}
+
+			entry = rosterCacheEntry{shifts: shifts, loaded: r.now()}
+
+			r.l.Lock()
+			r.days[key] = entry
+			r.l.Unlock()
+		}
+
+		result = append(result, entry.shifts...)
+	}
+
+	return result, nil
+}
+
+func (r *rosterFetcher) fetchRange(ctx context.Context, cli rosterClient, start, end time.Time) ([]*rosterv1.PlannedShift, error) {
+	res, err := cli.GetWorkingStaff2(ctx, connect.NewRequest(&rosterv1.GetWorkingStaffRequest2{
+		Query: &rosterv1.GetWorkingStaffRequest2_TimeRange{
+			TimeRange: commonv1.NewTimeRange(start, end),
+		},
+		RosterTypeName: r.rosterType,
+	}))
+	if err != nil {
+		return nil, err
+	}
+
+	return res.Msg.CurrentShifts, nil
+}
+
+// recordFailure records err unless ctx has ended. Running out of the
+// request deadline is not a failure of the roster service.
+func (r *rosterFetcher) recordFailure(ctx context.Context, err error) {
+	if ctx.Err() != nil {
+		return
+	}
+
+	r.recordResult(err)
+}
+
+// recordResult updates the circuit breaker. The breaker state changes are logged
+// once instead of on each request.
+func (r *rosterFetcher) recordResult(err error) {
+	r.l.Lock()
+	defer r.l.Unlock()
+
+	if err == nil {
+		if r.failures >= r.threshold {
+			slog.Info("roster service recovered, closing circuit breaker")
+		}
+
+		r.failures = 0
+		r.openUntil = time.Time{}
+
+		return
+	}
+
+	r.failures++
+
+	if r.failures >= r.threshold {
+		r.openUntil = r.now().Add(r.cooldown)
+
+		if r.failures == r.threshold {
+			slog.Error("roster service failed repeatedly, skipping roster lookups", "error", err, "failures", r.failures, "cooldown", r.cooldown)
+		}
+	}
+}
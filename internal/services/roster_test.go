@@ -0,0 +1,198 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	rosterv1 "github.com/tierklinik-dobersberg/apis/gen/go/tkd/roster/v1"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+type fakeRosterClient struct {
+	fail          bool
+	staffCalls    int
+	workShiftCall int
+
+	// shifts, if set, are returned if they overlap with the requested range.
+	shifts []*rosterv1.PlannedShift
+}
+
+func (f *fakeRosterClient) GetWorkingStaff2(_ context.Context, req *connect.Request[rosterv1.GetWorkingStaffRequest2]) (*connect.Response[rosterv1.GetWorkingStaffResponse], error) {
+	f.staffCalls++
+
+	if f.fail {
+		return nil, errors.New("roster unavailable")
+	}
+
+	if f.shifts != nil {
+		var result []*rosterv1.PlannedShift
+		for _, s := range f.shifts {
+			if s.From.AsTime().Before(req.Msg.GetTimeRange().To.AsTime()) && s.To.AsTime().After(req.Msg.GetTimeRange().From.AsTime()) {
+				result = append(result, s)
+			}
+		}
+
+		return connect.NewResponse(&rosterv1.GetWorkingStaffResponse{CurrentShifts: result}), nil
+	}
+
+	from := req.Msg.GetTimeRange().From.AsTime().Add(8 * time.Hour)
+
+	return connect.NewResponse(&rosterv1.GetWorkingStaffResponse{
+		CurrentShifts: []*rosterv1.PlannedShift{
+			{
+				From:            timestamppb.New(from),
+				To:              timestamppb.New(from.Add(4 * time.Hour)),
+				AssignedUserIds: []string{"user-1"},
+				WorkShiftId:     "shift",
+				UniqueId:        from.Format(time.RFC3339),
+			},
+		},
+	}), nil
+}
+
+func (f *fakeRosterClient) ListWorkShifts(context.Context, *connect.Request[rosterv1.ListWorkShiftsRequest]) (*connect.Response[rosterv1.ListWorkShiftsResponse], error) {
+	f.workShiftCall++
+
+	if f.fail {
+		return nil, errors.New("roster unavailable")
+	}
+
+	return connect.NewResponse(&rosterv1.ListWorkShiftsResponse{
+		WorkShifts: []*rosterv1.WorkShift{
+			{Id: "shift", Name: "Morning"},
+		},
+	}), nil
+}
+
+func newTestFetcher(cli *fakeRosterClient, now *time.Time) *rosterFetcher {
+	return &rosterFetcher{
+		clients: func(context.Context) (rosterClient, workShiftClient, error) {
+			return cli, cli, nil
+		},
+		rosterType: "Tierarzt",
+		ttl:        time.Minute,
+		threshold:  2,
+		cooldown:   30 * time.Second,
+		now:        func() time.Time { return *now },
+		loc:        time.Local,
+		days:       make(map[string]rosterCacheEntry),
+	}
+}
+
+func Test_RosterFetcher_Cache(t *testing.T) {
+	now := time.Date(2024, 6, 3, 10, 0, 0, 0, time.Local)
+	cli := &fakeRosterClient{}
+	fetcher := newTestFetcher(cli, &now)
+
+	start := time.Date(2024, 6, 3, 0, 0, 0, 0, time.Local)
+	end := start.AddDate(0, 0, 2)
+
+	shifts, definitions, err := fetcher.Fetch(context.Background(), start, end)
+	require.NoError(t, err)
+	assert.Len(t, shifts, 2)
+	assert.Contains(t, definitions, "shift")
+	assert.Equal(t, 2, cli.staffCalls)
+	assert.Equal(t, 1, cli.workShiftCall)
+
+	// a request within the TTL is served from the cache
+	_, _, err = fetcher.Fetch(context.Background(), start, start.AddDate(0, 0, 1))
+	require.NoError(t, err)
+	assert.Equal(t, 2, cli.staffCalls)
+	assert.Equal(t, 1, cli.workShiftCall)
+
+	// after the TTL the day is fetched again
+	now = now.Add(2 * time.Minute)
+
+	_, _, err = fetcher.Fetch(context.Background(), start, start.AddDate(0, 0, 1))
+	require.NoError(t, err)
+	assert.Equal(t, 3, cli.staffCalls)
+	assert.Equal(t, 2, cli.workShiftCall)
+}
+
+func Test_RosterFetcher_CircuitBreaker(t *testing.T) {
+	now := time.Date(2024, 6, 3, 10, 0, 0, 0, time.Local)
+	cli := &fakeRosterClient{fail: true}
+	fetcher := newTestFetcher(cli, &now)
+
+	start := time.Date(2024, 6, 3, 0, 0, 0, 0, time.Local)
+	end := start.AddDate(0, 0, 1)
+
+	for i := 0; i < 2; i++ {
+		_, _, err := fetcher.Fetch(context.Background(), start, end)
+		require.Error(t, err)
+		assert.NotErrorIs(t, err, ErrRosterUnavailable)
+	}
+
+	state := fetcher.State()
+	assert.True(t, state.Open)
+	assert.Equal(t, 2, state.ConsecutiveFailures)
+
+	// while the breaker is open the roster service is not contacted
+	cli.fail = false
+	calls := cli.workShiftCall
+
+	_, _, err := fetcher.Fetch(context.Background(), start, end)
+	assert.ErrorIs(t, err, ErrRosterUnavailable)
+	assert.Equal(t, calls, cli.workShiftCall)
+
+	// after the cooldown the roster service is tried again and the
+	// breaker closes once it recovered.
+	now = now.Add(time.Minute)
+
+	shifts, _, err := fetcher.Fetch(context.Background(), start, end)
+	require.NoError(t, err)
+	assert.Len(t, shifts, 1)
+
+	state = fetcher.State()
+	assert.False(t, state.Open)
+	assert.Equal(t, 0, state.ConsecutiveFailures)
+}
+
+func Test_RosterFetcher_ShiftCrossingMidnight(t *testing.T) {
+	vienna := time.FixedZone("CEST", 2*60*60)
+
+	// the night shift starts at 00:30 local time which is still the
+	// previous day in UTC.
+	nightStart := time.Date(2024, 6, 4, 0, 30, 0, 0, vienna)
+	lateStart := time.Date(2024, 6, 3, 20, 0, 0, 0, vienna)
+
+	cli := &fakeRosterClient{
+		shifts: []*rosterv1.PlannedShift{
+			{
+				From:            timestamppb.New(lateStart),
+				To:              timestamppb.New(lateStart.Add(8 * time.Hour)),
+				AssignedUserIds: []string{"user-1"},
+				WorkShiftId:     "shift",
+				UniqueId:        "late",
+			},
+			{
+				From:            timestamppb.New(nightStart),
+				To:              timestamppb.New(nightStart.Add(6 * time.Hour)),
+				AssignedUserIds: []string{"user-2"},
+				WorkShiftId:     "shift",
+				UniqueId:        "night",
+			},
+		},
+	}
+
+	now := time.Date(2024, 6, 3, 10, 0, 0, 0, vienna)
+	fetcher := newTestFetcher(cli, &now)
+	fetcher.loc = vienna
+
+	start := time.Date(2024, 6, 3, 0, 0, 0, 0, vienna)
+	shifts, _, err := fetcher.Fetch(context.Background(), start, start.AddDate(0, 0, 2))
+	require.NoError(t, err)
+
+	// the late shift is returned for both days but must only be bucketed
+	// once
+	require.Len(t, shifts["2024-06-03"], 1)
+	assert.Equal(t, "late", shifts["2024-06-03"][0].UniqueId)
+
+	require.Len(t, shifts["2024-06-04"], 1)
+	assert.Equal(t, "night", shifts["2024-06-04"][0].UniqueId)
+}
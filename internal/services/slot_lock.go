@@ -0,0 +1,229 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/bufbuild/connect-go"
+)
+
+// slotLockHeader may be set on CreateEvent requests to the token of a slot
+// lock held by the caller. The CreateEventRequest does not have a field for
+// it yet.
+const slotLockHeader = "X-Slot-Lock"
+
+// slotLock is an advisory lock on a time range of a calendar. It is held
+// while a booking dialog is open so two users do not book the same free
+// slot.
+type slotLock struct {
+	Token      string    `json:"token"`
+	CalendarID string    `json:"calendarId"`
+	Start      time.Time `json:"start"`
+	End        time.Time `json:"end"`
+	Owner      string    `json:"owner,omitempty"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+}
+
+func (l slotLock) overlaps(calendarID string, start, end time.Time) bool {
+	return l.CalendarID == calendarID && l.Start.Before(end) && start.Before(l.End)
+}
+
+// errSlotLocked is returned if a time range is already locked.
+type errSlotLocked struct {
+	lock slotLock
+}
+
+func (e errSlotLocked) Error() string {
+	return fmt.Sprintf("%s - %s is locked until %s", e.lock.Start.Format(time.RFC3339), e.lock.End.Format(time.RFC3339), e.lock.ExpiresAt.Format(time.RFC3339))
+}
+
+// slotLocks holds the slot locks of this instance. Locks are best-effort:
+// they are not shared between instances and vanish once expired.
+type slotLocks struct {
+	ttl time.Duration
+	now func() time.Time
+
+	l     sync.Mutex
+	locks map[string]slotLock
+}
+
+func newSlotLocks(ttl time.Duration) *slotLocks {
+	return &slotLocks{
+		ttl:   ttl,
+		now:   time.Now,
+		locks: make(map[string]slotLock),
+	}
+}
+
+// expire removes all expired locks. The caller must hold s.l.
+func (s *slotLocks) expire(now time.Time) {
+	for token, l := range s.locks {
+		if !now.Before(l.ExpiresAt) {
+			delete(s.locks, token)
+		}
+	}
+}
+
+// conflicting returns an unexpired lock other than token that overlaps the
+// given time range. The caller must hold s.l.
+func (s *slotLocks) conflicting(calendarID string, start, end time.Time, token string) (slotLock, bool) {
+	for _, l := range s.locks {
+		if l.Token != token && l.overlaps(calendarID, start, end) {
+			return l, true
+		}
+	}
+
+	return slotLock{}, false
+}
+
+// lock locks the time range for ttl. It fails with errSlotLocked if an
+// overlapping lock is held by someone else.
+func (s *slotLocks) lock(calendarID string, start, end time.Time, owner string) (slotLock, error) {
+	if !end.After(start) {
+		return slotLock{}, fmt.Errorf("end must be after start")
+	}
+
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return slotLock{}, err
+	}
+
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	now := s.now()
+	s.expire(now)
+
+	if l, ok := s.conflicting(calendarID, start, end, ""); ok {
+		return slotLock{}, errSlotLocked{lock: l}
+	}
+
+	l := slotLock{
+		Token:      hex.EncodeToString(buf),
+		CalendarID: calendarID,
+		Start:      start,
+		End:        end,
+		Owner:      owner,
+		ExpiresAt:  now.Add(s.ttl),
+	}
+	s.locks[l.Token] = l
+
+	return l, nil
+}
+
+// release removes the lock with token. It reports whether the lock has
+// still been held.
+func (s *slotLocks) release(token string) bool {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	s.expire(s.now())
+
+	_, ok := s.locks[token]
+	delete(s.locks, token)
+
+	return ok
+}
+
+// check returns a FailedPrecondition error if the time range is locked by
+// anyone but the holder of token.
+func (s *slotLocks) check(calendarID string, start, end time.Time, token string) error {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	s.expire(s.now())
+
+	if l, ok := s.conflicting(calendarID, start, end, token); ok {
+		return connect.NewError(connect.CodeFailedPrecondition, errSlotLocked{lock: l})
+	}
+
+	return nil
+}
+
+// SlotLockHandler manages slot locks:
+//
+//	POST   /slot-locks {"calendarId": "...", "start": "...", "end": "..."}
+//	DELETE /slot-locks?token=<token>
+//
+// Creating a lock returns the lock including its token which must be passed
+// to CreateEvent in the X-Slot-Lock header. Overlapping locks are rejected
+// with 409 Conflict.
+type SlotLockHandler struct {
+	locks        *slotLocks
+	allowedRoles []string
+}
+
+// NewSlotLockHandler returns a new slot lock handler for svc. If
+// allowedRoles is empty all authenticated users may lock slots.
+func NewSlotLockHandler(svc *CalendarService, allowedRoles []string) *SlotLockHandler {
+	return &SlotLockHandler{
+		locks:        svc.slotLocks,
+		allowedRoles: allowedRoles,
+	}
+}
+
+func (h *SlotLockHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !authorize(w, r, h.allowedRoles) {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		var body struct {
+			CalendarID string    `json:"calendarId"`
+			Start      time.Time `json:"start"`
+			End        time.Time `json:"end"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if body.CalendarID == "" {
+			http.Error(w, "missing value for calendarId", http.StatusBadRequest)
+			return
+		}
+
+		l, err := h.locks.lock(body.CalendarID, body.Start, body.End, r.Header.Get("X-Remote-User-ID"))
+		if err != nil {
+			status := http.StatusBadRequest
+			if _, ok := err.(errSlotLocked); ok {
+				status = http.StatusConflict
+			}
+
+			http.Error(w, err.Error(), status)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+
+		if err := json.NewEncoder(w).Encode(l); err != nil {
+			slog.Error("failed to encode slot lock", "error", err)
+		}
+
+	case http.MethodDelete:
+		token := r.URL.Query().Get("token")
+		if token == "" {
+			http.Error(w, "missing value for token", http.StatusBadRequest)
+			return
+		}
+
+		if !h.locks.release(token) {
+			http.Error(w, "lock not found or expired", http.StatusNotFound)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,170 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_SlotLocks(t *testing.T) {
+	now := makeTime("08:00")
+
+	locks := newSlotLocks(2 * time.Minute)
+	locks.now = func() time.Time { return now }
+
+	first, err := locks.lock("vet-1", makeTime("09:00"), makeTime("09:30"), "alice")
+	require.NoError(t, err)
+	assert.NotEmpty(t, first.Token)
+	assert.Equal(t, now.Add(2*time.Minute), first.ExpiresAt)
+
+	// overlapping windows of the same calendar are rejected
+	_, err = locks.lock("vet-1", makeTime("09:15"), makeTime("09:45"), "bob")
+	assert.ErrorAs(t, err, new(errSlotLocked))
+
+	// adjacent windows and other calendars are fine
+	_, err = locks.lock("vet-1", makeTime("09:30"), makeTime("10:00"), "bob")
+	assert.NoError(t, err)
+
+	_, err = locks.lock("vet-2", makeTime("09:00"), makeTime("09:30"), "bob")
+	assert.NoError(t, err)
+
+	_, err = locks.lock("vet-1", makeTime("11:00"), makeTime("10:00"), "bob")
+	assert.Error(t, err)
+
+	// only the lock holder passes the check
+	assert.NoError(t, locks.check("vet-1", makeTime("09:00"), makeTime("09:20"), first.Token))
+	assert.Equal(t, connect.CodeFailedPrecondition, connect.CodeOf(locks.check("vet-1", makeTime("09:00"), makeTime("09:20"), "")))
+
+	// expired locks vanish
+	now = now.Add(2 * time.Minute)
+	assert.NoError(t, locks.check("vet-1", makeTime("09:00"), makeTime("09:20"), ""))
+	assert.False(t, locks.release(first.Token))
+
+	second, err := locks.lock("vet-1", makeTime("09:15"), makeTime("09:45"), "bob")
+	require.NoError(t, err)
+	assert.True(t, locks.release(second.Token))
+	assert.False(t, locks.release(second.Token))
+}
+
+func Test_SlotLocks_Contention(t *testing.T) {
+	locks := newSlotLocks(time.Minute)
+
+	var (
+		wg      sync.WaitGroup
+		l       sync.Mutex
+		granted []string
+	)
+
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			lock, err := locks.lock("vet-1", makeTime("09:00"), makeTime("09:30"), "")
+			if err != nil {
+				return
+			}
+
+			l.Lock()
+			granted = append(granted, lock.Token)
+			l.Unlock()
+		}()
+	}
+
+	wg.Wait()
+
+	require.Len(t, granted, 1)
+}
+
+func Test_SlotLockHandler(t *testing.T) {
+	svc := &CalendarService{slotLocks: newSlotLocks(time.Minute)}
+	h := NewSlotLockHandler(svc, nil)
+
+	post := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/slot-locks", strings.NewReader(body))
+		req.Header.Set("X-Remote-User-ID", "alice")
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		return rec
+	}
+
+	body := `{"calendarId": "vet-1", "start": "2024-06-03T09:00:00Z", "end": "2024-06-03T09:30:00Z"}`
+
+	rec := post(body)
+	require.Equal(t, http.StatusCreated, rec.Code)
+
+	var lock slotLock
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&lock))
+	assert.Equal(t, "vet-1", lock.CalendarID)
+	assert.NotEmpty(t, lock.Token)
+
+	assert.Equal(t, http.StatusConflict, post(body).Code)
+	assert.Equal(t, http.StatusBadRequest, post(`{"start": "2024-06-03T09:00:00Z"}`).Code)
+
+	release := func(token string) int {
+		req := httptest.NewRequest(http.MethodDelete, "/slot-locks?token="+token, nil)
+		req.Header.Set("X-Remote-User-ID", "alice")
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		return rec.Code
+	}
+
+	assert.Equal(t, http.StatusNoContent, release(lock.Token))
+	assert.Equal(t, http.StatusNotFound, release(lock.Token))
+
+	rec = post(body)
+	require.Equal(t, http.StatusCreated, rec.Code)
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&lock))
+
+	// anonymous callers can neither create nor release locks
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/slot-locks", strings.NewReader(`{"calendarId": "vet-2", "start": "2024-06-03T09:00:00Z", "end": "2024-06-03T09:30:00Z"}`)))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/slot-locks?token="+lock.Token, nil))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Equal(t, http.StatusConflict, post(body).Code)
+
+	h.allowedRoles = []string{"booking"}
+	assert.Equal(t, http.StatusForbidden, release(lock.Token))
+}
+
+func Test_CreateEvent_SlotLock(t *testing.T) {
+	svc, fake := newBookingTestService(t)
+
+	at := func(ts string) time.Time {
+		return time.Date(2024, time.June, 3, makeTime(ts).Hour(), makeTime(ts).Minute(), 0, 0, time.UTC)
+	}
+
+	lock, err := svc.slotLocks.lock("vet-1", at("14:00"), at("14:30"), "alice")
+	require.NoError(t, err)
+
+	// someone else may not book the locked slot
+	_, err = svc.CreateEvent(context.Background(), createEventRequest(t, "14:15", "14:45", "maier"))
+	require.Error(t, err)
+	assert.Equal(t, connect.CodeFailedPrecondition, connect.CodeOf(err))
+	assert.Empty(t, fake.created)
+
+	// the lock holder may book it and the lock is released afterwards
+	req := createEventRequest(t, "14:00", "14:30", "maier")
+	req.Header().Set(slotLockHeader, lock.Token)
+
+	_, err = svc.CreateEvent(context.Background(), req)
+	require.NoError(t, err)
+	assert.Len(t, fake.created, 1)
+	assert.False(t, svc.slotLocks.release(lock.Token))
+}
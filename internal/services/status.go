@@ -0,0 +1,108 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/repo"
+)
+
+// eventStatus is the appointment status of an event as served by the
+// StatusHandler.
+type eventStatus struct {
+	CalendarID string    `json:"calendarId"`
+	EventID    string    `json:"eventId"`
+	Status     string    `json:"status"`
+	ChangedBy  string    `json:"changedBy,omitempty"`
+	ChangedAt  time.Time `json:"changedAt"`
+}
+
+// StatusHandler changes the appointment status of events, like marking
+// the arrival of a customer at the reception:
+//
+//	POST /event-status {"calendarId": "<id>", "eventId": "<id>", "status": "arrived"}
+//
+// Allowed transitions are planned → arrived → in-progress → done and
+// planned → no-show. Requests must be authenticated, the user is recorded
+// as the one who changed the status.
+type StatusHandler struct {
+	svc *CalendarService
+}
+
+// NewStatusHandler returns a new status handler for svc.
+func NewStatusHandler(svc *CalendarService) *StatusHandler {
+	return &StatusHandler{svc: svc}
+}
+
+func (h *StatusHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !authorize(w, r, nil) {
+		return
+	}
+
+	var body struct {
+		CalendarID string `json:"calendarId"`
+		EventID    string `json:"eventId"`
+		Status     string `json:"status"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if body.CalendarID == "" || body.EventID == "" {
+		http.Error(w, "missing value for calendarId or eventId", http.StatusBadRequest)
+		return
+	}
+
+	status, err := repo.ParseStatus(body.Status)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := pseudoEventError(body.EventID); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.svc.checkWritable(body.CalendarID); err != nil {
+		http.Error(w, err.Error(), httpStatus(err))
+		return
+	}
+
+	evt, err := h.svc.repo.UpdateEventStatus(r.Context(), body.CalendarID, body.EventID, status, r.Header.Get("X-Remote-User-ID"))
+	if err != nil {
+		code := httpStatus(err)
+
+		// invalid transitions and concurrent modifications conflict with
+		// the current status of the event.
+		if errors.Is(err, repo.ErrInvalidEvent) || connect.CodeOf(err) == connect.CodeAborted {
+			code = http.StatusConflict
+		}
+
+		http.Error(w, err.Error(), code)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(eventStatus{
+		CalendarID: evt.CalendarID,
+		EventID:    evt.ID,
+		Status:     evt.CurrentStatus(),
+		ChangedBy:  evt.StatusChangedBy,
+		ChangedAt:  evt.StatusChangedAt,
+	}); err != nil {
+		slog.Error("failed to encode event status", "error", err)
+	}
+}
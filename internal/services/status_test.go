@@ -0,0 +1,129 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/app"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/cache"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/repo"
+)
+
+// statusRepo is a repo.Service that stores the status of a single event.
+type statusRepo struct {
+	repo.Service
+
+	status    string
+	changedBy string
+}
+
+func (s *statusRepo) UpdateEventStatus(_ context.Context, calID, eventID, status, changedBy string) (*repo.Event, error) {
+	if eventID != "1" {
+		return nil, connect.NewError(connect.CodeNotFound, fmt.Errorf("event %q not found", eventID))
+	}
+
+	if err := repo.ValidateStatusTransition(s.status, status); err != nil {
+		return nil, err
+	}
+
+	s.status = status
+	s.changedBy = changedBy
+
+	return &repo.Event{
+		ID:              eventID,
+		CalendarID:      calID,
+		Status:          status,
+		StatusChangedBy: changedBy,
+		StatusChangedAt: time.Now(),
+	}, nil
+}
+
+func Test_StatusHandler(t *testing.T) {
+	fake := new(statusRepo)
+
+	calendarById := cache.NewIndex(func(c repo.Calendar) (string, bool) {
+		return c.ID, true
+	})
+	calendarById.Update([]repo.Calendar{{ID: "readonly", Readonly: true}})
+
+	h := NewStatusHandler(&CalendarService{
+		repo:         &app.App{Service: fake},
+		calendarById: calendarById,
+	})
+
+	post := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/event-status", strings.NewReader(body))
+		req.Header.Set("X-Remote-User-ID", "alice")
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		return rec
+	}
+
+	rec := post(`{"calendarId": "vet", "eventId": "1", "status": "Arrived"}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	var res eventStatus
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&res))
+	assert.Equal(t, "arrived", res.Status)
+	assert.Equal(t, "alice", res.ChangedBy)
+	assert.Equal(t, "alice", fake.changedBy)
+
+	// invalid transition
+	rec = post(`{"calendarId": "vet", "eventId": "1", "status": "done"}`)
+	assert.Equal(t, http.StatusConflict, rec.Code)
+	assert.Equal(t, repo.StatusArrived, fake.status)
+
+	rec = post(`{"calendarId": "vet", "eventId": "1", "status": "waiting"}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = post(`{"calendarId": "vet", "eventId": "2", "status": "in-progress"}`)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = post(`{"calendarId": "readonly", "eventId": "1", "status": "in-progress"}`)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	rec = post(`{"calendarId": "vet", "status": "in-progress"}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/event-status", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+
+	// anonymous callers must not change the status
+	fake.status = ""
+	fake.changedBy = ""
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/event-status", strings.NewReader(`{"calendarId": "vet", "eventId": "1", "status": "arrived"}`)))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Empty(t, fake.status)
+	assert.Empty(t, fake.changedBy)
+}
+
+func Test_ListEvents_Status(t *testing.T) {
+	svc, fake := newMaskTestService(1, 2)
+	fake.events[1].Status = repo.StatusArrived
+
+	req := listEventsRequest(1)
+	req.Header().Add(eventStatusHeader, "Arrived")
+
+	res, err := svc.ListEvents(context.Background(), req)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"cal-0 1 arrived"}, res.Header().Values(eventStatusResultHeader))
+
+	req.Header().Set(eventStatusHeader, "waiting")
+
+	_, err = svc.ListEvents(context.Background(), req)
+	assert.Equal(t, connect.CodeInvalidArgument, connect.CodeOf(err))
+}
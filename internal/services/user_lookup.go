@@ -0,0 +1,129 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/bufbuild/connect-go"
+	idmv1 "github.com/tierklinik-dobersberg/apis/gen/go/tkd/idm/v1"
+	"golang.org/x/sync/singleflight"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+)
+
+// unknownUserTTL is how long a user id that is unknown to the IDM is not
+// looked up again.
+const unknownUserTTL = time.Minute
+
+// userLookups deduplicates concurrent IDM lookups of the same user and
+// remembers user ids that are unknown to the IDM. The zero value is ready
+// to use.
+type userLookups struct {
+	group singleflight.Group
+
+	l       sync.Mutex
+	unknown map[string]time.Time
+}
+
+// isUnknown reports whether id has recently been reported as unknown by
+// the IDM.
+func (ul *userLookups) isUnknown(id string, now time.Time) bool {
+	ul.l.Lock()
+	defer ul.l.Unlock()
+
+	until, ok := ul.unknown[id]
+	if ok && !now.Before(until) {
+		delete(ul.unknown, id)
+		return false
+	}
+
+	return ok
+}
+
+// markUnknown remembers that id is unknown to the IDM for unknownUserTTL.
+func (ul *userLookups) markUnknown(id string, now time.Time) {
+	ul.l.Lock()
+	defer ul.l.Unlock()
+
+	if ul.unknown == nil {
+		ul.unknown = make(map[string]time.Time)
+	}
+
+	// drop expired entries so random ids do not grow the map forever.
+	for key, until := range ul.unknown {
+		if !now.Before(until) {
+			delete(ul.unknown, key)
+		}
+	}
+
+	ul.unknown[id] = now.Add(unknownUserTTL)
+}
+
+// lookupUser returns the profile of the user with the given id. Users that
+// are missing from the profile cache, like users that have been created
+// after the last refresh, are loaded from the IDM and added to the cache. A
+// NotFound error is returned if the IDM does not know the user either.
+// Concurrent lookups of the same user share one IDM request and unknown
+// users are not looked up again for unknownUserTTL.
+func (svc *CalendarService) lookupUser(ctx context.Context, id string) (*idmv1.Profile, error) {
+	if id == "" {
+		return nil, connect.NewError(connect.CodeNotFound, fmt.Errorf("no user id specified"))
+	}
+
+	if user, ok := svc.byUserId.Get(id); ok {
+		return user, nil
+	}
+
+	if svc.repo == nil || svc.repo.Users == nil || svc.userLookups.isUnknown(id, time.Now()) {
+		return nil, connect.NewError(connect.CodeNotFound, fmt.Errorf("user %q not found", id))
+	}
+
+	profile, err, _ := svc.userLookups.group.Do(id, func() (any, error) {
+		profile, err := svc.loadUser(ctx, id)
+		if connect.CodeOf(err) == connect.CodeNotFound {
+			svc.userLookups.markUnknown(id, time.Now())
+		}
+
+		return profile, err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return profile.(*idmv1.Profile), nil
+}
+
+// loadUser loads the profile of the user with the given id from the IDM
+// and adds it to the profile cache.
+func (svc *CalendarService) loadUser(ctx context.Context, id string) (*idmv1.Profile, error) {
+	res, err := svc.repo.Users.GetUser(ctx, connect.NewRequest(&idmv1.GetUserRequest{
+		Search: &idmv1.GetUserRequest_Id{Id: id},
+		FieldMask: &fieldmaskpb.FieldMask{
+			Paths: []string{"profile.user.extra", "profile.user.id", "profile.user.username"},
+		},
+	}))
+	if err != nil {
+		if connect.CodeOf(err) == connect.CodeNotFound {
+			return nil, connect.NewError(connect.CodeNotFound, fmt.Errorf("user %q not found", id))
+		}
+
+		return nil, connect.NewError(connect.CodeUnavailable, fmt.Errorf("failed to load user %q: %w", id, err))
+	}
+
+	profile := res.Msg.GetProfile()
+	if profile.GetUser().GetId() != id {
+		return nil, connect.NewError(connect.CodeNotFound, fmt.Errorf("user %q not found", id))
+	}
+
+	slog.Info("loaded user profile missing from the cache", "user-id", id)
+
+	if svc.users != nil {
+		svc.users.Upsert(profile, func(p *idmv1.Profile) bool {
+			return p.GetUser().GetId() == id
+		})
+	}
+
+	return profile, nil
+}
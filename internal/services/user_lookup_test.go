@@ -0,0 +1,162 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	calendarv1 "github.com/tierklinik-dobersberg/apis/gen/go/tkd/calendar/v1"
+	idmv1 "github.com/tierklinik-dobersberg/apis/gen/go/tkd/idm/v1"
+	"github.com/tierklinik-dobersberg/apis/gen/go/tkd/idm/v1/idmv1connect"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/cache"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// fakeIDM knows the given profiles and counts GetUser calls.
+type fakeIDM struct {
+	idmv1connect.UserServiceClient
+
+	profiles map[string]*idmv1.Profile
+	err      error
+	calls    int
+
+	// setErr is returned by SetUserExtraKey.
+	setErr error
+}
+
+func (f *fakeIDM) GetUser(_ context.Context, req *connect.Request[idmv1.GetUserRequest]) (*connect.Response[idmv1.GetUserResponse], error) {
+	f.calls++
+
+	if f.err != nil {
+		return nil, f.err
+	}
+
+	profile, ok := f.profiles[req.Msg.GetId()]
+	if !ok {
+		return nil, connect.NewError(connect.CodeNotFound, fmt.Errorf("user not found"))
+	}
+
+	return connect.NewResponse(&idmv1.GetUserResponse{Profile: profile}), nil
+}
+
+func newUserLookupTestService() (*CalendarService, *fakeIDM) {
+	svc, _ := newMaskTestService(2, 1)
+
+	idm := &fakeIDM{
+		profiles: map[string]*idmv1.Profile{
+			"new": {User: &idmv1.User{Id: "new", Extra: &structpb.Struct{Fields: map[string]*structpb.Value{
+				"calendarID": structpb.NewStringValue("cal-1"),
+			}}}},
+			"no-calendar": {User: &idmv1.User{Id: "no-calendar"}},
+		},
+	}
+	svc.repo.Users = idm
+
+	// the profile cache has not picked up any users yet
+	svc.users = cache.NewCache("profiles", time.Minute, cache.LoaderFunc[*idmv1.Profile](func(context.Context) ([]*idmv1.Profile, error) {
+		return nil, nil
+	}))
+	svc.byUserId = cache.CreateIndex(svc.users, func(p *idmv1.Profile) (string, bool) {
+		return p.User.Id, true
+	})
+
+	return svc, idm
+}
+
+func Test_ListEvents_UserLookupFallback(t *testing.T) {
+	svc, idm := newUserLookupTestService()
+
+	self := func(userID string) *connect.Request[calendarv1.ListEventsRequest] {
+		req := listEventsRequest(0)
+		req.Msg.Source = nil
+		req.Header().Set("X-Remote-User-ID", userID)
+
+		return req
+	}
+
+	res, err := svc.ListEvents(context.Background(), self("new"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"cal-1"}, resultCalendars(res))
+	assert.Equal(t, 1, idm.calls)
+
+	// the profile has been added to the cache
+	_, err = svc.ListEvents(context.Background(), self("new"))
+	require.NoError(t, err)
+	assert.Equal(t, 1, idm.calls)
+
+	_, err = svc.ListEvents(context.Background(), self("unknown"))
+	assert.Equal(t, connect.CodePermissionDenied, connect.CodeOf(err))
+
+	// user sources are resolved the same way
+	req := listEventsRequest(0)
+	req.Msg.Source = &calendarv1.ListEventsRequest_Sources{Sources: &calendarv1.EventSource{
+		UserIds: []string{"new", "unknown", "no-calendar"},
+	}}
+
+	res, err = svc.ListEvents(context.Background(), req)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"cal-1"}, resultCalendars(res))
+	assert.Equal(t, []string{"user no-calendar no-calendar", "user unknown not-found"}, res.Header().Values(unresolvedSourceHeader))
+
+	// a failing IDM is not reported as an unknown user
+	idm.err = connect.NewError(connect.CodeUnavailable, fmt.Errorf("idm down"))
+
+	req.Msg.Source = &calendarv1.ListEventsRequest_Sources{Sources: &calendarv1.EventSource{
+		UserIds: []string{"other"},
+	}}
+
+	_, err = svc.ListEvents(context.Background(), req)
+	assert.Equal(t, connect.CodeUnavailable, connect.CodeOf(err))
+}
+
+func Test_ResolveUserCalendar_Fallback(t *testing.T) {
+	svc, idm := newUserLookupTestService()
+
+	calID, err := svc.resolveUserCalendar(context.Background(), "new")
+	require.NoError(t, err)
+	assert.Equal(t, "cal-1", calID)
+
+	_, ok := svc.byUserId.Get("new")
+	assert.True(t, ok)
+
+	_, err = svc.resolveUserCalendar(context.Background(), "unknown")
+	assert.Equal(t, connect.CodeNotFound, connect.CodeOf(err))
+
+	_, err = svc.resolveUserCalendar(context.Background(), "no-calendar")
+	assert.Equal(t, connect.CodeNotFound, connect.CodeOf(err))
+
+	assert.Equal(t, 3, idm.calls)
+}
+
+func Test_LookupUser_CachesUnknownUsers(t *testing.T) {
+	svc, idm := newUserLookupTestService()
+
+	for i := 0; i < 5; i++ {
+		_, err := svc.lookupUser(context.Background(), "unknown")
+		assert.Equal(t, connect.CodeNotFound, connect.CodeOf(err))
+	}
+
+	assert.Equal(t, 1, idm.calls)
+
+	// failures of the IDM are not cached
+	idm.err = connect.NewError(connect.CodeUnavailable, fmt.Errorf("idm down"))
+
+	for i := 0; i < 2; i++ {
+		_, err := svc.lookupUser(context.Background(), "other")
+		assert.Equal(t, connect.CodeUnavailable, connect.CodeOf(err))
+	}
+
+	assert.Equal(t, 3, idm.calls)
+
+	// unknown users are looked up again once the entry expired
+	idm.err = nil
+	assert.False(t, svc.userLookups.isUnknown("unknown", time.Now().Add(unknownUserTTL)))
+
+	_, err := svc.lookupUser(context.Background(), "unknown")
+	assert.Equal(t, connect.CodeNotFound, connect.CodeOf(err))
+	assert.Equal(t, 4, idm.calls)
+}
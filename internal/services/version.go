@@ -0,0 +1,74 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/tierklinik-dobersberg/cis-cal/internal/cache"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/repo"
+)
+
+// VersionHandler serves the versions of calendars so polling clients can
+// skip fetching events if nothing changed:
+//
+//	GET /calendar-versions?calendar=<id>&calendar=<id>
+//
+// The response maps each calendar id to its version. Versions are opaque
+// strings that change whenever events of the calendar are created, updated
+// or deleted. Unknown calendars are rejected with 404 Not Found.
+type VersionHandler struct {
+	repo      repo.Service
+	calendars *cache.Index[string, repo.Calendar]
+}
+
+// NewVersionHandler returns a new version handler for svc.
+func NewVersionHandler(svc *CalendarService) *VersionHandler {
+	return &VersionHandler{
+		repo:      svc.repo,
+		calendars: svc.calendarById,
+	}
+}
+
+func (h *VersionHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !authorize(w, r, nil) {
+		return
+	}
+
+	calendars := r.URL.Query()["calendar"]
+	if len(calendars) == 0 {
+		http.Error(w, "missing value for calendar", http.StatusBadRequest)
+		return
+	}
+
+	versions := make(map[string]string, len(calendars))
+	for _, id := range calendars {
+		// the backend creates an event cache for every calendar id it is
+		// asked for so only known calendars must be passed on.
+		if _, ok := h.calendars.Get(id); !ok {
+			http.Error(w, fmt.Sprintf("calendar %q not found", id), http.StatusNotFound)
+			return
+		}
+
+		v, err := h.repo.CalendarVersion(r.Context(), id)
+		if err != nil {
+			http.Error(w, err.Error(), httpStatus(err))
+			return
+		}
+
+		versions[id] = strconv.FormatInt(v, 10)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(map[string]any{"versions": versions}); err != nil {
+		slog.Error("failed to encode calendar versions", "error", err)
+	}
+}
@@ -0,0 +1,94 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/cache"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/repo"
+)
+
+// versionRepo is a repo.Service with fixed calendar versions.
+type versionRepo struct {
+	repo.Service
+
+	versions map[string]int64
+}
+
+func (v versionRepo) CalendarVersion(_ context.Context, calID string) (int64, error) {
+	version, ok := v.versions[calID]
+	if !ok {
+		return 0, connect.NewError(connect.CodeNotFound, fmt.Errorf("calendar %q not found", calID))
+	}
+
+	return version, nil
+}
+
+func Test_VersionHandler(t *testing.T) {
+	calendars := cache.NewIndex(func(c repo.Calendar) (string, bool) {
+		return c.ID, true
+	})
+	calendars.Update([]repo.Calendar{{ID: "vet-1"}, {ID: "vet-2"}})
+
+	backend := &countingVersionRepo{versionRepo: versionRepo{versions: map[string]int64{
+		"vet-1":   1717401600000,
+		"vet-2":   42,
+		"removed": 7,
+	}}}
+
+	h := &VersionHandler{
+		repo:      backend,
+		calendars: calendars,
+	}
+
+	get := func(target string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.Header.Set("X-Remote-User-ID", "alice")
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		return rec
+	}
+
+	rec := get("/calendar-versions?calendar=vet-1&calendar=vet-2")
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var res struct {
+		Versions map[string]string `json:"versions"`
+	}
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&res))
+	assert.Equal(t, map[string]string{"vet-1": "1717401600000", "vet-2": "42"}, res.Versions)
+
+	assert.Equal(t, http.StatusBadRequest, get("/calendar-versions").Code)
+
+	// unknown calendars must never reach the backend
+	backend.calls = 0
+	assert.Equal(t, http.StatusNotFound, get("/calendar-versions?calendar=unknown").Code)
+	assert.Equal(t, http.StatusNotFound, get("/calendar-versions?calendar=vet-1&calendar=removed").Code)
+	assert.Equal(t, 1, backend.calls)
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/calendar-versions?calendar=vet-1", nil))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+}
+
+// countingVersionRepo counts the calendar versions requested.
+type countingVersionRepo struct {
+	versionRepo
+
+	calls int
+}
+
+func (c *countingVersionRepo) CalendarVersion(ctx context.Context, calID string) (int64, error) {
+	c.calls++
+
+	return c.versionRepo.CalendarVersion(ctx, calID)
+}
@@ -0,0 +1,90 @@
+package services
+
+import (
+	"net/http"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/i18n"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/repo"
+)
+
+// eventVisibilityHeader may be set on CreateEvent and UpdateEvent
+// (update-mask path "visibility") requests to set the visibility of the
+// event to default, public, private or confidential. The request messages
+// do not yet have a field for the visibility.
+const eventVisibilityHeader = "X-Event-Visibility"
+
+// visibilityFromHeader returns the visibility set in the
+// eventVisibilityHeader.
+func visibilityFromHeader(header http.Header) (string, error) {
+	visibility, err := repo.ParseVisibility(header.Get(eventVisibilityHeader))
+	if err != nil {
+		return "", connect.NewError(connect.CodeInvalidArgument, err)
+	}
+
+	return visibility, nil
+}
+
+// isCalendarOwner reports whether userID is the user the calendar is
+// assigned to.
+func (svc *CalendarService) isCalendarOwner(calID, userID string) bool {
+	if userID == "" {
+		return false
+	}
+
+	user, ok := svc.userByCalId.Get(calID)
+
+	return ok && user.User.GetId() == userID
+}
+
+// eventRedactor hides the details of private events from everyone but the
+// owner of their calendar, regardless of the roles of the caller. All
+// endpoints that return events must pass them through redact. The zero
+// value hides private events from everyone.
+type eventRedactor struct {
+	isOwner func(calID, userID string) bool
+}
+
+// redactor returns the event redactor of svc.
+func (svc *CalendarService) redactor() eventRedactor {
+	return eventRedactor{isOwner: svc.isCalendarOwner}
+}
+
+// hidesDetails reports whether the details of e must be hidden from userID.
+// Overlay events are owned by their source calendar.
+func (r eventRedactor) hidesDetails(e repo.Event, userID string) bool {
+	if !e.IsPrivate() {
+		return false
+	}
+
+	calID := e.CalendarID
+	if e.OverlayOf != "" {
+		calID = e.OverlayOf
+	}
+
+	return r.isOwner == nil || userID == "" || !r.isOwner(calID, userID)
+}
+
+// redact hides the details of the private events that userID may not see.
+// Redacted events keep their time so they still show as busy.
+func (r eventRedactor) redact(events []repo.Event, userID string, lang i18n.Language) []repo.Event {
+	for idx, e := range events {
+		if !r.hidesDetails(e, userID) {
+			continue
+		}
+
+		events[idx].Summary = lang.Sprintf(i18n.PrivateEventSummary)
+		events[idx].Description = ""
+		events[idx].DescriptionFormat = ""
+		events[idx].Data = nil
+		events[idx].Tags = nil
+		events[idx].GeneratedSummary = false
+	}
+
+	return events
+}
+
+// requestLanguage returns the language of a plain HTTP request.
+func requestLanguage(r *http.Request, fallback string) i18n.Language {
+	return i18n.Match(r.Header.Get("Accept-Language"), i18n.Language(fallback))
+}
@@ -0,0 +1,166 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	idmv1 "github.com/tierklinik-dobersberg/apis/gen/go/tkd/idm/v1"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/cache"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/repo"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// visibilityLister returns one event per visibility for each calendar.
+type visibilityLister struct {
+	repo.Service
+}
+
+func (visibilityLister) ListEvents(_ context.Context, calID string, _ ...repo.SearchOption) ([]repo.Event, error) {
+	start := time.Date(2024, 6, 3, 8, 0, 0, 0, time.Local)
+
+	var events []repo.Event
+	for idx, visibility := range []string{"", repo.VisibilityPublic, repo.VisibilityPrivate, repo.VisibilityConfidential} {
+		end := start.Add(time.Duration(idx+1) * time.Hour)
+
+		events = append(events, repo.Event{
+			ID:          calID + "-" + visibility,
+			CalendarID:  calID,
+			Summary:     "HR talk",
+			Description: "salary",
+			StartTime:   start.Add(time.Duration(idx) * time.Hour),
+			EndTime:     &end,
+			Data:        &repo.StructuredEvent{CustomerSource: "vetinf", CustomerID: "1234"},
+			Tags:        []string{"hr"},
+			Visibility:  visibility,
+		})
+	}
+
+	return events, nil
+}
+
+func newVisibilityTestService() *CalendarService {
+	svc, _ := newMaskTestService(1, 0)
+	svc.events = visibilityLister{}
+
+	svc.userByCalId = cache.NewIndex(func(p *idmv1.Profile) (string, bool) {
+		calID := p.User.GetExtra().GetFields()["calendarID"].GetStringValue()
+		return calID, calID != ""
+	})
+	svc.userByCalId.Update([]*idmv1.Profile{
+		{
+			User: &idmv1.User{
+				Id: "owner",
+				Extra: &structpb.Struct{Fields: map[string]*structpb.Value{
+					"calendarID": structpb.NewStringValue("cal-0"),
+				}},
+			},
+		},
+	})
+
+	return svc
+}
+
+func Test_ListEvents_Visibility(t *testing.T) {
+	svc := newVisibilityTestService()
+
+	cases := []struct {
+		name   string
+		userID string
+		roles  []string
+		hidden bool
+	}{
+		{"owner", "owner", nil, false},
+		{"owner with admin role", "owner", []string{"admin"}, false},
+		{"other user", "other", nil, true},
+		{"admin", "admin", []string{"admin"}, true},
+		{"anonymous", "", nil, true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			req := listEventsRequest(1)
+			req.Header().Set("X-Remote-User-ID", c.userID)
+			for _, role := range c.roles {
+				req.Header().Add("X-Remote-Role", role)
+			}
+
+			res, err := svc.ListEvents(context.Background(), req)
+			require.NoError(t, err)
+			require.Len(t, res.Msg.Results, 1)
+
+			events := res.Msg.Results[0].Events
+			require.Len(t, events, 4)
+
+			for _, e := range events {
+				private := e.Id == "cal-0-private" || e.Id == "cal-0-confidential"
+
+				if private && c.hidden {
+					assert.Equal(t, "Privat", e.Summary, e.Id)
+					assert.Empty(t, e.Description, e.Id)
+					assert.Nil(t, e.ExtraData, e.Id)
+					assert.NotNil(t, e.StartTime, "private events must still show as busy")
+				} else {
+					assert.Equal(t, "HR talk", e.Summary, e.Id)
+					assert.Equal(t, "salary", e.Description, e.Id)
+				}
+			}
+		})
+	}
+}
+
+func Test_VisibilityFromHeader(t *testing.T) {
+	header := make(map[string][]string)
+
+	v, err := visibilityFromHeader(header)
+	require.NoError(t, err)
+	assert.Empty(t, v)
+
+	for value, expected := range map[string]string{
+		"default":      "",
+		"Private":      repo.VisibilityPrivate,
+		"public":       repo.VisibilityPublic,
+		"confidential": repo.VisibilityConfidential,
+	} {
+		header[eventVisibilityHeader] = []string{value}
+
+		v, err := visibilityFromHeader(header)
+		require.NoError(t, err, value)
+		assert.Equal(t, expected, v, value)
+	}
+
+	header[eventVisibilityHeader] = []string{"secret"}
+
+	_, err = visibilityFromHeader(header)
+	assert.Equal(t, connect.CodeInvalidArgument, connect.CodeOf(err))
+}
+
+func Test_ICS_Visibility(t *testing.T) {
+	start := time.Date(2024, time.June, 3, 8, 0, 0, 0, time.UTC)
+
+	var buf bytes.Buffer
+	require.NoError(t, writeICS(&buf, repo.Calendar{Name: "HR"}, []repo.Event{
+		{ID: "1", Summary: "Team meeting", StartTime: start},
+		{ID: "2", Summary: "HR talk", StartTime: start, Visibility: repo.VisibilityPrivate},
+	}, start))
+
+	assert.Contains(t, buf.String(), "CLASS:PRIVATE\r\n")
+
+	events, err := parseICS(&buf)
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+
+	assert.Empty(t, events[0].Visibility)
+	assert.Equal(t, repo.VisibilityPrivate, events[1].Visibility)
+	assert.True(t, events[1].IsPrivate())
+
+	// unknown classifications are treated as private
+	events, err = parseICS(bytes.NewBufferString("BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nUID:x\r\nDTSTART:20240603T080000Z\r\nCLASS:X-HR-ONLY\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n"))
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.True(t, events[0].IsPrivate())
+}
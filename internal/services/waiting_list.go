@@ -0,0 +1,657 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bufbuild/connect-go"
+	calendarv1 "github.com/tierklinik-dobersberg/apis/gen/go/tkd/calendar/v1"
+	eventsv1 "github.com/tierklinik-dobersberg/apis/gen/go/tkd/events/v1"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/config"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/repo"
+	"golang.org/x/exp/maps"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// waitingListPublishTimeout is the maximum time publishing a free slot to
+// the events service may take.
+const waitingListPublishTimeout = 10 * time.Second
+
+// waitingListEntry is a customer that wants an earlier appointment of
+// Duration between Earliest and Latest. Entries with an empty CalendarID
+// and UserID are satisfied by the calendars of all users.
+type waitingListEntry struct {
+	ID             string          `json:"id"`
+	CustomerSource string          `json:"customerSource,omitempty"`
+	CustomerID     string          `json:"customerId"`
+	AnimalIDs      []string        `json:"animalIds,omitempty"`
+	CalendarID     string          `json:"calendarId,omitempty"`
+	UserID         string          `json:"userId,omitempty"`
+	Earliest       time.Time       `json:"earliest"`
+	Latest         time.Time       `json:"latest"`
+	Duration       config.Duration `json:"duration"`
+	Priority       int             `json:"priority"`
+	CreatedBy      string          `json:"createdBy,omitempty"`
+	CreatedAt      time.Time       `json:"createdAt"`
+}
+
+// expired reports whether the entry can no longer be satisfied at now.
+func (e waitingListEntry) expired(now time.Time) bool {
+	return now.Add(e.Duration.AsDuration()).After(e.Latest)
+}
+
+// waitingListWindow is a free slot of a calendar clipped to the acceptable
+// time of an entry. SlotID is the id of the unclipped free slot.
+type waitingListWindow struct {
+	SlotID     string
+	CalendarID string
+	Start, End time.Time
+}
+
+// covers reports whether w contains other.
+func (w waitingListWindow) covers(other waitingListWindow) bool {
+	return w.CalendarID == other.CalendarID && !w.Start.After(other.Start) && !w.End.Before(other.End)
+}
+
+// WaitingList keeps customers that want an earlier appointment and
+// notifies about free slots that satisfy them. Entries are persisted to the
+// configured store file and removed once their latest acceptable time has
+// passed.
+//
+// Changes to events re-match the entries of the affected calendars once no
+// further changes arrived for the debounce period. A free slot is only
+// reported if it was not free during the previous match of an entry, like
+// after an event has been deleted or moved away, and only to the entry with
+// the highest priority. Slots are never booked automatically.
+//
+// The API module does not have a SlotAvailable message yet so free slots
+// are published to the events service as calendarv1.CalendarEvent with the
+// free-slot id, calendar and time of the slot and the waiting list entry as
+// a structpb.Struct in ExtraData.
+type WaitingList struct {
+	storeFile string
+	debounce  time.Duration
+	overlays  []config.Overlay
+
+	// calendars returns the calendars that may satisfy an entry.
+	calendars func(ctx context.Context, entry waitingListEntry) ([]string, error)
+
+	// list returns the events and free slots of calendars between from
+	// and to.
+	list func(ctx context.Context, calendars []string, from, to time.Time) ([]*calendarv1.CalendarEvent, error)
+
+	// publish sends a free slot to the events service.
+	publish func(ctx context.Context, slot *calendarv1.CalendarEvent) error
+
+	ctx context.Context
+	now func() time.Time
+
+	// match serializes match runs.
+	match sync.Mutex
+
+	l       sync.Mutex
+	entries map[string]waitingListEntry
+	// known holds the free windows of an entry as of its last match.
+	known   map[string][]waitingListWindow
+	pending map[string]struct{}
+	timer   *time.Timer
+}
+
+// NewWaitingList returns a new waiting list for the calendars of svc and
+// loads the entries from the store file, if any. Free slots are published
+// using the events service of svc.
+func NewWaitingList(ctx context.Context, svc *CalendarService) (*WaitingList, error) {
+	cfg := svc.repo.Config
+
+	w := &WaitingList{
+		storeFile: cfg.WaitingList.StoreFile,
+		debounce:  cfg.WaitingList.Debounce.AsDuration(),
+		overlays:  cfg.Overlays,
+		calendars: svc.waitingListCalendars,
+		list:      svc.listBookingEvents,
+		ctx:       repo.WithQuotaCaller(ctx, "waiting-list"),
+		now:       time.Now,
+		entries:   make(map[string]waitingListEntry),
+		known:     make(map[string][]waitingListWindow),
+	}
+
+	w.publish = func(ctx context.Context, slot *calendarv1.CalendarEvent) error {
+		if svc.repo.Events == nil {
+			return nil
+		}
+
+		pb, err := anypb.New(slot)
+		if err != nil {
+			return err
+		}
+
+		ctx, cancel := context.WithTimeout(ctx, waitingListPublishTimeout)
+		defer cancel()
+
+		_, err = svc.repo.Events.Publish(ctx, connect.NewRequest(&eventsv1.Event{
+			Event: pb,
+		}))
+
+		return err
+	}
+
+	if w.storeFile == "" {
+		return w, nil
+	}
+
+	blob, err := os.ReadFile(w.storeFile)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return w, nil
+		}
+
+		return nil, fmt.Errorf("failed to read waiting list: %w", err)
+	}
+
+	var entries []waitingListEntry
+	if err := json.Unmarshal(blob, &entries); err != nil {
+		return nil, fmt.Errorf("failed to decode waiting list from %s: %w", w.storeFile, err)
+	}
+
+	for _, e := range entries {
+		w.entries[e.ID] = e
+	}
+
+	return w, nil
+}
+
+// waitingListCalendars returns the calendar of entry, the calendar of its
+// user or the calendars of all users.
+func (svc *CalendarService) waitingListCalendars(ctx context.Context, entry waitingListEntry) ([]string, error) {
+	switch {
+	case entry.CalendarID != "":
+		if _, ok := svc.calendarById.Get(entry.CalendarID); !ok {
+			return nil, connect.NewError(connect.CodeNotFound, fmt.Errorf("calendar %q not found", entry.CalendarID))
+		}
+
+		return []string{entry.CalendarID}, nil
+
+	case entry.UserID != "":
+		profile, err := svc.lookupUser(ctx, entry.UserID)
+		if err != nil {
+			return nil, err
+		}
+
+		calID := extractCalendarId(ctx, profile)
+		if calID == "" {
+			return nil, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("user %q does not have a calendar", entry.UserID))
+		}
+
+		return []string{calID}, nil
+
+	default:
+		return svc.userCalendarIds(), nil
+	}
+}
+
+// Start matches all entries in the background to learn the slots that are
+// free already.
+func (w *WaitingList) Start(ctx context.Context) {
+	ctx = repo.WithQuotaCaller(ctx, "waiting-list")
+
+	go w.run(ctx, func(waitingListEntry, []string) bool {
+		return true
+	})
+}
+
+// save writes all entries to the store file. The caller must hold w.l.
+func (w *WaitingList) save() error {
+	if w.storeFile == "" {
+		return nil
+	}
+
+	blob, err := json.MarshalIndent(w.listLocked(), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// write to a temporary file first so a crash does not leave a
+	// truncated store behind.
+	tmp, err := os.CreateTemp(filepath.Dir(w.storeFile), ".waiting-list-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(blob); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), w.storeFile)
+}
+
+// listLocked returns all entries ordered by priority, highest first, and
+// creation time. The caller must hold w.l.
+func (w *WaitingList) listLocked() []waitingListEntry {
+	entries := maps.Values(w.entries)
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Priority != entries[j].Priority {
+			return entries[i].Priority > entries[j].Priority
+		}
+
+		if !entries[i].CreatedAt.Equal(entries[j].CreatedAt) {
+			return entries[i].CreatedAt.Before(entries[j].CreatedAt)
+		}
+
+		return entries[i].ID < entries[j].ID
+	})
+
+	return entries
+}
+
+// expireLocked removes all entries that can no longer be satisfied. The
+// caller must hold w.l.
+func (w *WaitingList) expireLocked() {
+	now := w.now()
+
+	var expired []string
+	for id, e := range w.entries {
+		if e.expired(now) {
+			expired = append(expired, id)
+		}
+	}
+
+	if len(expired) == 0 {
+		return
+	}
+
+	for _, id := range expired {
+		slog.Info("waiting list entry expired", "id", id, "latest", w.entries[id].Latest)
+
+		delete(w.entries, id)
+		delete(w.known, id)
+	}
+
+	// expired entries are never returned so they are dropped with the
+	// next successful save.
+	if err := w.save(); err != nil {
+		slog.Error("failed to store waiting list after expiring entries", "error", err)
+	}
+}
+
+// entriesSnapshot returns all entries that have not yet expired ordered by
+// priority.
+func (w *WaitingList) entriesSnapshot() []waitingListEntry {
+	w.l.Lock()
+	defer w.l.Unlock()
+
+	w.expireLocked()
+
+	return w.listLocked()
+}
+
+// add validates and stores a new entry. The free slots of the entry are
+// matched right away so only slots freed afterwards are reported.
+func (w *WaitingList) add(ctx context.Context, entry waitingListEntry) (waitingListEntry, error) {
+	switch {
+	case entry.CustomerID == "":
+		return entry, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("missing value for customerId"))
+	case entry.CalendarID != "" && entry.UserID != "":
+		return entry, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("only one of calendarId or userId may be set"))
+	case entry.Duration <= 0:
+		return entry, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("invalid value for duration: must be positive"))
+	case entry.Earliest.Add(entry.Duration.AsDuration()).After(entry.Latest):
+		return entry, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("invalid value for latest: the duration does not fit between earliest and latest"))
+	case entry.expired(w.now()):
+		return entry, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("invalid value for latest: must be in the future"))
+	}
+
+	if _, err := w.calendars(ctx, entry); err != nil {
+		return entry, err
+	}
+
+	var err error
+	if entry.ID, err = randomID(8); err != nil {
+		return entry, err
+	}
+
+	entry.CreatedAt = w.now()
+
+	w.l.Lock()
+
+	w.entries[entry.ID] = entry
+
+	if err := w.save(); err != nil {
+		delete(w.entries, entry.ID)
+		w.l.Unlock()
+
+		return entry, fmt.Errorf("failed to store waiting list entry: %w", err)
+	}
+
+	w.l.Unlock()
+
+	slog.Info("added waiting list entry", "id", entry.ID, "customer", entry.CustomerID, "user", entry.CreatedBy)
+
+	w.run(ctx, func(e waitingListEntry, _ []string) bool {
+		return e.ID == entry.ID
+	})
+
+	return entry, nil
+}
+
+// remove deletes the entry with id. It reports whether the entry existed.
+func (w *WaitingList) remove(id string) (bool, error) {
+	w.l.Lock()
+	defer w.l.Unlock()
+
+	entry, ok := w.entries[id]
+	if !ok {
+		return false, nil
+	}
+
+	delete(w.entries, id)
+
+	if err := w.save(); err != nil {
+		w.entries[id] = entry
+
+		return true, fmt.Errorf("failed to store waiting list: %w", err)
+	}
+
+	delete(w.known, id)
+
+	return true, nil
+}
+
+// Notify schedules a match of the entries that may be satisfied by the
+// calendar of change. Creating an event never frees a slot but cannot be
+// told apart from moving one. It implements repo.ChangeListener and does not
+// block.
+func (w *WaitingList) Notify(change *calendarv1.CalendarChangeEvent) {
+	switch change.Kind.(type) {
+	case *calendarv1.CalendarChangeEvent_DeletedEventId, *calendarv1.CalendarChangeEvent_EventChange:
+	default:
+		return
+	}
+
+	w.l.Lock()
+	defer w.l.Unlock()
+
+	if len(w.entries) == 0 {
+		return
+	}
+
+	if w.pending == nil {
+		w.pending = make(map[string]struct{})
+	}
+
+	w.pending[change.Calendar] = struct{}{}
+
+	// events of overlay sources block time in their target calendars.
+	for _, o := range w.overlays {
+		if o.Source == change.Calendar {
+			w.pending[o.Target] = struct{}{}
+		}
+	}
+
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+
+	w.timer = time.AfterFunc(w.debounce, w.flush)
+}
+
+// flush matches the entries of all calendars changed since the last flush.
+func (w *WaitingList) flush() {
+	w.l.Lock()
+	changed := maps.Keys(w.pending)
+	w.pending = nil
+	w.timer = nil
+	w.l.Unlock()
+
+	if len(changed) == 0 {
+		return
+	}
+
+	w.run(w.ctx, func(_ waitingListEntry, calendars []string) bool {
+		return slices.ContainsFunc(calendars, func(calID string) bool {
+			return slices.Contains(changed, calID)
+		})
+	})
+}
+
+// run matches all entries for which affected returns true, ordered by
+// priority, and publishes the newly freed slots. Each slot is only
+// published for the first entry it satisfies. The first match of an entry
+// only learns the slots that are free already.
+func (w *WaitingList) run(ctx context.Context, affected func(entry waitingListEntry, calendars []string) bool) {
+	w.match.Lock()
+	defer w.match.Unlock()
+
+	offered := make(map[string]struct{})
+
+	for _, entry := range w.entriesSnapshot() {
+		calendars, err := w.calendars(ctx, entry)
+		if err != nil {
+			slog.Error("failed to get the calendars of waiting list entry", "id", entry.ID, "error", err)
+			continue
+		}
+
+		if len(calendars) == 0 || !affected(entry, calendars) {
+			continue
+		}
+
+		windows, err := w.windows(ctx, entry, calendars)
+		if err != nil {
+			slog.Error("failed to load free slots for waiting list entry", "id", entry.ID, "error", err)
+			continue
+		}
+
+		w.l.Lock()
+		known, matched := w.known[entry.ID]
+		w.l.Unlock()
+
+		current := windows[:0:0]
+		for _, win := range windows {
+			_, taken := offered[win.SlotID]
+
+			if !matched || taken || slices.ContainsFunc(known, func(k waitingListWindow) bool { return k.covers(win) }) {
+				current = append(current, win)
+				continue
+			}
+
+			if err := w.publish(ctx, w.slotEvent(entry, win)); err != nil {
+				// not remembered so it is published with the next match
+				slog.Error("failed to publish free slot for waiting list entry", "id", entry.ID, "calendar", win.CalendarID, "start", win.Start, "error", err)
+				continue
+			}
+
+			slog.Info("free slot matches waiting list entry", "id", entry.ID, "calendar", win.CalendarID, "start", win.Start, "end", win.End)
+
+			offered[win.SlotID] = struct{}{}
+			current = append(current, win)
+		}
+
+		w.l.Lock()
+		// the entry might have been removed in the meantime.
+		if _, ok := w.entries[entry.ID]; ok {
+			w.known[entry.ID] = current
+		}
+		w.l.Unlock()
+	}
+}
+
+// windows returns the free slots of calendars that are long enough for
+// entry, clipped to the acceptable time of entry.
+func (w *WaitingList) windows(ctx context.Context, entry waitingListEntry, calendars []string) ([]waitingListWindow, error) {
+	from := entry.Earliest
+	if now := w.now(); now.After(from) {
+		from = now
+	}
+
+	events, err := w.list(ctx, calendars, from, entry.Latest)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []waitingListWindow
+	for _, evt := range events {
+		if !strings.HasPrefix(evt.Id, freeSlotIDPrefix) || evt.GetEndTime() == nil {
+			continue
+		}
+
+		win := waitingListWindow{
+			SlotID:     evt.Id,
+			CalendarID: evt.CalendarId,
+			Start:      evt.StartTime.AsTime(),
+			End:        evt.EndTime.AsTime(),
+		}
+
+		if win.Start.Before(from) {
+			win.Start = from
+		}
+
+		if win.End.After(entry.Latest) {
+			win.End = entry.Latest
+		}
+
+		if win.End.Sub(win.Start) >= entry.Duration.AsDuration() {
+			result = append(result, win)
+		}
+	}
+
+	return result, nil
+}
+
+// slotEvent returns the event published for a free slot that satisfies
+// entry.
+func (w *WaitingList) slotEvent(entry waitingListEntry, win waitingListWindow) *calendarv1.CalendarEvent {
+	animals := make([]any, len(entry.AnimalIDs))
+	for idx, id := range entry.AnimalIDs {
+		animals[idx] = id
+	}
+
+	evt := &calendarv1.CalendarEvent{
+		Id:         win.SlotID,
+		CalendarId: win.CalendarID,
+		StartTime:  timestamppb.New(win.Start),
+		EndTime:    timestamppb.New(win.End),
+	}
+
+	extra, err := structpb.NewStruct(map[string]any{
+		"waitingListEntryId": entry.ID,
+		"customerSource":     entry.CustomerSource,
+		"customerId":         entry.CustomerID,
+		"animalIds":          animals,
+		"duration":           entry.Duration.AsDuration().String(),
+		"priority":           entry.Priority,
+	})
+	if err != nil {
+		slog.Error("failed to encode waiting list entry", "id", entry.ID, "error", err)
+		return evt
+	}
+
+	if evt.ExtraData, err = anypb.New(extra); err != nil {
+		slog.Error("failed to encode waiting list entry", "id", entry.ID, "error", err)
+	}
+
+	return evt
+}
+
+// WaitingListHandler manages the waiting list:
+//
+//	GET    /waiting-list
+//	POST   /waiting-list {"customerId": "<id>", "userId": "<user-id>", "earliest": "...", "latest": "...", "duration": "30m", "priority": 1}
+//
+//	DELETE /waiting-list?id=<id>
+//
+// Entries without calendarId and userId are satisfied by the calendars of
+// all users. Only callers with one of the allowed roles (X-Remote-Role) may
+// manage the waiting list.
+type WaitingListHandler struct {
+	list         *WaitingList
+	allowedRoles []string
+}
+
+// NewWaitingListHandler returns a new handler for list.
+func NewWaitingListHandler(list *WaitingList, allowedRoles []string) *WaitingListHandler {
+	return &WaitingListHandler{
+		list:         list,
+		allowedRoles: allowedRoles,
+	}
+}
+
+func (h *WaitingListHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !authorize(w, r, h.allowedRoles) {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		writeWaitingListJSON(w, http.StatusOK, h.list.entriesSnapshot())
+
+	case http.MethodPost:
+		var body waitingListEntry
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		body.CreatedBy = r.Header.Get("X-Remote-User-ID")
+
+		entry, err := h.list.add(r.Context(), body)
+		if err != nil {
+			http.Error(w, err.Error(), httpStatus(err))
+			return
+		}
+
+		writeWaitingListJSON(w, http.StatusCreated, entry)
+
+	case http.MethodDelete:
+		id := r.URL.Query().Get("id")
+		if id == "" {
+			http.Error(w, "missing value for id", http.StatusBadRequest)
+			return
+		}
+
+		found, err := h.list.remove(id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if !found {
+			http.Error(w, "waiting list entry not found", http.StatusNotFound)
+			return
+		}
+
+		slog.Info("removed waiting list entry", "id", id, "user", r.Header.Get("X-Remote-User-ID"))
+
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func writeWaitingListJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("failed to encode waiting list response", "error", err)
+	}
+}
@@ -0,0 +1,261 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	calendarv1 "github.com/tierklinik-dobersberg/apis/gen/go/tkd/calendar/v1"
+	eventsv1 "github.com/tierklinik-dobersberg/apis/gen/go/tkd/events/v1"
+	"github.com/tierklinik-dobersberg/apis/gen/go/tkd/events/v1/eventsv1connect"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/config"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// recordingEvents is an events service client that records all published
+// events.
+type recordingEvents struct {
+	eventsv1connect.EventServiceClient
+
+	l      sync.Mutex
+	events []*eventsv1.Event
+}
+
+func (r *recordingEvents) Publish(_ context.Context, req *connect.Request[eventsv1.Event]) (*connect.Response[emptypb.Empty], error) {
+	r.l.Lock()
+	defer r.l.Unlock()
+
+	r.events = append(r.events, req.Msg)
+
+	return connect.NewResponse(new(emptypb.Empty)), nil
+}
+
+func (r *recordingEvents) published() []*eventsv1.Event {
+	r.l.Lock()
+	defer r.l.Unlock()
+
+	return append([]*eventsv1.Event(nil), r.events...)
+}
+
+// newWaitingListTest returns a waiting list for vet-1 and vet-2 at
+// 2024-06-03 07:00 UTC. vet-1 is free between 08:00 and 09:00.
+func newWaitingListTest(t *testing.T) (*WaitingList, *fakeBookingEvents, *recordingEvents, *time.Time) {
+	t.Helper()
+
+	svc, _ := newBookingTestService(t)
+
+	events := &recordingEvents{}
+	svc.repo.Events = events
+	svc.repo.Config.WaitingList = config.WaitingList{
+		StoreFile: filepath.Join(t.TempDir(), "waiting-list.json"),
+		Debounce:  config.Duration(10 * time.Millisecond),
+	}
+
+	w, err := NewWaitingList(context.Background(), svc)
+	require.NoError(t, err)
+
+	now := time.Date(2024, time.June, 3, 7, 0, 0, 0, time.UTC)
+	w.now = func() time.Time {
+		return now
+	}
+
+	fake := &fakeBookingEvents{}
+	fake.set(
+		waitingListEvent(freeSlotIDPrefix+"1", 8, 9),
+		waitingListEvent("evt-1", 9, 10),
+	)
+	w.list = fake.list
+
+	return w, fake, events, &now
+}
+
+// waitingListEvent returns an event of vet-1 on 2024-06-03 between the
+// given hours in UTC.
+func waitingListEvent(id string, start, end int) *calendarv1.CalendarEvent {
+	return &calendarv1.CalendarEvent{
+		Id:         id,
+		CalendarId: "vet-1",
+		StartTime:  timestamppb.New(time.Date(2024, time.June, 3, start, 0, 0, 0, time.UTC)),
+		EndTime:    timestamppb.New(time.Date(2024, time.June, 3, end, 0, 0, 0, time.UTC)),
+	}
+}
+
+// waitForMatch waits for a running match of w to complete.
+func waitForMatch(w *WaitingList) {
+	w.match.Lock()
+	defer w.match.Unlock()
+}
+
+func waitingListTestEntry(customer string, priority int) waitingListEntry {
+	return waitingListEntry{
+		CustomerSource: "vetinf",
+		CustomerID:     customer,
+		CalendarID:     "vet-1",
+		Earliest:       time.Date(2024, time.June, 3, 8, 0, 0, 0, time.UTC),
+		Latest:         time.Date(2024, time.June, 3, 12, 0, 0, 0, time.UTC),
+		Duration:       config.Duration(90 * time.Minute),
+		Priority:       priority,
+	}
+}
+
+func Test_WaitingList_Matching(t *testing.T) {
+	w, fake, events, _ := newWaitingListTest(t)
+
+	low, err := w.add(context.Background(), waitingListTestEntry("huber", 1))
+	require.NoError(t, err)
+
+	high, err := w.add(context.Background(), waitingListTestEntry("maier", 5))
+	require.NoError(t, err)
+
+	// slots that are free already are not reported
+	assert.Empty(t, events.published())
+
+	// evt-1 has been deleted and freed 09:00 - 10:00
+	fake.set(waitingListEvent(freeSlotIDPrefix+"2", 8, 10))
+
+	// changes of other calendars do not affect the entries
+	w.Notify(&calendarv1.CalendarChangeEvent{
+		Calendar: "vet-2",
+		Kind:     &calendarv1.CalendarChangeEvent_DeletedEventId{DeletedEventId: "evt-2"},
+	})
+	w.Notify(&calendarv1.CalendarChangeEvent{
+		Calendar: "vet-1",
+		Kind:     &calendarv1.CalendarChangeEvent_DeletedEventId{DeletedEventId: "evt-1"},
+	})
+
+	require.Eventually(t, func() bool {
+		return len(events.published()) > 0
+	}, time.Second, 5*time.Millisecond)
+
+	waitForMatch(w)
+
+	// the slot is only offered to the entry with the highest priority
+	published := events.published()
+	require.Len(t, published, 1)
+
+	var slot calendarv1.CalendarEvent
+	require.NoError(t, published[0].Event.UnmarshalTo(&slot))
+	assert.Equal(t, freeSlotIDPrefix+"2", slot.Id)
+	assert.Equal(t, "vet-1", slot.CalendarId)
+	assert.Equal(t, time.Date(2024, time.June, 3, 10, 0, 0, 0, time.UTC), slot.EndTime.AsTime())
+
+	var extra structpb.Struct
+	require.NoError(t, slot.ExtraData.UnmarshalTo(&extra))
+	assert.Equal(t, high.ID, extra.Fields["waitingListEntryId"].GetStringValue())
+	assert.Equal(t, "maier", extra.Fields["customerId"].GetStringValue())
+
+	// the same slot is not reported again
+	w.run(context.Background(), func(waitingListEntry, []string) bool {
+		return true
+	})
+	assert.Len(t, events.published(), 1)
+
+	// entries are ordered by priority
+	entries := w.entriesSnapshot()
+	require.Len(t, entries, 2)
+	assert.Equal(t, high.ID, entries[0].ID)
+	assert.Equal(t, low.ID, entries[1].ID)
+}
+
+func Test_WaitingList_Store(t *testing.T) {
+	w, _, _, now := newWaitingListTest(t)
+
+	entry, err := w.add(context.Background(), waitingListTestEntry("huber", 1))
+	require.NoError(t, err)
+
+	// entries are persisted
+	reload := func() *WaitingList {
+		svc, _ := newBookingTestService(t)
+		svc.repo.Config.WaitingList.StoreFile = w.storeFile
+
+		reloaded, err := NewWaitingList(context.Background(), svc)
+		require.NoError(t, err)
+
+		return reloaded
+	}
+
+	reloaded := reload()
+	require.Contains(t, reloaded.entries, entry.ID)
+	assert.Equal(t, "huber", reloaded.entries[entry.ID].CustomerID)
+	assert.Equal(t, 90*time.Minute, reloaded.entries[entry.ID].Duration.AsDuration())
+
+	// entries expire once the duration no longer fits before latest
+	*now = time.Date(2024, time.June, 3, 10, 31, 0, 0, time.UTC)
+	assert.Empty(t, w.entriesSnapshot())
+	assert.Empty(t, reload().entries)
+
+	_, err = w.add(context.Background(), waitingListTestEntry("maier", 1))
+	assert.Equal(t, connect.CodeInvalidArgument, connect.CodeOf(err))
+}
+
+func Test_WaitingListHandler(t *testing.T) {
+	w, _, _, _ := newWaitingListTest(t)
+
+	h := NewWaitingListHandler(w, []string{"reception"})
+
+	send := func(method, target, body string, roles ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("X-Remote-User-ID", "alice")
+		for _, r := range roles {
+			req.Header.Add("X-Remote-Role", r)
+		}
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		return rec
+	}
+
+	body := `{"customerId": "huber", "calendarId": "vet-1", "earliest": "2024-06-03T08:00:00Z", "latest": "2024-06-03T12:00:00Z", "duration": "30m"}`
+
+	rec := send(http.MethodPost, "/waiting-list", body)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	rec = send(http.MethodPost, "/waiting-list", body, "reception")
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+
+	var created waitingListEntry
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&created))
+	assert.NotEmpty(t, created.ID)
+	assert.Equal(t, "alice", created.CreatedBy)
+
+	cases := []struct {
+		body string
+		code int
+	}{
+		{`{"calendarId": "vet-1", "latest": "2024-06-03T12:00:00Z", "duration": "30m"}`, http.StatusBadRequest},
+		{`{"customerId": "huber", "calendarId": "vet-3", "latest": "2024-06-03T12:00:00Z", "duration": "30m"}`, http.StatusNotFound},
+		{`{"customerId": "huber", "calendarId": "vet-1", "userId": "alice", "latest": "2024-06-03T12:00:00Z", "duration": "30m"}`, http.StatusBadRequest},
+		{`{"customerId": "huber", "calendarId": "vet-1", "earliest": "2024-06-03T11:45:00Z", "latest": "2024-06-03T12:00:00Z", "duration": "30m"}`, http.StatusBadRequest},
+	}
+
+	for _, c := range cases {
+		rec = send(http.MethodPost, "/waiting-list", c.body, "reception")
+		assert.Equal(t, c.code, rec.Code, c.body)
+	}
+
+	rec = send(http.MethodGet, "/waiting-list", "", "reception")
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var list []waitingListEntry
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&list))
+	require.Len(t, list, 1)
+	assert.Equal(t, created.ID, list[0].ID)
+
+	rec = send(http.MethodDelete, "/waiting-list?id="+created.ID, "", "reception")
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+
+	rec = send(http.MethodDelete, "/waiting-list?id="+created.ID, "", "reception")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
@@ -0,0 +1,224 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tierklinik-dobersberg/cis-cal/internal/i18n"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/repo"
+)
+
+// waitingEntry is an arrived customer in the waiting room.
+type waitingEntry struct {
+	CalendarID  string    `json:"calendarId"`
+	EventID     string    `json:"eventId"`
+	Summary     string    `json:"summary"`
+	Start       time.Time `json:"start"`
+	ArrivedAt   time.Time `json:"arrivedAt"`
+	WaitMinutes int       `json:"waitMinutes"`
+	CustomerID  string    `json:"customerId,omitempty"`
+}
+
+// waitingRoom lists the arrived customers ordered by their arrival and the
+// number of customers waiting per calendar.
+type waitingRoom struct {
+	Entries []waitingEntry `json:"entries"`
+	Counts  map[string]int `json:"counts"`
+}
+
+// versionedLister is an eventLister that reports calendar versions.
+type versionedLister interface {
+	eventLister
+	CalendarVersion(ctx context.Context, calendarID string) (int64, error)
+}
+
+// arrivalTime returns the time the customer of e arrived. Events without a
+// status time, like those marked by other tools, use the start time.
+func arrivalTime(e repo.Event) time.Time {
+	if e.StatusChangedAt.IsZero() {
+		return e.StartTime
+	}
+
+	return e.StatusChangedAt
+}
+
+// buildWaitingRoom returns the arrived events of the day of now ordered by
+// their arrival. Events that started on another day are ignored even if
+// they are still marked as arrived.
+func buildWaitingRoom(events map[string][]repo.Event, calendars []string, now time.Time) waitingRoom {
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	tomorrow := midnight.AddDate(0, 0, 1)
+
+	res := waitingRoom{
+		Entries: []waitingEntry{},
+		Counts:  make(map[string]int, len(calendars)),
+	}
+
+	for _, calID := range calendars {
+		res.Counts[calID] = 0
+
+		for _, e := range events[calID] {
+			if e.CurrentStatus() != repo.StatusArrived || e.StartTime.Before(midnight) || !e.StartTime.Before(tomorrow) {
+				continue
+			}
+
+			arrived := arrivalTime(e)
+
+			wait := now.Sub(arrived)
+			if wait < 0 {
+				wait = 0
+			}
+
+			entry := waitingEntry{
+				CalendarID:  calID,
+				EventID:     e.ID,
+				Summary:     e.Summary,
+				Start:       e.StartTime,
+				ArrivedAt:   arrived,
+				WaitMinutes: int(wait / time.Minute),
+			}
+
+			if e.Data != nil {
+				entry.CustomerID = e.Data.CustomerID
+			}
+
+			res.Entries = append(res.Entries, entry)
+			res.Counts[calID]++
+		}
+	}
+
+	sort.SliceStable(res.Entries, func(i, j int) bool {
+		a, b := res.Entries[i], res.Entries[j]
+
+		if !a.ArrivedAt.Equal(b.ArrivedAt) {
+			return a.ArrivedAt.Before(b.ArrivedAt)
+		}
+
+		return a.Start.Before(b.Start)
+	})
+
+	return res
+}
+
+// WaitingRoomHandler serves the customers that arrived today but have not
+// been called in yet:
+//
+//	GET /waiting-room?calendar=<id>&user=<id>
+//
+// Without calendar or user parameters all calendars assigned to users are
+// returned. Responses carry an ETag derived from the calendar versions and
+// the current minute so screens that poll every few seconds receive
+// 304 Not Modified until a status changes or the wait durations do.
+type WaitingRoomHandler struct {
+	events       versionedLister
+	calendars    func() []string
+	resolve      func(ctx context.Context, userID string) (string, error)
+	allowedRoles []string
+	redactor     eventRedactor
+	language     string
+
+	now func() time.Time
+}
+
+// NewWaitingRoomHandler returns a new waiting room handler for svc.
+func NewWaitingRoomHandler(svc *CalendarService) *WaitingRoomHandler {
+	return &WaitingRoomHandler{
+		events:       svc.repo,
+		calendars:    svc.userCalendarIds,
+		resolve:      svc.resolveUserCalendar,
+		allowedRoles: svc.repo.Config.WaitingRoom.AllowedRoles,
+		redactor:     svc.redactor(),
+		language:     svc.repo.Config.DefaultLanguage,
+		now:          time.Now,
+	}
+}
+
+// etag returns the etag of the waiting room of calendars at now as seen by
+// userID in lang. It reports false if a calendar version is unknown.
+func (h *WaitingRoomHandler) etag(ctx context.Context, calendars []string, now time.Time, userID string, lang i18n.Language) (string, bool) {
+	hash := sha256.New()
+
+	// wait durations are in whole minutes
+	hash.Write([]byte(now.Truncate(time.Minute).Format(time.RFC3339)))
+
+	// private events are only shown to the owner of their calendar
+	hash.Write([]byte("\nuser:" + userID + "\nlang:" + string(lang)))
+
+	for _, calID := range calendars {
+		version, err := h.events.CalendarVersion(ctx, calID)
+		if err != nil {
+			return "", false
+		}
+
+		hash.Write([]byte("\n" + calID + ":" + strconv.FormatInt(version, 10)))
+	}
+
+	return `"` + hex.EncodeToString(hash.Sum(nil))[:32] + `"`, true
+}
+
+func (h *WaitingRoomHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !authorize(w, r, h.allowedRoles) {
+		return
+	}
+
+	calendars, err := queryCalendars(r.Context(), r.URL.Query(), h.resolve, h.calendars)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	// the event caches start at local midnight
+	now := h.now().Local()
+
+	userID := r.Header.Get("X-Remote-User-ID")
+	lang := requestLanguage(r, h.language)
+
+	etag, ok := h.etag(r.Context(), calendars, now, userID, lang)
+	if ok {
+		w.Header().Set("ETag", etag)
+
+		for _, v := range strings.Split(r.Header.Get("If-None-Match"), ",") {
+			if strings.TrimSpace(v) == etag {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		}
+	}
+
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+
+	events := make(map[string][]repo.Event, len(calendars))
+	for _, calID := range calendars {
+		list, err := h.events.ListEvents(r.Context(), calID,
+			repo.WithEventsAfter(midnight),
+			repo.WithEventsBefore(midnight.AddDate(0, 0, 1)),
+			repo.WithStatus(repo.StatusArrived),
+		)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("failed to load events of calendar %q: %s", calID, err), httpStatus(err))
+			return
+		}
+
+		events[calID] = h.redactor.redact(list, userID, lang)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(buildWaitingRoom(events, calendars, now)); err != nil {
+		slog.Error("failed to encode waiting room", "error", err)
+	}
+}
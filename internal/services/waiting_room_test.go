@@ -0,0 +1,206 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/repo"
+)
+
+func waitingIDs(room waitingRoom) []string {
+	ids := []string{}
+	for _, e := range room.Entries {
+		ids = append(ids, e.EventID)
+	}
+
+	return ids
+}
+
+func Test_BuildWaitingRoom_Midnight(t *testing.T) {
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2024, time.June, day, hour, minute, 0, 0, time.UTC)
+	}
+
+	events := map[string][]repo.Event{
+		"vet": {
+			// still marked as arrived from yesterday
+			{ID: "yesterday", StartTime: at(2, 23, 30), Status: repo.StatusArrived, StatusChangedAt: at(2, 23, 25)},
+			{ID: "midnight", StartTime: at(3, 0, 0), Status: repo.StatusArrived, StatusChangedAt: at(3, 0, 2)},
+			// arrived before midnight for an appointment today
+			{ID: "early", StartTime: at(3, 0, 30), Status: repo.StatusArrived, StatusChangedAt: at(2, 23, 58), Data: &repo.StructuredEvent{CustomerID: "1234"}},
+			{ID: "planned", StartTime: at(3, 0, 15)},
+			{ID: "tomorrow", StartTime: at(4, 0, 0), Status: repo.StatusArrived, StatusChangedAt: at(3, 0, 1)},
+		},
+		"surgery": {
+			{ID: "in-progress", StartTime: at(3, 0, 0), Status: repo.StatusInProgress},
+			// marked without a status time
+			{ID: "no-time", StartTime: at(3, 0, 1), Status: repo.StatusArrived},
+		},
+	}
+
+	room := buildWaitingRoom(events, []string{"vet", "surgery", "empty"}, at(3, 0, 5))
+
+	assert.Equal(t, []string{"early", "no-time", "midnight"}, waitingIDs(room))
+	assert.Equal(t, map[string]int{"vet": 2, "surgery": 1, "empty": 0}, room.Counts)
+
+	assert.Equal(t, 7, room.Entries[0].WaitMinutes)
+	assert.Equal(t, "1234", room.Entries[0].CustomerID)
+	assert.Equal(t, at(3, 0, 1), room.Entries[1].ArrivedAt)
+	assert.Equal(t, 4, room.Entries[1].WaitMinutes)
+	assert.Equal(t, 3, room.Entries[2].WaitMinutes)
+
+	// right before midnight only yesterday's event is waiting
+	room = buildWaitingRoom(events, []string{"vet"}, at(2, 23, 59))
+	assert.Equal(t, []string{"yesterday"}, waitingIDs(room))
+}
+
+// waitingRoomRepo is a calendarLister with calendar versions.
+type waitingRoomRepo struct {
+	calendarLister
+
+	versions map[string]int64
+	calls    int
+}
+
+func (w *waitingRoomRepo) ListEvents(ctx context.Context, calID string, opts ...repo.SearchOption) ([]repo.Event, error) {
+	w.calls++
+
+	return w.calendarLister.ListEvents(ctx, calID, opts...)
+}
+
+func (w *waitingRoomRepo) CalendarVersion(ctx context.Context, calID string) (int64, error) {
+	return versionRepo{versions: w.versions}.CalendarVersion(ctx, calID)
+}
+
+func Test_WaitingRoomHandler(t *testing.T) {
+	now := time.Now()
+	start := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+
+	fake := &waitingRoomRepo{
+		calendarLister: calendarLister{
+			"vet": {
+				{ID: "a", StartTime: start, Status: repo.StatusArrived, StatusChangedAt: start},
+			},
+		},
+		versions: map[string]int64{"vet": 1},
+	}
+
+	h := &WaitingRoomHandler{
+		events:       fake,
+		calendars:    func() []string { return []string{"vet"} },
+		allowedRoles: []string{"reception"},
+		now:          func() time.Time { return now },
+	}
+
+	get := func(etag string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/waiting-room", nil)
+		req.Header.Set("X-Remote-User-ID", "alice")
+		req.Header.Set("X-Remote-Role", "reception")
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		return rec
+	}
+
+	rec := get("")
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var room waitingRoom
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&room))
+	assert.Equal(t, []string{"a"}, waitingIDs(room))
+
+	etag := rec.Header().Get("ETag")
+	require.NotEmpty(t, etag)
+
+	// polling without changes does not load events
+	fake.calls = 0
+	rec = get(etag)
+	assert.Equal(t, http.StatusNotModified, rec.Code)
+	assert.Zero(t, fake.calls)
+
+	// status changes bump the calendar version
+	fake.versions["vet"] = 2
+	rec = get(etag)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.NotEqual(t, etag, rec.Header().Get("ETag"))
+
+	// wait durations change every minute
+	etag = rec.Header().Get("ETag")
+	now = now.Add(time.Minute)
+	assert.Equal(t, http.StatusOK, get(etag).Code)
+
+	// unknown versions disable the etag
+	delete(fake.versions, "vet")
+	rec = get(etag)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Empty(t, rec.Header().Get("ETag"))
+
+	// anonymous callers and callers without an allowed role are rejected
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/waiting-room", nil))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	req := httptest.NewRequest(http.MethodGet, "/waiting-room", nil)
+	req.Header.Set("X-Remote-User-ID", "bob")
+	req.Header.Set("X-Remote-Role", "vet")
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+}
+
+func Test_WaitingRoomHandler_Private(t *testing.T) {
+	now := time.Now()
+	start := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+
+	h := &WaitingRoomHandler{
+		events: &waitingRoomRepo{
+			calendarLister: calendarLister{
+				"vet": {
+					{ID: "a", CalendarID: "vet", Summary: "Dr. Who", Visibility: repo.VisibilityPrivate, StartTime: start, Status: repo.StatusArrived, StatusChangedAt: start, Data: &repo.StructuredEvent{CustomerID: "1234"}},
+				},
+			},
+			versions: map[string]int64{"vet": 1},
+		},
+		calendars: func() []string { return []string{"vet"} },
+		redactor:  ownerRedactor(map[string]string{"vet": "alice"}),
+		now:       func() time.Time { return now },
+	}
+
+	get := func(user string) (waitingEntry, string) {
+		req := httptest.NewRequest(http.MethodGet, "/waiting-room", nil)
+		req.Header.Set("X-Remote-User-ID", user)
+		req.Header.Set("X-Remote-Role", "admin")
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		var room waitingRoom
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&room))
+		require.Len(t, room.Entries, 1)
+
+		return room.Entries[0], rec.Header().Get("ETag")
+	}
+
+	owner, ownerTag := get("alice")
+	assert.Equal(t, "Dr. Who", owner.Summary)
+	assert.Equal(t, "1234", owner.CustomerID)
+
+	other, otherTag := get("bob")
+	assert.Equal(t, "Privat", other.Summary)
+	assert.Empty(t, other.CustomerID)
+
+	// the redacted room must not be served from the owner's etag
+	assert.NotEqual(t, ownerTag, otherTag)
+}
@@ -0,0 +1,701 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	calendarv1 "github.com/tierklinik-dobersberg/apis/gen/go/tkd/calendar/v1"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/config"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/i18n"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/repo"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// The kinds of changes webhooks may subscribe to.
+const (
+	webhookKindChanged = "changed"
+	webhookKindDeleted = "deleted"
+)
+
+// The status of a webhook delivery.
+const (
+	webhookStatusPending   = "pending"
+	webhookStatusDelivered = "delivered"
+	webhookStatusFailed    = "failed"
+	webhookStatusDead      = "dead"
+)
+
+const (
+	// maxWebhookBackoff caps the delay between two delivery attempts.
+	maxWebhookBackoff = time.Hour
+	// maxWebhookDeliveries is the number of deliveries that are kept per
+	// subscription.
+	maxWebhookDeliveries = 100
+	// webhookTimeout is the timeout of a single delivery attempt.
+	webhookTimeout = 10 * time.Second
+)
+
+// webhookSubscription sends the changes of the matching calendars to URL.
+// Empty Calendars or Kinds match all calendars or kinds.
+type webhookSubscription struct {
+	ID        string    `json:"id"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"secret,omitempty"`
+	Calendars []string  `json:"calendars,omitempty"`
+	Kinds     []string  `json:"kinds,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+	CreatedBy string    `json:"createdBy,omitempty"`
+}
+
+func (s webhookSubscription) matches(calendarID, kind string) bool {
+	if len(s.Calendars) > 0 && !slices.Contains(s.Calendars, calendarID) {
+		return false
+	}
+
+	return len(s.Kinds) == 0 || slices.Contains(s.Kinds, kind)
+}
+
+// webhookDelivery records the status of a single change sent to a
+// subscription.
+type webhookDelivery struct {
+	ID             string    `json:"id"`
+	SubscriptionID string    `json:"subscriptionId"`
+	Kind           string    `json:"kind"`
+	CalendarID     string    `json:"calendarId"`
+	EventID        string    `json:"eventId"`
+	Status         string    `json:"status"`
+	Attempts       int       `json:"attempts"`
+	LastStatusCode int       `json:"lastStatusCode,omitempty"`
+	LastError      string    `json:"lastError,omitempty"`
+	CreatedAt      time.Time `json:"createdAt"`
+	UpdatedAt      time.Time `json:"updatedAt"`
+}
+
+// webhookPayload is the JSON body sent to subscribers. Event is the
+// protojson encoded calendar event and only set for changed events.
+type webhookPayload struct {
+	ID         string          `json:"id"`
+	Kind       string          `json:"kind"`
+	CalendarID string          `json:"calendarId"`
+	EventID    string          `json:"eventId"`
+	Event      json.RawMessage `json:"event,omitempty"`
+	Timestamp  time.Time       `json:"timestamp"`
+}
+
+// signWebhook returns the X-Webhook-Signature of body sent at timestamp.
+//
+// Receivers verify a delivery by computing the HMAC-SHA256 of the
+// X-Webhook-Timestamp header, a dot and the raw request body using the
+// secret of the subscription, and comparing the hex encoded result to the
+// signature (without the "sha256=" prefix) in constant time. Deliveries with
+// an old timestamp should be rejected to prevent replays.
+func signWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// randomID returns a random hex string of n bytes.
+func randomID(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(buf), nil
+}
+
+// WebhookDispatcher sends the event changes detected by the backends to
+// subscribed external URLs. Deliveries are signed, retried with an
+// exponential backoff and dead-lettered after the configured number of
+// attempts. Deliveries are sent concurrently so subscribers must not rely
+// on their order.
+//
+// Subscriptions are persisted to the configured store file while delivery
+// records are only kept in memory.
+type WebhookDispatcher struct {
+	ctx         context.Context
+	storeFile   string
+	maxAttempts int
+	backoff     time.Duration
+	client      *http.Client
+	now         func() time.Time
+
+	// events is used to look up the visibility of changed events, which
+	// is not part of the change. Changes are sent unredacted without it.
+	events   eventLoader
+	redactor eventRedactor
+	language i18n.Language
+
+	l             sync.Mutex
+	subscriptions map[string]webhookSubscription
+	deliveries    map[string][]*webhookDelivery
+}
+
+// NewWebhookDispatcher returns a new webhook dispatcher and loads the
+// subscriptions from the store file, if any. Pending deliveries are aborted
+// once ctx is cancelled.
+func NewWebhookDispatcher(ctx context.Context, cfg config.Webhooks) (*WebhookDispatcher, error) {
+	d := &WebhookDispatcher{
+		ctx:           ctx,
+		storeFile:     cfg.StoreFile,
+		maxAttempts:   cfg.MaxAttempts,
+		backoff:       cfg.Backoff.AsDuration(),
+		client:        &http.Client{Timeout: webhookTimeout},
+		now:           time.Now,
+		subscriptions: make(map[string]webhookSubscription),
+		deliveries:    make(map[string][]*webhookDelivery),
+	}
+
+	if d.storeFile == "" {
+		return d, nil
+	}
+
+	blob, err := os.ReadFile(d.storeFile)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return d, nil
+		}
+
+		return nil, fmt.Errorf("failed to read webhook subscriptions: %w", err)
+	}
+
+	var subs []webhookSubscription
+	if err := json.Unmarshal(blob, &subs); err != nil {
+		return nil, fmt.Errorf("failed to decode webhook subscriptions from %s: %w", d.storeFile, err)
+	}
+
+	for _, s := range subs {
+		d.subscriptions[s.ID] = s
+	}
+
+	return d, nil
+}
+
+// eventLoader loads a single event.
+type eventLoader interface {
+	LoadEvent(ctx context.Context, calendarID, eventID string, ignoreCache bool) (*repo.Event, error)
+}
+
+// RedactPrivateEvents makes d hide the details of private events from all
+// subscriptions that were not created by the owner of the calendar.
+func (d *WebhookDispatcher) RedactPrivateEvents(svc *CalendarService) {
+	d.events = svc.repo
+	d.redactor = svc.redactor()
+	d.language = i18n.Language(svc.repo.Config.DefaultLanguage)
+}
+
+// save writes all subscriptions to the store file. The caller must hold
+// d.l.
+func (d *WebhookDispatcher) save() error {
+	if d.storeFile == "" {
+		return nil
+	}
+
+	subs := d.listLocked()
+
+	blob, err := json.MarshalIndent(subs, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// write to a temporary file first so a crash does not leave a
+	// truncated store behind.
+	tmp, err := os.CreateTemp(filepath.Dir(d.storeFile), ".webhooks-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(blob); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), d.storeFile)
+}
+
+// listLocked returns all subscriptions sorted by creation time. The caller
+// must hold d.l.
+func (d *WebhookDispatcher) listLocked() []webhookSubscription {
+	subs := make([]webhookSubscription, 0, len(d.subscriptions))
+	for _, s := range d.subscriptions {
+		subs = append(subs, s)
+	}
+
+	sort.Slice(subs, func(i, j int) bool {
+		if !subs[i].CreatedAt.Equal(subs[j].CreatedAt) {
+			return subs[i].CreatedAt.Before(subs[j].CreatedAt)
+		}
+
+		return subs[i].ID < subs[j].ID
+	})
+
+	return subs
+}
+
+// list returns all subscriptions without their secrets.
+func (d *WebhookDispatcher) list() []webhookSubscription {
+	d.l.Lock()
+	defer d.l.Unlock()
+
+	subs := d.listLocked()
+	for idx := range subs {
+		subs[idx].Secret = ""
+	}
+
+	return subs
+}
+
+// add validates and stores a new subscription. A secret is generated if
+// sub does not have one. The returned subscription includes the secret.
+func (d *WebhookDispatcher) add(sub webhookSubscription) (webhookSubscription, error) {
+	u, err := url.Parse(sub.URL)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return sub, fmt.Errorf("invalid value for url %q: an absolute https URL is required", sub.URL)
+	}
+
+	for _, k := range sub.Kinds {
+		if k != webhookKindChanged && k != webhookKindDeleted {
+			return sub, fmt.Errorf("invalid value for kinds: unsupported kind %q", k)
+		}
+	}
+
+	if sub.ID, err = randomID(8); err != nil {
+		return sub, err
+	}
+
+	if sub.Secret == "" {
+		if sub.Secret, err = randomID(32); err != nil {
+			return sub, err
+		}
+	}
+
+	sub.CreatedAt = d.now()
+
+	d.l.Lock()
+	defer d.l.Unlock()
+
+	d.subscriptions[sub.ID] = sub
+
+	if err := d.save(); err != nil {
+		delete(d.subscriptions, sub.ID)
+
+		return sub, fmt.Errorf("failed to store webhook subscription: %w", err)
+	}
+
+	return sub, nil
+}
+
+// remove deletes the subscription with id and its delivery records. Retries
+// of pending deliveries are stopped. It reports whether the subscription
+// existed.
+func (d *WebhookDispatcher) remove(id string) (bool, error) {
+	d.l.Lock()
+	defer d.l.Unlock()
+
+	sub, ok := d.subscriptions[id]
+	if !ok {
+		return false, nil
+	}
+
+	delete(d.subscriptions, id)
+
+	if err := d.save(); err != nil {
+		d.subscriptions[id] = sub
+
+		return true, fmt.Errorf("failed to store webhook subscriptions: %w", err)
+	}
+
+	delete(d.deliveries, id)
+
+	return true, nil
+}
+
+// listDeliveries returns the delivery records of a subscription, newest
+// first.
+func (d *WebhookDispatcher) listDeliveries(id string) ([]webhookDelivery, bool) {
+	d.l.Lock()
+	defer d.l.Unlock()
+
+	if _, ok := d.subscriptions[id]; !ok {
+		return nil, false
+	}
+
+	records := d.deliveries[id]
+
+	result := make([]webhookDelivery, 0, len(records))
+	for idx := len(records) - 1; idx >= 0; idx-- {
+		result = append(result, *records[idx])
+	}
+
+	return result, true
+}
+
+// Notify sends change to all matching subscriptions. It implements
+// repo.ChangeListener and does not block.
+func (d *WebhookDispatcher) Notify(change *calendarv1.CalendarChangeEvent) {
+	payload := webhookPayload{
+		CalendarID: change.Calendar,
+		Timestamp:  d.now(),
+	}
+
+	switch kind := change.Kind.(type) {
+	case *calendarv1.CalendarChangeEvent_DeletedEventId:
+		payload.Kind = webhookKindDeleted
+		payload.EventID = kind.DeletedEventId
+
+		d.dispatch(payload, nil, repo.Event{})
+
+	case *calendarv1.CalendarChangeEvent_EventChange:
+		payload.Kind = webhookKindChanged
+		payload.EventID = kind.EventChange.GetId()
+
+		if d.events == nil {
+			d.dispatch(payload, kind.EventChange, repo.Event{})
+			return
+		}
+
+		// listeners are called while the event cache is locked so the
+		// event must be loaded in the background.
+		go func() {
+			evt, err := d.events.LoadEvent(d.ctx, change.Calendar, payload.EventID, false)
+			if err != nil {
+				slog.Error("failed to load event for webhooks, treating it as private", "calendar", change.Calendar, "event-id", payload.EventID, "error", err)
+
+				evt = &repo.Event{
+					ID:         payload.EventID,
+					CalendarID: change.Calendar,
+					Visibility: repo.VisibilityPrivate,
+				}
+			}
+
+			d.dispatch(payload, kind.EventChange, *evt)
+		}()
+	}
+}
+
+// dispatch sends payload to all matching subscriptions. If set, changed is
+// added to the payload and redacted for subscriptions that may not see the
+// details of evt.
+func (d *WebhookDispatcher) dispatch(payload webhookPayload, changed *calendarv1.CalendarEvent, evt repo.Event) {
+	d.l.Lock()
+	defer d.l.Unlock()
+
+	for _, sub := range d.subscriptions {
+		if !sub.matches(payload.CalendarID, payload.Kind) {
+			continue
+		}
+
+		if changed != nil {
+			pb := changed
+			if d.redactor.hidesDetails(evt, sub.CreatedBy) {
+				pb = redactEventProto(changed, d.language)
+			}
+
+			blob, err := protojson.Marshal(pb)
+			if err != nil {
+				slog.Error("failed to encode event for webhooks", "calendar", payload.CalendarID, "error", err)
+				return
+			}
+
+			payload.Event = blob
+		}
+
+		id, err := randomID(8)
+		if err != nil {
+			slog.Error("failed to generate webhook delivery id", "error", err)
+			continue
+		}
+
+		payload.ID = id
+
+		body, err := json.Marshal(payload)
+		if err != nil {
+			slog.Error("failed to encode webhook payload", "error", err)
+			continue
+		}
+
+		delivery := &webhookDelivery{
+			ID:             id,
+			SubscriptionID: sub.ID,
+			Kind:           payload.Kind,
+			CalendarID:     payload.CalendarID,
+			EventID:        payload.EventID,
+			Status:         webhookStatusPending,
+			CreatedAt:      payload.Timestamp,
+			UpdatedAt:      payload.Timestamp,
+		}
+
+		records := append(d.deliveries[sub.ID], delivery)
+		if len(records) > maxWebhookDeliveries {
+			records = records[len(records)-maxWebhookDeliveries:]
+		}
+		d.deliveries[sub.ID] = records
+
+		go d.deliver(sub, delivery, body)
+	}
+}
+
+// redactEventProto returns a copy of pb without the details that
+// eventRedactor hides.
+func redactEventProto(pb *calendarv1.CalendarEvent, lang i18n.Language) *calendarv1.CalendarEvent {
+	redacted := proto.Clone(pb).(*calendarv1.CalendarEvent)
+	redacted.Summary = lang.Sprintf(i18n.PrivateEventSummary)
+	redacted.Description = ""
+
+	if redacted.ExtraData.MessageIs(&calendarv1.CustomerAnnotation{}) {
+		redacted.ExtraData = nil
+	}
+
+	return redacted
+}
+
+// deliver sends body to the subscription until it is accepted or the
+// maximum number of attempts is reached.
+func (d *WebhookDispatcher) deliver(sub webhookSubscription, delivery *webhookDelivery, body []byte) {
+	backoff := d.backoff
+
+	for attempt := 1; ; attempt++ {
+		statusCode, err := d.send(sub, delivery.ID, body)
+
+		d.l.Lock()
+
+		delivery.Attempts = attempt
+		delivery.LastStatusCode = statusCode
+		delivery.UpdatedAt = d.now()
+
+		if err == nil {
+			delivery.Status = webhookStatusDelivered
+			delivery.LastError = ""
+			d.l.Unlock()
+
+			return
+		}
+
+		delivery.LastError = err.Error()
+
+		if attempt >= d.maxAttempts {
+			delivery.Status = webhookStatusDead
+			d.l.Unlock()
+
+			slog.Warn("giving up on webhook delivery", "subscription", sub.ID, "delivery", delivery.ID, "attempts", attempt, "error", err)
+
+			return
+		}
+
+		delivery.Status = webhookStatusFailed
+		d.l.Unlock()
+
+		slog.Info("webhook delivery failed, retrying", "subscription", sub.ID, "delivery", delivery.ID, "attempt", attempt, "backoff", backoff, "error", err)
+
+		select {
+		case <-d.ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+
+		backoff = min(2*backoff, maxWebhookBackoff)
+
+		// stop retrying if the subscription has been removed in the
+		// meantime.
+		d.l.Lock()
+		_, ok := d.subscriptions[sub.ID]
+		d.l.Unlock()
+
+		if !ok {
+			return
+		}
+	}
+}
+
+// send performs a single delivery attempt and returns the status code of
+// the response, if any.
+func (d *WebhookDispatcher) send(sub webhookSubscription, deliveryID string, body []byte) (int, error) {
+	timestamp := strconv.FormatInt(d.now().Unix(), 10)
+
+	req, err := http.NewRequestWithContext(d.ctx, http.MethodPost, sub.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-ID", deliveryID)
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	req.Header.Set("X-Webhook-Signature", signWebhook(sub.Secret, timestamp, body))
+
+	res, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+
+	// drain the body so the connection can be reused.
+	_, _ = io.Copy(io.Discard, io.LimitReader(res.Body, 64<<10))
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return res.StatusCode, fmt.Errorf("unexpected response status %s", res.Status)
+	}
+
+	return res.StatusCode, nil
+}
+
+// WebhookHandler manages webhook subscriptions:
+//
+//	GET    /webhooks
+//	POST   /webhooks {"url": "https://...", "calendars": ["..."], "kinds": ["changed", "deleted"]}
+//	DELETE /webhooks?id=<id>
+//
+// Creating a subscription returns it including the secret used to sign
+// the deliveries, which is not returned afterwards.
+type WebhookHandler struct {
+	webhooks     *WebhookDispatcher
+	allowedRoles []string
+}
+
+// NewWebhookHandler returns a new webhook handler for webhooks.
+func NewWebhookHandler(webhooks *WebhookDispatcher, allowedRoles []string) *WebhookHandler {
+	return &WebhookHandler{
+		webhooks:     webhooks,
+		allowedRoles: allowedRoles,
+	}
+}
+
+func (h *WebhookHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !authorize(w, r, h.allowedRoles) {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		writeWebhookJSON(w, http.StatusOK, h.webhooks.list())
+
+	case http.MethodPost:
+		var body struct {
+			URL       string   `json:"url"`
+			Secret    string   `json:"secret"`
+			Calendars []string `json:"calendars"`
+			Kinds     []string `json:"kinds"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		sub, err := h.webhooks.add(webhookSubscription{
+			URL:       body.URL,
+			Secret:    body.Secret,
+			Calendars: body.Calendars,
+			Kinds:     body.Kinds,
+			CreatedBy: r.Header.Get("X-Remote-User-ID"),
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		writeWebhookJSON(w, http.StatusCreated, sub)
+
+	case http.MethodDelete:
+		id := r.URL.Query().Get("id")
+		if id == "" {
+			http.Error(w, "missing value for id", http.StatusBadRequest)
+			return
+		}
+
+		found, err := h.webhooks.remove(id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if !found {
+			http.Error(w, "webhook subscription not found", http.StatusNotFound)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// WebhookDeliveryHandler serves the delivery records of a webhook
+// subscription, newest first:
+//
+//	GET /webhooks/deliveries?subscription=<id>
+type WebhookDeliveryHandler struct {
+	webhooks     *WebhookDispatcher
+	allowedRoles []string
+}
+
+// NewWebhookDeliveryHandler returns a new webhook delivery handler for
+// webhooks.
+func NewWebhookDeliveryHandler(webhooks *WebhookDispatcher, allowedRoles []string) *WebhookDeliveryHandler {
+	return &WebhookDeliveryHandler{
+		webhooks:     webhooks,
+		allowedRoles: allowedRoles,
+	}
+}
+
+func (h *WebhookDeliveryHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !authorize(w, r, h.allowedRoles) {
+		return
+	}
+
+	id := r.URL.Query().Get("subscription")
+	if id == "" {
+		http.Error(w, "missing value for subscription", http.StatusBadRequest)
+		return
+	}
+
+	deliveries, ok := h.webhooks.listDeliveries(id)
+	if !ok {
+		http.Error(w, "webhook subscription not found", http.StatusNotFound)
+		return
+	}
+
+	writeWebhookJSON(w, http.StatusOK, deliveries)
+}
+
+func writeWebhookJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("failed to encode webhook response", "error", err)
+	}
+}
@@ -0,0 +1,324 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	calendarv1 "github.com/tierklinik-dobersberg/apis/gen/go/tkd/calendar/v1"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/config"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/i18n"
+	"github.com/tierklinik-dobersberg/cis-cal/internal/repo"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+// Test_SignWebhook is the reference for receivers verifying deliveries:
+// the signature is the hex encoded HMAC-SHA256 of "<timestamp>.<body>".
+func Test_SignWebhook(t *testing.T) {
+	assert.Equal(t,
+		"sha256=e3bdabb7f8ee7ca124d346d7ba2993e22d48875cef5ed7ef7a41097cf4c04dbc",
+		signWebhook("whsec-test", "1717401600", []byte(`{"id":"1","kind":"deleted"}`)),
+	)
+}
+
+// webhookReceiver is a TLS test server that records all deliveries and
+// fails the first failures requests.
+type webhookReceiver struct {
+	*httptest.Server
+
+	l        sync.Mutex
+	failures int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func newWebhookReceiver(t *testing.T, failures int) *webhookReceiver {
+	rcv := &webhookReceiver{failures: failures}
+
+	rcv.Server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		rcv.l.Lock()
+		defer rcv.l.Unlock()
+
+		rcv.requests = append(rcv.requests, r)
+		rcv.bodies = append(rcv.bodies, body)
+
+		if len(rcv.requests) <= rcv.failures {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	t.Cleanup(rcv.Close)
+
+	return rcv
+}
+
+func newTestWebhookDispatcher(t *testing.T, rcv *webhookReceiver, maxAttempts int) *WebhookDispatcher {
+	d, err := NewWebhookDispatcher(context.Background(), config.Webhooks{
+		MaxAttempts: maxAttempts,
+		Backoff:     config.Duration(time.Millisecond),
+	})
+	require.NoError(t, err)
+
+	d.client = rcv.Client()
+
+	return d
+}
+
+func waitForDelivery(t *testing.T, d *WebhookDispatcher, subID, status string) webhookDelivery {
+	t.Helper()
+
+	var delivery webhookDelivery
+	require.Eventually(t, func() bool {
+		deliveries, _ := d.listDeliveries(subID)
+		if len(deliveries) == 0 {
+			return false
+		}
+
+		delivery = deliveries[0]
+
+		return delivery.Status == status
+	}, 5*time.Second, 5*time.Millisecond)
+
+	return delivery
+}
+
+func Test_WebhookDispatcher_Retry(t *testing.T) {
+	rcv := newWebhookReceiver(t, 2)
+	d := newTestWebhookDispatcher(t, rcv, 5)
+
+	sub, err := d.add(webhookSubscription{URL: rcv.URL + "/hook"})
+	require.NoError(t, err)
+	require.NotEmpty(t, sub.Secret)
+
+	d.Notify(&calendarv1.CalendarChangeEvent{
+		Calendar: "vet-1",
+		Kind: &calendarv1.CalendarChangeEvent_EventChange{
+			EventChange: &calendarv1.CalendarEvent{Id: "evt-1", CalendarId: "vet-1", Summary: "Checkup"},
+		},
+	})
+
+	delivery := waitForDelivery(t, d, sub.ID, webhookStatusDelivered)
+	assert.Equal(t, 3, delivery.Attempts)
+	assert.Equal(t, http.StatusOK, delivery.LastStatusCode)
+	assert.Empty(t, delivery.LastError)
+
+	rcv.l.Lock()
+	defer rcv.l.Unlock()
+
+	require.Len(t, rcv.requests, 3)
+
+	r, body := rcv.requests[2], rcv.bodies[2]
+	assert.Equal(t, delivery.ID, r.Header.Get("X-Webhook-ID"))
+	assert.Equal(t, signWebhook(sub.Secret, r.Header.Get("X-Webhook-Timestamp"), body), r.Header.Get("X-Webhook-Signature"))
+
+	var payload webhookPayload
+	require.NoError(t, json.Unmarshal(body, &payload))
+	assert.Equal(t, webhookKindChanged, payload.Kind)
+	assert.Equal(t, "vet-1", payload.CalendarID)
+	assert.Equal(t, "evt-1", payload.EventID)
+	assert.Contains(t, string(payload.Event), `"summary":"Checkup"`)
+}
+
+func Test_WebhookDispatcher_DeadLetter(t *testing.T) {
+	rcv := newWebhookReceiver(t, 10)
+	d := newTestWebhookDispatcher(t, rcv, 3)
+
+	sub, err := d.add(webhookSubscription{URL: rcv.URL})
+	require.NoError(t, err)
+
+	d.Notify(&calendarv1.CalendarChangeEvent{
+		Calendar: "vet-1",
+		Kind:     &calendarv1.CalendarChangeEvent_DeletedEventId{DeletedEventId: "evt-1"},
+	})
+
+	delivery := waitForDelivery(t, d, sub.ID, webhookStatusDead)
+	assert.Equal(t, 3, delivery.Attempts)
+	assert.Equal(t, http.StatusBadGateway, delivery.LastStatusCode)
+	assert.NotEmpty(t, delivery.LastError)
+}
+
+func Test_WebhookDispatcher_Filters(t *testing.T) {
+	rcv := newWebhookReceiver(t, 0)
+	d := newTestWebhookDispatcher(t, rcv, 1)
+
+	sub, err := d.add(webhookSubscription{URL: rcv.URL, Calendars: []string{"vet-1"}, Kinds: []string{webhookKindDeleted}})
+	require.NoError(t, err)
+
+	d.Notify(&calendarv1.CalendarChangeEvent{
+		Calendar: "vet-1",
+		Kind:     &calendarv1.CalendarChangeEvent_EventChange{EventChange: &calendarv1.CalendarEvent{Id: "evt-1"}},
+	})
+	d.Notify(&calendarv1.CalendarChangeEvent{
+		Calendar: "vet-2",
+		Kind:     &calendarv1.CalendarChangeEvent_DeletedEventId{DeletedEventId: "evt-2"},
+	})
+
+	deliveries, _ := d.listDeliveries(sub.ID)
+	assert.Empty(t, deliveries)
+
+	d.Notify(&calendarv1.CalendarChangeEvent{
+		Calendar: "vet-1",
+		Kind:     &calendarv1.CalendarChangeEvent_DeletedEventId{DeletedEventId: "evt-3"},
+	})
+
+	delivery := waitForDelivery(t, d, sub.ID, webhookStatusDelivered)
+	assert.Equal(t, "evt-3", delivery.EventID)
+
+	_, err = d.add(webhookSubscription{URL: "http://example.com"})
+	assert.Error(t, err)
+
+	_, err = d.add(webhookSubscription{URL: rcv.URL, Kinds: []string{"created"}})
+	assert.Error(t, err)
+}
+
+func Test_WebhookHandler(t *testing.T) {
+	cfg := config.Webhooks{
+		StoreFile:   filepath.Join(t.TempDir(), "webhooks.json"),
+		MaxAttempts: 1,
+		Backoff:     config.Duration(time.Second),
+	}
+
+	d, err := NewWebhookDispatcher(context.Background(), cfg)
+	require.NoError(t, err)
+
+	h := NewWebhookHandler(d, []string{"admin"})
+
+	send := func(method, target, body string, roles ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("X-Remote-User-ID", "alice")
+		for _, r := range roles {
+			req.Header.Add("X-Remote-Role", r)
+		}
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		return rec
+	}
+
+	rec := send(http.MethodPost, "/webhooks", `{"url": "https://portal.example.com/hook", "calendars": ["vet-1"]}`)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	rec = send(http.MethodPost, "/webhooks", `{"url": "https://portal.example.com/hook", "calendars": ["vet-1"]}`, "admin")
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+
+	var created webhookSubscription
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&created))
+	assert.NotEmpty(t, created.ID)
+	assert.NotEmpty(t, created.Secret)
+
+	// secrets are only returned on creation
+	rec = send(http.MethodGet, "/webhooks", "", "admin")
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var list []webhookSubscription
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&list))
+	require.Len(t, list, 1)
+	assert.Equal(t, created.ID, list[0].ID)
+	assert.Empty(t, list[0].Secret)
+
+	// subscriptions survive a restart
+	reloaded, err := NewWebhookDispatcher(context.Background(), cfg)
+	require.NoError(t, err)
+	require.Len(t, reloaded.subscriptions, 1)
+	assert.Equal(t, created.Secret, reloaded.subscriptions[created.ID].Secret)
+
+	rec = send(http.MethodDelete, "/webhooks?id="+created.ID, "", "admin")
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+
+	rec = send(http.MethodDelete, "/webhooks?id="+created.ID, "", "admin")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	reloaded, err = NewWebhookDispatcher(context.Background(), cfg)
+	require.NoError(t, err)
+	assert.Empty(t, reloaded.subscriptions)
+}
+
+// singleEventLoader loads a single event.
+type singleEventLoader repo.Event
+
+func (s singleEventLoader) LoadEvent(_ context.Context, calID, eventID string, _ bool) (*repo.Event, error) {
+	if calID != s.CalendarID || eventID != s.ID {
+		return nil, errors.New("event not found")
+	}
+
+	evt := repo.Event(s)
+
+	return &evt, nil
+}
+
+func Test_WebhookDispatcher_Private(t *testing.T) {
+	rcv := newWebhookReceiver(t, 0)
+	d := newTestWebhookDispatcher(t, rcv, 1)
+
+	d.events = singleEventLoader{ID: "evt-1", CalendarID: "vet-1", Visibility: repo.VisibilityPrivate}
+	d.redactor = ownerRedactor(map[string]string{"vet-1": "alice"})
+	d.language = i18n.English
+
+	owner, err := d.add(webhookSubscription{URL: rcv.URL + "/alice", CreatedBy: "alice"})
+	require.NoError(t, err)
+
+	other, err := d.add(webhookSubscription{URL: rcv.URL + "/bob", CreatedBy: "bob"})
+	require.NoError(t, err)
+
+	annotation, err := anypb.New(&calendarv1.CustomerAnnotation{CustomerId: "huber"})
+	require.NoError(t, err)
+
+	notify := func(eventID string) {
+		d.Notify(&calendarv1.CalendarChangeEvent{
+			Calendar: "vet-1",
+			Kind: &calendarv1.CalendarChangeEvent_EventChange{
+				EventChange: &calendarv1.CalendarEvent{Id: eventID, CalendarId: "vet-1", Summary: "Dr. Who", Description: "personal", ExtraData: annotation},
+			},
+		})
+	}
+
+	// the event is loaded in the background
+	notify("evt-1")
+	waitForDelivery(t, d, owner.ID, webhookStatusDelivered)
+	waitForDelivery(t, d, other.ID, webhookStatusDelivered)
+
+	// events that cannot be loaded are treated as private, which still
+	// shows them to the owner
+	notify("unknown")
+	require.Eventually(t, func() bool {
+		rcv.l.Lock()
+		defer rcv.l.Unlock()
+
+		return len(rcv.requests) == 4
+	}, 5*time.Second, 5*time.Millisecond)
+
+	rcv.l.Lock()
+	defer rcv.l.Unlock()
+
+	summaries := make(map[string][]string)
+	for idx, r := range rcv.requests {
+		var payload webhookPayload
+		require.NoError(t, json.Unmarshal(rcv.bodies[idx], &payload))
+
+		var evt calendarv1.CalendarEvent
+		require.NoError(t, protojson.Unmarshal(payload.Event, &evt))
+
+		if evt.Summary == "Private" {
+			assert.Empty(t, evt.Description)
+			assert.Nil(t, evt.ExtraData)
+		}
+
+		summaries[r.URL.Path] = append(summaries[r.URL.Path], payload.EventID+":"+evt.Summary)
+	}
+
+	assert.ElementsMatch(t, []string{"evt-1:Dr. Who", "unknown:Dr. Who"}, summaries["/alice"])
+	assert.ElementsMatch(t, []string{"evt-1:Private", "unknown:Private"}, summaries["/bob"])
+}